@@ -2,164 +2,504 @@ package cache
 
 import (
     "context"
-    "sync"
+    "fmt"
+    "math/rand"
     "sync/atomic"
     "time"
+
+    "github.com/velumlabs/thor/options"
 )
 
-// CacheStats holds statistics about the cache operations.
-type CacheStats struct {
-    Size    int
-    Hits    int64
-    Misses  int64
-    Evicted int64
-}
+// NewTyped creates a cache of values of type V under keys of type K,
+// configured by opts, whose expired items are removed in the background until
+// Close, unless WithCleanupPeriod disables it:
+//
+//	sessions, err := cache.NewTyped[id.ID, db.Session](
+//	    cache.WithMaxSize(10000),
+//	    cache.WithTTL(5*time.Minute),
+//	)
+//	session, ok := sessions.Get(sessionID)
+func NewTyped[K comparable, V any](opts ...Option) (*TypedCache[K, V], error) {
+    config := Config{
+        MaxSize:       DefaultMaxSize,
+        TTL:           DefaultTTL,
+        CleanupPeriod: DefaultCleanupPeriod,
+        Clock:         time.Now,
+        Rand:          rand.Float64,
+    }
+    if err := options.ApplyOptions(&config, opts...); err != nil {
+        return nil, fmt.Errorf("invalid cache options: %w", err)
+    }
 
-// CacheEntry represents an item in the cache with its value and expiration time.
-type CacheEntry struct {
-    Value      interface{}
-    Expiration time.Time
-}
+    c := &TypedCache[K, V]{
+        items:   make(map[K]entry[V]),
+        maxSize: config.MaxSize,
+        ttl:     config.TTL,
+        now:     config.Clock,
+        jitter:  config.Jitter,
+        rand:    config.Rand,
 
-// CacheKey is a type alias for string to represent cache keys.
-type CacheKey string
+        maxBytes: config.MaxBytes,
+    }
+    if config.sizer != nil {
+        sizer, ok := config.sizer.(func(V) int)
+        if !ok {
+            return nil, fmt.Errorf("invalid cache options: sizer %T doesn't match the cache's value type", config.sizer)
+        }
+        c.sizer = sizer
+    } else if config.MaxBytes > 0 {
+        c.sizer = func(value V) int {
+            return EstimateSize(value)
+        }
+    }
+    if config.onEvict != nil {
+        onEvict, ok := config.onEvict.(func(K, V, EvictReason))
+        if !ok {
+            return nil, fmt.Errorf("invalid cache options: eviction hook %T doesn't match the cache's key and value types", config.onEvict)
+        }
+        c.onEvict = onEvict
+    }
+    if config.onHit != nil {
+        onHit, ok := config.onHit.(func(K))
+        if !ok {
+            return nil, fmt.Errorf("invalid cache options: hit hook %T doesn't match the cache's key type", config.onHit)
+        }
+        c.onHit = onHit
+    }
+    if config.onMiss != nil {
+        onMiss, ok := config.onMiss.(func(K))
+        if !ok {
+            return nil, fmt.Errorf("invalid cache options: miss hook %T doesn't match the cache's key type", config.onMiss)
+        }
+        c.onMiss = onMiss
+    }
 
-// Config holds configuration parameters for the cache.
-type Config struct {
-    MaxSize       int
-    TTL           time.Duration
-    CleanupPeriod time.Duration
+    c.ctx, c.cancel = context.WithCancel(context.Background())
+    if config.CleanupPeriod > 0 {
+        c.done = make(chan struct{})
+        go c.cleanup(config.CleanupPeriod)
+    }
+    return c, nil
 }
 
-// Cache is the main structure that holds all cache data and methods.
-type Cache struct {
-    items    map[CacheKey]CacheEntry
-    maxSize  int
-    ttl      time.Duration
-    ctx      context.Context
-    cancel   context.CancelFunc
-    mu       sync.RWMutex
+// NewCache creates a cache of values of any type configured by opts, see
+// NewTyped.
+func NewCache(opts ...Option) (*Cache, error) {
+    return NewTyped[CacheKey, interface{}](opts...)
 }
 
-var (
-    hits    int64
-    misses  int64
-    evicted int64
-)
-
-// New initializes a new Cache with the given configuration.
+// New initializes a new Cache with the given configuration, like NewCache
+// with the options of its fields. Zero fields use the defaults, except a zero
+// CleanupPeriod, which runs no background cleanup; it panics if a field is
+// negative.
 func New(config Config) *Cache {
-    ctx, cancel := context.WithCancel(context.Background())
-    c := &Cache{
-        items:   make(map[CacheKey]CacheEntry),
-        maxSize: config.MaxSize,
-        ttl:     config.TTL,
-        ctx:     ctx,
-        cancel:  cancel,
+    var opts []Option
+    if config.MaxSize != 0 {
+        opts = append(opts, WithMaxSize(config.MaxSize))
+    }
+    if config.TTL != 0 {
+        opts = append(opts, WithTTL(config.TTL))
+    }
+    opts = append(opts, WithCleanupPeriod(config.CleanupPeriod))
+    if config.Clock != nil {
+        opts = append(opts, WithClock(config.Clock))
+    }
+    if config.MaxBytes != 0 {
+        opts = append(opts, WithMaxBytes(config.MaxBytes))
+    }
+    if config.Jitter != 0 {
+        opts = append(opts, WithJitter(config.Jitter))
+    }
+    if config.Rand != nil {
+        opts = append(opts, WithRand(config.Rand))
     }
 
-    go c.cleanup(config.CleanupPeriod)
+    c, err := NewCache(opts...)
+    if err != nil {
+        panic(err)
+    }
     return c
 }
 
-// Set adds an item to the cache. If the cache is full, it evicts the oldest item.
-func (c *Cache) Set(key CacheKey, value interface{}) {
+// Set adds an item to the cache that expires after the cache's TTL. If the
+// cache is full, it evicts the item closest to expiring.
+func (c *TypedCache[K, V]) Set(key K, value V) {
+    c.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL adds an item to the cache that expires after ttl instead of the
+// cache's TTL, e.g. to keep computed summaries longer than actors:
+//
+//	c.SetWithTTL(actorKey, actor, 5*time.Minute)
+//	c.SetWithTTL(summaryKey, summary, 12*time.Hour)
+//
+// A ttl of 0 uses the cache's TTL, and NoExpiry, or any negative ttl, keeps
+// the item until it is deleted or evicted. The cache's jitter, if set, applies
+// to either TTL.
+func (c *TypedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+    size, ttl := c.sizeOf(value), c.jittered(ttl)
+
+    var removed []evicted[K, V]
+    defer func() { c.notifyEvicted(removed) }()
+
     c.mu.Lock()
     defer c.mu.Unlock()
 
-    if len(c.items) >= c.maxSize {
-        c.evictOldest()
+    removed = c.set(key, value, size, ttl, c.now(), removed)
+}
+
+// SetMany adds items to the cache like Set, taking the cache's lock once for
+// all of them.
+func (c *TypedCache[K, V]) SetMany(items map[K]V) {
+    type pending struct {
+        size int64
+        ttl  time.Duration
+    }
+    // Sizes and jitter are worked out before taking the lock, for the caches
+    // that have them
+    var prepared map[K]pending
+    if c.sizer != nil || c.jitter > 0 {
+        prepared = make(map[K]pending, len(items))
+        for key, value := range items {
+            prepared[key] = pending{c.sizeOf(value), c.jittered(0)}
+        }
+    }
+
+    var removed []evicted[K, V]
+    defer func() { c.notifyEvicted(removed) }()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    now := c.now()
+    for key, value := range items {
+        item, ok := prepared[key]
+        if !ok {
+            item.ttl = c.ttl
+        }
+        removed = c.set(key, value, item.size, item.ttl, now, removed)
+    }
+}
+
+// sizeOf returns the estimated bytes of a value, zero unless the cache tracks
+// sizes.
+func (c *TypedCache[K, V]) sizeOf(value V) int64 {
+    if c.sizer == nil {
+        return 0
     }
+    return int64(c.sizer(value))
+}
 
-    c.items[key] = CacheEntry{
-        Value:      value,
-        Expiration: time.Now().Add(c.ttl),
+// jittered returns the TTL of an item set with ttl, see SetWithTTL.
+func (c *TypedCache[K, V]) jittered(ttl time.Duration) time.Duration {
+    if ttl == 0 {
+        ttl = c.ttl
+    }
+    if ttl > 0 && c.jitter > 0 {
+        ttl += time.Duration((2*c.rand() - 1) * c.jitter * float64(ttl))
     }
+    return ttl
+}
+
+// set adds an item of size that expires after ttl, if positive, appending the
+// items it replaces or evicts to removed. Must be called with mu held.
+func (c *TypedCache[K, V]) set(key K, value V, size int64, ttl time.Duration, now time.Time, removed []evicted[K, V]) []evicted[K, V] {
+    if old, exists := c.items[key]; exists {
+        c.remove(key, old, false)
+        if c.onEvict != nil {
+            removed = append(removed, evicted[K, V]{key, old.value, EvictReplaced})
+        }
+    }
+    if c.maxBytes > 0 && size > c.maxBytes {
+        if c.onEvict != nil {
+            removed = append(removed, evicted[K, V]{key, value, EvictCapacity})
+        }
+        return removed
+    }
+    for len(c.items) > 0 && (len(c.items) >= c.maxSize || (c.maxBytes > 0 && c.bytes+size > c.maxBytes)) {
+        removed = c.evictOldest(now, removed)
+    }
+    c.index(key)
+
+    var expiration time.Time
+    if ttl > 0 {
+        expiration = now.Add(ttl)
+    }
+    c.items[key] = entry[V]{
+        value:      value,
+        expiration: expiration,
+        size:       size,
+    }
+    c.bytes += size
+    return removed
 }
 
 // Get retrieves an item from the cache. It returns the value and a boolean indicating if the key was found.
-func (c *Cache) Get(key CacheKey) (interface{}, bool) {
+func (c *TypedCache[K, V]) Get(key K) (V, bool) {
+    value, _, ok := c.GetWithExpiry(key)
+    return value, ok
+}
+
+// GetWithExpiry retrieves an item from the cache like Get, along with the time
+// left until it expires, or NoExpiry if it never does, so callers can refresh
+// items before they expire:
+//
+//	summary, ttl, ok := c.GetWithExpiry(key)
+//	if !ok || (ttl != cache.NoExpiry && ttl < time.Minute) {
+//	    go refresh(key)
+//	}
+func (c *TypedCache[K, V]) GetWithExpiry(key K) (V, time.Duration, bool) {
     c.mu.RLock()
-    defer c.mu.RUnlock()
+    now := c.now()
+    item, exists := c.items[key]
+    c.mu.RUnlock()
 
-    entry, exists := c.items[key]
-    if !exists || time.Now().After(entry.Expiration) {
-        atomic.AddInt64(&misses, 1)
-        return nil, false
+    if !exists || item.expired(now) {
+        if exists {
+            c.expire([]K{key}, now)
+        }
+        atomic.AddInt64(&c.misses, 1)
+        if c.onMiss != nil {
+            c.onMiss(key)
+        }
+        var zero V
+        return zero, 0, false
     }
 
-    atomic.AddInt64(&hits, 1)
-    return entry.Value, true
+    atomic.AddInt64(&c.hits, 1)
+    if c.onHit != nil {
+        c.onHit(key)
+    }
+    if item.expiration.IsZero() {
+        return item.value, NoExpiry, true
+    }
+    return item.value, item.expiration.Sub(now), true
+}
+
+// GetMany retrieves the items under keys like Get, taking the cache's lock
+// once for all of them. It returns the items found and the keys of those that
+// weren't, in the order of keys.
+func (c *TypedCache[K, V]) GetMany(keys []K) (map[K]V, []K) {
+    found := make(map[K]V, len(keys))
+    var missing []K
+
+    var expired []K
+
+    c.mu.RLock()
+    now := c.now()
+    for _, key := range keys {
+        item, exists := c.items[key]
+        switch {
+        case exists && !item.expired(now):
+            found[key] = item.value
+        case exists:
+            expired = append(expired, key)
+            fallthrough
+        default:
+            missing = append(missing, key)
+        }
+    }
+    c.mu.RUnlock()
+
+    if len(expired) > 0 {
+        c.expire(expired, now)
+    }
+
+    atomic.AddInt64(&c.hits, int64(len(keys)-len(missing)))
+    atomic.AddInt64(&c.misses, int64(len(missing)))
+    if c.onHit != nil {
+        for key := range found {
+            c.onHit(key)
+        }
+    }
+    if c.onMiss != nil {
+        for _, key := range missing {
+            c.onMiss(key)
+        }
+    }
+    return found, missing
+}
+
+// GetOrSet returns the item under key, or loads it with load and caches it.
+// Errors of load are returned and not cached. Concurrent misses of a key may
+// each call load.
+func (c *TypedCache[K, V]) GetOrSet(key K, load func() (V, error)) (V, error) {
+    if value, ok := c.Get(key); ok {
+        return value, nil
+    }
+    value, err := load()
+    if err != nil {
+        return value, err
+    }
+    c.Set(key, value)
+    return value, nil
 }
 
 // Delete removes an item from the cache.
-func (c *Cache) Delete(key CacheKey) {
+func (c *TypedCache[K, V]) Delete(key K) {
     c.mu.Lock()
-    defer c.mu.Unlock()
-    delete(c.items, key)
+    item, exists := c.items[key]
+    if exists {
+        c.remove(key, item, false)
+    }
+    c.mu.Unlock()
+
+    if exists {
+        c.notifyEvicted([]evicted[K, V]{{key, item.value, EvictDeleted}})
+    }
 }
 
 // Clear empties the cache.
-func (c *Cache) Clear() {
+func (c *TypedCache[K, V]) Clear() {
     c.mu.Lock()
-    defer c.mu.Unlock()
-    c.items = make(map[CacheKey]CacheEntry)
+    items := c.items
+    c.items = make(map[K]entry[V])
+    c.bytes = 0
+    c.namespaces = nil
+    c.mu.Unlock()
+
+    if c.onEvict != nil {
+        for key, item := range items {
+            c.onEvict(key, item.value, EvictCleared)
+        }
+    }
 }
 
 // GetStats returns statistics on cache performance.
-func (c *Cache) GetStats() CacheStats {
+func (c *TypedCache[K, V]) GetStats() CacheStats {
     c.mu.RLock()
     defer c.mu.RUnlock()
 
     return CacheStats{
         Size:    len(c.items),
-        Hits:    atomic.LoadInt64(&hits),
-        Misses:  atomic.LoadInt64(&misses),
-        Evicted: atomic.LoadInt64(&evicted),
+        Bytes:   c.bytes,
+        Hits:    atomic.LoadInt64(&c.hits),
+        Misses:  atomic.LoadInt64(&c.misses),
+        Evicted: atomic.LoadInt64(&c.evicted),
     }
 }
 
 // cleanup runs periodically to remove expired items from the cache.
-func (c *Cache) cleanup(period time.Duration) {
+func (c *TypedCache[K, V]) cleanup(period time.Duration) {
+    defer close(c.done)
+
     ticker := time.NewTicker(period)
     defer ticker.Stop()
 
     for {
         select {
         case <-ticker.C:
-            c.mu.Lock()
-            now := time.Now()
-            for key, entry := range c.items {
-                if now.After(entry.Expiration) {
-                    delete(c.items, key)
-                    atomic.AddInt64(&evicted, 1)
-                }
-            }
-            c.mu.Unlock()
+            c.removeExpired()
         case <-c.ctx.Done():
             return
         }
     }
 }
 
-// evictOldest removes the oldest item from the cache.
-func (c *Cache) evictOldest() {
-    var oldestKey CacheKey
+// RunCleanupNow removes the expired items from the cache right away, as the
+// background cleanup does periodically, e.g. for tests, or for caches without
+// a background cleanup to free memory at times of their choosing.
+func (c *TypedCache[K, V]) RunCleanupNow() {
+    c.removeExpired()
+}
+
+// expire removes the items under keys that a lookup found expired at now,
+// unless they were replaced since, so their memory is freed without waiting
+// for the cleanup.
+func (c *TypedCache[K, V]) expire(keys []K, now time.Time) {
+    var removed []evicted[K, V]
+    defer func() { c.notifyEvicted(removed) }()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for _, key := range keys {
+        if item, exists := c.items[key]; exists && item.expired(now) {
+            c.remove(key, item, true)
+            atomic.AddInt64(&c.evicted, 1)
+            if c.onEvict != nil {
+                removed = append(removed, evicted[K, V]{key, item.value, EvictExpired})
+            }
+        }
+    }
+}
+
+// removeExpired removes the expired items from the cache.
+func (c *TypedCache[K, V]) removeExpired() {
+    var removed []evicted[K, V]
+    defer func() { c.notifyEvicted(removed) }()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    now := c.now()
+    for key, item := range c.items {
+        if item.expired(now) {
+            c.remove(key, item, true)
+            atomic.AddInt64(&c.evicted, 1)
+            if c.onEvict != nil {
+                removed = append(removed, evicted[K, V]{key, item.value, EvictExpired})
+            }
+        }
+    }
+}
+
+// remove removes an item from the cache and its namespaces, counting it as
+// evicted from them if it expired or was evicted. Must be called with mu held.
+func (c *TypedCache[K, V]) remove(key K, item entry[V], evicted bool) {
+    delete(c.items, key)
+    c.bytes -= item.size
+    c.unindex(key, evicted)
+}
+
+// notifyEvicted reports removed items to the OnEvict hook, if set. Must be
+// called without mu held.
+func (c *TypedCache[K, V]) notifyEvicted(removed []evicted[K, V]) {
+    if c.onEvict == nil {
+        return
+    }
+    for _, item := range removed {
+        c.onEvict(item.key, item.value, item.reason)
+    }
+}
+
+// evictOldest removes the item closest to expiring from the cache, or any item
+// if none of them expire, and appends it to removed. Must be called with mu
+// held.
+func (c *TypedCache[K, V]) evictOldest(now time.Time, removed []evicted[K, V]) []evicted[K, V] {
+    var oldestKey K
     var oldestTime time.Time
+    found := false
 
-    for key, entry := range c.items {
-        if oldestTime.IsZero() || entry.Expiration.Before(oldestTime) {
-            oldestKey, oldestTime = key, entry.Expiration
+    for key, item := range c.items {
+        if !found || (!item.expiration.IsZero() && (oldestTime.IsZero() || item.expiration.Before(oldestTime))) {
+            oldestKey, oldestTime, found = key, item.expiration, true
         }
     }
 
-    if !oldestTime.IsZero() {
-        delete(c.items, oldestKey)
-        atomic.AddInt64(&evicted, 1)
+    if !found {
+        return removed
     }
+    item := c.items[oldestKey]
+    c.remove(oldestKey, item, true)
+    atomic.AddInt64(&c.evicted, 1)
+
+    reason := EvictCapacity
+    if item.expired(now) {
+        reason = EvictExpired
+    }
+    if c.onEvict == nil {
+        return removed
+    }
+    return append(removed, evicted[K, V]{oldestKey, item.value, reason})
 }
 
-// Close cancels the context to stop the cleanup goroutine.
-func (c *Cache) Close() {
+// Close stops the background cleanup, waiting for it to exit. The cache stays
+// usable, expiring items only on lookups and RunCleanupNow. Closing a cache
+// again does nothing.
+func (c *TypedCache[K, V]) Close() {
     c.cancel()
+    if c.done != nil {
+        <-c.done
+    }
 }
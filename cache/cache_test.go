@@ -0,0 +1,691 @@
+package cache
+
+import (
+    "errors"
+    "fmt"
+    "math/rand"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestNewCacheOptions(t *testing.T) {
+    tests := []struct {
+        name string
+        opts []Option
+        // want is part of the error expected, none if empty
+        want string
+    }{
+        {name: "defaults"},
+        {name: "all set", opts: []Option{WithMaxSize(5), WithTTL(time.Second), WithCleanupPeriod(0), WithJitter(0.5), WithMaxBytes(100)}},
+        {name: "zero max size", opts: []Option{WithMaxSize(0)}, want: "cache max size must be positive"},
+        {name: "negative max size", opts: []Option{WithMaxSize(-1)}, want: "cache max size must be positive"},
+        {name: "zero TTL", opts: []Option{WithTTL(0)}, want: "cache TTL must be positive"},
+        {name: "no expiry TTL", opts: []Option{WithTTL(NoExpiry)}, want: "cache TTL must be positive"},
+        {name: "negative cleanup period", opts: []Option{WithCleanupPeriod(-time.Second)}, want: "cleanup period must not be negative"},
+        {name: "negative jitter", opts: []Option{WithJitter(-0.1)}, want: "cache jitter must be at least 0 and less than 1"},
+        {name: "whole jitter", opts: []Option{WithJitter(1)}, want: "cache jitter must be at least 0 and less than 1"},
+        {name: "nil rand", opts: []Option{WithRand(nil)}, want: "cache rand source must not be nil"},
+        {name: "nil clock", opts: []Option{WithClock(nil)}, want: "cache clock must not be nil"},
+        {name: "zero max bytes", opts: []Option{WithMaxBytes(0)}, want: "cache max bytes must be positive"},
+        {name: "nil sizer", opts: []Option{WithSizer[interface{}](nil)}, want: "cache sizer must not be nil"},
+        {name: "nil eviction hook", opts: []Option{WithOnEvict[CacheKey, interface{}](nil)}, want: "cache eviction hook must not be nil"},
+        {name: "nil hit hook", opts: []Option{WithOnHit[CacheKey](nil)}, want: "cache hit hook must not be nil"},
+        {name: "nil miss hook", opts: []Option{WithOnMiss[CacheKey](nil)}, want: "cache miss hook must not be nil"},
+        {name: "sizer of another type", opts: []Option{WithSizer(func(string) int { return 0 })}, want: "sizer func(string) int doesn't match"},
+        {
+            name: "eviction hook of other types",
+            opts: []Option{WithOnEvict(func(string, interface{}, EvictReason) {})},
+            want: "eviction hook func(string, interface {}, cache.EvictReason) doesn't match",
+        },
+        {name: "hit hook of another type", opts: []Option{WithOnHit(func(int) {})}, want: "hit hook func(int) doesn't match"},
+        {name: "miss hook of another type", opts: []Option{WithOnMiss(func(string) {})}, want: "miss hook func(string) doesn't match"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            c, err := NewCache(tt.opts...)
+            if tt.want == "" {
+                if err != nil {
+                    t.Fatal(err)
+                }
+                c.Close()
+                return
+            }
+            if err == nil || !strings.Contains(err.Error(), tt.want) {
+                t.Fatalf("error = %v, want %q", err, tt.want)
+            }
+            if !strings.HasPrefix(err.Error(), "invalid cache options: ") {
+                t.Errorf("error %q doesn't say the options are invalid", err)
+            }
+        })
+    }
+}
+
+func TestNewTypedHooks(t *testing.T) {
+    // Hooks and sizers of the cache's own types are accepted
+    c, err := NewTyped[string, int](
+        WithCleanupPeriod(0),
+        WithSizer(func(int) int { return 8 }),
+        WithOnEvict(func(string, int, EvictReason) {}),
+        WithOnHit(func(string) {}),
+        WithOnMiss(func(string) {}),
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    c.Set("a", 1)
+    if got := c.GetStats().Bytes; got != 8 {
+        t.Errorf("bytes = %d, want the sizer's 8", got)
+    }
+}
+
+func TestNew(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    tests := []struct {
+        name   string
+        config Config
+        // The settings of the cache expected
+        maxSize int
+        ttl     time.Duration
+        cleanup bool
+        // panics is whether New is expected to panic
+        panics bool
+    }{
+        {name: "zero config", config: Config{}, maxSize: DefaultMaxSize, ttl: DefaultTTL},
+        {
+            name:    "set",
+            config:  Config{MaxSize: 10, TTL: time.Minute, CleanupPeriod: time.Hour, Clock: clock.Now, Jitter: 0.1},
+            maxSize: 10,
+            ttl:     time.Minute,
+            cleanup: true,
+        },
+        {name: "negative size", config: Config{MaxSize: -1}, panics: true},
+        {name: "negative TTL", config: Config{TTL: -time.Minute}, panics: true},
+        {name: "negative cleanup period", config: Config{CleanupPeriod: -time.Minute}, panics: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            defer func() {
+                if r := recover(); (r != nil) != tt.panics {
+                    t.Errorf("panic = %v, want %v", r, tt.panics)
+                }
+            }()
+            c := New(tt.config)
+            defer c.Close()
+            if c.maxSize != tt.maxSize || c.ttl != tt.ttl || (c.done != nil) != tt.cleanup {
+                t.Errorf("cache of %d items for %s with cleanup %v, want %d for %s with cleanup %v",
+                    c.maxSize, c.ttl, c.done != nil, tt.maxSize, tt.ttl, tt.cleanup)
+            }
+        })
+    }
+}
+
+func TestTypedCacheSetWithTTL(t *testing.T) {
+    tests := []struct {
+        name string
+        ttl  time.Duration
+        // after is how long after the Set the item is looked up
+        after time.Duration
+        // want is the time left expected, or whether the item is gone
+        want time.Duration
+        gone bool
+    }{
+        {name: "cache TTL", after: 4 * time.Minute, want: 6 * time.Minute},
+        {name: "cache TTL at expiry", after: 10 * time.Minute, want: 0},
+        {name: "cache TTL expired", after: 10*time.Minute + time.Nanosecond, gone: true},
+        {name: "longer", ttl: 12 * time.Hour, after: time.Hour, want: 11 * time.Hour},
+        {name: "shorter", ttl: time.Minute, after: 30 * time.Second, want: 30 * time.Second},
+        {name: "shorter expired", ttl: time.Minute, after: 2 * time.Minute, gone: true},
+        {name: "no expiry", ttl: NoExpiry, after: 1000 * time.Hour, want: NoExpiry},
+        {name: "negative", ttl: -time.Hour, after: 1000 * time.Hour, want: NoExpiry},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            c, err := NewTyped[int, string](WithCleanupPeriod(0), WithClock(clock.Now), WithTTL(10*time.Minute))
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer c.Close()
+
+            c.SetWithTTL(1, "summary", tt.ttl)
+            clock.now = clock.now.Add(tt.after)
+            value, left, ok := c.GetWithExpiry(1)
+            if ok == tt.gone {
+                t.Fatalf("found = %v, want %v", ok, !tt.gone)
+            }
+            if tt.gone {
+                if value != "" || left != 0 {
+                    t.Errorf("GetWithExpiry = %q, %s, want the zero value", value, left)
+                }
+                if size := c.GetStats().Size; size != 0 {
+                    t.Errorf("size = %d, want the expired item freed on lookup", size)
+                }
+                return
+            }
+            if value != "summary" || left != tt.want {
+                t.Errorf("GetWithExpiry = %q, %s, want summary, %s", value, left, tt.want)
+            }
+        })
+    }
+}
+
+func TestTypedCacheSetReplaces(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c, err := NewTyped[string, int](WithCleanupPeriod(0), WithClock(clock.Now), WithTTL(time.Minute))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+
+    tests := []struct {
+        name string
+        set  func()
+        want time.Duration
+    }{
+        {name: "no expiry", set: func() { c.SetWithTTL("a", 1, NoExpiry) }, want: NoExpiry},
+        // Setting an item again gives it the new TTL, not the one it had
+        {name: "cache TTL", set: func() { c.Set("a", 2) }, want: time.Minute},
+        {name: "own TTL", set: func() { c.SetWithTTL("a", 3, time.Hour) }, want: time.Hour},
+    }
+    for i, tt := range tests {
+        tt.set()
+        value, left, ok := c.GetWithExpiry("a")
+        if !ok || value != i+1 || left != tt.want {
+            t.Errorf("%s: GetWithExpiry = %d, %s, %v, want %d, %s", tt.name, value, left, ok, i+1, tt.want)
+        }
+    }
+    if size := c.GetStats().Size; size != 1 {
+        t.Errorf("size = %d, want 1", size)
+    }
+}
+
+func TestCacheEviction(t *testing.T) {
+    type set struct {
+        key CacheKey
+        ttl time.Duration
+    }
+    tests := []struct {
+        name string
+        sets []set
+        // advance moves the clock before the last set
+        advance time.Duration
+        want    []CacheKey
+        evicted int64
+    }{
+        {
+            name:    "closest to expiring",
+            sets:    []set{{"a", time.Hour}, {"b", 10 * time.Minute}, {"c", 30 * time.Minute}, {"d", time.Hour}},
+            want:    []CacheKey{"a", "c", "d"},
+            evicted: 1,
+        },
+        {
+            name:    "items without expiry kept",
+            sets:    []set{{"a", NoExpiry}, {"b", NoExpiry}, {"c", 12 * time.Hour}, {"d", NoExpiry}},
+            want:    []CacheKey{"a", "b", "d"},
+            evicted: 1,
+        },
+        {
+            name:    "expired first",
+            sets:    []set{{"a", time.Hour}, {"b", time.Minute}, {"c", time.Hour}, {"d", time.Hour}},
+            advance: 2 * time.Minute,
+            want:    []CacheKey{"a", "c", "d"},
+            evicted: 1,
+        },
+        {
+            name: "replacing",
+            sets: []set{{"a", time.Hour}, {"b", time.Minute}, {"c", time.Hour}, {"b", time.Hour}},
+            want: []CacheKey{"a", "b", "c"},
+        },
+        {
+            name:    "new item closest to expiring",
+            sets:    []set{{"a", time.Hour}, {"b", time.Hour}, {"c", time.Hour}, {"d", time.Minute}},
+            want:    []CacheKey{"b", "c", "d"},
+            evicted: 1,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            c := newTestCache(t, clock, WithMaxSize(3))
+            for i, s := range tt.sets {
+                if i == len(tt.sets)-1 {
+                    clock.now = clock.now.Add(tt.advance)
+                } else {
+                    // Items are set a second apart, so the first set expires first
+                    clock.now = clock.now.Add(time.Second)
+                }
+                c.SetWithTTL(s.key, string(s.key), s.ttl)
+            }
+
+            got := c.Keys("")
+            sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("keys = %v, want %v", got, tt.want)
+            }
+            if stats := c.GetStats(); stats.Size != len(tt.want) || stats.Evicted != tt.evicted {
+                t.Errorf("stats = %+v, want %d items and %d evicted", stats, len(tt.want), tt.evicted)
+            }
+        })
+    }
+}
+
+func TestCacheStats(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c := newTestCache(t, clock, WithTTL(time.Minute), WithMaxSize(2))
+
+    steps := []struct {
+        name string
+        do   func()
+        want CacheStats
+    }{
+        {name: "empty", do: func() {}},
+        {name: "miss", do: func() { c.Get("a") }, want: CacheStats{Misses: 1}},
+        {name: "set", do: func() { c.Set("a", 1) }, want: CacheStats{Size: 1, Misses: 1}},
+        {name: "hit", do: func() { c.Get("a") }, want: CacheStats{Size: 1, Hits: 1, Misses: 1}},
+        {
+            name: "batch lookup",
+            do:   func() { c.GetMany([]CacheKey{"a", "b", "c"}) },
+            want: CacheStats{Size: 1, Hits: 2, Misses: 3},
+        },
+        {
+            name: "evicted",
+            do:   func() { clock.now = clock.now.Add(time.Second); c.Set("b", 2); c.SetWithTTL("c", 3, time.Hour) },
+            want: CacheStats{Size: 2, Hits: 2, Misses: 3, Evicted: 1},
+        },
+        {
+            name: "expired on lookup",
+            do:   func() { clock.now = clock.now.Add(2 * time.Minute); c.Get("b") },
+            want: CacheStats{Size: 1, Hits: 2, Misses: 4, Evicted: 2},
+        },
+        // Deleted and cleared items aren't evictions
+        {name: "deleted", do: func() { c.Delete("c") }, want: CacheStats{Hits: 2, Misses: 4, Evicted: 2}},
+        {name: "cleared", do: func() { c.Set("d", 4); c.Clear() }, want: CacheStats{Hits: 2, Misses: 4, Evicted: 2}},
+    }
+    for _, step := range steps {
+        step.do()
+        if got := c.GetStats(); got != step.want {
+            t.Errorf("%s: stats = %+v, want %+v", step.name, got, step.want)
+        }
+    }
+}
+
+func TestCacheDeleteAndClear(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c := newTestCache(t, clock)
+    c.SetMany(map[CacheKey]interface{}{"a": 1, "b": "two", "c": 3.0})
+
+    c.Delete("a")
+    c.Delete("missing")
+    if _, ok := c.Get("a"); ok {
+        t.Error("deleted item found")
+    }
+    if value, ok := c.Get("b"); !ok || value != "two" {
+        t.Errorf("Get(b) = %v, %v, want two", value, ok)
+    }
+
+    c.Clear()
+    if found, missing := c.GetMany([]CacheKey{"a", "b", "c"}); len(found) != 0 || len(missing) != 3 {
+        t.Errorf("GetMany after Clear = %v, %v, want nothing found", found, missing)
+    }
+    // The cache is usable after Clear
+    c.Set("a", 1)
+    if _, ok := c.Get("a"); !ok {
+        t.Error("item set after Clear not found")
+    }
+}
+
+func TestCacheGetMany(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c := newTestCache(t, clock, WithTTL(time.Minute))
+    c.SetMany(map[CacheKey]interface{}{"a": 1, "b": 2})
+    c.SetWithTTL("c", 3, time.Hour)
+    clock.now = clock.now.Add(2 * time.Minute)
+    c.SetMany(map[CacheKey]interface{}{"d": 4})
+
+    tests := []struct {
+        name    string
+        keys    []CacheKey
+        found   map[CacheKey]interface{}
+        missing []CacheKey
+    }{
+        {name: "none", found: map[CacheKey]interface{}{}},
+        {name: "expired", keys: []CacheKey{"a", "b"}, found: map[CacheKey]interface{}{}, missing: []CacheKey{"a", "b"}},
+        {
+            name:    "missing in order",
+            keys:    []CacheKey{"x", "c", "a", "d", "y"},
+            found:   map[CacheKey]interface{}{"c": 3, "d": 4},
+            missing: []CacheKey{"x", "a", "y"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            found, missing := c.GetMany(tt.keys)
+            if fmt.Sprint(found) != fmt.Sprint(tt.found) || fmt.Sprint(missing) != fmt.Sprint(tt.missing) {
+                t.Errorf("GetMany = %v, %v, want %v, %v", found, missing, tt.found, tt.missing)
+            }
+        })
+    }
+    if size := c.GetStats().Size; size != 2 {
+        t.Errorf("size = %d, want the expired items freed by the lookup", size)
+    }
+}
+
+func TestTypedCacheGetOrSet(t *testing.T) {
+    c, err := NewTyped[string, int](WithCleanupPeriod(0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+    errLoad := errors.New("database down")
+
+    loads := 0
+    tests := []struct {
+        name  string
+        load  func() (int, error)
+        want  int
+        err   error
+        loads int
+    }{
+        {name: "load failure", load: func() (int, error) { loads++; return 0, errLoad }, err: errLoad, loads: 1},
+        // The failure wasn't cached
+        {name: "loaded", load: func() (int, error) { loads++; return 42, nil }, want: 42, loads: 2},
+        {name: "cached", load: func() (int, error) { loads++; return 7, nil }, want: 42, loads: 2},
+    }
+    for _, tt := range tests {
+        got, err := c.GetOrSet("answer", tt.load)
+        if got != tt.want || !errors.Is(err, tt.err) || loads != tt.loads {
+            t.Errorf("%s: GetOrSet = %d, %v after %d loads, want %d, %v after %d", tt.name, got, err, loads, tt.want, tt.err, tt.loads)
+        }
+    }
+}
+
+func TestCacheOnEvict(t *testing.T) {
+    tests := []struct {
+        name string
+        // remove removes item "a" of a cache of 2 items with a minute TTL
+        remove func(c *Cache, clock *testClock)
+        want   EvictReason
+    }{
+        {name: "expired on lookup", remove: func(c *Cache, clock *testClock) { clock.now = clock.now.Add(time.Hour); c.Get("a") }, want: EvictExpired},
+        {name: "expired by cleanup", remove: func(c *Cache, clock *testClock) { clock.now = clock.now.Add(time.Hour); c.RunCleanupNow() }, want: EvictExpired},
+        {name: "capacity", remove: func(c *Cache, clock *testClock) { c.SetWithTTL("b", 2, time.Hour); c.SetWithTTL("c", 3, time.Hour) }, want: EvictCapacity},
+        {name: "deleted", remove: func(c *Cache, clock *testClock) { c.Delete("a") }, want: EvictDeleted},
+        {name: "cleared", remove: func(c *Cache, clock *testClock) { c.Clear() }, want: EvictCleared},
+        {name: "replaced", remove: func(c *Cache, clock *testClock) { c.Set("a", 2) }, want: EvictReplaced},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            var c *Cache
+            var got []string
+            c = newTestCache(t, clock, WithMaxSize(2), WithTTL(time.Minute),
+                WithOnEvict(func(key CacheKey, value interface{}, reason EvictReason) {
+                    // The hook may use the cache it is called by
+                    c.Keys("")
+                    got = append(got, fmt.Sprintf("%s=%v %s", key, value, reason))
+                }))
+            c.Set("a", 1)
+            tt.remove(c, clock)
+
+            want := fmt.Sprintf("a=1 %s", tt.want)
+            if len(got) != 1 || got[0] != want {
+                t.Errorf("evicted %v, want [%s]", got, want)
+            }
+        })
+    }
+}
+
+func TestCacheLookupHooks(t *testing.T) {
+    var hits, misses []CacheKey
+    c := newTestCache(t, &testClock{now: time.Unix(0, 0)},
+        WithOnHit(func(key CacheKey) { hits = append(hits, key) }),
+        WithOnMiss(func(key CacheKey) { misses = append(misses, key) }))
+    c.Set("a", 1)
+    c.Get("a")
+    c.Get("b")
+    c.GetMany([]CacheKey{"a", "c"})
+    c.GetOrSet("d", func() (interface{}, error) { return 4, nil })
+
+    if fmt.Sprint(hits) != "[a a]" || fmt.Sprint(misses) != "[b c d]" {
+        t.Errorf("hits %v and misses %v, want [a a] and [b c d]", hits, misses)
+    }
+}
+
+func TestCacheJitter(t *testing.T) {
+    tests := []struct {
+        name   string
+        jitter float64
+        rand   float64
+        ttl    time.Duration
+        want   time.Duration
+    }{
+        {name: "none", rand: 0, want: 10 * time.Minute},
+        {name: "shortest", jitter: 0.1, rand: 0, want: 9 * time.Minute},
+        {name: "middle", jitter: 0.1, rand: 0.5, want: 10 * time.Minute},
+        {name: "longer", jitter: 0.2, rand: 0.75, want: 11 * time.Minute},
+        {name: "own TTL", jitter: 0.1, rand: 0, ttl: time.Hour, want: 54 * time.Minute},
+        {name: "no expiry", jitter: 0.1, rand: 0, ttl: NoExpiry, want: NoExpiry},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            opts := []Option{WithTTL(10 * time.Minute), WithRand(func() float64 { return tt.rand })}
+            if tt.jitter > 0 {
+                opts = append(opts, WithJitter(tt.jitter))
+            }
+            c := newTestCache(t, &testClock{now: time.Unix(0, 0)}, opts...)
+            c.SetWithTTL("a", 1, tt.ttl)
+            c.SetMany(map[CacheKey]interface{}{"b": 2})
+
+            keys := []CacheKey{"a"}
+            if tt.ttl == 0 {
+                keys = append(keys, "b")
+            }
+            for _, key := range keys {
+                if _, left, _ := c.GetWithExpiry(key); left != tt.want {
+                    t.Errorf("%s expires in %s, want %s", key, left, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestCacheJitterSpread(t *testing.T) {
+    const items = 5000
+    tests := []struct {
+        name   string
+        jitter float64
+        // busiest is the most items expected to expire in the same second
+        busiest int
+    }{
+        {name: "no jitter", busiest: items},
+        // ±10% of 15 minutes spreads the items over 180 seconds
+        {name: "jitter", jitter: 0.1, busiest: items / 100},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            expirations := func() []time.Duration {
+                opts := []Option{WithTTL(15 * time.Minute), WithMaxSize(items), WithRand(rand.New(rand.NewSource(1)).Float64)}
+                if tt.jitter > 0 {
+                    opts = append(opts, WithJitter(tt.jitter))
+                }
+                // Items cached together at startup
+                c := newTestCache(t, &testClock{now: time.Unix(0, 0)}, opts...)
+                left := make([]time.Duration, items)
+                for i := range left {
+                    c.Set(CacheKey(fmt.Sprint(i)), i)
+                    _, left[i], _ = c.GetWithExpiry(CacheKey(fmt.Sprint(i)))
+                }
+                return left
+            }
+
+            left := expirations()
+            spread := time.Duration(tt.jitter * float64(15*time.Minute))
+            perSecond := make(map[time.Duration]int)
+            for _, d := range left {
+                if d < 15*time.Minute-spread || d > 15*time.Minute+spread {
+                    t.Fatalf("item expires in %s, beyond the jitter", d)
+                }
+                perSecond[d.Truncate(time.Second)]++
+            }
+            busiest := 0
+            for _, n := range perSecond {
+                if n > busiest {
+                    busiest = n
+                }
+            }
+            if busiest > tt.busiest {
+                t.Errorf("%d items expire in the same second, want at most %d", busiest, tt.busiest)
+            }
+
+            // The same rand source gives the same expirations
+            if again := expirations(); fmt.Sprint(again) != fmt.Sprint(left) {
+                t.Error("expirations differ with the same rand source")
+            }
+        })
+    }
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+    c := newTestCache(t, &testClock{now: time.Unix(0, 0)}, WithMaxSize(50),
+        WithOnEvict(func(CacheKey, interface{}, EvictReason) {}))
+
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 500; i++ {
+                key := CacheKey(fmt.Sprint(i % 80))
+                switch i % 5 {
+                case 0:
+                    c.Set(key, g)
+                case 1:
+                    c.SetMany(map[CacheKey]interface{}{key: g, "shared": i})
+                case 2:
+                    c.GetMany([]CacheKey{key, "shared"})
+                case 3:
+                    c.Delete(key)
+                default:
+                    c.Get(key)
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+
+    if size := c.GetStats().Size; size > 50 {
+        t.Errorf("size = %d, want at most 50", size)
+    }
+}
+
+func BenchmarkTypedCache(b *testing.B) {
+    keys := make([]CacheKey, 1000)
+    for i := range keys {
+        keys[i] = CacheKey(fmt.Sprintf("actor:%d", i))
+    }
+    clock := &testClock{now: time.Unix(0, 0)}
+
+    // A Cache is a TypedCache of interface{} values, so the typed cache is
+    // benchmarked against the assertions callers of a Cache make
+    b.Run("typed", func(b *testing.B) {
+        c, err := NewTyped[CacheKey, int](WithCleanupPeriod(0), WithClock(clock.Now), WithMaxSize(len(keys)))
+        if err != nil {
+            b.Fatal(err)
+        }
+        defer c.Close()
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            key := keys[i%len(keys)]
+            c.Set(key, i)
+            if value, ok := c.Get(key); !ok || value != i {
+                b.Fatalf("Get = %d, %v, want %d", value, ok, i)
+            }
+        }
+    })
+    b.Run("untyped", func(b *testing.B) {
+        c := newTestCache(b, clock, WithMaxSize(len(keys)))
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            key := keys[i%len(keys)]
+            c.Set(key, i)
+            value, ok := c.Get(key)
+            if n, isInt := value.(int); !ok || !isInt || n != i {
+                b.Fatalf("Get = %v, %v, want %d", value, ok, i)
+            }
+        }
+    })
+    b.Run("view", func(b *testing.B) {
+        view := ViewOf[int](newTestCache(b, clock, WithMaxSize(len(keys))))
+        b.ResetTimer()
+        for i := 0; i < b.N; i++ {
+            key := keys[i%len(keys)]
+            view.Set(key, i)
+            if value, ok := view.Get(key); !ok || value != i {
+                b.Fatalf("Get = %d, %v, want %d", value, ok, i)
+            }
+        }
+    })
+}
+
+func BenchmarkCacheBatch(b *testing.B) {
+    keys := make([]CacheKey, 100)
+    items := make(map[CacheKey]interface{}, len(keys))
+    for i := range keys {
+        keys[i] = CacheKey(fmt.Sprintf("fragment:%d", i))
+        items[keys[i]] = i
+    }
+
+    tests := []struct {
+        name string
+        run  func(c *Cache)
+    }{
+        {
+            name: "per key",
+            run: func(c *Cache) {
+                for key, value := range items {
+                    c.Set(key, value)
+                }
+                for _, key := range keys {
+                    c.Get(key)
+                }
+            },
+        },
+        {
+            name: "batch",
+            run: func(c *Cache) {
+                c.SetMany(items)
+                c.GetMany(keys)
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        b.Run(tt.name, func(b *testing.B) {
+            c := newTestCache(b, &testClock{now: time.Unix(0, 0)}, WithMaxSize(len(keys)))
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                tt.run(c)
+            }
+        })
+        b.Run(tt.name+" contended", func(b *testing.B) {
+            c := newTestCache(b, &testClock{now: time.Unix(0, 0)}, WithMaxSize(len(keys)))
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    tt.run(c)
+                }
+            })
+        })
+    }
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+    "fmt"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// cleanupGoroutines returns the number of goroutines running the background
+// cleanup of a cache, like goleak but only for the cache's own goroutines
+func cleanupGoroutines() int {
+    buf := make([]byte, 1<<20)
+    for {
+        n := runtime.Stack(buf, true)
+        if n < len(buf) {
+            buf = buf[:n]
+            break
+        }
+        buf = make([]byte, 2*len(buf))
+    }
+    // Goroutines not yet started have no cleanup frame, only their creator
+    return strings.Count(string(buf), "created by github.com/velumlabs/thor/cache.NewTyped")
+}
+
+// checkNoCleanupGoroutines fails the test if a background cleanup still runs
+// once it is done, so leaks of one test aren't blamed on the next
+func checkNoCleanupGoroutines(t *testing.T) {
+    t.Helper()
+    if n := cleanupGoroutines(); n != 0 {
+        t.Fatalf("%d cleanup goroutines running before the test", n)
+    }
+    t.Cleanup(func() {
+        if n := cleanupGoroutines(); n != 0 {
+            t.Errorf("%d cleanup goroutines leaked", n)
+        }
+    })
+}
+
+func TestCleanupGoroutine(t *testing.T) {
+    tests := []struct {
+        name  string
+        new   func() (*Cache, error)
+        // running is whether a background cleanup is expected
+        running bool
+    }{
+        {name: "default period", new: func() (*Cache, error) { return NewCache() }, running: true},
+        {name: "period", new: func() (*Cache, error) { return NewCache(WithCleanupPeriod(time.Millisecond)) }, running: true},
+        {name: "no cleanup", new: func() (*Cache, error) { return NewCache(WithCleanupPeriod(0)) }},
+        {name: "typed", new: func() (*Cache, error) { return NewTyped[CacheKey, interface{}](WithCleanupPeriod(time.Hour)) }, running: true},
+        // A zero period of a Config runs no cleanup rather than the default one
+        {name: "config without period", new: func() (*Cache, error) { return New(Config{}), nil }},
+        {name: "config period", new: func() (*Cache, error) { return New(Config{CleanupPeriod: time.Millisecond}), nil }, running: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            checkNoCleanupGoroutines(t)
+            c, err := tt.new()
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            want := 0
+            if tt.running {
+                want = 1
+            }
+            if got := cleanupGoroutines(); got != want {
+                t.Errorf("%d cleanup goroutines running, want %d", got, want)
+            }
+
+            // Close waits for the cleanup to exit, and closing again is fine
+            c.Close()
+            if got := cleanupGoroutines(); got != 0 {
+                t.Errorf("%d cleanup goroutines running after Close", got)
+            }
+            c.Close()
+        })
+    }
+}
+
+func TestCloseConcurrent(t *testing.T) {
+    checkNoCleanupGoroutines(t)
+    c, err := NewCache(WithCleanupPeriod(time.Millisecond))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            c.Set("a", 1)
+            c.Close()
+            // Every Close returns once the cleanup has exited
+            if n := cleanupGoroutines(); n != 0 {
+                t.Errorf("%d cleanup goroutines running after Close", n)
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+func TestBackgroundCleanup(t *testing.T) {
+    checkNoCleanupGoroutines(t)
+    var mu sync.Mutex
+    now := time.Unix(0, 0)
+    clock := func() time.Time {
+        mu.Lock()
+        defer mu.Unlock()
+        return now
+    }
+    expired := make(chan CacheKey, 2)
+    c, err := NewCache(WithCleanupPeriod(time.Millisecond), WithClock(clock), WithTTL(time.Minute),
+        WithOnEvict(func(key CacheKey, value interface{}, reason EvictReason) {
+            if reason == EvictExpired {
+                expired <- key
+            }
+        }))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer c.Close()
+
+    c.Set("a", 1)
+    c.SetWithTTL("b", 2, NoExpiry)
+    mu.Lock()
+    now = now.Add(time.Hour)
+    mu.Unlock()
+
+    // The item expires without being looked up
+    select {
+    case key := <-expired:
+        if key != "a" {
+            t.Errorf("%s expired, want a", key)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("expired item not removed in the background")
+    }
+    if stats := c.GetStats(); stats.Size != 1 || stats.Misses != 0 {
+        t.Errorf("stats = %+v, want the item without expiry left and no lookups", stats)
+    }
+}
+
+func TestRunCleanupNow(t *testing.T) {
+    tests := []struct {
+        name string
+        // advance is how long after the items are set the cleanup runs
+        advance time.Duration
+        want    []CacheKey
+    }{
+        {name: "none expired", advance: 30 * time.Second, want: []CacheKey{"hour", "minute", "never"}},
+        {name: "at expiry", advance: time.Minute, want: []CacheKey{"hour", "minute", "never"}},
+        {name: "some expired", advance: 2 * time.Minute, want: []CacheKey{"hour", "never"}},
+        {name: "all expired", advance: 1000 * time.Hour, want: []CacheKey{"never"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            c := newTestCache(t, clock, WithTTL(time.Minute))
+            c.Set("minute", 1)
+            c.SetWithTTL("hour", 2, time.Hour)
+            c.SetWithTTL("never", 3, NoExpiry)
+
+            clock.now = clock.now.Add(tt.advance)
+            c.RunCleanupNow()
+
+            got := c.Keys("")
+            sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("keys = %v, want %v", got, tt.want)
+            }
+            want := CacheStats{Size: len(tt.want), Evicted: int64(3 - len(tt.want))}
+            if stats := c.GetStats(); stats != want {
+                t.Errorf("stats = %+v, want %+v", stats, want)
+            }
+        })
+    }
+}
+
+func TestLazyExpiry(t *testing.T) {
+    tests := []struct {
+        name string
+        // lookup looks up item "a" after it expired
+        lookup func(c *Cache)
+    }{
+        {name: "get", lookup: func(c *Cache) { c.Get("a") }},
+        {name: "get with expiry", lookup: func(c *Cache) { c.GetWithExpiry("a") }},
+        {name: "get many", lookup: func(c *Cache) { c.GetMany([]CacheKey{"a", "b"}) }},
+        {name: "view", lookup: func(c *Cache) { ViewOf[int](c).Get("a") }},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            checkNoCleanupGoroutines(t)
+            clock := &testClock{now: time.Unix(0, 0)}
+            c := newTestCache(t, clock, WithTTL(time.Minute))
+            c.Set("a", 1)
+            c.SetWithTTL("b", 2, time.Hour)
+            clock.now = clock.now.Add(2 * time.Minute)
+
+            // Without a background cleanup, the lookup frees the expired item
+            tt.lookup(c)
+            if stats := c.GetStats(); stats.Size != 1 || stats.Evicted != 1 {
+                t.Errorf("stats = %+v, want the expired item freed", stats)
+            }
+            if _, ok := c.Get("b"); !ok {
+                t.Error("unexpired item freed")
+            }
+        })
+    }
+}
+
+func TestUseAfterClose(t *testing.T) {
+    checkNoCleanupGoroutines(t)
+    clock := &testClock{now: time.Unix(0, 0)}
+    c, err := NewCache(WithCleanupPeriod(time.Hour), WithClock(clock.Now), WithTTL(time.Minute))
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.Close()
+
+    // The cache stays usable, expiring items on lookups and RunCleanupNow
+    c.Set("a", 1)
+    c.Set("b", 2)
+    if value, ok := c.Get("a"); !ok || value != 1 {
+        t.Errorf("Get(a) = %v, %v after Close, want 1", value, ok)
+    }
+    clock.now = clock.now.Add(2 * time.Minute)
+    c.Get("a")
+    if size := c.GetStats().Size; size != 1 {
+        t.Errorf("size = %d after looking up an expired item, want 1", size)
+    }
+    c.RunCleanupNow()
+    if size := c.GetStats().Size; size != 0 {
+        t.Errorf("size = %d after RunCleanupNow, want 0", size)
+    }
+}
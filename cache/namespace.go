@@ -0,0 +1,229 @@
+package cache
+
+import (
+    "strings"
+    "sync/atomic"
+)
+
+// NamespaceSeparator ends the prefix of the keys of a namespace, see
+// NamespaceOf.
+const NamespaceSeparator = ":"
+
+// namespaceState indexes the keys of a namespace of a cache, so its items can
+// be listed and removed without scanning the whole cache, and counts its
+// lookups. The keys are guarded by the cache's lock. A namespace has a state
+// from the time its first item is added until its last item is removed.
+type namespaceState[K comparable] struct {
+    keys map[K]struct{}
+
+    hits    int64
+    misses  int64
+    evicted int64
+}
+
+// Namespace is a view of the items of a Cache whose keys start with the
+// prefix of the namespace, which it prepends to the keys it is given:
+//
+//	actor := cache.NamespaceOf(c, "actor:"+string(actorID))
+//	actor.Set("summary", summary) // under "actor:<id>:summary"
+//	...
+//	actor.Clear() // after merging the actor
+//
+// Namespaces nest, and the keys of every namespace are indexed as they are
+// added, so clearing and listing them doesn't scan the cache. Its lookups
+// through the namespace are counted in its Stats while it has items; the
+// index and stats of a namespace are dropped with its last item.
+type Namespace struct {
+    View[interface{}]
+}
+
+// NamespaceOf returns the namespace name of c, whose keys are prefixed with
+// name and NamespaceSeparator.
+func NamespaceOf(c *Cache, name string) Namespace {
+    return Namespace{View[interface{}]{
+        cache:  c,
+        prefix: CacheKey(name + NamespaceSeparator),
+    }}
+}
+
+// Namespace returns the namespace name nested in n.
+func (n Namespace) Namespace(name string) Namespace {
+    return NamespaceOf(n.cache, string(n.prefix)+name)
+}
+
+// Prefix returns the prefix of the keys of the namespace in the cache.
+func (n Namespace) Prefix() CacheKey {
+    return n.prefix
+}
+
+// Keys returns the keys of the unexpired items of the namespace, without its
+// prefix, in no particular order.
+func (n Namespace) Keys() []CacheKey {
+    keys := n.cache.Keys(string(n.prefix))
+    for i, key := range keys {
+        keys[i] = key[len(n.prefix):]
+    }
+    return keys
+}
+
+// Clear removes the items of the namespace, and of the namespaces nested in
+// it, returning how many were removed.
+func (n Namespace) Clear() int {
+    return n.cache.DeletePrefix(string(n.prefix))
+}
+
+// Stats returns the number of items of the namespace, the lookups through it
+// and the items of it that expired or were evicted, since its first item was
+// added. A namespace without items has none.
+func (n Namespace) Stats() CacheStats {
+    n.cache.mu.RLock()
+    defer n.cache.mu.RUnlock()
+
+    ns, ok := n.cache.namespaces[string(n.prefix)]
+    if !ok {
+        return CacheStats{}
+    }
+    return CacheStats{
+        Size:    len(ns.keys),
+        Hits:    atomic.LoadInt64(&ns.hits),
+        Misses:  atomic.LoadInt64(&ns.misses),
+        Evicted: atomic.LoadInt64(&ns.evicted),
+    }
+}
+
+// Keys returns the keys of the unexpired items whose keys start with prefix,
+// in no particular order. Prefixes ending with NamespaceSeparator, those of
+// namespaces, see NamespaceOf, are read from their index; others scan the
+// cache. Caches whose keys aren't strings have no keys with a prefix.
+func (c *TypedCache[K, V]) Keys(prefix string) []K {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    now := c.now()
+    var keys []K
+    c.eachWithPrefix(prefix, func(key K, item entry[V]) {
+        if !item.expired(now) {
+            keys = append(keys, key)
+        }
+    })
+    return keys
+}
+
+// DeletePrefix removes the items whose keys start with prefix, e.g. all those
+// about an actor, returning how many were removed. Like Keys, it only scans the
+// cache for prefixes that aren't those of a namespace.
+func (c *TypedCache[K, V]) DeletePrefix(prefix string) int {
+    var removed []evicted[K, V]
+    defer func() { c.notifyEvicted(removed) }()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    var keys []K
+    c.eachWithPrefix(prefix, func(key K, item entry[V]) {
+        keys = append(keys, key)
+        if c.onEvict != nil {
+            removed = append(removed, evicted[K, V]{key, item.value, EvictDeleted})
+        }
+    })
+    for _, key := range keys {
+        c.remove(key, c.items[key], false)
+    }
+    return len(keys)
+}
+
+// eachWithPrefix calls fn with the items whose keys start with prefix. Must be
+// called with mu held.
+func (c *TypedCache[K, V]) eachWithPrefix(prefix string, fn func(key K, item entry[V])) {
+    if strings.HasSuffix(prefix, NamespaceSeparator) {
+        // The index of a namespace lists all its keys, and a namespace
+        // without one has none
+        if ns, ok := c.namespaces[prefix]; ok {
+            for key := range ns.keys {
+                fn(key, c.items[key])
+            }
+        }
+        return
+    }
+    for key, item := range c.items {
+        if name, ok := keyString(key); ok && strings.HasPrefix(name, prefix) {
+            fn(key, item)
+        }
+    }
+}
+
+// countLookups counts lookups through the namespace with prefix, if it has
+// items.
+func (c *TypedCache[K, V]) countLookups(prefix string, hits, misses int64) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    if ns, ok := c.namespaces[prefix]; ok {
+        atomic.AddInt64(&ns.hits, hits)
+        atomic.AddInt64(&ns.misses, misses)
+    }
+}
+
+// index adds a new key to the namespaces it is in, adding those it is the
+// first item of. Must be called with mu held.
+func (c *TypedCache[K, V]) index(key K) {
+    eachNamespace(key, func(prefix string) {
+        ns, ok := c.namespaces[prefix]
+        if !ok {
+            if c.namespaces == nil {
+                c.namespaces = make(map[string]*namespaceState[K])
+            }
+            ns = &namespaceState[K]{keys: make(map[K]struct{})}
+            c.namespaces[prefix] = ns
+        }
+        ns.keys[key] = struct{}{}
+    })
+}
+
+// unindex removes a removed key from the namespaces it was in, see remove,
+// dropping those it was the last item of. Must be called with mu held.
+func (c *TypedCache[K, V]) unindex(key K, evicted bool) {
+    eachNamespace(key, func(prefix string) {
+        ns, ok := c.namespaces[prefix]
+        if !ok {
+            return
+        }
+        delete(ns.keys, key)
+        if evicted {
+            atomic.AddInt64(&ns.evicted, 1)
+        }
+        if len(ns.keys) == 0 {
+            delete(c.namespaces, prefix)
+        }
+    })
+}
+
+// eachNamespace calls fn with the prefixes of the namespaces key is in: those
+// ending at a NamespaceSeparator of the key.
+func eachNamespace[K comparable](key K, fn func(prefix string)) {
+    name, ok := keyString(key)
+    if !ok {
+        return
+    }
+    for i := strings.Index(name, NamespaceSeparator); i >= 0; {
+        end := i + len(NamespaceSeparator)
+        fn(name[:end])
+        next := strings.Index(name[end:], NamespaceSeparator)
+        if next < 0 {
+            break
+        }
+        i = end + next
+    }
+}
+
+// keyString returns a key as a string, if it is one.
+func keyString[K comparable](key K) (string, bool) {
+    switch key := any(key).(type) {
+    case CacheKey:
+        return string(key), true
+    case string:
+        return key, true
+    default:
+        return "", false
+    }
+}
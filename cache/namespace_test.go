@@ -0,0 +1,221 @@
+package cache
+
+import (
+    "fmt"
+    "sort"
+    "testing"
+    "time"
+)
+
+// testClock is a clock tests move forward by hand
+type testClock struct {
+    now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+    return c.now
+}
+
+// newTestCache returns a cache without background cleanup on clock
+func newTestCache(t testing.TB, clock *testClock, opts ...Option) *Cache {
+    t.Helper()
+    opts = append([]Option{WithCleanupPeriod(0), WithClock(clock.Now)}, opts...)
+    c, err := NewCache(opts...)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(c.Close)
+    return c
+}
+
+func TestNamespacePruned(t *testing.T) {
+    tests := []struct {
+        name string
+        opts []Option
+        // remove removes the items of namespace actor of c
+        remove func(c *Cache, actor Namespace, clock *testClock)
+    }{
+        {
+            name: "deleted",
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                actor.Delete("summary")
+                actor.Namespace("facts").Delete("age")
+            },
+        },
+        {
+            name: "namespace cleared",
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                actor.Clear()
+            },
+        },
+        {
+            name: "cache cleared",
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                c.Clear()
+            },
+        },
+        {
+            name: "expired",
+            opts: []Option{WithTTL(time.Minute)},
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                clock.now = clock.now.Add(time.Hour)
+                c.RunCleanupNow()
+            },
+        },
+        {
+            name: "expired on lookup",
+            opts: []Option{WithTTL(time.Minute)},
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                clock.now = clock.now.Add(time.Hour)
+                actor.Get("summary")
+                actor.Namespace("facts").Get("age")
+            },
+        },
+        {
+            name: "evicted",
+            opts: []Option{WithMaxSize(2)},
+            remove: func(c *Cache, actor Namespace, clock *testClock) {
+                // Items expiring are evicted before those that don't
+                c.SetWithTTL("other", 1, NoExpiry)
+                c.SetWithTTL("another", 2, NoExpiry)
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            c := newTestCache(t, clock, tt.opts...)
+
+            actor := NamespaceOf(c, "actor:1")
+            actor.Set("summary", "likes tea")
+            actor.Namespace("facts").Set("age", 42)
+            if got := len(c.namespaces); got != 3 {
+                t.Fatalf("namespaces = %d, want 3", got)
+            }
+
+            tt.remove(c, actor, clock)
+
+            if got := len(c.namespaces); got != 0 {
+                t.Errorf("namespaces = %d after removing their items, want 0", got)
+            }
+            if got := actor.Stats(); got != (CacheStats{}) {
+                t.Errorf("stats = %+v, want none", got)
+            }
+            if got := actor.Keys(); len(got) != 0 {
+                t.Errorf("keys = %v, want none", got)
+            }
+
+            // The namespace is indexed again with its next item
+            actor.Set("summary", "likes coffee")
+            if got := actor.Keys(); len(got) != 1 || got[0] != "summary" {
+                t.Errorf("keys = %v, want [summary]", got)
+            }
+        })
+    }
+}
+
+func TestNamespaceKeys(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c := newTestCache(t, clock)
+
+    // Items set before the namespace is first used are indexed
+    c.Set("actor:1:summary", "likes tea")
+    c.Set("actor:1:facts:age", 42)
+    c.Set("actor:2:summary", "likes coffee")
+    c.Set("actorless", true)
+
+    tests := []struct {
+        name string
+        keys func() []CacheKey
+        want []CacheKey
+    }{
+        {
+            name: "namespace",
+            keys: NamespaceOf(c, "actor:1").Keys,
+            want: []CacheKey{"facts:age", "summary"},
+        },
+        {
+            name: "nested namespace",
+            keys: NamespaceOf(c, "actor:1").Namespace("facts").Keys,
+            want: []CacheKey{"age"},
+        },
+        {
+            name: "unused namespace",
+            keys: NamespaceOf(c, "actor:3").Keys,
+        },
+        {
+            name: "prefix of no namespace",
+            keys: func() []CacheKey { return c.Keys("actor") },
+            want: []CacheKey{"actor:1:facts:age", "actor:1:summary", "actor:2:summary", "actorless"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := tt.keys()
+            sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("keys = %v, want %v", got, tt.want)
+            }
+        })
+    }
+
+    if removed := NamespaceOf(c, "actor:1").Clear(); removed != 2 {
+        t.Errorf("Clear removed %d items, want 2", removed)
+    }
+    if _, ok := c.Get("actor:2:summary"); !ok {
+        t.Error("Clear removed an item of another namespace")
+    }
+}
+
+func TestNamespaceStats(t *testing.T) {
+    clock := &testClock{now: time.Unix(0, 0)}
+    c := newTestCache(t, clock, WithTTL(time.Minute))
+
+    actor := NamespaceOf(c, "actor:1")
+    actor.Set("summary", "likes tea")
+    actor.Set("name", "Ada")
+    actor.Get("summary")
+    actor.Get("missing")
+    ViewOfNamespace[int](actor).Get("summary")
+    ViewOfNamespace[string](actor).GetMany([]CacheKey{"summary", "name", "missing"})
+
+    want := CacheStats{Size: 2, Hits: 3, Misses: 3}
+    if got := actor.Stats(); got != want {
+        t.Errorf("stats = %+v, want %+v", got, want)
+    }
+
+    // Lookups of other namespaces aren't counted
+    NamespaceOf(c, "actor:2").Get("summary")
+    if got := actor.Stats(); got != want {
+        t.Errorf("stats = %+v after looking up another namespace, want %+v", got, want)
+    }
+
+    // Evictions are counted until the last item is removed
+    actor.Set("name", "Ada")
+    clock.now = clock.now.Add(30 * time.Second)
+    actor.SetWithTTL("name", "Ada", time.Hour)
+    clock.now = clock.now.Add(time.Minute)
+    c.RunCleanupNow()
+    want = CacheStats{Size: 1, Hits: 3, Misses: 3, Evicted: 1}
+    if got := actor.Stats(); got != want {
+        t.Errorf("stats = %+v after expiry, want %+v", got, want)
+    }
+}
+
+func BenchmarkNamespaceOf(b *testing.B) {
+    for _, size := range []int{100, 10000} {
+        b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+            c := newTestCache(b, &testClock{now: time.Unix(0, 0)}, WithMaxSize(size+1))
+            for i := 0; i < size; i++ {
+                c.Set(CacheKey(fmt.Sprintf("actor:%d:summary", i)), i)
+            }
+
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                NamespaceOf(c, fmt.Sprintf("actor:%d", i%size)).Get("summary")
+            }
+        })
+    }
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/options"
+)
+
+// Option configures NewCache.
+type Option = options.Option[Config]
+
+// WithMaxSize sets the maximum number of items the cache holds,
+// DefaultMaxSize if not set.
+func WithMaxSize(n int) Option {
+    return func(c *Config) error {
+        if n <= 0 {
+            return fmt.Errorf("cache max size must be positive")
+        }
+        c.MaxSize = n
+        return nil
+    }
+}
+
+// WithTTL sets how long items are kept, DefaultTTL if not set. SetWithTTL
+// overrides it per item.
+func WithTTL(ttl time.Duration) Option {
+    return func(c *Config) error {
+        if ttl <= 0 {
+            return fmt.Errorf("cache TTL must be positive")
+        }
+        c.TTL = ttl
+        return nil
+    }
+}
+
+// WithCleanupPeriod sets how often expired items are removed in the
+// background, DefaultCleanupPeriod if not set, or 0 for no background cleanup.
+// Expired items are never returned, and are also freed when a lookup finds
+// them, by eviction and by RunCleanupNow.
+func WithCleanupPeriod(period time.Duration) Option {
+    return func(c *Config) error {
+        if period < 0 {
+            return fmt.Errorf("cache cleanup period must not be negative")
+        }
+        c.CleanupPeriod = period
+        return nil
+    }
+}
+
+// WithJitter spreads the expirations of items by up to fraction of their TTL
+// either way, e.g. 0.1 for ±10%, so items cached together, such as at startup,
+// don't all expire and reload at once. Expirations aren't spread if not set.
+func WithJitter(fraction float64) Option {
+    return func(c *Config) error {
+        if fraction < 0 || fraction >= 1 {
+            return fmt.Errorf("cache jitter must be at least 0 and less than 1")
+        }
+        c.Jitter = fraction
+        return nil
+    }
+}
+
+// WithRand sets the source of random numbers in [0, 1) the jitter is picked
+// with, rand.Float64 if not set, e.g. for tests to expire items
+// deterministically. It is called on every Set of a cache with jitter and must
+// be safe for concurrent use.
+func WithRand(source func() float64) Option {
+    return func(c *Config) error {
+        if source == nil {
+            return fmt.Errorf("cache rand source must not be nil")
+        }
+        c.Rand = source
+        return nil
+    }
+}
+
+// WithClock sets the clock items expire by, time.Now if not set, e.g. for
+// tests to expire items without waiting.
+func WithClock(now func() time.Time) Option {
+    return func(c *Config) error {
+        if now == nil {
+            return fmt.Errorf("cache clock must not be nil")
+        }
+        c.Clock = now
+        return nil
+    }
+}
+
+// WithMaxBytes bounds the estimated bytes of the items the cache holds,
+// evicting the items closest to expiring until a new item fits, e.g. for caches
+// whose values vary widely in size. Items larger than the limit aren't cached.
+// Sizes are estimated by EstimateSize unless WithSizer is set; see it for the
+// caveats of the estimate. The entry limit of WithMaxSize still applies.
+func WithMaxBytes(n int64) Option {
+    return func(c *Config) error {
+        if n <= 0 {
+            return fmt.Errorf("cache max bytes must be positive")
+        }
+        c.MaxBytes = n
+        return nil
+    }
+}
+
+// WithSizer sets how the cache estimates the bytes of its values, EstimateSize
+// if not set, for WithMaxBytes and the Bytes of GetStats, which is zero for
+// caches with neither option. Its value type must be that of the cache,
+// interface{} for a Cache. It is called outside the cache's lock on every Set.
+func WithSizer[V any](fn func(value V) int) Option {
+    return func(c *Config) error {
+        if fn == nil {
+            return fmt.Errorf("cache sizer must not be nil")
+        }
+        c.sizer = fn
+        return nil
+    }
+}
+
+// WithOnEvict sets a hook called with every item that leaves the cache and
+// why, e.g. to close the resources items hold:
+//
+//	statements, err := cache.NewTyped[string, *sql.Stmt](
+//	    cache.WithOnEvict(func(query string, stmt *sql.Stmt, reason cache.EvictReason) {
+//	        stmt.Close()
+//	    }),
+//	)
+//
+// Its key and value types must be those of the cache, CacheKey and
+// interface{} for a Cache. The hook is called outside the cache's lock, so it
+// may use the cache, but it delays the call that evicted the item, so it must
+// be fast or start a goroutine of its own.
+func WithOnEvict[K comparable, V any](fn func(key K, value V, reason EvictReason)) Option {
+    return func(c *Config) error {
+        if fn == nil {
+            return fmt.Errorf("cache eviction hook must not be nil")
+        }
+        c.onEvict = fn
+        return nil
+    }
+}
+
+// WithOnHit sets a hook called with the key of every lookup that found an
+// item, e.g. for metrics. Like the hook of WithOnEvict, it must be fast and its
+// key type must be that of the cache.
+func WithOnHit[K comparable](fn func(key K)) Option {
+    return func(c *Config) error {
+        if fn == nil {
+            return fmt.Errorf("cache hit hook must not be nil")
+        }
+        c.onHit = fn
+        return nil
+    }
+}
+
+// WithOnMiss sets a hook called with the key of every lookup that found no
+// item, see WithOnHit.
+func WithOnMiss[K comparable](fn func(key K)) Option {
+    return func(c *Config) error {
+        if fn == nil {
+            return fmt.Errorf("cache miss hook must not be nil")
+        }
+        c.onMiss = fn
+        return nil
+    }
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+    "reflect"
+)
+
+// maxEstimateDepth bounds how deep EstimateSize follows pointers, maps and
+// slices, so deeply linked values don't take long to estimate.
+const maxEstimateDepth = 16
+
+// EstimateSize returns a rough estimate of the bytes a value holds: its own
+// size plus the strings, slices, maps and pointed-to values it references. It
+// is the default sizer of a cache with WithMaxBytes.
+//
+// The estimate ignores allocator overhead, map buckets beyond their entries,
+// slice capacity beyond length, and values reached through interfaces of
+// channels or functions. Values referenced more than once are counted once,
+// and references deeper than a few levels aren't followed. Callers whose
+// values' sizes matter should pass their own sizer with WithSizer.
+func EstimateSize(value interface{}) int {
+    if value == nil {
+        return 0
+    }
+    v := reflect.ValueOf(value)
+    return int(v.Type().Size()) + estimateReferenced(v, make(map[uintptr]bool), 0)
+}
+
+// estimateReferenced returns the bytes referenced by v, beyond its own size.
+func estimateReferenced(v reflect.Value, seen map[uintptr]bool, depth int) int {
+    if depth > maxEstimateDepth {
+        return 0
+    }
+    switch v.Kind() {
+    case reflect.String:
+        return v.Len()
+    case reflect.Ptr:
+        if v.IsNil() || seen[v.Pointer()] {
+            return 0
+        }
+        seen[v.Pointer()] = true
+        elem := v.Elem()
+        return int(elem.Type().Size()) + estimateReferenced(elem, seen, depth+1)
+    case reflect.Interface:
+        if v.IsNil() {
+            return 0
+        }
+        elem := v.Elem()
+        return int(elem.Type().Size()) + estimateReferenced(elem, seen, depth+1)
+    case reflect.Slice:
+        if v.IsNil() || v.Len() == 0 || seen[v.Pointer()] {
+            return 0
+        }
+        seen[v.Pointer()] = true
+        size := v.Len() * int(v.Type().Elem().Size())
+        if hasReferences(v.Type().Elem()) {
+            for i := 0; i < v.Len(); i++ {
+                size += estimateReferenced(v.Index(i), seen, depth+1)
+            }
+        }
+        return size
+    case reflect.Array:
+        size := 0
+        if hasReferences(v.Type().Elem()) {
+            for i := 0; i < v.Len(); i++ {
+                size += estimateReferenced(v.Index(i), seen, depth+1)
+            }
+        }
+        return size
+    case reflect.Map:
+        if v.IsNil() || seen[v.Pointer()] {
+            return 0
+        }
+        seen[v.Pointer()] = true
+        size := v.Len() * int(v.Type().Key().Size()+v.Type().Elem().Size())
+        iter := v.MapRange()
+        for iter.Next() {
+            size += estimateReferenced(iter.Key(), seen, depth+1)
+            size += estimateReferenced(iter.Value(), seen, depth+1)
+        }
+        return size
+    case reflect.Struct:
+        size := 0
+        for i := 0; i < v.NumField(); i++ {
+            size += estimateReferenced(v.Field(i), seen, depth+1)
+        }
+        return size
+    default:
+        return 0
+    }
+}
+
+// hasReferences reports whether values of type t may reference memory beyond
+// their own size.
+func hasReferences(t reflect.Type) bool {
+    switch t.Kind() {
+    case reflect.String, reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+        return true
+    case reflect.Array:
+        return hasReferences(t.Elem())
+    case reflect.Struct:
+        for i := 0; i < t.NumField(); i++ {
+            if hasReferences(t.Field(i).Type) {
+                return true
+            }
+        }
+        return false
+    default:
+        return false
+    }
+}
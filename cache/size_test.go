@@ -0,0 +1,217 @@
+package cache
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+)
+
+// sizeNode is a linked value for the EstimateSize tests
+type sizeNode struct {
+    next *sizeNode
+    name string
+}
+
+func TestEstimateSize(t *testing.T) {
+    shared := make([]byte, 100)
+    cycle := &sizeNode{name: "x"}
+    cycle.next = cycle
+
+    tests := []struct {
+        name  string
+        value interface{}
+        want  int
+    }{
+        {name: "nil", value: nil, want: 0},
+        {name: "int", value: 42, want: 8},
+        {name: "string", value: "hello", want: 16 + 5},
+        {name: "bytes", value: make([]byte, 100), want: 24 + 100},
+        {name: "empty slice", value: []string{}, want: 24},
+        {name: "strings", value: []string{"ab", "c"}, want: 24 + 2*16 + 3},
+        {name: "interfaces", value: []interface{}{"ab", 1}, want: 24 + 2*16 + (16 + 2) + 8},
+        {name: "array", value: [2]string{"ab", "c"}, want: 2*16 + 3},
+        {name: "map", value: map[string]int{"ab": 1}, want: 8 + (16 + 8) + 2},
+        {name: "nil pointer", value: (*sizeNode)(nil), want: 8},
+        {name: "struct", value: sizeNode{name: "abc"}, want: 24 + 3},
+        {name: "pointer", value: &sizeNode{name: "abc"}, want: 8 + 24 + 3},
+        // Values referenced more than once are counted once
+        {name: "cycle", value: cycle, want: 8 + 24 + 1},
+        {name: "shared slice", value: [][]byte{shared, shared}, want: 24 + 2*24 + 100},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := EstimateSize(tt.value); got != tt.want {
+                t.Errorf("EstimateSize = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestEstimateSizeDepth(t *testing.T) {
+    // A chain longer than the depth followed is estimated in part
+    var head *sizeNode
+    for i := 0; i < 10*maxEstimateDepth; i++ {
+        head = &sizeNode{next: head, name: strings.Repeat("a", 100)}
+    }
+    got := EstimateSize(head)
+    if whole := 8 + 10*maxEstimateDepth*(24+100); got <= 0 || got >= whole {
+        t.Errorf("EstimateSize = %d, want less than the %d of the whole chain", got, whole)
+    }
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+    const maxBytes = 10000
+    tests := []struct {
+        name string
+        opts []Option
+        // size is the bytes counted for a value of n bytes
+        size func(n int) int64
+    }{
+        {name: "estimated", size: func(n int) int64 { return int64(24 + n) }},
+        {
+            name: "sizer",
+            opts: []Option{WithSizer(func(value interface{}) int { return 2 * len(value.([]byte)) })},
+            size: func(n int) int64 { return int64(2 * n) },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            c := newTestCache(t, clock, append([]Option{WithMaxBytes(maxBytes), WithTTL(time.Hour)}, tt.opts...)...)
+
+            // A stream of large values of varying sizes stays under the cap
+            for i := 0; i < 1000; i++ {
+                clock.now = clock.now.Add(time.Second)
+                n := 500 + (i*397)%2500
+                c.Set(CacheKey(fmt.Sprint(i)), make([]byte, n))
+
+                stats := c.GetStats()
+                if stats.Bytes > maxBytes {
+                    t.Fatalf("after %d values: %d bytes, want at most %d", i+1, stats.Bytes, maxBytes)
+                }
+                var total int64
+                for _, key := range c.Keys("") {
+                    value, _ := c.Get(key)
+                    total += tt.size(len(value.([]byte)))
+                }
+                if stats.Bytes != total {
+                    t.Fatalf("after %d values: %d bytes, want the %d of the items held", i+1, stats.Bytes, total)
+                }
+                // The newest value is always kept
+                if _, ok := c.Get(CacheKey(fmt.Sprint(i))); !ok {
+                    t.Fatalf("value %d evicted to make room for itself", i)
+                }
+            }
+            if stats := c.GetStats(); stats.Evicted == 0 {
+                t.Errorf("stats = %+v, want evictions", stats)
+            }
+        })
+    }
+}
+
+func TestCacheMaxBytesChanges(t *testing.T) {
+    // Values are counted as their length
+    sizer := WithSizer(func(value interface{}) int { return len(value.(string)) })
+    tests := []struct {
+        name string
+        opts []Option
+        do   func(c *Cache)
+        // want are the keys left and their bytes
+        want  []CacheKey
+        bytes int64
+        // evicted are the evictions reported
+        evicted []string
+    }{
+        {
+            name:    "too large",
+            do:      func(c *Cache) { c.Set("a", "aaaa"); c.Set("big", strings.Repeat("b", 11)) },
+            want:    []CacheKey{"a"},
+            bytes:   4,
+            evicted: []string{"big capacity"},
+        },
+        {
+            name:    "replaced by a smaller value",
+            do:      func(c *Cache) { c.Set("a", "aaaaaa"); c.Set("b", "bbbb"); c.Set("a", "a") },
+            want:    []CacheKey{"a", "b"},
+            bytes:   5,
+            evicted: []string{"a replaced"},
+        },
+        {
+            name:    "replaced by a larger value",
+            do:      func(c *Cache) { c.Set("a", "aaa"); c.Set("b", "bbbb"); c.Set("a", "aaaaaaaa") },
+            want:    []CacheKey{"a"},
+            bytes:   8,
+            evicted: []string{"a replaced", "b capacity"},
+        },
+        {
+            name:  "deleted",
+            do:    func(c *Cache) { c.Set("a", "aaa"); c.Set("b", "bbbb"); c.Delete("b") },
+            want:  []CacheKey{"a"},
+            bytes: 3,
+            // Deletions are reported too
+            evicted: []string{"b deleted"},
+        },
+        {
+            name:    "cleared",
+            do:      func(c *Cache) { c.Set("a", "aaa"); c.Clear(); c.Set("b", "bb") },
+            want:    []CacheKey{"b"},
+            bytes:   2,
+            evicted: []string{"a cleared"},
+        },
+        {
+            name:    "entry limit",
+            opts:    []Option{WithMaxSize(2)},
+            do:      func(c *Cache) { c.SetWithTTL("a", "a", time.Second); c.Set("b", "b"); c.Set("c", "c") },
+            want:    []CacheKey{"b", "c"},
+            bytes:   2,
+            evicted: []string{"a capacity"},
+        },
+        {
+            name:    "batch",
+            do:      func(c *Cache) { c.SetMany(map[CacheKey]interface{}{"a": "aaaaa", "b": "bbbbb"}); c.Set("c", "cccccc") },
+            want:    []CacheKey{"c"},
+            bytes:   6,
+            evicted: []string{"a capacity", "b capacity"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clock := &testClock{now: time.Unix(0, 0)}
+            var evicted []string
+            onEvict := WithOnEvict(func(key CacheKey, value interface{}, reason EvictReason) {
+                evicted = append(evicted, fmt.Sprintf("%s %s", key, reason))
+            })
+            opts := append([]Option{WithMaxBytes(10), sizer, onEvict}, tt.opts...)
+            c := newTestCache(t, clock, opts...)
+            tt.do(c)
+
+            got := c.Keys("")
+            sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+            if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+                t.Errorf("keys = %v, want %v", got, tt.want)
+            }
+            if stats := c.GetStats(); stats.Bytes != tt.bytes {
+                t.Errorf("bytes = %d, want %d", stats.Bytes, tt.bytes)
+            }
+            // Items expiring at once are evicted in no set order
+            sort.Strings(evicted)
+            if fmt.Sprint(evicted) != fmt.Sprint(tt.evicted) {
+                t.Errorf("evicted %v, want %v", evicted, tt.evicted)
+            }
+        })
+    }
+}
+
+func TestCacheBytesUntracked(t *testing.T) {
+    c := newTestCache(t, &testClock{now: time.Unix(0, 0)})
+    c.Set("a", strings.Repeat("a", 1000))
+    if stats := c.GetStats(); stats.Bytes != 0 {
+        t.Errorf("bytes = %d without WithMaxBytes or WithSizer, want 0", stats.Bytes)
+    }
+}
+
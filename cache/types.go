@@ -6,49 +6,156 @@ import (
     "time"
 )
 
+const (
+    // DefaultMaxSize is the number of items a cache holds when no size is set.
+    DefaultMaxSize = 1000
+
+    // DefaultTTL is how long items are kept when no TTL is set.
+    DefaultTTL = 15 * time.Minute
+
+    // DefaultCleanupPeriod is how often expired items are removed when no
+    // period is set.
+    DefaultCleanupPeriod = time.Minute
+
+    // NoExpiry is the TTL of items that never expire, see TypedCache.SetWithTTL.
+    // Such items are only evicted when the cache is full of them.
+    NoExpiry time.Duration = -1
+)
+
 // CacheKey is used as a type for cache keys.
 type CacheKey string
 
 // CacheEntry represents a single item in the cache with its value and expiration time.
+// Kept for compatibility; caches store their items as typed entries.
 type CacheEntry struct {
     Value      interface{}
     Expiration time.Time
 }
 
+// entry is an item of a TypedCache with its expiration time, zero if it never
+// expires, and its estimated size in bytes if the cache tracks sizes.
+type entry[V any] struct {
+    value      V
+    expiration time.Time
+    size       int64
+}
+
+// expired reports whether the item has expired at now.
+func (e entry[V]) expired(now time.Time) bool {
+    return !e.expiration.IsZero() && now.After(e.expiration)
+}
+
+// EvictReason tells why an item left the cache, see WithOnEvict.
+type EvictReason int
+
+const (
+    // EvictExpired is reported for items removed after their TTL.
+    EvictExpired EvictReason = iota
+    // EvictCapacity is reported for items evicted to make room in a full cache.
+    EvictCapacity
+    // EvictDeleted is reported for items removed by Delete.
+    EvictDeleted
+    // EvictCleared is reported for the items removed by Clear.
+    EvictCleared
+    // EvictReplaced is reported for items replaced by a Set under their key.
+    EvictReplaced
+)
+
+// String returns the name of the reason.
+func (r EvictReason) String() string {
+    switch r {
+    case EvictExpired:
+        return "expired"
+    case EvictCapacity:
+        return "capacity"
+    case EvictDeleted:
+        return "deleted"
+    case EvictCleared:
+        return "cleared"
+    case EvictReplaced:
+        return "replaced"
+    default:
+        return "unknown"
+    }
+}
+
 // Config holds configuration parameters for initializing a Cache.
 type Config struct {
-    MaxSize       int           // Maximum number of items the cache can hold.
-    TTL           time.Duration // Time to live for each cache item.
-    CleanupPeriod time.Duration // How often to clean up expired items.
+    MaxSize       int              // Maximum number of items the cache can hold.
+    TTL           time.Duration    // Time to live for each cache item.
+    CleanupPeriod time.Duration    // How often to clean up expired items, not in the background if zero.
+    Clock         func() time.Time // Current time for expirations, time.Now if nil.
+    MaxBytes      int64            // Estimated bytes the cache can hold, no limit if zero.
+    Jitter        float64          // Fraction of each TTL expirations are spread by, none if zero.
+    Rand          func() float64   // Random numbers in [0, 1) for the jitter, rand.Float64 if nil.
+
+    // sizer estimates the bytes of values, set by WithSizer and checked by
+    // NewTyped like the hooks
+    sizer interface{}
+
+    // Hooks set by WithOnEvict, WithOnHit and WithOnMiss, typed by the keys
+    // and values of the cache they were set for and checked by NewTyped
+    onEvict interface{}
+    onHit   interface{}
+    onMiss  interface{}
+}
+
+// evicted is an item removed from a cache, reported to its OnEvict hook once
+// the cache is unlocked.
+type evicted[K comparable, V any] struct {
+    key    K
+    value  V
+    reason EvictReason
 }
 
 // CacheStats provides statistics on cache operations.
 type CacheStats struct {
     Size    int   // Current number of items in the cache.
+    Bytes   int64 // Estimated bytes of the items, zero unless the cache tracks sizes.
     Hits    int64 // Number of successful cache retrievals.
     Misses  int64 // Number of failed cache retrievals.
     Evicted int64 // Number of items removed from the cache due to eviction.
 }
 
-// Cache represents the cache structure, embedding sync.RWMutex for thread safety.
-type Cache struct {
-    sync.RWMutex
-    items   map[CacheKey]CacheEntry
+// TypedCache is an in-memory cache of values of type V under keys of type K,
+// whose items expire after the cache's TTL or their own, evicting the items
+// closest to expiring when full. It is safe for concurrent use.
+type TypedCache[K comparable, V any] struct {
+    items   map[K]entry[V]
     maxSize int
     ttl     time.Duration
+
+    // jitter spreads expirations by up to that fraction of their TTL either
+    // way, picked with rand, see WithJitter
+    jitter float64
+    rand   func() float64
+
+    // sizer estimates the bytes of values for caches that track sizes, see
+    // WithMaxBytes; bytes is the total of the items, kept under maxBytes if set
+    sizer    func(value V) int
+    bytes    int64
+    maxBytes int64
+
+    now     func() time.Time
+    onEvict func(key K, value V, reason EvictReason)
+    onHit   func(key K)
+    onMiss  func(key K)
     ctx     context.Context
     cancel  context.CancelFunc
-}
+    // done is closed when the background cleanup exits, nil without one
+    done    chan struct{}
+    mu      sync.RWMutex
 
-// New initializes and returns a new Cache instance with the given configuration.
-func New(config Config) *Cache {
-    ctx, cancel := context.WithCancel(context.Background())
-    return &Cache{
-        RWMutex: sync.RWMutex{},
-        items:   make(map[CacheKey]CacheEntry),
-        maxSize: config.MaxSize,
-        ttl:     config.TTL,
-        ctx:     ctx,
-        cancel:  cancel,
-    }
+    // namespaces index the keys of the namespaces that have items, by
+    // prefix, see NamespaceOf
+    namespaces map[string]*namespaceState[K]
+
+    // Statistics, kept per cache so caches sharing a process report their own
+    hits    int64
+    misses  int64
+    evicted int64
 }
+
+// Cache is a cache of values of any type, which callers assert to the type
+// they stored. Prefer a TypedCache, or a View over a Cache shared with others.
+type Cache = TypedCache[CacheKey, interface{}]
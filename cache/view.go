@@ -0,0 +1,116 @@
+package cache
+
+import "time"
+
+// View is a typed view of the items of type V of a Cache shared with users
+// storing other types, e.g. the engine-wide cache. Items of other types under
+// a key read as missing rather than failing a type assertion.
+type View[V any] struct {
+    cache *Cache
+
+    // prefix is prepended to the keys of a view of a namespace, whose lookups
+    // are counted in its stats, see ViewOfNamespace
+    prefix CacheKey
+}
+
+// ViewOf returns the view of the items of type V of c.
+func ViewOf[V any](c *Cache) View[V] {
+    return View[V]{cache: c}
+}
+
+// ViewOfNamespace returns the view of the items of type V of namespace n.
+func ViewOfNamespace[V any](n Namespace) View[V] {
+    return View[V]{cache: n.cache, prefix: n.prefix}
+}
+
+// Get returns the item under key, if it is of type V.
+func (v View[V]) Get(key CacheKey) (V, bool) {
+    value, _, ok := v.GetWithExpiry(key)
+    return value, ok
+}
+
+// GetWithExpiry returns the item under key, if it is of type V, with the time
+// left until it expires, see TypedCache.GetWithExpiry.
+func (v View[V]) GetWithExpiry(key CacheKey) (V, time.Duration, bool) {
+    if value, ttl, ok := v.cache.GetWithExpiry(v.prefix + key); ok {
+        if typed, ok := value.(V); ok {
+            v.count(1, 0)
+            return typed, ttl, true
+        }
+    }
+    v.count(0, 1)
+    var zero V
+    return zero, 0, false
+}
+
+// GetMany returns the items under keys of type V, and the keys of the others,
+// in one lock acquisition, see TypedCache.GetMany.
+func (v View[V]) GetMany(keys []CacheKey) (map[CacheKey]V, []CacheKey) {
+    prefixed := keys
+    if v.prefix != "" {
+        prefixed = make([]CacheKey, len(keys))
+        for i, key := range keys {
+            prefixed[i] = v.prefix + key
+        }
+    }
+    values, _ := v.cache.GetMany(prefixed)
+
+    found := make(map[CacheKey]V, len(values))
+    var missing []CacheKey
+    for i, key := range keys {
+        if typed, ok := values[prefixed[i]].(V); ok {
+            found[key] = typed
+        } else {
+            missing = append(missing, key)
+        }
+    }
+    v.count(int64(len(found)), int64(len(missing)))
+    return found, missing
+}
+
+// count counts lookups through a view of a namespace in its stats.
+func (v View[V]) count(hits, misses int64) {
+    if v.prefix != "" {
+        v.cache.countLookups(string(v.prefix), hits, misses)
+    }
+}
+
+// SetMany adds items to the cache in one lock acquisition, see
+// TypedCache.SetMany.
+func (v View[V]) SetMany(items map[CacheKey]V) {
+    values := make(map[CacheKey]interface{}, len(items))
+    for key, value := range items {
+        values[v.prefix+key] = value
+    }
+    v.cache.SetMany(values)
+}
+
+// Set adds an item to the cache, see TypedCache.Set.
+func (v View[V]) Set(key CacheKey, value V) {
+    v.cache.Set(v.prefix+key, value)
+}
+
+// SetWithTTL adds an item to the cache that expires after ttl, 0 for the
+// cache's TTL or NoExpiry for never, see TypedCache.SetWithTTL.
+func (v View[V]) SetWithTTL(key CacheKey, value V, ttl time.Duration) {
+    v.cache.SetWithTTL(v.prefix+key, value, ttl)
+}
+
+// GetOrSet returns the item under key if it is of type V, or loads it with
+// load and caches it, see TypedCache.GetOrSet.
+func (v View[V]) GetOrSet(key CacheKey, load func() (V, error)) (V, error) {
+    if value, ok := v.Get(key); ok {
+        return value, nil
+    }
+    value, err := load()
+    if err != nil {
+        return value, err
+    }
+    v.Set(key, value)
+    return value, nil
+}
+
+// Delete removes the item under key, whatever its type.
+func (v View[V]) Delete(key CacheKey) {
+    v.cache.Delete(v.prefix + key)
+}
@@ -1,27 +1,179 @@
 package db
 
 import (
+    "database/sql"
     "fmt"
     "log"
+    "strings"
+    "time"
+
+    thorlogger "github.com/velumlabs/thor/logger"
+    "github.com/velumlabs/thor/options"
 
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
     "gorm.io/gorm/logger"
 )
 
+// DatabaseOptions configures NewDatabase.
+type DatabaseOptions struct {
+    // Apply pending migrations on connect; otherwise a database with pending
+    // migrations is rejected.
+    AutoMigrate bool
+
+    // Connection pool limits, database/sql's defaults if zero
+    MaxOpenConns    int
+    MaxIdleConns    int
+    ConnMaxLifetime time.Duration
+
+    // Cache prepared statements, see gorm.Config.PrepareStmt
+    PrepareStmt bool
+
+    // Logger receiving failed and slow queries, none if nil
+    Logger *thorlogger.Logger
+    // Duration above which queries are logged as slow,
+    // DefaultSlowQueryThreshold if zero
+    SlowQueryThreshold time.Duration
+    // GORM log level of the queries logged to Logger, following Logger's level
+    // if zero: every query at debug, otherwise failed and slow ones
+    QueryLogLevel logger.LogLevel
+
+    // GORM configuration to connect with, the default one if nil
+    GormConfig *gorm.Config
+}
+
+// DatabaseOption configures NewDatabase.
+type DatabaseOption = options.Option[DatabaseOptions]
+
+// WithoutAutoMigrate makes NewDatabase fail with a *PendingMigrationsError
+// when the schema is behind instead of migrating it, for deployments whose
+// schema changes are reviewed and applied with Migrate ahead of time.
+func WithoutAutoMigrate() DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        o.AutoMigrate = false
+        return nil
+    }
+}
+
+// WithMaxOpenConns limits the number of open connections to the database.
+func WithMaxOpenConns(n int) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        if n < 0 {
+            return fmt.Errorf("max open connections must not be negative")
+        }
+        o.MaxOpenConns = n
+        return nil
+    }
+}
+
+// WithMaxIdleConns limits the number of idle connections kept in the pool.
+func WithMaxIdleConns(n int) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        if n < 0 {
+            return fmt.Errorf("max idle connections must not be negative")
+        }
+        o.MaxIdleConns = n
+        return nil
+    }
+}
+
+// WithConnMaxLifetime closes connections once they have been open for d.
+func WithConnMaxLifetime(d time.Duration) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        if d < 0 {
+            return fmt.Errorf("connection max lifetime must not be negative")
+        }
+        o.ConnMaxLifetime = d
+        return nil
+    }
+}
+
+// WithPreparedStatements caches the prepared statements of queries on each
+// connection, saving a round trip for repeated queries.
+func WithPreparedStatements() DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        o.PrepareStmt = true
+        return nil
+    }
+}
+
+// WithQueryLogger logs failed queries, and queries slower than threshold, to
+// log, with their values elided. A zero threshold uses
+// DefaultSlowQueryThreshold. While log is at the debug level every query is
+// logged, see WithQueryLogLevel.
+func WithQueryLogger(log *thorlogger.Logger, threshold time.Duration) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        if log == nil {
+            return fmt.Errorf("query logger must not be nil")
+        }
+        if threshold < 0 {
+            return fmt.Errorf("slow query threshold must not be negative")
+        }
+        o.Logger = log
+        o.SlowQueryThreshold = threshold
+        return nil
+    }
+}
+
+// WithQueryLogLevel sets the GORM log level of the queries logged with
+// WithQueryLogger, instead of following the logger's level: logger.Info logs
+// every query at debug, logger.Warn failed and slow queries, logger.Error only
+// failed ones and logger.Silent none. The logger's level still applies.
+func WithQueryLogLevel(level logger.LogLevel) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        if level < logger.Silent || level > logger.Info {
+            return fmt.Errorf("invalid query log level %d", level)
+        }
+        o.QueryLogLevel = level
+        return nil
+    }
+}
+
+// WithGormConfig connects with a custom GORM configuration, for settings the
+// other options don't cover. The other options take precedence over its
+// Logger and PrepareStmt, and its Logger defaults to a silent one.
+func WithGormConfig(config *gorm.Config) DatabaseOption {
+    return func(o *DatabaseOptions) error {
+        o.GormConfig = config
+        return nil
+    }
+}
+
 // NewDatabase initializes a new database connection with GORM using PostgreSQL.
-// It also ensures the vector extension is enabled, checks its version, 
-// auto-migrates schemas, and creates fragment tables.
-func NewDatabase(url string) (*gorm.DB, error) {
-    db, err := gorm.Open(postgres.Open(url), &gorm.Config{
-        Logger: logger.Default.LogMode(logger.Silent),
-    })
+// By default it applies the pending schema migrations, which enable the vector
+// extension and create the model and fragment tables with their history
+// indexes and search vectors. It then checks the vector extension's version,
+// and marks the database with its partitioned fragment tables, see
+// PartitionFragmentTable.
+// Without options, connections use database/sql's pool defaults and GORM logs
+// nothing:
+//
+//	database, err := db.NewDatabase(url,
+//	    db.WithMaxOpenConns(20),
+//	    db.WithConnMaxLifetime(30*time.Minute),
+//	    db.WithQueryLogger(log, 500*time.Millisecond),
+//	)
+func NewDatabase(url string, opts ...DatabaseOption) (*gorm.DB, error) {
+    dbOpts := DatabaseOptions{
+        AutoMigrate: true,
+    }
+    if err := options.ApplyOptions(&dbOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid database options: %w", err)
+    }
+
+    db, err := gorm.Open(postgres.Open(url), gormConfig(dbOpts))
     if err != nil {
         return nil, fmt.Errorf("failed to connect to database: %w", err)
     }
+    if err := configurePool(db, dbOpts); err != nil {
+        return nil, err
+    }
 
-    // Enable vector extension if not exists
-    if err := enableVectorExtension(db); err != nil {
+    if dbOpts.AutoMigrate {
+        if err := Migrate(db, 0); err != nil {
+            return nil, err
+        }
+    } else if err := CheckMigrations(db); err != nil {
         return nil, err
     }
 
@@ -30,17 +182,54 @@ func NewDatabase(url string) (*gorm.DB, error) {
         return nil, err
     }
 
-    // Auto-migrate the schema for Actor and Session models
-    if err := autoMigrateSchemas(db); err != nil {
-        return nil, err
+    return MarkPartitionedTables(db)
+}
+
+// gormConfig returns the GORM configuration of a connection.
+func gormConfig(opts DatabaseOptions) *gorm.Config {
+    config := &gorm.Config{}
+    if opts.GormConfig != nil {
+        copied := *opts.GormConfig
+        config = &copied
     }
 
-    // Create fragment tables
-    if err := CreateFragmentTables(db); err != nil {
-        return nil, err
+    if opts.Logger != nil {
+        config.Logger = newQueryLogger(opts.Logger, opts.SlowQueryThreshold, opts.QueryLogLevel)
+    } else if config.Logger == nil {
+        config.Logger = logger.Default.LogMode(logger.Silent)
+    }
+    if opts.PrepareStmt {
+        config.PrepareStmt = true
     }
+    return config
+}
 
-    return db, nil
+// configurePool applies the connection pool limits that are set.
+func configurePool(db *gorm.DB, opts DatabaseOptions) error {
+    sqlDB, err := db.DB()
+    if err != nil {
+        return fmt.Errorf("failed to configure connection pool: %w", err)
+    }
+    if opts.MaxOpenConns > 0 {
+        sqlDB.SetMaxOpenConns(opts.MaxOpenConns)
+    }
+    if opts.MaxIdleConns > 0 {
+        sqlDB.SetMaxIdleConns(opts.MaxIdleConns)
+    }
+    if opts.ConnMaxLifetime > 0 {
+        sqlDB.SetConnMaxLifetime(opts.ConnMaxLifetime)
+    }
+    return nil
+}
+
+// Stats returns the connection pool statistics of a database, e.g. for a
+// health endpoint.
+func Stats(db *gorm.DB) (sql.DBStats, error) {
+    sqlDB, err := db.DB()
+    if err != nil {
+        return sql.DBStats{}, fmt.Errorf("failed to get connection pool: %w", err)
+    }
+    return sqlDB.Stats(), nil
 }
 
 // enableVectorExtension checks if the vector extension exists, 
@@ -62,22 +251,80 @@ func checkVectorExtensionVersion(db *gorm.DB) error {
     return nil
 }
 
-// autoMigrateSchemas handles the migration of the schema for specified models.
-func autoMigrateSchemas(db *gorm.DB) error {
-    if err := db.AutoMigrate(&Actor{}, &Session{}); err != nil {
-        return fmt.Errorf("failed to migrate schemas: %w", err)
+// CreateFragmentTables creates a table for each FragmentTable with the
+// Fragment model's columns and indexes, adding any that are missing from
+// existing tables, so it is safe to run on every start.
+func CreateFragmentTables(db *gorm.DB) error {
+    for _, table := range fragmentTables {
+        if err := db.Table(string(table)).AutoMigrate(&Fragment{}); err != nil {
+            return fmt.Errorf("failed to create %s table: %w", table, err)
+        }
     }
     return nil
 }
 
-// CreateFragmentTables creates tables for the fragments if they do not exist.
-func CreateFragmentTables(db *gorm.DB) error {
+// CreateFragmentArchiveTable creates a table with the Fragment model's columns
+// that purged fragments can be archived to, see stores.NewTableArchive. Like
+// CreateFragmentTables it is safe to run on every start.
+func CreateFragmentArchiveTable(db *gorm.DB, name string) error {
+    if name == "" || isFragmentTable(FragmentTable(name)) || name == MergedFragmentTable {
+        return fmt.Errorf("invalid archive table name %q", name)
+    }
+    if err := db.Table(name).AutoMigrate(&Fragment{}); err != nil {
+        return fmt.Errorf("failed to create %s table: %w", name, err)
+    }
+    return nil
+}
+
+// fragmentIndexedColumns are the columns every fragment table must index.
+var fragmentIndexedColumns = []string{"id", "actor_id", "session_id", "parent_id", "deleted_at"}
+
+// VerifySchema checks that the model and fragment tables exist and that the
+// fragment tables have their indexes, without changing the schema. The error
+// lists everything that is missing.
+func VerifySchema(db *gorm.DB) error {
+    var missing []string
+
+    for _, model := range []interface{}{&Actor{}, &Session{}, &SessionActor{}, &TokenUsage{}, &ScheduledResponse{}} {
+        if !db.Migrator().HasTable(model) {
+            stmt := &gorm.Statement{DB: db}
+            if err := stmt.Parse(model); err != nil {
+                return fmt.Errorf("failed to verify schema: %w", err)
+            }
+            missing = append(missing, fmt.Sprintf("table %s", stmt.Table))
+        }
+    }
+
     for _, table := range fragmentTables {
         if !db.Migrator().HasTable(string(table)) {
-            if err := db.Migrator().CreateTable(&Fragment{}, "table_name", string(table)); err != nil {
-                return fmt.Errorf("failed to create %s table: %w", table, err)
+            missing = append(missing, fmt.Sprintf("table %s", table))
+            continue
+        }
+
+        var indexed []string
+        if err := db.Raw(`
+            SELECT DISTINCT a.attname FROM pg_index i
+            JOIN pg_class t ON t.oid = i.indrelid
+            JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(i.indkey)
+            WHERE t.relname = ? AND t.relnamespace = to_regnamespace(current_schema())`,
+            string(table),
+        ).Scan(&indexed).Error; err != nil {
+            return fmt.Errorf("failed to verify indexes of %s: %w", table, err)
+        }
+
+        has := make(map[string]bool, len(indexed))
+        for _, column := range indexed {
+            has[column] = true
+        }
+        for _, column := range fragmentIndexedColumns {
+            if !has[column] {
+                missing = append(missing, fmt.Sprintf("index on %s.%s", table, column))
             }
         }
     }
+
+    if len(missing) > 0 {
+        return fmt.Errorf("database schema is incomplete, missing %s; apply the pending migrations with Migrate", strings.Join(missing, ", "))
+    }
     return nil
 }
@@ -0,0 +1,184 @@
+package db
+
+import (
+    "fmt"
+    "strings"
+
+    "gorm.io/gorm"
+)
+
+// VectorIndexType is the kind of pgvector index built on fragment embeddings.
+type VectorIndexType string
+
+const (
+    VectorIndexNone    VectorIndexType = ""        // No vector index
+    VectorIndexHNSW    VectorIndexType = "hnsw"    // Better recall and speed, slower to build
+    VectorIndexIVFFlat VectorIndexType = "ivfflat" // Faster to build, build after loading data
+)
+
+// VectorMetric is the distance a vector index serves. Searches only use an
+// index built for their metric.
+type VectorMetric string
+
+const (
+    VectorMetricCosine       VectorMetric = "cosine"
+    VectorMetricL2           VectorMetric = "l2"
+    VectorMetricInnerProduct VectorMetric = "inner_product"
+)
+
+// operatorClass returns the pgvector operator class of a metric.
+func (m VectorMetric) operatorClass() (string, error) {
+    switch m {
+    case VectorMetricCosine:
+        return "vector_cosine_ops", nil
+    case VectorMetricL2:
+        return "vector_l2_ops", nil
+    case VectorMetricInnerProduct:
+        return "vector_ip_ops", nil
+    default:
+        return "", fmt.Errorf("unknown vector metric %q", m)
+    }
+}
+
+// VectorIndexConfig describes the vector index of a fragment table. Zero
+// tuning parameters use the pgvector defaults.
+type VectorIndexConfig struct {
+    Type   VectorIndexType
+    Metric VectorMetric
+
+    // HNSW parameters
+    M              int // Connections per layer
+    EfConstruction int // Candidate list size while building
+
+    // IVFFlat parameters
+    Lists int // Number of inverted lists, e.g. rows / 1000
+}
+
+// IndexConfig configures the indexes MigrateIndexes creates.
+type IndexConfig struct {
+    // Vector index of every fragment table, unless overridden in Tables
+    Vector VectorIndexConfig
+    Tables map[FragmentTable]VectorIndexConfig
+
+    // GIN index on the metadata of every fragment table, which serves the
+    // containment and equality conditions of metadata filters
+    Metadata bool
+
+    // Build the indexes without locking the tables against writes, for live
+    // databases. Must not run inside a transaction.
+    Concurrently bool
+}
+
+// DefaultIndexConfig returns a configuration building HNSW cosine indexes,
+// the metric the stores search by default.
+func DefaultIndexConfig() IndexConfig {
+    return IndexConfig{
+        Vector: VectorIndexConfig{
+            Type:   VectorIndexHNSW,
+            Metric: VectorMetricCosine,
+        },
+        Metadata: true,
+    }
+}
+
+// MigrateIndexes creates the indexes of the fragment tables that don't exist
+// yet: a btree index on (session_id, created_at) for histories, the vector
+// index on embeddings configured for each table, and if enabled a GIN index on
+// metadata. It is idempotent, so it can
+// run at every boot or separately by operators, since building vector indexes
+// on large tables takes a while:
+//
+//	cfg := db.DefaultIndexConfig()
+//	cfg.Concurrently = true
+//	cfg.Tables = map[db.FragmentTable]db.VectorIndexConfig{
+//	    db.FragmentTableTwitter: {Type: db.VectorIndexIVFFlat, Metric: db.VectorMetricCosine, Lists: 100},
+//	}
+//	err := db.MigrateIndexes(database, cfg)
+//
+// Indexes are named after their table, kind and metric, so changing the
+// metric of a table adds an index next to the old one rather than replacing it.
+func MigrateIndexes(db *gorm.DB, cfg IndexConfig) error {
+    concurrently := ""
+    if cfg.Concurrently {
+        concurrently = "CONCURRENTLY "
+    }
+    quote := (&gorm.Statement{DB: db}).Quote
+
+    for _, table := range fragmentTables {
+        historyIndex := fmt.Sprintf("idx_%s_session_id_created_at", table)
+        if err := db.Exec(fmt.Sprintf(
+            "CREATE INDEX %sIF NOT EXISTS %s ON %s (session_id, created_at)",
+            concurrently, quote(historyIndex), quote(string(table)),
+        )).Error; err != nil {
+            return fmt.Errorf("failed to create history index of %s: %w", table, err)
+        }
+
+        if cfg.Metadata {
+            metadataIndex := fmt.Sprintf("idx_%s_metadata", table)
+            if err := db.Exec(fmt.Sprintf(
+                "CREATE INDEX %sIF NOT EXISTS %s ON %s USING gin (metadata jsonb_path_ops)",
+                concurrently, quote(metadataIndex), quote(string(table)),
+            )).Error; err != nil {
+                return fmt.Errorf("failed to create metadata index of %s: %w", table, err)
+            }
+        }
+
+        vector, ok := cfg.Tables[table]
+        if !ok {
+            vector = cfg.Vector
+        }
+        statement, err := vectorIndexStatement(table, vector, concurrently, quote)
+        if err != nil {
+            return fmt.Errorf("invalid vector index of %s: %w", table, err)
+        }
+        if statement == "" {
+            continue
+        }
+        if err := db.Exec(statement).Error; err != nil {
+            return fmt.Errorf("failed to create vector index of %s: %w", table, err)
+        }
+    }
+    return nil
+}
+
+// vectorIndexStatement returns the statement creating the vector index of a
+// table, or an empty statement if it has none.
+func vectorIndexStatement(table FragmentTable, cfg VectorIndexConfig, concurrently string, quote func(interface{}) string) (string, error) {
+    if cfg.Type == VectorIndexNone {
+        return "", nil
+    }
+    opClass, err := cfg.Metric.operatorClass()
+    if err != nil {
+        return "", err
+    }
+    if cfg.M < 0 || cfg.EfConstruction < 0 || cfg.Lists < 0 {
+        return "", fmt.Errorf("index parameters must not be negative")
+    }
+
+    var params []string
+    switch cfg.Type {
+    case VectorIndexHNSW:
+        if cfg.M > 0 {
+            params = append(params, fmt.Sprintf("m = %d", cfg.M))
+        }
+        if cfg.EfConstruction > 0 {
+            params = append(params, fmt.Sprintf("ef_construction = %d", cfg.EfConstruction))
+        }
+    case VectorIndexIVFFlat:
+        if cfg.Lists > 0 {
+            params = append(params, fmt.Sprintf("lists = %d", cfg.Lists))
+        }
+    default:
+        return "", fmt.Errorf("unknown vector index type %q", cfg.Type)
+    }
+
+    name := fmt.Sprintf("idx_%s_embedding_%s_%s", table, cfg.Type, cfg.Metric)
+    statement := fmt.Sprintf(
+        "CREATE INDEX %sIF NOT EXISTS %s ON %s USING %s (embedding %s)",
+        concurrently, quote(name), quote(string(table)), cfg.Type, opClass,
+    )
+    if len(params) > 0 {
+        statement += " WITH (" + strings.Join(params, ", ") + ")"
+    }
+    return statement, nil
+}
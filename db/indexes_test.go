@@ -0,0 +1,84 @@
+package db
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// dryRunIndexes returns the statements MigrateIndexes runs for cfg, without a
+// database
+func dryRunIndexes(t *testing.T, cfg IndexConfig) []string {
+    t.Helper()
+
+    recorder := &recordingLogger{Interface: logger.Discard}
+    database, err := gorm.Open(postgres.New(postgres.Config{}), &gorm.Config{
+        DryRun:                 true,
+        DisableAutomaticPing:   true,
+        SkipDefaultTransaction: true,
+        Logger:                 recorder,
+    })
+    if err != nil {
+        t.Fatalf("failed to open dry-run database: %v", err)
+    }
+    if err := MigrateIndexes(database, cfg); err != nil {
+        t.Fatal(err)
+    }
+    return recorder.statements
+}
+
+func TestMigrateIndexesMetadata(t *testing.T) {
+    noVector := func(cfg IndexConfig) IndexConfig {
+        cfg.Vector = VectorIndexConfig{}
+        return cfg
+    }
+    tests := []struct {
+        name string
+        cfg  IndexConfig
+        // want is the metadata index statement of each table, empty for none
+        want string
+    }{
+        {
+            name: "default",
+            cfg:  noVector(DefaultIndexConfig()),
+            want: `CREATE INDEX IF NOT EXISTS "idx_%[1]s_metadata" ON "%[1]s" USING gin (metadata jsonb_path_ops)`,
+        },
+        {
+            name: "concurrently",
+            cfg:  noVector(IndexConfig{Metadata: true, Concurrently: true}),
+            want: `CREATE INDEX CONCURRENTLY IF NOT EXISTS "idx_%[1]s_metadata" ON "%[1]s" USING gin (metadata jsonb_path_ops)`,
+        },
+        {name: "disabled", cfg: IndexConfig{}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            statements := dryRunIndexes(t, tt.cfg)
+            var metadata []string
+            for _, statement := range statements {
+                if strings.Contains(statement, "_metadata") {
+                    metadata = append(metadata, statement)
+                }
+            }
+
+            if tt.want == "" {
+                if len(metadata) != 0 {
+                    t.Errorf("metadata indexes %q were created while disabled", metadata)
+                }
+                return
+            }
+            if len(metadata) != len(fragmentTables) {
+                t.Fatalf("metadata indexes = %q, want one per fragment table", metadata)
+            }
+            for i, table := range fragmentTables {
+                if want := fmt.Sprintf(tt.want, table); metadata[i] != want {
+                    t.Errorf("metadata index of %s = %q, want %q", table, metadata[i], want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+    "context"
+    "errors"
+    "regexp"
+    "time"
+
+    "github.com/velumlabs/thor/logger"
+
+    "github.com/sirupsen/logrus"
+    "gorm.io/gorm"
+    gormlogger "gorm.io/gorm/logger"
+)
+
+// DefaultSlowQueryThreshold is the duration above which queries are logged as
+// slow when WithQueryLogger is given no threshold.
+const DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+// queryLogger routes gorm's logs to a thor logger, tagged component=db: failed
+// queries as errors, slow queries as warnings, and at the info level every
+// query at debug. Queries are logged with their values elided, since they hold
+// message contents and embeddings.
+type queryLogger struct {
+    log           *logger.Logger
+    slowThreshold time.Duration
+
+    // level is the gorm log level, or zero to follow the level of log at the
+    // time each query runs, see effectiveLevel
+    level gormlogger.LogLevel
+}
+
+func newQueryLogger(log *logger.Logger, slowThreshold time.Duration, level gormlogger.LogLevel) *queryLogger {
+    if slowThreshold <= 0 {
+        slowThreshold = DefaultSlowQueryThreshold
+    }
+    return &queryLogger{
+        log:           log.WithComponent("db"),
+        slowThreshold: slowThreshold,
+        level:         level,
+    }
+}
+
+func (l *queryLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+    copied := *l
+    copied.level = level
+    return &copied
+}
+
+// effectiveLevel returns the gorm log level of the logger. Unless set, it
+// follows the thor logger, so lowering its level to debug at runtime logs
+// every query.
+func (l *queryLogger) effectiveLevel() gormlogger.LogLevel {
+    if l.level != 0 {
+        return l.level
+    }
+    if l.log.IsLevelEnabled(logrus.DebugLevel) {
+        return gormlogger.Info
+    }
+    return gormlogger.Warn
+}
+
+func (l *queryLogger) Info(_ context.Context, msg string, args ...interface{}) {
+    if l.effectiveLevel() >= gormlogger.Info {
+        l.log.Infof(msg, args...)
+    }
+}
+
+func (l *queryLogger) Warn(_ context.Context, msg string, args ...interface{}) {
+    if l.effectiveLevel() >= gormlogger.Warn {
+        l.log.Warnf(msg, args...)
+    }
+}
+
+func (l *queryLogger) Error(_ context.Context, msg string, args ...interface{}) {
+    if l.effectiveLevel() >= gormlogger.Error {
+        l.log.Errorf(msg, args...)
+    }
+}
+
+// ParamsFilter drops the values of queries, so the SQL gorm passes to Trace
+// keeps its placeholders, see querySQL.
+func (l *queryLogger) ParamsFilter(_ context.Context, sql string, _ ...interface{}) (string, []interface{}) {
+    return sql, nil
+}
+
+// elidedPlaceholder matches the placeholders gorm marks, as $1$, when it
+// explains a query without its values
+var elidedPlaceholder = regexp.MustCompile(`\$(\d+)\$`)
+
+// querySQL returns the SQL and affected rows of a traced query, with its
+// placeholders restored
+func querySQL(fc func() (string, int64)) (string, int64) {
+    sql, rows := fc()
+    return elidedPlaceholder.ReplaceAllString(sql, "$$$1"), rows
+}
+
+// Trace logs a query once it has run. Missing records aren't failures, the
+// stores report them as ErrNotFound. The SQL is only built for queries the
+// thor logger's level lets through.
+func (l *queryLogger) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+    level := l.effectiveLevel()
+    if level <= gormlogger.Silent {
+        return
+    }
+
+    elapsed := time.Since(begin)
+    switch {
+    case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+        if level < gormlogger.Error || !l.log.IsLevelEnabled(logrus.ErrorLevel) {
+            return
+        }
+        sql, rows := querySQL(fc)
+        l.log.WithFields(map[string]interface{}{
+            "elapsed": elapsed,
+            "rows":    rows,
+        }).Errorf("query failed: %v: %s", err, sql)
+    case elapsed > l.slowThreshold:
+        if level < gormlogger.Warn || !l.log.IsLevelEnabled(logrus.WarnLevel) {
+            return
+        }
+        sql, rows := querySQL(fc)
+        l.log.WithFields(map[string]interface{}{
+            "elapsed":   elapsed,
+            "threshold": l.slowThreshold,
+            "rows":      rows,
+        }).Warnf("slow query: %s", sql)
+    case level >= gormlogger.Info && l.log.IsLevelEnabled(logrus.DebugLevel):
+        sql, rows := querySQL(fc)
+        l.log.WithFields(map[string]interface{}{
+            "elapsed": elapsed,
+            "rows":    rows,
+        }).Debugf("query: %s", sql)
+    }
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    thorlogger "github.com/velumlabs/thor/logger"
+
+    "github.com/sirupsen/logrus"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// logBuffer is a goroutine safe buffer of JSON log lines
+type logBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+// entries returns the entries logged so far
+func (b *logBuffer) entries(t *testing.T) []map[string]interface{} {
+    t.Helper()
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    var entries []map[string]interface{}
+    for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+        if line == "" {
+            continue
+        }
+        var entry map[string]interface{}
+        if err := json.Unmarshal([]byte(line), &entry); err != nil {
+            t.Fatalf("invalid log line %q: %v", line, err)
+        }
+        entries = append(entries, entry)
+    }
+    return entries
+}
+
+// newCapturingLogger returns a thor logger at level writing JSON to out
+func newCapturingLogger(t *testing.T, level string, out *logBuffer) *thorlogger.Logger {
+    t.Helper()
+    log, err := thorlogger.New(&thorlogger.Config{Level: level, JSONFormat: true, Output: out})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return log
+}
+
+func TestQueryLoggerTrace(t *testing.T) {
+    const sql = "SELECT * FROM actors WHERE name = $1$ AND id = $2$"
+    tests := []struct {
+        name     string
+        logLevel string
+        level    logger.LogLevel
+        elapsed  time.Duration
+        err      error
+        // want is the level and message logged, none if empty
+        wantLevel string
+        want      string
+    }{
+        {name: "failed", logLevel: "info", err: errors.New("connection reset"), wantLevel: "error",
+            want: "query failed: connection reset: SELECT * FROM actors WHERE name = $1 AND id = $2"},
+        {name: "record not found", logLevel: "debug", err: gorm.ErrRecordNotFound, wantLevel: "debug",
+            want: "query: SELECT * FROM actors WHERE name = $1 AND id = $2"},
+        {name: "slow", logLevel: "info", elapsed: time.Second, wantLevel: "warning",
+            want: "slow query: SELECT * FROM actors WHERE name = $1 AND id = $2"},
+        {name: "fast", logLevel: "info"},
+        {name: "fast at debug", logLevel: "debug", wantLevel: "debug",
+            want: "query: SELECT * FROM actors WHERE name = $1 AND id = $2"},
+        {name: "slow below the logger level", logLevel: "error", elapsed: time.Second},
+        {name: "errors only", logLevel: "info", level: logger.Error, elapsed: time.Second},
+        {name: "errors only failed", logLevel: "info", level: logger.Error, err: errors.New("syntax error"), wantLevel: "error",
+            want: "query failed: syntax error: SELECT * FROM actors WHERE name = $1 AND id = $2"},
+        {name: "silent", logLevel: "debug", level: logger.Silent, err: errors.New("syntax error")},
+        // The GORM level doesn't lower the logger's
+        {name: "every query below the logger level", logLevel: "info", level: logger.Info},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            out := &logBuffer{}
+            l := newQueryLogger(newCapturingLogger(t, tt.logLevel, out), 0, 0)
+            if tt.level != 0 {
+                l = l.LogMode(tt.level).(*queryLogger)
+            }
+
+            built := false
+            l.Trace(context.Background(), time.Now().Add(-tt.elapsed), func() (string, int64) {
+                built = true
+                return sql, 3
+            }, tt.err)
+
+            entries := out.entries(t)
+            if tt.want == "" {
+                if len(entries) != 0 {
+                    t.Errorf("logged %v, want nothing", entries)
+                }
+                if built {
+                    t.Error("the SQL was built for a query that isn't logged")
+                }
+                return
+            }
+            if len(entries) != 1 {
+                t.Fatalf("logged %v, want one entry", entries)
+            }
+            entry := entries[0]
+            if entry["level"] != tt.wantLevel || entry["msg"] != tt.want {
+                t.Errorf("logged %v %q, want %s %q", entry["level"], entry["msg"], tt.wantLevel, tt.want)
+            }
+            if entry["component"] != "db" || entry["rows"] != 3.0 {
+                t.Errorf("entry fields %v, want component db and 3 rows", entry)
+            }
+        })
+    }
+}
+
+func TestQueryLoggerFollowsLevel(t *testing.T) {
+    out := &logBuffer{}
+    log := newCapturingLogger(t, "info", out)
+    l := newQueryLogger(log, time.Hour, 0)
+    trace := func() {
+        l.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+    }
+
+    trace()
+    if err := log.SetLevel("", logrus.DebugLevel); err != nil {
+        t.Fatal(err)
+    }
+    trace()
+    if err := log.SetLevel("", logrus.InfoLevel); err != nil {
+        t.Fatal(err)
+    }
+    trace()
+
+    if entries := out.entries(t); len(entries) != 1 || entries[0]["msg"] != "query: SELECT 1" {
+        t.Errorf("logged %v, want the query run at debug only", entries)
+    }
+}
+
+func TestWithQueryLogLevel(t *testing.T) {
+    tests := []struct {
+        level logger.LogLevel
+        valid bool
+    }{
+        {level: logger.Silent, valid: true},
+        {level: logger.Info, valid: true},
+        {level: logger.Silent - 1},
+        {level: logger.Info + 1},
+    }
+
+    for _, tt := range tests {
+        var opts DatabaseOptions
+        if err := WithQueryLogLevel(tt.level)(&opts); (err == nil) != tt.valid {
+            t.Errorf("WithQueryLogLevel(%d) = %v, want valid %v", tt.level, err, tt.valid)
+        }
+    }
+}
+
+// TestSlowQueryLogged runs a query slowed down by a callback through the
+// configuration of NewDatabase, and checks it is logged without its values
+func TestSlowQueryLogged(t *testing.T) {
+    out := &logBuffer{}
+    var opts DatabaseOptions
+    if err := WithQueryLogger(newCapturingLogger(t, "info", out), time.Millisecond)(&opts); err != nil {
+        t.Fatal(err)
+    }
+    config := gormConfig(opts)
+    config.DryRun = true
+    config.DisableAutomaticPing = true
+    database, err := gorm.Open(postgres.New(postgres.Config{}), config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := database.Callback().Query().Before("gorm:query").Register("test:slow", func(*gorm.DB) {
+        time.Sleep(5 * time.Millisecond)
+    }); err != nil {
+        t.Fatal(err)
+    }
+
+    var actors []Actor
+    if err := database.Where("name = ?", "secret name").Find(&actors).Error; err != nil {
+        t.Fatal(err)
+    }
+    checkSlowQuery(t, out, "secret name")
+}
+
+// checkSlowQuery checks that out holds one slow query warning, without secret
+func checkSlowQuery(t *testing.T, out *logBuffer, secret string) {
+    t.Helper()
+    var slow []map[string]interface{}
+    for _, entry := range out.entries(t) {
+        if strings.HasPrefix(entry["msg"].(string), "slow query: ") {
+            slow = append(slow, entry)
+        }
+    }
+    if len(slow) != 1 {
+        t.Fatalf("slow queries logged = %v, want one", slow)
+    }
+    entry := slow[0]
+    if msg := entry["msg"].(string); strings.Contains(msg, secret) || !strings.Contains(msg, "$1") {
+        t.Errorf("slow query %q, want its values elided", msg)
+    }
+    if entry["level"] != "warning" || entry["component"] != "db" || entry["threshold"] == nil {
+        t.Errorf("slow query entry %v, want a db warning with its threshold", entry)
+    }
+}
+
+// TestSlowQueryLoggedLive runs pg_sleep against the database named by
+// THOR_TEST_DATABASE_URL
+func TestSlowQueryLoggedLive(t *testing.T) {
+    url := os.Getenv(testDatabaseURLEnv)
+    if url == "" {
+        t.Skipf("%s is not set", testDatabaseURLEnv)
+    }
+    out := &logBuffer{}
+    database, err := gorm.Open(postgres.Open(url), gormConfig(DatabaseOptions{
+        Logger:             newCapturingLogger(t, "info", out),
+        SlowQueryThreshold: 10 * time.Millisecond,
+    }))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var slept string
+    if err := database.Raw("SELECT pg_sleep(0.05)::text WHERE ? <> ''", "secret value").Scan(&slept).Error; err != nil {
+        t.Fatal(err)
+    }
+    checkSlowQuery(t, out, "secret value")
+}
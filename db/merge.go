@@ -0,0 +1,121 @@
+package db
+
+import (
+    "fmt"
+
+    "gorm.io/gorm"
+)
+
+// MergedFragmentTable is the table fragments of every type were stored in
+// before each FragmentTable got a table of its own.
+const MergedFragmentTable = "fragments"
+
+// fragmentColumns are the columns moved out of the merged table. Search
+// vectors are rebuilt by the destination table's trigger.
+const fragmentColumns = "id, actor_id, session_id, content, metadata, embedding, parent_id, created_at, updated_at, deleted_at"
+
+// FragmentRoute selects the rows of the merged table that belong to a
+// fragment table.
+type FragmentRoute struct {
+    Table FragmentTable
+    // SQL condition on the merged table's columns, e.g.
+    // "metadata->>'type' = 'session_summary'". Empty selects all rows left.
+    // Written into the statement as is, so it must not contain placeholders.
+    Condition string
+}
+
+// SplitFragmentsConfig configures SplitMergedFragments.
+type SplitFragmentsConfig struct {
+    // Table to move fragments out of, MergedFragmentTable if empty.
+    Source string
+    // Routes in order of precedence: a row goes to the first route whose
+    // condition it matches. Rows no route selects stay in the source table.
+    Routes []FragmentRoute
+    // Rows moved per statement, 1000 if zero.
+    BatchSize int
+}
+
+// SplitMergedFragments moves the fragments of the merged table into their
+// fragment tables, for databases migrated before each FragmentTable had a
+// table of its own. The fragment tables must exist, see CreateFragmentTables.
+// Rows are moved in batches, each deleted from the source and inserted into
+// its table in one statement, so the split can be interrupted and run again.
+// Rows whose ID is already in their table are left in the source for review.
+// It returns the number of rows moved to each table.
+//
+//	moved, err := db.SplitMergedFragments(database, db.SplitFragmentsConfig{
+//	    Routes: []db.FragmentRoute{
+//	        {Table: db.FragmentTableInsight, Condition: "metadata->>'confidence' IS NOT NULL"},
+//	        {Table: db.FragmentTableInteraction},
+//	    },
+//	})
+func SplitMergedFragments(db *gorm.DB, cfg SplitFragmentsConfig) (map[FragmentTable]int64, error) {
+    source := cfg.Source
+    if source == "" {
+        source = MergedFragmentTable
+    }
+    batchSize := cfg.BatchSize
+    if batchSize <= 0 {
+        batchSize = defaultBackfillBatchSize
+    }
+
+    moved := make(map[FragmentTable]int64)
+    if !db.Migrator().HasTable(source) {
+        return moved, nil
+    }
+
+    stmt := &gorm.Statement{DB: db}
+    // Rows matching an earlier route but left behind stay in the source
+    var earlier string
+    for _, route := range cfg.Routes {
+        if !isFragmentTable(route.Table) {
+            return moved, fmt.Errorf("unknown fragment table %q", route.Table)
+        }
+        if string(route.Table) == source {
+            return moved, fmt.Errorf("cannot move fragments of %s into itself", source)
+        }
+
+        condition := "TRUE"
+        if route.Condition != "" {
+            condition = "(" + route.Condition + ")"
+        }
+        selected := condition + earlier
+        earlier += " AND " + condition + " IS NOT TRUE"
+        from, to := stmt.Quote(source), stmt.Quote(string(route.Table))
+
+        for {
+            result := db.Exec(`
+                WITH moved AS (
+                    DELETE FROM `+from+` WHERE id IN (
+                        SELECT s.id FROM `+from+` s
+                        WHERE `+selected+`
+                        AND NOT EXISTS (SELECT 1 FROM `+to+` t WHERE t.id = s.id)
+                        LIMIT ?
+                    )
+                    RETURNING `+fragmentColumns+`
+                )
+                INSERT INTO `+to+` (`+fragmentColumns+`)
+                SELECT `+fragmentColumns+` FROM moved`,
+                batchSize,
+            )
+            if result.Error != nil {
+                return moved, fmt.Errorf("failed to move fragments to %s: %w", route.Table, result.Error)
+            }
+            moved[route.Table] += result.RowsAffected
+            if result.RowsAffected < int64(batchSize) {
+                break
+            }
+        }
+    }
+    return moved, nil
+}
+
+// isFragmentTable reports whether table is one of the fragment tables.
+func isFragmentTable(table FragmentTable) bool {
+    for _, t := range fragmentTables {
+        if t == table {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,445 @@
+package db
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// migrationLockKey is the advisory lock held while a migration runs, so
+// instances booting together apply each migration once.
+const migrationLockKey = 7243901
+
+// ErrPendingMigrations is matched by the PendingMigrationsError of a database
+// whose schema is behind.
+var ErrPendingMigrations = errors.New("pending migrations")
+
+// PendingMigrationsError reports a schema missing migrations.
+type PendingMigrationsError struct {
+    Pending []Migration
+}
+
+func (e *PendingMigrationsError) Error() string {
+    return fmt.Sprintf("pending migrations: %d", len(e.Pending))
+}
+
+func (e *PendingMigrationsError) Is(target error) bool {
+    return target == ErrPendingMigrations
+}
+
+// Migration is a numbered schema change. Up runs in a transaction with the
+// record of the migration, so it is applied completely or not at all.
+type Migration struct {
+    Version int
+    Name    string
+    Up      func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+    Version   int       `gorm:"primaryKey;autoIncrement:false"`
+    Name      string    `gorm:"type:varchar(255);not null"`
+    AppliedAt time.Time `gorm:"not null"`
+}
+
+// SchemaStatus reports the migrations applied to a database and those pending.
+type SchemaStatus struct {
+    Current int // Version of the last applied migration, 0 if none
+    Latest  int // Version of the last known migration
+    Applied []SchemaMigration
+    Pending []Migration
+}
+
+// migrations are the schema changes of the package, in order. Every change to
+// the schema is appended here as a new migration; applied migrations are never
+// edited. Migrations spell out their DDL rather than migrating the models, so
+// they keep creating the schema of their version as the models change. They
+// are idempotent, so databases created before migrations were tracked adopt
+// the tracking on their first Migrate.
+var migrations = []Migration{
+    {Version: 1, Name: "enable_vector_extension", Up: enableVectorExtension},
+    {Version: 2, Name: "create_model_tables", Up: createModelTables},
+    {Version: 3, Name: "create_fragment_tables", Up: createFragmentTables},
+    {Version: 4, Name: "create_history_indexes", Up: func(tx *gorm.DB) error {
+        return MigrateIndexes(tx, IndexConfig{})
+    }},
+    {Version: 5, Name: "add_text_search", Up: func(tx *gorm.DB) error {
+        return MigrateTextSearch(tx, TextSearchConfig{})
+    }},
+    {Version: 6, Name: "add_actor_metadata", Up: addActorMetadata},
+    {Version: 7, Name: "create_session_actors", Up: createSessionActors},
+    {Version: 8, Name: "add_tenant_ids", Up: addTenantIDs},
+    {Version: 9, Name: "create_tool_calls", Up: createToolCalls},
+    {Version: 10, Name: "create_token_usages", Up: createTokenUsages},
+    {Version: 11, Name: "add_session_details", Up: addSessionDetails},
+    {Version: 12, Name: "create_scheduled_responses", Up: createScheduledResponses},
+    {Version: 13, Name: "add_fragment_parents", Up: addFragmentParents},
+    {Version: 14, Name: "add_token_usage_tenants", Up: addTokenUsageTenants},
+}
+
+// execAll runs statements in order, naming what failed after what.
+func execAll(tx *gorm.DB, what string, statements ...string) error {
+    for _, statement := range statements {
+        if err := tx.Exec(statement).Error; err != nil {
+            return fmt.Errorf("failed to %s: %w", what, err)
+        }
+    }
+    return nil
+}
+
+// createModelTables creates the actors and sessions tables as they were before
+// migrations were tracked.
+func createModelTables(tx *gorm.DB) error {
+    return execAll(tx, "create model tables",
+        `CREATE TABLE IF NOT EXISTS "actors" (
+            "id" uuid NOT NULL,
+            "name" varchar(255) NOT NULL,
+            "assistant" boolean NOT NULL DEFAULT false,
+            "created_at" timestamptz,
+            "updated_at" timestamptz,
+            "deleted_at" timestamptz,
+            PRIMARY KEY ("id")
+        )`,
+        `CREATE INDEX IF NOT EXISTS "idx_actors_deleted_at" ON "actors" ("deleted_at")`,
+        `CREATE TABLE IF NOT EXISTS "sessions" (
+            "id" uuid NOT NULL DEFAULT gen_random_uuid(),
+            "created_at" timestamptz,
+            "updated_at" timestamptz,
+            "deleted_at" timestamptz,
+            PRIMARY KEY ("id")
+        )`,
+        `CREATE INDEX IF NOT EXISTS "idx_sessions_deleted_at" ON "sessions" ("deleted_at")`,
+    )
+}
+
+// createFragmentTables creates a table for each FragmentTable with the columns
+// fragments had when migrations started to be tracked.
+func createFragmentTables(tx *gorm.DB) error {
+    quote := (&gorm.Statement{DB: tx}).Quote
+
+    for _, table := range fragmentTables {
+        name := quote(string(table))
+        index := func(column string) string {
+            return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+                quote(fmt.Sprintf("idx_%s_%s", table, column)), name, quote(column))
+        }
+        if err := execAll(tx, fmt.Sprintf("create %s table", table),
+            fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+                "id" uuid NOT NULL,
+                "actor_id" uuid NOT NULL,
+                "session_id" uuid NOT NULL,
+                "content" text NOT NULL,
+                "metadata" jsonb NOT NULL DEFAULT '{}'::jsonb,
+                "embedding" vector(1536),
+                "created_at" timestamptz,
+                "updated_at" timestamptz,
+                "deleted_at" timestamptz,
+                PRIMARY KEY ("id"),
+                CONSTRAINT %s FOREIGN KEY ("actor_id") REFERENCES "actors" ("id"),
+                CONSTRAINT %s FOREIGN KEY ("session_id") REFERENCES "sessions" ("id")
+            )`, name, quote(fmt.Sprintf("fk_%s_actor", table)), quote(fmt.Sprintf("fk_%s_session", table))),
+            index("actor_id"),
+            index("session_id"),
+            index("deleted_at"),
+        ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// addActorMetadata adds the metadata column of actors, which tables created by
+// an earlier version of the model lack, and indexes it for containment queries.
+func addActorMetadata(tx *gorm.DB) error {
+    if err := tx.Exec("ALTER TABLE actors ADD COLUMN IF NOT EXISTS metadata jsonb NOT NULL DEFAULT '{}'::jsonb").Error; err != nil {
+        return fmt.Errorf("failed to add actor metadata: %w", err)
+    }
+    if err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_actors_metadata ON actors USING gin (metadata jsonb_path_ops)").Error; err != nil {
+        return fmt.Errorf("failed to index actor metadata: %w", err)
+    }
+    return nil
+}
+
+// createSessionActors creates the participants table and fills it from the
+// interactions already stored.
+func createSessionActors(tx *gorm.DB) error {
+    if err := execAll(tx, "create session actors",
+        `CREATE TABLE IF NOT EXISTS "session_actors" (
+            "session_id" uuid NOT NULL,
+            "actor_id" uuid NOT NULL,
+            "first_seen_at" timestamptz NOT NULL,
+            "last_seen_at" timestamptz NOT NULL,
+            PRIMARY KEY ("session_id", "actor_id"),
+            CONSTRAINT "fk_session_actors_session" FOREIGN KEY ("session_id") REFERENCES "sessions" ("id"),
+            CONSTRAINT "fk_session_actors_actor" FOREIGN KEY ("actor_id") REFERENCES "actors" ("id")
+        )`,
+        `CREATE INDEX IF NOT EXISTS "idx_session_actors_actor_id" ON "session_actors" ("actor_id")`,
+        `CREATE INDEX IF NOT EXISTS "idx_session_actors_last_seen_at" ON "session_actors" ("last_seen_at")`,
+    ); err != nil {
+        return err
+    }
+    interactions := (&gorm.Statement{DB: tx}).Quote(string(FragmentTableInteraction))
+    if err := tx.Exec(`
+        INSERT INTO session_actors (session_id, actor_id, first_seen_at, last_seen_at)
+        SELECT f.session_id, f.actor_id, MIN(f.created_at), MAX(f.created_at)
+        FROM `+interactions+` f
+        WHERE f.deleted_at IS NULL
+        AND EXISTS (SELECT 1 FROM sessions s WHERE s.id = f.session_id)
+        AND EXISTS (SELECT 1 FROM actors a WHERE a.id = f.actor_id)
+        GROUP BY f.session_id, f.actor_id
+        ON CONFLICT DO NOTHING`,
+    ).Error; err != nil {
+        return fmt.Errorf("failed to backfill session actors: %w", err)
+    }
+    return nil
+}
+
+// addTenantIDs adds the nullable tenant column of actors, sessions and
+// fragments, with indexes leading with it for the queries of scoped stores.
+func addTenantIDs(tx *gorm.DB) error {
+    quote := (&gorm.Statement{DB: tx}).Quote
+
+    tables := []string{"actors", "sessions"}
+    for _, table := range fragmentTables {
+        tables = append(tables, string(table))
+    }
+    for _, table := range tables {
+        if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS tenant_id uuid", quote(table))).Error; err != nil {
+            return fmt.Errorf("failed to add tenant ID to %s: %w", table, err)
+        }
+
+        // Fragments are read by session, actors and sessions listed by age
+        columns := "tenant_id, session_id, created_at"
+        switch table {
+        case "actors":
+            columns = "tenant_id, created_at"
+        case "sessions":
+            columns = "tenant_id, last_activity_at"
+        }
+        index := fmt.Sprintf("idx_%s_tenant_id", table)
+        if err := tx.Exec(fmt.Sprintf(
+            "CREATE INDEX IF NOT EXISTS %s ON %s (%s)",
+            quote(index), quote(table), columns,
+        )).Error; err != nil {
+            return fmt.Errorf("failed to index tenant ID of %s: %w", table, err)
+        }
+    }
+    return nil
+}
+
+// createToolCalls creates the table of tool call records, indexed by session
+// and tool name.
+func createToolCalls(tx *gorm.DB) error {
+    return execAll(tx, "create tool calls",
+        `CREATE TABLE IF NOT EXISTS "tool_calls" (
+            "id" uuid NOT NULL,
+            "session_id" uuid NOT NULL,
+            "fragment_id" uuid,
+            "name" varchar(255) NOT NULL,
+            "arguments" jsonb NOT NULL DEFAULT '{}'::jsonb,
+            "result" text NOT NULL DEFAULT '',
+            "result_truncated" boolean NOT NULL DEFAULT false,
+            "error" text NOT NULL DEFAULT '',
+            "duration" bigint NOT NULL DEFAULT 0,
+            "created_at" timestamptz,
+            PRIMARY KEY ("id")
+        )`,
+        `CREATE INDEX IF NOT EXISTS "idx_tool_calls_session_id" ON "tool_calls" ("session_id", "created_at")`,
+        `CREATE INDEX IF NOT EXISTS "idx_tool_calls_name" ON "tool_calls" ("name", "created_at")`,
+        `CREATE INDEX IF NOT EXISTS "idx_tool_calls_fragment_id" ON "tool_calls" ("fragment_id")`,
+    )
+}
+
+// createTokenUsages creates the table of the token usage of budget scopes.
+func createTokenUsages(tx *gorm.DB) error {
+    return execAll(tx, "create token usages",
+        `CREATE TABLE IF NOT EXISTS "token_usages" (
+            "scope" varchar(128) NOT NULL,
+            "prompt_tokens" bigint NOT NULL DEFAULT 0,
+            "completion_tokens" bigint NOT NULL DEFAULT 0,
+            "total_tokens" bigint NOT NULL DEFAULT 0,
+            "paused" boolean NOT NULL DEFAULT false,
+            "created_at" timestamptz,
+            "updated_at" timestamptz,
+            PRIMARY KEY ("scope")
+        )`,
+    )
+}
+
+// addSessionDetails adds the title, metadata, last activity and closing time
+// of sessions.
+func addSessionDetails(tx *gorm.DB) error {
+    return execAll(tx, "add session details",
+        `ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "title" varchar(255) NOT NULL DEFAULT ''`,
+        `ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "metadata" jsonb NOT NULL DEFAULT '{}'::jsonb`,
+        `ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "last_activity_at" timestamptz`,
+        `ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "closed_at" timestamptz`,
+        `CREATE INDEX IF NOT EXISTS "idx_sessions_last_activity_at" ON "sessions" ("last_activity_at")`,
+    )
+}
+
+// createScheduledResponses creates the table of the responses scheduled for
+// later, indexed for the scheduler's claims.
+func createScheduledResponses(tx *gorm.DB) error {
+    return execAll(tx, "create scheduled responses",
+        `CREATE TABLE IF NOT EXISTS "scheduled_responses" (
+            "id" uuid NOT NULL,
+            "session_id" uuid NOT NULL,
+            "assistant_id" uuid NOT NULL,
+            "instruction" text NOT NULL DEFAULT '',
+            "data" jsonb NOT NULL DEFAULT '{}'::jsonb,
+            "run_at" timestamptz NOT NULL,
+            "status" varchar(16) NOT NULL,
+            "claimed_at" timestamptz,
+            "error" text NOT NULL DEFAULT '',
+            "created_at" timestamptz,
+            "updated_at" timestamptz,
+            PRIMARY KEY ("id")
+        )`,
+        `CREATE INDEX IF NOT EXISTS "idx_scheduled_responses_session_id" ON "scheduled_responses" ("session_id")`,
+        `CREATE INDEX IF NOT EXISTS "idx_scheduled_responses_run_at" ON "scheduled_responses" ("run_at")`,
+        `CREATE INDEX IF NOT EXISTS "idx_scheduled_responses_status" ON "scheduled_responses" ("status")`,
+    )
+}
+
+// addFragmentParents adds the indexed parent link of fragments.
+func addFragmentParents(tx *gorm.DB) error {
+    quote := (&gorm.Statement{DB: tx}).Quote
+
+    for _, table := range fragmentTables {
+        if err := execAll(tx, fmt.Sprintf("add parents of %s", table),
+            fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS "parent_id" uuid`, quote(string(table))),
+            fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s ("parent_id")`,
+                quote(fmt.Sprintf("idx_%s_parent_id", table)), quote(string(table))),
+        ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// addTokenUsageTenants adds the tenant of token usage, so tenants keep their
+// own daily budgets.
+func addTokenUsageTenants(tx *gorm.DB) error {
+    return execAll(tx, "add token usage tenants",
+        `ALTER TABLE "token_usages" ADD COLUMN IF NOT EXISTS "tenant_id" uuid`,
+        `CREATE INDEX IF NOT EXISTS "idx_token_usages_tenant_id" ON "token_usages" ("tenant_id")`,
+    )
+}
+
+// Migrations returns the schema migrations of the package, in order.
+func Migrations() []Migration {
+    return append([]Migration(nil), migrations...)
+}
+
+// LatestMigration returns the version of the last schema migration.
+func LatestMigration() int {
+    return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies the pending migrations up to and including version target,
+// or all of them if target is 0, recording each in the schema_migrations
+// table. Migrations are never reverted, so a target below the applied version
+// is an error.
+func Migrate(db *gorm.DB, target int) error {
+    if target == 0 {
+        target = LatestMigration()
+    }
+    if target < 0 || target > LatestMigration() {
+        return fmt.Errorf("unknown migration version %d", target)
+    }
+
+    if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+        return fmt.Errorf("failed to create migrations table: %w", err)
+    }
+
+    status, err := MigrationStatus(db)
+    if err != nil {
+        return err
+    }
+    if status.Current > target {
+        return fmt.Errorf("cannot migrate down from version %d to %d", status.Current, target)
+    }
+
+    for _, migration := range status.Pending {
+        if migration.Version > target {
+            break
+        }
+        if err := applyMigration(db, migration); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// applyMigration runs a migration and records it in one transaction, unless
+// another instance applied it while the lock was awaited.
+func applyMigration(db *gorm.DB, migration Migration) error {
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockKey).Error; err != nil {
+            return err
+        }
+
+        var applied int64
+        if err := tx.Model(&SchemaMigration{}).Where("version = ?", migration.Version).Count(&applied).Error; err != nil {
+            return err
+        }
+        if applied > 0 {
+            return nil
+        }
+
+        if err := migration.Up(tx); err != nil {
+            return err
+        }
+        return tx.Create(&SchemaMigration{
+            Version:   migration.Version,
+            Name:      migration.Name,
+            AppliedAt: time.Now(),
+        }).Error
+    })
+    if err != nil {
+        return fmt.Errorf("failed to apply migration %d %s: %w", migration.Version, migration.Name, err)
+    }
+    return nil
+}
+
+// MigrationStatus returns the migrations applied to a database and those
+// pending, without changing the schema.
+func MigrationStatus(db *gorm.DB) (*SchemaStatus, error) {
+    status := &SchemaStatus{Latest: LatestMigration()}
+
+    if db.Migrator().HasTable(&SchemaMigration{}) {
+        if err := db.Order("version").Find(&status.Applied).Error; err != nil {
+            return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+        }
+    }
+
+    applied := make(map[int]bool, len(status.Applied))
+    for _, migration := range status.Applied {
+        applied[migration.Version] = true
+        if migration.Version > status.Current {
+            status.Current = migration.Version
+        }
+    }
+    for _, migration := range migrations {
+        if !applied[migration.Version] {
+            status.Pending = append(status.Pending, migration)
+        }
+    }
+    return status, nil
+}
+
+// CheckMigrations returns a *PendingMigrationsError if the database is missing
+// migrations.
+func CheckMigrations(db *gorm.DB) error {
+    status, err := MigrationStatus(db)
+    if err != nil {
+        return err
+    }
+    if len(status.Pending) > 0 {
+        return &PendingMigrationsError{Pending: status.Pending}
+    }
+    return nil
+}
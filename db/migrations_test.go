@@ -0,0 +1,229 @@
+package db
+
+import (
+    "context"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+    "gorm.io/gorm/schema"
+)
+
+// testDatabaseURLEnv names the environment variable holding the URL of an
+// empty Postgres database with pgvector to run the migrations against
+const testDatabaseURLEnv = "THOR_TEST_DATABASE_URL"
+
+// recordingLogger records the statements of a database
+type recordingLogger struct {
+    logger.Interface
+
+    mu         sync.Mutex
+    statements []string
+}
+
+func (l *recordingLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+    sql, _ := fc()
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.statements = append(l.statements, sql)
+}
+
+// dryRunMigrations returns the statements of the migrations, without a database
+func dryRunMigrations(t *testing.T) []string {
+    t.Helper()
+
+    recorder := &recordingLogger{Interface: logger.Discard}
+    database, err := gorm.Open(postgres.New(postgres.Config{}), &gorm.Config{
+        DryRun:                 true,
+        DisableAutomaticPing:   true,
+        SkipDefaultTransaction: true,
+        Logger:                 recorder,
+    })
+    if err != nil {
+        t.Fatalf("failed to open dry-run database: %v", err)
+    }
+    for _, migration := range migrations {
+        if err := migration.Up(database); err != nil {
+            t.Fatalf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+        }
+    }
+    return recorder.statements
+}
+
+var (
+    createTablePattern = regexp.MustCompile(`(?s)^CREATE TABLE IF NOT EXISTS "?(\w+)"? \((.*)\)$`)
+    addColumnPattern   = regexp.MustCompile(`^ALTER TABLE "?(\w+)"? ADD COLUMN IF NOT EXISTS "?(\w+)"?`)
+)
+
+// migratedColumns returns the columns of each table the statements create
+func migratedColumns(statements []string) map[string]map[string]bool {
+    tables := make(map[string]map[string]bool)
+    add := func(table, column string) {
+        if tables[table] == nil {
+            tables[table] = make(map[string]bool)
+        }
+        tables[table][column] = true
+    }
+
+    for _, statement := range statements {
+        statement = strings.TrimSpace(statement)
+        if match := createTablePattern.FindStringSubmatch(statement); match != nil {
+            for _, line := range strings.Split(match[2], "\n") {
+                fields := strings.Fields(strings.TrimSpace(line))
+                if len(fields) == 0 || fields[0] == "PRIMARY" || fields[0] == "CONSTRAINT" {
+                    continue
+                }
+                add(match[1], strings.Trim(fields[0], `"`))
+            }
+        }
+        if match := addColumnPattern.FindStringSubmatch(statement); match != nil {
+            add(match[1], match[2])
+        }
+    }
+    return tables
+}
+
+func TestMigrationsCreateModelColumns(t *testing.T) {
+    tables := migratedColumns(dryRunMigrations(t))
+
+    tests := []struct {
+        model interface{}
+        table string
+    }{
+        {model: &Actor{}},
+        {model: &Session{}},
+        {model: &SessionActor{}},
+        {model: &TokenUsage{}},
+        {model: &ScheduledResponse{}},
+        {model: &ToolCall{}},
+    }
+    for _, table := range fragmentTables {
+        tests = append(tests, struct {
+            model interface{}
+            table string
+        }{model: &Fragment{}, table: string(table)})
+    }
+
+    for _, tt := range tests {
+        parsed, err := schema.Parse(tt.model, &sync.Map{}, schema.NamingStrategy{})
+        if err != nil {
+            t.Fatal(err)
+        }
+        table := tt.table
+        if table == "" {
+            table = parsed.Table
+        }
+
+        t.Run(table, func(t *testing.T) {
+            columns, ok := tables[table]
+            if !ok {
+                t.Fatalf("no migration creates table %s", table)
+            }
+            for _, field := range parsed.Fields {
+                if field.DBName == "" {
+                    continue
+                }
+                if !columns[field.DBName] {
+                    t.Errorf("no migration creates column %s.%s of the model", table, field.DBName)
+                }
+            }
+        })
+    }
+}
+
+func TestMigrationsIdempotent(t *testing.T) {
+    idempotent := []*regexp.Regexp{
+        regexp.MustCompile(`^(CREATE (EXTENSION|TABLE|INDEX( CONCURRENTLY)?) IF NOT EXISTS)`),
+        regexp.MustCompile(`^ALTER TABLE \S+ ADD COLUMN IF NOT EXISTS`),
+        regexp.MustCompile(`^CREATE OR REPLACE FUNCTION`),
+        regexp.MustCompile(`^DROP TRIGGER IF EXISTS`),
+        // Recreated after being dropped above
+        regexp.MustCompile(`^CREATE TRIGGER`),
+        regexp.MustCompile(`(?s)^INSERT INTO .* ON CONFLICT DO NOTHING$`),
+        // Backfills only touch rows that still need it
+        regexp.MustCompile(`(?s)^UPDATE .* IS NULL`),
+    }
+
+    for _, statement := range dryRunMigrations(t) {
+        statement = strings.TrimSpace(statement)
+        ok := false
+        for _, pattern := range idempotent {
+            if pattern.MatchString(statement) {
+                ok = true
+                break
+            }
+        }
+        if !ok {
+            t.Errorf("statement is not idempotent: %s", statement)
+        }
+    }
+}
+
+func TestMigrationVersions(t *testing.T) {
+    names := make(map[string]bool)
+    for i, migration := range Migrations() {
+        if migration.Version != i+1 {
+            t.Errorf("migration %s has version %d, want %d", migration.Name, migration.Version, i+1)
+        }
+        if names[migration.Name] {
+            t.Errorf("migration name %s is used twice", migration.Name)
+        }
+        names[migration.Name] = true
+        if migration.Up == nil {
+            t.Errorf("migration %d %s has no Up", migration.Version, migration.Name)
+        }
+    }
+    if LatestMigration() != len(migrations) {
+        t.Errorf("LatestMigration() = %d, want %d", LatestMigration(), len(migrations))
+    }
+}
+
+// TestMigrateLive runs the chain from an empty database, twice, against the
+// database named by THOR_TEST_DATABASE_URL
+func TestMigrateLive(t *testing.T) {
+    url := os.Getenv(testDatabaseURLEnv)
+    if url == "" {
+        t.Skipf("%s is not set", testDatabaseURLEnv)
+    }
+    database, err := gorm.Open(postgres.Open(url), &gorm.Config{Logger: logger.Discard})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    status, err := MigrationStatus(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if status.Current != 0 {
+        t.Skipf("database is not empty, at migration %d", status.Current)
+    }
+
+    tests := []struct {
+        name   string
+        target int
+    }{
+        {name: "to the tracked baseline", target: 3},
+        {name: "to the latest version", target: 0},
+        {name: "again", target: 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := Migrate(database, tt.target); err != nil {
+                t.Fatalf("Migrate failed: %v", err)
+            }
+        })
+    }
+
+    if err := CheckMigrations(database); err != nil {
+        t.Errorf("CheckMigrations failed: %v", err)
+    }
+    if err := VerifySchema(database); err != nil {
+        t.Errorf("VerifySchema failed: %v", err)
+    }
+}
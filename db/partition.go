@@ -0,0 +1,369 @@
+package db
+
+import (
+    "database/sql"
+    "fmt"
+    "regexp"
+    "strconv"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+const (
+    // DefaultPartitionsAhead is the number of months past the current one
+    // partitions are created for when no number is given.
+    DefaultPartitionsAhead = 3
+
+    // partitionLockKey is the advisory lock held while partitions are created,
+    // so instances maintaining them together don't race.
+    partitionLockKey = 7243902
+
+    // partitionedTablesKey is the gorm setting marking a database with its
+    // partitioned fragment tables, see MarkPartitionedTables.
+    partitionedTablesKey = "thor:partitioned_tables"
+
+    // partitionBoundFormat formats the bounds of monthly partitions.
+    partitionBoundFormat = "2006-01-02 15:04:05-07"
+)
+
+// partitionColumns are the columns moved into a partitioned table. Search
+// vectors are rebuilt by the table's trigger.
+const partitionColumns = fragmentColumns + ", tenant_id"
+
+// Partition is a monthly partition of a fragment table, holding the fragments
+// created from From up to To.
+type Partition struct {
+    Name string
+    From time.Time
+    To   time.Time
+}
+
+// PartitionConfig configures PartitionFragmentTable.
+type PartitionConfig struct {
+    // Months past the current one to create partitions for,
+    // DefaultPartitionsAhead if zero.
+    Ahead int
+    // Rows moved per statement, 1000 if zero.
+    BatchSize int
+    // Called after each batch with the number of rows moved so far, e.g. to
+    // log progress.
+    OnBatch func(moved int64)
+}
+
+// PartitionFragmentTable turns a fragment table into a table partitioned by
+// month of creation, for tables large enough that queries and purges by date
+// slow down. Partitioning is optional; tables stay flat unless it is run.
+// Queries don't change, since PostgreSQL routes rows to their partitions, but
+// the primary key becomes (id, created_at), so the stores upsert fragments by
+// ID and creation time: upserting a fragment under another creation time
+// inserts it again. Stores notice the partitioning through NewDatabase, or
+// MarkPartitionedTables for databases opened otherwise.
+//
+// The table is renamed to <table>_flat, and a partitioned table with its
+// columns, indexes, constraints and triggers is created under its name, with
+// a partition per month from its oldest fragment up to Ahead months past the
+// current one and a default partition for the rest. The fragments are then
+// moved over in batches, each deleted from the flat table and inserted into
+// the partitioned one in one statement, and the empty flat table is dropped.
+// Fragments not moved yet can't be read or updated, so run it while the
+// application is stopped, or for large tables accept that older fragments
+// show up as they are moved:
+//
+//	err := db.PartitionFragmentTable(database, db.FragmentTableInteraction, db.PartitionConfig{
+//	    BatchSize: 10000,
+//	    OnBatch: func(moved int64) { log.Printf("moved %d fragments", moved) },
+//	})
+//
+// It can be interrupted and run again, and does nothing for a table already
+// partitioned. Keep the upcoming partitions created with EnsurePartitions,
+// e.g. with engine.WithPartitionMaintenance.
+func PartitionFragmentTable(db *gorm.DB, table FragmentTable, cfg PartitionConfig) error {
+    if !isFragmentTable(table) {
+        return fmt.Errorf("unknown fragment table %q", table)
+    }
+    ahead := cfg.Ahead
+    if ahead <= 0 {
+        ahead = DefaultPartitionsAhead
+    }
+
+    if err := db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", partitionLockKey).Error; err != nil {
+            return err
+        }
+        partitioned, err := IsPartitioned(tx, table)
+        if err != nil || partitioned {
+            return err
+        }
+        return partitionTable(tx, table, ahead)
+    }); err != nil {
+        return fmt.Errorf("failed to partition %s: %w", table, err)
+    }
+    return moveFlatFragments(db, table, cfg)
+}
+
+// tableDefinition is an index, constraint or trigger of a table, as the
+// statement recreating it.
+type tableDefinition struct {
+    Name       string
+    Definition string
+    IsPrimary  bool
+}
+
+// partitionTable replaces a flat fragment table with a partitioned one, see
+// PartitionFragmentTable.
+func partitionTable(tx *gorm.DB, table FragmentTable, ahead int) error {
+    quote := (&gorm.Statement{DB: tx}).Quote
+    name, flat := string(table), flatTableName(table)
+
+    // Definitions name the table, so recreated after the rename they apply to
+    // the partitioned one
+    var indexes, constraints []tableDefinition
+    if err := tx.Raw(`
+        SELECT i.relname AS name, pg_get_indexdef(i.oid) AS definition, x.indisprimary AS is_primary FROM pg_index x
+        JOIN pg_class i ON i.oid = x.indexrelid
+        WHERE x.indrelid = ?::regclass`,
+        name,
+    ).Scan(&indexes).Error; err != nil {
+        return fmt.Errorf("failed to get indexes: %w", err)
+    }
+    if err := tx.Raw(`
+        SELECT conname AS name, pg_get_constraintdef(oid) AS definition FROM pg_constraint
+        WHERE conrelid = ?::regclass AND contype IN ('c', 'f')`,
+        name,
+    ).Scan(&constraints).Error; err != nil {
+        return fmt.Errorf("failed to get constraints: %w", err)
+    }
+    var triggers []string
+    if err := tx.Raw(
+        "SELECT pg_get_triggerdef(oid) FROM pg_trigger WHERE tgrelid = ?::regclass AND NOT tgisinternal",
+        name,
+    ).Scan(&triggers).Error; err != nil {
+        return fmt.Errorf("failed to get triggers: %w", err)
+    }
+    var oldest sql.NullTime
+    if err := tx.Raw(fmt.Sprintf("SELECT MIN(created_at) FROM %s", quote(name))).Scan(&oldest).Error; err != nil {
+        return fmt.Errorf("failed to get oldest fragment: %w", err)
+    }
+
+    statements := []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quote(name), quote(flat))}
+    // Index names are unique per schema, so the flat table's make way
+    for _, index := range indexes {
+        statements = append(statements, fmt.Sprintf("ALTER INDEX %s RENAME TO %s", quote(index.Name), quote(index.Name+"_flat")))
+    }
+    statements = append(statements,
+        fmt.Sprintf(
+            "CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING STORAGE INCLUDING COMMENTS) PARTITION BY RANGE (created_at)",
+            quote(name), quote(flat),
+        ),
+        // Unique keys of partitioned tables must include the partition key
+        fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (id, created_at)", quote(name)),
+    )
+    for _, index := range indexes {
+        if index.IsPrimary {
+            continue
+        }
+        statements = append(statements, index.Definition)
+    }
+    for _, constraint := range constraints {
+        statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", quote(name), quote(constraint.Name), constraint.Definition))
+    }
+    statements = append(statements, triggers...)
+    statements = append(statements, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s DEFAULT", quote(name+"_default"), quote(name)))
+
+    for _, statement := range statements {
+        if err := tx.Exec(statement).Error; err != nil {
+            return err
+        }
+    }
+
+    from := monthStart(time.Now())
+    if oldest.Valid && oldest.Time.Before(from) {
+        from = monthStart(oldest.Time)
+    }
+    return createPartitions(tx, table, from, monthStart(time.Now()).AddDate(0, ahead+1, 0))
+}
+
+// moveFlatFragments moves the fragments of the flat table left by
+// partitionTable into the partitioned table, then drops it.
+func moveFlatFragments(db *gorm.DB, table FragmentTable, cfg PartitionConfig) error {
+    flat := flatTableName(table)
+    if !db.Migrator().HasTable(flat) {
+        return nil
+    }
+    batchSize := cfg.BatchSize
+    if batchSize <= 0 {
+        batchSize = defaultBackfillBatchSize
+    }
+
+    quote := (&gorm.Statement{DB: db}).Quote
+    from, to := quote(flat), quote(string(table))
+    var moved int64
+    for {
+        result := db.Exec(`
+            WITH moved AS (
+                DELETE FROM `+from+` WHERE id IN (SELECT id FROM `+from+` LIMIT ?)
+                RETURNING `+partitionColumns+`
+            )
+            INSERT INTO `+to+` (`+partitionColumns+`)
+            SELECT `+partitionColumns+` FROM moved`,
+            batchSize,
+        )
+        if result.Error != nil {
+            return fmt.Errorf("failed to move fragments to partitioned %s: %w", table, result.Error)
+        }
+        moved += result.RowsAffected
+        if result.RowsAffected > 0 && cfg.OnBatch != nil {
+            cfg.OnBatch(moved)
+        }
+        if result.RowsAffected < int64(batchSize) {
+            break
+        }
+    }
+
+    if err := db.Exec(fmt.Sprintf("DROP TABLE %s", from)).Error; err != nil {
+        return fmt.Errorf("failed to drop flat %s: %w", table, err)
+    }
+    return nil
+}
+
+// EnsurePartitions creates the monthly partitions of a partitioned fragment
+// table from the current month up to ahead months past it,
+// DefaultPartitionsAhead if not positive, so no fragment lands in the default
+// partition. It does nothing for tables that aren't partitioned, and is
+// idempotent, so it can run periodically, e.g. from the engine's scheduler
+// with engine.WithPartitionMaintenance.
+func EnsurePartitions(db *gorm.DB, table FragmentTable, ahead int) error {
+    if ahead <= 0 {
+        ahead = DefaultPartitionsAhead
+    }
+    err := db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", partitionLockKey).Error; err != nil {
+            return err
+        }
+        partitioned, err := IsPartitioned(tx, table)
+        if err != nil || !partitioned {
+            return err
+        }
+        from := monthStart(time.Now())
+        return createPartitions(tx, table, from, from.AddDate(0, ahead+1, 0))
+    })
+    if err != nil {
+        return fmt.Errorf("failed to create partitions of %s: %w", table, err)
+    }
+    return nil
+}
+
+// createPartitions creates the missing monthly partitions of a table from the
+// month starting at from up to the one starting at to, excluded.
+func createPartitions(tx *gorm.DB, table FragmentTable, from, to time.Time) error {
+    quote := (&gorm.Statement{DB: tx}).Quote
+    for month := from; month.Before(to); month = month.AddDate(0, 1, 0) {
+        next := month.AddDate(0, 1, 0)
+        if err := tx.Exec(fmt.Sprintf(
+            "CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+            quote(partitionName(table, month)), quote(string(table)),
+            month.Format(partitionBoundFormat), next.Format(partitionBoundFormat),
+        )).Error; err != nil {
+            return fmt.Errorf("failed to create partition of %s: %w", month.Format("2006-01"), err)
+        }
+    }
+    return nil
+}
+
+// IsPartitioned reports whether a fragment table is partitioned, see
+// PartitionFragmentTable.
+func IsPartitioned(db *gorm.DB, table FragmentTable) (bool, error) {
+    var partitioned bool
+    if err := db.Raw(`
+        SELECT EXISTS (
+            SELECT 1 FROM pg_partitioned_table p
+            JOIN pg_class c ON c.oid = p.partrelid
+            WHERE c.relname = ? AND c.relnamespace = to_regnamespace(current_schema())
+        )`,
+        string(table),
+    ).Scan(&partitioned).Error; err != nil {
+        return false, fmt.Errorf("failed to check partitioning of %s: %w", table, err)
+    }
+    return partitioned, nil
+}
+
+// ListPartitions returns the monthly partitions of a fragment table, oldest
+// first, or none if it isn't partitioned. The default partition isn't listed.
+func ListPartitions(db *gorm.DB, table FragmentTable) ([]Partition, error) {
+    var names []string
+    if err := db.Raw(`
+        SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        JOIN pg_class p ON p.oid = i.inhparent
+        WHERE p.relname = ? AND p.relnamespace = to_regnamespace(current_schema())
+        ORDER BY c.relname`,
+        string(table),
+    ).Scan(&names).Error; err != nil {
+        return nil, fmt.Errorf("failed to list partitions of %s: %w", table, err)
+    }
+
+    pattern := regexp.MustCompile("^" + regexp.QuoteMeta(string(table)) + `_p(\d{4})_(\d{2})$`)
+    var partitions []Partition
+    for _, name := range names {
+        match := pattern.FindStringSubmatch(name)
+        if match == nil {
+            continue
+        }
+        year, _ := strconv.Atoi(match[1])
+        month, _ := strconv.Atoi(match[2])
+        from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+        partitions = append(partitions, Partition{Name: name, From: from, To: from.AddDate(0, 1, 0)})
+    }
+    return partitions, nil
+}
+
+// MarkPartitionedTables returns database marked with its partitioned fragment
+// tables, which the stores created from it or its transactions upsert into by
+// ID and creation time, see PartitionFragmentTable. NewDatabase marks the
+// databases it opens.
+func MarkPartitionedTables(database *gorm.DB) (*gorm.DB, error) {
+    partitioned := make(map[FragmentTable]bool)
+    for _, table := range fragmentTables {
+        ok, err := IsPartitioned(database, table)
+        if err != nil {
+            return nil, err
+        }
+        if ok {
+            partitioned[table] = true
+        }
+    }
+    return database.Set(partitionedTablesKey, partitioned).Session(&gorm.Session{}), nil
+}
+
+// IsMarkedPartitioned reports whether database is marked with table as
+// partitioned by MarkPartitionedTables.
+func IsMarkedPartitioned(database *gorm.DB, table FragmentTable) bool {
+    if database == nil {
+        return false
+    }
+    value, ok := database.Get(partitionedTablesKey)
+    if !ok {
+        return false
+    }
+    partitioned, _ := value.(map[FragmentTable]bool)
+    return partitioned[table]
+}
+
+// partitionName returns the name of the partition of a table holding the
+// fragments created in the month starting at month.
+func partitionName(table FragmentTable, month time.Time) string {
+    return fmt.Sprintf("%s_p%04d_%02d", table, month.Year(), int(month.Month()))
+}
+
+// flatTableName returns the name a fragment table is moved out of while it is
+// partitioned.
+func flatTableName(table FragmentTable) string {
+    return string(table) + "_flat"
+}
+
+// monthStart returns the start of the month of t, in UTC.
+func monthStart(t time.Time) time.Time {
+    t = t.UTC()
+    return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+    "fmt"
+    "regexp"
+
+    "gorm.io/gorm"
+)
+
+// SearchVectorColumn is the tsvector column of the fragment tables that full
+// text searches match against.
+const SearchVectorColumn = "search_vector"
+
+// DefaultTextSearchLanguage is the text search configuration search vectors
+// are built with by default.
+const DefaultTextSearchLanguage = "english"
+
+// defaultBackfillBatchSize is the number of rows backfilled per statement.
+const defaultBackfillBatchSize = 1000
+
+// textSearchLanguagePattern matches the names of text search configurations,
+// which are written into the trigger definitions.
+var textSearchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// TextSearchConfig configures MigrateTextSearch.
+type TextSearchConfig struct {
+    // Text search configuration of the search vectors, e.g. "simple" to match
+    // words without stemming. DefaultTextSearchLanguage if empty.
+    Language string
+    // Rows backfilled per statement, 1000 if zero.
+    BatchSize int
+}
+
+// MigrateTextSearch adds the search vector column to the fragment tables,
+// with a trigger keeping it in sync with the content and a GIN index. Rows
+// without a search vector, such as those stored before the migration, are
+// backfilled in batches so large tables are never locked as a whole. It is
+// idempotent. Search vectors already built are kept when the language
+// changes; clear the column to rebuild them.
+func MigrateTextSearch(db *gorm.DB, cfg TextSearchConfig) error {
+    language := cfg.Language
+    if language == "" {
+        language = DefaultTextSearchLanguage
+    }
+    if !textSearchLanguagePattern.MatchString(language) {
+        return fmt.Errorf("invalid text search language %q", language)
+    }
+    batchSize := cfg.BatchSize
+    if batchSize <= 0 {
+        batchSize = defaultBackfillBatchSize
+    }
+
+    if err := db.Exec(`
+        CREATE OR REPLACE FUNCTION fragment_search_vector() RETURNS trigger AS $$
+        BEGIN
+            NEW.search_vector := to_tsvector(TG_ARGV[0]::regconfig, coalesce(NEW.content, ''));
+            RETURN NEW;
+        END
+        $$ LANGUAGE plpgsql`).Error; err != nil {
+        return fmt.Errorf("failed to create search vector function: %w", err)
+    }
+
+    quote := (&gorm.Statement{DB: db}).Quote
+    for _, table := range fragmentTables {
+        name := quote(string(table))
+        statements := []string{
+            fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s tsvector", name, SearchVectorColumn),
+            fmt.Sprintf("DROP TRIGGER IF EXISTS fragment_search_vector ON %s", name),
+            fmt.Sprintf(
+                "CREATE TRIGGER fragment_search_vector BEFORE INSERT OR UPDATE OF content ON %s FOR EACH ROW EXECUTE FUNCTION fragment_search_vector('%s')",
+                name, language,
+            ),
+            fmt.Sprintf(
+                "CREATE INDEX IF NOT EXISTS %s ON %s USING gin (%s)",
+                quote(fmt.Sprintf("idx_%s_%s", table, SearchVectorColumn)), name, SearchVectorColumn,
+            ),
+        }
+        for _, statement := range statements {
+            if err := db.Exec(statement).Error; err != nil {
+                return fmt.Errorf("failed to migrate text search of %s: %w", table, err)
+            }
+        }
+
+        if err := backfillSearchVectors(db, table, language, batchSize); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// backfillSearchVectors builds the missing search vectors of a table, a batch
+// at a time.
+func backfillSearchVectors(db *gorm.DB, table FragmentTable, language string, batchSize int) error {
+    name := (&gorm.Statement{DB: db}).Quote(string(table))
+    statement := fmt.Sprintf(`
+        UPDATE %[1]s SET %[2]s = to_tsvector(?::regconfig, coalesce(content, ''))
+        WHERE id IN (SELECT id FROM %[1]s WHERE %[2]s IS NULL LIMIT ?)`,
+        name, SearchVectorColumn,
+    )
+
+    for {
+        result := db.Exec(statement, language, batchSize)
+        if result.Error != nil {
+            return fmt.Errorf("failed to backfill search vectors of %s: %w", table, result.Error)
+        }
+        if result.RowsAffected < int64(batchSize) {
+            return nil
+        }
+    }
+}
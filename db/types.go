@@ -29,6 +29,14 @@ var fragmentTables = []FragmentTable{
     FragmentTableTwitter,
 }
 
+// FragmentTables returns the fragment tables, in the order they were added.
+func FragmentTables() []FragmentTable {
+    return append([]FragmentTable(nil), fragmentTables...)
+}
+
+// EmbeddingDimensions is the size of the fragment embedding vectors.
+const EmbeddingDimensions = 1536
+
 // Metadata represents a JSON object stored in the database.
 type Metadata map[string]interface{}
 
@@ -41,6 +49,13 @@ type Fragment struct {
     Metadata  Metadata        `gorm:"type:jsonb;not null;default:'{}'::jsonb"`
     Embedding pgvector.Vector `gorm:"type:vector(1536)"`
 
+    // TenantID is the tenant owning the fragment in multi-tenant databases, see
+    // stores.WithTenant, and nil in single-tenant ones
+    TenantID *id.ID `gorm:"type:uuid"`
+
+    // ParentID is the fragment this one replies to, e.g. the input a response answers
+    ParentID *id.ID `gorm:"type:uuid;index"`
+
     Actor   *Actor   `gorm:"foreignKey:ActorID"`
     Session *Session `gorm:"foreignKey:SessionID"`
 
@@ -56,6 +71,13 @@ type Actor struct {
 
     Assistant bool `gorm:"type:boolean;not null;default:false"`
 
+    // Metadata holds platform details such as handles, avatars, locale and
+    // preferences
+    Metadata Metadata `gorm:"type:jsonb;not null;default:'{}'::jsonb"`
+
+    // TenantID is the tenant owning the actor, nil in single-tenant databases
+    TenantID *id.ID `gorm:"type:uuid"`
+
     CreatedAt time.Time
     UpdatedAt time.Time
     DeletedAt gorm.DeletedAt `gorm:"index"`
@@ -65,11 +87,112 @@ type Actor struct {
 type Session struct {
     ID id.ID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
 
+    Title    string   `gorm:"type:varchar(255);not null;default:''"`
+    Metadata Metadata `gorm:"type:jsonb;not null;default:'{}'::jsonb"`
+
+    // LastActivityAt is updated whenever an input of the session is processed
+    LastActivityAt time.Time `gorm:"index"`
+    // ClosedAt is set once the session is closed and no longer accepts inputs
+    ClosedAt *time.Time
+
+    // TenantID is the tenant owning the session, nil in single-tenant databases
+    TenantID *id.ID `gorm:"type:uuid"`
+
     CreatedAt time.Time
     UpdatedAt time.Time
     DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// IsClosed reports whether the session has been closed.
+func (s *Session) IsClosed() bool {
+    return s.ClosedAt != nil
+}
+
+// SessionActor records an actor taking part in a session, from the first to
+// the last input of the actor in the session.
+type SessionActor struct {
+    SessionID id.ID `gorm:"type:uuid;primaryKey"`
+    ActorID   id.ID `gorm:"type:uuid;primaryKey;index"`
+
+    Session *Session `gorm:"foreignKey:SessionID"`
+    Actor   *Actor   `gorm:"foreignKey:ActorID"`
+
+    FirstSeenAt time.Time `gorm:"not null"`
+    LastSeenAt  time.Time `gorm:"not null;index"`
+}
+
+// TokenUsage tracks LLM token consumption for a budget scope, such as a
+// single session or a calendar day.
+type TokenUsage struct {
+    Scope            string `gorm:"type:varchar(128);primaryKey"`
+    PromptTokens     int64  `gorm:"not null;default:0"`
+    CompletionTokens int64  `gorm:"not null;default:0"`
+    TotalTokens      int64  `gorm:"not null;default:0"`
+
+    // Paused is set once the budget pause message has been sent for the scope
+    Paused bool `gorm:"not null;default:false"`
+
+    // TenantID is the tenant of the usage, nil in single-tenant databases, see
+    // stores.UsageStore
+    TenantID *id.ID `gorm:"type:uuid;index"`
+
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
+
+// ToolCall records a tool the model called while generating a response, see
+// stores.ToolCallStore.
+type ToolCall struct {
+    ID        id.ID `gorm:"type:uuid;primaryKey"`
+    SessionID id.ID `gorm:"type:uuid;not null;index:idx_tool_calls_session_id,priority:1"`
+    // FragmentID is the response the call was made for, nil if unknown
+    FragmentID *id.ID `gorm:"type:uuid;index"`
+
+    Name string `gorm:"type:varchar(255);not null;index:idx_tool_calls_name,priority:1"`
+    // Arguments are the JSON arguments of the call, under "raw" if they aren't
+    // a JSON object
+    Arguments Metadata `gorm:"type:jsonb;not null;default:'{}'::jsonb"`
+
+    // Result is the output of the tool, cut short if ResultTruncated
+    Result          string `gorm:"type:text;not null;default:''"`
+    ResultTruncated bool   `gorm:"type:boolean;not null;default:false"`
+    // Error is the error of a failed call, empty if it succeeded
+    Error    string        `gorm:"type:text;not null;default:''"`
+    Duration time.Duration `gorm:"not null;default:0"`
+
+    CreatedAt time.Time `gorm:"index:idx_tool_calls_session_id,priority:2;index:idx_tool_calls_name,priority:2"`
+}
+
+// ScheduleStatus is the state of a scheduled response
+type ScheduleStatus string
+
+const (
+    SchedulePending   ScheduleStatus = "pending"
+    ScheduleRunning   ScheduleStatus = "running"
+    ScheduleDone      ScheduleStatus = "done"
+    ScheduleFailed    ScheduleStatus = "failed"
+    ScheduleCancelled ScheduleStatus = "cancelled"
+)
+
+// ScheduledResponse is a response the engine should produce in a session at a later time.
+type ScheduledResponse struct {
+    ID        id.ID `gorm:"type:uuid;primaryKey"`
+    SessionID id.ID `gorm:"type:uuid;not null;index"`
+
+    // Assistant answering, and what it should follow up on
+    AssistantID id.ID    `gorm:"type:uuid;not null"`
+    Instruction string   `gorm:"type:text;not null;default:''"`
+    Data        Metadata `gorm:"type:jsonb;not null;default:'{}'::jsonb"`
+
+    RunAt     time.Time      `gorm:"not null;index"`
+    Status    ScheduleStatus `gorm:"type:varchar(16);not null;index"`
+    ClaimedAt *time.Time
+    Error     string `gorm:"type:text;not null;default:''"`
+
+    CreatedAt time.Time
+    UpdatedAt time.Time
+}
+
 // Value implements the driver.Valuer interface for Metadata.
 func (m Metadata) Value() (driver.Value, error) {
     if m == nil {
@@ -93,6 +216,41 @@ func (m *Metadata) Scan(value interface{}) error {
     return json.Unmarshal(bytes, m)
 }
 
+// Clone returns a deep copy of the Metadata. Nested maps and slices are copied,
+// so the clone can be mutated without affecting the original.
+func (m Metadata) Clone() Metadata {
+    if m == nil {
+        return nil
+    }
+    clone := make(Metadata, len(m))
+    for key, value := range m {
+        clone[key] = cloneValue(value)
+    }
+    return clone
+}
+
+// cloneValue deep copies the map and slice types JSON metadata can contain.
+func cloneValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case Metadata:
+        return v.Clone()
+    case map[string]interface{}:
+        return map[string]interface{}(Metadata(v).Clone())
+    case []interface{}:
+        clone := make([]interface{}, len(v))
+        for i, item := range v {
+            clone[i] = cloneValue(item)
+        }
+        return clone
+    case []string:
+        return append([]string(nil), v...)
+    case []float64:
+        return append([]float64(nil), v...)
+    default:
+        return v
+    }
+}
+
 // GetString retrieves a string value from Metadata, returning an empty string if not found or not a string.
 func (m Metadata) GetString(key string) string {
     if val, ok := m[key].(string); ok {
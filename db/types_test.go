@@ -0,0 +1,69 @@
+package db
+
+import (
+    "fmt"
+    "testing"
+)
+
+func TestMetadataClone(t *testing.T) {
+    tests := []struct {
+        name     string
+        metadata Metadata
+        // mutate changes the clone
+        mutate func(clone Metadata)
+    }{
+        {
+            name:     "nil",
+            metadata: nil,
+            mutate:   func(clone Metadata) {},
+        },
+        {
+            name:     "values",
+            metadata: Metadata{"source": "chat", "count": 2.0},
+            mutate:   func(clone Metadata) { clone["source"] = "email" },
+        },
+        {
+            name:     "nested metadata",
+            metadata: Metadata{"user": Metadata{"name": "Alice"}},
+            mutate:   func(clone Metadata) { clone["user"].(Metadata)["name"] = "Bob" },
+        },
+        {
+            name:     "nested map",
+            metadata: Metadata{"tags": map[string]interface{}{"lang": "en", "inner": map[string]interface{}{"a": 1.0}}},
+            mutate: func(clone Metadata) {
+                tags := clone["tags"].(map[string]interface{})
+                tags["lang"] = "fr"
+                tags["inner"].(map[string]interface{})["a"] = 2.0
+            },
+        },
+        {
+            name:     "slices",
+            metadata: Metadata{"items": []interface{}{"a", map[string]interface{}{"b": 1.0}}, "names": []string{"x"}, "scores": []float64{0.5}},
+            mutate: func(clone Metadata) {
+                items := clone["items"].([]interface{})
+                items[0] = "z"
+                items[1].(map[string]interface{})["b"] = 2.0
+                clone["names"].([]string)[0] = "y"
+                clone["scores"].([]float64)[0] = 0.9
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            before := fmt.Sprint(tt.metadata)
+            clone := tt.metadata.Clone()
+            if (clone == nil) != (tt.metadata == nil) {
+                t.Fatalf("clone = %v of %v", clone, tt.metadata)
+            }
+            if fmt.Sprint(clone) != before {
+                t.Errorf("clone = %v, want %v", clone, before)
+            }
+
+            tt.mutate(clone)
+            if got := fmt.Sprint(tt.metadata); got != before {
+                t.Errorf("original = %s after mutating the clone, want %s", got, before)
+            }
+        })
+    }
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+    "fmt"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+)
+
+// AssistantProfile is an assistant identity responses can be authored by
+type AssistantProfile struct {
+    ID               id.ID
+    Name             string
+    DefaultModelType llm.ModelType // Model type used to generate the assistant's responses
+}
+
+// actor returns the actor record of the assistant.
+func (p AssistantProfile) actor() *db.Actor {
+    return &db.Actor{
+        ID:        p.ID,
+        Name:      p.Name,
+        Assistant: true,
+    }
+}
+
+// RegisterAssistant adds an assistant identity to the engine and upserts it as
+// an assistant actor. Registering an existing ID updates its profile.
+// The engine's own ID and name are registered as the default assistant.
+func (e *Engine) RegisterAssistant(assistantID id.ID, name string, defaultModelType llm.ModelType) error {
+    if assistantID == "" {
+        return fmt.Errorf("assistant ID is required")
+    }
+    if name == "" {
+        return fmt.Errorf("assistant name is required")
+    }
+    if defaultModelType == "" {
+        defaultModelType = llm.ModelTypeDefault
+    }
+
+    if err := e.UpsertActor(assistantID, name, true); err != nil {
+        return fmt.Errorf("failed to register assistant %s: %w", assistantID, err)
+    }
+
+    e.assistantsMu.Lock()
+    defer e.assistantsMu.Unlock()
+
+    if e.assistants == nil {
+        e.assistants = make(map[id.ID]AssistantProfile)
+    }
+    e.assistants[assistantID] = AssistantProfile{
+        ID:               assistantID,
+        Name:             name,
+        DefaultModelType: defaultModelType,
+    }
+
+    return nil
+}
+
+// GetAssistant returns the profile of a registered assistant.
+func (e *Engine) GetAssistant(assistantID id.ID) (AssistantProfile, error) {
+    e.assistantsMu.RLock()
+    defer e.assistantsMu.RUnlock()
+
+    profile, ok := e.assistants[assistantID]
+    if !ok {
+        return AssistantProfile{}, fmt.Errorf("assistant %s is not registered", assistantID)
+    }
+    return profile, nil
+}
+
+// defaultAssistant returns the profile of the engine's own identity.
+func (e *Engine) defaultAssistant() AssistantProfile {
+    e.assistantsMu.RLock()
+    defer e.assistantsMu.RUnlock()
+
+    if profile, ok := e.assistants[e.ID]; ok {
+        return profile
+    }
+    return AssistantProfile{
+        ID:               e.ID,
+        Name:             e.Name,
+        DefaultModelType: llm.ModelTypeDefault,
+    }
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/velumlabs/thor/manager"
+)
+
+// backgroundProcess tracks the background work of a single manager.
+type backgroundProcess struct {
+    manager manager.Manager
+    cancel  context.CancelFunc
+    done    chan struct{}
+}
+
+// Shutdown stops the scheduler, drains the input queue, stops the background
+// processes of all managers and waits until they have finished or ctx is done,
+// then drains the event dispatchers of the managers and the event bus. Returns an error naming the managers that did not
+// stop in time.
+func (e *Engine) Shutdown(ctx context.Context) error {
+    if err := e.stopScheduler(ctx); err != nil {
+        return err
+    }
+
+    if err := e.drainQueue(ctx); err != nil {
+        return err
+    }
+
+    e.managersMu.Lock()
+    processes := e.background
+    e.background = nil
+    e.backgroundRunning = false
+    e.managersMu.Unlock()
+
+    for _, process := range processes {
+        e.signalStop(process)
+    }
+
+    var stuck []string
+    for managerID, process := range processes {
+        select {
+        case <-process.done:
+        case <-ctx.Done():
+            stuck = append(stuck, string(managerID))
+        }
+    }
+
+    if len(stuck) > 0 {
+        sort.Strings(stuck)
+        return fmt.Errorf("managers failed to stop in time: %s", strings.Join(stuck, ", "))
+    }
+
+    managers, _, _ := e.snapshotManagers()
+    for _, m := range managers {
+        if err := e.closeManagerEvents(ctx, m); err != nil {
+            return err
+        }
+    }
+
+    if e.eventBus != nil {
+        if err := e.eventBus.Close(ctx); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// startBackground starts the background work of a manager, unless it declares
+// no background capability.
+// Must be called with managersMu held.
+func (e *Engine) startBackground(m manager.Manager) {
+    if !manager.Supports(m, manager.CapabilityBackground) {
+        return
+    }
+
+    if e.background == nil {
+        e.background = make(map[manager.ManagerID]*backgroundProcess)
+    }
+
+    ctx, cancel := context.WithCancel(e.ctx)
+    process := &backgroundProcess{
+        manager: m,
+        cancel:  cancel,
+        done:    make(chan struct{}),
+    }
+    e.background[m.GetID()] = process
+
+    runner, ok := manager.Unwrap(m).(manager.BackgroundRunner)
+    if !ok {
+        // Legacy managers are considered stopped once StopBackgroundProcesses returns
+        go m.StartBackgroundProcesses()
+        return
+    }
+
+    go func() {
+        defer close(process.done)
+        if err := runner.RunBackground(ctx); err != nil && ctx.Err() == nil {
+            e.logger.WithError(err).WithField("manager", m.GetID()).Error("Background process failed")
+        }
+    }()
+}
+
+// stopBackground signals the background work of a manager to stop without waiting.
+// Must be called with managersMu held.
+func (e *Engine) stopBackground(managerID manager.ManagerID) {
+    process, ok := e.background[managerID]
+    if !ok {
+        return
+    }
+    delete(e.background, managerID)
+    e.signalStop(process)
+}
+
+// signalStop cancels a background process and, for managers without a
+// background runner, calls StopBackgroundProcesses.
+func (e *Engine) signalStop(process *backgroundProcess) {
+    process.cancel()
+
+    if _, ok := manager.Unwrap(process.manager).(manager.BackgroundRunner); ok {
+        return
+    }
+
+    go func() {
+        defer close(process.done)
+        process.manager.StopBackgroundProcesses()
+    }()
+}
+
+// closeManagerEvents stops the event dispatcher of a manager, if it has one,
+// once its queued events are delivered or ctx is done, see manager.EventCloser.
+func (e *Engine) closeManagerEvents(ctx context.Context, m manager.Manager) error {
+    closer, ok := manager.Unwrap(m).(manager.EventCloser)
+    if !ok {
+        return nil
+    }
+    if err := closer.CloseEvents(ctx); err != nil {
+        e.logger.WithError(err).WithField("manager", m.GetID()).Warn("Failed to drain manager events")
+        return fmt.Errorf("manager %s: %w", m.GetID(), err)
+    }
+    return nil
+}
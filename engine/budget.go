@@ -0,0 +1,131 @@
+package engine
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/stores"
+)
+
+// ErrBudgetExceeded is returned by GenerateResponse once a session or daily
+// token budget has been used up.
+var ErrBudgetExceeded = errors.New("token budget exceeded")
+
+// GetSessionUsage returns the tokens consumed by a session so far.
+// A session without any recorded usage returns a zero usage.
+func (e *Engine) GetSessionUsage(sessionID id.ID) (*db.TokenUsage, error) {
+    return e.usageStore.Get(sessionScope(sessionID))
+}
+
+// GetDailyUsage returns the tokens consumed across all sessions today (UTC), or
+// across the sessions of its tenant if the engine runs for one.
+func (e *Engine) GetDailyUsage() (*db.TokenUsage, error) {
+    return e.usageStore.Get(dailyScope(time.Now()))
+}
+
+// ResetSessionUsage clears the recorded usage of a session, re-enabling
+// generation for it if it had exceeded its budget.
+func (e *Engine) ResetSessionUsage(sessionID id.ID) error {
+    return e.usageStore.Reset(sessionScope(sessionID))
+}
+
+// ResetDailyUsage clears today's recorded global usage.
+func (e *Engine) ResetDailyUsage() error {
+    return e.usageStore.Reset(dailyScope(time.Now()))
+}
+
+// reservation is the tokens reserved for a completion in the usage scopes it
+// is recorded in
+type reservation struct {
+    tokens int64
+    scopes []string
+}
+
+// reserveBudget reserves the estimated prompt tokens of messages in the session
+// and daily budgets, returning ErrBudgetExceeded if either is used up. The
+// check and the reservation are one atomic step, so concurrent responses can't
+// all pass the check of the same usage. Without budgets nothing is reserved.
+func (e *Engine) reserveBudget(sessionID id.ID, messages []llm.Message) (reservation, error) {
+    res := reservation{scopes: []string{sessionScope(sessionID), dailyScope(time.Now())}}
+    if e.sessionTokenBudget == 0 && e.dailyTokenBudget == 0 {
+        return res, nil
+    }
+
+    // Reserve at least a token, so even empty prompts count against the budget
+    res.tokens = 1
+    var estimate int64
+    for _, message := range messages {
+        estimate += int64(llm.EstimateTokens(message.Content))
+    }
+    if estimate > res.tokens {
+        res.tokens = estimate
+    }
+
+    err := e.usageStore.Reserve(res.tokens,
+        stores.UsageLimit{Scope: res.scopes[0], Budget: e.sessionTokenBudget},
+        stores.UsageLimit{Scope: res.scopes[1], Budget: e.dailyTokenBudget},
+    )
+    var limitErr *stores.UsageLimitError
+    if errors.As(err, &limitErr) {
+        if limitErr.Scope == res.scopes[0] {
+            return reservation{}, fmt.Errorf("%w: session %s used %d of %d tokens", ErrBudgetExceeded, sessionID, limitErr.Used, limitErr.Budget)
+        }
+        return reservation{}, fmt.Errorf("%w: %d of %d daily tokens used", ErrBudgetExceeded, limitErr.Used, limitErr.Budget)
+    }
+    if err != nil {
+        return reservation{}, fmt.Errorf("failed to reserve tokens: %w", err)
+    }
+    return res, nil
+}
+
+// recordUsage adds the usage of a completion to the scopes of its reservation,
+// less the tokens reserved for it. A failed completion records a zero usage,
+// giving the reserved tokens back.
+func (e *Engine) recordUsage(res reservation, usage llm.Usage) error {
+    if usage.TotalTokens == 0 && res.tokens == 0 {
+        return nil
+    }
+    return e.usageStore.Add(db.TokenUsage{
+        PromptTokens:     int64(usage.PromptTokens),
+        CompletionTokens: int64(usage.CompletionTokens),
+        TotalTokens:      int64(usage.TotalTokens) - res.tokens,
+    }, res.scopes...)
+}
+
+// pauseResponse returns the configured pause message as a response fragment the
+// first time a session is refused because of its budget, and nil afterwards.
+// The fragment is authored by the given assistant.
+func (e *Engine) pauseResponse(sessionID id.ID, assistantID id.ID) (*db.Fragment, error) {
+    if e.budgetPauseMessage == "" {
+        return nil, nil
+    }
+
+    // Another call already delivered the pause message
+    paused, err := e.usageStore.Pause(sessionScope(sessionID))
+    if err != nil || !paused {
+        return nil, err
+    }
+
+    return &db.Fragment{
+        ID:        id.New(),
+        ActorID:   assistantID,
+        SessionID: sessionID,
+        Content:   e.budgetPauseMessage,
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+    }, nil
+}
+
+// sessionScope returns the usage scope for a session.
+func sessionScope(sessionID id.ID) string {
+    return "session:" + string(sessionID)
+}
+
+// dailyScope returns the global usage scope for the UTC day of t.
+func dailyScope(t time.Time) string {
+    return "daily:" + t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/options"
+)
+
+// withCompletionUsage makes the completions of env report a usage of tokens
+func withCompletionUsage(env *managertest.TestEnvironment, tokens int) {
+    env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+        return llm.Message{
+            Role:    llm.RoleAssistant,
+            Content: llm.MockResponse,
+            Usage:   llm.Usage{PromptTokens: tokens / 2, CompletionTokens: tokens - tokens/2, TotalTokens: tokens},
+        }, nil
+    }
+}
+
+func TestGenerateResponseBudget(t *testing.T) {
+    tests := []struct {
+        name string
+        opts []options.Option[Engine]
+        // want are the outcomes of successive responses: the content of the
+        // response, or "" for ErrBudgetExceeded
+        want []string
+    }{
+        {
+            name: "unlimited",
+            want: []string{llm.MockResponse, llm.MockResponse, llm.MockResponse},
+        },
+        {
+            name: "session budget",
+            opts: []options.Option[Engine]{WithSessionTokenBudget(150)},
+            want: []string{llm.MockResponse, llm.MockResponse, "", ""},
+        },
+        {
+            name: "daily budget",
+            opts: []options.Option[Engine]{WithDailyTokenBudget(100)},
+            want: []string{llm.MockResponse, "", ""},
+        },
+        {
+            name: "pause message",
+            opts: []options.Option[Engine]{WithSessionTokenBudget(100), WithBudgetPauseMessage("Taking a break")},
+            want: []string{llm.MockResponse, "Taking a break", "", ""},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t, tt.opts...)
+            withCompletionUsage(env, 100)
+            session := env.NewSession()
+            messages := []llm.Message{{Role: llm.RoleUser, Content: "Hello"}}
+
+            for i, want := range tt.want {
+                response, err := e.GenerateResponse(messages, session.ID)
+                if want == "" {
+                    if !errors.Is(err, ErrBudgetExceeded) {
+                        t.Errorf("response %d: error = %v, want ErrBudgetExceeded", i, err)
+                    }
+                    continue
+                }
+                if err != nil {
+                    t.Fatalf("response %d failed: %v", i, err)
+                }
+                if response.Content != want {
+                    t.Errorf("response %d = %q, want %q", i, response.Content, want)
+                }
+            }
+        })
+    }
+}
+
+func TestGenerateResponseRecordsUsage(t *testing.T) {
+    e, env := newTestEngine(t, WithSessionTokenBudget(1000))
+    session := env.NewSession()
+    messages := []llm.Message{{Role: llm.RoleUser, Content: "Hello there, how are you?"}}
+
+    // A failed completion gives its reserved tokens back
+    env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+        return llm.Message{}, errors.New("provider down")
+    }
+    if _, err := e.GenerateResponse(messages, session.ID); err == nil {
+        t.Fatal("GenerateResponse succeeded with a failing provider")
+    }
+    usage, err := e.GetSessionUsage(session.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if usage.TotalTokens != 0 {
+        t.Errorf("total after a failure = %d, want 0", usage.TotalTokens)
+    }
+
+    // A completion records its actual usage in place of the reservation
+    withCompletionUsage(env, 40)
+    if _, err := e.GenerateResponse(messages, session.ID); err != nil {
+        t.Fatal(err)
+    }
+    for name, get := range map[string]func() (int64, error){
+        "session": func() (int64, error) {
+            usage, err := e.GetSessionUsage(session.ID)
+            return usage.TotalTokens, err
+        },
+        "daily": func() (int64, error) {
+            usage, err := e.GetDailyUsage()
+            return usage.TotalTokens, err
+        },
+    } {
+        total, err := get()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if total != 40 {
+            t.Errorf("%s total = %d, want 40", name, total)
+        }
+    }
+}
+
+func TestGenerateResponseBudgetConcurrent(t *testing.T) {
+    e, env := newTestEngine(t, WithDailyTokenBudget(100))
+    withCompletionUsage(env, 100)
+    // The prompt is estimated at 60 tokens
+    messages := []llm.Message{{Role: llm.RoleUser, Content: strings.Repeat("word ", 48)}}
+
+    var (
+        wg        sync.WaitGroup
+        responses atomic.Int64
+    )
+    for i := 0; i < 10; i++ {
+        session := env.NewSession()
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := e.GenerateResponse(messages, session.ID)
+            if err != nil && !errors.Is(err, ErrBudgetExceeded) {
+                t.Error(err)
+                return
+            }
+            if err == nil {
+                responses.Add(1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    // Each response reserves its prompt before the completion, so however the
+    // calls interleave the budget admits two of them at most
+    if got := responses.Load(); got < 1 || got > 2 {
+        t.Errorf("responses = %d, want 1 or 2", got)
+    }
+    usage, err := e.GetDailyUsage()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if usage.TotalTokens != responses.Load()*100 {
+        t.Errorf("total = %d, want %d", usage.TotalTokens, responses.Load()*100)
+    }
+}
+
+func TestDailyBudgetTenants(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    withCompletionUsage(env, 100)
+    messages := []llm.Message{{Role: llm.RoleUser, Content: "Hello"}}
+
+    // The engines share the stores of env, each with an assistant of its tenant
+    engineA, _ := newTestEngineWithEnv(t, env, WithTenant(id.New()), WithIdentifier(id.New(), "Thor"), WithDailyTokenBudget(100))
+    engineB, _ := newTestEngineWithEnv(t, env, WithTenant(id.New()), WithIdentifier(id.New(), "Thor"), WithDailyTokenBudget(100))
+
+    tests := []struct {
+        name   string
+        engine *Engine
+        want   error
+    }{
+        {name: "first tenant", engine: engineA},
+        {name: "first tenant again", engine: engineA, want: ErrBudgetExceeded},
+        {name: "other tenant", engine: engineB},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := tt.engine.GenerateResponse(messages, id.New())
+            if !errors.Is(err, tt.want) {
+                t.Errorf("error = %v, want %v", err, tt.want)
+            }
+        })
+    }
+}
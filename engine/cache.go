@@ -0,0 +1,72 @@
+package engine
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/stores"
+
+    "gorm.io/gorm"
+)
+
+// StoreCacheStats holds the lookups of the engine's caching stores
+type StoreCacheStats struct {
+    Actors    stores.CacheStats
+    Sessions  stores.CacheStats
+    Fragments stores.CacheStats
+}
+
+// StoreCacheStats returns the lookups of the engine's stores, all zero unless
+// they cache, see WithStoreCache
+func (e *Engine) StoreCacheStats() StoreCacheStats {
+    return StoreCacheStats{
+        Actors:    e.actorStore.CacheStats(),
+        Sessions:  e.sessionStore.CacheStats(),
+        Fragments: e.interactionFragmentStore.CacheStats(),
+    }
+}
+
+// cacheStores makes the engine's stores cache their lookups in the store cache
+func (e *Engine) cacheStores() {
+    e.actorStore = e.actorStore.WithCache(e.storeCache, e.storeNotFoundTTL)
+    e.sessionStore = e.sessionStore.WithCache(e.storeCache, e.storeNotFoundTTL)
+    e.interactionFragmentStore = e.interactionFragmentStore.WithCache(e.storeCache, e.storeNotFoundTTL)
+}
+
+// getActor returns an actor. It is read outside the transaction of the phase,
+// so that the actor store's cache answers it if the engine has one, see
+// WithStoreCache; the store drops the actors it writes from its cache. Each
+// call returns its own copy, so callers may modify it.
+func (e *Engine) getActor(actorID id.ID) (*db.Actor, error) {
+    return retryStore(e, nil, func() (*db.Actor, error) {
+        return e.actorStore.GetByID(actorID)
+    })
+}
+
+// getSession returns a session, read outside the transaction of the phase like
+// getActor. Each call returns its own copy, so callers may modify it.
+func (e *Engine) getSession(sessionID id.ID) (*db.Session, error) {
+    return retryStore(e, nil, func() (*db.Session, error) {
+        return e.sessionStore.GetByID(sessionID)
+    })
+}
+
+// createSession creates a missing session in tx and returns it.
+func (e *Engine) createSession(tx *gorm.DB, sessionID id.ID) (*db.Session, error) {
+    if err := e.retryStoreWrite(tx, func() error {
+        return e.sessionStore.WithTx(tx).Touch(sessionID)
+    }); err != nil {
+        return nil, fmt.Errorf("failed to create session: %w", err)
+    }
+
+    // Dry runs don't write the session, so there is nothing to read back
+    if e.dryRun {
+        return &db.Session{ID: sessionID, Metadata: db.Metadata{}, LastActivityAt: time.Now()}, nil
+    }
+    // The session isn't committed yet, so it is read back in tx
+    return retryStore(e, tx, func() (*db.Session, error) {
+        return e.sessionStore.WithTx(tx).GetByID(sessionID)
+    })
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+    "testing"
+    "time"
+
+    "github.com/velumlabs/thor/cache"
+    "github.com/velumlabs/thor/options"
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+)
+
+func TestWithStoreCache(t *testing.T) {
+    c, err := cache.NewCache(cache.WithCleanupPeriod(0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(c.Close)
+    e, env := newTestEngine(t, WithStoreCache(c, 0))
+
+    user := env.NewActor("Alice", false)
+    session := env.NewSession()
+    for _, content := range []string{"Hello", "Again"} {
+        if err := e.Process(env.NewState(env.NewFragment(user, session, content))); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // The actor is looked up once per input, and only read the first time
+    stats := e.StoreCacheStats()
+    if want := (stores.CacheStats{Hits: 1, Misses: 1}); stats.Actors != want {
+        t.Errorf("actor lookups = %+v, want %+v", stats.Actors, want)
+    }
+    if stats.Sessions.Misses == 0 {
+        t.Errorf("session lookups = %+v, want the session read through the cache", stats.Sessions)
+    }
+
+    // Writes through the engine are seen by its next lookups
+    if err := e.UpsertActor(user.ID, "Alicia", false); err != nil {
+        t.Fatal(err)
+    }
+    actor, err := e.getActor(user.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if actor.Name != "Alicia" {
+        t.Errorf("actor name = %q after UpsertActor, want Alicia", actor.Name)
+    }
+}
+
+func TestWithStoreCacheOption(t *testing.T) {
+    c, err := cache.NewCache(cache.WithCleanupPeriod(0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(c.Close)
+
+    tests := []struct {
+        name  string
+        cache *cache.Cache
+        ttl   time.Duration
+        valid bool
+    }{
+        {name: "cache", cache: c, ttl: time.Second, valid: true},
+        {name: "no cache"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e := &Engine{}
+            err := WithStoreCache(tt.cache, tt.ttl)(e)
+            if (err == nil) != tt.valid {
+                t.Fatalf("WithStoreCache = %v, want valid %v", err, tt.valid)
+            }
+            if tt.valid && (e.storeCache != tt.cache || e.storeNotFoundTTL != tt.ttl) {
+                t.Errorf("store cache = %p with TTL %s, want %p with %s", e.storeCache, e.storeNotFoundTTL, tt.cache, tt.ttl)
+            }
+        })
+    }
+}
+
+func BenchmarkProcessStoreCache(b *testing.B) {
+    tests := []struct {
+        name   string
+        cached bool
+    }{
+        {name: "uncached"},
+        {name: "cached", cached: true},
+    }
+
+    for _, tt := range tests {
+        b.Run(tt.name, func(b *testing.B) {
+            var opts []options.Option[Engine]
+            if tt.cached {
+                c, err := cache.NewCache(cache.WithCleanupPeriod(0))
+                if err != nil {
+                    b.Fatal(err)
+                }
+                b.Cleanup(c.Close)
+                opts = append(opts, WithStoreCache(c, 0))
+            }
+            e, env := newTestEngine(b, opts...)
+
+            // A hot conversation, whose actor and session every input looks up
+            user := env.NewActor("Alice", false)
+            session := env.NewSession()
+            inputs := make([]*state.State, b.N)
+            for i := range inputs {
+                inputs[i] = env.NewState(env.NewFragment(user, session, "Hello"))
+            }
+
+            b.ResetTimer()
+            for _, input := range inputs {
+                if err := e.Process(input); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.StopTimer()
+
+            // Without the cache every lookup reads the stores. The session is
+            // still read once per input, since Process touches it.
+            if tt.cached {
+                stats := e.StoreCacheStats()
+                n := float64(b.N)
+                b.ReportMetric(float64(stats.Actors.Hits+stats.Actors.Misses)/n, "actor-lookups/op")
+                b.ReportMetric(float64(stats.Actors.Misses)/n, "actor-reads/op")
+                b.ReportMetric(float64(stats.Sessions.Hits+stats.Sessions.Misses)/n, "session-lookups/op")
+                b.ReportMetric(float64(stats.Sessions.Misses)/n, "session-reads/op")
+            }
+        })
+    }
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/state"
+)
+
+// ContextConflict reports a state data key returned by more than one manager
+// during CollectContext. The first manager keeps the key.
+type ContextConflict struct {
+    Key      state.StateDataKey
+    Managers []manager.ManagerID // Managers returning the key, in execution order
+}
+
+// CollectContext asks every manager for its context data, in manager order, and
+// adds it to the state's manager data, owned by the returning manager, so
+// prompts can use it. Managers that don't declare the context capability or are
+// disabled are skipped. When several managers return the same key, the first
+// one in manager order wins and the clash is returned as a conflict; with the
+// state's strict keys, the clash and data for a key another manager already
+// holds in the state instead fail the later manager with a
+// state.KeyConflictError. Data breaking the state data contract of its
+// manager or of a manager requiring it is discarded and reported as a failure of
+// the returning manager, naming both. Manager errors are handled according to
+// the engine's failure policy.
+func (e *Engine) CollectContext(ctx context.Context, currentState *state.State) ([]ContextConflict, error) {
+    contracts := e.snapshotStateContracts()
+    owners := make(map[state.StateDataKey][]manager.ManagerID)
+    var keys []state.StateDataKey
+
+    err := e.runPhase(PhaseContext, currentState, func() error {
+        return e.executeManagersInOrder(currentState, func(m manager.Manager) error {
+            if !manager.Supports(m, manager.CapabilityContext) || !e.managerEnabled(m, PhaseContext) {
+                return nil
+            }
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+
+            var data []state.StateData
+            if err := e.runManager(ctx, m, PhaseContext, currentState, func(_ context.Context, s *state.State) error {
+                var err error
+                data, err = m.Context(s)
+                return err
+            }); err != nil {
+                return err
+            }
+
+            for _, item := range data {
+                if err := contracts.check(m.GetID(), item); err != nil {
+                    return err
+                }
+            }
+
+            var added []state.StateData
+            for _, item := range data {
+                item.Owner = string(m.GetID())
+                if previous, exists := owners[item.Key]; !exists {
+                    keys = append(keys, item.Key)
+                    added = append(added, item)
+                } else if currentState.StrictKeys() {
+                    return &state.KeyConflictError{
+                        Key:           item.Key,
+                        Owner:         item.Owner,
+                        PreviousOwner: string(previous[0]),
+                    }
+                }
+                owners[item.Key] = append(owners[item.Key], m.GetID())
+            }
+            return currentState.MergeManagerData(added)
+        })
+    })
+
+    var conflicts []ContextConflict
+    for _, key := range keys {
+        if managers := owners[key]; len(managers) > 1 {
+            conflicts = append(conflicts, ContextConflict{
+                Key:      key,
+                Managers: managers,
+            })
+        }
+    }
+
+    if err != nil {
+        return conflicts, fmt.Errorf("failed to collect manager context: %w", err)
+    }
+    return conflicts, nil
+}
+
+// collectReplyContext runs CollectContext for Reply, logging conflicts.
+func (e *Engine) collectReplyContext(ctx context.Context, currentState *state.State) error {
+    conflicts, err := e.CollectContext(ctx, currentState)
+    for _, conflict := range conflicts {
+        e.logger.WithFields(map[string]interface{}{
+            "key":      conflict.Key,
+            "managers": conflict.Managers,
+        }).Warn("Several managers returned the same context key")
+    }
+    return err
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+    "fmt"
+
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/state"
+)
+
+// stateContracts indexes the state data contracts declared by the registered
+// managers, so CollectContext can check returned values against them.
+type stateContracts struct {
+    provided map[state.StateDataKey][]contractParty
+    required map[state.StateDataKey][]contractParty
+}
+
+// contractParty is a manager declaring a contract.
+type contractParty struct {
+    manager  manager.ManagerID
+    contract manager.StateDataContract
+}
+
+// buildStateContracts indexes the contracts of a set of managers, validating
+// that every required key is provided by some manager with a compatible type.
+// Returns an error naming the requiring and providing managers otherwise.
+func buildStateContracts(managers []manager.Manager) (*stateContracts, error) {
+    contracts := &stateContracts{
+        provided: make(map[state.StateDataKey][]contractParty),
+        required: make(map[state.StateDataKey][]contractParty),
+    }
+
+    for _, m := range managers {
+        for _, contract := range manager.ProvidedKeys(m) {
+            contracts.provided[contract.Key] = append(contracts.provided[contract.Key], contractParty{m.GetID(), contract})
+        }
+        for _, contract := range manager.RequiredKeys(m) {
+            contracts.required[contract.Key] = append(contracts.required[contract.Key], contractParty{m.GetID(), contract})
+        }
+    }
+
+    for _, m := range managers {
+        for _, required := range manager.RequiredKeys(m) {
+            providers := contracts.provided[required.Key]
+            if len(providers) == 0 {
+                return nil, fmt.Errorf("manager %s requires state data %s which no manager provides: %w", m.GetID(), required, manager.ErrContractViolation)
+            }
+            for _, provider := range providers {
+                if err := provider.contract.Satisfies(required); err != nil {
+                    return nil, fmt.Errorf("manager %s requires state data %s from manager %s: %w", m.GetID(), required, provider.manager, err)
+                }
+            }
+        }
+    }
+
+    return contracts, nil
+}
+
+// check returns an error if a value a manager returned from Context doesn't
+// match its own contract for the key or the contract of a manager requiring it.
+func (c *stateContracts) check(providerID manager.ManagerID, item state.StateData) error {
+    if c == nil {
+        return nil
+    }
+
+    for _, provider := range c.provided[item.Key] {
+        if provider.manager != providerID {
+            continue
+        }
+        if err := provider.contract.Check(item.Value); err != nil {
+            return fmt.Errorf("manager %s returned state data breaking its own contract: %w", providerID, err)
+        }
+    }
+
+    for _, requirer := range c.required[item.Key] {
+        if err := requirer.contract.Check(item.Value); err != nil {
+            return fmt.Errorf("manager %s returned state data breaking the contract of manager %s: %w", providerID, requirer.manager, err)
+        }
+    }
+
+    return nil
+}
+
+// snapshotStateContracts returns the current contract index.
+func (e *Engine) snapshotStateContracts() *stateContracts {
+    e.managersMu.RLock()
+    defer e.managersMu.RUnlock()
+    return e.stateContracts
+}
@@ -0,0 +1,40 @@
+// Package engine provides the conversation engine that runs inputs through
+// managers, generates responses with the LLM and stores the interaction.
+//
+// Reply runs the whole pipeline in one call:
+//
+//	eng, err := engine.New(
+//	    engine.WithContext(ctx),
+//	    engine.WithDB(database),
+//	    engine.WithLogger(log),
+//	    engine.WithIdentifier(assistantID, "Thor"),
+//	    engine.WithActorStore(stores.NewActorStore(ctx, database)),
+//	    engine.WithSessionStore(stores.NewSessionStore(ctx, database)),
+//	    engine.WithInteractionFragmentStore(stores.NewFragmentStore(ctx, database, db.FragmentTableInteraction)),
+//	    engine.WithLLMClient(llmClient),
+//	    engine.WithManagers(personalityManager, insightManager),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	input := &db.Fragment{
+//	    ID:        id.New(),
+//	    ActorID:   userID,
+//	    SessionID: sessionID,
+//	    Content:   "What did we talk about yesterday?",
+//	    Actor:     &db.Actor{ID: userID, Name: "alice"},
+//	}
+//
+//	response, err := eng.Reply(ctx, input, func(builder *state.PromptBuilder) error {
+//	    builder.
+//	        AddSystemSection("You are Thor, talking to {{.Input.Actor.Name}}.").
+//	        WithManagerData(insight.InsightsKey).
+//	        AddUserSection("{{.Input.Content}}", "alice")
+//	    return nil
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	fmt.Println(response.Content)
+package engine
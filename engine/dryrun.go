@@ -0,0 +1,72 @@
+package engine
+
+import (
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/state"
+)
+
+// DryRunWritesKey is the state custom data key holding the []DryRunWrite
+// collected in dry-run mode
+const DryRunWritesKey = "dry_run_writes"
+
+// dryRunMetadataKey marks response fragments generated in dry-run mode
+const dryRunMetadataKey = "dry_run"
+
+// DryRunWrite is a write the engine skipped in dry-run mode
+type DryRunWrite struct {
+    Operation string      // e.g. "upsert_fragment" or "touch_session"
+    Value     interface{} // The fragment or ID that would have been written
+}
+
+// enableDryRun replaces the engine's stores with no-op copies and enables
+// dry-run on the registered managers.
+func (e *Engine) enableDryRun() {
+    e.actorStore = e.actorStore.DryRun()
+    e.sessionStore = e.sessionStore.DryRun()
+    e.interactionFragmentStore = e.interactionFragmentStore.DryRun()
+
+    for _, m := range e.managers {
+        e.enableManagerDryRun(m)
+    }
+}
+
+// enableManagerDryRun enables dry-run on a manager if the engine runs in dry-run mode.
+func (e *Engine) enableManagerDryRun(m manager.Manager) {
+    if !e.dryRun {
+        return
+    }
+    if runner, ok := manager.Unwrap(m).(manager.DryRunner); ok {
+        runner.EnableDryRun()
+        return
+    }
+    e.logger.WithField("manager", m.GetID()).Warn("Manager does not support dry-run, its writes will persist")
+}
+
+// recordDryRunWrite adds a skipped write to the state in dry-run mode.
+func (e *Engine) recordDryRunWrite(currentState *state.State, operation string, value interface{}) {
+    if !e.dryRun {
+        return
+    }
+
+    var writes []DryRunWrite
+    if existing, ok := currentState.GetCustomData(DryRunWritesKey); ok {
+        writes, _ = existing.([]DryRunWrite)
+    }
+    currentState.AddCustomData(DryRunWritesKey, append(writes, DryRunWrite{
+        Operation: operation,
+        Value:     value,
+    }))
+}
+
+// markDryRun marks the metadata of a newly generated response in dry-run mode.
+func (e *Engine) markDryRun(metadata db.Metadata) {
+    if e.dryRun {
+        metadata[dryRunMetadataKey] = true
+    }
+}
+
+// isDryRunFragment reports whether a fragment was generated in dry-run mode.
+func isDryRunFragment(fragment *db.Fragment) bool {
+    return fragment.Metadata.GetBool(dryRunMetadataKey)
+}
@@ -0,0 +1,209 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+
+    "github.com/pgvector/pgvector-go"
+)
+
+// EmbeddingMode determines how GenerateResponse embeds responses
+type EmbeddingMode int
+
+const (
+    // EmbedSync embeds the response before GenerateResponse returns
+    EmbedSync EmbeddingMode = iota
+    // EmbedAsync returns the response with a zero vector embedding and fills it
+    // in the background once the response has been stored by PostProcess
+    EmbedAsync
+    // EmbedSkip stores responses without an embedding
+    EmbedSkip
+)
+
+// EmbeddingHook observes the completion of an asynchronous embedding.
+// err is nil if the embedding was stored.
+type EmbeddingHook func(fragmentID id.ID, err error)
+
+const (
+    // pendingEmbeddingTTL is how long a computed embedding waits for its
+    // response to be stored before it is dropped
+    pendingEmbeddingTTL = 10 * time.Minute
+
+    // embeddingBackfillBatchSize is the number of fragments loaded at a time
+    // when backfilling pending embeddings
+    embeddingBackfillBatchSize = 100
+)
+
+// pendingEmbedding tracks an asynchronous embedding until it is both computed
+// and its response stored. Whichever happens last writes the embedding.
+type pendingEmbedding struct {
+    vector pgvector.Vector
+    ready  bool
+    stored bool
+}
+
+// pendingEmbeddings holds the asynchronous embeddings of an engine.
+type pendingEmbeddings struct {
+    mu      sync.Mutex
+    entries map[id.ID]*pendingEmbedding
+}
+
+// BackfillPendingEmbeddings computes and stores the embeddings of all fragments
+// still holding the zero vector, e.g. because the process stopped before an
+// asynchronous embedding completed. Each embedding is stored at most once, even
+// if several engines backfill concurrently. Failures are reported to the
+// embedding hook and don't stop the backfill.
+func (e *Engine) BackfillPendingEmbeddings(ctx context.Context) error {
+    store := e.interactionFragmentStore.WithContext(ctx)
+
+    var (
+        lastCreatedAt time.Time
+        lastID        id.ID
+    )
+
+    for {
+        fragments, err := store.GetPendingEmbeddings(lastCreatedAt, lastID, embeddingBackfillBatchSize)
+        if err != nil {
+            return fmt.Errorf("failed to backfill embeddings: %w", err)
+        }
+
+        for _, fragment := range fragments {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if e.isEmbeddingPending(fragment.ID) {
+                continue
+            }
+
+            vector, err := e.llmClient.EmbedText(fragment.Content)
+            if err != nil {
+                e.completeEmbedding(fragment.ID, fmt.Errorf("failed to create embedding: %w", err))
+                continue
+            }
+            e.storePendingEmbedding(fragment.ID, pgvector.NewVector(vector))
+        }
+
+        if len(fragments) < embeddingBackfillBatchSize {
+            return nil
+        }
+        last := fragments[len(fragments)-1]
+        lastCreatedAt, lastID = last.CreatedAt, last.ID
+    }
+}
+
+// responseEmbedding returns the embedding of a new response according to the
+// engine's embedding mode.
+func (e *Engine) responseEmbedding(fragmentID id.ID, content string) (pgvector.Vector, error) {
+    switch e.embeddingMode {
+    case EmbedSkip:
+        return pgvector.Vector{}, nil
+    case EmbedAsync:
+        e.pending.mu.Lock()
+        e.pending.entries[fragmentID] = &pendingEmbedding{}
+        e.pending.mu.Unlock()
+
+        go e.computePendingEmbedding(fragmentID, content)
+
+        return pgvector.NewVector(make([]float32, db.EmbeddingDimensions)), nil
+    default:
+        embedding, err := e.llmClient.EmbedText(content)
+        if err != nil {
+            return pgvector.Vector{}, fmt.Errorf("failed to create embedding for response: %v", err)
+        }
+        return pgvector.NewVector(embedding), nil
+    }
+}
+
+// computePendingEmbedding computes an asynchronous embedding and stores it if
+// its response has already been stored.
+func (e *Engine) computePendingEmbedding(fragmentID id.ID, content string) {
+    embedding, err := e.llmClient.EmbedText(content)
+
+    e.pending.mu.Lock()
+    entry, ok := e.pending.entries[fragmentID]
+    if !ok {
+        e.pending.mu.Unlock()
+        return
+    }
+    if err != nil {
+        // The zero vector stays in place for BackfillPendingEmbeddings
+        delete(e.pending.entries, fragmentID)
+        e.pending.mu.Unlock()
+        e.completeEmbedding(fragmentID, fmt.Errorf("failed to create embedding: %w", err))
+        return
+    }
+    entry.vector = pgvector.NewVector(embedding)
+    entry.ready = true
+    store := entry.stored
+    if store {
+        delete(e.pending.entries, fragmentID)
+    }
+    e.pending.mu.Unlock()
+
+    if store {
+        e.storePendingEmbedding(fragmentID, entry.vector)
+        return
+    }
+
+    // Drop the embedding if the response is never stored
+    time.AfterFunc(pendingEmbeddingTTL, func() {
+        e.pending.mu.Lock()
+        defer e.pending.mu.Unlock()
+        if current, ok := e.pending.entries[fragmentID]; ok && current == entry && !entry.stored {
+            delete(e.pending.entries, fragmentID)
+        }
+    })
+}
+
+// resolvePendingEmbedding is called once a response has been stored and stores
+// its asynchronous embedding if it has already been computed.
+func (e *Engine) resolvePendingEmbedding(fragmentID id.ID) {
+    e.pending.mu.Lock()
+    entry, ok := e.pending.entries[fragmentID]
+    if !ok {
+        e.pending.mu.Unlock()
+        return
+    }
+    entry.stored = true
+    store := entry.ready
+    if store {
+        delete(e.pending.entries, fragmentID)
+    }
+    e.pending.mu.Unlock()
+
+    if store {
+        e.storePendingEmbedding(fragmentID, entry.vector)
+    }
+}
+
+// storePendingEmbedding replaces the zero vector of a stored fragment.
+func (e *Engine) storePendingEmbedding(fragmentID id.ID, embedding pgvector.Vector) {
+    if _, err := e.interactionFragmentStore.UpdatePendingEmbedding(fragmentID, embedding); err != nil {
+        e.completeEmbedding(fragmentID, err)
+        return
+    }
+    e.completeEmbedding(fragmentID, nil)
+}
+
+// completeEmbedding logs a failed asynchronous embedding and reports it to the hook.
+func (e *Engine) completeEmbedding(fragmentID id.ID, err error) {
+    if err != nil {
+        e.logger.WithError(err).WithField("fragment", fragmentID).Warn("Failed to backfill embedding")
+    }
+    if e.embeddingHook != nil {
+        e.embeddingHook(fragmentID, err)
+    }
+}
+
+// isEmbeddingPending reports whether an asynchronous embedding of the fragment is in progress.
+func (e *Engine) isEmbeddingPending(fragmentID id.ID) bool {
+    e.pending.mu.Lock()
+    defer e.pending.mu.Unlock()
+    _, ok := e.pending.entries[fragmentID]
+    return ok
+}
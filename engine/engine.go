@@ -1,78 +1,280 @@
 package engine
 
 import (
+    "context"
+    "errors"
     "fmt"
+    "sync"
+    "sync/atomic"
     "time"
 
     "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/events"
     "github.com/velumlabs/thor/id"
     "github.com/velumlabs/thor/llm"
     "github.com/velumlabs/thor/manager"
     "github.com/velumlabs/thor/options"
     "github.com/velumlabs/thor/state"
-    toolkit "github.com/velumlabs/toolkit/go"
+    "github.com/velumlabs/thor/stores"
     "github.com/pgvector/pgvector-go"
     "golang.org/x/sync/errgroup"
+    "gorm.io/gorm"
 )
 
+// ErrManagerTimeout is returned when a manager does not finish within its timeout.
+var ErrManagerTimeout = errors.New("manager timed out")
+
+// ErrAlreadyProcessed is returned by Process under idempotent processing when the
+// input has already been stored.
+var ErrAlreadyProcessed = errors.New("input already processed")
+
 // New creates a new Engine instance with the provided options.
-// Returns an error if required fields are missing or if actor creation fails.
+// Returns an error if required fields are missing, if the managers' state data
+// contracts are unsatisfied or if actor creation fails.
 func New(opts ...options.Option[Engine]) (*Engine, error) {
-    e := &Engine{}
+    e := &Engine{
+        metrics:               newMetricsRecorder(),
+        queueSize:             defaultQueueSize,
+        scheduleLateThreshold: defaultScheduleLateThreshold,
+    }
     if err := options.ApplyOptions(e, opts...); err != nil {
         return nil, fmt.Errorf("failed to create engine: %w", err)
     }
 
-    if err := e.upsertActor(e.ID, e.Name, true); err != nil {
-        return nil, fmt.Errorf("failed to upsert actor: %w", err)
+    if e.storeCache != nil {
+        e.cacheStores()
+    }
+    if e.tenant != "" {
+        e.scopeToTenant()
+    }
+    if e.scheduleStore == nil {
+        e.scheduleStore = stores.NewScheduleStore(e.ctx, e.db)
+    }
+    if e.usageStore == nil {
+        e.usageStore = e.sessionStore.Usage()
+    }
+
+    if len(e.managerMiddleware) > 0 {
+        wrapped := make([]manager.Manager, len(e.managers))
+        for i, m := range e.managers {
+            wrapped[i] = e.wrapManager(m)
+        }
+        e.managers = wrapped
+    }
+
+    for _, m := range e.managers {
+        e.scopeManagerToTenant(m)
+        e.injectResolver(m)
+        e.injectLLMRecorder(m)
+    }
+
+    e.queue = newInputQueue(e.queueSize)
+    e.pending = &pendingEmbeddings{entries: make(map[id.ID]*pendingEmbedding)}
+
+    if e.dryRun {
+        e.enableDryRun()
+    }
+
+    if e.eventBus == nil {
+        bus, err := events.NewBus(events.WithLogger(e.logger), events.WithDeliveryPolicy(e.eventDelivery))
+        if err != nil {
+            return nil, err
+        }
+        e.eventBus = bus
+    }
+
+    contracts, err := buildStateContracts(e.managers)
+    if err != nil {
+        return nil, fmt.Errorf("failed to validate manager contracts: %w", err)
+    }
+    e.stateContracts = contracts
+
+    if err := e.refreshManagerOrder(e.managers); err != nil {
+        return nil, fmt.Errorf("failed to order managers: %w", err)
+    }
+
+    if err := e.RegisterAssistant(e.ID, e.Name, llm.ModelTypeDefault); err != nil {
+        return nil, err
+    }
+
+    if e.embeddingMode == EmbedAsync {
+        // Complete embeddings left pending by a previous run
+        go func() {
+            if err := e.BackfillPendingEmbeddings(e.ctx); err != nil && e.ctx.Err() == nil {
+                e.logger.WithError(err).Error("Failed to backfill pending embeddings")
+            }
+        }()
     }
 
     return e, nil
 }
 
 // Process handles the processing of a new input through the runtime pipeline:
-// 1. Retrieves actor and session information
+// 1. Retrieves actor and session information, creating the session if it doesn't exist
+//    and recording the actor as a participant of the session
 // 2. Creates a copy of the input fragment
-// 3. Executes all managers in parallel
-// 4. Stores the processed input
-// Returns an error if any step fails.
+// 3. Loads recent and relevant interactions into the state, if enabled
+// 4. Executes all managers in parallel, or in dependency stages if staged processing is enabled
+// 5. Stores the processed input and records activity on its session
+// Returns an error if any step fails, and ErrSessionClosed for inputs of a closed
+// session. Under the ContinueAndCollect failure policy
+// the input is stored even if managers fail, and their errors are returned joined.
+// With idempotent processing, inputs that were already stored are skipped according
+// to the engine's duplicate policy. Registered before and after process hooks surround the whole pipeline.
 func (e *Engine) Process(currentState *state.State) error {
+    return e.runPhase(PhaseProcess, currentState, func() error {
+        return e.process(currentState)
+    })
+}
+
+// process implements Process without hooks.
+func (e *Engine) process(currentState *state.State) error {
     input := currentState.Input
 
     e.logger.WithFields(map[string]interface{}{
         "input": input.ID,
     }).Info("Processing input")
 
-    actor, err := e.actorStore.GetByID(input.ActorID)
-    if err != nil {
-        return fmt.Errorf("failed to get actor: %w", err)
+    if e.idempotentProcessing {
+        exists, err := e.interactionFragmentStore.Exists(input.ID)
+        if err != nil {
+            return fmt.Errorf("failed to check for duplicate input: %w", err)
+        }
+        if exists {
+            return e.handleDuplicate(input.ID)
+        }
     }
 
-    session, err := e.sessionStore.GetByID(input.SessionID)
-    if err != nil {
-        return fmt.Errorf("failed to get session: %w", err)
-    }
+    var failures []error
+    if err := e.inTransaction(PhaseProcess, currentState, func(tx *gorm.DB) error {
+        actor, err := e.getActor(input.ActorID)
+        if errors.Is(err, stores.ErrNotFound) {
+            return fmt.Errorf("actor %s was never upserted, call UpsertActor before processing its inputs: %w", input.ActorID, err)
+        }
+        if err != nil {
+            return fmt.Errorf("failed to get actor: %w", err)
+        }
 
-    inputCopy := e.createFragmentCopy(input, actor, session)
+        session, err := e.getSession(input.SessionID)
+        if errors.Is(err, stores.ErrNotFound) {
+            // Sessions start with their first input
+            session, err = e.createSession(tx, input.SessionID)
+        }
+        if err != nil {
+            return fmt.Errorf("failed to get session: %w", err)
+        }
+        if session.IsClosed() {
+            return fmt.Errorf("session %s: %w", session.ID, ErrSessionClosed)
+        }
 
-    currentState.Input = inputCopy
+        inputCopy := e.createFragmentCopy(input, actor, session)
+        if err := e.preprocessInput(inputCopy); err != nil {
+            return err
+        }
 
-    errGroup := new(errgroup.Group)
-    for _, m := range e.managers {
-        m := m // Capture the loop variable
-        errGroup.Go(func() error {
-            return m.Process(currentState)
+        currentState.Input = inputCopy
+
+        if err := e.addParticipant(tx, currentState); err != nil {
+            return err
+        }
+
+        if currentState.Assistant == nil {
+            assistant := e.defaultAssistant()
+            currentState.Assistant = assistant.actor()
+        }
+
+        if err := e.populateInteractions(currentState); err != nil {
+            return fmt.Errorf("failed to load interactions: %w", err)
+        }
+
+        managers, _, stages := e.snapshotManagers()
+
+        for _, stage := range e.processStages(managers, stages) {
+            stageFailures, err := e.processStage(currentState, stage)
+            if err != nil {
+                return fmt.Errorf("failed to execute manager analysis: %w", err)
+            }
+            failures = append(failures, stageFailures...)
+        }
+
+        if err := e.retryStoreWrite(tx, func() error {
+            return e.sessionStore.WithTx(tx).Touch(session.ID)
+        }); err != nil {
+            return err
+        }
+        e.recordDryRunWrite(currentState, "touch_session", session.ID)
+
+        if !e.idempotentProcessing {
+            if err := e.retryStoreWrite(tx, func() error {
+                return e.interactionFragmentStore.WithTx(tx).Upsert(inputCopy)
+            }); err != nil {
+                return fmt.Errorf("failed to store input: %w", err)
+            }
+            e.recordDryRunWrite(currentState, "upsert_fragment", inputCopy)
+            return nil
+        }
+
+        // Insert-if-absent so that concurrent deliveries of the same input
+        // which both passed the existence check are still stored only once
+        inserted, err := retryStore(e, tx, func() (bool, error) {
+            return e.interactionFragmentStore.WithTx(tx).CreateIfNotExists(inputCopy)
         })
+        if err != nil {
+            return fmt.Errorf("failed to store input: %w", err)
+        }
+        if !inserted {
+            // Roll back manager writes of the duplicate when running transactionally
+            return ErrAlreadyProcessed
+        }
+        e.recordDryRunWrite(currentState, "create_fragment", inputCopy)
+
+        return nil
+    }); err != nil {
+        if errors.Is(err, ErrAlreadyProcessed) {
+            return e.handleDuplicate(input.ID)
+        }
+        return err
     }
 
-    if err := errGroup.Wait(); err != nil {
-        return fmt.Errorf("failed to execute manager analysis: %w", err)
+    e.publish(events.InputProcessed{
+        FragmentID:     currentState.Input.ID,
+        SessionID:      currentState.Input.SessionID,
+        ActorID:        currentState.Input.ActorID,
+        FailedManagers: append([]string(nil), currentState.FailedManagers...),
+    })
+
+    if e.autoSessionTitles && !e.dryRun && !currentState.Input.Actor.Assistant && currentState.Input.Session.Title == "" {
+        go e.generateSessionTitle(currentState.Input.SessionID, currentState.Input.Content)
+    }
+
+    if len(failures) > 0 {
+        return fmt.Errorf("failed to execute manager analysis: %w", errors.Join(failures...))
+    }
+
+    return nil
+}
+
+// addParticipant records the input's actor as a participant of its session
+// and loads the session's participants into the state.
+func (e *Engine) addParticipant(tx *gorm.DB, currentState *state.State) error {
+    input := currentState.Input
+    seenAt := input.CreatedAt
+    if seenAt.IsZero() {
+        seenAt = time.Now()
     }
 
-    if err := e.interactionFragmentStore.Upsert(inputCopy); err != nil {
-        return fmt.Errorf("failed to store input: %w", err)
+    if err := e.retryStoreWrite(tx, func() error {
+        return e.sessionStore.WithTx(tx).AddParticipant(input.SessionID, input.ActorID, seenAt)
+    }); err != nil {
+        return fmt.Errorf("failed to add session participant: %w", err)
     }
+    e.recordDryRunWrite(currentState, "add_participant", input.ActorID)
 
+    participants, err := e.sessionStore.WithTx(tx).GetParticipants(input.SessionID)
+    if err != nil {
+        return err
+    }
+    currentState.SetParticipants(participants)
     return nil
 }
 
@@ -81,80 +283,224 @@ func (e *Engine) Process(currentState *state.State) error {
 // 2. Creates a copy of the response fragment
 // 3. Executes all managers in sequence
 // 4. Stores the processed response
-// Returns an error if any step fails.
+// Returns an error if any step fails. Manager failures are handled according
+// to the engine's failure policy, as in Process. Registered before and after
+// post-process hooks surround the whole pipeline.
 func (e *Engine) PostProcess(response *db.Fragment, currentState *state.State) error {
-    actor, err := e.actorStore.GetByID(response.ActorID)
-    if err != nil {
-        return fmt.Errorf("failed to get actor: %w", err)
-    }
+    return e.runPhase(PhasePostProcess, currentState, func() error {
+        return e.postProcess(response, currentState)
+    })
+}
 
-    session, err := e.sessionStore.GetByID(response.SessionID)
-    if err != nil {
-        return fmt.Errorf("failed to get session: %w", err)
-    }
+// postProcess implements PostProcess without hooks.
+func (e *Engine) postProcess(response *db.Fragment, currentState *state.State) error {
+    var managerErr error
+    if err := e.inTransaction(PhasePostProcess, currentState, func(tx *gorm.DB) error {
+        actor, err := e.getActor(response.ActorID)
+        if err != nil {
+            return fmt.Errorf("failed to get actor: %w", err)
+        }
+
+        session, err := e.getSession(response.SessionID)
+        if err != nil {
+            return fmt.Errorf("failed to get session: %w", err)
+        }
+
+        // A response replies to the input it was generated for
+        if response.ParentID == nil && currentState.Input != nil {
+            response.ParentID = cloneID(&currentState.Input.ID)
+        }
 
-    responseCopy := e.createFragmentCopy(response, actor, session)
+        responseCopy := e.createFragmentCopy(response, actor, session)
 
-    currentState.Output = responseCopy
+        currentState.Output = responseCopy
 
-    if err := e.executeManagersInOrder(currentState, func(m manager.Manager) error {
-        return m.PostProcess(currentState)
+        if actor.Assistant {
+            currentState.Assistant = actor
+        }
+
+        managerErr = e.executeManagersInOrder(currentState, func(m manager.Manager) error {
+            if !manager.Supports(m, manager.CapabilityPostProcess) || !e.managerEnabled(m, PhasePostProcess) {
+                return nil
+            }
+            return e.runManager(e.ctx, m, PhasePostProcess, currentState, func(_ context.Context, s *state.State) error {
+                return m.PostProcess(s)
+            })
+        })
+        if managerErr != nil && e.failurePolicy == FailFast {
+            return fmt.Errorf("failed to execute manager actions: %w", managerErr)
+        }
+        mergeManagerMetadata(response, responseCopy)
+
+        store := e.interactionFragmentStore.WithTx(tx)
+        if isDryRunFragment(response) {
+            store = store.DryRun()
+        }
+        if err := e.retryStoreWrite(tx, func() error {
+            return store.Upsert(response)
+        }); err != nil {
+            return fmt.Errorf("failed to store response: %w", err)
+        }
+        e.recordDryRunWrite(currentState, "upsert_fragment", response)
+
+        return nil
     }); err != nil {
-        return fmt.Errorf("failed to execute manager actions: %w", err)
+        return err
     }
 
-    if err := e.interactionFragmentStore.Upsert(response); err != nil {
-        return fmt.Errorf("failed to store response: %w", err)
+    e.resolvePendingEmbedding(response.ID)
+
+    if managerErr != nil {
+        return fmt.Errorf("failed to execute manager actions: %w", managerErr)
     }
 
     return nil
 }
 
 // GenerateResponse creates a new response using the LLM:
-// 1. Generates completion from provided messages
-// 2. Creates embedding for the response
-// 3. Builds response fragment with metadata
-// Returns the response fragment and any error encountered.
-func (e *Engine) GenerateResponse(messages []llm.Message, sessionID id.ID, tools ...toolkit.Tool) (*db.Fragment, error) {
+// 1. Checks the session and daily token budgets
+// 2. Generates completion from provided messages
+// 3. Records the token usage of the completion
+// 4. Creates embedding for the response, unless the embedding mode defers or skips it
+// 5. Builds response fragment with metadata: the caller's WithMetadata keys,
+//    overlaid with the model, token usage, latency and tool calls of the completion,
+//    and the prompt version and hash given with WithPromptInfo
+// Returns the response fragment and any error encountered. Once a budget is
+// used up it returns ErrBudgetExceeded, preceded by a single pause response
+// if one is configured. With a response rate limit it waits for the session
+// or returns ErrRateLimited, depending on the rate limit mode. In dry-run mode
+// budgets are neither checked nor recorded, and the response is marked so
+// PostProcess doesn't persist it.
+func (e *Engine) GenerateResponse(messages []llm.Message, sessionID id.ID, opts ...ResponseOption) (*db.Fragment, error) {
+    var responseOpts ResponseOptions
+    if err := options.ApplyOptions(&responseOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid response options: %w", err)
+    }
+    if err := e.acquireResponse(e.ctx, sessionID); err != nil {
+        return nil, err
+    }
+    return e.generateResponse(e.defaultAssistant(), messages, sessionID, responseOpts)
+}
+
+// GenerateResponseAs is GenerateResponse for a registered assistant: the response
+// is authored by the assistant and generated with its default model type.
+// Returns an error if the assistant is not registered.
+func (e *Engine) GenerateResponseAs(assistantID id.ID, messages []llm.Message, sessionID id.ID, opts ...ResponseOption) (*db.Fragment, error) {
+    var responseOpts ResponseOptions
+    if err := options.ApplyOptions(&responseOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid response options: %w", err)
+    }
+    assistant, err := e.GetAssistant(assistantID)
+    if err != nil {
+        return nil, err
+    }
+    if err := e.acquireResponse(e.ctx, sessionID); err != nil {
+        return nil, err
+    }
+    return e.generateResponse(assistant, messages, sessionID, responseOpts)
+}
+
+// generateResponse implements GenerateResponse for the given assistant.
+func (e *Engine) generateResponse(assistant AssistantProfile, messages []llm.Message, sessionID id.ID, opts ResponseOptions) (*db.Fragment, error) {
+    var res reservation
+    if !e.dryRun {
+        var err error
+        if res, err = e.reserveBudget(sessionID, messages); err != nil {
+            if errors.Is(err, ErrBudgetExceeded) {
+                pause, pauseErr := e.pauseResponse(sessionID, assistant.ID)
+                if pauseErr != nil {
+                    return nil, pauseErr
+                }
+                if pause != nil {
+                    pause.Metadata = opts.Metadata.Clone()
+                    return pause, nil
+                }
+            }
+            return nil, err
+        }
+    }
+
+    start := time.Now()
     response, err := e.llmClient.GenerateCompletion(llm.CompletionRequest{
         Messages:    messages,
-        ModelType:   llm.ModelTypeDefault,
+        ModelType:   assistant.DefaultModelType,
         Temperature: 0.7,
-        Tools:       tools,
+        Tools:       opts.Tools,
     })
     if err != nil {
+        var toolErr *llm.ToolError
+        if errors.As(err, &toolErr) {
+            e.recordToolCalls(sessionID, nil, []llm.ToolCall{toolErr.Call}, toolErr.Err)
+        }
+        if !e.dryRun {
+            if err := e.recordUsage(res, llm.Usage{}); err != nil {
+                e.logger.WithError(err).Warn("Failed to release reserved tokens")
+            }
+        }
         return nil, fmt.Errorf("failed to generate completion: %v", err)
     }
+    latency := time.Since(start)
 
-    embedding, err := e.llmClient.EmbedText(response.Content)
+    if !e.dryRun {
+        if err := e.recordUsage(res, response.Usage); err != nil {
+            e.logger.WithError(err).Warn("Failed to record token usage")
+        }
+    }
+
+    fragmentID := id.New()
+    e.recordToolCalls(sessionID, &fragmentID, response.ToolCalls, nil)
+
+    embedding, err := e.responseEmbedding(fragmentID, response.Content)
     if err != nil {
-        return nil, fmt.Errorf("failed to create embedding for response: %v", err)
+        return nil, err
     }
 
+    e.publish(events.ResponseGenerated{
+        FragmentID:  fragmentID,
+        SessionID:   sessionID,
+        AssistantID: assistant.ID,
+        Model:       response.Model,
+        TotalTokens: response.Usage.TotalTokens,
+    })
+
     return &db.Fragment{
-        ID:        id.New(),
-        ActorID:   e.ID,
+        ID:        fragmentID,
+        ActorID:   assistant.ID,
         SessionID: sessionID,
         Content:   response.Content,
-        Embedding: pgvector.NewVector(embedding),
+        Embedding: embedding,
         CreatedAt: time.Now(),
         UpdatedAt: time.Now(),
-        Metadata:  nil,
+        Metadata:  e.responseMetadata(opts, response, latency),
     }, nil
 }
 
 // StartBackgroundProcesses initiates background processes for all managers.
-// Each manager's background process runs in its own goroutine.
+// Each manager's background process runs in its own goroutine under a context
+// derived from the engine's context. Managers implementing manager.BackgroundRunner
+// have RunBackground called; all others have StartBackgroundProcesses called.
 func (e *Engine) StartBackgroundProcesses() {
+    e.managersMu.Lock()
+    defer e.managersMu.Unlock()
+
+    if e.backgroundRunning {
+        return
+    }
+
+    e.backgroundRunning = true
     for _, m := range e.managers {
-        go m.StartBackgroundProcesses()
+        if e.backgroundPaused(m.GetID()) {
+            continue
+        }
+        e.startBackground(m)
     }
 }
 
-// StopBackgroundProcesses terminates background processes for all managers.
+// StopBackgroundProcesses terminates background processes for all managers and
+// waits for them to finish. Use Shutdown to bound the wait.
 func (e *Engine) StopBackgroundProcesses() {
-    for _, m := range e.managers {
-        m.StopBackgroundProcesses()
+    if err := e.Shutdown(context.Background()); err != nil {
+        e.logger.WithError(err).Error("Failed to stop background processes")
     }
 }
 
@@ -162,8 +508,14 @@ func (e *Engine) StopBackgroundProcesses() {
 // Validates that:
 // 1. The manager ID is not duplicate
 // 2. All manager dependencies are available
+// 3. The state data keys required by the managers are provided with compatible types
 // Returns an error if validation fails.
 func (e *Engine) AddManager(newManager manager.Manager) error {
+    e.managersMu.Lock()
+    defer e.managersMu.Unlock()
+
+    newManager = e.wrapManager(newManager)
+
     for _, m := range e.managers {
         if m.GetID() == newManager.GetID() {
             return fmt.Errorf("duplicate manager with ID %s", newManager.GetID())
@@ -181,19 +533,249 @@ func (e *Engine) AddManager(newManager manager.Manager) error {
         }
     }
 
-    e.managers = append(e.managers, newManager)
+    managers := make([]manager.Manager, 0, len(e.managers)+1)
+    managers = append(managers, e.managers...)
+    managers = append(managers, newManager)
+
+    contracts, err := buildStateContracts(managers)
+    if err != nil {
+        return err
+    }
+    if err := e.refreshManagerOrder(managers); err != nil {
+        return err
+    }
+    e.managers = managers
+    e.stateContracts = contracts
+    e.enableManagerDryRun(newManager)
+    e.scopeManagerToTenant(newManager)
+    e.injectResolver(newManager)
+    e.injectLLMRecorder(newManager)
+
+    if e.backgroundRunning {
+        e.startBackground(newManager)
+    }
+
+    return nil
+}
+
+// RemoveManager removes a manager from the runtime and stops its background processes.
+// Returns an error if the manager is not registered or if another manager depends on it
+// or on state data only it provides.
+// It is safe to call while Process or PostProcess is running; in-flight calls
+// complete with the managers they started with.
+func (e *Engine) RemoveManager(managerID manager.ManagerID) error {
+    e.managersMu.Lock()
+    defer e.managersMu.Unlock()
+
+    var removed manager.Manager
+    managers := make([]manager.Manager, 0, len(e.managers))
+    for _, m := range e.managers {
+        if m.GetID() == managerID {
+            removed = m
+            continue
+        }
+        managers = append(managers, m)
+    }
+
+    if removed == nil {
+        return fmt.Errorf("manager %s not found", managerID)
+    }
+
+    for _, m := range managers {
+        for _, dep := range m.GetDependencies() {
+            if dep == managerID {
+                return fmt.Errorf("manager %s is required by manager %s", managerID, m.GetID())
+            }
+        }
+    }
+
+    contracts, err := buildStateContracts(managers)
+    if err != nil {
+        return err
+    }
+
+    order := make([]manager.ManagerID, 0, len(e.managerOrder))
+    for _, id := range e.managerOrder {
+        if id != managerID {
+            order = append(order, id)
+        }
+    }
+
+    e.managers = managers
+    e.stateContracts = contracts
+    e.managerOrder = order
+    delete(e.disabledManagers, managerID)
+    if err := e.refreshManagerOrder(managers); err != nil {
+        return err
+    }
+
+    if e.backgroundRunning {
+        e.stopBackground(managerID)
+    }
+    go e.closeManagerEvents(e.ctx, removed)
+
+    return nil
+}
+
+// ReplaceManager swaps a registered manager for a new implementation with the same ID.
+// The replaced manager's background processes are stopped and, if the engine's
+// background processes are running, the new manager's are started.
+// Returns an error if no manager with that ID is registered or if the
+// replacement's dependencies are not available or it breaks a state data contract.
+func (e *Engine) ReplaceManager(newManager manager.Manager) error {
+    e.managersMu.Lock()
+    defer e.managersMu.Unlock()
+
+    newManager = e.wrapManager(newManager)
+
+    managerID := newManager.GetID()
+
+    index := -1
+    available := make(map[manager.ManagerID]bool)
+    for i, m := range e.managers {
+        if m.GetID() == managerID {
+            index = i
+            continue
+        }
+        available[m.GetID()] = true
+    }
+
+    if index == -1 {
+        return fmt.Errorf("manager %s not found", managerID)
+    }
+
+    for _, dep := range newManager.GetDependencies() {
+        if !available[dep] {
+            return fmt.Errorf("manager %s requires manager %s which was not provided", managerID, dep)
+        }
+    }
+
+    replaced := e.managers[index]
+    managers := make([]manager.Manager, len(e.managers))
+    copy(managers, e.managers)
+    managers[index] = newManager
+
+    contracts, err := buildStateContracts(managers)
+    if err != nil {
+        return err
+    }
+    if err := e.refreshManagerOrder(managers); err != nil {
+        return err
+    }
+    e.managers = managers
+    e.stateContracts = contracts
+    e.enableManagerDryRun(newManager)
+    e.scopeManagerToTenant(newManager)
+    e.injectResolver(newManager)
+    e.injectLLMRecorder(newManager)
+
+    if e.backgroundRunning {
+        e.stopBackground(managerID)
+        if !e.backgroundPaused(managerID) {
+            e.startBackground(newManager)
+        }
+    }
+    if manager.Unwrap(replaced) != manager.Unwrap(newManager) {
+        go e.closeManagerEvents(e.ctx, replaced)
+    }
+
     return nil
 }
 
+// snapshotManagers returns the current managers, their execution order and
+// dependency stages. The returned slices are never modified in place, so callers
+// may iterate them without holding the lock.
+func (e *Engine) snapshotManagers() ([]manager.Manager, []manager.ManagerID, [][]manager.ManagerID) {
+    e.managersMu.RLock()
+    defer e.managersMu.RUnlock()
+    return e.managers, e.managerOrder, e.managerStages
+}
+
+// processStages groups managers into the stages Process executes one after another.
+// Without staged processing all managers form a single parallel stage.
+func (e *Engine) processStages(managers []manager.Manager, stages [][]manager.ManagerID) [][]manager.Manager {
+    if !e.stagedProcess || len(stages) == 0 {
+        return [][]manager.Manager{managers}
+    }
+
+    managerMap := make(map[manager.ManagerID]manager.Manager, len(managers))
+    for _, m := range managers {
+        managerMap[m.GetID()] = m
+    }
+
+    grouped := make([][]manager.Manager, 0, len(stages))
+    for _, stage := range stages {
+        group := make([]manager.Manager, 0, len(stage))
+        for _, managerID := range stage {
+            if m, exists := managerMap[managerID]; exists {
+                group = append(group, m)
+            }
+        }
+        grouped = append(grouped, group)
+    }
+    return grouped
+}
+
+// processStage runs Process for a group of managers in parallel, bounded by the
+// engine's manager concurrency limit.
+// Under FailFast the first error aborts the stage and is returned as err;
+// otherwise manager errors are recorded on the state and returned as failures.
+func (e *Engine) processStage(currentState *state.State, stage []manager.Manager) (failures []error, err error) {
+    var failuresMu sync.Mutex
+
+    errGroup := new(errgroup.Group)
+    if e.maxConcurrentManagers > 0 {
+        errGroup.SetLimit(e.maxConcurrentManagers)
+    }
+
+    for _, m := range stage {
+        if !manager.Supports(m, manager.CapabilityProcess) || !e.managerEnabled(m, PhaseProcess) {
+            continue
+        }
+        m := m // Capture the loop variable
+        errGroup.Go(func() error {
+            atomic.AddInt64(&e.inFlightManagers, 1)
+            defer atomic.AddInt64(&e.inFlightManagers, -1)
+
+            err := e.runManager(e.ctx, m, PhaseProcess, currentState, func(ctx context.Context, s *state.State) error {
+                return m.Process(ctx, s)
+            })
+            if err == nil {
+                return nil
+            }
+
+            err = fmt.Errorf("manager %s failed: %w", m.GetID(), err)
+            if e.failurePolicy == FailFast {
+                return err
+            }
+
+            failuresMu.Lock()
+            defer failuresMu.Unlock()
+            failures = append(failures, err)
+            currentState.FailedManagers = append(currentState.FailedManagers, string(m.GetID()))
+            return nil
+        })
+    }
+
+    if err := errGroup.Wait(); err != nil {
+        return nil, err
+    }
+
+    return failures, nil
+}
+
 // createFragmentCopy creates a copy of a fragment with provided actor and session data.
+// Metadata and embedding are deep copied, so managers may freely mutate the state's
+// copy without affecting the original fragment.
 func (e *Engine) createFragmentCopy(fragment *db.Fragment, actor *db.Actor, session *db.Session) *db.Fragment {
     return &db.Fragment{
         ID:        fragment.ID,
         ActorID:   fragment.ActorID,
         SessionID: fragment.SessionID,
         Content:   fragment.Content,
-        Metadata:  fragment.Metadata,
-        Embedding: fragment.Embedding,
+        Metadata:  fragment.Metadata.Clone(),
+        Embedding: pgvector.NewVector(append([]float32(nil), fragment.Embedding.Slice()...)),
+        ParentID:  cloneID(fragment.ParentID),
         Actor:     actor,
         Session:   session,
         CreatedAt: fragment.CreatedAt,
@@ -202,32 +784,134 @@ func (e *Engine) createFragmentCopy(fragment *db.Fragment, actor *db.Actor, sess
     }
 }
 
+// wrapManager applies the engine's manager middlewares to a manager.
+func (e *Engine) wrapManager(m manager.Manager) manager.Manager {
+    return manager.Chain(m, e.managerMiddleware...)
+}
+
+// cloneID returns a copy of an optional ID.
+func cloneID(fragmentID *id.ID) *id.ID {
+    if fragmentID == nil {
+        return nil
+    }
+    clone := *fragmentID
+    return &clone
+}
+
 // executeManagersInOrder runs managers in a specified order:
 // 1. Creates a map for quick manager lookup
 // 2. Uses managerOrder if specified, otherwise uses registration order
 // 3. Executes each manager with the provided function
-// Returns an error if any manager execution fails.
+// Returns an error if any manager execution fails. Under the ContinueAndCollect
+// failure policy the remaining managers still run and all errors are joined.
 func (e *Engine) executeManagersInOrder(currentState *state.State, executeFn func(manager.Manager) error) error {
+    managers, managerOrder, _ := e.snapshotManagers()
+
     managerMap := make(map[manager.ManagerID]manager.Manager)
-    for _, m := range e.managers {
+    for _, m := range managers {
         managerMap[m.GetID()] = m
     }
 
-    executionOrder := e.managerOrder
+    executionOrder := managerOrder
     if len(executionOrder) == 0 {
-        executionOrder = make([]manager.ManagerID, len(e.managers))
-        for i, m := range e.managers {
+        executionOrder = make([]manager.ManagerID, len(managers))
+        for i, m := range managers {
             executionOrder[i] = m.GetID()
         }
     }
 
+    var failures []error
     for _, managerID := range executionOrder {
         if manager, exists := managerMap[managerID]; exists {
             if err := executeFn(manager); err != nil {
-                return fmt.Errorf("manager %s failed: %w", managerID, err)
+                err = fmt.Errorf("manager %s failed: %w", managerID, err)
+                if e.failurePolicy == FailFast {
+                    return err
+                }
+
+                failures = append(failures, err)
+                currentState.FailedManagers = append(currentState.FailedManagers, string(managerID))
             }
         }
     }
 
-    return nil
+    return errors.Join(failures...)
+}
+
+// handleDuplicate counts a skipped duplicate input and returns the result
+// mandated by the duplicate policy.
+func (e *Engine) handleDuplicate(inputID id.ID) error {
+    atomic.AddInt64(&e.skippedDuplicates, 1)
+
+    e.logger.WithFields(map[string]interface{}{
+        "input": inputID,
+    }).Info("Skipping already processed input")
+
+    if e.duplicatePolicy == DuplicateSkip {
+        return nil
+    }
+    return fmt.Errorf("%w: %s", ErrAlreadyProcessed, inputID)
+}
+
+// runManager executes fn for a single manager in the given phase, enforcing the
+// manager's timeout, logging a warning if it exceeds the slow threshold and
+// reporting the execution to the metrics and manager hooks.
+func (e *Engine) runManager(ctx context.Context, m manager.Manager, phase Phase, currentState *state.State, fn func(ctx context.Context, s *state.State) error) error {
+    start := time.Now()
+    err := e.runWithTimeout(ctx, m, currentState, fn)
+    elapsed := time.Since(start)
+
+    e.recordManager(phase, m.GetID(), elapsed, err)
+    e.runManagerHooks(phase, m.GetID(), elapsed, err)
+
+    if e.slowManagerThreshold > 0 && elapsed > e.slowManagerThreshold {
+        e.logger.WithFields(map[string]interface{}{
+            "manager": m.GetID(),
+            "phase":   phase,
+            "elapsed": elapsed.String(),
+        }).Warn("Slow manager")
+    }
+
+    return err
+}
+
+// runWithTimeout runs fn under the manager's timeout, if one is configured.
+// fn is given a context derived from ctx that is cancelled when the timeout
+// expires, and a fork of the state joined back once it returns in time. At the
+// deadline the engine returns ErrManagerTimeout without waiting for fn, so a
+// manager ignoring ctx can't stall the pipeline; the fork of a manager that
+// timed out is never joined, discarding its writes to the state.
+func (e *Engine) runWithTimeout(ctx context.Context, m manager.Manager, currentState *state.State, fn func(ctx context.Context, s *state.State) error) error {
+    timeout := e.managerTimeout
+    if override, ok := e.managerTimeouts[m.GetID()]; ok {
+        timeout = override
+    }
+    if timeout <= 0 {
+        return fn(ctx, currentState)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    forked := currentState.Fork()
+    done := make(chan error, 1)
+    go func() {
+        done <- fn(ctx, forked)
+    }()
+
+    var err error
+    select {
+    case err = <-done:
+    case <-ctx.Done():
+    }
+    if ctxErr := ctx.Err(); ctxErr != nil {
+        if errors.Is(ctxErr, context.DeadlineExceeded) {
+            return fmt.Errorf("%w after %s", ErrManagerTimeout, timeout)
+        }
+        return fmt.Errorf("cancelled: %w", ctxErr)
+    }
+    if joinErr := currentState.Join(forked); err == nil {
+        err = joinErr
+    }
+    return err
 }
@@ -0,0 +1,327 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/events"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/options"
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+)
+
+// newTestEngine returns an engine on the stores of a managertest environment
+func newTestEngine(t testing.TB, opts ...options.Option[Engine]) (*Engine, *managertest.TestEnvironment) {
+    t.Helper()
+
+    env := managertest.NewTestEnvironment(t)
+    return newTestEngineWithEnv(t, env, opts...)
+}
+
+// newTestEngineWithEnv returns an engine on the stores of env
+func newTestEngineWithEnv(t testing.TB, env *managertest.TestEnvironment, opts ...options.Option[Engine]) (*Engine, *managertest.TestEnvironment) {
+    t.Helper()
+
+    e, err := New(append([]options.Option[Engine]{
+        WithContext(env.Ctx),
+        WithDB(env.DB),
+        WithLogger(env.Logger),
+        WithIdentifier(env.Assistant.ID, env.Assistant.Name),
+        WithActorStore(env.ActorStore),
+        WithSessionStore(env.SessionStore),
+        WithInteractionFragmentStore(env.InteractionFragmentStore),
+        WithLLMClient(env.LLM),
+    }, opts...)...)
+    if err != nil {
+        t.Fatalf("failed to create engine: %v", err)
+    }
+    return e, env
+}
+
+// newTestInput returns a state holding a new input of a user of env
+func newTestInput(env *managertest.TestEnvironment, content string) *state.State {
+    user := env.NewActor("Alice", false)
+    session := env.NewSession()
+    input := env.NewFragment(user, session, content)
+    return env.NewState(input)
+}
+
+// fakeManager is a manager whose Process runs process, if set
+type fakeManager struct {
+    *manager.BaseManager
+    id           manager.ManagerID
+    dependencies []manager.ManagerID
+    process      func(ctx context.Context, s *state.State) error
+}
+
+func newFakeManager(t testing.TB, env *managertest.TestEnvironment, managerID manager.ManagerID, process func(ctx context.Context, s *state.State) error, dependencies ...manager.ManagerID) *fakeManager {
+    t.Helper()
+    base, err := manager.NewBaseManager(env.BaseOptions()...)
+    if err != nil {
+        t.Fatalf("failed to create manager: %v", err)
+    }
+    return &fakeManager{BaseManager: base, id: managerID, dependencies: dependencies, process: process}
+}
+
+func (m *fakeManager) GetID() manager.ManagerID {
+    return m.id
+}
+
+func (m *fakeManager) GetDependencies() []manager.ManagerID {
+    return m.dependencies
+}
+
+func (m *fakeManager) Process(ctx context.Context, s *state.State) error {
+    if m.process == nil {
+        return nil
+    }
+    return m.process(ctx, s)
+}
+
+func TestProcessManagerTimeout(t *testing.T) {
+    const slowID manager.ManagerID = "slow"
+
+    tests := []struct {
+        name  string
+        opts  []options.Option[Engine]
+        delay time.Duration
+        // ignoreCtx makes the manager sleep through its timeout
+        ignoreCtx bool
+        timeout   bool
+    }{
+        {
+            name:  "no timeout",
+            delay: 10 * time.Millisecond,
+        },
+        {
+            name:  "within timeout",
+            opts:  []options.Option[Engine]{WithManagerTimeout(time.Second)},
+            delay: 10 * time.Millisecond,
+        },
+        {
+            name:    "timed out",
+            opts:    []options.Option[Engine]{WithManagerTimeout(20 * time.Millisecond)},
+            delay:   time.Second,
+            timeout: true,
+        },
+        {
+            name:      "timed out ignoring ctx",
+            opts:      []options.Option[Engine]{WithManagerTimeout(20 * time.Millisecond)},
+            delay:     time.Second,
+            ignoreCtx: true,
+            timeout:   true,
+        },
+        {
+            name:    "timed out by override",
+            opts:    []options.Option[Engine]{WithManagerTimeout(time.Second), WithManagerTimeoutFor(slowID, 20*time.Millisecond)},
+            delay:   time.Second,
+            timeout: true,
+        },
+        {
+            name:  "override disabling the timeout",
+            opts:  []options.Option[Engine]{WithManagerTimeout(time.Millisecond), WithManagerTimeoutFor(slowID, 0)},
+            delay: 20 * time.Millisecond,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            env := managertest.NewTestEnvironment(t)
+            returned := make(chan struct{})
+            slow := newFakeManager(t, env, slowID, func(ctx context.Context, s *state.State) error {
+                defer close(returned)
+                if tt.ignoreCtx {
+                    time.Sleep(tt.delay)
+                } else {
+                    select {
+                    case <-time.After(tt.delay):
+                    case <-ctx.Done():
+                    }
+                }
+                // Written after the timeout too, which must neither race with
+                // the pipeline reading the state nor reach it
+                s.Input.Metadata["slow"] = true
+                s.AddCustomData("slow", true)
+                return ctx.Err()
+            })
+
+            e, _ := newTestEngineWithEnv(t, env, append(tt.opts, WithManagers(slow))...)
+            s := newTestInput(env, "Hello")
+            start := time.Now()
+            err := e.Process(s)
+            elapsed := time.Since(start)
+            // The manager is done with the state before the next subtest
+            defer func() { <-returned }()
+
+            _, customData := s.GetCustomData("slow")
+            _, metadata := s.Input.Metadata["slow"]
+            if !tt.timeout {
+                if err != nil {
+                    t.Fatalf("Process failed: %v", err)
+                }
+                if !customData || !metadata {
+                    t.Errorf("writes of the manager reached the state: custom data %v, metadata %v, want both", customData, metadata)
+                }
+                return
+            }
+            if !errors.Is(err, ErrManagerTimeout) {
+                t.Fatalf("Process returned %v, want ErrManagerTimeout", err)
+            }
+            if n := strings.Count(err.Error(), string(slowID)); n != 1 {
+                t.Errorf("error %q names the manager %d times, want once", err, n)
+            }
+            if elapsed >= tt.delay/2 {
+                t.Errorf("Process took %s, want it to return at the timeout", elapsed)
+            }
+
+            // Writes made after the timeout are discarded
+            <-returned
+            _, customData = s.GetCustomData("slow")
+            _, metadata = s.Input.Metadata["slow"]
+            if customData || metadata {
+                t.Errorf("writes of the timed out manager reached the state: custom data %v, metadata %v", customData, metadata)
+            }
+        })
+    }
+}
+
+func TestShutdownClosesManagerEvents(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    m := newFakeManager(t, env, "events", nil)
+
+    var handled int32
+    m.RegisterEventHandler(func(manager.EventData) {
+        atomic.AddInt32(&handled, 1)
+    })
+
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(m))
+    m.Emit(events.Event{Type: events.EventManager})
+    if err := e.Shutdown(context.Background()); err != nil {
+        t.Fatalf("Shutdown failed: %v", err)
+    }
+    if got := atomic.LoadInt32(&handled); got != 1 {
+        t.Fatalf("handler received %d events before Shutdown returned, want 1", got)
+    }
+
+    // With the dispatcher closed, the handler runs synchronously
+    m.Emit(events.Event{Type: events.EventManager})
+    if got := atomic.LoadInt32(&handled); got != 2 {
+        t.Errorf("handler received %d events, want 2", got)
+    }
+}
+
+func TestProcessWithSlowEventHandler(t *testing.T) {
+    const handlerDelay = time.Second
+
+    tests := []struct {
+        name string
+        // register registers handler with the manager
+        register func(m *fakeManager, handler manager.EventCallbackFunc)
+    }{
+        {
+            name:     "handler of every type",
+            register: func(m *fakeManager, handler manager.EventCallbackFunc) { m.RegisterEventHandler(handler) },
+        },
+        {
+            name: "handler of the type",
+            register: func(m *fakeManager, handler manager.EventCallbackFunc) {
+                m.RegisterEventHandlerFor(events.EventManager, handler)
+            },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            env := managertest.NewTestEnvironment(t)
+            var m *fakeManager
+            m = newFakeManager(t, env, "events", func(ctx context.Context, s *state.State) error {
+                m.Emit(events.Event{Type: events.EventManager})
+                m.Emit(events.Event{Type: events.EventManager})
+                return nil
+            })
+            var handled int32
+            tt.register(m, func(manager.EventData) {
+                time.Sleep(handlerDelay)
+                atomic.AddInt32(&handled, 1)
+            })
+
+            e, _ := newTestEngineWithEnv(t, env, WithManagers(m))
+            start := time.Now()
+            if err := e.Process(newTestInput(env, "Hello")); err != nil {
+                t.Fatalf("Process failed: %v", err)
+            }
+            if elapsed := time.Since(start); elapsed >= handlerDelay/2 {
+                t.Errorf("Process took %s with a handler taking %s", elapsed, handlerDelay)
+            }
+            if got := atomic.LoadInt32(&handled); got != 0 {
+                t.Errorf("%d events handled before Process returned, want them handled in the background", got)
+            }
+
+            // The events are still delivered, by Shutdown at the latest
+            if err := e.Shutdown(context.Background()); err != nil {
+                t.Fatalf("Shutdown failed: %v", err)
+            }
+            if got := atomic.LoadInt32(&handled); got != 2 {
+                t.Errorf("handler received %d events, want 2", got)
+            }
+        })
+    }
+}
+
+func TestProcessMissingRecords(t *testing.T) {
+    tests := []struct {
+        name           string
+        missingActor   bool
+        missingSession bool
+        // err is part of the error expected, if any
+        err string
+    }{
+        {name: "existing"},
+        {name: "missing session is created", missingSession: true},
+        {name: "missing actor", missingActor: true, err: "was never upserted, call UpsertActor"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t)
+            input := &db.Fragment{
+                ID:        id.New(),
+                ActorID:   env.NewActor("Alice", false).ID,
+                SessionID: env.NewSession().ID,
+                Content:   "Hello",
+                Metadata:  db.Metadata{},
+            }
+            if tt.missingActor {
+                input.ActorID = id.New()
+            }
+            if tt.missingSession {
+                input.SessionID = id.New()
+            }
+
+            err := e.Process(env.NewState(input))
+            if tt.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                    t.Fatalf("error = %v, want %q", err, tt.err)
+                }
+                if !errors.Is(err, stores.ErrNotFound) {
+                    t.Errorf("error %v does not match stores.ErrNotFound", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if _, err := env.SessionStore.GetByID(input.SessionID); err != nil {
+                t.Errorf("session of the input: %v", err)
+            }
+        })
+    }
+}
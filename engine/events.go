@@ -0,0 +1,26 @@
+package engine
+
+import (
+    "fmt"
+
+    "github.com/velumlabs/thor/events"
+)
+
+// Subscribe registers a handler for engine and manager events of the given type, or of every
+// type when eventType is events.Wildcard. Handlers run on the event bus workers,
+// never on the Process call path. Returns a function that removes the subscription.
+func (e *Engine) Subscribe(eventType events.EventType, handler events.Handler) (func(), error) {
+    if handler == nil {
+        return nil, fmt.Errorf("event handler is required")
+    }
+    return e.eventBus.Subscribe(eventType, handler), nil
+}
+
+// publish queues an engine event with a typed payload on the event bus.
+func (e *Engine) publish(payload events.TypedPayload) {
+    event := events.New(payload)
+    event.Source = string(e.ID)
+    if err := e.eventBus.Publish(event); err != nil {
+        e.logger.WithError(err).WithField("event", event.Type).Warn("Failed to publish event")
+    }
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+    "context"
+    "sync"
+    "testing"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+func TestProcessCopiesInputMetadata(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    user := env.NewActor("Alice", false)
+    input := env.NewFragment(user, env.NewSession(), "Hello")
+    input.Metadata = db.Metadata{
+        "source": "chat",
+        "tags":   map[string]interface{}{"lang": "en"},
+        "scores": []interface{}{1.0, 2.0},
+    }
+
+    // The managers write the state's copy of the input while the caller
+    // reads the original, which must not share any map or slice with it
+    write := func(key string) func(ctx context.Context, s *state.State) error {
+        return func(ctx context.Context, s *state.State) error {
+            s.Input.Metadata[key] = true
+            s.Input.Metadata["tags"].(map[string]interface{})[key] = "seen"
+            s.Input.Metadata["scores"].([]interface{})[0] = key
+            return nil
+        }
+    }
+    first := newFakeManager(t, env, "first", write("first"))
+    second := newFakeManager(t, env, "second", write("second"), "first")
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(first, second), WithStagedProcess())
+
+    stop := make(chan struct{})
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for {
+            select {
+            case <-stop:
+                return
+            default:
+            }
+            _ = input.Metadata["tags"].(map[string]interface{})["lang"]
+            _ = input.Metadata["scores"].([]interface{})[0]
+            if _, ok := input.Metadata["first"]; ok {
+                t.Error("manager wrote the original metadata")
+                return
+            }
+        }
+    }()
+
+    s := env.NewState(input)
+    err := e.Process(s)
+    close(stop)
+    wg.Wait()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name     string
+        metadata db.Metadata
+        keys     []string
+        tags     int
+        score    interface{}
+    }{
+        {name: "original", metadata: input.Metadata, tags: 1, score: 1.0},
+        {name: "state copy", metadata: s.Input.Metadata, keys: []string{"first", "second"}, tags: 3, score: "second"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for _, key := range tt.keys {
+                if tt.metadata[key] != true {
+                    t.Errorf("%s = %v, want true", key, tt.metadata[key])
+                }
+            }
+            if got := len(tt.metadata["tags"].(map[string]interface{})); got != tt.tags {
+                t.Errorf("tags = %d, want %d", got, tt.tags)
+            }
+            if got := tt.metadata["scores"].([]interface{})[0]; got != tt.score {
+                t.Errorf("first score = %v, want %v", got, tt.score)
+            }
+        })
+    }
+}
+
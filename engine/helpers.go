@@ -1,18 +1,22 @@
 package engine
 
 import (
+    "context"
     "fmt"
+    "time"
 
     "github.com/velumlabs/thor/db"
     "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/stores"
+
+    "github.com/sirupsen/logrus"
 )
 
 // UpsertSession creates or updates a session in the database.
-// If the session ID already exists, it will be updated.
+// If the session ID already exists, only its activity time is updated,
+// so its title, metadata and closed state are kept.
 func (e *Engine) UpsertSession(sessionID id.ID) error {
-    if err := e.sessionStore.Upsert(&db.Session{
-        ID: sessionID,
-    }); err != nil {
+    if err := e.sessionStore.Touch(sessionID); err != nil {
         return fmt.Errorf("failed to upsert session: %w", err)
     }
     return nil
@@ -20,17 +24,39 @@ func (e *Engine) UpsertSession(sessionID id.ID) error {
 
 // UpsertActor creates or updates an actor in the database.
 // If the actor ID already exists, it will be updated with the new name and assistant status.
-func (e *Engine) UpsertActor(actorID id.ID, actorName string, assistant bool) error {
+// Optional metadata, such as a handle or locale, is merged into the actor's:
+//
+//	err := eng.UpsertActor(userID, "Alice", false, db.Metadata{"handle": "@alice"})
+func (e *Engine) UpsertActor(actorID id.ID, actorName string, assistant bool, metadata ...db.Metadata) error {
+    merged := db.Metadata{}
+    for _, m := range metadata {
+        for key, value := range m {
+            merged[key] = value
+        }
+    }
     if err := e.actorStore.Upsert(&db.Actor{
         ID:        actorID,
         Name:      actorName,
         Assistant: assistant,
+        Metadata:  merged,
     }); err != nil {
         return fmt.Errorf("failed to upsert actor: %w", err)
     }
     return nil
 }
 
+// MergeActors merges actors that turned out to be the same person into the
+// actor keepID, moving their fragments and session participations to it, see
+// stores.ActorStore.Merge:
+//
+//	err := eng.MergeActors(ctx, aliceID, aliceTwitterID)
+func (e *Engine) MergeActors(ctx context.Context, keepID id.ID, mergeIDs ...id.ID) error {
+    if err := e.actorStore.WithContext(ctx).Merge(keepID, mergeIDs...); err != nil {
+        return fmt.Errorf("failed to merge actors: %w", err)
+    }
+    return nil
+}
+
 // UpsertInteractionFragment creates or updates an interaction fragment in the database.
 // If the fragment ID already exists, it will be updated with the new data.
 func (e *Engine) UpsertInteractionFragment(fragment *db.Fragment) error {
@@ -40,10 +66,70 @@ func (e *Engine) UpsertInteractionFragment(fragment *db.Fragment) error {
 // DoesInteractionFragmentExist checks if an interaction fragment exists in the database.
 // Returns true if the fragment exists, false otherwise, along with any error encountered.
 func (e *Engine) DoesInteractionFragmentExist(fragmentID id.ID) (bool, error) {
-    fragment, err := e.interactionFragmentStore.GetByID(fragmentID)
+    exists, err := e.interactionFragmentStore.Exists(fragmentID)
     if err != nil {
         return false, fmt.Errorf("failed to check for fragment existence: %w", err)
     }
-    // If fragment is nil, it means the fragment does not exist
-    return fragment != nil, nil
+    return exists, nil
+}
+
+// HistoryOptions controls which fragments GetConversationHistory returns
+type HistoryOptions struct {
+    Limit          int       // Maximum number of fragments (the most recent ones), 0 for no limit
+    Before         time.Time // Only fragments created before this time, if set
+    After          time.Time // Only fragments created after this time, if set
+    IncludeDeleted bool      // Include soft-deleted fragments
+    PreloadActor   bool      // Load each fragment's Actor, e.g. for name attribution
+
+    // Thread, if set, returns the reply chain ending at this fragment instead of
+    // the flat session history. Limit then bounds the length of the chain, and
+    // actors are always loaded.
+    Thread id.ID
+}
+
+// defaultThreadDepth bounds the reply chain of a thread history without a limit
+const defaultThreadDepth = 100
+
+// GetConversationHistory returns the interaction fragments of a session, oldest first.
+// When a limit is set, the most recent fragments are returned.
+func (e *Engine) GetConversationHistory(ctx context.Context, sessionID id.ID, opts HistoryOptions) ([]db.Fragment, error) {
+    if opts.Thread != "" {
+        depth := defaultThreadDepth
+        if opts.Limit > 0 {
+            depth = opts.Limit - 1
+        }
+        fragments, err := e.interactionFragmentStore.WithContext(ctx).GetThread(opts.Thread, depth)
+        if err != nil {
+            return nil, fmt.Errorf("failed to get conversation thread: %w", err)
+        }
+        return fragments, nil
+    }
+
+    fragments, err := e.interactionFragmentStore.WithContext(ctx).GetSessionHistory(sessionID, stores.HistoryQuery{
+        Limit:          opts.Limit,
+        Before:         opts.Before,
+        After:          opts.After,
+        IncludeDeleted: opts.IncludeDeleted,
+        PreloadActor:   opts.PreloadActor,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to get conversation history: %w", err)
+    }
+    return fragments, nil
+}
+
+// SetLogLevel changes the level of the engine's logger, or of its sub-logger
+// with a dotted name such as "llm", and of the sub-loggers below it at runtime,
+// e.g. from an admin endpoint to debug one component in production:
+//
+//	err := eng.SetLogLevel("llm", "debug")
+func (e *Engine) SetLogLevel(name string, level string) error {
+    parsed, err := logrus.ParseLevel(level)
+    if err != nil {
+        return fmt.Errorf("invalid log level: %w", err)
+    }
+    if err := e.logger.SetLevel(name, parsed); err != nil {
+        return fmt.Errorf("failed to set log level: %w", err)
+    }
+    return nil
 }
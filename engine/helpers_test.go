@@ -0,0 +1,119 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+
+    "github.com/velumlabs/thor/cache"
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/stores"
+)
+
+func TestUpsertActorMetadata(t *testing.T) {
+    tests := []struct {
+        name string
+        // upserts are the metadata of successive upserts of the actor
+        upserts [][]db.Metadata
+        want    db.Metadata
+    }{
+        {name: "none", upserts: [][]db.Metadata{nil}, want: db.Metadata{}},
+        {
+            name:    "merged in order",
+            upserts: [][]db.Metadata{{{"handle": "@alice", "locale": "en"}, {"locale": "fr"}}},
+            want:    db.Metadata{"handle": "@alice", "locale": "fr"},
+        },
+        {
+            name:    "kept by later upserts",
+            upserts: [][]db.Metadata{{{"handle": "@alice"}}, nil, {{"locale": "en"}}},
+            want:    db.Metadata{"handle": "@alice", "locale": "en"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t)
+            actorID := id.New()
+            for _, metadata := range tt.upserts {
+                if err := e.UpsertActor(actorID, "Alice", false, metadata...); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            actor, err := env.ActorStore.GetByID(actorID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if fmt.Sprint(actor.Metadata) != fmt.Sprint(tt.want) {
+                t.Errorf("metadata = %v, want %v", actor.Metadata, tt.want)
+            }
+        })
+    }
+}
+
+func TestMergeActors(t *testing.T) {
+    c, err := cache.NewCache(cache.WithCleanupPeriod(0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(c.Close)
+    e, env := newTestEngine(t, WithStoreCache(c, 0))
+    keep := env.NewActor("Alice", false)
+    merged := env.NewActor("alice_tw", false)
+    session := env.NewSession()
+    if err := e.Process(env.NewState(env.NewFragment(merged, session, "Hello"))); err != nil {
+        t.Fatal(err)
+    }
+    // Cache the merged actor, which the merge must drop
+    if _, err := e.getActor(merged.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name     string
+        keep     id.ID
+        merge    []id.ID
+        notFound bool
+    }{
+        {name: "merged", keep: keep.ID, merge: []id.ID{merged.ID}},
+        {name: "already merged", keep: keep.ID, merge: []id.ID{merged.ID}, notFound: true},
+        {name: "missing actor to keep", keep: id.New(), merge: []id.ID{keep.ID}, notFound: true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := e.MergeActors(context.Background(), tt.keep, tt.merge...)
+            if tt.notFound {
+                if !errors.Is(err, stores.ErrNotFound) {
+                    t.Errorf("error = %v, want not found", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+        })
+    }
+
+    if _, err := e.getActor(merged.ID); !errors.Is(err, stores.ErrNotFound) {
+        t.Errorf("merged actor after the merge: %v, want not found", err)
+    }
+    kept, err := e.getActor(keep.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := kept.Metadata[stores.MergedActorsKey]; !ok {
+        t.Errorf("kept actor metadata = %v, want the merge history", kept.Metadata)
+    }
+    fragments, err := env.InteractionFragmentStore.GetSessionHistory(session.ID, stores.HistoryQuery{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, fragment := range fragments {
+        if fragment.ActorID != keep.ID {
+            t.Errorf("fragment %q by %s, want the kept actor", fragment.Content, fragment.ActorID)
+        }
+    }
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/state"
+)
+
+// BeforeHook runs before a pipeline phase. It may mutate the state, and
+// returning an error aborts the phase.
+type BeforeHook func(currentState *state.State) error
+
+// AfterHook runs after a pipeline phase with the phase's error, if any.
+// After hooks always run, even when the phase or a before hook failed.
+type AfterHook func(currentState *state.State, err error)
+
+// ManagerHook observes a single manager's execution within a phase.
+type ManagerHook func(phase Phase, managerID manager.ManagerID, duration time.Duration, err error)
+
+// runPhase executes fn surrounded by the before and after hooks registered for the phase.
+// Hooks run in registration order, and the phase's overall latency is recorded in the metrics.
+func (e *Engine) runPhase(phase Phase, currentState *state.State, fn func() error) error {
+    start := time.Now()
+    err := func() error {
+        for _, hook := range e.beforeHooks[phase] {
+            if err := hook(currentState); err != nil {
+                return fmt.Errorf("before %s hook failed: %w", phase, err)
+            }
+        }
+        return fn()
+    }()
+
+    e.recordPhase(phase, time.Since(start), err)
+
+    for _, hook := range e.afterHooks[phase] {
+        hook(currentState, err)
+    }
+
+    return err
+}
+
+// runManagerHooks notifies the manager hooks of a finished manager execution.
+func (e *Engine) runManagerHooks(phase Phase, managerID manager.ManagerID, duration time.Duration, err error) {
+    for _, hook := range e.managerHooks {
+        hook(phase, managerID, duration, err)
+    }
+}
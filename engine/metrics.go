@@ -0,0 +1,208 @@
+package engine
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/velumlabs/thor/manager"
+)
+
+// ExecutionMetrics aggregates the executions of a pipeline phase or a manager
+type ExecutionMetrics struct {
+    Calls         int64
+    Failures      int64
+    TotalDuration time.Duration
+    LastDuration  time.Duration
+    MaxDuration   time.Duration
+}
+
+// AverageDuration returns the mean duration of all recorded executions.
+func (m ExecutionMetrics) AverageDuration() time.Duration {
+    if m.Calls == 0 {
+        return 0
+    }
+    return m.TotalDuration / time.Duration(m.Calls)
+}
+
+// Metrics is a point-in-time snapshot of the engine's execution metrics
+type Metrics struct {
+    // Overall latency of Process and PostProcess
+    Phases map[Phase]ExecutionMetrics
+    // Per-manager execution, keyed by manager and phase
+    Managers map[manager.ManagerID]map[Phase]ExecutionMetrics
+    // LLM calls managers made through their BaseManager helpers, keyed by manager
+    LLMCalls map[manager.ManagerID]LLMMetrics
+    // Number of managers currently running Process
+    InFlightManagers int64
+    // Number of inputs skipped by idempotent processing
+    SkippedDuplicates int64
+    // Number of inputs waiting in the queue and the status of each queue worker
+    QueueDepth int
+    Workers    []WorkerStatus
+}
+
+// LLMMetrics aggregates the LLM calls of a manager
+type LLMMetrics struct {
+    ExecutionMetrics
+    PromptTokens     int64
+    CompletionTokens int64
+    TotalTokens      int64
+}
+
+// MetricsSink receives execution measurements as they are recorded,
+// e.g. to forward them to a monitoring system. Implementations must be safe
+// for concurrent use and should not block.
+type MetricsSink interface {
+    RecordPhase(phase Phase, duration time.Duration, err error)
+    RecordManager(phase Phase, managerID manager.ManagerID, duration time.Duration, err error)
+}
+
+// LLMMetricsSink is implemented by metrics sinks that also receive the LLM
+// calls of managers.
+type LLMMetricsSink interface {
+    RecordLLMCall(managerID manager.ManagerID, call manager.LLMCall)
+}
+
+// metricsRecorder accumulates execution metrics.
+type metricsRecorder struct {
+    mu       sync.Mutex
+    phases   map[Phase]*ExecutionMetrics
+    managers map[manager.ManagerID]map[Phase]*ExecutionMetrics
+    llmCalls map[manager.ManagerID]*LLMMetrics
+}
+
+func newMetricsRecorder() *metricsRecorder {
+    return &metricsRecorder{
+        phases:   make(map[Phase]*ExecutionMetrics),
+        managers: make(map[manager.ManagerID]map[Phase]*ExecutionMetrics),
+        llmCalls: make(map[manager.ManagerID]*LLMMetrics),
+    }
+}
+
+// Metrics returns a snapshot of the engine's execution metrics.
+func (e *Engine) Metrics() Metrics {
+    queueDepth, workers := e.queueMetrics()
+
+    e.metrics.mu.Lock()
+    defer e.metrics.mu.Unlock()
+
+    snapshot := Metrics{
+        Phases:            make(map[Phase]ExecutionMetrics, len(e.metrics.phases)),
+        Managers:          make(map[manager.ManagerID]map[Phase]ExecutionMetrics, len(e.metrics.managers)),
+        LLMCalls:          make(map[manager.ManagerID]LLMMetrics, len(e.metrics.llmCalls)),
+        InFlightManagers:  atomic.LoadInt64(&e.inFlightManagers),
+        SkippedDuplicates: atomic.LoadInt64(&e.skippedDuplicates),
+        QueueDepth:        queueDepth,
+        Workers:           workers,
+    }
+
+    for phase, m := range e.metrics.phases {
+        snapshot.Phases[phase] = *m
+    }
+
+    for managerID, phases := range e.metrics.managers {
+        managerMetrics := make(map[Phase]ExecutionMetrics, len(phases))
+        for phase, m := range phases {
+            managerMetrics[phase] = *m
+        }
+        snapshot.Managers[managerID] = managerMetrics
+    }
+
+    for managerID, m := range e.metrics.llmCalls {
+        snapshot.LLMCalls[managerID] = *m
+    }
+
+    return snapshot
+}
+
+// recordPhase records the execution of a pipeline phase.
+func (e *Engine) recordPhase(phase Phase, duration time.Duration, err error) {
+    e.metrics.mu.Lock()
+    m, ok := e.metrics.phases[phase]
+    if !ok {
+        m = &ExecutionMetrics{}
+        e.metrics.phases[phase] = m
+    }
+    m.record(duration, err)
+    e.metrics.mu.Unlock()
+
+    if e.metricsSink != nil {
+        e.metricsSink.RecordPhase(phase, duration, err)
+    }
+}
+
+// recordManager records the execution of a single manager.
+func (e *Engine) recordManager(phase Phase, managerID manager.ManagerID, duration time.Duration, err error) {
+    e.metrics.mu.Lock()
+    phases, ok := e.metrics.managers[managerID]
+    if !ok {
+        phases = make(map[Phase]*ExecutionMetrics)
+        e.metrics.managers[managerID] = phases
+    }
+    m, ok := phases[phase]
+    if !ok {
+        m = &ExecutionMetrics{}
+        phases[phase] = m
+    }
+    m.record(duration, err)
+    e.metrics.mu.Unlock()
+
+    if e.metricsSink != nil {
+        e.metricsSink.RecordManager(phase, managerID, duration, err)
+    }
+}
+
+// recordLLMCall records an LLM call of a manager.
+func (e *Engine) recordLLMCall(managerID manager.ManagerID, call manager.LLMCall) {
+    e.metrics.mu.Lock()
+    m, ok := e.metrics.llmCalls[managerID]
+    if !ok {
+        m = &LLMMetrics{}
+        e.metrics.llmCalls[managerID] = m
+    }
+    m.record(call.Duration, call.Err)
+    m.PromptTokens += int64(call.Usage.PromptTokens)
+    m.CompletionTokens += int64(call.Usage.CompletionTokens)
+    m.TotalTokens += int64(call.Usage.TotalTokens)
+    e.metrics.mu.Unlock()
+
+    if sink, ok := e.metricsSink.(LLMMetricsSink); ok {
+        sink.RecordLLMCall(managerID, call)
+    }
+}
+
+// managerLLMRecorder attributes the LLM calls of a manager to it.
+type managerLLMRecorder struct {
+    engine *Engine
+    owner  manager.ManagerID
+}
+
+func (r *managerLLMRecorder) RecordLLMCall(call manager.LLMCall) {
+    r.engine.recordLLMCall(r.owner, call)
+}
+
+// injectLLMRecorder makes a manager report its LLM calls to the engine's metrics.
+func (e *Engine) injectLLMRecorder(m manager.Manager) {
+    receiver, ok := manager.Unwrap(m).(manager.LLMRecorderReceiver)
+    if !ok {
+        return
+    }
+    receiver.SetLLMRecorder(&managerLLMRecorder{
+        engine: e,
+        owner:  m.GetID(),
+    })
+}
+
+// record adds a single execution to the metrics.
+func (m *ExecutionMetrics) record(duration time.Duration, err error) {
+    m.Calls++
+    if err != nil {
+        m.Failures++
+    }
+    m.TotalDuration += duration
+    m.LastDuration = duration
+    if duration > m.MaxDuration {
+        m.MaxDuration = duration
+    }
+}
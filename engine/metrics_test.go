@@ -0,0 +1,132 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+// recordingSink is a MetricsSink keeping what it receives
+type recordingSink struct {
+    mu       sync.Mutex
+    phases   []Phase
+    managers map[Phase][]manager.ManagerID
+    llmCalls []manager.ManagerID
+}
+
+func (s *recordingSink) RecordPhase(phase Phase, duration time.Duration, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.phases = append(s.phases, phase)
+}
+
+func (s *recordingSink) RecordManager(phase Phase, managerID manager.ManagerID, duration time.Duration, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.managers == nil {
+        s.managers = make(map[Phase][]manager.ManagerID)
+    }
+    s.managers[phase] = append(s.managers[phase], managerID)
+}
+
+func (s *recordingSink) RecordLLMCall(managerID manager.ManagerID, call manager.LLMCall) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.llmCalls = append(s.llmCalls, managerID)
+}
+
+func TestMetrics(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+        return llm.Message{Role: llm.RoleAssistant, Content: "summary", Usage: llm.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}}, nil
+    }
+
+    var summarizer *fakeManager
+    summarizer = newFakeManager(t, env, "summarizer", func(ctx context.Context, s *state.State) error {
+        time.Sleep(time.Millisecond)
+        _, err := summarizer.GenerateCompletionContext(ctx, llm.CompletionRequest{
+            Messages: []llm.Message{{Role: llm.RoleUser, Content: s.Input.Content}},
+        })
+        return err
+    })
+    quiet := newFakeManager(t, env, "quiet", nil)
+
+    sink := &recordingSink{}
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(summarizer, quiet), WithMetricsSink(sink))
+    s := newTestInput(env, "Hello")
+    if err := e.Process(s); err != nil {
+        t.Fatal(err)
+    }
+    response, err := e.GenerateResponse([]llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, s.Input.SessionID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := e.PostProcess(response, s); err != nil {
+        t.Fatal(err)
+    }
+
+    metrics := e.Metrics()
+    for _, phase := range []Phase{PhaseProcess, PhasePostProcess} {
+        if got := metrics.Phases[phase]; got.Calls != 1 || got.Failures != 0 || got.TotalDuration <= 0 {
+            t.Errorf("%s metrics = %+v, want one timed call", phase, got)
+        }
+        for _, managerID := range []manager.ManagerID{"summarizer", "quiet"} {
+            if got := metrics.Managers[managerID][phase]; got.Calls != 1 || got.LastDuration != got.TotalDuration {
+                t.Errorf("%s metrics of %s = %+v, want one call", phase, managerID, got)
+            }
+        }
+    }
+    if got := metrics.Managers["summarizer"][PhaseProcess]; got.TotalDuration < time.Millisecond {
+        t.Errorf("process duration of summarizer = %s, want at least 1ms", got.TotalDuration)
+    }
+
+    want := LLMMetrics{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}
+    got := metrics.LLMCalls["summarizer"]
+    if got.Calls != 1 || got.PromptTokens != want.PromptTokens || got.CompletionTokens != want.CompletionTokens || got.TotalTokens != want.TotalTokens {
+        t.Errorf("LLM metrics of summarizer = %+v, want one call of %+v", got, want)
+    }
+    if _, ok := metrics.LLMCalls["quiet"]; ok {
+        t.Error("LLM metrics recorded for a manager without LLM calls")
+    }
+
+    sink.mu.Lock()
+    defer sink.mu.Unlock()
+    if len(sink.phases) != 2 || len(sink.managers[PhaseProcess]) != 2 || len(sink.managers[PhasePostProcess]) != 2 {
+        t.Errorf("sink received phases %v and managers %v, want both phases of both managers", sink.phases, sink.managers)
+    }
+    if len(sink.llmCalls) != 1 || sink.llmCalls[0] != "summarizer" {
+        t.Errorf("sink received LLM calls of %v, want [summarizer]", sink.llmCalls)
+    }
+}
+
+func TestMetricsFailures(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    failing := newFakeManager(t, env, "failing", func(ctx context.Context, s *state.State) error {
+        return errors.New("store unavailable")
+    })
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(failing))
+
+    for i := 0; i < 2; i++ {
+        if err := e.Process(newTestInput(env, "Hello")); err == nil {
+            t.Fatal("Process succeeded with a failing manager")
+        }
+    }
+
+    metrics := e.Metrics()
+    if got := metrics.Managers["failing"][PhaseProcess]; got.Calls != 2 || got.Failures != 2 {
+        t.Errorf("manager metrics = %+v, want 2 failed calls", got)
+    }
+    if got := metrics.Phases[PhaseProcess]; got.Calls != 2 || got.Failures != 2 {
+        t.Errorf("phase metrics = %+v, want 2 failed calls", got)
+    }
+    if got := metrics.Managers["failing"][PhaseProcess].AverageDuration(); got != metrics.Managers["failing"][PhaseProcess].TotalDuration/2 {
+        t.Errorf("average duration = %s, want half the total", got)
+    }
+}
@@ -3,7 +3,11 @@ package engine
 import (
     "context"
     "fmt"
+    "time"
 
+    "github.com/velumlabs/thor/cache"
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/events"
     "github.com/velumlabs/thor/id"
     "github.com/velumlabs/thor/llm"
     "github.com/velumlabs/thor/logger"
@@ -143,6 +147,7 @@ func WithManagerOrder(order []manager.ManagerID) options.Option[Engine] {
         }
 
         e.managerOrder = order
+        e.explicitManagerOrder = true
         return nil
     }
 }
@@ -154,3 +159,583 @@ func WithLLMClient(client *llm.LLMClient) options.Option[Engine] {
         return nil
     }
 }
+
+// WithSessionTokenBudget limits the number of LLM tokens a single session may consume.
+// A budget of zero disables the limit.
+func WithSessionTokenBudget(tokens int64) options.Option[Engine] {
+    return func(e *Engine) error {
+        if tokens < 0 {
+            return fmt.Errorf("session token budget must not be negative")
+        }
+        e.sessionTokenBudget = tokens
+        return nil
+    }
+}
+
+// WithDailyTokenBudget limits the number of LLM tokens consumed across all sessions per UTC day.
+// A budget of zero disables the limit.
+func WithDailyTokenBudget(tokens int64) options.Option[Engine] {
+    return func(e *Engine) error {
+        if tokens < 0 {
+            return fmt.Errorf("daily token budget must not be negative")
+        }
+        e.dailyTokenBudget = tokens
+        return nil
+    }
+}
+
+// WithBudgetPauseMessage sets a final response returned once per session when a
+// token budget is exceeded, before GenerateResponse starts returning ErrBudgetExceeded.
+func WithBudgetPauseMessage(message string) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.budgetPauseMessage = message
+        return nil
+    }
+}
+
+// WithManagerTimeout sets the maximum time each manager may spend in a single
+// Process or PostProcess call. Process is given a context cancelled when the
+// timeout expires; a call that runs over it fails with ErrManagerTimeout at the
+// deadline, without waiting for the manager, and its writes to the state are
+// discarded. A timeout of zero disables the limit.
+func WithManagerTimeout(timeout time.Duration) options.Option[Engine] {
+    return func(e *Engine) error {
+        if timeout < 0 {
+            return fmt.Errorf("manager timeout must not be negative")
+        }
+        e.managerTimeout = timeout
+        return nil
+    }
+}
+
+// WithManagerTimeoutFor overrides the manager timeout for a specific manager.
+// A timeout of zero disables the limit for that manager.
+func WithManagerTimeoutFor(id manager.ManagerID, timeout time.Duration) options.Option[Engine] {
+    return func(e *Engine) error {
+        if timeout < 0 {
+            return fmt.Errorf("timeout for manager %s must not be negative", id)
+        }
+        if e.managerTimeouts == nil {
+            e.managerTimeouts = make(map[manager.ManagerID]time.Duration)
+        }
+        e.managerTimeouts[id] = timeout
+        return nil
+    }
+}
+
+// WithSlowManagerThreshold sets the duration after which a manager call is
+// logged as slow, even if it eventually succeeds.
+func WithSlowManagerThreshold(threshold time.Duration) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.slowManagerThreshold = threshold
+        return nil
+    }
+}
+
+// WithManagerFailurePolicy sets how Process and PostProcess handle manager errors.
+func WithManagerFailurePolicy(policy FailurePolicy) options.Option[Engine] {
+    return func(e *Engine) error {
+        switch policy {
+        case FailFast, ContinueAndCollect:
+            e.failurePolicy = policy
+            return nil
+        default:
+            return fmt.Errorf("unknown manager failure policy %d", policy)
+        }
+    }
+}
+
+// WithAutoManagerOrder orders managers for PostProcess by their declared dependencies,
+// so every manager runs after the managers it depends on. Dependency cycles are
+// reported when the engine is created. An explicit WithManagerOrder takes precedence.
+func WithAutoManagerOrder() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.autoManagerOrder = true
+        return nil
+    }
+}
+
+// WithStagedProcess runs Process in dependency stages instead of fully in parallel:
+// managers run concurrently within a stage, and each stage starts once all managers
+// it depends on have finished. Implies WithAutoManagerOrder.
+func WithStagedProcess() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.autoManagerOrder = true
+        e.stagedProcess = true
+        return nil
+    }
+}
+
+// WithBeforeProcessHook registers a hook that runs before Process.
+// The hook may mutate the state or abort processing by returning an error.
+func WithBeforeProcessHook(hook BeforeHook) options.Option[Engine] {
+    return withBeforeHook(PhaseProcess, hook)
+}
+
+// WithAfterProcessHook registers a hook that runs after Process, even if it failed.
+func WithAfterProcessHook(hook AfterHook) options.Option[Engine] {
+    return withAfterHook(PhaseProcess, hook)
+}
+
+// WithBeforePostProcessHook registers a hook that runs before PostProcess.
+// The hook may mutate the state or abort post-processing by returning an error.
+func WithBeforePostProcessHook(hook BeforeHook) options.Option[Engine] {
+    return withBeforeHook(PhasePostProcess, hook)
+}
+
+// WithAfterPostProcessHook registers a hook that runs after PostProcess, even if it failed.
+func WithAfterPostProcessHook(hook AfterHook) options.Option[Engine] {
+    return withAfterHook(PhasePostProcess, hook)
+}
+
+// WithManagerMiddleware wraps every manager, including those added later with
+// AddManager or ReplaceManager, with the given middlewares, e.g.
+// manager.LoggingMiddleware and manager.RecoveryMiddleware. The first middleware
+// is the outermost; middlewares from several calls are applied in call order.
+func WithManagerMiddleware(middlewares ...manager.Middleware) options.Option[Engine] {
+    return func(e *Engine) error {
+        for _, mw := range middlewares {
+            if mw == nil {
+                return fmt.Errorf("manager middleware must not be nil")
+            }
+        }
+        e.managerMiddleware = append(e.managerMiddleware, middlewares...)
+        return nil
+    }
+}
+
+// WithInputPreprocessor registers preprocessors that rewrite each input before
+// managers see it, such as NormalizeWhitespace and DetectLanguage. They run in
+// registration order, and an error from any of them aborts Process with an
+// InputRejectedError.
+func WithInputPreprocessor(preprocessors ...InputPreprocessor) options.Option[Engine] {
+    return func(e *Engine) error {
+        for _, preprocess := range preprocessors {
+            if preprocess == nil {
+                return fmt.Errorf("input preprocessor must not be nil")
+            }
+        }
+        e.preprocessors = append(e.preprocessors, preprocessors...)
+        return nil
+    }
+}
+
+// WithManagerHook registers a hook that observes every manager execution,
+// e.g. to time individual managers.
+func WithManagerHook(hook ManagerHook) options.Option[Engine] {
+    return func(e *Engine) error {
+        if hook == nil {
+            return fmt.Errorf("manager hook must not be nil")
+        }
+        e.managerHooks = append(e.managerHooks, hook)
+        return nil
+    }
+}
+
+func withBeforeHook(phase Phase, hook BeforeHook) options.Option[Engine] {
+    return func(e *Engine) error {
+        if hook == nil {
+            return fmt.Errorf("before %s hook must not be nil", phase)
+        }
+        if e.beforeHooks == nil {
+            e.beforeHooks = make(map[Phase][]BeforeHook)
+        }
+        e.beforeHooks[phase] = append(e.beforeHooks[phase], hook)
+        return nil
+    }
+}
+
+func withAfterHook(phase Phase, hook AfterHook) options.Option[Engine] {
+    return func(e *Engine) error {
+        if hook == nil {
+            return fmt.Errorf("after %s hook must not be nil", phase)
+        }
+        if e.afterHooks == nil {
+            e.afterHooks = make(map[Phase][]AfterHook)
+        }
+        e.afterHooks[phase] = append(e.afterHooks[phase], hook)
+        return nil
+    }
+}
+
+// WithTransactionalPipeline runs each Process and PostProcess call in a single
+// database transaction that commits only after all managers and the final upsert
+// succeed. Managers reach the transaction through state.Transaction and can register
+// compensations for side effects outside the database with state.AddCompensation.
+func WithTransactionalPipeline() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.transactional = true
+        return nil
+    }
+}
+
+// WithMetricsSink forwards phase and manager execution measurements to sink
+// in addition to the snapshot available from Engine.Metrics.
+func WithMetricsSink(sink MetricsSink) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.metricsSink = sink
+        return nil
+    }
+}
+
+// WithMaxConcurrentManagers limits how many managers run Process concurrently.
+// With staged processing the limit applies to each stage. Zero means unlimited.
+func WithMaxConcurrentManagers(n int) options.Option[Engine] {
+    return func(e *Engine) error {
+        if n < 0 {
+            return fmt.Errorf("max concurrent managers must not be negative")
+        }
+        e.maxConcurrentManagers = n
+        return nil
+    }
+}
+
+// WithIdempotentProcessing makes Process skip inputs whose ID was already stored,
+// e.g. messages redelivered by platform webhooks. The policy determines whether
+// duplicates return ErrAlreadyProcessed or are silently ignored.
+func WithIdempotentProcessing(policy DuplicatePolicy) options.Option[Engine] {
+    return func(e *Engine) error {
+        switch policy {
+        case DuplicateError, DuplicateSkip:
+            e.idempotentProcessing = true
+            e.duplicatePolicy = policy
+            return nil
+        default:
+            return fmt.Errorf("unknown duplicate policy %d", policy)
+        }
+    }
+}
+
+// WithRecentInteractions makes Process load the last limit interactions of the
+// session into State.RecentInteractions before managers run.
+func WithRecentInteractions(limit int) options.Option[Engine] {
+    return func(e *Engine) error {
+        if limit < 0 {
+            return fmt.Errorf("recent interactions limit must not be negative")
+        }
+        e.recentInteractionsLimit = limit
+        return nil
+    }
+}
+
+// WithThreadedHistory makes Process load the reply chain of inputs that have a
+// ParentID into State.RecentInteractions, instead of the latest fragments of the
+// session. It only has an effect together with WithRecentInteractions.
+func WithThreadedHistory() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.threadedHistory = true
+        return nil
+    }
+}
+
+// WithRelevantInteractions makes Process load the limit interactions most similar
+// to the input into State.RelevantInteractions before managers run, searching
+// either the input's session or all sessions.
+func WithRelevantInteractions(limit int, acrossSessions bool) options.Option[Engine] {
+    return func(e *Engine) error {
+        if limit < 0 {
+            return fmt.Errorf("relevant interactions limit must not be negative")
+        }
+        e.relevantInteractionsLimit = limit
+        e.relevantAcrossSessions = acrossSessions
+        return nil
+    }
+}
+
+// WithRelevanceThreshold sets the minimum cosine similarity for relevant interactions.
+func WithRelevanceThreshold(threshold float64) options.Option[Engine] {
+    return func(e *Engine) error {
+        if threshold < -1 || threshold > 1 {
+            return fmt.Errorf("relevance threshold must be between -1 and 1")
+        }
+        e.relevanceThreshold = threshold
+        return nil
+    }
+}
+
+// WithEventBus sets the event bus managers publish their events to.
+// Pass the same bus to the managers with manager.WithEventBus.
+// Without it the engine creates its own bus.
+func WithEventBus(bus *events.Bus) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.eventBus = bus
+        return nil
+    }
+}
+
+// WithEventDelivery sets what the engine's own event bus does when subscribers
+// fall behind and its queue is full. It has no effect with WithEventBus.
+func WithEventDelivery(policy events.DeliveryPolicy) options.Option[Engine] {
+    return func(e *Engine) error {
+        switch policy {
+        case events.DropNewest, events.DropOldest, events.Block:
+            e.eventDelivery = policy
+            return nil
+        default:
+            return fmt.Errorf("unknown event delivery policy %d", policy)
+        }
+    }
+}
+
+// WithQueueSize sets how many inputs Enqueue buffers before returning ErrQueueFull.
+func WithQueueSize(size int) options.Option[Engine] {
+    return func(e *Engine) error {
+        if size < 0 {
+            return fmt.Errorf("queue size must not be negative")
+        }
+        e.queueSize = size
+        return nil
+    }
+}
+
+// WithAutoSessionTitles makes Process generate a title for untitled sessions
+// from their first user input, using the fast model in the background.
+func WithAutoSessionTitles() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.autoSessionTitles = true
+        return nil
+    }
+}
+
+// WithDryRun runs the pipeline without persisting anything, e.g. to replay inputs
+// for prompt experimentation. The engine's and managers' store writes become no-ops,
+// and the writes the engine would have made are collected in the state under
+// DryRunWritesKey. Token budgets are neither enforced nor recorded and session
+// titles are not generated. Inputs must belong to an existing actor and session.
+//
+// Side effects outside the stores still happen: LLM completions and embeddings
+// are requested and billed, tools called by the model are executed, and managers
+// writing through State.Transaction or to external systems are not intercepted.
+func WithDryRun() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.dryRun = true
+        return nil
+    }
+}
+
+// WithStoreRetry retries the store reads and writes of Process and PostProcess
+// that fail with transient errors such as lost connections, serialization
+// failures or deadlocks. Has no effect with WithTransactionalPipeline, where a
+// failed statement aborts the transaction.
+func WithStoreRetry(policy RetryPolicy) options.Option[Engine] {
+    return func(e *Engine) error {
+        if policy.Attempts < 1 {
+            return fmt.Errorf("retry attempts must be at least 1")
+        }
+        if policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+            return fmt.Errorf("retry backoff must not be negative")
+        }
+        e.storeRetry = policy
+        return nil
+    }
+}
+
+// WithCache sets a shared cache the engine uses for actor and session lookups
+// in Process and PostProcess. It is WithStoreCache with the default not-found
+// TTL, or no cache if c is nil. Pass the same cache to the managers with
+// manager.WithCache to share it with them.
+func WithCache(c *cache.Cache) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.storeCache = c
+        e.storeNotFoundTTL = 0
+        return nil
+    }
+}
+
+// WithStoreCache makes the engine's actor, session and interaction fragment
+// stores cache the records they look up by ID in c, see stores.ActorStore.WithCache.
+// Missing records are cached for notFoundTTL, stores.DefaultNotFoundTTL if
+// zero. The lookups are reported by StoreCacheStats.
+func WithStoreCache(c *cache.Cache, notFoundTTL time.Duration) options.Option[Engine] {
+    return func(e *Engine) error {
+        if c == nil {
+            return fmt.Errorf("store cache is required")
+        }
+        e.storeCache = c
+        e.storeNotFoundTTL = notFoundTTL
+        return nil
+    }
+}
+
+// WithEmbeddingMode sets how GenerateResponse embeds responses. With EmbedAsync
+// the response is returned right away with a zero vector embedding, which is
+// replaced in the background once PostProcess has stored the response; on start
+// the engine also backfills embeddings left pending by a previous run.
+func WithEmbeddingMode(mode EmbeddingMode) options.Option[Engine] {
+    return func(e *Engine) error {
+        if mode < EmbedSync || mode > EmbedSkip {
+            return fmt.Errorf("invalid embedding mode: %d", mode)
+        }
+        e.embeddingMode = mode
+        return nil
+    }
+}
+
+// WithEmbeddingHook sets a hook observing the completion or failure of asynchronous embeddings.
+func WithEmbeddingHook(hook EmbeddingHook) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.embeddingHook = hook
+        return nil
+    }
+}
+
+// WithScheduleStore sets the store for scheduled responses. Without it the
+// engine creates one on its database.
+func WithScheduleStore(store *stores.ScheduleStore) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.scheduleStore = store
+        return nil
+    }
+}
+
+// WithUsageStore sets the store tracking the token usage of sessions and days
+// for the token budgets. Without it the engine uses the usage store of its
+// session store.
+func WithUsageStore(store *stores.UsageStore) options.Option[Engine] {
+    return func(e *Engine) error {
+        if store == nil {
+            return fmt.Errorf("usage store is required")
+        }
+        e.usageStore = store
+        return nil
+    }
+}
+
+// WithToolCallStore records the tools the model calls while generating
+// responses in store, with their arguments, results, durations and errors, so
+// they can be listed by session or tool. ExportSession includes them.
+func WithToolCallStore(store *stores.ToolCallStore) options.Option[Engine] {
+    return func(e *Engine) error {
+        if store == nil {
+            return fmt.Errorf("tool call store is required")
+        }
+        e.toolCallStore = store
+        return nil
+    }
+}
+
+// WithScheduleHandler sets the handler producing scheduled responses when they
+// are due. Without it the engine generates a follow-up from the schedule's
+// instruction and the recent conversation, and stores it in the session.
+func WithScheduleHandler(handler ScheduleHandler) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.scheduleHandler = handler
+        return nil
+    }
+}
+
+// WithScheduleLateThreshold sets how overdue a scheduled response must be when
+// it fires to be flagged as late.
+func WithScheduleLateThreshold(threshold time.Duration) options.Option[Engine] {
+    return func(e *Engine) error {
+        if threshold < 0 {
+            return fmt.Errorf("late threshold must not be negative")
+        }
+        e.scheduleLateThreshold = threshold
+        return nil
+    }
+}
+
+// WithPartitionMaintenance makes the scheduler, see StartScheduler, create the
+// monthly partitions of the interaction table up to ahead months past the
+// current one, db.DefaultPartitionsAhead if zero, when it starts and daily
+// after that. It does nothing while the table isn't partitioned, see
+// db.PartitionFragmentTable.
+func WithPartitionMaintenance(ahead int) options.Option[Engine] {
+    return func(e *Engine) error {
+        if ahead < 0 {
+            return fmt.Errorf("partitions ahead must not be negative")
+        }
+        if ahead == 0 {
+            ahead = db.DefaultPartitionsAhead
+        }
+        e.partitionsAhead = ahead
+        return nil
+    }
+}
+
+// WithResponseRateLimit limits each session to one generated response per
+// period, allowing bursts of up to burst responses. What happens to responses
+// over the limit is set with WithRateLimitMode.
+func WithResponseRateLimit(per time.Duration, burst int) options.Option[Engine] {
+    return func(e *Engine) error {
+        if per <= 0 {
+            return fmt.Errorf("rate limit period must be positive")
+        }
+        if burst < 1 {
+            return fmt.Errorf("rate limit burst must be at least 1")
+        }
+        e.responseLimiter = newResponseLimiter(per, burst, e.rateLimitMode)
+        return nil
+    }
+}
+
+// WithRateLimitMode sets how responses over the rate limit are handled,
+// RateLimitBlock by default.
+func WithRateLimitMode(mode RateLimitMode) options.Option[Engine] {
+    return func(e *Engine) error {
+        if mode < RateLimitBlock || mode > RateLimitCoalesce {
+            return fmt.Errorf("invalid rate limit mode: %d", mode)
+        }
+        e.rateLimitMode = mode
+        if e.responseLimiter != nil {
+            e.responseLimiter.mode = mode
+        }
+        return nil
+    }
+}
+
+// WithPausedDisabledBackground stops the background processes of managers
+// disabled with SetManagerEnabled, and restarts them once re-enabled. By
+// default disabled managers only leave the pipeline and their background
+// processes keep running.
+func WithPausedDisabledBackground() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.pauseDisabledBackground = true
+        return nil
+    }
+}
+
+// WithPromptDebugLogging logs the prompt of every Reply at debug level as a
+// state.RenderReport: the role, template, referenced data keys, rendered text
+// and tokens of each section. Sections referencing any of the redact template
+// data keys, e.g. "Actor" or a manager data key, are logged without their
+// text. The report is logged under the "prompt" field, which the logger's
+// redaction rules apply to, e.g. to truncate it or mask keys, see
+// logger.Logger.SetRedaction. Nothing is rendered twice unless the logger is at
+// debug level.
+func WithPromptDebugLogging(redact ...string) options.Option[Engine] {
+    return func(e *Engine) error {
+        e.promptDebug = true
+        e.promptDebugRedact = append([]string(nil), redact...)
+        return nil
+    }
+}
+
+// WithStrictStateKeys makes the states of the pipeline reject context data a
+// manager returns for a key another manager holds, failing the manager with a
+// state.KeyConflictError under the failure policy. By default the conflict is
+// logged and the first manager keeps the key.
+func WithStrictStateKeys() options.Option[Engine] {
+    return func(e *Engine) error {
+        e.strictStateKeys = true
+        return nil
+    }
+}
+
+// WithTenant scopes the whole pipeline to a tenant of a shared database: the
+// engine's stores, the stores of managers supporting it and the stores of
+// State.Stores only read the tenant's actors, sessions and fragments, and
+// stamp it on those they write. See stores.WithTenant.
+//
+// Actors belong to one tenant, so the engines of different tenants need
+// different assistant IDs, see WithIdentifier.
+func WithTenant(tenantID id.ID) options.Option[Engine] {
+    return func(e *Engine) error {
+        if tenantID == "" {
+            return fmt.Errorf("tenant ID is required")
+        }
+        e.tenant = tenantID
+        return nil
+    }
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/velumlabs/thor/manager"
+)
+
+// sortManagers orders managers so that every manager comes after the managers
+// it depends on. Managers are grouped into stages: a manager's stage is one past
+// the latest stage of its dependencies, so managers within a stage are independent
+// of each other. Registration order is preserved within a stage.
+// Returns an error describing the cycle if the dependencies are cyclic.
+func sortManagers(managers []manager.Manager) ([][]manager.ManagerID, error) {
+    byID := make(map[manager.ManagerID]manager.Manager, len(managers))
+    for _, m := range managers {
+        byID[m.GetID()] = m
+    }
+
+    for _, m := range managers {
+        for _, dep := range m.GetDependencies() {
+            if _, ok := byID[dep]; !ok {
+                return nil, fmt.Errorf("manager %s requires manager %s which was not provided", m.GetID(), dep)
+            }
+        }
+    }
+
+    if cycle := findManagerCycle(managers, byID); cycle != nil {
+        ids := make([]string, len(cycle))
+        for i, id := range cycle {
+            ids[i] = string(id)
+        }
+        return nil, fmt.Errorf("manager dependency cycle detected: %s", strings.Join(ids, " -> "))
+    }
+
+    stageOf := make(map[manager.ManagerID]int, len(managers))
+    var resolve func(id manager.ManagerID) int
+    resolve = func(id manager.ManagerID) int {
+        if stage, ok := stageOf[id]; ok {
+            return stage
+        }
+        stage := 0
+        for _, dep := range byID[id].GetDependencies() {
+            if depStage := resolve(dep) + 1; depStage > stage {
+                stage = depStage
+            }
+        }
+        stageOf[id] = stage
+        return stage
+    }
+
+    var stages [][]manager.ManagerID
+    for _, m := range managers {
+        stage := resolve(m.GetID())
+        for len(stages) <= stage {
+            stages = append(stages, nil)
+        }
+        stages[stage] = append(stages[stage], m.GetID())
+    }
+
+    return stages, nil
+}
+
+// findManagerCycle returns the IDs along a dependency cycle, starting and ending
+// with the same manager, or nil if the dependency graph is acyclic.
+func findManagerCycle(managers []manager.Manager, byID map[manager.ManagerID]manager.Manager) []manager.ManagerID {
+    const (
+        unvisited = iota
+        visiting
+        visited
+    )
+
+    status := make(map[manager.ManagerID]int, len(managers))
+    var path []manager.ManagerID
+
+    var visit func(id manager.ManagerID) []manager.ManagerID
+    visit = func(id manager.ManagerID) []manager.ManagerID {
+        status[id] = visiting
+        path = append(path, id)
+
+        for _, dep := range byID[id].GetDependencies() {
+            switch status[dep] {
+            case visiting:
+                for i, p := range path {
+                    if p == dep {
+                        cycle := append([]manager.ManagerID{}, path[i:]...)
+                        return append(cycle, dep)
+                    }
+                }
+            case unvisited:
+                if cycle := visit(dep); cycle != nil {
+                    return cycle
+                }
+            }
+        }
+
+        path = path[:len(path)-1]
+        status[id] = visited
+        return nil
+    }
+
+    for _, m := range managers {
+        if status[m.GetID()] == unvisited {
+            if cycle := visit(m.GetID()); cycle != nil {
+                return cycle
+            }
+        }
+    }
+
+    return nil
+}
+
+// flattenStages concatenates manager stages into a single execution order.
+func flattenStages(stages [][]manager.ManagerID) []manager.ManagerID {
+    var order []manager.ManagerID
+    for _, stage := range stages {
+        order = append(order, stage...)
+    }
+    return order
+}
+
+// refreshManagerOrder recomputes the automatic manager order after the set of
+// managers changed. It is a no-op unless automatic ordering is enabled.
+// Must be called with managersMu held.
+func (e *Engine) refreshManagerOrder(managers []manager.Manager) error {
+    if !e.autoManagerOrder {
+        return nil
+    }
+
+    stages, err := sortManagers(managers)
+    if err != nil {
+        return err
+    }
+
+    e.managerStages = stages
+    if !e.explicitManagerOrder {
+        e.managerOrder = flattenStages(stages)
+    }
+
+    return nil
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+// testManagers returns fake managers of the given IDs, each depending on the
+// IDs it maps to, in the order of ids
+func testManagers(t *testing.T, env *managertest.TestEnvironment, ids []manager.ManagerID, deps map[manager.ManagerID][]manager.ManagerID, process func(id manager.ManagerID) func(ctx context.Context, s *state.State) error) []manager.Manager {
+    t.Helper()
+    managers := make([]manager.Manager, len(ids))
+    for i, id := range ids {
+        managers[i] = newFakeManager(t, env, id, process(id), deps[id]...)
+    }
+    return managers
+}
+
+func TestSortManagers(t *testing.T) {
+    tests := []struct {
+        name string
+        ids  []manager.ManagerID
+        deps map[manager.ManagerID][]manager.ManagerID
+        want [][]manager.ManagerID
+        // err is part of the error expected instead of stages
+        err string
+    }{
+        {
+            name: "independent",
+            ids:  []manager.ManagerID{"a", "b", "c"},
+            want: [][]manager.ManagerID{{"a", "b", "c"}},
+        },
+        {
+            name: "chain",
+            ids:  []manager.ManagerID{"c", "b", "a"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"c": {"b"}, "b": {"a"}},
+            want: [][]manager.ManagerID{{"a"}, {"b"}, {"c"}},
+        },
+        {
+            name: "diamond keeps registration order within stages",
+            ids:  []manager.ManagerID{"d", "c", "b", "a"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"d": {"b", "c"}, "c": {"a"}, "b": {"a"}},
+            want: [][]manager.ManagerID{{"a"}, {"c", "b"}, {"d"}},
+        },
+        {
+            name: "stage after the latest dependency",
+            ids:  []manager.ManagerID{"a", "b", "c"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"c": {"a", "b"}, "b": {"a"}},
+            want: [][]manager.ManagerID{{"a"}, {"b"}, {"c"}},
+        },
+        {
+            name: "missing dependency",
+            ids:  []manager.ManagerID{"a"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"a": {"memory"}},
+            err:  "manager a requires manager memory which was not provided",
+        },
+        {
+            name: "cycle",
+            ids:  []manager.ManagerID{"a", "b", "c"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+            err:  "manager dependency cycle detected: a -> b -> c -> a",
+        },
+        {
+            name: "self dependency",
+            ids:  []manager.ManagerID{"a"},
+            deps: map[manager.ManagerID][]manager.ManagerID{"a": {"a"}},
+            err:  "manager dependency cycle detected: a -> a",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            env := managertest.NewTestEnvironment(t)
+            managers := testManagers(t, env, tt.ids, tt.deps, func(manager.ManagerID) func(context.Context, *state.State) error { return nil })
+
+            stages, err := sortManagers(managers)
+            if tt.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                    t.Fatalf("error = %v, want %q", err, tt.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if fmt.Sprint(stages) != fmt.Sprint(tt.want) {
+                t.Errorf("stages = %v, want %v", stages, tt.want)
+            }
+        })
+    }
+}
+
+func TestAutoManagerOrderCycle(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    managers := testManagers(t, env, []manager.ManagerID{"a", "b"},
+        map[manager.ManagerID][]manager.ManagerID{"a": {"b"}, "b": {"a"}},
+        func(manager.ManagerID) func(context.Context, *state.State) error { return nil })
+
+    _, err := New(WithContext(env.Ctx), WithDB(env.DB), WithLogger(env.Logger),
+        WithIdentifier(env.Assistant.ID, env.Assistant.Name),
+        WithActorStore(env.ActorStore), WithSessionStore(env.SessionStore),
+        WithInteractionFragmentStore(env.InteractionFragmentStore),
+        WithLLMClient(env.LLM), WithManagers(managers...), WithAutoManagerOrder())
+    if err == nil || !strings.Contains(err.Error(), "cycle") {
+        t.Errorf("New returned %v, want a cycle error", err)
+    }
+}
+
+func TestStagedProcess(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    deps := map[manager.ManagerID][]manager.ManagerID{"d": {"b", "c"}, "c": {"a"}, "b": {"a"}}
+
+    var (
+        mu       sync.Mutex
+        finished = make(map[manager.ManagerID]bool)
+    )
+    managers := testManagers(t, env, []manager.ManagerID{"d", "c", "b", "a"}, deps, func(id manager.ManagerID) func(context.Context, *state.State) error {
+        return func(ctx context.Context, s *state.State) error {
+            mu.Lock()
+            defer mu.Unlock()
+            for _, dep := range deps[id] {
+                if !finished[dep] {
+                    t.Errorf("manager %s started before its dependency %s finished", id, dep)
+                }
+            }
+            finished[id] = true
+            return nil
+        }
+    })
+
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(managers...), WithStagedProcess())
+    if err := e.Process(newTestInput(env, "Hello")); err != nil {
+        t.Fatal(err)
+    }
+    if len(finished) != 4 {
+        t.Errorf("finished managers = %v, want all 4", finished)
+    }
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+    "fmt"
+    "strings"
+    "unicode"
+
+    "github.com/velumlabs/thor/db"
+)
+
+// LanguageKey is the input metadata key DetectLanguage stores the detected
+// ISO 639-1 language code under.
+const LanguageKey = "lang"
+
+// InputPreprocessor rewrites an input before managers see it. It may change the
+// input's Content and add Metadata keys. Returning an error rejects the input.
+type InputPreprocessor func(input *db.Fragment) error
+
+// InputRejectedError is returned by Process when a preprocessor rejects an input.
+// Callers can use errors.As to tell invalid inputs apart from processing failures
+// and answer them with a validation message.
+type InputRejectedError struct {
+    Err error
+}
+
+func (e *InputRejectedError) Error() string {
+    return fmt.Sprintf("input rejected: %v", e.Err)
+}
+
+func (e *InputRejectedError) Unwrap() error {
+    return e.Err
+}
+
+// preprocessInput runs the registered preprocessors on the input, in registration order.
+func (e *Engine) preprocessInput(input *db.Fragment) error {
+    if len(e.preprocessors) == 0 {
+        return nil
+    }
+    if input.Metadata == nil {
+        input.Metadata = db.Metadata{}
+    }
+    for _, preprocess := range e.preprocessors {
+        if err := preprocess(input); err != nil {
+            return &InputRejectedError{Err: err}
+        }
+    }
+    return nil
+}
+
+// NormalizeWhitespace is a preprocessor that removes control characters, collapses
+// runs of spaces and tabs, drops trailing spaces and extra blank lines, and trims
+// the content. Line breaks are kept.
+func NormalizeWhitespace(input *db.Fragment) error {
+    var b strings.Builder
+    b.Grow(len(input.Content))
+
+    space, newlines := false, 0
+    for _, r := range strings.ReplaceAll(input.Content, "\r\n", "\n") {
+        switch {
+        case r == '\n' || r == '\r':
+            space = false
+            newlines++
+        case unicode.IsSpace(r):
+            space = true
+        case unicode.IsControl(r) || r == '\u200b' || r == '\ufeff':
+            // Zero width and control characters are platform artifacts
+        default:
+            if b.Len() > 0 {
+                if newlines > 0 {
+                    b.WriteString(strings.Repeat("\n", min(newlines, 2)))
+                } else if space {
+                    b.WriteByte(' ')
+                }
+            }
+            space, newlines = false, 0
+            b.WriteRune(r)
+        }
+    }
+
+    input.Content = b.String()
+    return nil
+}
+
+// languageScripts maps writing systems used by a single common language to that language.
+var languageScripts = []struct {
+    table *unicode.RangeTable
+    lang  string
+}{
+    {unicode.Hangul, "ko"},
+    {unicode.Hiragana, "ja"},
+    {unicode.Katakana, "ja"},
+    {unicode.Han, "zh"},
+    {unicode.Cyrillic, "ru"},
+    {unicode.Arabic, "ar"},
+    {unicode.Hebrew, "he"},
+    {unicode.Greek, "el"},
+    {unicode.Thai, "th"},
+    {unicode.Devanagari, "hi"},
+}
+
+// languageStopwords holds frequent words of languages written in Latin script.
+var languageStopwords = map[string][]string{
+    "en": {"the", "and", "is", "are", "you", "that", "of", "to", "it", "what", "this", "with", "have", "for", "not"},
+    "de": {"der", "die", "das", "und", "ist", "nicht", "ich", "du", "ein", "eine", "mit", "zu", "wie", "was", "auch"},
+    "fr": {"le", "la", "les", "et", "est", "je", "tu", "vous", "pas", "une", "des", "que", "pour", "avec", "ce"},
+    "es": {"el", "la", "los", "las", "y", "es", "que", "no", "por", "una", "para", "con", "como", "pero", "yo"},
+    "it": {"il", "lo", "gli", "e", "è", "che", "non", "per", "una", "sono", "con", "come", "ma", "io", "di"},
+    "pt": {"o", "os", "as", "e", "é", "que", "não", "para", "uma", "com", "como", "mas", "eu", "você", "do"},
+    "nl": {"de", "het", "een", "en", "is", "niet", "ik", "je", "dat", "van", "met", "voor", "zijn", "wat", "ook"},
+}
+
+// minLanguageScore is the number of stopword hits DetectLanguage needs before
+// it tags a Latin script input, so short inputs are left untagged.
+const minLanguageScore = 2
+
+// DetectLanguage is a preprocessor that tags the input with its language under
+// LanguageKey. Non-Latin scripts are recognized by their characters and Latin
+// script languages by their most frequent words. Inputs that cannot be detected
+// confidently, or that are already tagged, are left unchanged.
+func DetectLanguage(input *db.Fragment) error {
+    if input.Metadata.GetString(LanguageKey) != "" {
+        return nil
+    }
+    if lang := detectLanguage(input.Content); lang != "" {
+        input.Metadata[LanguageKey] = lang
+    }
+    return nil
+}
+
+// detectLanguage returns the language code of text, or "" if unsure.
+func detectLanguage(text string) string {
+    letters := 0
+    scripts := make(map[string]int)
+    for _, r := range text {
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        letters++
+        for _, script := range languageScripts {
+            if unicode.Is(script.table, r) {
+                scripts[script.lang]++
+                break
+            }
+        }
+    }
+    if letters == 0 {
+        return ""
+    }
+
+    // Japanese mixes kana with Han characters, so any kana decides for it
+    if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+        return "ja"
+    }
+    for lang, count := range scripts {
+        if count > letters/2 {
+            return lang
+        }
+    }
+
+    scores := make(map[string]int)
+    for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && r != '\''
+    }) {
+        for lang, stopwords := range languageStopwords {
+            for _, stopword := range stopwords {
+                if word == stopword {
+                    scores[lang]++
+                    break
+                }
+            }
+        }
+    }
+
+    best, bestScore, tied := "", 0, false
+    for lang, score := range scores {
+        switch {
+        case score > bestScore:
+            best, bestScore, tied = lang, score, false
+        case score == bestScore:
+            tied = true
+        }
+    }
+    if bestScore < minLanguageScore || tied {
+        return ""
+    }
+    return best
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/state"
+)
+
+const defaultQueueSize = 1024
+
+var (
+    // ErrQueueFull is returned by Enqueue when the input queue is full
+    ErrQueueFull = errors.New("input queue is full")
+
+    // ErrQueueClosed is returned by Enqueue after Shutdown
+    ErrQueueClosed = errors.New("input queue is closed")
+)
+
+// WorkerStatus describes a queue worker at a point in time
+type WorkerStatus struct {
+    ID        int
+    Busy      bool
+    SessionID id.ID // Session of the input being processed, if busy
+    Processed int64
+    Failures  int64
+    LastError string
+}
+
+// queuedInput is an input waiting to be processed by a worker.
+type queuedInput struct {
+    ctx   context.Context
+    input *db.Fragment
+}
+
+// sessionQueue holds the inputs of a session in the order they were enqueued.
+// At most one worker drains it at a time.
+type sessionQueue struct {
+    items  []queuedInput
+    active bool // A worker is processing an input of the session
+}
+
+// inputQueue buffers inputs for the workers started with StartWorkers. Inputs
+// are queued per session, and a session is ready for the workers while it has
+// inputs and no worker is processing one of them, so a busy session holds a
+// single worker and never starves the others.
+type inputQueue struct {
+    mu       sync.Mutex
+    cond     *sync.Cond
+    sessions map[id.ID]*sessionQueue
+    ready    []id.ID // Sessions waiting for a worker, oldest first
+    pending  int
+    size     int
+    closed   bool
+    wg       sync.WaitGroup
+
+    statusMu sync.Mutex
+    workers  []*WorkerStatus
+}
+
+func newInputQueue(size int) *inputQueue {
+    q := &inputQueue{
+        sessions: make(map[id.ID]*sessionQueue),
+        size:     size,
+    }
+    q.cond = sync.NewCond(&q.mu)
+    return q
+}
+
+// Enqueue adds an input to the queue without waiting for it to be processed.
+// Inputs are processed by the workers started with StartWorkers, one at a time
+// and in order per session. Returns ErrQueueFull if the queue is full and
+// ErrQueueClosed after Shutdown. Inputs whose ctx is done by the time a worker
+// picks them up are dropped.
+func (e *Engine) Enqueue(ctx context.Context, input *db.Fragment) error {
+    if input == nil {
+        return fmt.Errorf("input is required")
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
+    q := e.queue
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return ErrQueueClosed
+    }
+    if q.pending >= q.size {
+        return ErrQueueFull
+    }
+
+    session, ok := q.sessions[input.SessionID]
+    if !ok {
+        session = &sessionQueue{}
+        q.sessions[input.SessionID] = session
+    }
+    if !session.active && len(session.items) == 0 {
+        q.ready = append(q.ready, input.SessionID)
+        q.cond.Signal()
+    }
+    session.items = append(session.items, queuedInput{ctx: ctx, input: input})
+    q.pending++
+
+    return nil
+}
+
+// StartWorkers starts n workers processing queued inputs. Inputs of the same
+// session never run concurrently, inputs of different sessions run in parallel.
+// Calling it again adds more workers.
+func (e *Engine) StartWorkers(n int) error {
+    if n <= 0 {
+        return fmt.Errorf("number of workers must be positive")
+    }
+
+    e.queue.mu.Lock()
+    defer e.queue.mu.Unlock()
+
+    if e.queue.closed {
+        return ErrQueueClosed
+    }
+
+    e.queue.statusMu.Lock()
+    defer e.queue.statusMu.Unlock()
+
+    for i := 0; i < n; i++ {
+        status := &WorkerStatus{ID: len(e.queue.workers)}
+        e.queue.workers = append(e.queue.workers, status)
+
+        e.queue.wg.Add(1)
+        go e.worker(status)
+    }
+
+    return nil
+}
+
+// drainQueue stops accepting inputs and waits until the workers have processed
+// the queued ones or ctx is done.
+func (e *Engine) drainQueue(ctx context.Context) error {
+    e.queue.mu.Lock()
+    e.queue.closed = true
+    e.queue.cond.Broadcast()
+    e.queue.mu.Unlock()
+
+    done := make(chan struct{})
+    go func() {
+        e.queue.wg.Wait()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return fmt.Errorf("input queue did not drain: %w", ctx.Err())
+    }
+}
+
+// worker processes queued inputs until the queue is closed and empty.
+func (e *Engine) worker(status *WorkerStatus) {
+    defer e.queue.wg.Done()
+
+    for {
+        item, ok := e.queue.next()
+        if !ok {
+            return
+        }
+        sessionID := item.input.SessionID
+
+        // The session is held from here on, so an input canceled while it
+        // waited behind the session's earlier inputs is dropped
+        if item.ctx.Err() != nil {
+            e.queue.release(sessionID)
+            continue
+        }
+
+        e.setWorkerBusy(status, sessionID)
+        currentState := state.NewState()
+        currentState.Input = item.input
+        currentState.SetStrictKeys(e.strictStateKeys)
+        err := e.Process(currentState)
+        e.queue.release(sessionID)
+
+        if err != nil {
+            e.logger.WithError(err).WithFields(map[string]interface{}{
+                "worker":  status.ID,
+                "input":   item.input.ID,
+                "session": sessionID,
+            }).Error("Failed to process queued input")
+        }
+        e.setWorkerIdle(status, err)
+    }
+}
+
+// next blocks until a session is ready and returns its oldest input, holding
+// the session until release. Returns false once the queue is closed and empty.
+func (q *inputQueue) next() (queuedInput, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    for len(q.ready) == 0 {
+        if q.closed && q.pending == 0 {
+            return queuedInput{}, false
+        }
+        q.cond.Wait()
+    }
+
+    sessionID := q.ready[0]
+    q.ready = q.ready[1:]
+    session := q.sessions[sessionID]
+    item := session.items[0]
+    session.items[0] = queuedInput{}
+    session.items = session.items[1:]
+    session.active = true
+    q.pending--
+
+    return item, true
+}
+
+// release hands a session held by a worker back to the queue, behind the
+// sessions already waiting if it has more inputs.
+func (q *inputQueue) release(sessionID id.ID) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    session := q.sessions[sessionID]
+    session.active = false
+    if len(session.items) == 0 {
+        delete(q.sessions, sessionID)
+    } else {
+        q.ready = append(q.ready, sessionID)
+        q.cond.Signal()
+    }
+    // Workers waiting for the last inputs exit once the queue is closed and empty
+    if q.closed && q.pending == 0 {
+        q.cond.Broadcast()
+    }
+}
+
+func (e *Engine) setWorkerBusy(status *WorkerStatus, sessionID id.ID) {
+    e.queue.statusMu.Lock()
+    defer e.queue.statusMu.Unlock()
+    status.Busy = true
+    status.SessionID = sessionID
+}
+
+func (e *Engine) setWorkerIdle(status *WorkerStatus, err error) {
+    e.queue.statusMu.Lock()
+    defer e.queue.statusMu.Unlock()
+    status.Busy = false
+    status.SessionID = ""
+    status.Processed++
+    if err != nil {
+        status.Failures++
+        status.LastError = err.Error()
+    }
+}
+
+// queueMetrics returns the current queue depth and a snapshot of the worker statuses.
+func (e *Engine) queueMetrics() (int, []WorkerStatus) {
+    e.queue.mu.Lock()
+    depth := e.queue.pending
+    e.queue.mu.Unlock()
+
+    e.queue.statusMu.Lock()
+    defer e.queue.statusMu.Unlock()
+
+    workers := make([]WorkerStatus, len(e.queue.workers))
+    for i, status := range e.queue.workers {
+        workers[i] = *status
+    }
+
+    return depth, workers
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+func TestQueueHotSession(t *testing.T) {
+    tests := []struct {
+        name    string
+        workers int
+        // hot is the number of inputs of the busy session queued before the other one
+        hot int
+    }{
+        {name: "two workers", workers: 2, hot: 10},
+        {name: "four workers", workers: 4, hot: 20},
+        {name: "more workers than inputs", workers: 16, hot: 8},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            env := managertest.NewTestEnvironment(t)
+            user := env.NewActor("Alice", false)
+            hot := env.NewSession()
+            cold := env.NewSession()
+
+            // The hot session's inputs block until released, the cold one's report it ran
+            release := make(chan struct{})
+            coldDone := make(chan struct{})
+            var mu sync.Mutex
+            var order []string
+            running := 0
+            m := newFakeManager(t, env, "queue", func(ctx context.Context, s *state.State) error {
+                if s.Input.SessionID == cold.ID {
+                    close(coldDone)
+                    return nil
+                }
+                mu.Lock()
+                running++
+                if running > 1 {
+                    t.Error("inputs of the hot session processed concurrently")
+                }
+                order = append(order, s.Input.Content)
+                mu.Unlock()
+
+                <-release
+
+                mu.Lock()
+                running--
+                mu.Unlock()
+                return nil
+            })
+            e, _ := newTestEngineWithEnv(t, env, WithManagers(m))
+            if err := e.StartWorkers(tt.workers); err != nil {
+                t.Fatal(err)
+            }
+
+            var want []string
+            for i := 0; i < tt.hot; i++ {
+                content := fmt.Sprintf("hot %d", i)
+                want = append(want, content)
+                if err := e.Enqueue(context.Background(), env.NewFragment(user, hot, content)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if err := e.Enqueue(context.Background(), env.NewFragment(user, cold, "cold")); err != nil {
+                t.Fatal(err)
+            }
+
+            // The cold session runs while the hot one holds a single worker
+            select {
+            case <-coldDone:
+            case <-time.After(5 * time.Second):
+                t.Fatal("cold session starved by the hot one")
+            }
+            if depth, _ := e.queueMetrics(); depth != tt.hot-1 {
+                t.Errorf("queue depth = %d, want the %d hot inputs waiting", depth, tt.hot-1)
+            }
+
+            close(release)
+            if err := e.Shutdown(context.Background()); err != nil {
+                t.Fatal(err)
+            }
+            if fmt.Sprint(order) != fmt.Sprint(want) {
+                t.Errorf("hot inputs processed in order %v, want %v", order, want)
+            }
+        })
+    }
+}
+
+func TestQueueCanceledInput(t *testing.T) {
+    tests := []struct {
+        name string
+        // cancel cancels the second input's ctx after it is queued, if set
+        cancel bool
+        want   []string
+    }{
+        {name: "not canceled", want: []string{"first", "second", "third"}},
+        {name: "canceled while waiting for its session", cancel: true, want: []string{"first", "third"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            env := managertest.NewTestEnvironment(t)
+            user := env.NewActor("Alice", false)
+            session := env.NewSession()
+
+            started := make(chan struct{}, 3)
+            release := make(chan struct{})
+            var mu sync.Mutex
+            var processed []string
+            m := newFakeManager(t, env, "queue", func(ctx context.Context, s *state.State) error {
+                mu.Lock()
+                processed = append(processed, s.Input.Content)
+                mu.Unlock()
+                started <- struct{}{}
+                <-release
+                return nil
+            })
+            e, _ := newTestEngineWithEnv(t, env, WithManagers(m))
+            if err := e.StartWorkers(4); err != nil {
+                t.Fatal(err)
+            }
+
+            ctx, cancel := context.WithCancel(context.Background())
+            defer cancel()
+            inputs := []struct {
+                ctx     context.Context
+                content string
+            }{
+                {context.Background(), "first"},
+                {ctx, "second"},
+                {context.Background(), "third"},
+            }
+            for _, input := range inputs {
+                if err := e.Enqueue(input.ctx, env.NewFragment(user, session, input.content)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            // The second input waits behind the first, which holds the session
+            <-started
+            if tt.cancel {
+                cancel()
+            }
+            close(release)
+            if err := e.Shutdown(context.Background()); err != nil {
+                t.Fatal(err)
+            }
+            if fmt.Sprint(processed) != fmt.Sprint(tt.want) {
+                t.Errorf("processed %v, want %v", processed, tt.want)
+            }
+        })
+    }
+}
+
+func TestEnqueueFull(t *testing.T) {
+    tests := []struct {
+        name string
+        size int
+        // sessions is the number of sessions the inputs are spread over
+        sessions int
+    }{
+        {name: "one session", size: 3, sessions: 1},
+        {name: "several sessions", size: 3, sessions: 3},
+        {name: "no buffer", size: 0, sessions: 1},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t, WithQueueSize(tt.size))
+            user := env.NewActor("Alice", false)
+            sessions := make([]*db.Session, tt.sessions)
+            for i := range sessions {
+                sessions[i] = env.NewSession()
+            }
+
+            // Without workers, the queue fills up whatever the sessions
+            for i := 0; i < tt.size; i++ {
+                if err := e.Enqueue(context.Background(), env.NewFragment(user, sessions[i%tt.sessions], "Hello")); err != nil {
+                    t.Fatalf("input %d: %v", i, err)
+                }
+            }
+            if err := e.Enqueue(context.Background(), env.NewFragment(user, sessions[0], "Hello")); err != ErrQueueFull {
+                t.Errorf("Enqueue on a full queue returned %v, want ErrQueueFull", err)
+            }
+            if depth, _ := e.queueMetrics(); depth != tt.size {
+                t.Errorf("queue depth = %d, want %d", depth, tt.size)
+            }
+
+            if err := e.drainQueue(context.Background()); err != nil {
+                t.Fatal(err)
+            }
+            if err := e.Enqueue(context.Background(), env.NewFragment(user, sessions[0], "Hello")); err != ErrQueueClosed {
+                t.Errorf("Enqueue after drain returned %v, want ErrQueueClosed", err)
+            }
+        })
+    }
+}
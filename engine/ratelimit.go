@@ -0,0 +1,264 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+)
+
+// ErrRateLimited is returned when a session exceeds its response rate limit
+// under the RateLimitReject mode
+var ErrRateLimited = errors.New("response rate limit exceeded")
+
+// CoalescedInputsKey is the state custom data key holding the []*db.Fragment
+// inputs a coalesced Reply answers, oldest first
+const CoalescedInputsKey = "coalesced_inputs"
+
+// maxIdleRateLimitBuckets is the number of sessions above which idle limiter
+// state is discarded
+const maxIdleRateLimitBuckets = 1024
+
+// RateLimitMode determines what happens to a response exceeding the rate limit
+type RateLimitMode int
+
+const (
+    // RateLimitBlock waits until the session may respond again
+    RateLimitBlock RateLimitMode = iota
+    // RateLimitReject returns ErrRateLimited
+    RateLimitReject
+    // RateLimitCoalesce makes Reply calls arriving while a session is limited
+    // share a single response. The first waiting call generates it once the session
+    // may respond again, with all waiting inputs under CoalescedInputsKey; the others
+    // return the same response. Elsewhere it behaves like RateLimitBlock.
+    RateLimitCoalesce
+)
+
+// RateLimitStatus describes the limiter state of a session
+type RateLimitStatus struct {
+    Tokens      float64   // Responses the session may generate right now, fractional while refilling
+    NextTokenAt time.Time // When the next response becomes available, zero if one is available
+    Waiting     int       // Calls blocked waiting for the session
+    Coalesced   int       // Inputs waiting in the pending coalesced reply
+}
+
+// responseLimiter is a token bucket rate limiter keyed by session.
+type responseLimiter struct {
+    per   time.Duration
+    burst int
+    mode  RateLimitMode
+
+    mu      sync.Mutex
+    buckets map[id.ID]*rateBucket
+}
+
+// rateBucket is the limiter state of a single session.
+type rateBucket struct {
+    tokens  float64
+    last    time.Time
+    waiting int
+    pending *coalescedReply
+}
+
+// coalescedReply is a reply shared by several inputs of a rate limited session.
+type coalescedReply struct {
+    inputs   []*db.Fragment
+    done     chan struct{}
+    response *db.Fragment
+    err      error
+}
+
+func newResponseLimiter(per time.Duration, burst int, mode RateLimitMode) *responseLimiter {
+    return &responseLimiter{
+        per:     per,
+        burst:   burst,
+        mode:    mode,
+        buckets: make(map[id.ID]*rateBucket),
+    }
+}
+
+// RateLimitStatus returns the limiter state of a session, for debugging.
+// Returns false if rate limiting is disabled.
+func (e *Engine) RateLimitStatus(sessionID id.ID) (RateLimitStatus, bool) {
+    if e.responseLimiter == nil {
+        return RateLimitStatus{}, false
+    }
+    return e.responseLimiter.status(sessionID, time.Now()), true
+}
+
+// acquireResponse waits until the session may generate a response, or fails
+// according to the rate limit mode.
+func (e *Engine) acquireResponse(ctx context.Context, sessionID id.ID) error {
+    if e.responseLimiter == nil {
+        return nil
+    }
+    return e.responseLimiter.acquire(ctx, sessionID)
+}
+
+// bucket returns the refilled bucket of a session. Must be called with mu held.
+func (l *responseLimiter) bucket(sessionID id.ID, now time.Time) *rateBucket {
+    b, ok := l.buckets[sessionID]
+    if !ok {
+        if len(l.buckets) >= maxIdleRateLimitBuckets {
+            l.pruneIdle(now)
+        }
+        b = &rateBucket{tokens: float64(l.burst), last: now}
+        l.buckets[sessionID] = b
+        return b
+    }
+
+    b.tokens += float64(now.Sub(b.last)) / float64(l.per)
+    if b.tokens > float64(l.burst) {
+        b.tokens = float64(l.burst)
+    }
+    b.last = now
+    return b
+}
+
+// pruneIdle discards the state of sessions that are fully refilled and unused.
+// Must be called with mu held.
+func (l *responseLimiter) pruneIdle(now time.Time) {
+    for sessionID, b := range l.buckets {
+        refilled := b.tokens+float64(now.Sub(b.last))/float64(l.per) >= float64(l.burst)
+        if refilled && b.waiting == 0 && b.pending == nil {
+            delete(l.buckets, sessionID)
+        }
+    }
+}
+
+// wait returns how long until the bucket holds a whole token.
+func (l *responseLimiter) wait(b *rateBucket) time.Duration {
+    return time.Duration((1 - b.tokens) * float64(l.per))
+}
+
+// acquire takes a token for the session, waiting for one unless the mode rejects.
+func (l *responseLimiter) acquire(ctx context.Context, sessionID id.ID) error {
+    l.mu.Lock()
+    b := l.bucket(sessionID, time.Now())
+    if b.tokens >= 1 {
+        b.tokens--
+        l.mu.Unlock()
+        return nil
+    }
+    if l.mode == RateLimitReject {
+        l.mu.Unlock()
+        return fmt.Errorf("session %s: %w", sessionID, ErrRateLimited)
+    }
+    b.waiting++
+    l.mu.Unlock()
+
+    defer func() {
+        l.mu.Lock()
+        b.waiting--
+        l.mu.Unlock()
+    }()
+
+    for {
+        l.mu.Lock()
+        b = l.bucket(sessionID, time.Now())
+        if b.tokens >= 1 {
+            b.tokens--
+            l.mu.Unlock()
+            return nil
+        }
+        wait := l.wait(b)
+        l.mu.Unlock()
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-timer.C:
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        }
+    }
+}
+
+// coalesce takes a token for a Reply input under RateLimitCoalesce. If the
+// session may respond right away the call answers just its own input. Otherwise
+// the first limited call becomes the leader, answering all inputs arriving while
+// it waits, and the returned reply must be finished by it. Later calls return the
+// pending reply with leader false and should wait for it.
+func (l *responseLimiter) coalesce(ctx context.Context, sessionID id.ID, input *db.Fragment) (reply *coalescedReply, leader bool, err error) {
+    l.mu.Lock()
+    b := l.bucket(sessionID, time.Now())
+
+    if b.pending != nil {
+        b.pending.inputs = append(b.pending.inputs, input)
+        reply = b.pending
+        l.mu.Unlock()
+        return reply, false, nil
+    }
+
+    reply = &coalescedReply{
+        inputs: []*db.Fragment{input},
+        done:   make(chan struct{}),
+    }
+
+    if b.tokens >= 1 {
+        b.tokens--
+        l.mu.Unlock()
+        return reply, true, nil
+    }
+
+    b.pending = reply
+    l.mu.Unlock()
+
+    err = l.acquire(ctx, sessionID)
+
+    // Close the reply to new inputs before answering
+    l.mu.Lock()
+    b.pending = nil
+    l.mu.Unlock()
+
+    if err != nil {
+        reply.finish(nil, err)
+        return nil, false, err
+    }
+    return reply, true, nil
+}
+
+// status returns the limiter state of a session.
+func (l *responseLimiter) status(sessionID id.ID, now time.Time) RateLimitStatus {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    b, ok := l.buckets[sessionID]
+    if !ok {
+        return RateLimitStatus{Tokens: float64(l.burst)}
+    }
+    b = l.bucket(sessionID, now)
+
+    status := RateLimitStatus{
+        Tokens:  b.tokens,
+        Waiting: b.waiting,
+    }
+    if b.tokens < 1 {
+        status.NextTokenAt = now.Add(l.wait(b))
+    }
+    if b.pending != nil {
+        status.Coalesced = len(b.pending.inputs)
+    }
+    return status
+}
+
+// finish publishes the outcome of a coalesced reply to the waiting calls.
+func (r *coalescedReply) finish(response *db.Fragment, err error) {
+    r.response = response
+    r.err = err
+    close(r.done)
+}
+
+// wait blocks until the coalesced reply is finished or ctx is done.
+func (r *coalescedReply) wait(ctx context.Context) (*db.Fragment, error) {
+    select {
+    case <-r.done:
+        return r.response, r.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/options"
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+
+    "github.com/sirupsen/logrus"
+)
+
+// ReplyOptions controls Reply
+type ReplyOptions struct {
+    AssistantID id.ID // Registered assistant authoring the response
+}
+
+// ReplyOption configures a Reply call
+type ReplyOption = options.Option[ReplyOptions]
+
+// WithAssistant makes a registered assistant author the response.
+func WithAssistant(assistantID id.ID) ReplyOption {
+    return func(o *ReplyOptions) error {
+        o.AssistantID = assistantID
+        return nil
+    }
+}
+
+// Reply runs the full pipeline for a single input and returns the stored response:
+// 1. Creates the input's session, and its actor from input.Actor, if they don't exist
+// 2. Runs Process on a new state for the input
+// 3. Collects the managers' context data into the state with CollectContext
+// 4. Builds the prompt with promptFn on a state-backed PromptBuilder
+// 5. Generates the response with the state's tools, as the engine's default
+//    assistant or the one selected with WithAssistant, subject to the response
+//    rate limit; with RateLimitCoalesce concurrent calls of a limited session
+//    may share one response
+// 6. Runs PostProcess, which stores the response
+// Errors are wrapped with the name of the stage that failed. ctx is checked
+// between stages, so a cancelled context stops the pipeline early.
+func (e *Engine) Reply(ctx context.Context, input *db.Fragment, promptFn func(*state.PromptBuilder) error, opts ...ReplyOption) (*db.Fragment, error) {
+    if input == nil {
+        return nil, fmt.Errorf("input is required")
+    }
+    if promptFn == nil {
+        return nil, fmt.Errorf("prompt function is required")
+    }
+
+    replyOpts := ReplyOptions{AssistantID: e.ID}
+    if err := options.ApplyOptions(&replyOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid reply options: %w", err)
+    }
+    assistant, err := e.GetAssistant(replyOpts.AssistantID)
+    if err != nil {
+        return nil, fmt.Errorf("prepare: %w", err)
+    }
+
+    if err := e.ensureParticipants(input); err != nil {
+        return nil, fmt.Errorf("prepare: %w", err)
+    }
+
+    currentState := state.NewState()
+    currentState.Input = input
+    currentState.SetStrictKeys(e.strictStateKeys)
+    currentState.Assistant = assistant.actor()
+
+    if err := ctx.Err(); err != nil {
+        return nil, fmt.Errorf("process: %w", err)
+    }
+    if err := e.Process(currentState); err != nil {
+        return nil, fmt.Errorf("process: %w", err)
+    }
+
+    if e.responseLimiter != nil && e.responseLimiter.mode == RateLimitCoalesce {
+        reply, leader, err := e.responseLimiter.coalesce(ctx, input.SessionID, currentState.Input)
+        if err != nil {
+            return nil, fmt.Errorf("generate: %w", err)
+        }
+        if !leader {
+            response, err := reply.wait(ctx)
+            if err != nil {
+                return nil, fmt.Errorf("generate: %w", err)
+            }
+            return response, nil
+        }
+
+        currentState.AddCustomData(CoalescedInputsKey, reply.inputs)
+        response, err := e.reply(ctx, currentState, assistant, promptFn)
+        reply.finish(response, err)
+        return response, err
+    }
+
+    if err := e.acquireResponse(ctx, input.SessionID); err != nil {
+        return nil, fmt.Errorf("generate: %w", err)
+    }
+
+    return e.reply(ctx, currentState, assistant, promptFn)
+}
+
+// reply collects the manager context of a processed state, builds the prompt,
+// generates the response and post-processes it.
+func (e *Engine) reply(ctx context.Context, currentState *state.State, assistant AssistantProfile, promptFn func(*state.PromptBuilder) error) (*db.Fragment, error) {
+    if err := e.collectReplyContext(ctx, currentState); err != nil {
+        return nil, fmt.Errorf("collect context: %w", err)
+    }
+
+    builder := state.NewPromptBuilder(currentState)
+    if err := promptFn(builder); err != nil {
+        return nil, fmt.Errorf("build prompt: %w", err)
+    }
+    messages, err := builder.Compose()
+    if err != nil {
+        return nil, fmt.Errorf("build prompt: %w", err)
+    }
+    e.logPrompt(builder, currentState)
+
+    if err := ctx.Err(); err != nil {
+        return nil, fmt.Errorf("generate: %w", err)
+    }
+    response, err := e.generateResponse(assistant, messages, currentState.Input.SessionID, ResponseOptions{
+        Tools:  currentState.GetTools(),
+        Prompt: builder.PromptInfo(),
+    })
+    if err != nil {
+        return nil, fmt.Errorf("generate: %w", err)
+    }
+
+    if err := ctx.Err(); err != nil {
+        return nil, fmt.Errorf("post process: %w", err)
+    }
+    if err := e.PostProcess(response, currentState); err != nil {
+        return nil, fmt.Errorf("post process: %w", err)
+    }
+
+    return response, nil
+}
+
+// logPrompt logs the render report of a composed prompt if prompt debug
+// logging is enabled, under the "prompt" field so the logger's redaction rules
+// apply to it. Failing to build the report doesn't fail the reply.
+func (e *Engine) logPrompt(builder *state.PromptBuilder, currentState *state.State) {
+    if !e.promptDebug || !e.logger.IsLevelEnabled(logrus.DebugLevel) {
+        return
+    }
+
+    log := e.logger.WithField("session_id", currentState.Input.SessionID)
+    report, err := builder.Debug()
+    if err != nil {
+        log.WithError(err).Warn("Failed to build prompt render report")
+        return
+    }
+    log.WithFields(map[string]interface{}{
+        "sections": len(report.Sections),
+        "tokens":   report.Tokens,
+        "prompt":   report.Redact(e.promptDebugRedact...).String(),
+    }).Debug("Prompt sent to the model")
+}
+
+// ensureParticipants creates the input's session and actor if they don't exist yet.
+// The actor can only be created if the input carries it.
+func (e *Engine) ensureParticipants(input *db.Fragment) error {
+    if _, err := e.sessionStore.GetByID(input.SessionID); err != nil {
+        if !errors.Is(err, stores.ErrNotFound) {
+            return fmt.Errorf("failed to get session: %w", err)
+        }
+        if err := e.UpsertSession(input.SessionID); err != nil {
+            return err
+        }
+    }
+
+    if _, err := e.actorStore.GetByID(input.ActorID); err != nil {
+        if !errors.Is(err, stores.ErrNotFound) {
+            return fmt.Errorf("failed to get actor: %w", err)
+        }
+        if input.Actor == nil {
+            return fmt.Errorf("actor %s was never upserted and input has no actor to create", input.ActorID)
+        }
+        if err := e.UpsertActor(input.ActorID, input.Actor.Name, input.Actor.Assistant); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/logger"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+func TestLogPromptRedacts(t *testing.T) {
+    tests := []struct {
+        name      string
+        rules     logger.RedactionRules
+        redact    []string
+        want      []string
+        forbidden []string
+    }{
+        {
+            name:      "pattern",
+            rules:     logger.RedactionRules{Patterns: []string{`sk-[a-z0-9]{8}`}},
+            want:      []string{"Prompt sent to the model", "[REDACTED]"},
+            forbidden: []string{"sk-abcdef12"},
+        },
+        {
+            name:      "truncated field",
+            rules:     logger.RedactionRules{Truncate: map[string]int{"prompt": 10}},
+            want:      []string{"Prompt sent to the model"},
+            forbidden: []string{"sk-abcdef12", "Answer kindly"},
+        },
+        {
+            name:      "redacted state key",
+            redact:    []string{"Input"},
+            want:      []string{"Answer kindly"},
+            forbidden: []string{"sk-abcdef12"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var out bytes.Buffer
+            log, err := logger.New(&logger.Config{Level: "debug", JSONFormat: true, Output: &out, Redaction: &tt.rules})
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            env := managertest.NewTestEnvironment(t)
+            e, _ := newTestEngineWithEnv(t, env, WithLogger(log), WithPromptDebugLogging(tt.redact...))
+            s := newTestInput(env, "my key is sk-abcdef12")
+
+            out.Reset()
+            builder := state.NewPromptBuilder(s).
+                AddSystemSection("Answer kindly.").
+                AddUserSection("{{.Input.Content}}", "")
+            e.logPrompt(builder, s)
+
+            for _, want := range tt.want {
+                if !strings.Contains(out.String(), want) {
+                    t.Errorf("output lacks %q: %s", want, out.String())
+                }
+            }
+            for _, forbidden := range tt.forbidden {
+                if strings.Contains(out.String(), forbidden) {
+                    t.Errorf("output leaks %q: %s", forbidden, out.String())
+                }
+            }
+        })
+    }
+}
+
+func TestEnsureParticipants(t *testing.T) {
+    tests := []struct {
+        name           string
+        missingActor   bool
+        missingSession bool
+        // inputActor is whether the input carries its actor
+        inputActor bool
+        err        string
+    }{
+        {name: "existing"},
+        {name: "missing session", missingSession: true},
+        {name: "missing actor carried by the input", missingActor: true, inputActor: true},
+        {name: "missing actor", missingActor: true, err: "was never upserted and input has no actor to create"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t)
+            input := &db.Fragment{
+                ID:        id.New(),
+                ActorID:   env.NewActor("Alice", false).ID,
+                SessionID: env.NewSession().ID,
+                Content:   "Hello",
+            }
+            if tt.missingActor {
+                input.ActorID = id.New()
+            }
+            if tt.missingSession {
+                input.SessionID = id.New()
+            }
+            if tt.inputActor {
+                input.Actor = &db.Actor{ID: input.ActorID, Name: "Bob"}
+            }
+
+            err := e.ensureParticipants(input)
+            if tt.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                    t.Fatalf("error = %v, want %q", err, tt.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if _, err := env.SessionStore.GetByID(input.SessionID); err != nil {
+                t.Errorf("session of the input: %v", err)
+            }
+            actor, err := env.ActorStore.GetByID(input.ActorID)
+            if err != nil {
+                t.Fatalf("actor of the input: %v", err)
+            }
+            if tt.inputActor && actor.Name != "Bob" {
+                t.Errorf("actor name = %q, want the input's Bob", actor.Name)
+            }
+        })
+    }
+}
@@ -0,0 +1,57 @@
+package engine
+
+import (
+    "fmt"
+
+    "github.com/velumlabs/thor/manager"
+)
+
+// GetManager returns a registered manager, unwrapped from any manager
+// middleware so it can be type asserted to its concrete type.
+func (e *Engine) GetManager(managerID manager.ManagerID) (manager.Manager, bool) {
+    managers, _, _ := e.snapshotManagers()
+    for _, m := range managers {
+        if m.GetID() == managerID {
+            return manager.Unwrap(m), true
+        }
+    }
+    return nil, false
+}
+
+// dependencyResolver resolves the declared dependencies of a single manager.
+type dependencyResolver struct {
+    engine   *Engine
+    owner    manager.ManagerID
+    declared map[manager.ManagerID]bool
+}
+
+// Resolve returns a dependency of the owning manager, looked up at call time so
+// replaced managers are picked up.
+func (r *dependencyResolver) Resolve(managerID manager.ManagerID) (manager.Manager, error) {
+    if !r.declared[managerID] {
+        return nil, fmt.Errorf("manager %s accessing %s: %w", r.owner, managerID, manager.ErrUndeclaredDependency)
+    }
+    m, ok := r.engine.GetManager(managerID)
+    if !ok {
+        return nil, fmt.Errorf("manager %s accessing %s: %w", r.owner, managerID, manager.ErrManagerNotFound)
+    }
+    return m, nil
+}
+
+// injectResolver gives a manager accepting a resolver access to its declared dependencies.
+func (e *Engine) injectResolver(m manager.Manager) {
+    receiver, ok := manager.Unwrap(m).(manager.ResolverReceiver)
+    if !ok {
+        return
+    }
+
+    declared := make(map[manager.ManagerID]bool)
+    for _, dep := range m.GetDependencies() {
+        declared[dep] = true
+    }
+    receiver.SetManagerResolver(&dependencyResolver{
+        engine:   e,
+        owner:    m.GetID(),
+        declared: declared,
+    })
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/options"
+    "github.com/velumlabs/thor/state"
+
+    toolkit "github.com/velumlabs/toolkit/go"
+)
+
+// Metadata keys the engine sets on every generated response. They always hold
+// the engine's values: caller metadata and manager changes to these keys are ignored.
+const (
+    ResponseModelKey     = "model"      // Name of the model that generated the response
+    ResponseUsageKey     = "usage"      // Tokens consumed, with prompt_tokens, completion_tokens and total_tokens
+    ResponseLatencyKey   = "latency_ms" // Completion latency in milliseconds
+    ResponseToolCallsKey = "tool_calls" // Tool calls executed, each with name and arguments
+
+    ResponsePromptVersionKey = "prompt_version" // Version of the prompt, if it has one
+    ResponsePromptHashKey    = "prompt_hash"    // Hash of the prompt's section templates
+)
+
+// reservedResponseKeys are the response metadata keys owned by the engine
+var reservedResponseKeys = []string{
+    ResponseModelKey,
+    ResponseUsageKey,
+    ResponseLatencyKey,
+    ResponseToolCallsKey,
+    ResponsePromptVersionKey,
+    ResponsePromptHashKey,
+    dryRunMetadataKey,
+}
+
+// ResponseOptions controls GenerateResponse
+type ResponseOptions struct {
+    Tools    []toolkit.Tool   // Tools the model may call
+    Metadata db.Metadata      // Caller metadata merged into the response
+    Prompt   state.PromptInfo // Prompt the messages were composed from, if known
+}
+
+// ResponseOption configures a generated response
+type ResponseOption = options.Option[ResponseOptions]
+
+// WithTools makes the tools available to the model while generating the response.
+func WithTools(tools ...toolkit.Tool) ResponseOption {
+    return func(o *ResponseOptions) error {
+        o.Tools = append(o.Tools, tools...)
+        return nil
+    }
+}
+
+// WithMetadata merges the keys into the metadata of the generated response, so
+// they are stored with it by PostProcess. When given several times, later keys
+// win. Keys the engine sets itself, such as ResponseModelKey, cannot be overridden.
+func WithMetadata(metadata db.Metadata) ResponseOption {
+    return func(o *ResponseOptions) error {
+        if o.Metadata == nil {
+            o.Metadata = db.Metadata{}
+        }
+        for key, value := range metadata.Clone() {
+            o.Metadata[key] = value
+        }
+        return nil
+    }
+}
+
+// WithPromptInfo records the version and hash of the prompt the messages were
+// composed from in the response's metadata, under ResponsePromptVersionKey and
+// ResponsePromptHashKey. Reply records them itself.
+func WithPromptInfo(info state.PromptInfo) ResponseOption {
+    return func(o *ResponseOptions) error {
+        o.Prompt = info
+        return nil
+    }
+}
+
+// responseMetadata returns the metadata of a newly generated response: the
+// caller's keys overlaid with the engine's.
+func (e *Engine) responseMetadata(opts ResponseOptions, completion llm.Message, latency time.Duration) db.Metadata {
+    metadata := opts.Metadata.Clone()
+    if metadata == nil {
+        metadata = db.Metadata{}
+    }
+
+    if completion.Model != "" {
+        metadata[ResponseModelKey] = completion.Model
+    }
+    metadata[ResponseUsageKey] = map[string]interface{}{
+        "prompt_tokens":     completion.Usage.PromptTokens,
+        "completion_tokens": completion.Usage.CompletionTokens,
+        "total_tokens":      completion.Usage.TotalTokens,
+    }
+    metadata[ResponseLatencyKey] = latency.Milliseconds()
+    if len(completion.ToolCalls) > 0 {
+        toolCalls := make([]interface{}, len(completion.ToolCalls))
+        for i, call := range completion.ToolCalls {
+            toolCalls[i] = map[string]interface{}{
+                "name":      call.Name,
+                "arguments": call.Arguments,
+            }
+        }
+        metadata[ResponseToolCallsKey] = toolCalls
+    }
+    if opts.Prompt.Version != "" {
+        metadata[ResponsePromptVersionKey] = opts.Prompt.Version
+    }
+    if opts.Prompt.Hash != "" {
+        metadata[ResponsePromptHashKey] = opts.Prompt.Hash
+    }
+
+    e.markDryRun(metadata)
+    return metadata
+}
+
+// mergeManagerMetadata copies the metadata managers set on the response's
+// state copy into the stored response, keeping the engine's reserved keys.
+func mergeManagerMetadata(response *db.Fragment, output *db.Fragment) {
+    if len(output.Metadata) == 0 {
+        return
+    }
+    if response.Metadata == nil {
+        response.Metadata = db.Metadata{}
+    }
+
+    reserved := make(map[string]bool, len(reservedResponseKeys))
+    for _, key := range reservedResponseKeys {
+        reserved[key] = true
+    }
+    for key, value := range output.Metadata {
+        if !reserved[key] {
+            response.Metadata[key] = value
+        }
+    }
+}
+
+// CountResponsesByPromptVersion returns the number of stored responses by the
+// version of the prompt that produced them, to compare prompt variants.
+// Responses without a prompt version aren't counted.
+func (e *Engine) CountResponsesByPromptVersion(ctx context.Context) (map[string]int64, error) {
+    counts, err := e.interactionFragmentStore.WithContext(ctx).CountByMetadata(ResponsePromptVersionKey)
+    if err != nil {
+        return nil, fmt.Errorf("failed to count responses by prompt version: %w", err)
+    }
+    return counts, nil
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+)
+
+// postProcessManager is a fake manager whose PostProcess runs postProcess
+type postProcessManager struct {
+    *fakeManager
+    postProcess func(s *state.State) error
+}
+
+func (m *postProcessManager) PostProcess(s *state.State) error {
+    return m.postProcess(s)
+}
+
+// withCompletion makes the provider of env answer with the model and usage
+func withCompletion(env *managertest.TestEnvironment, model string, usage llm.Usage) {
+    env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+        return llm.Message{Role: llm.RoleAssistant, Content: "Hi", Model: model, Usage: usage}, nil
+    }
+}
+
+func TestGenerateResponseMetadata(t *testing.T) {
+    usage := llm.Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}
+    wantUsage := map[string]interface{}{"prompt_tokens": 7, "completion_tokens": 3, "total_tokens": 10}
+
+    tests := []struct {
+        name string
+        opts []ResponseOption
+        want db.Metadata
+    }{
+        {
+            name: "engine keys only",
+            want: db.Metadata{ResponseModelKey: "gpt-test", ResponseUsageKey: wantUsage},
+        },
+        {
+            name: "caller keys",
+            opts: []ResponseOption{WithMetadata(db.Metadata{"platform": "discord", "channel": "general"})},
+            want: db.Metadata{"platform": "discord", "channel": "general", ResponseModelKey: "gpt-test"},
+        },
+        {
+            name: "later caller keys win",
+            opts: []ResponseOption{
+                WithMetadata(db.Metadata{"platform": "discord", "channel": "general"}),
+                WithMetadata(db.Metadata{"channel": "random"}),
+            },
+            want: db.Metadata{"platform": "discord", "channel": "random"},
+        },
+        {
+            name: "engine keys win over caller keys",
+            opts: []ResponseOption{WithMetadata(db.Metadata{
+                ResponseModelKey: "caller-model",
+                ResponseUsageKey: "none",
+                "platform":       "discord",
+            })},
+            want: db.Metadata{ResponseModelKey: "gpt-test", ResponseUsageKey: wantUsage, "platform": "discord"},
+        },
+        {
+            name: "prompt info",
+            opts: []ResponseOption{WithPromptInfo(state.PromptInfo{Version: "v2", Hash: "abc"})},
+            want: db.Metadata{ResponsePromptVersionKey: "v2", ResponsePromptHashKey: "abc"},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t)
+            withCompletion(env, "gpt-test", usage)
+
+            response, err := e.GenerateResponse([]llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, env.NewSession().ID, tt.opts...)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for key, want := range tt.want {
+                if got := response.Metadata[key]; fmt.Sprint(got) != fmt.Sprint(want) {
+                    t.Errorf("metadata %s = %v, want %v", key, got, want)
+                }
+            }
+            if _, ok := response.Metadata[ResponseLatencyKey]; !ok {
+                t.Errorf("metadata %v has no latency", response.Metadata)
+            }
+        })
+    }
+}
+
+func TestWithMetadataCopies(t *testing.T) {
+    e, env := newTestEngine(t)
+    withCompletion(env, "gpt-test", llm.Usage{})
+
+    caller := db.Metadata{"platform": "discord"}
+    response, err := e.GenerateResponse([]llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, env.NewSession().ID, WithMetadata(caller))
+    if err != nil {
+        t.Fatal(err)
+    }
+    caller["platform"] = "slack"
+    if got := response.Metadata["platform"]; got != "discord" {
+        t.Errorf("platform = %v after changing the caller's metadata, want discord", got)
+    }
+    if _, ok := caller[ResponseModelKey]; ok {
+        t.Error("the engine's keys were written to the caller's metadata")
+    }
+}
+
+func TestResponseMetadataToolCalls(t *testing.T) {
+    e, _ := newTestEngine(t)
+    completion := llm.Message{
+        Model:     "gpt-test",
+        ToolCalls: []llm.ToolCall{{Name: "search", Arguments: `{"query":"thor"}`, Result: "found"}},
+    }
+
+    metadata := e.responseMetadata(ResponseOptions{}, completion, 1500*time.Millisecond)
+    if got := metadata[ResponseLatencyKey]; got != int64(1500) {
+        t.Errorf("latency = %v, want 1500", got)
+    }
+    want := `[map[arguments:{"query":"thor"} name:search]]`
+    if got := fmt.Sprint(metadata[ResponseToolCallsKey]); got != want {
+        t.Errorf("tool calls = %s, want %s", got, want)
+    }
+}
+
+func TestPostProcessKeepsResponseMetadata(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    withCompletion(env, "gpt-test", llm.Usage{TotalTokens: 10})
+    tagger := &postProcessManager{
+        fakeManager: newFakeManager(t, env, "tagger", nil),
+        postProcess: func(s *state.State) error {
+            s.Output.Metadata["topic"] = "greeting"
+            s.Output.Metadata["platform"] = "manager"
+            s.Output.Metadata[ResponseModelKey] = "manager-model"
+            delete(s.Output.Metadata, ResponseUsageKey)
+            return nil
+        },
+    }
+    e, _ := newTestEngineWithEnv(t, env, WithManagers(tagger))
+
+    s := newTestInput(env, "Hello")
+    if err := e.Process(s); err != nil {
+        t.Fatal(err)
+    }
+    response, err := e.GenerateResponse([]llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, s.Input.SessionID,
+        WithMetadata(db.Metadata{"platform": "discord"}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := e.PostProcess(response, s); err != nil {
+        t.Fatal(err)
+    }
+
+    stored, err := env.InteractionFragmentStore.GetByID(response.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        key  string
+        want interface{}
+    }{
+        // Managers may add and change keys of their own, including caller keys
+        {key: "topic", want: "greeting"},
+        {key: "platform", want: "manager"},
+        // but not the engine's
+        {key: ResponseModelKey, want: "gpt-test"},
+        {key: ResponseUsageKey, want: map[string]interface{}{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 10}},
+    }
+    for _, tt := range tests {
+        if got := stored.Metadata[tt.key]; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Errorf("stored metadata %s = %v, want %v", tt.key, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "gorm.io/gorm"
+)
+
+// RetryPolicy controls how store operations in the pipeline are retried
+// after transient errors
+type RetryPolicy struct {
+    Attempts       int           // Total number of attempts, including the first one
+    InitialBackoff time.Duration // Wait before the first retry, doubled for each further retry
+    MaxBackoff     time.Duration // Upper bound for the wait between retries, zero for no bound
+}
+
+// retryStore runs a store operation, retrying it according to the engine's retry
+// policy while it fails with a transient error. Operations inside a transaction
+// are not retried, since a failed statement aborts the whole transaction.
+func retryStore[T any](e *Engine, tx *gorm.DB, fn func() (T, error)) (T, error) {
+    result, err := fn()
+    if err == nil || tx != nil || e.storeRetry.Attempts <= 1 {
+        return result, err
+    }
+
+    backoff := e.storeRetry.InitialBackoff
+    attempt := 1
+    for ; attempt < e.storeRetry.Attempts && isTransientStoreError(err); attempt++ {
+        e.logger.WithError(err).WithField("attempt", attempt).Warn("Retrying store operation after transient error")
+
+        select {
+        case <-time.After(backoff):
+        case <-e.ctx.Done():
+            return result, fmt.Errorf("%w (gave up after %d attempts: %v)", err, attempt, e.ctx.Err())
+        }
+
+        backoff *= 2
+        if e.storeRetry.MaxBackoff > 0 && backoff > e.storeRetry.MaxBackoff {
+            backoff = e.storeRetry.MaxBackoff
+        }
+
+        if result, err = fn(); err == nil {
+            return result, nil
+        }
+    }
+
+    if attempt > 1 {
+        return result, fmt.Errorf("%w (failed after %d attempts)", err, attempt)
+    }
+    return result, err
+}
+
+// retryStoreWrite is retryStore for operations without a result.
+func (e *Engine) retryStoreWrite(tx *gorm.DB, fn func() error) error {
+    _, err := retryStore(e, tx, func() (struct{}, error) {
+        return struct{}{}, fn()
+    })
+    return err
+}
+
+// isTransientStoreError reports whether a store error is worth retrying:
+// lost connections, serialization failures and deadlocks.
+func isTransientStoreError(err error) bool {
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) {
+        // serialization_failure, deadlock_detected and connection exceptions
+        return pgErr.Code == "40001" || pgErr.Code == "40P01" || strings.HasPrefix(pgErr.Code, "08")
+    }
+
+    if errors.Is(err, driver.ErrBadConn) ||
+        errors.Is(err, io.ErrUnexpectedEOF) ||
+        errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, syscall.ECONNREFUSED) ||
+        errors.Is(err, syscall.EPIPE) {
+        return true
+    }
+
+    var netErr net.Error
+    return errors.As(err, &netErr)
+}
@@ -0,0 +1,148 @@
+package engine
+
+import (
+    "context"
+    "database/sql/driver"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+    "gorm.io/gorm"
+)
+
+// flakyStore is a fake store operation failing with err for its first
+// failures calls
+type flakyStore struct {
+    failures int
+    err      error
+    calls    int
+}
+
+func (s *flakyStore) get() (string, error) {
+    s.calls++
+    if s.calls <= s.failures {
+        return "", s.err
+    }
+    return "record", nil
+}
+
+func TestIsTransientStoreError(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want bool
+    }{
+        {name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+        {name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+        {name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+        {name: "wrapped postgres error", err: fmt.Errorf("failed to update session: %w", &pgconn.PgError{Code: "40001"}), want: true},
+        {name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+        {name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+        {name: "bad connection", err: driver.ErrBadConn, want: true},
+        {name: "broken pipe", err: fmt.Errorf("write: %w", syscall.EPIPE), want: true},
+        {name: "not found", err: gorm.ErrRecordNotFound},
+        {name: "other", err: errors.New("invalid metadata")},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := isTransientStoreError(tt.err); got != tt.want {
+                t.Errorf("isTransientStoreError(%v) = %v, want %v", tt.err, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRetryStore(t *testing.T) {
+    transient := &pgconn.PgError{Code: "40001"}
+    tests := []struct {
+        name     string
+        attempts int
+        failures int
+        err      error
+        // inTx runs the operation inside a transaction
+        inTx      bool
+        wantCalls int
+        // wantErr is part of the error expected, if any
+        wantErr string
+    }{
+        {name: "success", attempts: 3, wantCalls: 1},
+        {name: "recovers", attempts: 3, failures: 2, err: transient, wantCalls: 3},
+        {name: "gives up", attempts: 3, failures: 5, err: transient, wantCalls: 3, wantErr: "failed after 3 attempts"},
+        {name: "permanent error", attempts: 3, failures: 1, err: gorm.ErrRecordNotFound, wantCalls: 1, wantErr: "record not found"},
+        {name: "no retries by default", attempts: 1, failures: 1, err: transient, wantCalls: 1, wantErr: "40001"},
+        {name: "transaction", attempts: 3, failures: 1, err: transient, inTx: true, wantCalls: 1, wantErr: "40001"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e, env := newTestEngine(t, WithStoreRetry(RetryPolicy{Attempts: tt.attempts, InitialBackoff: time.Millisecond}))
+            var tx *gorm.DB
+            if tt.inTx {
+                tx = env.DB
+            }
+
+            store := &flakyStore{failures: tt.failures, err: tt.err}
+            got, err := retryStore(e, tx, store.get)
+            if store.calls != tt.wantCalls {
+                t.Errorf("calls = %d, want %d", store.calls, tt.wantCalls)
+            }
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("error = %v, want %q", err, tt.wantErr)
+                }
+                if !errors.Is(err, tt.err) {
+                    t.Errorf("error %v does not wrap %v", err, tt.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != "record" {
+                t.Errorf("result = %q, want record", got)
+            }
+        })
+    }
+}
+
+func TestRetryStoreCanceled(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    e, _ := newTestEngine(t, WithStoreRetry(RetryPolicy{Attempts: 3, InitialBackoff: time.Hour}), WithContext(ctx))
+    cancel()
+
+    store := &flakyStore{failures: 1, err: syscall.ECONNRESET}
+    _, err := retryStore(e, nil, store.get)
+    if err == nil || !strings.Contains(err.Error(), "gave up after 1 attempts") {
+        t.Fatalf("error = %v, want to give up once canceled", err)
+    }
+    if store.calls != 1 {
+        t.Errorf("calls = %d, want 1", store.calls)
+    }
+}
+
+func TestWithStoreRetry(t *testing.T) {
+    tests := []struct {
+        name   string
+        policy RetryPolicy
+        valid  bool
+    }{
+        {name: "valid", policy: RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Second}, valid: true},
+        {name: "no attempts", policy: RetryPolicy{}},
+        {name: "negative backoff", policy: RetryPolicy{Attempts: 2, InitialBackoff: -time.Millisecond}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := WithStoreRetry(tt.policy)(&Engine{})
+            if (err == nil) != tt.valid {
+                t.Errorf("WithStoreRetry(%+v) = %v, want valid %v", tt.policy, err, tt.valid)
+            }
+        })
+    }
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+)
+
+const (
+    // scheduleBatchSize is the number of due scheduled responses claimed per poll
+    scheduleBatchSize = 50
+
+    // staleScheduleClaim is how long a scheduled response may stay running before
+    // the scheduler assumes its process died and runs it again
+    staleScheduleClaim = 10 * time.Minute
+
+    // defaultScheduleLateThreshold is how overdue a scheduled response must be to be flagged late
+    defaultScheduleLateThreshold = time.Minute
+
+    // followUpHistoryLimit is the number of recent fragments the default schedule
+    // handler includes in the follow-up prompt
+    followUpHistoryLimit = 20
+
+    // partitionMaintenanceInterval is how often the scheduler creates upcoming
+    // partitions, see WithPartitionMaintenance
+    partitionMaintenanceInterval = 24 * time.Hour
+)
+
+// ErrScheduleNotFound is returned when cancelling a scheduled response that is not pending
+var ErrScheduleNotFound = errors.New("scheduled response not found or not pending")
+
+// SchedulePayload describes the response to produce when a schedule fires
+type SchedulePayload struct {
+    AssistantID id.ID       // Registered assistant answering, the engine's default assistant if empty
+    Instruction string      // What to follow up on, e.g. "Remind the user to stretch"
+    Data        db.Metadata // Arbitrary data for the schedule handler
+}
+
+// ScheduledItem is a due scheduled response passed to the schedule handler
+type ScheduledItem struct {
+    db.ScheduledResponse
+    // Late is set when the item fires noticeably after its due time,
+    // e.g. because the engine was down
+    Late bool
+}
+
+// ScheduleHandler produces the response of a due scheduled response
+type ScheduleHandler func(ctx context.Context, item ScheduledItem) error
+
+// scheduler is the background loop firing scheduled responses.
+type scheduler struct {
+    cancel context.CancelFunc
+    done   chan struct{}
+}
+
+// ScheduleResponse schedules a response in a session at runAt. The schedule is
+// persisted, so it fires even if the engine restarts in between, as long as
+// StartScheduler runs. Returns the ID of the scheduled response.
+func (e *Engine) ScheduleResponse(ctx context.Context, sessionID id.ID, runAt time.Time, payload SchedulePayload) (id.ID, error) {
+    if payload.AssistantID == "" {
+        payload.AssistantID = e.ID
+    }
+    if _, err := e.GetAssistant(payload.AssistantID); err != nil {
+        return "", err
+    }
+
+    schedule := &db.ScheduledResponse{
+        ID:          id.New(),
+        SessionID:   sessionID,
+        AssistantID: payload.AssistantID,
+        Instruction: payload.Instruction,
+        Data:        payload.Data,
+        RunAt:       runAt,
+        Status:      db.SchedulePending,
+    }
+    if err := e.scheduleStore.WithContext(ctx).Create(schedule); err != nil {
+        return "", fmt.Errorf("failed to schedule response: %w", err)
+    }
+
+    return schedule.ID, nil
+}
+
+// CancelScheduledResponse cancels a pending scheduled response.
+// Returns ErrScheduleNotFound if it doesn't exist or is no longer pending.
+func (e *Engine) CancelScheduledResponse(ctx context.Context, scheduleID id.ID) error {
+    cancelled, err := e.scheduleStore.WithContext(ctx).Cancel(scheduleID)
+    if err != nil {
+        return err
+    }
+    if !cancelled {
+        return fmt.Errorf("schedule %s: %w", scheduleID, ErrScheduleNotFound)
+    }
+    return nil
+}
+
+// ListScheduledResponses returns the pending scheduled responses of a session, earliest first.
+func (e *Engine) ListScheduledResponses(ctx context.Context, sessionID id.ID) ([]db.ScheduledResponse, error) {
+    return e.scheduleStore.WithContext(ctx).ListPending(sessionID)
+}
+
+// StartScheduler starts the background loop firing due scheduled responses,
+// checking for them every pollInterval. Scheduled responses missed while the
+// engine was down fire immediately, flagged as late. The loop stops on Shutdown.
+func (e *Engine) StartScheduler(pollInterval time.Duration) error {
+    if pollInterval <= 0 {
+        return fmt.Errorf("poll interval must be positive")
+    }
+
+    e.schedulerMu.Lock()
+    defer e.schedulerMu.Unlock()
+
+    if e.scheduler != nil {
+        return fmt.Errorf("scheduler is already running")
+    }
+
+    ctx, cancel := context.WithCancel(e.ctx)
+    e.scheduler = &scheduler{
+        cancel: cancel,
+        done:   make(chan struct{}),
+    }
+
+    go e.runScheduler(ctx, pollInterval, e.scheduler.done)
+    return nil
+}
+
+// stopScheduler stops the scheduler loop and waits until the scheduled
+// response being fired, if any, has finished or ctx is done.
+func (e *Engine) stopScheduler(ctx context.Context) error {
+    e.schedulerMu.Lock()
+    current := e.scheduler
+    e.scheduler = nil
+    e.schedulerMu.Unlock()
+
+    if current == nil {
+        return nil
+    }
+
+    current.cancel()
+    select {
+    case <-current.done:
+        return nil
+    case <-ctx.Done():
+        return fmt.Errorf("scheduler did not stop: %w", ctx.Err())
+    }
+}
+
+// runScheduler fires due scheduled responses until ctx is done.
+func (e *Engine) runScheduler(ctx context.Context, pollInterval time.Duration, done chan struct{}) {
+    defer close(done)
+
+    store := e.scheduleStore.WithContext(ctx)
+
+    if released, err := store.ReleaseStale(time.Now().Add(-staleScheduleClaim)); err != nil {
+        e.logger.WithError(err).Warn("Failed to release stale scheduled responses")
+    } else if released > 0 {
+        e.logger.WithField("count", released).Info("Released stale scheduled responses")
+    }
+
+    ticker := time.NewTicker(pollInterval)
+    defer ticker.Stop()
+
+    var partitionsCreatedAt time.Time
+    for {
+        if e.partitionsAhead > 0 && time.Since(partitionsCreatedAt) >= partitionMaintenanceInterval {
+            e.createPartitions(ctx)
+            partitionsCreatedAt = time.Now()
+        }
+        e.fireDueSchedules(ctx)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// createPartitions creates the upcoming partitions of the interaction table,
+// see WithPartitionMaintenance.
+func (e *Engine) createPartitions(ctx context.Context) {
+    if e.dryRun {
+        return
+    }
+    if err := db.EnsurePartitions(e.db.WithContext(ctx), db.FragmentTableInteraction, e.partitionsAhead); err != nil {
+        e.logger.WithError(err).Warn("Failed to create upcoming partitions")
+    }
+}
+
+// fireDueSchedules claims and runs all scheduled responses that are due.
+func (e *Engine) fireDueSchedules(ctx context.Context) {
+    store := e.scheduleStore.WithContext(ctx)
+
+    for ctx.Err() == nil {
+        now := time.Now()
+        due, err := store.ClaimDue(now, scheduleBatchSize)
+        if err != nil {
+            e.logger.WithError(err).Warn("Failed to claim due scheduled responses")
+            return
+        }
+
+        for _, schedule := range due {
+            item := ScheduledItem{
+                ScheduledResponse: schedule,
+                Late:              now.Sub(schedule.RunAt) > e.scheduleLateThreshold,
+            }
+
+            handler := e.scheduleHandler
+            if handler == nil {
+                handler = e.followUp
+            }
+
+            runErr := handler(ctx, item)
+            if runErr != nil {
+                e.logger.WithError(runErr).WithField("schedule", schedule.ID).Error("Scheduled response failed")
+            }
+
+            // Record the outcome even if the loop is stopping
+            if err := e.scheduleStore.Complete(schedule.ID, runErr); err != nil {
+                e.logger.WithError(err).WithField("schedule", schedule.ID).Warn("Failed to record scheduled response outcome")
+            }
+        }
+
+        if len(due) < scheduleBatchSize {
+            return
+        }
+    }
+}
+
+// followUp is the default schedule handler. It generates a response following
+// the schedule's instruction from the recent conversation and stores it.
+func (e *Engine) followUp(ctx context.Context, item ScheduledItem) error {
+    assistant, err := e.GetAssistant(item.AssistantID)
+    if err != nil {
+        return err
+    }
+
+    history, err := e.GetConversationHistory(ctx, item.SessionID, HistoryOptions{
+        Limit:        followUpHistoryLimit,
+        PreloadActor: true,
+    })
+    if err != nil {
+        return err
+    }
+
+    messages := make([]llm.Message, 0, len(history)+1)
+    messages = append(messages, llm.Message{
+        Role:    llm.RoleSystem,
+        Content: fmt.Sprintf("You are %s. Without being asked, follow up on the conversation below: %s", assistant.Name, item.Instruction),
+    })
+    for _, fragment := range history {
+        message := llm.Message{
+            Role:    llm.RoleUser,
+            Content: fragment.Content,
+        }
+        if fragment.Actor != nil && fragment.Actor.Assistant {
+            message.Role = llm.RoleAssistant
+        }
+        messages = append(messages, message)
+    }
+
+    if err := e.acquireResponse(ctx, item.SessionID); err != nil {
+        return err
+    }
+
+    response, err := e.generateResponse(assistant, messages, item.SessionID, ResponseOptions{
+        Metadata: db.Metadata{
+            "scheduled_response_id": string(item.ID),
+            "late":                  item.Late,
+        },
+    })
+    if err != nil {
+        return err
+    }
+
+    if err := e.UpsertInteractionFragment(response); err != nil {
+        return fmt.Errorf("failed to store scheduled response: %w", err)
+    }
+    e.resolvePendingEmbedding(response.ID)
+
+    return nil
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/options"
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+
+    "github.com/pgvector/pgvector-go"
+)
+
+// SearchMode selects how SearchRelevantFragments retrieves fragments
+type SearchMode string
+
+const (
+    // SearchVector retrieves the fragments most similar to the query's embedding
+    SearchVector SearchMode = "vector"
+    // SearchHybrid also retrieves fragments matching the query's words, fusing
+    // both rankings, so exact names and IDs are found along with paraphrases
+    SearchHybrid SearchMode = "hybrid"
+)
+
+// SearchOptions controls SearchRelevantFragments
+type SearchOptions struct {
+    AllSessions   bool       // Search across all sessions instead of only the given one
+    MinSimilarity float64    // Drop results with a lower cosine similarity
+    Mode          SearchMode // SearchVector if empty
+}
+
+// SearchOption configures a relevance search
+type SearchOption = options.Option[SearchOptions]
+
+// WithAllSessions searches the interactions of all sessions.
+func WithAllSessions() SearchOption {
+    return func(o *SearchOptions) error {
+        o.AllSessions = true
+        return nil
+    }
+}
+
+// WithMinSimilarity drops results whose cosine similarity is below threshold,
+// overriding the engine's relevance threshold.
+func WithMinSimilarity(threshold float64) SearchOption {
+    return func(o *SearchOptions) error {
+        if threshold < -1 || threshold > 1 {
+            return fmt.Errorf("similarity threshold must be between -1 and 1")
+        }
+        o.MinSimilarity = threshold
+        return nil
+    }
+}
+
+// WithSearchMode selects the retrieval of the search.
+func WithSearchMode(mode SearchMode) SearchOption {
+    return func(o *SearchOptions) error {
+        switch mode {
+        case SearchVector, SearchHybrid:
+        default:
+            return fmt.Errorf("unknown search mode %q", mode)
+        }
+        o.Mode = mode
+        return nil
+    }
+}
+
+// SearchRelevantFragments embeds the query and returns the k interaction fragments
+// most similar to it, most similar first. Each fragment's similarity score is set
+// under the "similarity" Metadata key. In SearchHybrid mode, fragments are ordered
+// by their fused score, set under the "relevance" key, and MinSimilarity only
+// applies to fragments the text search didn't find.
+func (e *Engine) SearchRelevantFragments(ctx context.Context, sessionID id.ID, query string, k int, opts ...SearchOption) ([]db.Fragment, error) {
+    embedding, err := e.llmClient.EmbedText(query)
+    if err != nil {
+        return nil, fmt.Errorf("failed to embed search query: %w", err)
+    }
+
+    return e.searchRelevant(ctx, sessionID, query, pgvector.NewVector(embedding), k, opts...)
+}
+
+// searchRelevant returns the k interaction fragments most relevant to the query
+// text and its embedding.
+func (e *Engine) searchRelevant(ctx context.Context, sessionID id.ID, text string, embedding pgvector.Vector, k int, opts ...SearchOption) ([]db.Fragment, error) {
+    searchOpts := SearchOptions{
+        MinSimilarity: e.relevanceThreshold,
+    }
+    if err := options.ApplyOptions(&searchOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid search options: %w", err)
+    }
+
+    if searchOpts.AllSessions {
+        sessionID = ""
+    }
+
+    if searchOpts.Mode == SearchHybrid {
+        return e.searchHybrid(ctx, sessionID, text, embedding, k, searchOpts)
+    }
+
+    matches, err := e.interactionFragmentStore.WithContext(ctx).FindSimilar(embedding, sessionID, k)
+    if err != nil {
+        return nil, fmt.Errorf("failed to search relevant fragments: %w", err)
+    }
+
+    fragments := make([]db.Fragment, 0, len(matches))
+    for _, match := range matches {
+        if match.Similarity < searchOpts.MinSimilarity {
+            continue
+        }
+
+        fragment := match.Fragment
+        metadata := fragment.Metadata.Clone()
+        if metadata == nil {
+            metadata = make(db.Metadata, 1)
+        }
+        metadata["similarity"] = match.Similarity
+        fragment.Metadata = metadata
+
+        fragments = append(fragments, fragment)
+    }
+
+    return fragments, nil
+}
+
+// searchHybrid returns the k interaction fragments best ranked by the fusion of
+// a full text search of the text and a vector search of the embedding.
+func (e *Engine) searchHybrid(ctx context.Context, sessionID id.ID, text string, embedding pgvector.Vector, k int, searchOpts SearchOptions) ([]db.Fragment, error) {
+    matches, err := e.interactionFragmentStore.WithContext(ctx).SearchHybrid(stores.HybridQuery{
+        Text:         text,
+        Embedding:    embedding,
+        Limit:        k,
+        SearchFilter: stores.SearchFilter{SessionID: sessionID},
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to search relevant fragments: %w", err)
+    }
+
+    fragments := make([]db.Fragment, 0, len(matches))
+    for _, match := range matches {
+        // Text matches are relevant by their words, whatever their similarity
+        if !match.InText && match.VectorScore < searchOpts.MinSimilarity {
+            continue
+        }
+
+        fragment := match.Fragment
+        metadata := fragment.Metadata.Clone()
+        if metadata == nil {
+            metadata = make(db.Metadata, 2)
+        }
+        if match.InVector {
+            metadata["similarity"] = match.VectorScore
+        }
+        metadata["relevance"] = match.Score
+        fragment.Metadata = metadata
+
+        fragments = append(fragments, fragment)
+    }
+
+    return fragments, nil
+}
+
+// populateInteractions fills the state's recent and relevant interactions for
+// the current input, if enabled.
+func (e *Engine) populateInteractions(currentState *state.State) error {
+    input := currentState.Input
+
+    if e.recentInteractionsLimit > 0 {
+        opts := HistoryOptions{
+            Limit:        e.recentInteractionsLimit,
+            PreloadActor: true,
+        }
+        // Threaded inputs see the chain they reply to rather than the whole session
+        if e.threadedHistory && input.ParentID != nil {
+            opts.Thread = *input.ParentID
+        }
+        recent, err := e.GetConversationHistory(e.ctx, input.SessionID, opts)
+        if err != nil {
+            return err
+        }
+        currentState.SetRecentInteractions(recent)
+    }
+
+    if e.relevantInteractionsLimit > 0 {
+        embedding := input.Embedding
+        if len(embedding.Slice()) == 0 {
+            vector, err := e.llmClient.EmbedText(input.Content)
+            if err != nil {
+                return fmt.Errorf("failed to embed input: %w", err)
+            }
+            embedding = pgvector.NewVector(vector)
+        }
+
+        var opts []SearchOption
+        if e.relevantAcrossSessions {
+            opts = append(opts, WithAllSessions())
+        }
+
+        relevant, err := e.searchRelevant(e.ctx, input.SessionID, input.Content, embedding, e.relevantInteractionsLimit, opts...)
+        if err != nil {
+            return err
+        }
+        currentState.SetRelevantInteractions(relevant)
+    }
+
+    return nil
+}
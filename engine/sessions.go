@@ -0,0 +1,97 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/stores"
+)
+
+// ErrSessionClosed is returned by Process for inputs of a closed session
+var ErrSessionClosed = errors.New("session is closed")
+
+// ListSessionsOptions controls which sessions ListSessions returns
+type ListSessionsOptions struct {
+    Limit         int  // Maximum number of sessions, 0 for no limit
+    Offset        int  // Number of sessions to skip, for pagination
+    IncludeClosed bool // Include closed sessions
+}
+
+// ListSessions returns sessions ordered by last activity, most recent first.
+func (e *Engine) ListSessions(ctx context.Context, opts ListSessionsOptions) ([]db.Session, error) {
+    sessions, err := e.sessionStore.WithContext(ctx).List(stores.SessionQuery{
+        Limit:         opts.Limit,
+        Offset:        opts.Offset,
+        IncludeClosed: opts.IncludeClosed,
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to list sessions: %w", err)
+    }
+    return sessions, nil
+}
+
+// UpdateSessionMetadata merges the given keys into the metadata of a session.
+func (e *Engine) UpdateSessionMetadata(sessionID id.ID, metadata db.Metadata) error {
+    if err := e.sessionStore.MergeMetadata(sessionID, metadata); err != nil {
+        return fmt.Errorf("failed to update session metadata: %w", err)
+    }
+    return nil
+}
+
+// UpdateSessionTitle sets the title of a session.
+func (e *Engine) UpdateSessionTitle(sessionID id.ID, title string) error {
+    if err := e.sessionStore.UpdateTitle(sessionID, title); err != nil {
+        return fmt.Errorf("failed to update session title: %w", err)
+    }
+    return nil
+}
+
+// CloseSession marks a session as closed. Process returns ErrSessionClosed
+// for any further input of the session.
+func (e *Engine) CloseSession(sessionID id.ID) error {
+    if err := e.sessionStore.Close(sessionID); err != nil {
+        return fmt.Errorf("failed to close session: %w", err)
+    }
+    return nil
+}
+
+// generateSessionTitle generates a title for a session from its first user
+// input and stores it unless the session got a title in the meantime.
+func (e *Engine) generateSessionTitle(sessionID id.ID, content string) {
+    response, err := e.llmClient.GenerateCompletion(llm.CompletionRequest{
+        Messages: []llm.Message{
+            {
+                Role:    llm.RoleSystem,
+                Content: "Write a short title of at most six words for a conversation starting with the user's message. Reply with the title only.",
+            },
+            {
+                Role:    llm.RoleUser,
+                Content: content,
+            },
+        },
+        ModelType:   llm.ModelTypeFast,
+        Temperature: 0.3,
+    })
+    if err != nil {
+        e.logger.WithError(err).WithField("session", sessionID).Warn("Failed to generate session title")
+        return
+    }
+
+    title := strings.Trim(strings.TrimSpace(response.Content), `"'`)
+    if title == "" {
+        return
+    }
+    if runes := []rune(title); len(runes) > 255 {
+        title = string(runes[:255])
+    }
+
+    if _, err := e.sessionStore.SetTitleIfEmpty(sessionID, title); err != nil {
+        e.logger.WithError(err).WithField("session", sessionID).Warn("Failed to store session title")
+        return
+    }
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/stores"
+)
+
+// scopeToTenant scopes the engine's database and stores to its tenant. Stores
+// created from the database afterwards, including those of the pipeline
+// transactions, are scoped too.
+func (e *Engine) scopeToTenant() {
+    e.db = stores.WithTenant(e.db, e.tenant)
+    e.actorStore = e.actorStore.WithTenant(e.tenant)
+    e.sessionStore = e.sessionStore.WithTenant(e.tenant)
+    e.interactionFragmentStore = e.interactionFragmentStore.WithTenant(e.tenant)
+    if e.scheduleStore != nil {
+        e.scheduleStore = e.scheduleStore.WithTenant(e.tenant)
+    }
+    if e.toolCallStore != nil {
+        e.toolCallStore = e.toolCallStore.WithTenant(e.tenant)
+    }
+    if e.usageStore != nil {
+        e.usageStore = e.usageStore.WithTenant(e.tenant)
+    }
+}
+
+// scopeManagerToTenant scopes a manager's stores if the engine runs for a tenant.
+func (e *Engine) scopeManagerToTenant(m manager.Manager) {
+    if e.tenant == "" {
+        return
+    }
+    if scoper, ok := manager.Unwrap(m).(manager.TenantScoper); ok {
+        scoper.ScopeToTenant(e.tenant)
+        return
+    }
+    e.logger.WithField("manager", m.GetID()).Warn("Manager does not support tenants, its stores are not scoped")
+}
@@ -0,0 +1,124 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/managertest"
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+)
+
+func TestWithTenant(t *testing.T) {
+    env := managertest.NewTestEnvironment(t)
+    tenantID, otherID := id.New(), id.New()
+
+    // A fragment of another tenant, which nothing in the pipeline may reach
+    other := &db.Actor{ID: id.New(), Name: "Mallory"}
+    if err := env.ActorStore.WithTenant(otherID).Create(other); err != nil {
+        t.Fatal(err)
+    }
+    otherSession := &db.Session{ID: id.New(), Metadata: db.Metadata{}}
+    if err := env.SessionStore.WithTenant(otherID).Create(otherSession); err != nil {
+        t.Fatal(err)
+    }
+    foreign := &db.Fragment{ID: id.New(), ActorID: other.ID, SessionID: otherSession.ID, Content: "secret", Metadata: db.Metadata{}}
+    if err := env.InteractionFragmentStore.WithTenant(otherID).Create(foreign); err != nil {
+        t.Fatal(err)
+    }
+
+    var managerErr error
+    var spy *fakeManager
+    spy = newFakeManager(t, env, "spy", func(ctx context.Context, s *state.State) error {
+        _, managerErr = spy.InteractionFragmentStore.GetByID(foreign.ID)
+        return nil
+    })
+    e, _ := newTestEngineWithEnv(t, env, WithTenant(tenantID), WithIdentifier(id.New(), "Thor"), WithManagers(spy))
+
+    userID := id.New()
+    if err := e.UpsertActor(userID, "Alice", false); err != nil {
+        t.Fatal(err)
+    }
+    s := state.NewState()
+    s.Input = &db.Fragment{ID: id.New(), ActorID: userID, SessionID: id.New(), Content: "Hello", Metadata: db.Metadata{}}
+    if err := e.Process(s); err != nil {
+        t.Fatal(err)
+    }
+    if !errors.Is(managerErr, stores.ErrNotFound) {
+        t.Errorf("manager reached the other tenant's fragment: %v", managerErr)
+    }
+
+    tests := []struct {
+        name   string
+        lookup func() error
+        found  bool
+    }{
+        {name: "input of the tenant", lookup: func() error {
+            _, err := env.InteractionFragmentStore.WithTenant(tenantID).GetByID(s.Input.ID)
+            return err
+        }, found: true},
+        {name: "session of the tenant", lookup: func() error {
+            _, err := env.SessionStore.WithTenant(tenantID).GetByID(s.Input.SessionID)
+            return err
+        }, found: true},
+        {name: "input for the other tenant", lookup: func() error {
+            _, err := env.InteractionFragmentStore.WithTenant(otherID).GetByID(s.Input.ID)
+            return err
+        }},
+        {name: "session for the other tenant", lookup: func() error {
+            _, err := env.SessionStore.WithTenant(otherID).GetByID(s.Input.SessionID)
+            return err
+        }},
+        {name: "actor for the other tenant", lookup: func() error {
+            _, err := env.ActorStore.WithTenant(otherID).GetByID(userID)
+            return err
+        }},
+        {name: "other tenant's fragment for the engine", lookup: func() error {
+            _, err := e.interactionFragmentStore.GetByID(foreign.ID)
+            return err
+        }},
+        {name: "other tenant's actor for the engine", lookup: func() error {
+            _, err := e.actorStore.GetByID(other.ID)
+            return err
+        }},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := tt.lookup()
+            if tt.found && err != nil {
+                t.Fatalf("lookup failed: %v", err)
+            }
+            if !tt.found && !errors.Is(err, stores.ErrNotFound) {
+                t.Errorf("lookup returned %v, want not found", err)
+            }
+        })
+    }
+}
+
+func TestWithTenantOption(t *testing.T) {
+    tests := []struct {
+        name   string
+        tenant id.ID
+        valid  bool
+    }{
+        {name: "tenant", tenant: id.New(), valid: true},
+        {name: "empty", tenant: ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            e := &Engine{}
+            err := WithTenant(tt.tenant)(e)
+            if (err == nil) != tt.valid {
+                t.Errorf("WithTenant(%q) = %v, want valid %v", tt.tenant, err, tt.valid)
+            }
+            if tt.valid && e.tenant != tt.tenant {
+                t.Errorf("tenant = %q, want %q", e.tenant, tt.tenant)
+            }
+        })
+    }
+}
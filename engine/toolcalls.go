@@ -0,0 +1,51 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/stores"
+)
+
+// recordToolCalls records the tool calls made for a response of a session, if
+// a tool call store is set. callErr is the error the calls failed with, if
+// any. Records that fail to be written are logged, not returned, so they don't
+// fail the response.
+func (e *Engine) recordToolCalls(sessionID id.ID, fragmentID *id.ID, calls []llm.ToolCall, callErr error) {
+    if e.toolCallStore == nil || e.dryRun {
+        return
+    }
+    for _, call := range calls {
+        record := &db.ToolCall{
+            ID:         id.New(),
+            SessionID:  sessionID,
+            FragmentID: fragmentID,
+            Name:       call.Name,
+            Arguments:  stores.ToolCallArguments(call.Arguments),
+            Result:     call.Result,
+            Duration:   call.Duration,
+        }
+        if callErr != nil {
+            record.Error = callErr.Error()
+        }
+        if err := e.toolCallStore.Create(record); err != nil {
+            e.logger.WithError(err).WithField("tool", call.Name).Warn("Failed to record tool call")
+        }
+    }
+}
+
+// ListToolCalls returns the tools called in a session, oldest first, if the
+// engine records them, see WithToolCallStore.
+func (e *Engine) ListToolCalls(ctx context.Context, sessionID id.ID) ([]db.ToolCall, error) {
+    if e.toolCallStore == nil {
+        return nil, fmt.Errorf("tool calls are not recorded, see WithToolCallStore")
+    }
+    calls, err := e.toolCallStore.WithContext(ctx).ListBySession(sessionID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list tool calls: %w", err)
+    }
+    return calls, nil
+}
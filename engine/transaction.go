@@ -0,0 +1,42 @@
+package engine
+
+import (
+    "github.com/velumlabs/thor/state"
+    "github.com/velumlabs/thor/stores"
+
+    "gorm.io/gorm"
+)
+
+// inTransaction runs fn inside a database transaction when the transactional
+// pipeline is enabled, and directly with a nil transaction otherwise.
+// The transaction is exposed to managers through the state for the duration of fn,
+// with State.Stores binding stores to it.
+// If the transaction is rolled back, the compensations registered on the state
+// run in reverse order.
+func (e *Engine) inTransaction(phase Phase, currentState *state.State, fn func(tx *gorm.DB) error) error {
+    if !e.transactional {
+        return fn(nil)
+    }
+
+    err := stores.Transaction(e.db.WithContext(e.ctx), func(txStores *stores.Stores) error {
+        tx := txStores.DB()
+        currentState.SetTransaction(tx)
+        defer currentState.SetTransaction(nil)
+        return fn(tx)
+    })
+
+    compensations := currentState.TakeCompensations()
+    if err == nil {
+        return nil
+    }
+
+    e.logger.WithError(err).WithField("phase", phase).Warn("Rolled back pipeline transaction")
+
+    for i := len(compensations) - 1; i >= 0; i-- {
+        if compErr := compensations[i](); compErr != nil {
+            e.logger.WithError(compErr).WithField("phase", phase).Error("Compensation failed after rollback")
+        }
+    }
+
+    return err
+}
@@ -0,0 +1,315 @@
+package engine
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/options"
+
+    "github.com/pgvector/pgvector-go"
+    "gorm.io/gorm"
+)
+
+// transcriptBatchSize is the number of fragments read at a time during export
+const transcriptBatchSize = 200
+
+// TranscriptSession is the first line of an exported session transcript
+type TranscriptSession struct {
+    ID             id.ID       `json:"id"`
+    Title          string      `json:"title,omitempty"`
+    Metadata       db.Metadata `json:"metadata,omitempty"`
+    LastActivityAt time.Time   `json:"last_activity_at"`
+    ClosedAt       *time.Time  `json:"closed_at,omitempty"`
+    CreatedAt      time.Time   `json:"created_at"`
+}
+
+// TranscriptFragment is a single fragment line of an exported session transcript
+type TranscriptFragment struct {
+    ID             id.ID       `json:"id"`
+    ActorID        id.ID       `json:"actor_id"`
+    ActorName      string      `json:"actor_name"`
+    ActorAssistant bool        `json:"actor_assistant,omitempty"`
+    Content        string      `json:"content"`
+    Metadata       db.Metadata `json:"metadata,omitempty"`
+    Embedding      []float32   `json:"embedding,omitempty"`
+    ParentID       *id.ID      `json:"parent_id,omitempty"`
+    CreatedAt      time.Time   `json:"created_at"`
+    UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// TranscriptToolCall is a tool call line of an exported session transcript
+type TranscriptToolCall struct {
+    ID              id.ID         `json:"id"`
+    FragmentID      *id.ID        `json:"fragment_id,omitempty"`
+    Name            string        `json:"name"`
+    Arguments       db.Metadata   `json:"arguments,omitempty"`
+    Result          string        `json:"result,omitempty"`
+    ResultTruncated bool          `json:"result_truncated,omitempty"`
+    Error           string        `json:"error,omitempty"`
+    Duration        time.Duration `json:"duration_ns"`
+    CreatedAt       time.Time     `json:"created_at"`
+}
+
+// transcriptLine is a line of a JSONL session transcript, holding either
+// the session, one of its fragments or one of its tool calls
+type transcriptLine struct {
+    Session  *TranscriptSession  `json:"session,omitempty"`
+    Fragment *TranscriptFragment `json:"fragment,omitempty"`
+    ToolCall *TranscriptToolCall `json:"tool_call,omitempty"`
+}
+
+// ExportOptions controls ExportSession
+type ExportOptions struct {
+    IncludeEmbeddings bool // Include fragment embeddings in the transcript
+}
+
+// ExportOption configures a session export
+type ExportOption = options.Option[ExportOptions]
+
+// WithEmbeddings includes fragment embeddings in the exported transcript.
+func WithEmbeddings() ExportOption {
+    return func(o *ExportOptions) error {
+        o.IncludeEmbeddings = true
+        return nil
+    }
+}
+
+// ImportOptions controls ImportSession
+type ImportOptions struct {
+    NewIDs  bool // Generate fresh IDs for the session, actors and fragments instead of keeping the original ones
+    Reembed bool // Generate embeddings for the imported content instead of using the exported ones
+}
+
+// ExportSession writes the session and its fragments to w as JSONL: a session
+// line followed by one line per fragment, oldest first. Fragments are streamed
+// in batches, so large sessions are not loaded into memory at once. When the
+// engine records tool calls, see WithToolCallStore, a line per tool call of
+// the session follows, oldest first.
+func (e *Engine) ExportSession(ctx context.Context, sessionID id.ID, w io.Writer, opts ...ExportOption) error {
+    var exportOpts ExportOptions
+    if err := options.ApplyOptions(&exportOpts, opts...); err != nil {
+        return fmt.Errorf("invalid export options: %w", err)
+    }
+
+    session, err := e.sessionStore.WithContext(ctx).GetByID(sessionID)
+    if err != nil {
+        return fmt.Errorf("failed to get session: %w", err)
+    }
+
+    buffered := bufio.NewWriter(w)
+    encoder := json.NewEncoder(buffered)
+
+    if err := encoder.Encode(transcriptLine{Session: &TranscriptSession{
+        ID:             session.ID,
+        Title:          session.Title,
+        Metadata:       session.Metadata,
+        LastActivityAt: session.LastActivityAt,
+        ClosedAt:       session.ClosedAt,
+        CreatedAt:      session.CreatedAt,
+    }}); err != nil {
+        return fmt.Errorf("failed to write session: %w", err)
+    }
+
+    if err := e.interactionFragmentStore.WithContext(ctx).IterateSession(sessionID, transcriptBatchSize, func(fragments []db.Fragment) error {
+        for _, fragment := range fragments {
+            line := &TranscriptFragment{
+                ID:        fragment.ID,
+                ActorID:   fragment.ActorID,
+                Content:   fragment.Content,
+                Metadata:  fragment.Metadata,
+                ParentID:  fragment.ParentID,
+                CreatedAt: fragment.CreatedAt,
+                UpdatedAt: fragment.UpdatedAt,
+            }
+            if fragment.Actor != nil {
+                line.ActorName = fragment.Actor.Name
+                line.ActorAssistant = fragment.Actor.Assistant
+            }
+            if exportOpts.IncludeEmbeddings {
+                line.Embedding = fragment.Embedding.Slice()
+            }
+
+            if err := encoder.Encode(transcriptLine{Fragment: line}); err != nil {
+                return fmt.Errorf("failed to write fragment %s: %w", fragment.ID, err)
+            }
+        }
+        return nil
+    }); err != nil {
+        return fmt.Errorf("failed to export session: %w", err)
+    }
+
+    if e.toolCallStore != nil {
+        calls, err := e.toolCallStore.WithContext(ctx).ListBySession(sessionID)
+        if err != nil {
+            return fmt.Errorf("failed to export session: %w", err)
+        }
+        for _, call := range calls {
+            if err := encoder.Encode(transcriptLine{ToolCall: &TranscriptToolCall{
+                ID:              call.ID,
+                FragmentID:      call.FragmentID,
+                Name:            call.Name,
+                Arguments:       call.Arguments,
+                Result:          call.Result,
+                ResultTruncated: call.ResultTruncated,
+                Error:           call.Error,
+                Duration:        call.Duration,
+                CreatedAt:       call.CreatedAt,
+            }}); err != nil {
+                return fmt.Errorf("failed to write tool call %s: %w", call.ID, err)
+            }
+        }
+    }
+
+    if err := buffered.Flush(); err != nil {
+        return fmt.Errorf("failed to write transcript: %w", err)
+    }
+
+    return nil
+}
+
+// ImportSession reads a transcript written by ExportSession and recreates its
+// session, actors and fragments in a single transaction, and its tool calls if
+// the engine records them. Returns the ID of the
+// imported session, which differs from the exported one when opts.NewIDs is set.
+// Fragments exported without embeddings are always embedded on import.
+// The transcript is decoded line by line, so large sessions are not loaded into memory at once.
+func (e *Engine) ImportSession(ctx context.Context, r io.Reader, opts ImportOptions) (id.ID, error) {
+    decoder := json.NewDecoder(r)
+
+    var header transcriptLine
+    if err := decoder.Decode(&header); err != nil {
+        return "", fmt.Errorf("failed to read session: %w", err)
+    }
+    if header.Session == nil {
+        return "", fmt.Errorf("transcript must start with a session line")
+    }
+
+    ids := make(map[id.ID]id.ID)
+    mapID := func(original id.ID) id.ID {
+        if !opts.NewIDs {
+            return original
+        }
+        mapped, ok := ids[original]
+        if !ok {
+            mapped = id.New()
+            ids[original] = mapped
+        }
+        return mapped
+    }
+
+    sessionID := mapID(header.Session.ID)
+
+    if err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        sessionStore := e.sessionStore.WithContext(ctx).WithTx(tx)
+        actorStore := e.actorStore.WithTx(tx)
+        fragmentStore := e.interactionFragmentStore.WithContext(ctx).WithTx(tx)
+
+        if err := sessionStore.Upsert(&db.Session{
+            ID:             sessionID,
+            Title:          header.Session.Title,
+            Metadata:       header.Session.Metadata,
+            LastActivityAt: header.Session.LastActivityAt,
+            ClosedAt:       header.Session.ClosedAt,
+            CreatedAt:      header.Session.CreatedAt,
+        }); err != nil {
+            return err
+        }
+
+        importedActors := make(map[id.ID]bool)
+
+        for {
+            var line transcriptLine
+            if err := decoder.Decode(&line); err == io.EOF {
+                return nil
+            } else if err != nil {
+                return fmt.Errorf("failed to read fragment: %w", err)
+            }
+            if line.ToolCall != nil {
+                if err := e.importToolCall(ctx, tx, line.ToolCall, sessionID, mapID); err != nil {
+                    return err
+                }
+                continue
+            }
+            if line.Fragment == nil {
+                return fmt.Errorf("unexpected transcript line without fragment")
+            }
+            fragment := line.Fragment
+
+            actorID := mapID(fragment.ActorID)
+            if !importedActors[actorID] {
+                if err := actorStore.Upsert(&db.Actor{
+                    ID:        actorID,
+                    Name:      fragment.ActorName,
+                    Assistant: fragment.ActorAssistant,
+                }); err != nil {
+                    return err
+                }
+                importedActors[actorID] = true
+            }
+
+            embedding := fragment.Embedding
+            if opts.Reembed || len(embedding) == 0 {
+                vector, err := e.llmClient.EmbedText(fragment.Content)
+                if err != nil {
+                    return fmt.Errorf("failed to embed fragment %s: %w", fragment.ID, err)
+                }
+                embedding = vector
+            }
+
+            var parentID *id.ID
+            if fragment.ParentID != nil {
+                mapped := mapID(*fragment.ParentID)
+                parentID = &mapped
+            }
+
+            if err := fragmentStore.Upsert(&db.Fragment{
+                ID:        mapID(fragment.ID),
+                ActorID:   actorID,
+                SessionID: sessionID,
+                Content:   fragment.Content,
+                Metadata:  fragment.Metadata,
+                Embedding: pgvector.NewVector(embedding),
+                ParentID:  parentID,
+                CreatedAt: fragment.CreatedAt,
+                UpdatedAt: fragment.UpdatedAt,
+            }); err != nil {
+                return err
+            }
+        }
+    }); err != nil {
+        return "", fmt.Errorf("failed to import session: %w", err)
+    }
+
+    return sessionID, nil
+}
+
+// importToolCall recreates a tool call of an imported session, if the engine
+// records tool calls
+func (e *Engine) importToolCall(ctx context.Context, tx *gorm.DB, call *TranscriptToolCall, sessionID id.ID, mapID func(id.ID) id.ID) error {
+    if e.toolCallStore == nil {
+        return nil
+    }
+    var fragmentID *id.ID
+    if call.FragmentID != nil {
+        mapped := mapID(*call.FragmentID)
+        fragmentID = &mapped
+    }
+    return e.toolCallStore.WithContext(ctx).WithTx(tx).Upsert(&db.ToolCall{
+        ID:              mapID(call.ID),
+        SessionID:       sessionID,
+        FragmentID:      fragmentID,
+        Name:            call.Name,
+        Arguments:       call.Arguments,
+        Result:          call.Result,
+        ResultTruncated: call.ResultTruncated,
+        Error:           call.Error,
+        Duration:        call.Duration,
+        CreatedAt:       call.CreatedAt,
+    })
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/velumlabs/thor/cache"
+    "github.com/velumlabs/thor/events"
+    "github.com/velumlabs/thor/id"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/logger"
+    "github.com/velumlabs/thor/manager"
+    "github.com/velumlabs/thor/stores"
+
+    "gorm.io/gorm"
+)
+
+// Engine coordinates managers, stores and the LLM client to process
+// conversation inputs and generate responses.
+type Engine struct {
+    ctx context.Context
+
+    db *gorm.DB
+
+    logger *logger.Logger
+
+    // Identity of the assistant this engine speaks for by default
+    ID   id.ID
+    Name string
+
+    // Registered assistant identities, including the default one
+    assistantsMu sync.RWMutex
+    assistants   map[id.ID]AssistantProfile
+
+    // Registered managers and their optional post-processing order.
+    // Both slices are replaced rather than modified so readers can use a snapshot.
+    managersMu        sync.RWMutex
+    managers          []manager.Manager
+    managerOrder      []manager.ManagerID
+    backgroundRunning bool
+    background        map[manager.ManagerID]*backgroundProcess
+
+    // Managers switched off with SetManagerEnabled, and whether their
+    // background processes are paused while they are off
+    disabledManagers        map[manager.ManagerID]bool
+    pauseDisabledBackground bool
+
+    // Dependency-based ordering; an explicit manager order takes precedence
+    autoManagerOrder     bool
+    explicitManagerOrder bool
+    stagedProcess        bool
+    managerStages        [][]manager.ManagerID
+
+    // State data contracts declared by the managers, validated whenever the
+    // set of managers changes
+    stateContracts *stateContracts
+
+    // Fail managers writing state data keys other managers hold
+    strictStateKeys bool
+
+    // Stores
+    actorStore               *stores.ActorStore
+    sessionStore             *stores.SessionStore
+    interactionFragmentStore *stores.FragmentStore
+
+    llmClient *llm.LLMClient
+
+    // How responses are embedded, and asynchronous embeddings in progress
+    embeddingMode EmbeddingMode
+    embeddingHook EmbeddingHook
+    pending       *pendingEmbeddings
+
+    // Optional cache of the stores' lookups by ID, see WithStoreCache
+    storeCache       *cache.Cache
+    storeNotFoundTTL time.Duration
+
+    // Event bus delivering engine and manager events to subscribers, and the
+    // delivery policy of the bus the engine creates when none is given
+    eventBus      *events.Bus
+    eventDelivery events.DeliveryPolicy
+
+    // Manager execution limits, zero disables them
+    managerTimeout       time.Duration
+    managerTimeouts      map[manager.ManagerID]time.Duration
+    slowManagerThreshold time.Duration
+    failurePolicy        FailurePolicy
+
+    // Maximum number of managers processing concurrently, zero means unlimited
+    maxConcurrentManagers int
+    inFlightManagers      int64
+
+    // Skip inputs that were already stored
+    idempotentProcessing bool
+    duplicatePolicy      DuplicatePolicy
+    skippedDuplicates    int64
+
+    // Interactions loaded into the state before managers run, zero disables them
+    recentInteractionsLimit   int
+    threadedHistory           bool
+    relevantInteractionsLimit int
+    relevantAcrossSessions    bool
+    relevanceThreshold        float64
+
+    // Generate a title for untitled sessions from their first user input
+    autoSessionTitles bool
+
+    // Per-session response rate limit, nil if disabled
+    responseLimiter *responseLimiter
+    rateLimitMode   RateLimitMode
+
+    // Optional store recording the tools the model calls, see WithToolCallStore
+    toolCallStore *stores.ToolCallStore
+
+    // Scheduled responses and the loop firing them
+    scheduleStore         *stores.ScheduleStore
+    scheduleHandler       ScheduleHandler
+    scheduleLateThreshold time.Duration
+    schedulerMu           sync.Mutex
+    scheduler             *scheduler
+    // Months of upcoming partitions the scheduler keeps created, see
+    // WithPartitionMaintenance
+    partitionsAhead int
+
+    // Inputs waiting for the queue workers
+    queue     *inputQueue
+    queueSize int
+
+    // Run the pipeline without persisting anything
+    dryRun bool
+
+    // Tenant the engine's stores are scoped to, empty for single-tenant databases
+    tenant id.ID
+
+    // Retries of pipeline store operations after transient errors
+    storeRetry RetryPolicy
+
+    // Run each Process/PostProcess call in a single database transaction
+    transactional bool
+
+    // Middlewares wrapping every registered manager, outermost first
+    managerMiddleware []manager.Middleware
+
+    // Input preprocessors, run in registration order at the start of Process
+    preprocessors []InputPreprocessor
+
+    // Pipeline hooks, run in registration order
+    beforeHooks  map[Phase][]BeforeHook
+    afterHooks   map[Phase][]AfterHook
+    managerHooks []ManagerHook
+
+    // Execution metrics and an optional sink receiving them as they are recorded
+    metrics     *metricsRecorder
+    metricsSink MetricsSink
+
+    // Token budgets, zero means unlimited, and the store tracking the usage
+    usageStore         *stores.UsageStore
+    sessionTokenBudget int64
+    dailyTokenBudget   int64
+    budgetPauseMessage string
+
+    // Log the prompts of Reply at debug level, without the sections
+    // referencing the redacted template data keys
+    promptDebug       bool
+    promptDebugRedact []string
+}
+
+// Phase identifies a stage of the engine pipeline
+type Phase string
+
+const (
+    PhaseProcess     Phase = "process"
+    PhaseContext     Phase = "context"
+    PhasePostProcess Phase = "post_process"
+)
+
+// FailurePolicy determines how the engine reacts to manager errors
+type FailurePolicy int
+
+const (
+    // FailFast aborts the phase on the first manager error
+    FailFast FailurePolicy = iota
+    // ContinueAndCollect runs all managers, persists the fragment regardless,
+    // and returns the joined manager errors
+    ContinueAndCollect
+)
+
+// DuplicatePolicy determines what Process returns for an input that was already processed
+type DuplicatePolicy int
+
+const (
+    // DuplicateError returns ErrAlreadyProcessed
+    DuplicateError DuplicatePolicy = iota
+    // DuplicateSkip silently returns nil
+    DuplicateSkip
+)
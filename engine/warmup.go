@@ -0,0 +1,161 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/velumlabs/thor/db"
+    "github.com/velumlabs/thor/llm"
+    "github.com/velumlabs/thor/options"
+)
+
+const (
+    // defaultWarmupSessions is the number of recently active sessions Warmup caches
+    defaultWarmupSessions = 50
+)
+
+// WarmupOptions controls Warmup
+type WarmupOptions struct {
+    Sessions int  // Number of recently active sessions to preload into the cache
+    PingLLM  bool // Issue a minimal completion to establish the LLM connection
+}
+
+// WarmupOption configures an engine warmup
+type WarmupOption = options.Option[WarmupOptions]
+
+// WithWarmupSessions sets how many recently active sessions Warmup preloads.
+func WithWarmupSessions(n int) WarmupOption {
+    return func(o *WarmupOptions) error {
+        if n < 0 {
+            return fmt.Errorf("warmup sessions must not be negative")
+        }
+        o.Sessions = n
+        return nil
+    }
+}
+
+// WithLLMPing makes Warmup issue one minimal completion, so the first response
+// does not pay for connection setup.
+func WithLLMPing() WarmupOption {
+    return func(o *WarmupOptions) error {
+        o.PingLLM = true
+        return nil
+    }
+}
+
+// WarmupStep is a single check or preload performed by Warmup
+type WarmupStep struct {
+    Name     string
+    Detail   string
+    Duration time.Duration
+    Err      error
+}
+
+// WarmupReport describes what Warmup checked and loaded, and how long it took
+type WarmupReport struct {
+    Steps    []WarmupStep
+    Duration time.Duration
+}
+
+// Warmup prepares the engine for traffic. It verifies the database schema,
+// preloads the assistant actors and recently active sessions into the cache and
+// optionally establishes the LLM connection. It stops at the first failing step
+// and returns the report so far together with the error, so a deployment can be
+// held back before it serves users.
+func (e *Engine) Warmup(ctx context.Context, opts ...WarmupOption) (*WarmupReport, error) {
+    warmupOpts := WarmupOptions{
+        Sessions: defaultWarmupSessions,
+    }
+    if err := options.ApplyOptions(&warmupOpts, opts...); err != nil {
+        return nil, fmt.Errorf("invalid warmup options: %w", err)
+    }
+
+    report := &WarmupReport{}
+    start := time.Now()
+    defer func() {
+        report.Duration = time.Since(start)
+    }()
+
+    run := func(name string, fn func() (string, error)) error {
+        stepStart := time.Now()
+        detail, err := fn()
+        report.Steps = append(report.Steps, WarmupStep{
+            Name:     name,
+            Detail:   detail,
+            Duration: time.Since(stepStart),
+            Err:      err,
+        })
+        if err != nil {
+            return fmt.Errorf("warmup %s: %w", name, err)
+        }
+        return nil
+    }
+
+    if err := run("schema", func() (string, error) {
+        if err := db.VerifySchema(e.db.WithContext(ctx)); err != nil {
+            return "", err
+        }
+        return "tables and indexes present", nil
+    }); err != nil {
+        return report, err
+    }
+
+    if err := run("assistants", func() (string, error) {
+        e.assistantsMu.RLock()
+        assistants := make([]AssistantProfile, 0, len(e.assistants))
+        for _, profile := range e.assistants {
+            assistants = append(assistants, profile)
+        }
+        e.assistantsMu.RUnlock()
+
+        for _, profile := range assistants {
+            if _, err := e.getActor(profile.ID); err != nil {
+                return "", fmt.Errorf("failed to load assistant %s: %w", profile.ID, err)
+            }
+        }
+        return fmt.Sprintf("%d assistants loaded", len(assistants)), nil
+    }); err != nil {
+        return report, err
+    }
+
+    if warmupOpts.Sessions > 0 {
+        if err := run("sessions", func() (string, error) {
+            if e.storeCache == nil {
+                return "skipped, no cache configured", nil
+            }
+            sessions, err := e.ListSessions(ctx, ListSessionsOptions{Limit: warmupOpts.Sessions})
+            if err != nil {
+                return "", err
+            }
+            // Lookups by ID fill the session store's cache
+            for _, session := range sessions {
+                if _, err := e.getSession(session.ID); err != nil {
+                    return "", fmt.Errorf("failed to load session %s: %w", session.ID, err)
+                }
+            }
+            return fmt.Sprintf("%d sessions cached", len(sessions)), nil
+        }); err != nil {
+            return report, err
+        }
+    }
+
+    if warmupOpts.PingLLM {
+        if err := run("llm", func() (string, error) {
+            if _, err := e.llmClient.GenerateCompletion(llm.CompletionRequest{
+                Messages: []llm.Message{{
+                    Role:    llm.RoleUser,
+                    Content: "Reply with OK.",
+                }},
+                ModelType: llm.ModelTypeFast,
+            }); err != nil {
+                return "", err
+            }
+            return "completion succeeded", nil
+        }); err != nil {
+            return report, err
+        }
+    }
+
+    return report, nil
+}
@@ -0,0 +1,243 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/velumlabs/thor/logger"
+	"github.com/velumlabs/thor/options"
+)
+
+var (
+	// ErrBusClosed is returned when publishing to a closed bus
+	ErrBusClosed = errors.New("event bus is closed")
+
+	// ErrBusFull is returned when the event queue is full
+	ErrBusFull = errors.New("event bus queue is full")
+)
+
+// NewBus creates a new Bus and starts its workers
+func NewBus(opts ...options.Option[Bus]) (*Bus, error) {
+	b := &Bus{
+		workers:     4,
+		bufferSize:  256,
+		subscribers: make(map[EventType]map[uint64]Handler),
+	}
+	if err := options.ApplyOptions(b, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create event bus: %w", err)
+	}
+
+	b.queue = make(chan Event, b.bufferSize)
+	b.closing = make(chan struct{})
+	for i := 0; i < b.workers; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+
+	return b, nil
+}
+
+// ValidateRequiredFields ensures all required fields are set on the Bus
+func (b *Bus) ValidateRequiredFields() error {
+	if b.logger == nil {
+		return fmt.Errorf("logger is required")
+	}
+	return nil
+}
+
+// WithLogger sets the logger used to report subscriber errors
+func WithLogger(logger *logger.Logger) options.Option[Bus] {
+	return func(b *Bus) error {
+		b.logger = logger
+		return nil
+	}
+}
+
+// WithWorkers sets the number of workers delivering events
+func WithWorkers(workers int) options.Option[Bus] {
+	return func(b *Bus) error {
+		if workers <= 0 {
+			return fmt.Errorf("workers must be positive")
+		}
+		b.workers = workers
+		return nil
+	}
+}
+
+// WithBufferSize sets how many events can be queued before Publish fails
+func WithBufferSize(size int) options.Option[Bus] {
+	return func(b *Bus) error {
+		if size < 0 {
+			return fmt.Errorf("buffer size must not be negative")
+		}
+		b.bufferSize = size
+		return nil
+	}
+}
+
+// WithDeliveryPolicy sets what Publish does when the queue is full
+func WithDeliveryPolicy(policy DeliveryPolicy) options.Option[Bus] {
+	return func(b *Bus) error {
+		switch policy {
+		case DropNewest, DropOldest, Block:
+			b.policy = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown delivery policy %d", policy)
+		}
+	}
+}
+
+// Subscribe registers a handler for events of the given type, or of every type
+// when eventType is Wildcard. Returns a function that removes the subscription.
+func (b *Bus) Subscribe(eventType EventType, handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+
+	if b.subscribers[eventType] == nil {
+		b.subscribers[eventType] = make(map[uint64]Handler)
+	}
+	b.subscribers[eventType][id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[eventType], id)
+	}
+}
+
+// Publish queues an event for delivery without waiting for subscribers.
+// When the queue is full the delivery policy applies: DropNewest returns
+// ErrBusFull, DropOldest discards the oldest queued event and Block waits for
+// room. Returns ErrBusClosed after Close.
+func (b *Bus) Publish(event Event) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	select {
+	case b.queue <- event:
+		return nil
+	default:
+	}
+
+	switch b.policy {
+	case Block:
+		select {
+		case b.queue <- event:
+			return nil
+		case <-b.closing:
+			return ErrBusClosed
+		}
+	case DropOldest:
+		for {
+			select {
+			case b.queue <- event:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-b.queue:
+				atomic.AddInt64(&b.dropped, 1)
+				b.logger.WithField("event", dropped.Type).Warn("Event queue full, dropping oldest event")
+			default:
+			}
+		}
+	default:
+		atomic.AddInt64(&b.dropped, 1)
+		return ErrBusFull
+	}
+}
+
+// Dropped returns the number of events discarded because the queue was full
+func (b *Bus) Dropped() int64 {
+	return atomic.LoadInt64(&b.dropped)
+}
+
+// Close stops accepting events and waits until queued events have been
+// delivered or ctx is done.
+func (b *Bus) Close(ctx context.Context) error {
+	// Wake publishers blocked on a full queue so they release the lock
+	b.closeOnce.Do(func() { close(b.closing) })
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("event bus did not drain: %w", ctx.Err())
+	}
+}
+
+// worker delivers queued events until the queue is closed
+func (b *Bus) worker() {
+	defer b.wg.Done()
+	for event := range b.queue {
+		b.deliver(event)
+	}
+}
+
+// deliver calls every handler subscribed to the event's type or to the wildcard
+func (b *Bus) deliver(event Event) {
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.subscribers[event.Type])+len(b.subscribers[Wildcard]))
+	for _, handler := range b.subscribers[event.Type] {
+		handlers = append(handlers, handler)
+	}
+	if event.Type != Wildcard {
+		for _, handler := range b.subscribers[Wildcard] {
+			handlers = append(handlers, handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		b.call(handler, event)
+	}
+}
+
+// call runs a single handler, logging its error or panic
+func (b *Bus) call(handler Handler, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.WithFields(map[string]interface{}{
+				"event":  event.Type,
+				"source": event.Source,
+			}).Errorf("Event subscriber panicked: %v", r)
+		}
+	}()
+
+	if err := handler(event); err != nil {
+		b.logger.WithError(err).WithFields(map[string]interface{}{
+			"event":  event.Type,
+			"source": event.Source,
+		}).Error("Event subscriber failed")
+	}
+}
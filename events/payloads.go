@@ -0,0 +1,74 @@
+package events
+
+import (
+	"github.com/velumlabs/thor/id"
+)
+
+const (
+	// EventInputProcessed is published once an input has been processed and stored
+	EventInputProcessed EventType = "input.processed"
+
+	// EventResponseGenerated is published once a response has been generated
+	EventResponseGenerated EventType = "response.generated"
+
+	// EventInsightCreated is published by managers when they derive an insight
+	EventInsightCreated EventType = "insight.created"
+)
+
+// TypedPayload is a payload struct with its own event type
+type TypedPayload interface {
+	EventType() EventType
+}
+
+// InputProcessed is the payload of EventInputProcessed
+type InputProcessed struct {
+	FragmentID     id.ID
+	SessionID      id.ID
+	ActorID        id.ID
+	FailedManagers []string
+}
+
+func (InputProcessed) EventType() EventType { return EventInputProcessed }
+
+// ResponseGenerated is the payload of EventResponseGenerated
+type ResponseGenerated struct {
+	FragmentID  id.ID
+	SessionID   id.ID
+	AssistantID id.ID
+	Model       string
+	TotalTokens int
+}
+
+func (ResponseGenerated) EventType() EventType { return EventResponseGenerated }
+
+// InsightCreated is the payload of EventInsightCreated
+type InsightCreated struct {
+	FragmentID id.ID
+	SessionID  id.ID
+	ActorID    id.ID
+	Content    string
+}
+
+func (InsightCreated) EventType() EventType { return EventInsightCreated }
+
+// New returns an event carrying a typed payload in Data
+func New(payload TypedPayload) Event {
+	return Event{
+		Type: payload.EventType(),
+		Data: payload,
+	}
+}
+
+// On subscribes a handler to the events carrying payloads of type T.
+// Events of T's type published without a T payload are ignored.
+// Returns a function that removes the subscription.
+func On[T TypedPayload](b *Bus, handler func(payload T, event Event) error) func() {
+	var zero T
+	return b.Subscribe(zero.EventType(), func(event Event) error {
+		payload, ok := event.Data.(T)
+		if !ok {
+			return nil
+		}
+		return handler(payload, event)
+	})
+}
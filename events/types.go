@@ -0,0 +1,67 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/logger"
+)
+
+// EventType identifies the kind of an event
+type EventType string
+
+const (
+	// Wildcard subscribes a handler to events of every type
+	Wildcard EventType = "*"
+
+	// EventManager is used for manager events published without a specific type
+	EventManager EventType = "manager"
+)
+
+// Event is a typed message published on the bus
+type Event struct {
+	Type      EventType
+	Source    string // ID of the publisher, e.g. a manager ID
+	Payload   map[string]interface{}
+	Data      interface{} // Typed payload, such as InsightCreated, for events built with New
+	Timestamp time.Time
+}
+
+// Handler processes a delivered event. Returned errors are logged.
+type Handler func(event Event) error
+
+// DeliveryPolicy determines what Publish does when the event queue is full
+type DeliveryPolicy int
+
+const (
+	// DropNewest rejects the published event with ErrBusFull
+	DropNewest DeliveryPolicy = iota
+	// DropOldest discards the oldest queued event to make room for the new one
+	DropOldest
+	// Block waits until the queue has room or the bus is closed
+	Block
+)
+
+// Bus delivers published events to subscribers asynchronously.
+// Events are queued on a buffered channel and dispatched by a pool of workers,
+// so publishers never wait on slow subscribers.
+type Bus struct {
+	logger *logger.Logger
+
+	workers    int
+	bufferSize int
+
+	policy DeliveryPolicy
+
+	queue   chan Event
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+	dropped   int64
+
+	mu          sync.RWMutex
+	subscribers map[EventType]map[uint64]Handler
+	nextID      uint64
+	closed      bool
+}
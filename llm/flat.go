@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+// RoleRenderer renders chat messages into a single prompt for models that take
+// no roles, e.g. local models served through a plain generate endpoint
+type RoleRenderer interface {
+	// RenderMessage renders a message with its role marker
+	RenderMessage(message Message) string
+	// Cue returns the text after the messages prompting the assistant's turn
+	Cue() string
+}
+
+// ChatMLRenderer renders messages in ChatML, naming participants after their
+// role:
+//
+//	<|im_start|>user (alice)
+//	Hello<|im_end|>
+//	<|im_start|>assistant
+type ChatMLRenderer struct{}
+
+func (ChatMLRenderer) RenderMessage(message Message) string {
+	return "<|im_start|>" + roleLabel(string(message.Role), message.Name) + "\n" + message.Content + "<|im_end|>\n"
+}
+
+func (ChatMLRenderer) Cue() string {
+	return "<|im_start|>" + string(RoleAssistant) + "\n"
+}
+
+// HeadingRenderer renders messages under markdown headings:
+//
+//	### User (alice):
+//	Hello
+//
+//	### Assistant:
+type HeadingRenderer struct{}
+
+func (HeadingRenderer) RenderMessage(message Message) string {
+	return "### " + roleLabel(capitalize(string(message.Role)), message.Name) + ":\n" + message.Content + "\n\n"
+}
+
+func (HeadingRenderer) Cue() string {
+	return "### " + capitalize(string(RoleAssistant)) + ":\n"
+}
+
+// DefaultRoleRenderer renders flat prompts when no renderer is given
+var DefaultRoleRenderer RoleRenderer = ChatMLRenderer{}
+
+// FlatPromptOptions configures how messages are flattened into one prompt
+type FlatPromptOptions struct {
+	Renderer RoleRenderer // DefaultRoleRenderer if nil
+	// Append the definitions of the tools as a JSON block in a system message,
+	// for models calling tools from their prompt
+	IncludeTools bool
+}
+
+// FlatProvider is implemented by providers of models without chat roles. The
+// client sets the Prompt of their completion requests to the request's
+// messages flattened with the provider's options.
+type FlatProvider interface {
+	FlatPrompt() FlatPromptOptions
+}
+
+// toolDefinition is the JSON form of a tool in flat prompts
+type toolDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+// FlattenMessages renders messages into a single prompt ending with the
+// renderer's cue for the assistant, with the tools appended if requested
+func FlattenMessages(messages []Message, tools []toolkit.Tool, opts FlatPromptOptions) (string, error) {
+	renderer := opts.Renderer
+	if renderer == nil {
+		renderer = DefaultRoleRenderer
+	}
+
+	var b strings.Builder
+	for _, message := range messages {
+		b.WriteString(renderer.RenderMessage(message))
+	}
+
+	if opts.IncludeTools && len(tools) > 0 {
+		definitions := make([]toolDefinition, len(tools))
+		for i, tool := range tools {
+			definitions[i] = toolDefinition{
+				Name:        tool.GetName(),
+				Description: tool.GetDescription(),
+				Parameters:  tool.GetSchema().Parameters,
+			}
+		}
+		encoded, err := json.MarshalIndent(definitions, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode tools: %w", err)
+		}
+		b.WriteString(renderer.RenderMessage(Message{
+			Role:    RoleSystem,
+			Content: "Available tools:\n```json\n" + string(encoded) + "\n```",
+		}))
+	}
+
+	b.WriteString(renderer.Cue())
+	return b.String(), nil
+}
+
+// roleLabel returns a role followed by the participant name, if any
+func roleLabel(role, name string) string {
+	if name == "" {
+		return role
+	}
+	return role + " (" + name + ")"
+}
+
+// capitalize upper-cases the first letter of a role
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
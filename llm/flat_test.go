@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+var update = flag.Bool("update", false, "update the golden files of flat prompts")
+
+// flatProvider is a MockProvider of a model taking flat prompts
+type flatProvider struct {
+	*MockProvider
+	opts FlatPromptOptions
+}
+
+func (p *flatProvider) FlatPrompt() FlatPromptOptions {
+	return p.opts
+}
+
+// checkGolden compares got with the golden file testdata/flat/name.golden,
+// writing it instead with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "flat", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("flat prompt =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFlattenMessages(t *testing.T) {
+	messages := []Message{
+		{Role: RoleSystem, Content: "You are Thor."},
+		{Role: RoleUser, Content: "Hello", Name: "alice"},
+		{Role: RoleAssistant, Content: "Hi Alice!"},
+		{Role: RoleUser, Content: "What is the answer?", Name: "alice"},
+	}
+	tools := []toolkit.Tool{&lookupTool{}}
+
+	tests := []struct {
+		name     string
+		messages []Message
+		opts     FlatPromptOptions
+	}{
+		{name: "default", messages: messages},
+		{name: "chatml", messages: messages, opts: FlatPromptOptions{Renderer: ChatMLRenderer{}}},
+		{name: "heading", messages: messages, opts: FlatPromptOptions{Renderer: HeadingRenderer{}}},
+		{name: "chatml_tools", messages: messages, opts: FlatPromptOptions{IncludeTools: true}},
+		{name: "heading_tools", messages: messages, opts: FlatPromptOptions{Renderer: HeadingRenderer{}, IncludeTools: true}},
+		{name: "no_messages"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FlattenMessages(tt.messages, tools, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.name, got)
+		})
+	}
+}
+
+func TestFlattenMessagesWithoutTools(t *testing.T) {
+	messages := []Message{{Role: RoleUser, Content: "Hello"}}
+	want, err := FlattenMessages(messages, nil, FlatPromptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		tools []toolkit.Tool
+		opts  FlatPromptOptions
+	}{
+		{name: "not included", tools: []toolkit.Tool{&lookupTool{}}},
+		{name: "none to include", opts: FlatPromptOptions{IncludeTools: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FlattenMessages(messages, tt.tools, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("flat prompt = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestClientFlattensRequests(t *testing.T) {
+	messages := []Message{{Role: RoleUser, Content: "Hello", Name: "alice"}}
+	flat, err := FlattenMessages(messages, nil, FlatPromptOptions{Renderer: HeadingRenderer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		flat   bool
+		prompt string
+		want   string
+	}{
+		{name: "flat provider", flat: true, want: flat},
+		{name: "prompt given", flat: true, prompt: "custom", want: "custom"},
+		{name: "chat provider"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMockProvider()
+			var provider Provider = mock
+			if tt.flat {
+				provider = &flatProvider{MockProvider: mock, opts: FlatPromptOptions{Renderer: HeadingRenderer{}}}
+			}
+			client := NewLLMClientFromProvider(provider, Config{})
+
+			if _, ok := client.FlatPrompt(); ok != tt.flat {
+				t.Errorf("FlatPrompt reported %v, want %v", ok, tt.flat)
+			}
+			if _, err := client.GenerateCompletion(CompletionRequest{Messages: messages, Prompt: tt.prompt}); err != nil {
+				t.Fatal(err)
+			}
+			requests := mock.CompletionRequests()
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if requests[0].Prompt != tt.want {
+				t.Errorf("prompt = %q, want %q", requests[0].Prompt, tt.want)
+			}
+		})
+	}
+}
@@ -1,35 +1,118 @@
-module github.com/velumlabs/thor
-
-go 1.23.3
-
-require (
-	github.com/go-resty/resty/v2 v2.16.2
-	github.com/google/uuid v1.6.0
-	github.com/joho/godotenv v1.5.1
-	github.com/k0kubun/pp v3.0.1+incompatible
-	github.com/pgvector/pgvector-go v0.2.2
-	github.com/sashabaranov/go-openai v1.35.7
-	github.com/sirupsen/logrus v1.9.3
-	golang.org/x/exp v0.0.0-20241108190413-2d47ceb2692f
-	golang.org/x/sync v0.9.0
-	gorm.io/driver/postgres v1.5.10
-	gorm.io/gorm v1.25.12
-)
+package llm
+
+import (
+	"context"
+	"fmt"
 
-require (
-	github.com/jackc/pgpassfile v1.0.0 // indirect
-	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
-	github.com/jackc/pgx/v5 v5.6.0 // indirect
-	github.com/jackc/puddle/v2 v2.2.1 // indirect
-	github.com/jinzhu/inflection v1.0.0 // indirect
-	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/k0kubun/colorstring v0.0.0-20150214042306-9440f1994b88 // indirect
-	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.16 // indirect
-	github.com/mitchellh/mapstructure v1.5.0 // indirect
-	github.com/soralabs/toolkit/go v0.0.0-20250104120828-ea094df8becc // indirect
-	golang.org/x/crypto v0.26.0 // indirect
-	golang.org/x/net v0.27.0 // indirect
-	golang.org/x/sys v0.23.0 // indirect
-	golang.org/x/text v0.17.0 // indirect
+	"github.com/velumlabs/thor/logger"
 )
+
+// LLMClient wraps a Provider and binds it to the context and logger
+// supplied in the client configuration.
+type LLMClient struct {
+	provider Provider
+	logger   *logger.Logger
+	ctx      context.Context
+}
+
+// NewLLMClient creates a new LLMClient for the configured provider type.
+func NewLLMClient(config Config) (*LLMClient, error) {
+	var provider Provider
+
+	switch config.ProviderType {
+	case ProviderOpenAI:
+		provider = NewOpenAIProvider(config)
+	default:
+		return nil, fmt.Errorf("unsupported provider type: %s", config.ProviderType)
+	}
+
+	return NewLLMClientFromProvider(provider, config), nil
+}
+
+// NewLLMClientFromProvider creates a new LLMClient for an existing provider,
+// such as a MockProvider. The provider type of the configuration is ignored.
+func NewLLMClientFromProvider(provider Provider, config Config) *LLMClient {
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &LLMClient{
+		provider: provider,
+		logger:   config.Logger,
+		ctx:      ctx,
+	}
+}
+
+// WithContext returns a copy of the client whose requests use ctx, e.g. so
+// they are cancelled with the caller.
+func (c *LLMClient) WithContext(ctx context.Context) *LLMClient {
+	if ctx == nil {
+		return c
+	}
+	return &LLMClient{
+		provider: c.provider,
+		logger:   c.logger,
+		ctx:      ctx,
+	}
+}
+
+// GenerateCompletion generates a completion for the given request.
+func (c *LLMClient) GenerateCompletion(req CompletionRequest) (Message, error) {
+	req, err := c.flatten(req)
+	if err != nil {
+		return Message{}, err
+	}
+	return c.provider.GenerateCompletion(c.ctx, req)
+}
+
+// GenerateCompletionStream generates a completion for the given request and
+// delivers it incrementally to the callback. Tool calls requested by the model
+// are executed between round trips, so the callback observes a single
+// continuous stream. Returns an error if the provider does not support streaming.
+func (c *LLMClient) GenerateCompletionStream(req CompletionRequest, callback StreamCallback) (Message, error) {
+	streamer, ok := c.provider.(StreamingProvider)
+	if !ok {
+		return Message{}, fmt.Errorf("provider does not support streaming")
+	}
+	req, err := c.flatten(req)
+	if err != nil {
+		return Message{}, err
+	}
+	return streamer.GenerateCompletionStream(c.ctx, req, callback)
+}
+
+// GenerateStructuredOutput generates a structured output for the given request
+// and unmarshals it into result.
+func (c *LLMClient) GenerateStructuredOutput(req StructuredOutputRequest, result interface{}) error {
+	return c.provider.GenerateStructuredOutput(c.ctx, req, result)
+}
+
+// FlatPrompt returns the flat prompt options of the provider, if its models
+// take a single prompt rather than messages
+func (c *LLMClient) FlatPrompt() (FlatPromptOptions, bool) {
+	flat, ok := c.provider.(FlatProvider)
+	if !ok {
+		return FlatPromptOptions{}, false
+	}
+	return flat.FlatPrompt(), true
+}
+
+// flatten sets the prompt of a completion request for FlatProviders
+func (c *LLMClient) flatten(req CompletionRequest) (CompletionRequest, error) {
+	opts, ok := c.FlatPrompt()
+	if !ok || req.Prompt != "" {
+		return req, nil
+	}
+	prompt, err := FlattenMessages(req.Messages, req.Tools, opts)
+	if err != nil {
+		return req, fmt.Errorf("failed to flatten messages: %w", err)
+	}
+	req.Prompt = prompt
+	return req, nil
+}
+
+// EmbedText generates an embedding vector for the given text.
+func (c *LLMClient) EmbedText(text string) ([]float32, error) {
+	return c.provider.EmbedText(c.ctx, text)
+}
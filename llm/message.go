@@ -1,5 +1,11 @@
 package llm
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type Role string
 
 const (
@@ -12,13 +18,63 @@ const (
 type ToolCall struct {
 	Name      string
 	Arguments string
+
+	// Result and Duration of the call, once the tool was executed
+	Result   string
+	Duration time.Duration
+}
+
+// ToolError is the error of a completion whose tool call failed, with the
+// call's duration set
+type ToolError struct {
+	Call ToolCall
+	Err  error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool execution error: %v", e.Err)
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
 }
 
+// MaxToolRounds is the number of tool calls a completion executes at most,
+// each followed by another completion, before it fails with
+// ErrTooManyToolRounds
+const MaxToolRounds = 8
+
+// ErrTooManyToolRounds is the error of a completion whose model kept calling
+// tools past MaxToolRounds
+var ErrTooManyToolRounds = errors.New("too many tool rounds")
+
 type Message struct {
 	Role     Role
 	Content  string
 	Name     string
 	ToolCall *ToolCall
+	Usage    Usage
+
+	// Model is the name of the model that generated the message
+	Model string
+	// ToolCalls are the tool calls executed while generating the message, in order
+	ToolCalls []ToolCall
+}
+
+// Usage reports the number of tokens consumed to produce a message
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
 }
 
 type ModelType string
@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// MockEmbeddingDimensions is the size of the embeddings returned by MockProvider
+// by default, matching the embedding column of the fragment tables
+const MockEmbeddingDimensions = 1536
+
+// MockProvider is a Provider for tests that never calls a model. Each method
+// can be replaced by setting the matching function field; by default
+// completions return MockResponse, structured outputs leave the result
+// untouched and embeddings are derived deterministically from the text.
+// All requests are recorded.
+type MockProvider struct {
+	CompletionFunc       func(ctx context.Context, req CompletionRequest) (Message, error)
+	StructuredOutputFunc func(ctx context.Context, req StructuredOutputRequest, result interface{}) error
+	EmbedFunc            func(ctx context.Context, text string) ([]float32, error)
+
+	mu                sync.Mutex
+	completions       []CompletionRequest
+	structuredOutputs []StructuredOutputRequest
+	embeddedTexts     []string
+}
+
+// MockResponse is the content of the completions MockProvider returns by default
+const MockResponse = "mock response"
+
+// NewMockProvider creates a MockProvider with the default behavior
+func NewMockProvider() *MockProvider {
+	return &MockProvider{}
+}
+
+// GenerateCompletion records the request and returns the mocked completion
+func (p *MockProvider) GenerateCompletion(ctx context.Context, req CompletionRequest) (Message, error) {
+	p.mu.Lock()
+	p.completions = append(p.completions, req)
+	p.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
+	if p.CompletionFunc != nil {
+		return p.CompletionFunc(ctx, req)
+	}
+	return Message{
+		Role:    RoleAssistant,
+		Content: MockResponse,
+		Model:   "mock",
+	}, nil
+}
+
+// GenerateStructuredOutput records the request and fills result with the mocked output
+func (p *MockProvider) GenerateStructuredOutput(ctx context.Context, req StructuredOutputRequest, result interface{}) error {
+	p.mu.Lock()
+	p.structuredOutputs = append(p.structuredOutputs, req)
+	p.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if p.StructuredOutputFunc != nil {
+		return p.StructuredOutputFunc(ctx, req, result)
+	}
+	return nil
+}
+
+// EmbedText records the text and returns its mocked embedding
+func (p *MockProvider) EmbedText(ctx context.Context, text string) ([]float32, error) {
+	p.mu.Lock()
+	p.embeddedTexts = append(p.embeddedTexts, text)
+	p.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.EmbedFunc != nil {
+		return p.EmbedFunc(ctx, text)
+	}
+	return MockEmbedding(text), nil
+}
+
+// CompletionRequests returns the completion requests received so far
+func (p *MockProvider) CompletionRequests() []CompletionRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]CompletionRequest(nil), p.completions...)
+}
+
+// StructuredOutputRequests returns the structured output requests received so far
+func (p *MockProvider) StructuredOutputRequests() []StructuredOutputRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]StructuredOutputRequest(nil), p.structuredOutputs...)
+}
+
+// EmbeddedTexts returns the texts embedded so far
+func (p *MockProvider) EmbeddedTexts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.embeddedTexts...)
+}
+
+// MockEmbedding returns a unit vector of MockEmbeddingDimensions derived from the
+// text, so equal texts get equal embeddings
+func MockEmbedding(text string) []float32 {
+	hash := fnv.New64a()
+	hash.Write([]byte(text))
+	seed := hash.Sum64()
+
+	embedding := make([]float32, MockEmbeddingDimensions)
+	var norm float64
+	for i := range embedding {
+		// xorshift keeps the sequence deterministic without a shared random source
+		seed ^= seed << 13
+		seed ^= seed >> 7
+		seed ^= seed << 17
+		value := float64(seed%2000)/1000 - 1
+		embedding[i] = float32(value)
+		norm += value * value
+	}
+
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return embedding
+	}
+	for i := range embedding {
+		embedding[i] = float32(float64(embedding[i]) / norm)
+	}
+	return embedding
+}
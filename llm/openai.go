@@ -3,10 +3,17 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/velumlabs/thor/logger"
 
+	toolkit "github.com/velumlabs/kit/go"
+
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 )
@@ -50,21 +57,16 @@ func NewOpenAIProvider(config Config) *OpenAIProvider {
 // GenerateCompletion sends a conversation to the OpenAI ChatCompletion API
 // and returns the model's text completion.
 func (p *OpenAIProvider) GenerateCompletion(ctx context.Context, req CompletionRequest) (Message, error) {
-	functions := make([]openai.FunctionDefinition, len(req.Tools))
-	for i, tool := range req.Tools {
-		schema := tool.GetSchema()
-		functions[i] = openai.FunctionDefinition{
-			Name:        tool.GetName(),
-			Description: tool.GetDescription(),
-			Parameters:  schema.Parameters,
-		}
-	}
+	return p.generateCompletion(ctx, req, 0)
+}
 
+// generateCompletion is GenerateCompletion after rounds tool calls
+func (p *OpenAIProvider) generateCompletion(ctx context.Context, req CompletionRequest, rounds int) (Message, error) {
 	resp, err := p.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model:       p.getModel(req.ModelType),
 		Messages:    p.convertMessages(req.Messages),
-		Temperature: req.Temperature,
-		Functions:   functions,
+		Temperature: openAITemperature(req.Temperature, req.TemperatureSet),
+		Functions:   p.convertTools(req.Tools),
 	})
 	if err != nil {
 		return Message{}, fmt.Errorf("OpenAI API error: %w", err)
@@ -74,54 +76,183 @@ func (p *OpenAIProvider) GenerateCompletion(ctx context.Context, req CompletionR
 		return Message{}, fmt.Errorf("no completion returned")
 	}
 
+	usage := p.convertUsage(resp.Usage)
+
 	// Handle function calls if present
-	if resp.Choices[0].Message.FunctionCall != nil {
-		call := resp.Choices[0].Message.FunctionCall
-		for _, tool := range req.Tools {
-			if tool.GetName() == call.Name {
-				// Execute the tool
-				result, err := tool.Execute(ctx, json.RawMessage(call.Arguments))
-				if err != nil {
-					return Message{}, fmt.Errorf("tool execution error: %w", err)
-				}
-
-				// Create a new message array with the tool result
-				toolResultMessages := append(req.Messages,
-					Message{
-						Role:    RoleAssistant,
-						Content: "",
-						ToolCall: &ToolCall{
-							Name:      call.Name,
-							Arguments: string(call.Arguments),
-						},
-					},
-					Message{
-						Role:    RoleTool,
-						Content: string(result),
-						Name:    tool.GetName(),
-					},
-				)
-
-				// Make a follow-up completion request with the tool result
-				followUpReq := CompletionRequest{
-					Messages:    toolResultMessages,
-					ModelType:   req.ModelType,
-					Temperature: req.Temperature,
-					Tools:       req.Tools,
-				}
-
-				return p.GenerateCompletion(ctx, followUpReq)
-			}
+	if call := resp.Choices[0].Message.FunctionCall; call != nil {
+		toolCall := &ToolCall{
+			Name:      call.Name,
+			Arguments: call.Arguments,
 		}
-		return Message{}, fmt.Errorf("function %s not found", call.Name)
+
+		if req.DeferToolExecution {
+			return Message{
+				Role:     RoleAssistant,
+				Content:  resp.Choices[0].Message.Content,
+				ToolCall: toolCall,
+				Usage:    usage,
+				Model:    resp.Model,
+			}, nil
+		}
+
+		if rounds >= MaxToolRounds {
+			return Message{}, fmt.Errorf("%w: %d tool calls executed", ErrTooManyToolRounds, rounds)
+		}
+		tool, err := p.findTool(req.Tools, toolCall.Name)
+		if err != nil {
+			return Message{}, err
+		}
+
+		// Execute the tool
+		start := time.Now()
+		result, err := tool.Execute(ctx, json.RawMessage(toolCall.Arguments))
+		toolCall.Duration = time.Since(start)
+		if err != nil {
+			return Message{}, &ToolError{Call: *toolCall, Err: err}
+		}
+		toolCall.Result = string(result)
+
+		// Make a follow-up completion request with the tool result
+		content := resp.Choices[0].Message.Content
+		followUp, err := p.generateCompletion(ctx, p.followUpRequest(req, content, toolCall, string(result)), rounds+1)
+		if err != nil {
+			return Message{}, err
+		}
+		followUp.Content = content + followUp.Content
+		followUp.Usage = usage.Add(followUp.Usage)
+		followUp.ToolCalls = append([]ToolCall{*toolCall}, followUp.ToolCalls...)
+		return followUp, nil
 	}
 
 	return Message{
 		Role:    RoleAssistant,
 		Content: resp.Choices[0].Message.Content,
+		Usage:   usage,
+		Model:   resp.Model,
 	}, nil
 }
 
+// GenerateCompletionStream streams a completion from the OpenAI ChatCompletion API
+// to the callback. Function call arguments arrive as fragments and are accumulated
+// until the call is complete, at which point the tool is executed and the follow-up
+// completion continues streaming to the same callback. The content streamed
+// before the call starts the content of the returned message.
+func (p *OpenAIProvider) GenerateCompletionStream(ctx context.Context, req CompletionRequest, callback StreamCallback) (Message, error) {
+	return p.generateCompletionStream(ctx, req, callback, 0)
+}
+
+// generateCompletionStream is GenerateCompletionStream after rounds tool calls
+func (p *OpenAIProvider) generateCompletionStream(ctx context.Context, req CompletionRequest, callback StreamCallback, rounds int) (Message, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:       p.getModel(req.ModelType),
+		Messages:    p.convertMessages(req.Messages),
+		Temperature: openAITemperature(req.Temperature, req.TemperatureSet),
+		Functions:   p.convertTools(req.Tools),
+		StreamOptions: &openai.StreamOptions{
+			IncludeUsage: true,
+		},
+	})
+	if err != nil {
+		return Message{}, fmt.Errorf("OpenAI API error: %w", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	var toolCall *ToolCall
+	var usage Usage
+	var model string
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Message{}, fmt.Errorf("OpenAI stream error: %w", err)
+		}
+
+		if resp.Model != "" {
+			model = resp.Model
+		}
+
+		// Usage is only reported on the final chunk
+		if resp.Usage != nil {
+			usage = p.convertUsage(*resp.Usage)
+		}
+
+		if len(resp.Choices) == 0 {
+			continue
+		}
+
+		delta := resp.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if err := callback(StreamEvent{Type: StreamEventContent, Content: delta.Content}); err != nil {
+				return Message{}, err
+			}
+		}
+
+		// Accumulate function call fragments until the stream completes
+		if delta.FunctionCall != nil {
+			if toolCall == nil {
+				toolCall = &ToolCall{}
+			}
+			toolCall.Name += delta.FunctionCall.Name
+			toolCall.Arguments += delta.FunctionCall.Arguments
+		}
+	}
+
+	if toolCall == nil || req.DeferToolExecution {
+		return Message{
+			Role:     RoleAssistant,
+			Content:  content.String(),
+			ToolCall: toolCall,
+			Usage:    usage,
+			Model:    model,
+		}, nil
+	}
+
+	if rounds >= MaxToolRounds {
+		return Message{}, fmt.Errorf("%w: %d tool calls executed", ErrTooManyToolRounds, rounds)
+	}
+	tool, err := p.findTool(req.Tools, toolCall.Name)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if err := callback(StreamEvent{Type: StreamEventToolStarted, ToolCall: toolCall}); err != nil {
+		return Message{}, err
+	}
+
+	start := time.Now()
+	result, execErr := tool.Execute(ctx, json.RawMessage(toolCall.Arguments))
+	toolCall.Duration = time.Since(start)
+	toolCall.Result = string(result)
+
+	if err := callback(StreamEvent{
+		Type:     StreamEventToolFinished,
+		ToolCall: toolCall,
+		Result:   string(result),
+		Err:      execErr,
+	}); err != nil {
+		return Message{}, err
+	}
+
+	if execErr != nil {
+		return Message{}, &ToolError{Call: *toolCall, Err: execErr}
+	}
+
+	// Continue streaming the follow-up completion to the same callback
+	followUp, err := p.generateCompletionStream(ctx, p.followUpRequest(req, content.String(), toolCall, string(result)), callback, rounds+1)
+	if err != nil {
+		return Message{}, err
+	}
+	followUp.Content = content.String() + followUp.Content
+	followUp.Usage = usage.Add(followUp.Usage)
+	followUp.ToolCalls = append([]ToolCall{*toolCall}, followUp.ToolCalls...)
+	return followUp, nil
+}
+
 // GenerateStructuredOutput prompts the OpenAI API to return JSON data conforming
 func (p *OpenAIProvider) GenerateStructuredOutput(ctx context.Context, req StructuredOutputRequest, result interface{}) error {
 	schema, err := jsonschema.GenerateSchemaForType(result)
@@ -140,7 +271,7 @@ func (p *OpenAIProvider) GenerateStructuredOutput(ctx context.Context, req Struc
 				Strict: req.StrictSchema,
 			},
 		},
-		Temperature: req.Temperature,
+		Temperature: openAITemperature(req.Temperature, req.TemperatureSet),
 	})
 	if err != nil {
 		return fmt.Errorf("OpenAI API error: %w", err)
@@ -170,6 +301,77 @@ func (p *OpenAIProvider) EmbedText(ctx context.Context, text string) ([]float32,
 	return resp.Data[0].Embedding, nil
 }
 
+// convertTools transforms toolkit tools into OpenAI function definitions.
+func (p *OpenAIProvider) convertTools(tools []toolkit.Tool) []openai.FunctionDefinition {
+	functions := make([]openai.FunctionDefinition, len(tools))
+	for i, tool := range tools {
+		schema := tool.GetSchema()
+		functions[i] = openai.FunctionDefinition{
+			Name:        tool.GetName(),
+			Description: tool.GetDescription(),
+			Parameters:  schema.Parameters,
+		}
+	}
+	return functions
+}
+
+// findTool returns the tool with the given name from the request's tools.
+func (p *OpenAIProvider) findTool(tools []toolkit.Tool, name string) (toolkit.Tool, error) {
+	for _, tool := range tools {
+		if tool.GetName() == name {
+			return tool, nil
+		}
+	}
+	return nil, fmt.Errorf("function %s not found", name)
+}
+
+// followUpRequest builds the completion request that continues a conversation
+// after a tool call has been executed, content being the text the model
+// generated along with the call.
+func (p *OpenAIProvider) followUpRequest(req CompletionRequest, content string, toolCall *ToolCall, result string) CompletionRequest {
+	messages := make([]Message, 0, len(req.Messages)+2)
+	messages = append(messages, req.Messages...)
+	messages = append(messages,
+		Message{
+			Role:     RoleAssistant,
+			Content:  content,
+			ToolCall: toolCall,
+		},
+		Message{
+			Role:    RoleTool,
+			Content: result,
+			Name:    toolCall.Name,
+		},
+	)
+
+	return CompletionRequest{
+		Messages:       messages,
+		ModelType:      req.ModelType,
+		Temperature:    req.Temperature,
+		TemperatureSet: req.TemperatureSet,
+		Tools:          req.Tools,
+	}
+}
+
+// openAITemperature returns the temperature of a request to the OpenAI API,
+// which omits a zero temperature and uses the model's default instead. An
+// explicit zero is sent as the smallest positive temperature.
+func openAITemperature(temperature float32, set bool) float32 {
+	if temperature == 0 && set {
+		return math.SmallestNonzeroFloat32
+	}
+	return temperature
+}
+
+// convertUsage transforms OpenAI token usage to the internal format.
+func (p *OpenAIProvider) convertUsage(usage openai.Usage) Usage {
+	return Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
 // getModel returns the OpenAI model identifier for the given model type.
 // Falls back to default model if type is not found.
 func (p *OpenAIProvider) getModel(modelType ModelType) string {
@@ -0,0 +1,373 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	toolkit "github.com/velumlabs/kit/go"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIServer is a fake OpenAI API recording the bodies of the requests
+type openAIServer struct {
+	mu       sync.Mutex
+	requests []map[string]interface{}
+}
+
+// body returns the decoded body of the ith request
+func (s *openAIServer) body(t *testing.T, i int) map[string]interface{} {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i >= len(s.requests) {
+		t.Fatalf("got %d requests, want at least %d", len(s.requests), i+1)
+	}
+	return s.requests[i]
+}
+
+// newTestOpenAIProvider returns a provider calling a fake OpenAI API, which
+// answers the nth request with respond(w, n)
+func newTestOpenAIProvider(t *testing.T, respond func(w http.ResponseWriter, n int)) (*OpenAIProvider, *openAIServer) {
+	t.Helper()
+	server := &openAIServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request: %v", err)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		server.mu.Lock()
+		n := len(server.requests)
+		server.requests = append(server.requests, body)
+		server.mu.Unlock()
+		respond(w, n)
+	}))
+	t.Cleanup(srv.Close)
+
+	config := openai.DefaultConfig("test")
+	config.BaseURL = srv.URL + "/v1"
+	provider := NewOpenAIProvider(Config{})
+	provider.client = openai.NewClientWithConfig(config)
+	return provider, server
+}
+
+// writeCompletion answers with a completion of content
+func writeCompletion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+		Model: "gpt-test",
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+		}},
+	})
+}
+
+func TestOpenAITemperature(t *testing.T) {
+	tests := []struct {
+		name        string
+		temperature float32
+		set         bool
+		// want is the temperature sent, nil if omitted
+		want *float64
+	}{
+		{name: "unset", want: nil},
+		{name: "explicit zero", set: true, want: ptr(1e-45)},
+		{name: "non-zero", temperature: 0.5, want: ptr(0.5)},
+		{name: "non-zero set", temperature: 0.5, set: true, want: ptr(0.5)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, server := newTestOpenAIProvider(t, func(w http.ResponseWriter, n int) {
+				writeCompletion(w, "ok")
+			})
+
+			_, err := provider.GenerateCompletion(context.Background(), CompletionRequest{
+				Messages:       []Message{{Role: RoleUser, Content: "hello"}},
+				Temperature:    tt.temperature,
+				TemperatureSet: tt.set,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := server.body(t, 0)["temperature"].(float64)
+			switch {
+			case tt.want == nil && ok:
+				t.Errorf("temperature = %v, want omitted", got)
+			case tt.want != nil && !ok:
+				t.Errorf("temperature omitted, want %v", *tt.want)
+			case tt.want != nil && got != *tt.want:
+				t.Errorf("temperature = %v, want %v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// lookupTool is a tool recording the arguments it is called with
+type lookupTool struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (t *lookupTool) GetName() string        { return "lookup" }
+func (t *lookupTool) GetDescription() string { return "Looks up a value" }
+func (t *lookupTool) GetSchema() toolkit.Schema {
+	return toolkit.Schema{Parameters: json.RawMessage(`{"type":"object","properties":{"q":{"type":"string"}}}`)}
+}
+
+func (t *lookupTool) Execute(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, string(params))
+	return json.RawMessage(`{"value":42}`), nil
+}
+
+// writeStream answers with a stream of chunks
+func writeStream(w http.ResponseWriter, chunks ...openai.ChatCompletionStreamResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func contentChunk(content string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Model:   "gpt-test",
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: content}}},
+	}
+}
+
+func callChunk(name, arguments string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Model: "gpt-test",
+		Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{
+			FunctionCall: &openai.FunctionCall{Name: name, Arguments: arguments},
+		}}},
+	}
+}
+
+func usageChunk(tokens int) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Model: "gpt-test",
+		Usage: &openai.Usage{PromptTokens: tokens, CompletionTokens: tokens, TotalTokens: 2 * tokens},
+	}
+}
+
+// toolCallStream is a stream of content followed by a call of the lookup tool
+// whose name and arguments arrive in fragments
+var toolCallStream = []openai.ChatCompletionStreamResponse{
+	contentChunk("Let me "),
+	contentChunk("check. "),
+	callChunk("look", `{"q":`),
+	callChunk("up", `"answer"`),
+	callChunk("", `}`),
+	usageChunk(10),
+}
+
+func TestGenerateCompletionStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		deferTools bool
+		respond    func(w http.ResponseWriter, n int)
+		// want is the content of the returned message
+		want string
+		// wantCalls are the arguments the tool is executed with
+		wantCalls []string
+		// wantEvents are the types of the stream events
+		wantEvents []StreamEventType
+		// wantUsage is the total number of tokens
+		wantUsage int
+		wantErr   error
+	}{
+		{
+			name: "content",
+			respond: func(w http.ResponseWriter, n int) {
+				writeStream(w, contentChunk("Hello"), contentChunk(" there"), usageChunk(5))
+			},
+			want:       "Hello there",
+			wantEvents: []StreamEventType{StreamEventContent, StreamEventContent},
+			wantUsage:  10,
+		},
+		{
+			name: "fragmented tool call with follow-up",
+			respond: func(w http.ResponseWriter, n int) {
+				if n == 0 {
+					writeStream(w, toolCallStream...)
+					return
+				}
+				writeStream(w, contentChunk("It is "), contentChunk("42."), usageChunk(5))
+			},
+			want:      "Let me check. It is 42.",
+			wantCalls: []string{`{"q":"answer"}`},
+			wantEvents: []StreamEventType{
+				StreamEventContent, StreamEventContent,
+				StreamEventToolStarted, StreamEventToolFinished,
+				StreamEventContent, StreamEventContent,
+			},
+			wantUsage: 30,
+		},
+		{
+			name:       "deferred tool call",
+			deferTools: true,
+			respond: func(w http.ResponseWriter, n int) {
+				writeStream(w, toolCallStream...)
+			},
+			want:       "Let me check. ",
+			wantEvents: []StreamEventType{StreamEventContent, StreamEventContent},
+			wantUsage:  20,
+		},
+		{
+			name: "too many tool rounds",
+			respond: func(w http.ResponseWriter, n int) {
+				writeStream(w, toolCallStream...)
+			},
+			wantErr: ErrTooManyToolRounds,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, server := newTestOpenAIProvider(t, tt.respond)
+			tool := &lookupTool{}
+
+			var events []StreamEventType
+			message, err := provider.GenerateCompletionStream(context.Background(), CompletionRequest{
+				Messages:           []Message{{Role: RoleUser, Content: "What is the answer?"}},
+				Tools:              []toolkit.Tool{tool},
+				DeferToolExecution: tt.deferTools,
+			}, func(event StreamEvent) error {
+				events = append(events, event.Type)
+				return nil
+			})
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if got := len(tool.calls); got != MaxToolRounds {
+					t.Errorf("tool executed %d times, want %d", got, MaxToolRounds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if message.Content != tt.want {
+				t.Errorf("content = %q, want %q", message.Content, tt.want)
+			}
+			if !reflect.DeepEqual(tool.calls, tt.wantCalls) {
+				t.Errorf("tool calls = %q, want %q", tool.calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+			if message.Usage.TotalTokens != tt.wantUsage {
+				t.Errorf("total tokens = %d, want %d", message.Usage.TotalTokens, tt.wantUsage)
+			}
+			if tt.deferTools {
+				if message.ToolCall == nil || message.ToolCall.Name != "lookup" || message.ToolCall.Arguments != `{"q":"answer"}` {
+					t.Errorf("deferred tool call = %+v, want lookup with the whole arguments", message.ToolCall)
+				}
+				return
+			}
+			if len(tt.wantCalls) == 0 {
+				return
+			}
+
+			if len(message.ToolCalls) != 1 || message.ToolCalls[0].Result != `{"value":42}` {
+				t.Errorf("executed tool calls = %+v, want the lookup with its result", message.ToolCalls)
+			}
+			// The follow-up carries the content streamed before the call
+			messages := server.body(t, 1)["messages"].([]interface{})
+			if len(messages) != 3 {
+				t.Fatalf("follow-up messages = %v, want 3", messages)
+			}
+			assistant := messages[1].(map[string]interface{})
+			if assistant["content"] != "Let me check. " {
+				t.Errorf("follow-up assistant content = %q, want the content before the call", assistant["content"])
+			}
+			call := assistant["function_call"].(map[string]interface{})
+			if call["name"] != "lookup" || call["arguments"] != `{"q":"answer"}` {
+				t.Errorf("follow-up function call = %v, want the accumulated call", call)
+			}
+			if result := messages[2].(map[string]interface{}); result["content"] != `{"value":42}` {
+				t.Errorf("follow-up tool result = %v, want the tool output", result["content"])
+			}
+		})
+	}
+}
+
+func TestGenerateCompletionToolRounds(t *testing.T) {
+	tests := []struct {
+		name string
+		// calls is the number of completions calling the tool
+		calls   int
+		want    string
+		wantErr error
+	}{
+		{name: "one round", calls: 1, want: "Checking. Done."},
+		{name: "last allowed round", calls: MaxToolRounds, want: strings.Repeat("Checking. ", MaxToolRounds) + "Done."},
+		{name: "too many rounds", calls: MaxToolRounds + 1, wantErr: ErrTooManyToolRounds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _ := newTestOpenAIProvider(t, func(w http.ResponseWriter, n int) {
+				if n >= tt.calls {
+					writeCompletion(w, "Done.")
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+					Model: "gpt-test",
+					Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{
+						Role:         openai.ChatMessageRoleAssistant,
+						Content:      "Checking. ",
+						FunctionCall: &openai.FunctionCall{Name: "lookup", Arguments: `{"q":"answer"}`},
+					}}},
+				})
+			})
+
+			message, err := provider.GenerateCompletion(context.Background(), CompletionRequest{
+				Messages: []Message{{Role: RoleUser, Content: "What is the answer?"}},
+				Tools:    []toolkit.Tool{&lookupTool{}},
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Content != tt.want {
+				t.Errorf("content = %q, want %q", message.Content, tt.want)
+			}
+			if len(message.ToolCalls) != tt.calls {
+				t.Errorf("tool calls = %d, want %d", len(message.ToolCalls), tt.calls)
+			}
+		})
+	}
+}
@@ -12,17 +12,35 @@ type Provider interface {
 	EmbedText(ctx context.Context, text string) ([]float32, error)
 }
 
+// StreamingProvider is implemented by providers that can deliver completions
+// incrementally.
+type StreamingProvider interface {
+	GenerateCompletionStream(ctx context.Context, req CompletionRequest, callback StreamCallback) (Message, error)
+}
+
 type CompletionRequest struct {
 	Messages    []Message
 	Tools       []toolkit.Tool
 	ModelType   ModelType
 	Temperature float32
+	// TemperatureSet makes a zero Temperature explicit. A zero Temperature
+	// otherwise counts as unset, leaving the temperature to the defaults of
+	// the manager and the model.
+	TemperatureSet bool
+	// DeferToolExecution returns tool calls to the caller in Message.ToolCall
+	// instead of executing them and requesting a follow-up completion.
+	DeferToolExecution bool
+	// Prompt holds Messages flattened into a single prompt for FlatProviders.
+	// The client sets it when empty.
+	Prompt string
 }
 
 type StructuredOutputRequest struct {
-	Messages     []Message
-	ModelType    ModelType
-	Temperature  float32
-	SchemaName   string
-	StrictSchema bool
+	Messages    []Message
+	ModelType   ModelType
+	Temperature float32
+	// TemperatureSet makes a zero Temperature explicit, as in CompletionRequest
+	TemperatureSet bool
+	SchemaName     string
+	StrictSchema   bool
 }
@@ -0,0 +1,26 @@
+package llm
+
+// StreamEventType identifies the kind of event delivered to a StreamCallback
+type StreamEventType string
+
+const (
+	// StreamEventContent carries an incremental piece of the completion text
+	StreamEventContent StreamEventType = "content"
+	// StreamEventToolStarted is emitted once a tool call has been fully received
+	// and is about to be executed
+	StreamEventToolStarted StreamEventType = "tool_started"
+	// StreamEventToolFinished is emitted after a tool call has been executed
+	StreamEventToolFinished StreamEventType = "tool_finished"
+)
+
+// StreamEvent is a single event within a streamed completion
+type StreamEvent struct {
+	Type     StreamEventType
+	Content  string    // Text delta for content events
+	ToolCall *ToolCall // The tool call for tool events
+	Result   string    // Tool output for tool finished events
+	Err      error     // Tool execution error for tool finished events
+}
+
+// StreamCallback receives stream events in order. Returning an error aborts the stream.
+type StreamCallback func(event StreamEvent) error
@@ -0,0 +1,30 @@
+package llm
+
+import "unicode/utf8"
+
+// charsPerToken is the rough number of characters per token of English text
+// used by EstimateTokens
+const charsPerToken = 4
+
+// TokenCounter counts the tokens a text takes in a model's context window
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// TokenCounterFunc adapts a function to a TokenCounter
+type TokenCounterFunc func(text string) int
+
+// CountTokens calls f(text)
+func (f TokenCounterFunc) CountTokens(text string) int {
+	return f(text)
+}
+
+// EstimateTokens estimates the tokens of a text at one token per four
+// characters, rounded up. It avoids a tokenizer dependency at the cost of
+// precision, so budgets based on it should keep some headroom.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimatingTokenCounter is the TokenCounter of EstimateTokens
+var EstimatingTokenCounter TokenCounter = TokenCounterFunc(EstimateTokens)
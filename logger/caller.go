@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxCallerDepth bounds the frames searched for the caller of the logger
+const maxCallerDepth = 32
+
+var (
+	// loggerPackage and logrusPackage are the packages of this logger and of
+	// logrus, see isWrapperFrame
+	loggerPackage = packageOf(runtime.FuncForPC(funcPC(packageOf)).Name())
+	logrusPackage = packageOf(runtime.FuncForPC(funcPC(logrus.New)).Name())
+
+	// wrapperFrames are the functions of this package between a call to the
+	// logger and logrus. Other functions of the package logging are callers.
+	wrapperFrames = functionNames(
+		(*Logger).log, (*Logger).logf,
+		(*Logger).Debug, (*Logger).Info, (*Logger).Warn, (*Logger).Error, (*Logger).Fatal,
+		(*Logger).Debugf, (*Logger).Infof, (*Logger).Warnf, (*Logger).Errorf, (*Logger).Fatalf,
+	)
+)
+
+// callerHook replaces the caller logrus reports, which is the wrapper in this
+// package that called it, with the first frame outside of logrus and the
+// wrappers of this package, skipping skip more frames, so formatters show the
+// real call site
+type callerHook struct {
+	skip int
+}
+
+func (h *callerHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *callerHook) Fire(entry *logrus.Entry) error {
+	pcs := make([]uintptr, maxCallerDepth)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	skip := h.skip
+	for {
+		frame, more := frames.Next()
+		if !isWrapperFrame(frame) {
+			if skip == 0 {
+				entry.Caller = &frame
+				return nil
+			}
+			skip--
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// isWrapperFrame reports whether a frame is one of logrus or of a wrapper of
+// this package, including those the compiler generates for the methods of
+// logrus.Logger promoted to Logger
+func isWrapperFrame(frame runtime.Frame) bool {
+	if wrapperFrames[frame.Function] {
+		return true
+	}
+	pkg := packageOf(frame.Function)
+	return pkg == logrusPackage || (pkg == loggerPackage && frame.File == "<autogenerated>")
+}
+
+// functionNames returns the set of the fully qualified names of functions
+func functionNames(fns ...interface{}) map[string]bool {
+	names := make(map[string]bool, len(fns))
+	for _, fn := range fns {
+		names[runtime.FuncForPC(funcPC(fn)).Name()] = true
+	}
+	return names
+}
+
+// packageOf returns the package of a fully qualified function name, e.g.
+// github.com/sirupsen/logrus for github.com/sirupsen/logrus.(*Entry).Log
+func packageOf(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
+
+// funcPC returns the program counter of a function
+func funcPC(fn interface{}) uintptr {
+	return reflect.ValueOf(fn).Pointer()
+}
@@ -0,0 +1,60 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/velumlabs/thor/logger"
+)
+
+// callerLine returns the line of the call to it
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+// logThrough logs through a helper of one's own, as skipped with CallerSkip
+func logThrough(l *logger.Logger) {
+	l.Info("entry")
+}
+
+func TestReportCaller(t *testing.T) {
+	tests := []struct {
+		name string
+		skip int
+		// log logs an entry and returns the line of its call site
+		log func(l *logger.Logger) int
+	}{
+		{name: "Info", log: func(l *logger.Logger) int { l.Info("entry"); return callerLine() }},
+		{name: "Errorf", log: func(l *logger.Logger) int { l.Errorf("entry %d", 1); return callerLine() }},
+		{name: "copy", log: func(l *logger.Logger) int { l.WithField("user", "alice").Warn("entry"); return callerLine() }},
+		{name: "sub-logger", log: func(l *logger.Logger) int { l.NewSubLogger("llm", nil).Info("entry"); return callerLine() }},
+		{name: "promoted logrus method", log: func(l *logger.Logger) int { l.Println("entry"); return callerLine() }},
+		{name: "logrus logger", log: func(l *logger.Logger) int { l.Logger.Info("entry"); return callerLine() }},
+		{name: "caller skip", skip: 1, log: func(l *logger.Logger) int { logThrough(l); return callerLine() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l, err := logger.New(&logger.Config{Level: "info", JSONFormat: true, ReportCaller: true, CallerSkip: tt.skip, Output: &out})
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := tt.log(l)
+
+			var entry struct {
+				File string `json:"file"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("failed to decode %s: %v", out.String(), err)
+			}
+			if want := fmt.Sprintf("caller_test.go:%d", line); entry.File != want {
+				t.Errorf("caller = %s, want %s", entry.File, want)
+			}
+		})
+	}
+}
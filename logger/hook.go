@@ -0,0 +1,249 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultHookQueueSize is the number of entries a hook holds while its sink is
+// busy before it drops entries
+const DefaultHookQueueSize = 1024
+
+// Entry is a log entry passed to the sinks of hooks, see AddHook
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   logrus.Level           `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	// Caller is the file:line of the call site, if callers are reported
+	Caller string `json:"caller,omitempty"`
+}
+
+// hookSet fires the hooks added with AddHook to the loggers sharing a logrus
+// logger. It is registered with the logrus logger once, and hooks are added to
+// and removed from it under its own lock, so the hooks of the logrus logger,
+// which logging goroutines read under the logger's lock, are never rewritten.
+type hookSet struct {
+	mu sync.RWMutex
+	// hooks are replaced rather than changed in place, so Fire can use them
+	// after releasing the lock
+	hooks logrus.LevelHooks
+}
+
+var (
+	// hookSetsMu guards hookSets
+	hookSetsMu sync.Mutex
+	// hookSets are the hook sets of the logrus loggers, by logger
+	hookSets = make(map[*logrus.Logger]*hookSet)
+)
+
+// hookSetOf returns the hook set of a logrus logger, registering one with the
+// logger if it has none yet
+func hookSetOf(logger *logrus.Logger) *hookSet {
+	hookSetsMu.Lock()
+	defer hookSetsMu.Unlock()
+
+	set, ok := hookSets[logger]
+	if !ok {
+		set = &hookSet{hooks: make(logrus.LevelHooks)}
+		hookSets[logger] = set
+		logger.AddHook(set)
+	}
+	return set
+}
+
+// add adds a hook to the set
+func (s *hookSet) add(h *Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hooks := make(logrus.LevelHooks, len(s.hooks))
+	for level, levelHooks := range s.hooks {
+		hooks[level] = append([]logrus.Hook(nil), levelHooks...)
+	}
+	for _, level := range h.Levels() {
+		hooks[level] = append(hooks[level], h)
+	}
+	s.hooks = hooks
+}
+
+// remove removes a hook from the set. Removing a hook not in the set does
+// nothing.
+func (s *hookSet) remove(h *Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hooks := make(logrus.LevelHooks, len(s.hooks))
+	for level, levelHooks := range s.hooks {
+		for _, hook := range levelHooks {
+			if hook != logrus.Hook(h) {
+				hooks[level] = append(hooks[level], hook)
+			}
+		}
+	}
+	s.hooks = hooks
+}
+
+// Levels returns all levels, the hooks of the set pick theirs
+func (s *hookSet) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire passes an entry to the hooks of the set for its level
+func (s *hookSet) Fire(entry *logrus.Entry) error {
+	s.mu.RLock()
+	hooks := s.hooks
+	s.mu.RUnlock()
+	return hooks.Fire(entry.Level, entry)
+}
+
+// Hook ships the entries of a logger to a sink in the background, see AddHook
+type Hook struct {
+	// set is the hook set the hook is added to
+	set    *hookSet
+	levels []logrus.Level
+	sink   func(Entry) error
+	queue  chan Entry
+	// redact redacts entries like the logger's formatter, if set
+	redact *redactor
+
+	dropped int64
+	failed  int64
+
+	// mu orders queueing entries with closing the queue
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// AddHook sends the entries of the given levels, or of all levels if nil,
+// written through the logger, its sub-loggers and the other loggers sharing
+// its output, to sink, e.g. to forward errors to an alerting service:
+//
+//	hook := log.AddHook([]logrus.Level{logrus.ErrorLevel}, func(entry logger.Entry) error {
+//		return alerts.Send(entry.Message, entry.Fields)
+//	})
+//	defer hook.Close()
+//
+// Entries carry all their fields, including the name of the sub-logger under
+// "logger", redacted like the logger's output, see SetRedaction. They are
+// queued, DefaultHookQueueSize at most, and passed to sink one at a time in a
+// goroutine of the hook, so a slow sink can't hold up logging; entries that
+// don't fit in the queue are dropped and counted. Errors of sink are counted
+// and reported on stderr rather than logged, so they can't feed back into the
+// hook.
+//
+// AddHook takes the place of the AddHook method of the embedded logrus logger;
+// hooks implementing logrus.Hook are added with l.Logger.AddHook.
+func (l *Logger) AddHook(levels []logrus.Level, sink func(Entry) error) *Hook {
+	if levels == nil {
+		levels = logrus.AllLevels
+	}
+	h := &Hook{
+		set:    hookSetOf(l.Logger),
+		levels: levels,
+		sink:   sink,
+		queue:  make(chan Entry, DefaultHookQueueSize),
+		redact: l.redact,
+		done:   make(chan struct{}),
+	}
+	go h.run()
+	h.set.add(h)
+	return h
+}
+
+// Dropped returns the number of entries dropped because the queue was full
+func (h *Hook) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// Failed returns the number of entries the sink failed to take
+func (h *Hook) Failed() int64 {
+	return atomic.LoadInt64(&h.failed)
+}
+
+// Close unregisters the hook, stops queueing entries and waits for the queued
+// ones to be passed to the sink. Closing a hook again does nothing.
+func (h *Hook) Close() {
+	// Entries being written meanwhile may still reach Fire, which drops them
+	// once closed
+	h.set.remove(h)
+
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+	<-h.done
+}
+
+// Levels returns the levels of the entries the hook ships
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire queues an entry, or drops it if the queue is full or the hook closed
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		atomic.AddInt64(&h.dropped, 1)
+		return nil
+	}
+	if h.redact != nil {
+		entry = h.redact.redact(entry)
+	}
+	select {
+	case h.queue <- newEntry(entry):
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+	}
+	return nil
+}
+
+// run passes the queued entries to the sink until the hook is closed
+func (h *Hook) run() {
+	defer close(h.done)
+	for entry := range h.queue {
+		if err := h.sink(entry); err != nil {
+			atomic.AddInt64(&h.failed, 1)
+			reportSinkError(err)
+		}
+	}
+}
+
+// reportSinkError reports an error of a sink on stderr, rather than through a
+// logger whose hooks could pass it back to the sink
+func reportSinkError(err error) {
+	fmt.Fprintf(os.Stderr, "logger: failed to ship log entries: %v\n", err)
+}
+
+// newEntry returns the Entry of a logrus entry, with errors among its fields
+// turned into their messages so they can be encoded
+func newEntry(entry *logrus.Entry) Entry {
+	fields := make(map[string]interface{}, len(entry.Data))
+	for key, value := range entry.Data {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		fields[key] = value
+	}
+	e := Entry{
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: entry.Message,
+		Fields:  fields,
+	}
+	if entry.HasCaller() {
+		e.Caller = fmt.Sprintf("%s:%d", filepath.Base(entry.Caller.File), entry.Caller.Line)
+	}
+	return e
+}
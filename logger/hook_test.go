@@ -0,0 +1,197 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// collectingSink is a sink keeping the messages of its entries
+type collectingSink struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (s *collectingSink) send(entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, entry.Message)
+	return nil
+}
+
+// registered reports whether a hook is among the hooks of its hook set
+func registered(h *Hook) bool {
+	h.set.mu.RLock()
+	defer h.set.mu.RUnlock()
+	for _, levelHooks := range h.set.hooks {
+		for _, hook := range levelHooks {
+			if hook == logrus.Hook(h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestAddHookLevels(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels []logrus.Level
+		want   []string
+	}{
+		{name: "all levels", want: []string{"info entry", "warn entry", "error entry"}},
+		{name: "errors", levels: []logrus.Level{logrus.ErrorLevel}, want: []string{"error entry"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			root, _, _, _ := newTestTree(t, &out)
+			sink := &collectingSink{}
+			hook := root.AddHook(tt.levels, sink.send)
+
+			root.Debug("debug entry")
+			root.Info("info entry")
+			root.Warn("warn entry")
+			root.Error("error entry")
+			hook.Close()
+
+			if len(sink.messages) != len(tt.want) {
+				t.Fatalf("messages = %q, want %q", sink.messages, tt.want)
+			}
+			for i, want := range tt.want {
+				if sink.messages[i] != want {
+					t.Errorf("message %d = %q, want %q", i, sink.messages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestHookClose(t *testing.T) {
+	var out bytes.Buffer
+	root, _, _, _ := newTestTree(t, &out)
+	sink := &collectingSink{}
+	hook := root.AddHook(nil, sink.send)
+	other := root.AddHook(nil, (&collectingSink{}).send)
+	defer other.Close()
+
+	root.Info("before close")
+	hook.Close()
+	if registered(hook) {
+		t.Error("closed hook is still registered")
+	}
+	if !registered(other) {
+		t.Error("closing a hook unregistered another")
+	}
+
+	// Entries written after Close neither reach the hook nor count as dropped
+	root.Info("after close")
+	hook.Close()
+	if len(sink.messages) != 1 || hook.Dropped() != 0 {
+		t.Errorf("messages = %q with %d dropped, want the entry before close", sink.messages, hook.Dropped())
+	}
+}
+
+func TestHookCloseConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	root, _, _, _ := newTestTree(t, &out)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		hook := root.AddHook(nil, (&collectingSink{}).send)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			root.Info("entry")
+		}()
+		go func() {
+			defer wg.Done()
+			hook.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			hook.Close()
+		}()
+	}
+	wg.Wait()
+
+	if got := len(hookSetOf(root.Logger).hooks[logrus.InfoLevel]); got != 0 {
+		t.Errorf("hooks = %d after closing all, want 0", got)
+	}
+}
+
+// logrusHook is a hook added to a logrus logger directly
+type logrusHook struct{}
+
+func (logrusHook) Levels() []logrus.Level   { return logrus.AllLevels }
+func (logrusHook) Fire(*logrus.Entry) error { return nil }
+
+// Run with -race: hooks are added and closed while entries are written
+func TestHookCloseWhileLogging(t *testing.T) {
+	tests := []struct {
+		name string
+		// hooks is the number of hooks added and closed in turn
+		hooks int
+		// logrusHooks is whether hooks are also added to the logrus logger
+		// directly meanwhile
+		logrusHooks bool
+	}{
+		{name: "hooks", hooks: 50},
+		{name: "with logrus hooks", hooks: 50, logrusHooks: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			root, llm, _, _ := newTestTree(t, &out)
+			kept := &collectingSink{}
+			keptHook := root.AddHook([]logrus.Level{logrus.WarnLevel}, kept.send)
+
+			stop := make(chan struct{})
+			var loggers sync.WaitGroup
+			for _, l := range []*Logger{root, llm, root, llm} {
+				loggers.Add(1)
+				go func(l *Logger) {
+					defer loggers.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							l.Info("entry")
+						}
+					}
+				}(l)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.hooks; i++ {
+				hook := root.AddHook(nil, (&collectingSink{}).send)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					hook.Close()
+				}()
+				if tt.logrusHooks {
+					root.Logger.AddHook(logrusHook{})
+				}
+			}
+			wg.Wait()
+			close(stop)
+			loggers.Wait()
+
+			// The hook left registered still gets its entries
+			root.Warn("kept")
+			keptHook.Close()
+			if len(kept.messages) != 1 || kept.messages[0] != "kept" {
+				t.Errorf("kept hook messages = %q, want [kept]", kept.messages)
+			}
+			if got := len(hookSetOf(root.Logger).hooks[logrus.InfoLevel]); got != 0 {
+				t.Errorf("hooks = %d after closing all, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHTTPSinkBatchSize is the number of entries an HTTPSink posts at
+	// once when no batch size is set
+	DefaultHTTPSinkBatchSize = 100
+
+	// DefaultHTTPSinkFlushInterval is how long an HTTPSink holds entries before
+	// posting a partial batch when no interval is set
+	DefaultHTTPSinkFlushInterval = 5 * time.Second
+)
+
+// HTTPSink posts log entries to an HTTP endpoint as JSON arrays in batches,
+// e.g. for a log pipeline. It is a sink for AddHook:
+//
+//	sink := logger.NewHTTPSink("https://logs.example.com/ingest", 0, 0)
+//	hook := log.AddHook(nil, sink.Send)
+//	defer sink.Close()
+//	defer hook.Close()
+//
+// Batches are posted once they are full, and partial batches after the flush
+// interval. A batch that fails to post is dropped. Fields that can't be
+// encoded as JSON are sent as their text.
+type HTTPSink struct {
+	URL    string
+	Client *http.Client
+	// Header is added to every request, e.g. for authorization
+	Header http.Header
+
+	batchSize int
+
+	mu       sync.Mutex
+	batch    []Entry
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewHTTPSink returns a sink posting to url in batches of batchSize entries,
+// or of the entries of flushInterval, DefaultHTTPSinkBatchSize and
+// DefaultHTTPSinkFlushInterval if zero
+func NewHTTPSink(url string, batchSize int, flushInterval time.Duration) *HTTPSink {
+	if batchSize <= 0 {
+		batchSize = DefaultHTTPSinkBatchSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultHTTPSinkFlushInterval
+	}
+	s := &HTTPSink{
+		URL:       url,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		Header:    http.Header{},
+		batchSize: batchSize,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go s.flushPeriodically(flushInterval)
+	return s
+}
+
+// Send adds an entry to the batch, posting the batch if it is full
+func (s *HTTPSink) Send(entry Entry) error {
+	s.mu.Lock()
+	s.batch = append(s.batch, entry)
+	if len(s.batch) < s.batchSize {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+	return s.post(batch)
+}
+
+// Flush posts the entries of the batch
+func (s *HTTPSink) Flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.post(batch)
+}
+
+// Close stops the periodic flush and posts the entries of the batch. Close
+// the hooks sending to the sink first, so their queued entries are included.
+func (s *HTTPSink) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.stopped
+	return s.Flush()
+}
+
+// flushPeriodically posts partial batches until the sink is closed. Failures
+// are reported on stderr like those of hooks.
+func (s *HTTPSink) flushPeriodically(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				reportSinkError(err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// post posts a batch of entries
+func (s *HTTPSink) post(batch []Entry) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		body, err = json.Marshal(encodableEntries(batch))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %d log entries: %w", len(batch), err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post log entries: %w", err)
+	}
+	for key, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post %d log entries: %w", len(batch), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post %d log entries: %s", len(batch), resp.Status)
+	}
+	return nil
+}
+
+// encodableEntries returns copies of entries whose fields that can't be encoded
+// as JSON, such as channels or NaN, are replaced by their text, so one field
+// doesn't lose a whole batch
+func encodableEntries(entries []Entry) []Entry {
+	encodable := make([]Entry, len(entries))
+	for i, entry := range entries {
+		fields := make(map[string]interface{}, len(entry.Fields))
+		for key, value := range entry.Fields {
+			if _, err := json.Marshal(value); err != nil {
+				value = fmt.Sprint(value)
+			}
+			fields[key] = value
+		}
+		entry.Fields = fields
+		encodable[i] = entry
+	}
+	return encodable
+}
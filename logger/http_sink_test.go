@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestHTTPSink returns a sink posting batches of batchSize to a test
+// server, and a function returning the batches it received
+func newTestHTTPSink(t *testing.T, batchSize int) (*HTTPSink, func() [][]Entry) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		batches [][]Entry
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Entry
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("failed to decode batch: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewHTTPSink(server.URL, batchSize, time.Hour)
+	return sink, func() [][]Entry {
+		mu.Lock()
+		defer mu.Unlock()
+		return batches
+	}
+}
+
+func TestHTTPSinkFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   map[string]interface{}
+		// stringified are the fields sent as their text, whatever it is
+		stringified []string
+	}{
+		{
+			name:   "encodable",
+			fields: map[string]interface{}{"user": "alice", "count": 2},
+			want:   map[string]interface{}{"user": "alice", "count": float64(2)},
+		},
+		{
+			name:   "NaN",
+			fields: map[string]interface{}{"user": "alice", "score": math.NaN()},
+			want:   map[string]interface{}{"user": "alice", "score": "NaN"},
+		},
+		{
+			name:        "function",
+			fields:      map[string]interface{}{"user": "alice", "callback": func() {}},
+			want:        map[string]interface{}{"user": "alice"},
+			stringified: []string{"callback"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, batches := newTestHTTPSink(t, 2)
+			if err := sink.Send(Entry{Message: "first", Fields: tt.fields}); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Send(Entry{Message: "second"}); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := batches()
+			if len(got) != 1 || len(got[0]) != 2 {
+				t.Fatalf("batches = %+v, want one of both entries", got)
+			}
+			for key, want := range tt.want {
+				if got[0][0].Fields[key] != want {
+					t.Errorf("field %s = %v, want %v", key, got[0][0].Fields[key], want)
+				}
+			}
+			for _, key := range tt.stringified {
+				if _, ok := got[0][0].Fields[key].(string); !ok {
+					t.Errorf("field %s = %v, want its text", key, got[0][0].Fields[key])
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSinkCloseConcurrent(t *testing.T) {
+	sink, batches := newTestHTTPSink(t, 10)
+	if err := sink.Send(Entry{Message: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.Close(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := batches(); len(got) != 1 || len(got[0]) != 1 {
+		t.Errorf("batches = %+v, want the pending entry once", got)
+	}
+}
@@ -2,9 +2,13 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,10 +17,37 @@ import (
 // Logger extends logrus.Logger with additional functionality
 type Logger struct {
 	*logrus.Logger
-	fields   logrus.Fields
-	name     string
+	fields logrus.Fields
+	name   string
+	// parent and children link the logger into the tree of sub-loggers of the
+	// logger returned by New. The copies made by WithField share them with the
+	// logger they are made from, so they take its place in the tree.
 	parent   *Logger
 	children map[string]*Logger
+	// tree guards the children of all the loggers of the tree
+	tree *sync.RWMutex
+
+	// output is the file output closed by Close
+	output io.Closer
+	// level is the effective level of the logger, shared with the copies made
+	// by WithField. The shared logrus logger is kept at the most verbose level
+	// of all, so each logger drops the entries below its own.
+	level *levelState
+	// redact redacts entries, shared with the sub-loggers and copies
+	redact *redactor
+}
+
+// levelState holds the effective level of a logger
+type levelState struct {
+	level uint32
+}
+
+func (s *levelState) get() logrus.Level {
+	return logrus.Level(atomic.LoadUint32(&s.level))
+}
+
+func (s *levelState) set(level logrus.Level) {
+	atomic.StoreUint32(&s.level, uint32(level))
 }
 
 // Config holds logger configuration
@@ -28,6 +59,32 @@ type Config struct {
 	TimeFormat   string
 	TreeFormat   bool
 	UseColors    bool
+
+	// Rotation of FileOutput, see RotatingFile: the size in megabytes past
+	// which the file is rotated, never if zero, how many rotated files are kept
+	// and for how many days, all if zero, and whether they are gzipped
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+
+	// Output replaces the file of FileOutput, e.g. with a writer rotating it
+	// some other way. It is closed by Close if it is an io.Closer.
+	Output io.Writer
+
+	// MultiOutput writes to stdout as well as the file. With ConsoleTreeFormat
+	// the console gets the tree format while the file keeps the configured
+	// one, e.g. JSON.
+	MultiOutput       bool
+	ConsoleTreeFormat bool
+
+	// Redaction selects the values redacted from entries before they are
+	// written or passed to hooks, none if nil, see SetRedaction
+	Redaction *RedactionRules
+
+	// CallerSkip is the number of frames skipped beyond those of the logger
+	// when reporting callers, for wrappers of the logger of one's own
+	CallerSkip int
 }
 
 // DefaultConfig returns default logger configuration
@@ -56,6 +113,12 @@ func New(config *Config) (*Logger, error) {
 	}
 	log.SetLevel(level)
 
+	// Report the callers of the logger, not the logger itself. The hook is
+	// added first so the other hooks see the callers too.
+	if config.ReportCaller {
+		log.AddHook(&callerHook{skip: config.CallerSkip})
+	}
+
 	// Configure formatter
 	if config.TreeFormat {
 		log.SetFormatter(&TreeFormatter{
@@ -83,24 +146,94 @@ func New(config *Config) (*Logger, error) {
 		log.SetFormatter(formatter)
 	}
 
+	// Redact entries in whichever format they are written
+	redactor, err := newRedactor(config.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	log.SetFormatter(&redactingFormatter{formatter: log.Formatter, redactor: redactor})
+
 	// Configure output
-	if config.FileOutput != "" {
-		file, err := os.OpenFile(config.FileOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	output := config.Output
+	if output == nil && config.FileOutput != "" {
+		file, err := NewRotatingFile(config.FileOutput, config.MaxSizeMB, config.MaxBackups, config.MaxAgeDays, config.Compress)
 		if err != nil {
-			return nil, fmt.Errorf("failed to open log file: %w", err)
+			return nil, err
+		}
+		output = file
+	}
+	if output != nil {
+		switch {
+		case !config.MultiOutput:
+			log.SetOutput(output)
+		case config.ConsoleTreeFormat:
+			log.SetOutput(output)
+			log.AddHook(&consoleHook{
+				out: os.Stdout,
+				formatter: &redactingFormatter{
+					formatter: &TreeFormatter{
+						TimestampFormat: config.TimeFormat,
+						ShowCaller:      config.ReportCaller,
+						UseColors:       config.UseColors,
+					},
+					redactor: redactor,
+				},
+			})
+		default:
+			log.SetOutput(io.MultiWriter(os.Stdout, output))
 		}
-		log.SetOutput(file)
 	}
 
 	// Enable caller reporting if configured
 	log.SetReportCaller(config.ReportCaller)
 
+	var closer io.Closer
+	if c, ok := output.(io.Closer); ok {
+		closer = c
+	}
 	return &Logger{
-		Logger: log,
-		fields: logrus.Fields{},
+		Logger:   log,
+		fields:   logrus.Fields{},
+		children: make(map[string]*Logger),
+		tree:     &sync.RWMutex{},
+		output:   closer,
+		level:    &levelState{level: uint32(level)},
+		redact:   redactor,
 	}, nil
 }
 
+// Close closes the file output of a logger returned by New, if any. Its
+// sub-loggers share the output, so they must not be used afterwards.
+func (l *Logger) Close() error {
+	if l.output == nil {
+		return nil
+	}
+	return l.output.Close()
+}
+
+// consoleHook writes entries to the console in a format of its own, for
+// MultiOutput with ConsoleTreeFormat
+type consoleHook struct {
+	mu        sync.Mutex
+	out       io.Writer
+	formatter logrus.Formatter
+}
+
+func (h *consoleHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *consoleHook) Fire(entry *logrus.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.out.Write(line)
+	return err
+}
+
 // WithField adds a field to the logger context
 func (l *Logger) WithField(key string, value interface{}) *Logger {
 	newFields := make(logrus.Fields)
@@ -108,10 +241,7 @@ func (l *Logger) WithField(key string, value interface{}) *Logger {
 		newFields[k] = v
 	}
 	newFields[key] = value
-	return &Logger{
-		Logger: l.Logger,
-		fields: newFields,
-	}
+	return l.copyWith(newFields)
 }
 
 // WithFields adds multiple fields to the logger context
@@ -123,9 +253,21 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	for k, v := range fields {
 		newFields[k] = v
 	}
+	return l.copyWith(newFields)
+}
+
+// copyWith returns a copy of the logger with other fields, which takes its
+// place in the tree of sub-loggers
+func (l *Logger) copyWith(fields logrus.Fields) *Logger {
 	return &Logger{
-		Logger: l.Logger,
-		fields: newFields,
+		Logger:   l.Logger,
+		fields:   fields,
+		name:     l.name,
+		parent:   l.parent,
+		children: l.children,
+		tree:     l.tree,
+		level:    l.level,
+		redact:   l.redact,
 	}
 }
 
@@ -134,8 +276,124 @@ func (l *Logger) WithError(err error) *Logger {
 	return l.WithField("error", err)
 }
 
+// SetRedaction replaces the rules redacting the entries of the logger, its
+// sub-loggers and the other loggers sharing its output at runtime, e.g. to
+// hide a leaking field during an incident:
+//
+//	err := log.SetRedaction(logger.RedactionRules{
+//		Fields:   []string{"api_key", "authorization"},
+//		Truncate: map[string]int{"content": 200},
+//		Hash:     true,
+//	})
+//
+// The previous rules are kept if the new ones are invalid.
+func (l *Logger) SetRedaction(rules RedactionRules) error {
+	if l.redact == nil {
+		return fmt.Errorf("logger was not created by New")
+	}
+	if err := l.redact.set(rules); err != nil {
+		return fmt.Errorf("failed to set redaction rules: %w", err)
+	}
+	return nil
+}
+
+// GetLevel returns the effective level of the logger, which may differ from
+// that of its parent, see SetLevel
+func (l *Logger) GetLevel() logrus.Level {
+	if l.level == nil {
+		return l.Logger.GetLevel()
+	}
+	return l.level.get()
+}
+
+// IsLevelEnabled reports whether the logger writes entries of a level
+func (l *Logger) IsLevelEnabled(level logrus.Level) bool {
+	return l.GetLevel() >= level
+}
+
+// SetLevel sets the level of the sub-logger with a dotted name relative to
+// the logger, e.g. "llm" or "llm.openai", or of the logger itself if name is
+// empty, and of all the sub-loggers below it, at runtime:
+//
+//	log.SetLevel("llm", logrus.DebugLevel)
+//
+// Sub-loggers created later inherit the level. A copy made by WithField sets
+// the level of the logger it was made from. The shared logrus logger is kept
+// at the most verbose level of the whole tree, so setting the level of one
+// logger never changes the entries written by the others. It returns an error
+// if there is no sub-logger with the name.
+func (l *Logger) SetLevel(name string, level logrus.Level) error {
+	target := l
+	if name != "" {
+		for _, part := range strings.Split(name, ".") {
+			target = target.GetSubLogger(part)
+			if target == nil {
+				return fmt.Errorf("no sub-logger %q", name)
+			}
+		}
+	}
+	mu := l.treeLock()
+	mu.Lock()
+	defer mu.Unlock()
+	target.setSubtreeLevel(level)
+	l.root().syncLevel()
+	return nil
+}
+
+// setSubtreeLevel sets the level of the logger and its sub-loggers. The tree
+// lock must be held.
+func (l *Logger) setSubtreeLevel(level logrus.Level) {
+	if l.level == nil {
+		l.level = &levelState{}
+	}
+	l.level.set(level)
+	for _, child := range l.children {
+		child.setSubtreeLevel(level)
+	}
+}
+
+// treeLock returns the lock guarding the tree of the logger
+func (l *Logger) treeLock() *sync.RWMutex {
+	if l.tree == nil {
+		return &orphanTree
+	}
+	return l.tree
+}
+
+// orphanTree guards the children of the loggers not created by New
+var orphanTree sync.RWMutex
+
+// root returns the logger the logger is a sub-logger of, or itself
+func (l *Logger) root() *Logger {
+	for l.parent != nil {
+		l = l.parent
+	}
+	return l
+}
+
+// syncLevel sets the level of the shared logrus logger to the most verbose
+// level of the logger and its sub-loggers. The tree lock must be held.
+func (l *Logger) syncLevel() {
+	l.Logger.SetLevel(l.mostVerbose())
+}
+
+// mostVerbose returns the most verbose level of the logger and its
+// sub-loggers. The tree lock must be held.
+func (l *Logger) mostVerbose() logrus.Level {
+	level := l.GetLevel()
+	for _, child := range l.children {
+		if childLevel := child.mostVerbose(); childLevel > level {
+			level = childLevel
+		}
+	}
+	return level
+}
+
 // log implements the actual logging logic
 func (l *Logger) log(level logrus.Level, args ...interface{}) {
+	if !l.IsLevelEnabled(level) {
+		return
+	}
 	if len(l.fields) > 0 {
 		l.Logger.WithFields(l.fields).Log(level, args...)
 	} else {
@@ -145,6 +403,9 @@ func (l *Logger) log(level logrus.Level, args ...interface{}) {
 
 // logf implements the actual formatted logging logic
 func (l *Logger) logf(level logrus.Level, format string, args ...interface{}) {
+	if !l.IsLevelEnabled(level) {
+		return
+	}
 	if len(l.fields) > 0 {
 		l.Logger.WithFields(l.fields).Logf(level, format, args...)
 	} else {
@@ -174,7 +435,8 @@ func (l *Logger) Fatalf(format string, args ...interface{}) {
 type SubLoggerOpts struct {
 	// Additional fields to add to the sub-logger
 	Fields logrus.Fields
-	// Override the log level for this sub-logger (optional)
+	// Override the log level for this sub-logger (optional), which otherwise
+	// inherits the level of its parent
 	Level *logrus.Level
 }
 
@@ -201,34 +463,52 @@ func (l *Logger) NewSubLogger(name string, opts *SubLoggerOpts) *Logger {
 		fields["logger"] = l.name + "." + name
 	}
 
+	level := l.GetLevel()
+	if opts.Level != nil {
+		level = *opts.Level
+	}
+
 	subLogger := &Logger{
 		Logger:   l.Logger,
 		fields:   fields,
 		name:     name,
 		parent:   l,
 		children: make(map[string]*Logger),
+		tree:     l.tree,
+		level:    &levelState{level: uint32(level)},
+		redact:   l.redact,
 	}
 
 	// Store in parent's children map
+	mu := l.treeLock()
+	mu.Lock()
+	defer mu.Unlock()
 	if l.children == nil {
 		l.children = make(map[string]*Logger)
 	}
 	l.children[name] = subLogger
-
+	l.root().syncLevel()
 	return subLogger
 }
 
 // GetSubLogger retrieves an existing sub-logger by name
 func (l *Logger) GetSubLogger(name string) *Logger {
-	if l.children == nil {
-		return nil
-	}
+	mu := l.treeLock()
+	mu.RLock()
+	defer mu.RUnlock()
 	return l.children[name]
 }
 
-// GetAllSubLoggers returns all immediate sub-loggers
+// GetAllSubLoggers returns a snapshot of all immediate sub-loggers
 func (l *Logger) GetAllSubLoggers() map[string]*Logger {
-	return l.children
+	mu := l.treeLock()
+	mu.RLock()
+	defer mu.RUnlock()
+	children := make(map[string]*Logger, len(l.children))
+	for name, child := range l.children {
+		children[name] = child
+	}
+	return children
 }
 
 // WithScope adds a scope field to the logger
@@ -0,0 +1,199 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestTree returns a logger at info with the sub-loggers "llm",
+// "llm.openai" and "store", writing to out
+func newTestTree(t *testing.T, out *bytes.Buffer) (root, llm, openai, store *Logger) {
+	t.Helper()
+	root, err := New(&Config{Level: "info", JSONFormat: true, Output: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm = root.NewSubLogger("llm", nil)
+	openai = llm.NewSubLogger("openai", nil)
+	store = root.NewSubLogger("store", nil)
+	return root, llm, openai, store
+}
+
+func TestSetLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		// set changes the levels of the tree
+		set func(root, llm, store *Logger) error
+		// want are the levels of root, llm, llm.openai and store
+		want [4]logrus.Level
+		// shared is the level of the shared logrus logger
+		shared logrus.Level
+	}{
+		{
+			name:   "sub-logger",
+			set:    func(root, llm, store *Logger) error { return root.SetLevel("llm", logrus.DebugLevel) },
+			want:   [4]logrus.Level{logrus.InfoLevel, logrus.DebugLevel, logrus.DebugLevel, logrus.InfoLevel},
+			shared: logrus.DebugLevel,
+		},
+		{
+			name:   "dotted name",
+			set:    func(root, llm, store *Logger) error { return root.SetLevel("llm.openai", logrus.TraceLevel) },
+			want:   [4]logrus.Level{logrus.InfoLevel, logrus.InfoLevel, logrus.TraceLevel, logrus.InfoLevel},
+			shared: logrus.TraceLevel,
+		},
+		{
+			name:   "root",
+			set:    func(root, llm, store *Logger) error { return root.SetLevel("", logrus.WarnLevel) },
+			want:   [4]logrus.Level{logrus.WarnLevel, logrus.WarnLevel, logrus.WarnLevel, logrus.WarnLevel},
+			shared: logrus.WarnLevel,
+		},
+		{
+			name: "copy of a sub-logger",
+			set: func(root, llm, store *Logger) error {
+				if err := root.SetLevel("llm", logrus.DebugLevel); err != nil {
+					return err
+				}
+				return store.WithField("table", "actors").SetLevel("", logrus.ErrorLevel)
+			},
+			want:   [4]logrus.Level{logrus.InfoLevel, logrus.DebugLevel, logrus.DebugLevel, logrus.ErrorLevel},
+			shared: logrus.DebugLevel,
+		},
+		{
+			name: "copy of the root",
+			set: func(root, llm, store *Logger) error {
+				return root.WithField("request", "1").SetLevel("llm", logrus.DebugLevel)
+			},
+			want:   [4]logrus.Level{logrus.InfoLevel, logrus.DebugLevel, logrus.DebugLevel, logrus.InfoLevel},
+			shared: logrus.DebugLevel,
+		},
+		{
+			name: "copy lowering its level",
+			set: func(root, llm, store *Logger) error {
+				if err := root.SetLevel("llm", logrus.DebugLevel); err != nil {
+					return err
+				}
+				return llm.WithFields(map[string]interface{}{"model": "gpt"}).SetLevel("", logrus.WarnLevel)
+			},
+			want:   [4]logrus.Level{logrus.InfoLevel, logrus.WarnLevel, logrus.WarnLevel, logrus.InfoLevel},
+			shared: logrus.InfoLevel,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			root, llm, openai, store := newTestTree(t, &out)
+			if err := tt.set(root, llm, store); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, l := range []*Logger{root, llm, openai, store} {
+				if got := l.GetLevel(); got != tt.want[i] {
+					t.Errorf("level of logger %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+			if got := root.Logger.GetLevel(); got != tt.shared {
+				t.Errorf("shared level = %s, want %s", got, tt.shared)
+			}
+
+			// Each logger writes the entries of its own level only
+			for i, l := range []*Logger{root, llm, openai, store} {
+				out.Reset()
+				l.Debug("debug entry")
+				if got, want := out.Len() > 0, tt.want[i] >= logrus.DebugLevel; got != want {
+					t.Errorf("logger %d wrote debug entry = %v, want %v", i, got, want)
+				}
+				out.Reset()
+				l.Info("info entry")
+				if got, want := out.Len() > 0, tt.want[i] >= logrus.InfoLevel; got != want {
+					t.Errorf("logger %d wrote info entry = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetLevelUnknown(t *testing.T) {
+	var out bytes.Buffer
+	root, _, _, _ := newTestTree(t, &out)
+	if err := root.SetLevel("llm.anthropic", logrus.DebugLevel); err == nil {
+		t.Error("SetLevel of an unknown sub-logger succeeded")
+	}
+}
+
+func TestSubLoggerOfCopy(t *testing.T) {
+	var out bytes.Buffer
+	root, llm, _, _ := newTestTree(t, &out)
+
+	// A sub-logger of a copy joins the tree of the logger the copy was made
+	// from, so it is found and leveled through it
+	copied := llm.WithField("request", "1")
+	anthropic := copied.NewSubLogger("anthropic", nil)
+	if got := llm.GetSubLogger("anthropic"); got != anthropic {
+		t.Fatalf("GetSubLogger = %p, want %p", got, anthropic)
+	}
+	if err := root.SetLevel("llm.anthropic", logrus.DebugLevel); err != nil {
+		t.Fatal(err)
+	}
+	if got := anthropic.GetLevel(); got != logrus.DebugLevel {
+		t.Errorf("level = %s, want debug", got)
+	}
+
+	anthropic.Debug("debug entry")
+	if !strings.Contains(out.String(), `"logger":"llm.anthropic"`) {
+		t.Errorf("output = %s, want the name of the sub-logger", out.String())
+	}
+}
+
+func TestSetLevelConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	root, llm, _, _ := newTestTree(t, &out)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			llm.WithField("worker", i).NewSubLogger(fmt.Sprintf("worker%d", i), nil).Info("created")
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := root.SetLevel("llm", logrus.DebugLevel); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range root.GetAllSubLoggers() {
+			}
+			llm.GetSubLogger("openai").Debug("debug entry")
+		}()
+	}
+	wg.Wait()
+
+	if got := len(llm.GetAllSubLoggers()); got != 9 {
+		t.Errorf("sub-loggers of llm = %d, want 9", got)
+	}
+}
+
+func TestReportCallerInPackage(t *testing.T) {
+	var out bytes.Buffer
+	l, err := New(&Config{Level: "info", JSONFormat: true, ReportCaller: true, Output: &out})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls from this package are callers like any other, only the wrappers
+	// of the logger are skipped
+	l.Info("entry")
+	_, _, line, _ := runtime.Caller(0)
+	if want := fmt.Sprintf(`"file":"logger_test.go:%d"`, line-1); !strings.Contains(out.String(), want) {
+		t.Errorf("output = %s, want %s", out.String(), want)
+	}
+}
@@ -0,0 +1,258 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces redacted values in log entries
+const Redacted = "[REDACTED]"
+
+// RedactionRules selects the values redacted from log entries, see
+// Logger.SetRedaction
+type RedactionRules struct {
+	// Fields whose values are redacted, matched case-insensitively, e.g.
+	// "api_key" or "authorization". They also match the keys of maps and the
+	// fields of structs nested in field values.
+	Fields []string
+	// Fields whose values are cut to a prefix of the given number of bytes
+	// when longer, e.g. {"content": 200} for prompts
+	Truncate map[string]int
+	// Patterns, in RE2 syntax, whose matches are redacted from the messages
+	// and the text in field values of entries, e.g. `sk-[A-Za-z0-9]{20,}`
+	Patterns []string
+	// Hash replaces redacted values with a short hash of them, e.g.
+	// "[REDACTED:3f2a9c1d]", and appends it to truncated ones, so entries
+	// with the same values can be correlated
+	Hash bool
+}
+
+// redactor redacts log entries by rules that can be replaced at runtime. It is
+// shared by a logger and its sub-loggers.
+type redactor struct {
+	rules atomic.Value // *compiledRules
+}
+
+// compiledRules are RedactionRules ready to apply
+type compiledRules struct {
+	fields   map[string]bool
+	truncate map[string]int
+	patterns []*regexp.Regexp
+	hash     bool
+}
+
+// empty reports whether the rules redact nothing
+func (r *compiledRules) empty() bool {
+	return len(r.fields) == 0 && len(r.truncate) == 0 && len(r.patterns) == 0
+}
+
+// newRedactor returns a redactor applying rules, if any
+func newRedactor(rules *RedactionRules) (*redactor, error) {
+	r := &redactor{}
+	if rules == nil {
+		rules = &RedactionRules{}
+	}
+	if err := r.set(*rules); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// set replaces the rules of the redactor
+func (r *redactor) set(rules RedactionRules) error {
+	compiled := &compiledRules{
+		fields:   make(map[string]bool, len(rules.Fields)),
+		truncate: make(map[string]int, len(rules.Truncate)),
+		hash:     rules.Hash,
+	}
+	for _, field := range rules.Fields {
+		compiled.fields[strings.ToLower(field)] = true
+	}
+	for field, length := range rules.Truncate {
+		if length < 0 {
+			return fmt.Errorf("invalid truncation of field %q: length must not be negative", field)
+		}
+		compiled.truncate[strings.ToLower(field)] = length
+	}
+	for _, pattern := range rules.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		compiled.patterns = append(compiled.patterns, re)
+	}
+	r.rules.Store(compiled)
+	return nil
+}
+
+// redact returns a copy of entry with its message and fields redacted, or
+// entry itself if the rules redact nothing
+func (r *redactor) redact(entry *logrus.Entry) *logrus.Entry {
+	rules := r.rules.Load().(*compiledRules)
+	if rules.empty() {
+		return entry
+	}
+
+	redacted := *entry
+	redacted.Message = rules.redactString(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		redacted.Data[key] = rules.redactField(key, value)
+	}
+	return &redacted
+}
+
+// maxRedactDepth bounds how deep redaction descends into nested values, so
+// cyclic values can't recurse forever
+const maxRedactDepth = 16
+
+// redactField returns the redacted value of a field
+func (r *compiledRules) redactField(key string, value interface{}) interface{} {
+	return r.redactValue(strings.ToLower(key), value, 0)
+}
+
+// redactValue returns the redacted value of the field name, lowercased.
+// Strings, byte slices, errors and Stringers are redacted as text; maps with
+// string keys and structs are copied to maps whose entries are redacted as
+// fields of their own, under their keys and JSON names; and slices and arrays
+// are copied with their elements redacted like the field.
+func (r *compiledRules) redactValue(name string, value interface{}, depth int) interface{} {
+	if value == nil {
+		return nil
+	}
+	if r.fields[name] {
+		return r.replacement(fmt.Sprint(value))
+	}
+
+	switch v := value.(type) {
+	case string:
+		return r.redactText(name, v)
+	case []byte:
+		return r.redactText(name, string(v))
+	case error:
+		return r.redactText(name, v.Error())
+	case fmt.Stringer:
+		return r.redactText(name, v.String())
+	}
+	if depth >= maxRedactDepth {
+		return value
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return value
+		}
+		return r.redactValue(name, rv.Elem().Interface(), depth+1)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		redacted := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			redacted[key] = r.redactValue(strings.ToLower(key), iter.Value().Interface(), depth+1)
+		}
+		return redacted
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return value
+		}
+		redacted := make([]interface{}, rv.Len())
+		for i := range redacted {
+			redacted[i] = r.redactValue(name, rv.Index(i).Interface(), depth+1)
+		}
+		return redacted
+	case reflect.Struct:
+		redacted := make(map[string]interface{}, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			key, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			redacted[key] = r.redactValue(strings.ToLower(key), rv.Field(i).Interface(), depth+1)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// jsonName returns the name a struct field is encoded under in JSON, and false
+// if it isn't encoded
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return name, true
+	}
+}
+
+// redactText returns the redacted text of the field name, lowercased
+func (r *compiledRules) redactText(name, text string) string {
+	text = r.redactString(text)
+	if length, ok := r.truncate[name]; ok && len(text) > length {
+		truncated := truncateUTF8(text, length) + "…"
+		if r.hash {
+			truncated += r.replacement(text)
+		}
+		text = truncated
+	}
+	return text
+}
+
+// redactString returns text with the matches of the patterns redacted
+func (r *compiledRules) redactString(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllStringFunc(text, r.replacement)
+	}
+	return text
+}
+
+// replacement returns what replaces a redacted value
+func (r *compiledRules) replacement(value string) string {
+	if !r.hash {
+		return Redacted
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "[REDACTED:" + hex.EncodeToString(sum[:4]) + "]"
+}
+
+// truncateUTF8 cuts text to at most n bytes without splitting a character
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
+
+// redactingFormatter redacts entries before formatting them
+type redactingFormatter struct {
+	formatter logrus.Formatter
+	redactor  *redactor
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return f.formatter.Format(f.redactor.redact(entry))
+}
@@ -0,0 +1,201 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// credentials is a struct logged as a field value
+type credentials struct {
+	User   string `json:"user"`
+	APIKey string `json:"api_key"`
+	Token  []byte
+	secret string
+	Hidden string `json:"-"`
+}
+
+// stringer is a value logged through its String method
+type stringer string
+
+func (s stringer) String() string {
+	return "stringer " + string(s)
+}
+
+// node is a cyclic value
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestRedactField(t *testing.T) {
+	rules := RedactionRules{
+		Fields:   []string{"api_key", "Authorization"},
+		Truncate: map[string]int{"content": 5},
+		Patterns: []string{`sk-[a-z0-9]{8}`},
+	}
+	cyclic := &node{Name: "sk-aaaaaaaa"}
+	cyclic.Next = cyclic
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "redacted field", key: "API_KEY", value: 42, want: Redacted},
+		{name: "pattern in string", key: "note", value: "key sk-abcdef12 used", want: "key [REDACTED] used"},
+		{name: "pattern in bytes", key: "note", value: []byte("sk-abcdef12"), want: Redacted},
+		{name: "pattern in error", key: "error", value: errors.New("bad key sk-abcdef12"), want: "bad key [REDACTED]"},
+		{name: "pattern in stringer", key: "note", value: stringer("sk-abcdef12"), want: "stringer [REDACTED]"},
+		{name: "truncated", key: "content", value: "héllo world", want: "héll…"},
+		{name: "untouched number", key: "count", value: 3, want: 3},
+		{name: "nil", key: "note", value: nil, want: nil},
+		{
+			name: "nested map",
+			key:  "request",
+			value: map[string]interface{}{
+				"authorization": "Bearer abc",
+				"body":          map[string]string{"content": "a long prompt", "key": "sk-abcdef12"},
+			},
+			want: map[string]interface{}{
+				"authorization": Redacted,
+				"body":          map[string]interface{}{"content": "a lon…", "key": Redacted},
+			},
+		},
+		{
+			name:  "struct",
+			key:   "credentials",
+			value: credentials{User: "alice", APIKey: "secret", Token: []byte("sk-abcdef12"), secret: "x", Hidden: "y"},
+			want:  map[string]interface{}{"user": "alice", "api_key": Redacted, "Token": Redacted},
+		},
+		{
+			name:  "pointer to struct",
+			key:   "credentials",
+			value: &credentials{User: "sk-abcdef12"},
+			want:  map[string]interface{}{"user": Redacted, "api_key": Redacted, "Token": ""},
+		},
+		{
+			name:  "slice",
+			key:   "messages",
+			value: []interface{}{"sk-abcdef12", map[string]string{"api_key": "secret"}},
+			want:  []interface{}{Redacted, map[string]interface{}{"api_key": Redacted}},
+		},
+		{
+			name:  "slice of truncated field",
+			key:   "content",
+			value: []string{"short", "longer text"},
+			want:  []interface{}{"short", "longe…"},
+		},
+		{
+			name:  "non-string map keys",
+			key:   "counts",
+			value: map[int]string{1: "sk-abcdef12"},
+			want:  map[int]string{1: "sk-abcdef12"},
+		},
+	}
+
+	redactor, err := newRedactor(&rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := redactor.rules.Load().(*compiledRules)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compiled.redactField(tt.key, tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactField(%q, %#v) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("cyclic", func(t *testing.T) {
+		got := compiled.redactField("node", cyclic)
+		if m, ok := got.(map[string]interface{}); !ok || m["Name"] != Redacted {
+			t.Errorf("redactField of a cyclic value = %#v", got)
+		}
+	})
+}
+
+func TestRedactionInOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "text", config: Config{Level: "info"}},
+		{name: "json", config: Config{Level: "info", JSONFormat: true}},
+		{name: "tree", config: Config{Level: "info", TreeFormat: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.config.Output = &out
+			tt.config.Redaction = &RedactionRules{
+				Fields:   []string{"api_key"},
+				Patterns: []string{`sk-[a-z0-9]{8}`},
+			}
+			log, err := New(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			log.WithFields(map[string]interface{}{
+				"request": map[string]interface{}{"api_key": "hunter2", "header": "sk-abcdef12"},
+			}).Info("calling with sk-12345678")
+
+			for _, secret := range []string{"hunter2", "sk-abcdef12", "sk-12345678"} {
+				if strings.Contains(out.String(), secret) {
+					t.Errorf("output leaks %q: %s", secret, out.String())
+				}
+			}
+
+			// Rules can be replaced at runtime
+			out.Reset()
+			if err := log.SetRedaction(RedactionRules{}); err != nil {
+				t.Fatal(err)
+			}
+			log.WithField("api_key", "hunter2").Info("no rules")
+			if !strings.Contains(out.String(), "hunter2") {
+				t.Errorf("output is redacted without rules: %s", out.String())
+			}
+		})
+	}
+}
+
+func TestSetRedactionInvalid(t *testing.T) {
+	var out bytes.Buffer
+	log, err := New(&Config{Level: "info", JSONFormat: true, Output: &out, Redaction: &RedactionRules{Fields: []string{"api_key"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rules RedactionRules
+	}{
+		{name: "invalid pattern", rules: RedactionRules{Patterns: []string{"("}}},
+		{name: "negative truncation", rules: RedactionRules{Truncate: map[string]int{"content": -1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := log.SetRedaction(tt.rules); err == nil {
+				t.Fatal("SetRedaction accepted invalid rules")
+			}
+
+			out.Reset()
+			log.WithField("api_key", "hunter2").Info("kept rules")
+			var entry map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["api_key"] != Redacted {
+				t.Errorf("previous rules were not kept: %v", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp in the names of rotated log files
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is a log file that is rotated when it grows past a size: it is
+// renamed with the time of the rotation, e.g. agent-2024-05-01T10-00-00.000.log,
+// numbered if the file was already rotated within the millisecond, and a new
+// file is started. Old rotated files are removed beyond a count and
+// an age, and optionally compressed. It is safe for concurrent writes.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// mill removes and compresses rotated files in the background, one run
+	// at a time
+	mill sync.Mutex
+	wg   sync.WaitGroup
+}
+
+// NewRotatingFile opens the log file at path for appending, rotated once it
+// grows past maxSizeMB megabytes, never if zero. maxBackups and maxAgeDays
+// bound how many and how old the rotated files kept are, no limit if zero.
+func NewRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int, compress bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+		compress:   compress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write writes p to the file, rotating it first if p would take it past its
+// maximum size. Writes larger than the maximum size go to a file of their own.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, fmt.Errorf("log file %s is closed", r.path)
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now, e.g. on SIGHUP
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+// Close closes the file, waiting for rotated files being compressed
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.wg.Wait()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open opens the file for appending. Must be called with mu held.
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate renames the file with the current time and opens a new one. Must be
+// called with mu held.
+func (r *RotatingFile) rotate() error {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			return fmt.Errorf("failed to close log file: %w", err)
+		}
+		r.file = nil
+	}
+	if err := os.Rename(r.path, r.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.millBackups()
+	}()
+	return nil
+}
+
+// backupName returns a name for the file rotated at t that no rotated file
+// has, numbering the rotations within a millisecond after the first, e.g.
+// agent-2024-05-01T10-00-00.000.1.log
+func (r *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext) + "-" + t.Format(backupTimeFormat)
+	name := base + ext
+	for seq := 1; backupExists(name); seq++ {
+		name = fmt.Sprintf("%s.%d%s", base, seq, ext)
+	}
+	return name
+}
+
+// backupExists reports whether a rotated file, or its compressed copy, exists
+func backupExists(name string) bool {
+	for _, path := range []string{name, name + ".gz"} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// backup is a rotated log file
+type backup struct {
+	path      string
+	rotatedAt time.Time
+	// seq numbers the files rotated within the same millisecond
+	seq int
+}
+
+// backups returns the rotated files, newest first
+func (r *RotatingFile) backups() ([]backup, error) {
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		rotatedAt, seq, ok := parseBackupStamp(strings.TrimPrefix(stamp, prefix))
+		if !ok {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), rotatedAt: rotatedAt, seq: seq})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].rotatedAt.Equal(backups[j].rotatedAt) {
+			return backups[i].rotatedAt.After(backups[j].rotatedAt)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+	return backups, nil
+}
+
+// parseBackupStamp parses the time and sequence number of a rotated file's
+// name, see backupName
+func parseBackupStamp(stamp string) (time.Time, int, bool) {
+	if len(stamp) < len(backupTimeFormat) {
+		return time.Time{}, 0, false
+	}
+	rotatedAt, err := time.ParseInLocation(backupTimeFormat, stamp[:len(backupTimeFormat)], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	suffix := stamp[len(backupTimeFormat):]
+	if suffix == "" {
+		return rotatedAt, 0, true
+	}
+	seq, err := strconv.Atoi(strings.TrimPrefix(suffix, "."))
+	if err != nil || !strings.HasPrefix(suffix, ".") || seq < 1 {
+		return time.Time{}, 0, false
+	}
+	return rotatedAt, seq, true
+}
+
+// millBackups removes the rotated files beyond the maximum count and age and
+// compresses the others, if configured. Failures are reported on stderr, as
+// the log itself may be what failed.
+func (r *RotatingFile) millBackups() {
+	r.mill.Lock()
+	defer r.mill.Unlock()
+
+	backups, err := r.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger: failed to list rotated log files: %v\n", err)
+		return
+	}
+	cutoff := time.Now().Add(-r.maxAge)
+	for i, b := range backups {
+		if (r.maxBackups > 0 && i >= r.maxBackups) || (r.maxAge > 0 && b.rotatedAt.Before(cutoff)) {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "logger: failed to remove rotated log file: %v\n", err)
+			}
+			continue
+		}
+		if r.compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compressFile(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "logger: failed to compress rotated log file: %v\n", err)
+			}
+		}
+	}
+}
+
+// compressFile replaces a file with its gzipped copy, named with .gz appended
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
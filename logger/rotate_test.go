@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateWithinMillisecond(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRotatingFile(filepath.Join(dir, "agent.log"), 0, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Rotations this fast share milliseconds, yet each keeps its own file
+	const rotations = 20
+	for i := 0; i < rotations; i++ {
+		if _, err := fmt.Fprintf(r, "entry %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.wg.Wait()
+
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != rotations {
+		t.Fatalf("backups = %d, want %d", len(backups), rotations)
+	}
+	for i, b := range backups {
+		content, err := os.ReadFile(b.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Backups are listed newest first
+		if want := fmt.Sprintf("entry %d\n", rotations-1-i); string(content) != want {
+			t.Errorf("backup %s = %q, want %q", filepath.Base(b.path), content, want)
+		}
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	rotatedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		existing []string
+		want     string
+	}{
+		{name: "first", want: "agent-2024-05-01T10-00-00.000.log"},
+		{
+			name:     "taken",
+			existing: []string{"agent-2024-05-01T10-00-00.000.log"},
+			want:     "agent-2024-05-01T10-00-00.000.1.log",
+		},
+		{
+			name:     "compressed",
+			existing: []string{"agent-2024-05-01T10-00-00.000.log.gz", "agent-2024-05-01T10-00-00.000.1.log"},
+			want:     "agent-2024-05-01T10-00-00.000.2.log",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &RotatingFile{path: filepath.Join(dir, "agent.log")}
+			if got := filepath.Base(r.backupName(rotatedAt)); got != tt.want {
+				t.Errorf("backupName = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackups(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"agent-2024-05-01T10-00-00.000.log",
+		"agent-2024-05-01T10-00-00.000.2.log.gz",
+		"agent-2024-05-01T10-00-00.000.1.log",
+		"agent-2024-05-02T09-00-00.000.log",
+		"agent-2024-05-01T10-00-00.000.x.log",
+		"agent-2024-05-01T10-00-00.000.0.log",
+		"agent-notes.log",
+		"agent.log",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &RotatingFile{path: filepath.Join(dir, "agent.log")}
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range backups {
+		got = append(got, filepath.Base(b.path))
+	}
+	want := []string{
+		"agent-2024-05-02T09-00-00.000.log",
+		"agent-2024-05-01T10-00-00.000.2.log.gz",
+		"agent-2024-05-01T10-00-00.000.1.log",
+		"agent-2024-05-01T10-00-00.000.log",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("backups = %v, want %v", got, want)
+	}
+
+	// Keeping three removes the oldest
+	r.maxBackups = 3
+	r.millBackups()
+	for i, name := range want {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != (i < 3) {
+			t.Errorf("%s exists = %v, want %v", name, exists, i < 3)
+		}
+	}
+}
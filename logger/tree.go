@@ -3,6 +3,7 @@ package logger
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -70,9 +71,17 @@ func (f *TreeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	b.WriteString(entry.Message)
 	b.WriteString("\n")
 
-	// Sort fields for consistent output
+	// Sort fields for consistent output, with the caller among them if shown
+	values := entry.Data
+	if f.ShowCaller && entry.HasCaller() {
+		values = make(logrus.Fields, len(entry.Data)+1)
+		for field, value := range entry.Data {
+			values[field] = value
+		}
+		values[logrus.FieldKeyFile] = fmt.Sprintf("%s:%d", filepath.Base(entry.Caller.File), entry.Caller.Line)
+	}
 	var fields []string
-	for field := range entry.Data {
+	for field := range values {
 		fields = append(fields, field)
 	}
 	sort.Strings(fields)
@@ -85,7 +94,7 @@ func (f *TreeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 			prefix = treeLastPrefix
 		}
 
-		value := entry.Data[field]
+		value := values[field]
 		b.WriteString(prefix)
 		b.WriteString(fmt.Sprintf("%s: %v\n", field, value))
 	}
@@ -0,0 +1,33 @@
+package manager
+
+// Capability names a pipeline phase a manager takes part in
+type Capability string
+
+const (
+	CapabilityProcess     Capability = "process"
+	CapabilityPostProcess Capability = "post_process"
+	CapabilityContext     Capability = "context"
+	CapabilityBackground  Capability = "background"
+)
+
+// CapabilityProvider is implemented by managers that only take part in some
+// phases. The engine skips a manager in the phases it doesn't list instead of
+// calling its no-op methods. Managers without it take part in every phase.
+type CapabilityProvider interface {
+	Capabilities() []Capability
+}
+
+// Supports reports whether a manager takes part in the phase of a capability
+// Managers wrapped by middleware are checked through to the original manager
+func Supports(m Manager, capability Capability) bool {
+	provider, ok := Unwrap(m).(CapabilityProvider)
+	if !ok {
+		return true
+	}
+	for _, c := range provider.Capabilities() {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
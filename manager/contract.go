@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/velumlabs/thor/state"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// ErrContractViolation is returned when state data doesn't match the contract
+// of a manager providing or requiring it
+var ErrContractViolation = errors.New("state data contract violated")
+
+// StateDataContract describes a state data key a manager provides or requires,
+// and the shape of its value. Type pins the Go type values must be assignable
+// to; Schema describes the value's JSON encoding instead, for consumers that
+// only rely on its shape, such as templates. A contract setting neither only
+// requires the key to exist. Nil values satisfy every contract.
+type StateDataContract struct {
+	Key    state.StateDataKey
+	Type   reflect.Type
+	Schema *jsonschema.Definition
+}
+
+// ContractProvider is implemented by managers declaring the state data keys
+// their Context returns. The engine checks the returned values against the
+// declared contracts.
+type ContractProvider interface {
+	ProvidedKeys() []StateDataContract
+}
+
+// ContractRequirer is implemented by managers relying on state data keys of
+// other managers, e.g. in their Context or in the templates of their prompts.
+// The engine refuses to register a manager whose required keys no manager
+// provides, or provides with an incompatible type.
+type ContractRequirer interface {
+	RequiredKeys() []StateDataContract
+}
+
+// TypedContract returns the contract of a key holding values of type T:
+//
+//	func (m *MemoryManager) ProvidedKeys() []manager.StateDataContract {
+//		return []manager.StateDataContract{
+//			manager.TypedContract[[]db.Fragment](RecentInteractionsKey),
+//		}
+//	}
+func TypedContract[T any](key state.StateDataKey) StateDataContract {
+	return StateDataContract{
+		Key:  key,
+		Type: reflect.TypeOf((*T)(nil)).Elem(),
+	}
+}
+
+// SchemaContract returns the contract of a key whose values encode to JSON
+// matching schema
+func SchemaContract(key state.StateDataKey, schema jsonschema.Definition) StateDataContract {
+	return StateDataContract{
+		Key:    key,
+		Schema: &schema,
+	}
+}
+
+// ProvidedKeys returns the contracts a manager provides, checked through to
+// the original manager of one wrapped by middleware
+func ProvidedKeys(m Manager) []StateDataContract {
+	if provider, ok := Unwrap(m).(ContractProvider); ok {
+		return provider.ProvidedKeys()
+	}
+	return nil
+}
+
+// RequiredKeys returns the contracts a manager requires, checked through to
+// the original manager of one wrapped by middleware
+func RequiredKeys(m Manager) []StateDataContract {
+	if requirer, ok := Unwrap(m).(ContractRequirer); ok {
+		return requirer.RequiredKeys()
+	}
+	return nil
+}
+
+// Check returns an error wrapping ErrContractViolation if value doesn't
+// satisfy the contract
+func (c StateDataContract) Check(value interface{}) error {
+	if isNil(value) {
+		return nil
+	}
+
+	if c.Type != nil && !reflect.TypeOf(value).AssignableTo(c.Type) {
+		return fmt.Errorf("%w: %s holds a %T, not a %s", ErrContractViolation, c.Key, value, c.Type)
+	}
+
+	if c.Schema != nil {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s can't be encoded to JSON: %v", ErrContractViolation, c.Key, err)
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			return fmt.Errorf("%w: %s can't be decoded from JSON: %v", ErrContractViolation, c.Key, err)
+		}
+		if !jsonschema.Validate(*c.Schema, decoded) {
+			return fmt.Errorf("%w: %s holds a %T not matching its schema", ErrContractViolation, c.Key, value)
+		}
+	}
+
+	return nil
+}
+
+// Satisfies returns an error wrapping ErrContractViolation if values of the
+// provided contract can't satisfy the required one. Only types are compared;
+// schemas are checked against the values at runtime.
+func (c StateDataContract) Satisfies(required StateDataContract) error {
+	if c.Type != nil && required.Type != nil && !c.Type.AssignableTo(required.Type) {
+		return fmt.Errorf("%w: %s is provided as a %s, not a %s", ErrContractViolation, c.Key, c.Type, required.Type)
+	}
+	return nil
+}
+
+// String describes the contract's value, for error messages
+func (c StateDataContract) String() string {
+	switch {
+	case c.Type != nil:
+		return fmt.Sprintf("%s (%s)", c.Key, c.Type)
+	case c.Schema != nil:
+		return fmt.Sprintf("%s (%s schema)", c.Key, c.Schema.Type)
+	default:
+		return string(c.Key)
+	}
+}
+
+// isNil reports whether a value is nil or a nil pointer, slice, map, channel,
+// function or interface
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
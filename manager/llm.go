@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"github.com/velumlabs/thor/llm"
+)
+
+// LLMCallKind names the kind of request an LLM call made
+type LLMCallKind string
+
+const (
+	LLMCallCompletion       LLMCallKind = "completion"
+	LLMCallStructuredOutput LLMCallKind = "structured_output"
+)
+
+// LLMCall describes an LLM request a manager made through its helpers
+type LLMCall struct {
+	Kind      LLMCallKind
+	ModelType llm.ModelType
+	Duration  time.Duration
+	Usage     llm.Usage // Zero for structured outputs, whose usage isn't reported
+	Err       error
+}
+
+// LLMRecorder receives the LLM calls of a manager
+type LLMRecorder interface {
+	RecordLLMCall(call LLMCall)
+}
+
+// LLMRecorderReceiver is implemented by managers that report their LLM calls
+// The engine injects a recorder attributing the calls to the manager when the
+// manager is registered. BaseManager implements it.
+type LLMRecorderReceiver interface {
+	SetLLMRecorder(recorder LLMRecorder)
+}
+
+// SetLLMRecorder sets the recorder the LLM helpers report calls to
+func (bm *BaseManager) SetLLMRecorder(recorder LLMRecorder) {
+	bm.llmRecorderMu.Lock()
+	defer bm.llmRecorderMu.Unlock()
+	bm.llmRecorder = recorder
+}
+
+// GenerateCompletion generates a completion with the manager's LLM client under
+// the manager's context. The manager's default model type and temperature are
+// used unless the request sets its own, and the call is reported to the engine.
+func (bm *BaseManager) GenerateCompletion(req llm.CompletionRequest) (llm.Message, error) {
+	return bm.GenerateCompletionContext(bm.Ctx, req)
+}
+
+// GenerateCompletionContext is GenerateCompletion under ctx, e.g. the context
+// Process is called with
+func (bm *BaseManager) GenerateCompletionContext(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+	req.ModelType, req.Temperature, req.TemperatureSet = bm.llmDefaults(req.ModelType, req.Temperature, req.TemperatureSet)
+
+	start := time.Now()
+	response, err := bm.LLM.WithContext(ctx).GenerateCompletion(req)
+	bm.recordLLMCall(LLMCall{
+		Kind:      LLMCallCompletion,
+		ModelType: req.ModelType,
+		Duration:  time.Since(start),
+		Usage:     response.Usage,
+		Err:       err,
+	})
+	return response, err
+}
+
+// GenerateStructuredOutput generates a structured output into result with the
+// manager's LLM client under the manager's context, with the same defaults and
+// reporting as GenerateCompletion
+func (bm *BaseManager) GenerateStructuredOutput(req llm.StructuredOutputRequest, result interface{}) error {
+	return bm.GenerateStructuredOutputContext(bm.Ctx, req, result)
+}
+
+// GenerateStructuredOutputContext is GenerateStructuredOutput under ctx
+func (bm *BaseManager) GenerateStructuredOutputContext(ctx context.Context, req llm.StructuredOutputRequest, result interface{}) error {
+	req.ModelType, req.Temperature, req.TemperatureSet = bm.llmDefaults(req.ModelType, req.Temperature, req.TemperatureSet)
+
+	start := time.Now()
+	err := bm.LLM.WithContext(ctx).GenerateStructuredOutput(req, result)
+	bm.recordLLMCall(LLMCall{
+		Kind:      LLMCallStructuredOutput,
+		ModelType: req.ModelType,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+	return err
+}
+
+// llmDefaults fills in the manager's default model type and temperature. A
+// zero temperature counts as unset unless set says otherwise.
+func (bm *BaseManager) llmDefaults(modelType llm.ModelType, temperature float32, set bool) (llm.ModelType, float32, bool) {
+	if modelType == "" {
+		modelType = bm.defaultModelType
+	}
+	if temperature == 0 && !set && bm.defaultTemperature != nil {
+		temperature, set = *bm.defaultTemperature, true
+	}
+	return modelType, temperature, set
+}
+
+// recordLLMCall reports a call to the recorder, if one is set
+func (bm *BaseManager) recordLLMCall(call LLMCall) {
+	bm.llmRecorderMu.RLock()
+	recorder := bm.llmRecorder
+	bm.llmRecorderMu.RUnlock()
+
+	if recorder != nil {
+		recorder.RecordLLMCall(call)
+	}
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/velumlabs/thor/llm"
+)
+
+func TestLLMDefaultTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		// defaultTemperature is the manager's default, none if nil
+		defaultTemperature *float32
+		temperature        float32
+		set                bool
+		want               float32
+		wantSet            bool
+	}{
+		{name: "no default", want: 0},
+		{name: "default", defaultTemperature: ptr(float32(0.7)), want: 0.7, wantSet: true},
+		{name: "request temperature", defaultTemperature: ptr(float32(0.7)), temperature: 0.2, want: 0.2},
+		{name: "explicit zero", defaultTemperature: ptr(float32(0.7)), set: true, want: 0, wantSet: true},
+		{name: "default zero", defaultTemperature: ptr(float32(0)), want: 0, wantSet: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := llm.NewMockProvider()
+			bm := &BaseManager{
+				Ctx: context.Background(),
+				LLM: llm.NewLLMClientFromProvider(provider, llm.Config{Logger: newTestLogger(t), Context: context.Background()}),
+			}
+			if tt.defaultTemperature != nil {
+				if err := WithDefaultTemperature(*tt.defaultTemperature)(bm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := bm.GenerateCompletion(llm.CompletionRequest{
+				Messages:       []llm.Message{{Role: llm.RoleUser, Content: "hello"}},
+				Temperature:    tt.temperature,
+				TemperatureSet: tt.set,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			var result struct{}
+			if err := bm.GenerateStructuredOutput(llm.StructuredOutputRequest{
+				Messages:       []llm.Message{{Role: llm.RoleUser, Content: "hello"}},
+				Temperature:    tt.temperature,
+				TemperatureSet: tt.set,
+			}, &result); err != nil {
+				t.Fatal(err)
+			}
+
+			completion := provider.CompletionRequests()[0]
+			if completion.Temperature != tt.want || completion.TemperatureSet != tt.wantSet {
+				t.Errorf("completion temperature = %v (set %v), want %v (set %v)",
+					completion.Temperature, completion.TemperatureSet, tt.want, tt.wantSet)
+			}
+			structured := provider.StructuredOutputRequests()[0]
+			if structured.Temperature != tt.want || structured.TemperatureSet != tt.wantSet {
+				t.Errorf("structured output temperature = %v (set %v), want %v (set %v)",
+					structured.Temperature, structured.TemperatureSet, tt.want, tt.wantSet)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
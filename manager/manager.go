@@ -1,10 +1,14 @@
 package manager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/events"
+	"github.com/velumlabs/thor/id"
 	"github.com/velumlabs/thor/state"
 
 	"github.com/velumlabs/thor/cache"
@@ -21,22 +25,25 @@ func (bm *BaseManager) GetDependencies() []ManagerID {
 	return []ManagerID{}
 }
 
-// Process provides a default implementation that panics
+// Process provides a default implementation that does nothing
 // Managers should override this method with their specific analysis logic
-func (bm *BaseManager) Process(state *state.State) error {
-	panic("Process not implemented")
+func (bm *BaseManager) Process(ctx context.Context, state *state.State) error {
+	bm.debugDefault("Process")
+	return nil
 }
 
-// PostProcess provides a default implementation that panics
+// PostProcess provides a default implementation that does nothing
 // Managers should override this method with their specific post-processing logic
 func (bm *BaseManager) PostProcess(state *state.State) error {
-	panic("PostProcess not implemented")
+	bm.debugDefault("PostProcess")
+	return nil
 }
 
-// Context provides a default implementation that panics
+// Context provides a default implementation that returns no context data
 // Managers should override this method to provide their specific context data
 func (bm *BaseManager) Context(state *state.State) ([]state.StateData, error) {
-	panic("Context not implemented")
+	bm.debugDefault("Context")
+	return nil, nil
 }
 
 // Store persists a fragment to the fragment store
@@ -44,44 +51,323 @@ func (bm *BaseManager) Store(fragment *db.Fragment) error {
 	return bm.FragmentStore.Create(fragment)
 }
 
-// StartBackgroundProcesses provides a default implementation that panics
-// Managers should override this method if they need background processing
+// StartBackgroundProcesses starts the tasks registered with RunPeriodic
+// Managers should override this method if they need other background processing
 func (bm *BaseManager) StartBackgroundProcesses() {
-	panic("StartBackgroundProcesses not implemented")
+	if !bm.startPeriodicTasks() {
+		bm.debugDefault("StartBackgroundProcesses")
+	}
 }
 
-// StopBackgroundProcesses provides a default implementation that panics
-// Managers should override this method if they need to clean up background processes
+// StopBackgroundProcesses stops the tasks registered with RunPeriodic and waits for them
+// Managers should override this method if they need to clean up other background processes
 func (bm *BaseManager) StopBackgroundProcesses() {
-	panic("StopBackgroundProcesses not implemented")
+	if !bm.stopPeriodicTasks() {
+		bm.debugDefault("StopBackgroundProcesses")
+	}
+}
+
+// debugDefault logs that a manager relied on a default no-op method
+func (bm *BaseManager) debugDefault(method string) {
+	if bm.Logger != nil {
+		bm.Logger.WithField("method", method).Debug("Manager does not override method, skipping")
+	}
 }
 
-// RegisterEventHandler sets the event handler callback for this manager
+// RegisterEventHandler sets the event handler callback for this manager, called
+// for events of every type; see RegisterEventHandlerFor for a single type.
+// The callback runs on a dispatcher goroutine, in the order events were
+// triggered, so a slow handler doesn't block the manager. The dispatcher runs
+// until CloseEvents, which the engine calls when it shuts down.
 func (bm *BaseManager) RegisterEventHandler(callback EventCallbackFunc) {
+	bm.eventMu.Lock()
+	defer bm.eventMu.Unlock()
+
 	bm.eventHandler = callback
+	bm.startDispatcher()
 }
 
-// triggerEvent sends an event to the registered handler
-// Panics if no handler is registered
-func (bm *BaseManager) triggerEvent(eventData EventData) {
+// RegisterEventHandlerFor adds a handler called for the events of eventType
+// only, or of every type for events.Wildcard, next to the handler set with
+// RegisterEventHandler. Events that aren't an events.Event have the type
+// events.EventManager. Handlers run on the same dispatcher, in the order they
+// were registered. Returns a function that removes the handler.
+func (bm *BaseManager) RegisterEventHandlerFor(eventType events.EventType, callback EventCallbackFunc) func() {
+	bm.eventMu.Lock()
+	defer bm.eventMu.Unlock()
+
+	if bm.typedHandlers == nil {
+		bm.typedHandlers = make(map[events.EventType][]typedEventHandler)
+	}
+	handlerID := bm.nextHandlerID
+	bm.nextHandlerID++
+	bm.typedHandlers[eventType] = append(bm.typedHandlers[eventType], typedEventHandler{id: handlerID, callback: callback})
+	bm.startDispatcher()
+
+	return func() {
+		bm.eventMu.Lock()
+		defer bm.eventMu.Unlock()
+
+		handlers := bm.typedHandlers[eventType]
+		for i, handler := range handlers {
+			if handler.id == handlerID {
+				bm.typedHandlers[eventType] = append(handlers[:i:i], handlers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// startDispatcher starts the dispatcher running the event handlers, unless it
+// runs already. Without a logger, handlers run synchronously. Must be called
+// with eventMu held.
+func (bm *BaseManager) startDispatcher() {
+	if bm.handlerBus != nil || bm.Logger == nil {
+		return
+	}
+
+	bus, err := events.NewBus(
+		events.WithLogger(bm.Logger),
+		events.WithWorkers(1),
+		events.WithDeliveryPolicy(bm.eventDelivery),
+	)
+	if err != nil {
+		bm.Logger.WithError(err).Warn("Failed to start event dispatcher, events are handled synchronously")
+		return
+	}
+	bus.Subscribe(events.Wildcard, func(event events.Event) error {
+		for _, handler := range bm.handlers(event.Type) {
+			handler(event.Data)
+		}
+		return nil
+	})
+	bm.handlerBus = bus
+}
+
+// CloseEvents delivers the events queued for the registered handler and stops
+// its dispatcher, or returns an error if ctx is done first. Events triggered
+// afterwards are passed to the handler synchronously.
+func (bm *BaseManager) CloseEvents(ctx context.Context) error {
+	bm.eventMu.Lock()
+	bus := bm.handlerBus
+	bm.handlerBus = nil
+	bm.eventMu.Unlock()
+
+	if bus == nil {
+		return nil
+	}
+	return bus.Close(ctx)
+}
+
+// handlers returns the registered handlers of an event type, the handler set
+// with RegisterEventHandler first
+func (bm *BaseManager) handlers(eventType events.EventType) []EventCallbackFunc {
+	bm.eventMu.RLock()
+	defer bm.eventMu.RUnlock()
+
+	var handlers []EventCallbackFunc
 	if bm.eventHandler != nil {
-		bm.eventHandler(eventData)
-	} else {
-		panic("No event handler registered")
+		handlers = append(handlers, bm.eventHandler)
+	}
+	for _, handler := range bm.typedHandlers[eventType] {
+		handlers = append(handlers, handler.callback)
+	}
+	if eventType != events.Wildcard {
+		for _, handler := range bm.typedHandlers[events.Wildcard] {
+			handlers = append(handlers, handler.callback)
+		}
+	}
+	return handlers
+}
+
+// dispatch runs the handlers of an event synchronously
+func (bm *BaseManager) dispatch(eventType events.EventType, eventData EventData) {
+	for _, handler := range bm.handlers(eventType) {
+		handler(eventData)
+	}
+}
+
+// EnableDryRun replaces the manager's stores with copies whose writes are no-ops,
+// so Store and any other store writes don't persist anything
+func (bm *BaseManager) EnableDryRun() {
+	if bm.FragmentStore != nil {
+		bm.FragmentStore = bm.FragmentStore.DryRun()
+	}
+	if bm.InteractionFragmentStore != nil {
+		bm.InteractionFragmentStore = bm.InteractionFragmentStore.DryRun()
+	}
+	if bm.ActorStore != nil {
+		bm.ActorStore = bm.ActorStore.DryRun()
+	}
+	if bm.SessionStore != nil {
+		bm.SessionStore = bm.SessionStore.DryRun()
+	}
+}
+
+// ScopeToTenant replaces the manager's stores with copies scoped to a tenant,
+// so they only read and write the tenant's records
+func (bm *BaseManager) ScopeToTenant(tenantID id.ID) {
+	if bm.FragmentStore != nil {
+		bm.FragmentStore = bm.FragmentStore.WithTenant(tenantID)
+	}
+	if bm.InteractionFragmentStore != nil {
+		bm.InteractionFragmentStore = bm.InteractionFragmentStore.WithTenant(tenantID)
+	}
+	if bm.ActorStore != nil {
+		bm.ActorStore = bm.ActorStore.WithTenant(tenantID)
+	}
+	if bm.SessionStore != nil {
+		bm.SessionStore = bm.SessionStore.WithTenant(tenantID)
 	}
 }
 
+// Emit publishes an event to the event bus and queues it for the registered
+// handler without waiting for either. Use events.New for typed payloads.
+func (bm *BaseManager) Emit(event events.Event) {
+	bm.triggerEvent(event)
+}
+
+// PublishEvent triggers a typed event with the given payload
+func (bm *BaseManager) PublishEvent(eventType events.EventType, payload map[string]interface{}) {
+	bm.triggerEvent(events.Event{
+		Type:    eventType,
+		Payload: payload,
+	})
+}
+
+// triggerEvent publishes an event to the event bus, if configured, and sends it
+// to the registered handler.
+// The event is dropped with a warning if neither an event bus nor a handler is configured
+func (bm *BaseManager) triggerEvent(eventData EventData) {
+	eventType := events.EventManager
+	if event, ok := eventData.(events.Event); ok {
+		eventType = event.Type
+	}
+
+	bm.eventMu.RLock()
+	handled, handlerBus := bm.eventHandler != nil || len(bm.typedHandlers) > 0, bm.handlerBus
+	bm.eventMu.RUnlock()
+
+	if bm.EventBus == nil && !handled {
+		if bm.Logger != nil {
+			bm.Logger.Warn("No event bus or handler registered, dropping event")
+		}
+		return
+	}
+
+	if bm.EventBus != nil {
+		event, ok := eventData.(events.Event)
+		if !ok {
+			event = events.Event{
+				Type:    events.EventManager,
+				Payload: map[string]interface{}{"data": eventData},
+				Data:    eventData,
+			}
+		}
+		if err := bm.EventBus.Publish(event); err != nil && bm.Logger != nil {
+			bm.Logger.WithError(err).WithField("event", event.Type).Warn("Failed to publish event")
+		}
+	}
+
+	if !handled {
+		return
+	}
+	if handlerBus == nil {
+		bm.dispatch(eventType, eventData)
+		return
+	}
+
+	err := handlerBus.Publish(events.Event{Type: eventType, Data: eventData})
+	if errors.Is(err, events.ErrBusClosed) {
+		// Closed since it was read, see CloseEvents
+		bm.dispatch(eventType, eventData)
+		return
+	}
+	if err != nil && bm.Logger != nil {
+		bm.Logger.WithError(err).WithField("event", eventType).Warn("Failed to queue event for handler")
+	}
+}
+
+// defaultCacheConfig configures the manager's own cache when WithCacheConfig isn't used
+var defaultCacheConfig = cache.Config{
+	MaxSize:       1000,
+	TTL:           15 * time.Minute,
+	CleanupPeriod: 30 * time.Minute,
+}
+
 // NewBaseManager creates a new BaseManager instance with the provided options
+// Without a shared cache the manager gets its own cache, sized by WithCacheConfig
+// or the default configuration
 func NewBaseManager(opts ...options.Option[BaseManager]) (*BaseManager, error) {
-	bm := &BaseManager{
-		Cache: cache.New(cache.Config{
-			MaxSize:       1000,
-			TTL:           15 * time.Minute,
-			CleanupPeriod: 30 * time.Minute,
-		}),
-	}
+	bm := &BaseManager{}
 	if err := options.ApplyOptions(bm, opts...); err != nil {
 		return nil, fmt.Errorf("failed to create base manager: %w", err)
 	}
+	if bm.Cache == nil {
+		config := defaultCacheConfig
+		if bm.cacheConfig != nil {
+			config = *bm.cacheConfig
+		}
+		bm.Cache = cache.New(config)
+	}
 	return bm, nil
 }
+
+// GetCacheStats returns the statistics of the manager's cache
+// A shared cache reports the statistics of all its users
+func (bm *BaseManager) GetCacheStats() cache.CacheStats {
+	if bm.Cache == nil {
+		return cache.CacheStats{}
+	}
+	return bm.Cache.GetStats()
+}
+
+// StartBackground runs fn in a goroutine under a context derived from the manager's
+// context. It lets managers implement StartBackgroundProcesses as a single call
+// around their background loop. Calling it while a loop is running is a no-op.
+func (bm *BaseManager) StartBackground(fn func(ctx context.Context) error) {
+	bm.backgroundMu.Lock()
+	defer bm.backgroundMu.Unlock()
+
+	if bm.backgroundCancel != nil {
+		return
+	}
+
+	parent := bm.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+
+	bm.backgroundCancel = cancel
+	bm.backgroundDone = done
+
+	go func() {
+		defer close(done)
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			bm.Logger.WithError(err).Error("Background process failed")
+		}
+	}()
+}
+
+// StopBackground cancels the loop started with StartBackground and waits for it
+// to return. Returns an error if it doesn't stop before ctx is done.
+func (bm *BaseManager) StopBackground(ctx context.Context) error {
+	bm.backgroundMu.Lock()
+	cancel, done := bm.backgroundCancel, bm.backgroundDone
+	bm.backgroundCancel, bm.backgroundDone = nil, nil
+	bm.backgroundMu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background process did not stop: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/events"
+	"github.com/velumlabs/thor/logger"
+)
+
+// newTestLogger returns a logger writing nowhere
+func newTestLogger(t testing.TB) *logger.Logger {
+	t.Helper()
+	log, err := logger.New(&logger.Config{Level: "debug", Output: io.Discard})
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	return log
+}
+
+// waitForGoroutines waits until at most n goroutines run
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines still running, want at most %d", runtime.NumGoroutine(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger bool
+		events int
+	}{
+		{name: "dispatched", logger: true, events: 10},
+		{name: "synchronous without logger", events: 10},
+		{name: "no events", logger: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline := runtime.NumGoroutine()
+
+			bm := &BaseManager{}
+			if tt.logger {
+				bm.Logger = newTestLogger(t)
+			}
+
+			var mu sync.Mutex
+			var received []EventData
+			bm.RegisterEventHandler(func(eventData EventData) {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, eventData)
+			})
+			bm.RegisterEventHandler(func(eventData EventData) {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, eventData)
+			})
+
+			for i := 0; i < tt.events; i++ {
+				bm.Emit(events.Event{Type: events.EventManager, Payload: map[string]interface{}{"i": i}})
+			}
+			if err := bm.CloseEvents(context.Background()); err != nil {
+				t.Fatalf("CloseEvents failed: %v", err)
+			}
+
+			mu.Lock()
+			if len(received) != tt.events {
+				t.Errorf("handler received %d events, want %d", len(received), tt.events)
+			}
+			for i, eventData := range received {
+				if got := eventData.(events.Event).Payload["i"]; got != i {
+					t.Errorf("event %d has payload %v, out of order", i, got)
+				}
+			}
+			mu.Unlock()
+
+			// Events after closing reach the handler synchronously
+			bm.Emit(events.Event{Type: events.EventManager})
+			mu.Lock()
+			if len(received) != tt.events+1 {
+				t.Errorf("handler received %d events after closing, want %d", len(received), tt.events+1)
+			}
+			mu.Unlock()
+
+			waitForGoroutines(t, baseline)
+		})
+	}
+}
+
+func TestEmitWithSlowHandler(t *testing.T) {
+	bm := &BaseManager{Logger: newTestLogger(t)}
+
+	release := make(chan struct{})
+	bm.RegisterEventHandler(func(EventData) {
+		<-release
+	})
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		bm.Emit(events.Event{Type: events.EventManager})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Emit took %s with a blocked handler", elapsed)
+	}
+
+	close(release)
+	if err := bm.CloseEvents(context.Background()); err != nil {
+		t.Fatalf("CloseEvents failed: %v", err)
+	}
+}
+
+func TestRegisterEventHandlerFor(t *testing.T) {
+	const insightEvent events.EventType = "insight_created"
+	emitted := []EventData{
+		events.Event{Type: events.EventInputProcessed},
+		events.Event{Type: insightEvent},
+		"raw data",
+		events.Event{Type: events.EventInputProcessed},
+	}
+
+	tests := []struct {
+		name   string
+		logger bool
+		// eventType is the type the handler is registered for
+		eventType events.EventType
+		// removed removes the handler before the last event
+		removed bool
+		// want are the indexes of the emitted events the handler receives
+		want []int
+	}{
+		{name: "type", logger: true, eventType: events.EventInputProcessed, want: []int{0, 3}},
+		{name: "other type", logger: true, eventType: insightEvent, want: []int{1}},
+		{name: "data without type", logger: true, eventType: events.EventManager, want: []int{2}},
+		{name: "wildcard", logger: true, eventType: events.Wildcard, want: []int{0, 1, 2, 3}},
+		{name: "unknown type", logger: true, eventType: "unknown"},
+		{name: "removed", logger: true, eventType: events.EventInputProcessed, removed: true, want: []int{0}},
+		{name: "synchronous without logger", eventType: events.EventInputProcessed, want: []int{0, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := &BaseManager{}
+			if tt.logger {
+				bm.Logger = newTestLogger(t)
+			}
+
+			var mu sync.Mutex
+			var typed, all []EventData
+			bm.RegisterEventHandler(func(eventData EventData) {
+				mu.Lock()
+				defer mu.Unlock()
+				all = append(all, eventData)
+			})
+			remove := bm.RegisterEventHandlerFor(tt.eventType, func(eventData EventData) {
+				mu.Lock()
+				defer mu.Unlock()
+				typed = append(typed, eventData)
+			})
+
+			for i, eventData := range emitted {
+				if tt.removed && i == len(emitted)-1 {
+					// Wait for the queued events so the removal follows them
+					if err := bm.CloseEvents(context.Background()); err != nil {
+						t.Fatal(err)
+					}
+					remove()
+				}
+				bm.triggerEvent(eventData)
+			}
+			if err := bm.CloseEvents(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			var want []EventData
+			for _, i := range tt.want {
+				want = append(want, emitted[i])
+			}
+			if fmt.Sprint(typed) != fmt.Sprint(want) {
+				t.Errorf("typed handler received %v, want %v", typed, want)
+			}
+			// The handler for every type still receives them all
+			if len(all) != len(emitted) {
+				t.Errorf("handler of every type received %d events, want %d", len(all), len(emitted))
+			}
+		})
+	}
+}
+
+func TestRegisterEventHandlerForOrder(t *testing.T) {
+	bm := &BaseManager{Logger: newTestLogger(t)}
+
+	// Handlers of one event run in turn, in the order they were registered
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) EventCallbackFunc {
+		return func(EventData) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name)
+		}
+	}
+	bm.RegisterEventHandlerFor(events.Wildcard, record("wildcard"))
+	bm.RegisterEventHandlerFor(events.EventManager, record("first"))
+	bm.RegisterEventHandler(record("all"))
+	bm.RegisterEventHandlerFor(events.EventManager, record("second"))
+
+	bm.Emit(events.Event{Type: events.EventManager})
+	if err := bm.CloseEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(calls), "[all first second wildcard]"; got != want {
+		t.Errorf("handlers called in order %s, want %s", got, want)
+	}
+}
+
+func TestTriggerEventWithoutLogger(t *testing.T) {
+	log := newTestLogger(t)
+	closed, err := events.NewBus(events.WithLogger(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		bm   *BaseManager
+	}{
+		{name: "no bus or handler", bm: &BaseManager{}},
+		{name: "failing bus", bm: &BaseManager{EventBus: closed}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Must not dereference the missing logger
+			tt.bm.PublishEvent(events.EventManager, map[string]interface{}{"key": "value"})
+		})
+	}
+}
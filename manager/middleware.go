@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/velumlabs/thor/logger"
+	"github.com/velumlabs/thor/state"
+)
+
+// ErrManagerPanic is returned by managers wrapped with RecoveryMiddleware when they panic
+var ErrManagerPanic = errors.New("manager panicked")
+
+// Phase is a pipeline phase a middleware wraps
+type Phase string
+
+const (
+	PhaseProcess     Phase = "process"
+	PhasePostProcess Phase = "post_process"
+)
+
+// Middleware wraps a manager to add cross-cutting behavior such as logging,
+// metrics or tracing without changing the manager itself
+type Middleware func(Manager) Manager
+
+// PhaseFunc runs around a phase of a wrapped manager. It must call next to run
+// the manager's own implementation, and returns the phase's error.
+type PhaseFunc func(m Manager, phase Phase, state *state.State, next func() error) error
+
+// Unwrapper is implemented by managers wrapping another manager
+type Unwrapper interface {
+	Unwrap() Manager
+}
+
+// Unwrap returns the innermost manager below any middleware, so optional
+// interfaces such as BackgroundRunner can be detected on the original manager
+func Unwrap(m Manager) Manager {
+	for {
+		wrapper, ok := m.(Unwrapper)
+		if !ok {
+			return m
+		}
+		m = wrapper.Unwrap()
+	}
+}
+
+// Chain applies middlewares to a manager; the first middleware is the outermost
+func Chain(m Manager, middlewares ...Middleware) Manager {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		m = middlewares[i](m)
+	}
+	return m
+}
+
+// WrapPhases returns a middleware running fn around the Process and PostProcess
+// calls of each manager. All other methods, including GetID and GetDependencies,
+// pass through to the wrapped manager.
+func WrapPhases(fn PhaseFunc) Middleware {
+	return func(m Manager) Manager {
+		return &phaseWrapper{Manager: m, fn: fn}
+	}
+}
+
+// phaseWrapper is a manager whose phases run through a PhaseFunc
+type phaseWrapper struct {
+	Manager
+	fn PhaseFunc
+}
+
+func (w *phaseWrapper) Process(ctx context.Context, s *state.State) error {
+	return w.fn(w.Manager, PhaseProcess, s, func() error {
+		return w.Manager.Process(ctx, s)
+	})
+}
+
+func (w *phaseWrapper) PostProcess(s *state.State) error {
+	return w.fn(w.Manager, PhasePostProcess, s, func() error {
+		return w.Manager.PostProcess(s)
+	})
+}
+
+func (w *phaseWrapper) Unwrap() Manager {
+	return w.Manager
+}
+
+// LoggingMiddleware logs the phase, manager ID, duration and error of every
+// Process and PostProcess call. Entries go through the redaction rules of log,
+// see logger.Logger.SetRedaction, so errors quoting prompts or keys are
+// redacted like any other entry.
+func LoggingMiddleware(log *logger.Logger) Middleware {
+	return WrapPhases(func(m Manager, phase Phase, s *state.State, next func() error) error {
+		start := time.Now()
+		err := next()
+
+		entry := log.WithFields(map[string]interface{}{
+			"manager":  m.GetID(),
+			"phase":    phase,
+			"duration": time.Since(start).String(),
+		})
+		if err != nil {
+			entry.WithError(err).Error("Manager phase failed")
+		} else {
+			entry.Info("Manager phase finished")
+		}
+		return err
+	})
+}
+
+// RecoveryMiddleware turns panics in Process and PostProcess into errors
+// wrapping ErrManagerPanic, so a faulty manager cannot crash the engine
+func RecoveryMiddleware() Middleware {
+	return WrapPhases(func(m Manager, phase Phase, s *state.State, next func() error) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%w: %s %s: %v\n%s", ErrManagerPanic, m.GetID(), phase, r, debug.Stack())
+			}
+		}()
+		return next()
+	})
+}
@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/logger"
+	"github.com/velumlabs/thor/state"
+)
+
+// failingManager is a manager whose phases fail with err
+type failingManager struct {
+	BaseManager
+	err error
+}
+
+func (m *failingManager) GetID() ManagerID {
+	return "failing"
+}
+
+func (m *failingManager) Process(ctx context.Context, s *state.State) error {
+	return m.err
+}
+
+func (m *failingManager) PostProcess(s *state.State) error {
+	panic("post process")
+}
+
+func TestLoggingMiddlewareRedacts(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   string
+		absent string
+	}{
+		{
+			name:   "error quoting a key",
+			err:    errors.New("provider rejected key sk-abcdef12"),
+			want:   "Manager phase failed",
+			absent: "sk-abcdef12",
+		},
+		{
+			name: "success",
+			want: "Manager phase finished",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log, err := logger.New(&logger.Config{
+				Level:     "info",
+				Output:    &out,
+				Redaction: &logger.RedactionRules{Patterns: []string{`sk-[a-z0-9]{8}`}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := Chain(&failingManager{err: tt.err}, LoggingMiddleware(log))
+			if err := m.Process(context.Background(), state.NewState()); !errors.Is(err, tt.err) {
+				t.Fatalf("Process returned %v, want %v", err, tt.err)
+			}
+
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("output %q lacks %q", out.String(), tt.want)
+			}
+			if tt.absent != "" && strings.Contains(out.String(), tt.absent) {
+				t.Errorf("output leaks %q: %s", tt.absent, out.String())
+			}
+		})
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	m := Chain(&failingManager{}, RecoveryMiddleware())
+	if err := m.PostProcess(state.NewState()); !errors.Is(err, ErrManagerPanic) {
+		t.Fatalf("PostProcess returned %v, want ErrManagerPanic", err)
+	}
+	if err := m.Process(context.Background(), state.NewState()); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if got := Unwrap(m).GetID(); got != "failing" {
+		t.Errorf("Unwrap returned %s", got)
+	}
+}
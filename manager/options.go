@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/events"
 	"github.com/velumlabs/thor/id"
 	"github.com/velumlabs/thor/llm"
 	"github.com/velumlabs/thor/logger"
@@ -118,3 +120,101 @@ func WithInteractionFragmentStore(store *stores.FragmentStore) options.Option[Ba
 		return nil
 	}
 }
+
+// WithEventBus sets the event bus for the manager
+// Triggered events are published to it for engine subscribers
+func WithEventBus(bus *events.Bus) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		m.EventBus = bus
+		return nil
+	}
+}
+
+// WithEventDelivery sets what happens to triggered events when the registered
+// event handler falls behind: dropping the newest or oldest queued events, or blocking
+func WithEventDelivery(policy events.DeliveryPolicy) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		switch policy {
+		case events.DropNewest, events.DropOldest, events.Block:
+			m.eventDelivery = policy
+			return nil
+		default:
+			return fmt.Errorf("unknown event delivery policy %d", policy)
+		}
+	}
+}
+
+// WithCache sets a shared cache for the manager
+// Used instead of a per-manager default cache, so it can be configured centrally
+func WithCache(c *cache.Cache) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		m.Cache = c
+		return nil
+	}
+}
+
+// WithSharedCache makes the manager use the given cache, such as the engine-wide one
+// It is an alias of WithCache
+func WithSharedCache(c *cache.Cache) options.Option[BaseManager] {
+	return WithCache(c)
+}
+
+// WithCacheConfig sizes the manager's own cache
+// A zero cleanup period runs no background cleanup, see cache.New
+// Ignored if a shared cache is set with WithCache or WithSharedCache
+func WithCacheConfig(config cache.Config) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		if config.MaxSize <= 0 {
+			return fmt.Errorf("cache max size must be positive")
+		}
+		if config.TTL <= 0 {
+			return fmt.Errorf("cache TTL must be positive")
+		}
+		if config.CleanupPeriod < 0 {
+			return fmt.Errorf("cache cleanup period must not be negative")
+		}
+		m.cacheConfig = &config
+		return nil
+	}
+}
+
+// WithDefaultModelType sets the model type of the manager's LLM requests that
+// don't specify one, when made through BaseManager.GenerateCompletion or
+// BaseManager.GenerateStructuredOutput
+func WithDefaultModelType(modelType llm.ModelType) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		switch modelType {
+		case llm.ModelTypeFast, llm.ModelTypeDefault, llm.ModelTypeAdvanced:
+			m.defaultModelType = modelType
+			return nil
+		default:
+			return fmt.Errorf("unknown model type %q", modelType)
+		}
+	}
+}
+
+// WithDefaultTemperature sets the temperature of the manager's LLM requests that
+// don't specify one, when made through BaseManager.GenerateCompletion or
+// BaseManager.GenerateStructuredOutput. A request specifies a zero temperature
+// with TemperatureSet.
+func WithDefaultTemperature(temperature float32) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		if temperature < 0 || temperature > 2 {
+			return fmt.Errorf("temperature must be between 0 and 2")
+		}
+		m.defaultTemperature = &temperature
+		return nil
+	}
+}
+
+// WithClock sets the clock driving Debounce and RateLimit
+// Used to control time in tests
+func WithClock(clock Clock) options.Option[BaseManager] {
+	return func(m *BaseManager) error {
+		if clock == nil {
+			return fmt.Errorf("clock is required")
+		}
+		m.throttleClock = clock
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package manager_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+)
+
+// newBaseManager returns a base manager of a test environment with opts
+func newBaseManager(t *testing.T, opts ...options.Option[manager.BaseManager]) (*manager.BaseManager, error) {
+	t.Helper()
+	env := managertest.NewTestEnvironment(t)
+	return manager.NewBaseManager(append(env.BaseOptions(), opts...)...)
+}
+
+func TestWithCacheConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config cache.Config
+		// want is part of the error expected, none if empty
+		want string
+	}{
+		{name: "cleanup", config: cache.Config{MaxSize: 10, TTL: time.Minute, CleanupPeriod: time.Minute}},
+		// A zero cleanup period runs no background cleanup
+		{name: "no cleanup", config: cache.Config{MaxSize: 10, TTL: time.Minute}},
+		{name: "no size", config: cache.Config{TTL: time.Minute}, want: "cache max size must be positive"},
+		{name: "no TTL", config: cache.Config{MaxSize: 10}, want: "cache TTL must be positive"},
+		{
+			name:   "negative cleanup period",
+			config: cache.Config{MaxSize: 10, TTL: time.Minute, CleanupPeriod: -time.Minute},
+			want:   "cache cleanup period must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := newBaseManager(t, manager.WithCacheConfig(tt.config))
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("error = %v, want %q", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bm.Cache.Close()
+
+			// The manager's own cache is sized by the config
+			for i := 0; i < tt.config.MaxSize+5; i++ {
+				bm.Cache.Set(cache.CacheKey(strings.Repeat("k", i+1)), i)
+			}
+			if size := bm.GetCacheStats().Size; size != tt.config.MaxSize {
+				t.Errorf("cache holds %d items, want %d", size, tt.config.MaxSize)
+			}
+		})
+	}
+}
+
+func TestWithCacheConfigShared(t *testing.T) {
+	shared, err := cache.NewCache(cache.WithCleanupPeriod(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared.Close()
+	config := manager.WithCacheConfig(cache.Config{MaxSize: 1, TTL: time.Minute})
+
+	// The config is ignored for a shared cache, whichever option comes first
+	tests := []struct {
+		name string
+		opts []options.Option[manager.BaseManager]
+	}{
+		{name: "config first", opts: []options.Option[manager.BaseManager]{config, manager.WithSharedCache(shared)}},
+		{name: "shared cache first", opts: []options.Option[manager.BaseManager]{manager.WithCache(shared), config}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := newBaseManager(t, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bm.Cache != shared {
+				t.Error("manager doesn't use the shared cache")
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/velumlabs/thor/logger"
+)
+
+// periodicJitter is the fraction of the interval randomly added to each wait,
+// so tasks of many managers don't all fire at the same moment
+const periodicJitter = 0.1
+
+// PeriodicTaskStats reports the executions of a periodic task
+type PeriodicTaskStats struct {
+	Name     string
+	Interval time.Duration
+	Runs     int64 // Completed runs, including failed ones
+	Failures int64 // Runs that returned an error or panicked
+	Skipped  int64 // Ticks skipped because the previous run was still executing
+	LastRun  time.Time
+	LastErr  error
+}
+
+// periodicTask is a task registered with RunPeriodic
+type periodicTask struct {
+	name     string
+	interval time.Duration
+	fn       func(ctx context.Context) error
+
+	running  int32
+	runs     int64
+	failures int64
+	skipped  int64
+
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+}
+
+// RunPeriodic registers fn to run every interval, with a small random jitter,
+// while the manager's background processes run. The default
+// StartBackgroundProcesses and StopBackgroundProcesses start and stop all
+// registered tasks; a task registered while they run starts right away.
+// A tick is skipped while the previous run is still executing. Errors and
+// panics are logged and don't stop the task. fn should return once ctx is done.
+func (bm *BaseManager) RunPeriodic(name string, interval time.Duration, fn func(ctx context.Context) error) error {
+	if name == "" {
+		return fmt.Errorf("periodic task name is required")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("periodic task interval must be positive")
+	}
+	if fn == nil {
+		return fmt.Errorf("periodic task function is required")
+	}
+
+	bm.periodicMu.Lock()
+	defer bm.periodicMu.Unlock()
+
+	for _, task := range bm.periodicTasks {
+		if task.name == name {
+			return fmt.Errorf("periodic task %s already registered", name)
+		}
+	}
+
+	task := &periodicTask{
+		name:     name,
+		interval: interval,
+		fn:       fn,
+	}
+	bm.periodicTasks = append(bm.periodicTasks, task)
+
+	if bm.periodicCtx != nil {
+		bm.startPeriodic(bm.periodicCtx, task)
+	}
+	return nil
+}
+
+// GetPeriodicTasks returns the statistics of the registered periodic tasks
+func (bm *BaseManager) GetPeriodicTasks() []PeriodicTaskStats {
+	bm.periodicMu.Lock()
+	tasks := append([]*periodicTask(nil), bm.periodicTasks...)
+	bm.periodicMu.Unlock()
+
+	stats := make([]PeriodicTaskStats, len(tasks))
+	for i, task := range tasks {
+		task.mu.Lock()
+		stats[i] = PeriodicTaskStats{
+			Name:     task.name,
+			Interval: task.interval,
+			Runs:     atomic.LoadInt64(&task.runs),
+			Failures: atomic.LoadInt64(&task.failures),
+			Skipped:  atomic.LoadInt64(&task.skipped),
+			LastRun:  task.lastRun,
+			LastErr:  task.lastErr,
+		}
+		task.mu.Unlock()
+	}
+	return stats
+}
+
+// startPeriodicTasks starts all registered periodic tasks
+// Returns false if there are none
+func (bm *BaseManager) startPeriodicTasks() bool {
+	bm.periodicMu.Lock()
+	defer bm.periodicMu.Unlock()
+
+	if len(bm.periodicTasks) == 0 {
+		return false
+	}
+	if bm.periodicCtx != nil {
+		return true
+	}
+
+	parent := bm.Ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	bm.periodicCtx, bm.periodicCancel = context.WithCancel(parent)
+
+	for _, task := range bm.periodicTasks {
+		bm.startPeriodic(bm.periodicCtx, task)
+	}
+	return true
+}
+
+// stopPeriodicTasks stops the periodic tasks and waits for running executions
+// Returns false if none were running
+func (bm *BaseManager) stopPeriodicTasks() bool {
+	bm.periodicMu.Lock()
+	cancel := bm.periodicCancel
+	bm.periodicCtx, bm.periodicCancel = nil, nil
+	bm.periodicMu.Unlock()
+
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	bm.periodicWG.Wait()
+	return true
+}
+
+// startPeriodic runs the tick loop of a task until ctx is done
+// Must be called with periodicMu held
+func (bm *BaseManager) startPeriodic(ctx context.Context, task *periodicTask) {
+	bm.periodicWG.Add(1)
+	go func() {
+		defer bm.periodicWG.Done()
+
+		timer := time.NewTimer(jitter(task.interval))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			if !atomic.CompareAndSwapInt32(&task.running, 0, 1) {
+				skipped := atomic.AddInt64(&task.skipped, 1)
+				if log := bm.periodicLogger(task); log != nil {
+					log.WithField("skipped", skipped).Warn("Previous run still executing, skipping tick")
+				}
+			} else {
+				bm.periodicWG.Add(1)
+				go func() {
+					defer bm.periodicWG.Done()
+					defer atomic.StoreInt32(&task.running, 0)
+					bm.runPeriodic(ctx, task)
+				}()
+			}
+
+			timer.Reset(jitter(task.interval))
+		}
+	}()
+}
+
+// runPeriodic executes a task once, recording its outcome
+func (bm *BaseManager) runPeriodic(ctx context.Context, task *periodicTask) {
+	start := time.Now()
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("periodic task panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return task.fn(ctx)
+	}()
+
+	atomic.AddInt64(&task.runs, 1)
+	if err != nil {
+		atomic.AddInt64(&task.failures, 1)
+	}
+	task.mu.Lock()
+	task.lastRun = start
+	task.lastErr = err
+	task.mu.Unlock()
+
+	log := bm.periodicLogger(task)
+	if log == nil {
+		return
+	}
+	log = log.WithField("duration", time.Since(start).String())
+	if err != nil && ctx.Err() == nil {
+		log.WithError(err).Error("Periodic task failed")
+		return
+	}
+	log.Debug("Periodic task finished")
+}
+
+// periodicLogger returns the manager's logger scoped to a task, or nil without a logger
+func (bm *BaseManager) periodicLogger(task *periodicTask) *logger.Logger {
+	if bm.Logger == nil {
+		return nil
+	}
+	return bm.Logger.WithField("task", task.name)
+}
+
+// jitter returns the interval extended by a random fraction of up to periodicJitter
+func jitter(interval time.Duration) time.Duration {
+	return interval + time.Duration(rand.Int63n(int64(float64(interval)*periodicJitter)+1))
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrManagerNotFound is returned when resolving a manager that isn't registered
+	ErrManagerNotFound = errors.New("manager not found")
+
+	// ErrUndeclaredDependency is returned when resolving a manager that the
+	// requesting manager doesn't list in GetDependencies
+	ErrUndeclaredDependency = errors.New("manager is not a declared dependency")
+)
+
+// ManagerResolver gives a manager access to the managers it depends on
+type ManagerResolver interface {
+	// Resolve returns a declared dependency, unwrapped from any middleware
+	Resolve(managerID ManagerID) (Manager, error)
+}
+
+// ResolverReceiver is implemented by managers that accept a ManagerResolver
+// The engine injects a resolver scoped to the manager's dependencies when the
+// manager is registered. BaseManager implements it.
+type ResolverReceiver interface {
+	SetManagerResolver(resolver ManagerResolver)
+}
+
+// SetManagerResolver sets the resolver Dependency uses
+func (bm *BaseManager) SetManagerResolver(resolver ManagerResolver) {
+	bm.resolverMu.Lock()
+	defer bm.resolverMu.Unlock()
+	bm.resolver = resolver
+}
+
+// Dependency returns a manager this manager declared in GetDependencies
+// Returns ErrUndeclaredDependency for any other manager
+func (bm *BaseManager) Dependency(managerID ManagerID) (Manager, error) {
+	bm.resolverMu.RLock()
+	resolver := bm.resolver
+	bm.resolverMu.RUnlock()
+
+	if resolver == nil {
+		return nil, fmt.Errorf("manager %s: no manager resolver, the manager is not registered with an engine", managerID)
+	}
+	return resolver.Resolve(managerID)
+}
+
+// DependencyAs returns a declared dependency as its concrete type, so managers
+// can call each other's public methods:
+//
+//	// MemoryManager exposes its recall to the managers depending on it
+//	func (m *MemoryManager) Recall(sessionID id.ID, query string) ([]db.Fragment, error)
+//
+//	func (m *ResponseManager) GetDependencies() []manager.ManagerID {
+//		return []manager.ManagerID{MemoryManagerID}
+//	}
+//
+//	func (m *ResponseManager) Process(ctx context.Context, s *state.State) error {
+//		memory, err := manager.DependencyAs[*MemoryManager](m.BaseManager, MemoryManagerID)
+//		if err != nil {
+//			return err
+//		}
+//		fragments, err := memory.Recall(s.Input.SessionID, s.Input.Content)
+//		...
+//	}
+func DependencyAs[T Manager](bm *BaseManager, managerID ManagerID) (T, error) {
+	var zero T
+	m, err := bm.Dependency(managerID)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := m.(T)
+	if !ok {
+		return zero, fmt.Errorf("manager %s is a %T, not a %T", managerID, m, zero)
+	}
+	return typed, nil
+}
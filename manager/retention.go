@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/stores"
+)
+
+// defaultRetentionInterval is how often a retention policy runs by default
+const defaultRetentionInterval = time.Hour
+
+// RetentionPolicy configures the periodic purge of a fragment store
+type RetentionPolicy struct {
+	// Store to purge, the manager's InteractionFragmentStore if nil
+	Store *stores.FragmentStore
+	// How long soft-deleted fragments are kept before being purged
+	DeletedFor time.Duration
+	// Age at which live fragments are purged too, never if zero
+	MaxAge time.Duration
+	// How often the purge runs, hourly if zero
+	Interval time.Duration
+
+	BatchSize int                    // See stores.PurgeOptions
+	Archive   stores.FragmentArchive // Archive of the purged fragments, if set
+}
+
+// RunRetention registers a periodic task purging the fragments a policy
+// expires, see RunPeriodic. Progress is logged with the manager's logger.
+func (bm *BaseManager) RunRetention(policy RetentionPolicy) error {
+	store := policy.Store
+	if store == nil {
+		store = bm.InteractionFragmentStore
+	}
+	if store == nil {
+		return fmt.Errorf("retention policy requires a fragment store")
+	}
+	if policy.DeletedFor < 0 || policy.MaxAge < 0 {
+		return fmt.Errorf("retention periods must not be negative")
+	}
+	interval := policy.Interval
+	if interval == 0 {
+		interval = defaultRetentionInterval
+	}
+
+	name := fmt.Sprintf("retention:%s", store.Table())
+	return bm.RunPeriodic(name, interval, func(ctx context.Context) error {
+		now := time.Now()
+		var liveBefore time.Time
+		if policy.MaxAge > 0 {
+			liveBefore = now.Add(-policy.MaxAge)
+		}
+
+		purged, err := store.WithContext(ctx).PurgeBefore(now.Add(-policy.DeletedFor), liveBefore, stores.PurgeOptions{
+			BatchSize: policy.BatchSize,
+			Archive:   policy.Archive,
+			OnBatch: func(deleted, total int64) {
+				if bm.Logger != nil {
+					bm.Logger.WithField("task", name).
+						WithField("deleted", deleted).
+						WithField("total", total).
+						Debug("Purged fragment batch")
+				}
+			},
+		})
+		if purged > 0 && bm.Logger != nil {
+			bm.Logger.WithField("task", name).WithField("purged", purged).Info("Purged expired fragments")
+		}
+		return err
+	})
+}
@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/id"
+)
+
+// Clock tells the time and schedules functions, so throttling can be driven by
+// a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function scheduled by a Clock
+type Timer interface {
+	// Stop cancels the function, returning false if it already ran or was stopped
+	Stop() bool
+}
+
+// realClock is the Clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// ThrottleStats counts the executions skipped by Debounce and RateLimit
+type ThrottleStats struct {
+	Debounced   int64 // Debounced calls superseded by a later call of the same session
+	RateLimited int64 // Calls skipped because the session was over its rate limit
+}
+
+// debounceEntry is the pending execution of a debounced session
+type debounceEntry struct {
+	timer Timer
+}
+
+// Debounce runs fn once the session has been quiet for window: every call
+// postpones the pending execution, which then runs the fn of the latest call
+// in its own goroutine. Superseded calls count as debounced. The pending
+// execution is kept in the manager's cache, so it survives across Process
+// calls; window should be shorter than the cache TTL.
+func (bm *BaseManager) Debounce(sessionID id.ID, window time.Duration, fn func()) {
+	if bm.Cache == nil || window <= 0 {
+		go bm.runThrottled(sessionID, fn)
+		return
+	}
+
+	key := bm.throttleKey("debounce", sessionID)
+	pending := cache.ViewOf[*debounceEntry](bm.Cache)
+
+	bm.throttleMu.Lock()
+	defer bm.throttleMu.Unlock()
+
+	if previous, ok := pending.Get(key); ok && previous.timer.Stop() {
+		atomic.AddInt64(&bm.debounced, 1)
+	}
+
+	entry := &debounceEntry{}
+	entry.timer = bm.clock().AfterFunc(window, func() {
+		bm.throttleMu.Lock()
+		if current, ok := pending.Get(key); ok && current == entry {
+			pending.Delete(key)
+		}
+		bm.throttleMu.Unlock()
+
+		bm.runThrottled(sessionID, fn)
+	})
+	pending.Set(key, entry)
+}
+
+// RateLimit runs fn unless it already ran for the session within the last per,
+// in which case the call is skipped and counted as rate limited. Returns
+// whether fn ran and its error. The time of the last run is kept in the
+// manager's cache, so limits shorter than the cache TTL survive across
+// Process calls.
+func (bm *BaseManager) RateLimit(sessionID id.ID, per time.Duration, fn func() error) (bool, error) {
+	if bm.Cache == nil || per <= 0 {
+		return true, fn()
+	}
+
+	key := bm.throttleKey("rate_limit", sessionID)
+	lastRuns := cache.ViewOf[time.Time](bm.Cache)
+	now := bm.clock().Now()
+
+	bm.throttleMu.Lock()
+	if lastRun, ok := lastRuns.Get(key); ok && now.Sub(lastRun) < per {
+		bm.throttleMu.Unlock()
+		atomic.AddInt64(&bm.rateLimited, 1)
+		return false, nil
+	}
+	lastRuns.Set(key, now)
+	bm.throttleMu.Unlock()
+
+	return true, fn()
+}
+
+// GetThrottleStats returns the number of executions Debounce and RateLimit skipped
+func (bm *BaseManager) GetThrottleStats() ThrottleStats {
+	return ThrottleStats{
+		Debounced:   atomic.LoadInt64(&bm.debounced),
+		RateLimited: atomic.LoadInt64(&bm.rateLimited),
+	}
+}
+
+// throttleKey returns the cache key of a session's throttling state, scoped to
+// the manager since caches may be shared
+func (bm *BaseManager) throttleKey(kind string, sessionID id.ID) cache.CacheKey {
+	return cache.CacheKey(fmt.Sprintf("throttle:%p:%s:%s", bm, kind, sessionID))
+}
+
+// runThrottled runs a debounced function, logging a panic instead of crashing
+func (bm *BaseManager) runThrottled(sessionID id.ID, fn func()) {
+	defer func() {
+		if r := recover(); r != nil && bm.Logger != nil {
+			bm.Logger.WithFields(map[string]interface{}{
+				"session": sessionID,
+				"panic":   r,
+				"stack":   string(debug.Stack()),
+			}).Error("Debounced function panicked")
+		}
+	}()
+	fn()
+}
+
+// clock returns the manager's clock, the real one unless set with WithClock
+func (bm *BaseManager) clock() Clock {
+	if bm.throttleClock == nil {
+		return realClock{}
+	}
+	return bm.throttleClock
+}
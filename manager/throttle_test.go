@@ -0,0 +1,304 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/id"
+)
+
+// fakeClock is a Clock whose time only moves with Advance, which runs the
+// functions that became due
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a function scheduled on a fakeClock
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d, running the due functions in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		switch {
+		case timer.stopped:
+		case !timer.at.After(c.now):
+			timer.stopped = true
+			due = append(due, timer)
+		default:
+			pending = append(pending, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+// newThrottledManager returns a manager throttling with clock and a cache, if withCache
+func newThrottledManager(t *testing.T, clock *fakeClock, withCache bool) *BaseManager {
+	t.Helper()
+	bm := &BaseManager{Logger: newTestLogger(t), throttleClock: clock}
+	if withCache {
+		c, err := cache.NewCache(cache.WithCleanupPeriod(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(c.Close)
+		bm.Cache = c
+	}
+	return bm
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		withCache bool
+		per       time.Duration
+		// calls are the clock's advances before each call
+		calls []time.Duration
+		want  []bool
+	}{
+		{
+			name:      "within the limit",
+			withCache: true,
+			per:       time.Minute,
+			calls:     []time.Duration{0, time.Minute, time.Minute},
+			want:      []bool{true, true, true},
+		},
+		{
+			name:      "over the limit",
+			withCache: true,
+			per:       time.Minute,
+			calls:     []time.Duration{0, 10 * time.Second, 20 * time.Second, 30 * time.Second, 10 * time.Second},
+			want:      []bool{true, false, false, true, false},
+		},
+		{
+			name:  "without cache",
+			per:   time.Minute,
+			calls: []time.Duration{0, time.Second},
+			want:  []bool{true, true},
+		},
+		{
+			name:      "no limit",
+			withCache: true,
+			calls:     []time.Duration{0, 0},
+			want:      []bool{true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			bm := newThrottledManager(t, clock, tt.withCache)
+			sessionID := id.New()
+
+			var skipped int64
+			for i, advance := range tt.calls {
+				clock.Advance(advance)
+				calls := 0
+				ran, err := bm.RateLimit(sessionID, tt.per, func() error {
+					calls++
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ran != tt.want[i] || (calls == 1) != tt.want[i] {
+					t.Errorf("call %d ran = %v with %d calls, want %v", i, ran, calls, tt.want[i])
+				}
+				if !ran {
+					skipped++
+				}
+			}
+			if got := bm.GetThrottleStats(); got.RateLimited != skipped || got.Debounced != 0 {
+				t.Errorf("stats = %+v, want %d rate limited", got, skipped)
+			}
+		})
+	}
+}
+
+func TestRateLimitSessions(t *testing.T) {
+	clock := newFakeClock()
+	bm := newThrottledManager(t, clock, true)
+	other := newThrottledManager(t, clock, false)
+	other.Cache = bm.Cache
+	first, second := id.New(), id.New()
+
+	tests := []struct {
+		name      string
+		m         *BaseManager
+		sessionID id.ID
+		want      bool
+	}{
+		{name: "first session", m: bm, sessionID: first, want: true},
+		{name: "first session again", m: bm, sessionID: first},
+		{name: "second session", m: bm, sessionID: second, want: true},
+		{name: "other manager sharing the cache", m: other, sessionID: first, want: true},
+	}
+	for _, tt := range tests {
+		ran, err := tt.m.RateLimit(tt.sessionID, time.Minute, func() error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ran != tt.want {
+			t.Errorf("%s: ran = %v, want %v", tt.name, ran, tt.want)
+		}
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	bm := newThrottledManager(t, newFakeClock(), true)
+	failure := errors.New("extraction failed")
+	ran, err := bm.RateLimit(id.New(), time.Minute, func() error { return failure })
+	if !ran || !errors.Is(err, failure) {
+		t.Errorf("RateLimit = %v, %v, want the error of the run", ran, err)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		// calls are the clock's advances before each call
+		calls []time.Duration
+		// after is how long the clock advances after the last call
+		after time.Duration
+		// want are the calls whose function ran
+		want          []int
+		wantDebounced int64
+	}{
+		{
+			name:   "single call",
+			window: time.Minute,
+			calls:  []time.Duration{0},
+			after:  time.Minute,
+			want:   []int{0},
+		},
+		{
+			name:   "not quiet yet",
+			window: time.Minute,
+			calls:  []time.Duration{0},
+			after:  59 * time.Second,
+		},
+		{
+			name:          "burst runs the latest",
+			window:        time.Minute,
+			calls:         []time.Duration{0, 10 * time.Second, 50 * time.Second},
+			after:         time.Minute,
+			want:          []int{2},
+			wantDebounced: 2,
+		},
+		{
+			name:   "quiet between calls",
+			window: time.Minute,
+			calls:  []time.Duration{0, 2 * time.Minute},
+			after:  time.Minute,
+			want:   []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			bm := newThrottledManager(t, clock, true)
+			sessionID := id.New()
+
+			var ran []int
+			for i, advance := range tt.calls {
+				clock.Advance(advance)
+				i := i
+				bm.Debounce(sessionID, tt.window, func() { ran = append(ran, i) })
+			}
+			clock.Advance(tt.after)
+
+			if len(ran) != len(tt.want) {
+				t.Fatalf("ran calls %v, want %v", ran, tt.want)
+			}
+			for i := range tt.want {
+				if ran[i] != tt.want[i] {
+					t.Errorf("ran calls %v, want %v", ran, tt.want)
+				}
+			}
+			if got := bm.GetThrottleStats(); got.Debounced != tt.wantDebounced || got.RateLimited != 0 {
+				t.Errorf("stats = %+v, want %d debounced", got, tt.wantDebounced)
+			}
+		})
+	}
+}
+
+func TestDebounceImmediate(t *testing.T) {
+	tests := []struct {
+		name      string
+		withCache bool
+		window    time.Duration
+	}{
+		{name: "without cache", window: time.Minute},
+		{name: "no window", withCache: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newThrottledManager(t, newFakeClock(), tt.withCache)
+			done := make(chan struct{})
+			bm.Debounce(id.New(), tt.window, func() { close(done) })
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("function did not run without the clock advancing")
+			}
+		})
+	}
+}
+
+func TestDebouncePanic(t *testing.T) {
+	clock := newFakeClock()
+	bm := newThrottledManager(t, clock, true)
+	bm.Debounce(id.New(), time.Second, func() { panic("boom") })
+
+	// The panic is logged, not propagated
+	clock.Advance(time.Second)
+}
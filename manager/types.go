@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"context"
+	"sync"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/events"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/logger"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+)
+
+// ManagerID uniquely identifies a manager
+type ManagerID string
+
+const (
+	BaseManagerID ManagerID = "base"
+)
+
+// NamespacedKey returns the state data key a manager publishes name under,
+// e.g. "insight/insights", so managers publishing the same name don't collide
+func NamespacedKey(id ManagerID, name string) state.StateDataKey {
+	return state.NamespacedKey(string(id), name)
+}
+
+// EventData carries the payload of an event triggered by a manager.
+// An events.Event is published to the event bus as is; any other value
+// is published as an events.EventManager event under the "data" payload key.
+type EventData interface{}
+
+// EventCallbackFunc is called when a manager triggers an event
+type EventCallbackFunc func(eventData EventData)
+
+// typedEventHandler is a handler registered with RegisterEventHandlerFor
+type typedEventHandler struct {
+	id       uint64
+	callback EventCallbackFunc
+}
+
+// Manager defines the interface all managers must implement
+type Manager interface {
+	GetID() ManagerID
+	GetDependencies() []ManagerID
+	// Process analyzes the input of the pipeline. ctx is cancelled when the
+	// manager's timeout expires or the engine shuts down, and the engine waits
+	// for Process to return before going on, so it should return promptly then.
+	Process(ctx context.Context, state *state.State) error
+	PostProcess(state *state.State) error
+	Context(state *state.State) ([]state.StateData, error)
+	Store(fragment *db.Fragment) error
+	StartBackgroundProcesses()
+	StopBackgroundProcesses()
+	RegisterEventHandler(callback EventCallbackFunc)
+	triggerEvent(eventData EventData)
+}
+
+// BackgroundRunner is implemented by managers that run a background loop.
+// The engine runs RunBackground in its own goroutine and cancels ctx on shutdown;
+// RunBackground should return promptly once ctx is done.
+// Managers implementing it don't need to override StartBackgroundProcesses
+// or StopBackgroundProcesses.
+type BackgroundRunner interface {
+	RunBackground(ctx context.Context) error
+}
+
+// DryRunner is implemented by managers that can turn their store writes into
+// no-ops. The engine calls EnableDryRun on them when it runs in dry-run mode.
+type DryRunner interface {
+	EnableDryRun()
+}
+
+// EventCloser is implemented by managers that dispatch their events to a
+// handler in the background. The engine calls CloseEvents when it shuts down
+// and when the manager is removed or replaced.
+type EventCloser interface {
+	CloseEvents(ctx context.Context) error
+}
+
+// TenantScoper is implemented by managers that can scope their stores to a
+// tenant. The engine calls ScopeToTenant on them when it runs for a tenant,
+// see stores.WithTenant.
+type TenantScoper interface {
+	ScopeToTenant(tenantID id.ID)
+}
+
+// BaseManager provides the shared dependencies and default behavior for managers
+type BaseManager struct {
+	Ctx context.Context
+
+	// Stores
+	FragmentStore            *stores.FragmentStore
+	ActorStore               *stores.ActorStore
+	SessionStore             *stores.SessionStore
+	InteractionFragmentStore *stores.FragmentStore
+
+	LLM    *llm.LLMClient
+	Logger *logger.Logger
+	Cache  *cache.Cache
+
+	// Defaults of the LLM requests made through the manager's helpers, and the
+	// recorder their calls are reported to, set by the engine
+	defaultModelType   llm.ModelType
+	defaultTemperature *float32
+	llmRecorderMu      sync.RWMutex
+	llmRecorder        LLMRecorder
+
+	// Configuration of the manager's own cache when no shared cache is set
+	cacheConfig *cache.Config
+
+	// Default identity of the assistant the manager works for.
+	// Engines hosting several assistants set the active one in state.Assistant.
+	AssistantName string
+	AssistantID   id.ID
+
+	// Event bus receiving triggered events, if configured
+	EventBus *events.Bus
+
+	// Handlers of triggered events, for every event type and by event type,
+	// and the dispatcher running them off the manager's call path, guarded by
+	// eventMu, and the dispatcher's behavior when the handlers fall behind
+	eventMu       sync.RWMutex
+	eventHandler  EventCallbackFunc
+	typedHandlers map[events.EventType][]typedEventHandler
+	nextHandlerID uint64
+	handlerBus    *events.Bus
+	eventDelivery events.DeliveryPolicy
+
+	// Resolver for the managers listed in GetDependencies, set by the engine
+	resolverMu sync.RWMutex
+	resolver   ManagerResolver
+
+	// Tasks registered with RunPeriodic, and the context they run under while started
+	periodicMu     sync.Mutex
+	periodicTasks  []*periodicTask
+	periodicCtx    context.Context
+	periodicCancel context.CancelFunc
+	periodicWG     sync.WaitGroup
+
+	// Debounce and RateLimit state, the clock driving them and their skipped executions
+	throttleMu    sync.Mutex
+	throttleClock Clock
+	debounced     int64
+	rateLimited   int64
+
+	// Background loop started with StartBackground
+	backgroundMu     sync.Mutex
+	backgroundCancel context.CancelFunc
+	backgroundDone   chan struct{}
+}
@@ -0,0 +1,251 @@
+// Package classifier provides a manager that tags each user input with a
+// sentiment and a coarse intent, so downstream managers and prompts can branch
+// on them. The labels and the model's confidence in them are written into the
+// input's metadata during Process, before the engine stores the input:
+//
+//	if input.Metadata.GetString(classifier.IntentKey) == classifier.IntentComplaint {
+//	    // escalate
+//	}
+//
+// Prompts can include the classification under ClassificationKey:
+//
+//	builder.WithManagerData(classifier.ClassificationKey).
+//	    AddSystemSection("{{with .classification}}The user's intent: {{.Intent}}{{end}}")
+//
+// Classification uses a single structured-output call to the fast model per
+// input. With WithBatchWindow, recent messages of the session that aren't
+// classified yet are classified in the same call. Failures are logged and
+// never fail Process.
+package classifier
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+)
+
+const defaultBatchWindow = 1
+
+// classificationPrompt instructs the model classifying messages, given the
+// sentiment and intent labels
+const classificationPrompt = `You classify chat messages written by users. For each numbered message,
+give its sentiment, one of: %s; and its intent, one of: %s. Use only these labels and rate your
+confidence in each label between 0 and 1.`
+
+// NewClassifierManager creates a new ClassifierManager with the given base and classifier options
+// By default it classifies each input alone with the default labels
+func NewClassifierManager(baseOpts []options.Option[manager.BaseManager], classifierOpts ...options.Option[ClassifierManager]) (*ClassifierManager, error) {
+	// The fast model is the default; base options given by the caller take precedence
+	baseOpts = append([]options.Option[manager.BaseManager]{manager.WithDefaultModelType(llm.ModelTypeFast)}, baseOpts...)
+	base, err := manager.NewBaseManager(baseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &ClassifierManager{
+		BaseManager:     base,
+		sentimentLabels: []string{SentimentPositive, SentimentNeutral, SentimentNegative},
+		intentLabels:    []string{IntentQuestion, IntentCommand, IntentSmalltalk, IntentComplaint},
+		batchWindow:     defaultBatchWindow,
+	}
+	if err := options.ApplyOptions(m, classifierOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create classifier manager: %w", err)
+	}
+
+	return m, nil
+}
+
+// GetID returns the manager's identifier
+func (m *ClassifierManager) GetID() manager.ManagerID {
+	return ClassifierManagerID
+}
+
+// Capabilities declares that the manager only takes part in Process and Context
+func (m *ClassifierManager) Capabilities() []manager.Capability {
+	return []manager.Capability{manager.CapabilityProcess, manager.CapabilityContext}
+}
+
+// ProvidedKeys declares the types of the classification in the context data
+func (m *ClassifierManager) ProvidedKeys() []manager.StateDataContract {
+	return []manager.StateDataContract{
+		manager.TypedContract[*Classification](ClassificationKey),
+	}
+}
+
+// Restore the context data of state snapshots with its type
+func init() {
+	state.RegisterDataType[*Classification](ClassificationKey)
+}
+
+// Process classifies user inputs, writing the labels into the input's metadata
+// and, in batch mode, into the recent messages classified with it. Failures
+// are logged and leave the messages unclassified.
+func (m *ClassifierManager) Process(ctx context.Context, currentState *state.State) error {
+	input := currentState.Input
+	if input == nil || (input.Actor != nil && input.Actor.Assistant) {
+		return nil
+	}
+
+	if err := m.classify(ctx, currentState); err != nil {
+		m.Logger.WithError(err).WithField("fragment", input.ID).Warn("Failed to classify input")
+	}
+	return nil
+}
+
+// Context returns the classification of the input
+func (m *ClassifierManager) Context(currentState *state.State) ([]state.StateData, error) {
+	input := currentState.Input
+	if input == nil {
+		return nil, nil
+	}
+	return []state.StateData{{Key: ClassificationKey, Value: GetClassification(input)}}, nil
+}
+
+// GetClassification returns the classification stored in a fragment's
+// metadata, or nil if the fragment isn't classified
+func GetClassification(fragment *db.Fragment) *Classification {
+	sentiment := fragment.Metadata.GetString(SentimentKey)
+	intent := fragment.Metadata.GetString(IntentKey)
+	if sentiment == "" && intent == "" {
+		return nil
+	}
+	return &Classification{
+		Sentiment:           sentiment,
+		SentimentConfidence: fragment.Metadata.GetFloat(SentimentConfidenceKey),
+		Intent:              intent,
+		IntentConfidence:    fragment.Metadata.GetFloat(IntentConfidenceKey),
+	}
+}
+
+// classify classifies the input together with the unclassified messages of
+// its batch window in a single call, and stores the labels of the latter
+func (m *ClassifierManager) classify(ctx context.Context, currentState *state.State) error {
+	input := currentState.Input
+
+	backlog, err := m.backlog(ctx, currentState)
+	if err != nil {
+		return err
+	}
+	messages := append(backlog, input)
+
+	var numbered strings.Builder
+	for i, message := range messages {
+		fmt.Fprintf(&numbered, "%d. %s\n", i+1, message.Content)
+	}
+
+	var result classification
+	if err := m.GenerateStructuredOutputContext(ctx, llm.StructuredOutputRequest{
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: fmt.Sprintf(classificationPrompt,
+				strings.Join(m.sentimentLabels, ", "), strings.Join(m.intentLabels, ", "))},
+			{Role: llm.RoleUser, Content: numbered.String()},
+		},
+		SchemaName:   "classification",
+		StrictSchema: true,
+	}, &result); err != nil {
+		return fmt.Errorf("failed to classify messages: %w", err)
+	}
+
+	classified := make(map[int]bool, len(result.Messages))
+	for _, entry := range result.Messages {
+		index := entry.Index - 1
+		if index < 0 || index >= len(messages) || classified[index] {
+			continue
+		}
+		if m.label(messages[index], entry) {
+			classified[index] = true
+		}
+	}
+
+	store := m.InteractionFragmentStore.WithTx(currentState.Transaction())
+	for i, message := range backlog {
+		if !classified[i] {
+			continue
+		}
+		if err := store.Upsert(message); err != nil {
+			return fmt.Errorf("failed to store classification of %s: %w", message.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// backlog returns the user messages among the batch window of the input's
+// session that aren't classified yet, oldest first
+func (m *ClassifierManager) backlog(ctx context.Context, currentState *state.State) ([]*db.Fragment, error) {
+	input := currentState.Input
+	if m.batchWindow <= 1 {
+		return nil, nil
+	}
+
+	history, err := m.InteractionFragmentStore.WithContext(ctx).GetSessionHistory(input.SessionID, stores.HistoryQuery{
+		Limit:  m.batchWindow - 1,
+		Before: input.CreatedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recent messages: %w", err)
+	}
+
+	assistantID := m.AssistantID
+	if currentState.Assistant != nil {
+		assistantID = currentState.Assistant.ID
+	}
+
+	var backlog []*db.Fragment
+	for i := range history {
+		message := &history[i]
+		if message.ID == input.ID || message.ActorID == assistantID || GetClassification(message) != nil {
+			continue
+		}
+		backlog = append(backlog, message)
+	}
+	return backlog, nil
+}
+
+// label writes the labels of a classified message into its metadata, dropping
+// labels outside the taxonomy. Returns whether any label was kept.
+func (m *ClassifierManager) label(fragment *db.Fragment, entry classifiedMessage) bool {
+	sentiment := matchLabel(entry.Sentiment, m.sentimentLabels)
+	intent := matchLabel(entry.Intent, m.intentLabels)
+	if sentiment == "" && intent == "" {
+		return false
+	}
+
+	if fragment.Metadata == nil {
+		fragment.Metadata = db.Metadata{}
+	}
+	if sentiment != "" {
+		fragment.Metadata[SentimentKey] = sentiment
+		fragment.Metadata[SentimentConfidenceKey] = clampConfidence(entry.SentimentConfidence)
+	}
+	if intent != "" {
+		fragment.Metadata[IntentKey] = intent
+		fragment.Metadata[IntentConfidenceKey] = clampConfidence(entry.IntentConfidence)
+	}
+	return true
+}
+
+// matchLabel returns the label of the taxonomy the returned label names, or an
+// empty string if it names none
+func matchLabel(label string, taxonomy []string) string {
+	label = normalizeLabel(label)
+	for _, known := range taxonomy {
+		if label == known {
+			return known
+		}
+	}
+	return ""
+}
+
+// clampConfidence bounds a confidence returned by the model to [0, 1]
+func clampConfidence(confidence float64) float64 {
+	return math.Max(0, math.Min(confidence, 1))
+}
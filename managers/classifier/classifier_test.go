@@ -0,0 +1,372 @@
+package classifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+)
+
+// withClassification makes the mock provider return messages as the
+// classification
+func withClassification(env *managertest.TestEnvironment, messages ...classifiedMessage) {
+	env.Provider.StructuredOutputFunc = func(ctx context.Context, req llm.StructuredOutputRequest, result interface{}) error {
+		data, err := json.Marshal(classification{Messages: messages})
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, result)
+	}
+}
+
+func TestClassifierManagerConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[ClassifierManager]
+	}{
+		{name: "default"},
+		{name: "batch", opts: []options.Option[ClassifierManager]{WithBatchWindow(5)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m, err := NewClassifierManager(env.BaseOptions(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			managertest.RunConformance(t, m)
+		})
+	}
+}
+
+func TestMatchLabel(t *testing.T) {
+	taxonomy := []string{"positive", "neutral", "smalltalk"}
+	tests := []struct {
+		name  string
+		label string
+		want  string
+	}{
+		{name: "exact", label: "neutral", want: "neutral"},
+		{name: "upper case", label: "POSITIVE", want: "positive"},
+		{name: "mixed case", label: "SmallTalk", want: "smalltalk"},
+		{name: "surrounding space", label: " \tneutral\n", want: "neutral"},
+		{name: "unknown", label: "ecstatic"},
+		{name: "empty", label: ""},
+		{name: "inner space", label: "small talk"},
+		{name: "prefix", label: "pos"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchLabel(tt.label, taxonomy); got != tt.want {
+				t.Errorf("matchLabel(%q) = %q, want %q", tt.label, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClampConfidence(t *testing.T) {
+	tests := []struct {
+		name       string
+		confidence float64
+		want       float64
+	}{
+		{name: "zero", confidence: 0, want: 0},
+		{name: "within", confidence: 0.42, want: 0.42},
+		{name: "one", confidence: 1, want: 1},
+		{name: "negative", confidence: -0.5, want: 0},
+		{name: "above one", confidence: 1.5, want: 1},
+		{name: "percentage", confidence: 87, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clampConfidence(tt.confidence); got != tt.want {
+				t.Errorf("clampConfidence(%v) = %v, want %v", tt.confidence, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    options.Option[ClassifierManager]
+		want   []string
+		err    string
+		intent bool
+	}{
+		{name: "sentiment", opt: WithSentimentLabels(" Happy", "SAD "), want: []string{"happy", "sad"}},
+		{name: "intent", opt: WithIntentLabels("Greeting", "farewell"), want: []string{"greeting", "farewell"}, intent: true},
+		{name: "none", opt: WithSentimentLabels(), err: "at least one label is required"},
+		{name: "empty", opt: WithIntentLabels("greeting", "  "), err: "labels must not be empty"},
+		{name: "duplicate after normalization", opt: WithIntentLabels("greeting", "Greeting"), err: `duplicate label "greeting"`},
+		{name: "batch window", opt: WithBatchWindow(0), err: "batch window must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m, err := NewClassifierManager(env.BaseOptions(), tt.opt)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.sentimentLabels
+			if tt.intent {
+				got = m.intentLabels
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifierManagerProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		// messages is the classification returned, output the raw output if set
+		messages []classifiedMessage
+		output   string
+		err      error
+		// assistant makes the input the assistant's
+		assistant bool
+		want      *Classification
+		// calls is the number of classification calls expected
+		calls int
+	}{
+		{
+			name:     "labelled",
+			messages: []classifiedMessage{{Index: 1, Sentiment: "negative", SentimentConfidence: 0.9, Intent: "complaint", IntentConfidence: 0.8}},
+			want:     &Classification{Sentiment: "negative", SentimentConfidence: 0.9, Intent: "complaint", IntentConfidence: 0.8},
+			calls:    1,
+		},
+		{
+			name:     "normalized labels",
+			messages: []classifiedMessage{{Index: 1, Sentiment: " Positive ", SentimentConfidence: 0.7, Intent: "QUESTION", IntentConfidence: 0.6}},
+			want:     &Classification{Sentiment: "positive", SentimentConfidence: 0.7, Intent: "question", IntentConfidence: 0.6},
+			calls:    1,
+		},
+		{
+			name:     "clamped confidence",
+			messages: []classifiedMessage{{Index: 1, Sentiment: "neutral", SentimentConfidence: -1, Intent: "command", IntentConfidence: 95}},
+			want:     &Classification{Sentiment: "neutral", SentimentConfidence: 0, Intent: "command", IntentConfidence: 1},
+			calls:    1,
+		},
+		{
+			name:     "label outside the taxonomy dropped",
+			messages: []classifiedMessage{{Index: 1, Sentiment: "furious", SentimentConfidence: 0.9, Intent: "complaint", IntentConfidence: 0.8}},
+			want:     &Classification{Intent: "complaint", IntentConfidence: 0.8},
+			calls:    1,
+		},
+		{
+			name:     "no label in the taxonomy",
+			messages: []classifiedMessage{{Index: 1, Sentiment: "furious", Intent: "rant"}},
+			calls:    1,
+		},
+		{
+			name:     "unknown index",
+			messages: []classifiedMessage{{Index: 2, Sentiment: "neutral", Intent: "question"}, {Index: 0, Sentiment: "neutral"}},
+			calls:    1,
+		},
+		{
+			name: "first entry of an index kept",
+			messages: []classifiedMessage{
+				{Index: 1, Sentiment: "positive", SentimentConfidence: 0.5},
+				{Index: 1, Sentiment: "negative", SentimentConfidence: 0.5},
+			},
+			want:  &Classification{Sentiment: "positive", SentimentConfidence: 0.5},
+			calls: 1,
+		},
+		{
+			name:  "LLM error",
+			err:   errors.New("provider unavailable"),
+			calls: 1,
+		},
+		{
+			name:   "unparseable output",
+			output: `{"messages": "positive"`,
+			calls:  1,
+		},
+		{
+			name:      "assistant input",
+			messages:  []classifiedMessage{{Index: 1, Sentiment: "neutral", Intent: "question"}},
+			assistant: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			withClassification(env, tt.messages...)
+			if tt.output != "" || tt.err != nil {
+				env.Provider.StructuredOutputFunc = func(ctx context.Context, req llm.StructuredOutputRequest, result interface{}) error {
+					if tt.err != nil {
+						return tt.err
+					}
+					return json.Unmarshal([]byte(tt.output), result)
+				}
+			}
+			m, err := NewClassifierManager(env.BaseOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actor := env.NewActor("Alice", false)
+			if tt.assistant {
+				actor = env.Assistant
+			}
+			input := env.NewFragment(actor, env.NewSession(), "Why is my order late?")
+
+			// Failures are logged, never returned
+			s := env.NewState(input)
+			if err := m.Process(context.Background(), s); err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if calls := len(env.Provider.StructuredOutputRequests()); calls != tt.calls {
+				t.Errorf("%d classification calls, want %d", calls, tt.calls)
+			}
+
+			got := GetClassification(input)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("classification = %+v, want %+v", got, tt.want)
+			}
+			data, err := m.Context(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != 1 || data[0].Key != ClassificationKey {
+				t.Fatalf("context data = %+v, want the classification", data)
+			}
+			if value := data[0].Value.(*Classification); (value == nil) != (tt.want == nil) {
+				t.Errorf("context classification = %+v, want %+v", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifierManagerBacklog(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		// classified labels the backlog messages by content
+		classified map[string]string
+		// want are the intents of the stored messages by content, empty if
+		// unclassified
+		want map[string]string
+		// prompted are the messages numbered in the prompt
+		prompted []string
+	}{
+		{
+			name:       "input alone",
+			window:     1,
+			classified: map[string]string{"input": "question"},
+			want:       map[string]string{"old": "", "older": "", "input": "question"},
+			prompted:   []string{"input"},
+		},
+		{
+			name:       "backlog flushed",
+			window:     10,
+			classified: map[string]string{"older": "command", "old": "smalltalk", "input": "question"},
+			want:       map[string]string{"older": "command", "old": "smalltalk", "labelled": "complaint", "input": "question"},
+			prompted:   []string{"older", "old", "input"},
+		},
+		{
+			name:       "backlog cut by the window",
+			window:     3,
+			classified: map[string]string{"old": "smalltalk", "input": "question"},
+			want:       map[string]string{"older": "", "old": "smalltalk", "input": "question"},
+			prompted:   []string{"old", "input"},
+		},
+		{
+			name:       "backlog left unclassified",
+			window:     10,
+			classified: map[string]string{"older": "rant", "input": "question"},
+			want:       map[string]string{"older": "", "old": "", "input": "question"},
+			prompted:   []string{"older", "old", "input"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m, err := NewClassifierManager(env.BaseOptions(), WithBatchWindow(tt.window))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The session's history, oldest first: a message classified earlier,
+			// two unclassified ones and an answer of the assistant
+			user := env.NewActor("Alice", false)
+			session := env.NewSession()
+			labelled := env.NewFragment(user, session, "labelled")
+			labelled.Metadata[IntentKey] = IntentComplaint
+			if err := env.InteractionFragmentStore.Upsert(labelled); err != nil {
+				t.Fatal(err)
+			}
+			fragments := map[string]*db.Fragment{"labelled": labelled}
+			for _, content := range []string{"older", "old"} {
+				fragments[content] = env.NewFragment(user, session, content)
+			}
+			env.NewFragment(env.Assistant, session, "answer")
+			input := env.NewFragment(user, session, "input")
+			fragments["input"] = input
+
+			env.Provider.StructuredOutputFunc = func(ctx context.Context, req llm.StructuredOutputRequest, result interface{}) error {
+				var messages []classifiedMessage
+				for i, line := range strings.Split(strings.TrimSpace(req.Messages[1].Content), "\n") {
+					content := strings.SplitN(line, ". ", 2)[1]
+					if intent, ok := tt.classified[content]; ok {
+						messages = append(messages, classifiedMessage{Index: i + 1, Intent: intent, IntentConfidence: 0.5})
+					}
+				}
+				data, err := json.Marshal(classification{Messages: messages})
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, result)
+			}
+
+			if err := m.Process(context.Background(), env.NewState(input)); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := env.Provider.StructuredOutputRequests()
+			if len(requests) != 1 {
+				t.Fatalf("%d classification calls, want 1", len(requests))
+			}
+			var prompted []string
+			for _, line := range strings.Split(strings.TrimSpace(requests[0].Messages[1].Content), "\n") {
+				prompted = append(prompted, strings.SplitN(line, ". ", 2)[1])
+			}
+			if strings.Join(prompted, ",") != strings.Join(tt.prompted, ",") {
+				t.Errorf("prompted %v, want %v", prompted, tt.prompted)
+			}
+
+			for content, want := range tt.want {
+				fragment := fragments[content]
+				if content != "input" {
+					if fragment, err = env.InteractionFragmentStore.GetByID(fragment.ID); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if got := fragment.Metadata.GetString(IntentKey); got != want {
+					t.Errorf("intent of %s = %q, want %q", content, got, want)
+				}
+			}
+		})
+	}
+}
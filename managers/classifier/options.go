@@ -0,0 +1,75 @@
+package classifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// WithSentimentLabels replaces the sentiment labels, positive, neutral and
+// negative by default
+func WithSentimentLabels(labels ...string) options.Option[ClassifierManager] {
+	return func(m *ClassifierManager) error {
+		normalized, err := normalizeLabels(labels)
+		if err != nil {
+			return fmt.Errorf("invalid sentiment labels: %w", err)
+		}
+		m.sentimentLabels = normalized
+		return nil
+	}
+}
+
+// WithIntentLabels replaces the intent labels, question, command, smalltalk and
+// complaint by default
+func WithIntentLabels(labels ...string) options.Option[ClassifierManager] {
+	return func(m *ClassifierManager) error {
+		normalized, err := normalizeLabels(labels)
+		if err != nil {
+			return fmt.Errorf("invalid intent labels: %w", err)
+		}
+		m.intentLabels = normalized
+		return nil
+	}
+}
+
+// WithBatchWindow classifies the input together with the user messages among
+// the last size messages of its session that aren't classified yet, in a
+// single call. Messages stored while the classifier was disabled or failing
+// are caught up this way at no extra call.
+func WithBatchWindow(size int) options.Option[ClassifierManager] {
+	return func(m *ClassifierManager) error {
+		if size <= 0 {
+			return fmt.Errorf("batch window must be positive")
+		}
+		m.batchWindow = size
+		return nil
+	}
+}
+
+// normalizeLabels lowercases and trims labels, rejecting empty and duplicate ones
+func normalizeLabels(labels []string) ([]string, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("at least one label is required")
+	}
+
+	normalized := make([]string, 0, len(labels))
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		label = normalizeLabel(label)
+		if label == "" {
+			return nil, fmt.Errorf("labels must not be empty")
+		}
+		if seen[label] {
+			return nil, fmt.Errorf("duplicate label %q", label)
+		}
+		seen[label] = true
+		normalized = append(normalized, label)
+	}
+	return normalized, nil
+}
+
+// normalizeLabel brings a configured or returned label to its canonical form
+func normalizeLabel(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
@@ -0,0 +1,76 @@
+package classifier
+
+import (
+	"github.com/velumlabs/thor/manager"
+)
+
+const (
+	// ClassifierManagerID identifies the classifier manager
+	ClassifierManagerID manager.ManagerID = "classifier"
+)
+
+var (
+	// ClassificationKey holds the classification of the input as a *Classification
+	// in the manager's context data, nil if the input could not be classified
+	ClassificationKey = manager.NamespacedKey(ClassifierManagerID, "classification")
+)
+
+// Metadata keys of classified fragments
+const (
+	SentimentKey           = "sentiment"            // Sentiment label of the fragment
+	SentimentConfidenceKey = "sentiment_confidence" // Confidence in the sentiment label, from 0 to 1
+	IntentKey              = "intent"               // Intent label of the fragment
+	IntentConfidenceKey    = "intent_confidence"    // Confidence in the intent label, from 0 to 1
+)
+
+// Default sentiment labels
+const (
+	SentimentPositive = "positive"
+	SentimentNeutral  = "neutral"
+	SentimentNegative = "negative"
+)
+
+// Default intent labels
+const (
+	IntentQuestion  = "question"
+	IntentCommand   = "command"
+	IntentSmalltalk = "smalltalk"
+	IntentComplaint = "complaint"
+)
+
+// Classification is the sentiment and intent of a message. A label is empty if
+// the model gave none from the taxonomy.
+type Classification struct {
+	Sentiment           string
+	SentimentConfidence float64
+	Intent              string
+	IntentConfidence    float64
+}
+
+// ClassifierManager tags user messages with a sentiment and an intent, so other
+// managers and prompts can branch on them
+type ClassifierManager struct {
+	*manager.BaseManager
+
+	// Label taxonomy; labels the model returns outside of it are dropped
+	sentimentLabels []string
+	intentLabels    []string
+
+	// Number of recent messages classified together with the input, 1
+	// classifies the input alone
+	batchWindow int
+}
+
+// classification is the structured output of a classification call
+type classification struct {
+	Messages []classifiedMessage `json:"messages" description:"One entry per numbered message"`
+}
+
+// classifiedMessage is the classification of a single numbered message
+type classifiedMessage struct {
+	Index               int     `json:"index" description:"Number of the message"`
+	Sentiment           string  `json:"sentiment" description:"Sentiment label of the message"`
+	SentimentConfidence float64 `json:"sentiment_confidence" description:"Confidence in the sentiment label, between 0 and 1"`
+	Intent              string  `json:"intent" description:"Intent label of the message"`
+	IntentConfidence    float64 `json:"intent_confidence" description:"Confidence in the intent label, between 0 and 1"`
+}
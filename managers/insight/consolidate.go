@@ -0,0 +1,78 @@
+package insight
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+const (
+	// consolidationBatchSize is the number of insights loaded at a time while consolidating
+	consolidationBatchSize = 100
+
+	// consolidationCandidates is the number of similar insights checked for each insight
+	consolidationCandidates = 10
+)
+
+// consolidate merges near-duplicate insights of each actor. Insights are visited
+// oldest first, and each absorbs the newer insights above the duplicate
+// threshold, which are then deleted.
+func (m *InsightManager) consolidate(ctx context.Context) error {
+	store := m.FragmentStore.WithContext(ctx)
+	merged := make(map[id.ID]bool)
+	count := 0
+
+	err := store.Iterate(consolidationBatchSize, func(insights []db.Fragment) error {
+		for _, insight := range insights {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if merged[insight.ID] || len(insight.Embedding.Slice()) == 0 {
+				continue
+			}
+
+			matches, err := store.FindSimilarForActor(insight.Embedding, insight.ActorID, consolidationCandidates)
+			if err != nil {
+				return err
+			}
+
+			changed := false
+			for _, match := range matches {
+				if match.ID == insight.ID || merged[match.ID] || match.Similarity < m.duplicateThreshold {
+					continue
+				}
+				// Newer insights are merged into older ones, never the other way round
+				if match.CreatedAt.Before(insight.CreatedAt) {
+					continue
+				}
+
+				insight.Metadata = mergeMetadata(insight.Metadata, match.Metadata)
+				if err := store.Delete(match.ID); err != nil {
+					return err
+				}
+				merged[match.ID] = true
+				changed = true
+				count++
+			}
+
+			if changed {
+				insight.UpdatedAt = time.Now()
+				if err := store.Upsert(&insight); err != nil {
+					return fmt.Errorf("failed to update insight: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to consolidate insights: %w", err)
+	}
+
+	if count > 0 {
+		m.Logger.WithField("merged", count).Info("Consolidated duplicate insights")
+	}
+	return nil
+}
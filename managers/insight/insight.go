@@ -0,0 +1,235 @@
+// Package insight provides a manager that extracts durable facts about users,
+// such as where they live or how they like to be answered, from their
+// conversations. Insights are stored as fragments in the store given with
+// manager.WithFragmentStore, normally the insight fragment table, and the ones
+// relevant to an input are provided to prompts under InsightsKey.
+package insight
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+const (
+	defaultRelevantLimit         = 5
+	defaultMinConfidence         = 0.6
+	defaultDuplicateThreshold    = 0.9
+	defaultConsolidationInterval = time.Hour
+
+	// consolidationTask names the background consolidation task
+	consolidationTask = "consolidate_insights"
+)
+
+// extractionPrompt instructs the model extracting insights from an exchange
+const extractionPrompt = `You extract durable facts about the user from a conversation exchange.
+Only include facts that are likely to stay true for weeks or longer, such as where the user lives,
+their job, their preferences or how they like to be answered. Ignore small talk, questions and
+anything about the assistant. Write each fact as a short statement starting with "The user".
+Return an empty list if there is nothing worth remembering.`
+
+// NewInsightManager creates a new InsightManager with the given base and insight options
+// The consolidation task is registered with RunPeriodic and runs while the
+// manager's background processes run
+func NewInsightManager(baseOpts []options.Option[manager.BaseManager], insightOpts ...options.Option[InsightManager]) (*InsightManager, error) {
+	// The fast model is the default; base options given by the caller take precedence
+	baseOpts = append([]options.Option[manager.BaseManager]{manager.WithDefaultModelType(llm.ModelTypeFast)}, baseOpts...)
+	base, err := manager.NewBaseManager(baseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &InsightManager{
+		BaseManager:           base,
+		relevantLimit:         defaultRelevantLimit,
+		minConfidence:         defaultMinConfidence,
+		duplicateThreshold:    defaultDuplicateThreshold,
+		consolidationInterval: defaultConsolidationInterval,
+	}
+	if err := options.ApplyOptions(m, insightOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create insight manager: %w", err)
+	}
+
+	if m.consolidationInterval > 0 {
+		if err := m.RunPeriodic(consolidationTask, m.consolidationInterval, m.consolidate); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// GetID returns the manager's identifier
+func (m *InsightManager) GetID() manager.ManagerID {
+	return InsightManagerID
+}
+
+// Capabilities declares that the manager takes part in PostProcess, Context and
+// the background, but not in Process
+func (m *InsightManager) Capabilities() []manager.Capability {
+	return []manager.Capability{
+		manager.CapabilityPostProcess,
+		manager.CapabilityContext,
+		manager.CapabilityBackground,
+	}
+}
+
+// ProvidedKeys declares the types of the relevant insights in the context data
+func (m *InsightManager) ProvidedKeys() []manager.StateDataContract {
+	return []manager.StateDataContract{
+		manager.TypedContract[[]db.Fragment](InsightsKey),
+	}
+}
+
+// Restore the context data of state snapshots with its type
+func init() {
+	state.RegisterDataType[[]db.Fragment](InsightsKey)
+}
+
+// PostProcess extracts insights from the latest exchange and stores the new ones.
+// Insights matching a stored one are merged into it instead.
+func (m *InsightManager) PostProcess(currentState *state.State) error {
+	input, output := currentState.Input, currentState.Output
+	if input == nil || output == nil || (input.Actor != nil && input.Actor.Assistant) {
+		return nil
+	}
+
+	_, err := m.RateLimit(input.SessionID, m.extractionInterval, func() error {
+		return m.extract(currentState)
+	})
+	return err
+}
+
+// extract extracts insights from the latest exchange and stores them
+func (m *InsightManager) extract(currentState *state.State) error {
+	input, output := currentState.Input, currentState.Output
+
+	var result extraction
+	if err := m.GenerateStructuredOutput(llm.StructuredOutputRequest{
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: extractionPrompt},
+			{Role: llm.RoleUser, Content: fmt.Sprintf("User: %s\nAssistant: %s", input.Content, output.Content)},
+		},
+		SchemaName:   "insights",
+		StrictSchema: true,
+	}, &result); err != nil {
+		return fmt.Errorf("failed to extract insights: %w", err)
+	}
+
+	store := m.FragmentStore.WithTx(currentState.Transaction())
+	for _, extracted := range result.Insights {
+		fact := strings.TrimSpace(extracted.Fact)
+		if fact == "" || extracted.Confidence < m.minConfidence {
+			continue
+		}
+		if err := m.remember(store, input, fact, math.Min(extracted.Confidence, 1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Context returns the stored insights about the input's actor most relevant to the input
+func (m *InsightManager) Context(currentState *state.State) ([]state.StateData, error) {
+	input := currentState.Input
+	if input == nil {
+		return nil, nil
+	}
+
+	embedding, err := m.embed(input)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := m.FragmentStore.WithContext(m.Ctx).FindSimilarForActor(embedding, input.ActorID, m.relevantLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load insights: %w", err)
+	}
+
+	insights := make([]db.Fragment, len(matches))
+	for i, match := range matches {
+		insights[i] = match.Fragment
+	}
+
+	return []state.StateData{{Key: InsightsKey, Value: insights}}, nil
+}
+
+// remember stores a fact about the input's actor, or reinforces the stored
+// insight it duplicates
+func (m *InsightManager) remember(store *stores.FragmentStore, input *db.Fragment, fact string, confidence float64) error {
+	vector, err := m.LLM.EmbedText(fact)
+	if err != nil {
+		return fmt.Errorf("failed to embed insight: %w", err)
+	}
+	embedding := pgvector.NewVector(vector)
+
+	matches, err := store.WithContext(m.Ctx).FindSimilarForActor(embedding, input.ActorID, 1)
+	if err != nil {
+		return fmt.Errorf("failed to search insights: %w", err)
+	}
+
+	if len(matches) > 0 && matches[0].Similarity >= m.duplicateThreshold {
+		existing := matches[0].Fragment
+		existing.Metadata = mergeMetadata(existing.Metadata, db.Metadata{
+			ConfidenceKey: confidence,
+			MentionsKey:   1.0,
+		})
+		existing.UpdatedAt = time.Now()
+		if err := store.Upsert(&existing); err != nil {
+			return fmt.Errorf("failed to update insight: %w", err)
+		}
+		return nil
+	}
+
+	if err := store.Create(&db.Fragment{
+		ID:        id.New(),
+		ActorID:   input.ActorID,
+		SessionID: input.SessionID,
+		Content:   fact,
+		Embedding: embedding,
+		Metadata: db.Metadata{
+			ConfidenceKey: confidence,
+			MentionsKey:   1.0,
+			SourceKey:     string(input.ID),
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to store insight: %w", err)
+	}
+	return nil
+}
+
+// embed returns the input's embedding, computing it if the input has none
+func (m *InsightManager) embed(input *db.Fragment) (pgvector.Vector, error) {
+	if len(input.Embedding.Slice()) > 0 {
+		return input.Embedding, nil
+	}
+	vector, err := m.LLM.EmbedText(input.Content)
+	if err != nil {
+		return pgvector.Vector{}, fmt.Errorf("failed to embed input: %w", err)
+	}
+	return pgvector.NewVector(vector), nil
+}
+
+// mergeMetadata combines the metadata of an insight with that of a duplicate:
+// the higher confidence is kept and the mentions are added up
+func mergeMetadata(into, from db.Metadata) db.Metadata {
+	merged := into.Clone()
+	if merged == nil {
+		merged = db.Metadata{}
+	}
+	merged[ConfidenceKey] = math.Max(merged.GetFloat(ConfidenceKey), from.GetFloat(ConfidenceKey))
+	merged[MentionsKey] = math.Max(merged.GetFloat(MentionsKey), 1) + math.Max(from.GetFloat(MentionsKey), 1)
+	return merged
+}
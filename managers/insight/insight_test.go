@@ -0,0 +1,119 @@
+package insight
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+)
+
+func TestInsightManagerConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[InsightManager]
+	}{
+		{name: "default"},
+		{name: "without consolidation", opts: []options.Option[InsightManager]{WithConsolidationInterval(0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m, err := NewInsightManager(env.BaseOptions(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			managertest.RunConformance(t, m)
+		})
+	}
+}
+
+func TestInsightManagerPostProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		extracted []extractedInsight
+		// want maps the stored insights to their mentions
+		want map[string]float64
+	}{
+		{
+			name:      "nothing to remember",
+			extracted: nil,
+			want:      map[string]float64{},
+		},
+		{
+			name: "stored",
+			extracted: []extractedInsight{
+				{Fact: "The user lives in Paris", Confidence: 0.9},
+				{Fact: "The user prefers short answers", Confidence: 0.8},
+			},
+			want: map[string]float64{"The user lives in Paris": 1, "The user prefers short answers": 1},
+		},
+		{
+			name: "low confidence and empty facts skipped",
+			extracted: []extractedInsight{
+				{Fact: "The user lives in Paris", Confidence: 0.9},
+				{Fact: "The user might like jazz", Confidence: 0.3},
+				{Fact: "  ", Confidence: 1},
+			},
+			want: map[string]float64{"The user lives in Paris": 1},
+		},
+		{
+			name: "duplicates merged",
+			extracted: []extractedInsight{
+				{Fact: "The user lives in Paris", Confidence: 0.7},
+				{Fact: "The user lives in Paris", Confidence: 0.9},
+			},
+			want: map[string]float64{"The user lives in Paris": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			env.Provider.StructuredOutputFunc = func(ctx context.Context, req llm.StructuredOutputRequest, result interface{}) error {
+				data, err := json.Marshal(extraction{Insights: tt.extracted})
+				if err != nil {
+					return err
+				}
+				return json.Unmarshal(data, result)
+			}
+			m, err := NewInsightManager(env.BaseOptions(), WithConsolidationInterval(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user := env.NewActor("Alice", false)
+			session := env.NewSession()
+			s := env.NewState(env.NewFragment(user, session, "I moved to Paris, keep it short"))
+			s.Output = env.NewFragment(env.Assistant, session, "Noted!")
+			if err := m.PostProcess(s); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := m.Context(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			insights := data[0].Value.([]db.Fragment)
+			got := make(map[string]float64, len(insights))
+			for _, insight := range insights {
+				got[insight.Content] = insight.Metadata.GetFloat(MentionsKey)
+				if insight.ActorID != user.ID {
+					t.Errorf("insight %q belongs to %s, want the user", insight.Content, insight.ActorID)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("insights = %v, want %v", got, tt.want)
+			}
+			for fact, mentions := range tt.want {
+				if got[fact] != mentions {
+					t.Errorf("mentions of %q = %v, want %v", fact, got[fact], mentions)
+				}
+			}
+		})
+	}
+}
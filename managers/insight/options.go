@@ -0,0 +1,68 @@
+package insight
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// WithRelevantLimit sets how many insights Context returns for an input
+func WithRelevantLimit(limit int) options.Option[InsightManager] {
+	return func(m *InsightManager) error {
+		if limit <= 0 {
+			return fmt.Errorf("relevant limit must be positive")
+		}
+		m.relevantLimit = limit
+		return nil
+	}
+}
+
+// WithMinConfidence sets the minimum confidence of an extracted insight to be stored
+func WithMinConfidence(confidence float64) options.Option[InsightManager] {
+	return func(m *InsightManager) error {
+		if confidence < 0 || confidence > 1 {
+			return fmt.Errorf("minimum confidence must be between 0 and 1")
+		}
+		m.minConfidence = confidence
+		return nil
+	}
+}
+
+// WithDuplicateThreshold sets the cosine similarity above which an insight is
+// treated as a duplicate of a stored one, both when extracting and consolidating
+func WithDuplicateThreshold(threshold float64) options.Option[InsightManager] {
+	return func(m *InsightManager) error {
+		if threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("duplicate threshold must be between 0 and 1")
+		}
+		m.duplicateThreshold = threshold
+		return nil
+	}
+}
+
+// WithConsolidationInterval sets how often near-duplicate insights are merged
+// in the background. Zero disables consolidation.
+func WithConsolidationInterval(interval time.Duration) options.Option[InsightManager] {
+	return func(m *InsightManager) error {
+		if interval < 0 {
+			return fmt.Errorf("consolidation interval must not be negative")
+		}
+		m.consolidationInterval = interval
+		return nil
+	}
+}
+
+// WithExtractionRateLimit extracts insights at most once per interval in each
+// session, skipping the exchanges in between, so fast-moving chats don't run
+// an extraction on every message. Skipped extractions are counted in the
+// manager's throttle stats.
+func WithExtractionRateLimit(interval time.Duration) options.Option[InsightManager] {
+	return func(m *InsightManager) error {
+		if interval < 0 {
+			return fmt.Errorf("extraction interval must not be negative")
+		}
+		m.extractionInterval = interval
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package insight
+
+import (
+	"time"
+
+	"github.com/velumlabs/thor/manager"
+)
+
+const (
+	// InsightManagerID identifies the insight manager
+	InsightManagerID manager.ManagerID = "insight"
+)
+
+var (
+	// InsightsKey holds the stored insights about the input's actor most relevant
+	// to the input, most relevant first, as []db.Fragment in the manager's context data
+	InsightsKey = manager.NamespacedKey(InsightManagerID, "insights")
+)
+
+// Metadata keys of stored insight fragments
+const (
+	ConfidenceKey = "confidence"      // Confidence of the insight, between 0 and 1
+	MentionsKey   = "mentions"        // Number of times the insight was extracted or merged
+	SourceKey     = "source_fragment" // ID of the input the insight was first extracted from
+)
+
+// InsightManager extracts durable facts about users from their conversations,
+// stores them as insight fragments and provides the relevant ones to prompts
+type InsightManager struct {
+	*manager.BaseManager
+
+	// Number of insights Context returns
+	relevantLimit int
+	// Minimum time between two extractions in a session, 0 extracts on every exchange
+	extractionInterval time.Duration
+	// Minimum confidence of an extracted insight to be stored
+	minConfidence float64
+	// Similarity above which two insights are considered the same fact
+	duplicateThreshold float64
+	// Interval of the background consolidation of near-duplicate insights, 0 disables it
+	consolidationInterval time.Duration
+}
+
+// extraction is the structured output of the insight extraction
+type extraction struct {
+	Insights []extractedInsight `json:"insights" description:"Durable facts about the user"`
+}
+
+// extractedInsight is a single extracted fact
+type extractedInsight struct {
+	Fact       string  `json:"fact" description:"A short, self-contained statement about the user"`
+	Confidence float64 `json:"confidence" description:"Confidence that the fact is true and lasting, between 0 and 1"`
+}
@@ -0,0 +1,230 @@
+// Package memory provides a manager that loads the conversation memory of each
+// input into the state: the most recent fragments of its session and the past
+// fragments most similar to it.
+//
+// It is also the reference implementation of a manager: it embeds
+// manager.BaseManager, takes functional options, declares the phases it takes
+// part in and exposes its results through Context under documented keys.
+//
+//	memoryManager, err := memory.NewMemoryManager(
+//	    []options.Option[manager.BaseManager]{
+//	        manager.WithContext(ctx),
+//	        manager.WithLogger(log),
+//	        manager.WithLLM(llmClient),
+//	        manager.WithFragmentStore(fragmentStore),
+//	        manager.WithInteractionFragmentStore(interactionStore),
+//	        manager.WithActorStore(actorStore),
+//	        manager.WithSessionStore(sessionStore),
+//	        manager.WithAssistantDetails("Thor", assistantID),
+//	    },
+//	    memory.WithRecentLimit(20),
+//	    memory.WithRelevantLimit(5),
+//	    memory.WithSimilarityThreshold(0.75),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//
+//	// The recent interactions are then available to prompts
+//	builder.WithManagerData(memory.RecentInteractionsKey)
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+const (
+	defaultRecentLimit   = 20
+	defaultRelevantLimit = 5
+	defaultThreshold     = 0.7
+
+	// relevantCandidates is how many more candidates than needed are fetched
+	// when recency reranks the similarity search
+	relevantCandidates = 4
+)
+
+// NewMemoryManager creates a new MemoryManager with the given base and memory options
+// By default it loads the 20 most recent fragments and the 5 most similar past
+// fragments of any session with a similarity of at least 0.7
+func NewMemoryManager(baseOpts []options.Option[manager.BaseManager], memoryOpts ...options.Option[MemoryManager]) (*MemoryManager, error) {
+	base, err := manager.NewBaseManager(baseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MemoryManager{
+		BaseManager:   base,
+		recentLimit:   defaultRecentLimit,
+		relevantLimit: defaultRelevantLimit,
+		threshold:     defaultThreshold,
+		crossSession:  true,
+	}
+	if err := options.ApplyOptions(m, memoryOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create memory manager: %w", err)
+	}
+
+	return m, nil
+}
+
+// GetID returns the manager's identifier
+func (m *MemoryManager) GetID() manager.ManagerID {
+	return MemoryManagerID
+}
+
+// Capabilities declares that the manager only takes part in Process and Context
+func (m *MemoryManager) Capabilities() []manager.Capability {
+	return []manager.Capability{manager.CapabilityProcess, manager.CapabilityContext}
+}
+
+// ProvidedKeys declares the types of the interactions it loads in the context data
+func (m *MemoryManager) ProvidedKeys() []manager.StateDataContract {
+	return []manager.StateDataContract{
+		manager.TypedContract[[]db.Fragment](RecentInteractionsKey),
+		manager.TypedContract[[]db.Fragment](RelevantInteractionsKey),
+	}
+}
+
+// Restore the context data of state snapshots with its type
+func init() {
+	state.RegisterDataType[[]db.Fragment](RecentInteractionsKey)
+	state.RegisterDataType[[]db.Fragment](RelevantInteractionsKey)
+}
+
+// Process loads the recent and relevant interactions of the input into the state
+func (m *MemoryManager) Process(ctx context.Context, currentState *state.State) error {
+	input := currentState.Input
+	if input == nil {
+		return nil
+	}
+
+	var recent []db.Fragment
+	if m.recentLimit > 0 {
+		var err error
+		recent, err = m.InteractionFragmentStore.WithContext(ctx).GetSessionHistory(input.SessionID, stores.HistoryQuery{
+			Limit:        m.recentLimit,
+			PreloadActor: true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load recent interactions: %w", err)
+		}
+	}
+
+	var relevant []db.Fragment
+	if m.relevantLimit > 0 {
+		var err error
+		relevant, err = m.relevant(ctx, input, recent)
+		if err != nil {
+			return err
+		}
+	}
+
+	currentState.SetRecentInteractions(recent).SetRelevantInteractions(relevant)
+	return nil
+}
+
+// Context exposes the loaded interactions under RecentInteractionsKey and RelevantInteractionsKey
+func (m *MemoryManager) Context(currentState *state.State) ([]state.StateData, error) {
+	return []state.StateData{
+		{Key: RecentInteractionsKey, Value: currentState.GetRecentInteractions()},
+		{Key: RelevantInteractionsKey, Value: currentState.GetRelevantInteractions()},
+	}, nil
+}
+
+// relevant returns the past fragments most similar to the input, leaving out
+// the input itself and the fragments already loaded as recent interactions
+func (m *MemoryManager) relevant(ctx context.Context, input *db.Fragment, recent []db.Fragment) ([]db.Fragment, error) {
+	embedding := input.Embedding
+	if len(embedding.Slice()) == 0 {
+		vector, err := m.LLM.WithContext(ctx).EmbedText(input.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed input: %w", err)
+		}
+		embedding = pgvector.NewVector(vector)
+	}
+
+	var sessionID id.ID
+	if !m.crossSession {
+		sessionID = input.SessionID
+	}
+
+	exclude := make(map[id.ID]bool, len(recent)+1)
+	exclude[input.ID] = true
+	for _, fragment := range recent {
+		exclude[fragment.ID] = true
+	}
+
+	limit := m.relevantLimit + len(exclude)
+	if m.recencyWeight > 0 {
+		limit = m.relevantLimit*relevantCandidates + len(exclude)
+	}
+
+	matches, err := m.InteractionFragmentStore.WithContext(ctx).FindSimilar(embedding, sessionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load relevant interactions: %w", err)
+	}
+
+	type candidate struct {
+		fragment db.Fragment
+		score    float64
+	}
+	now := time.Now()
+	candidates := make([]candidate, 0, len(matches))
+	for _, match := range matches {
+		if match.Similarity < m.threshold || exclude[match.ID] {
+			continue
+		}
+
+		fragment := match.Fragment
+		metadata := fragment.Metadata.Clone()
+		if metadata == nil {
+			metadata = make(db.Metadata, 1)
+		}
+		metadata[SimilarityKey] = match.Similarity
+		fragment.Metadata = metadata
+
+		candidates = append(candidates, candidate{
+			fragment: fragment,
+			score:    m.score(match.Similarity, now.Sub(fragment.CreatedAt)),
+		})
+	}
+
+	// Matches arrive by similarity, so the order only changes with recency weighting
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > m.relevantLimit {
+		candidates = candidates[:m.relevantLimit]
+	}
+
+	fragments := make([]db.Fragment, len(candidates))
+	for i, c := range candidates {
+		fragments[i] = c.fragment
+	}
+	return fragments, nil
+}
+
+// score blends the similarity of a fragment with its recency, which decays
+// exponentially with the fragment's age
+func (m *MemoryManager) score(similarity float64, age time.Duration) float64 {
+	if m.recencyWeight == 0 {
+		return similarity
+	}
+	if age < 0 {
+		age = 0
+	}
+	recency := math.Exp2(-float64(age) / float64(m.recencyHalfLife))
+	return (1-m.recencyWeight)*similarity + m.recencyWeight*recency
+}
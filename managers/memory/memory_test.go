@@ -0,0 +1,345 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// newTestManager returns a memory manager wired to env
+func newTestManager(t *testing.T, env *managertest.TestEnvironment, opts ...options.Option[MemoryManager]) *MemoryManager {
+	t.Helper()
+	m, err := NewMemoryManager(env.BaseOptions(), opts...)
+	if err != nil {
+		t.Fatalf("failed to create memory manager: %v", err)
+	}
+	return m
+}
+
+// newFragmentAt creates a fragment of an actor in a session, created at createdAt
+func newFragmentAt(t *testing.T, env *managertest.TestEnvironment, actor *db.Actor, session *db.Session, content string, createdAt time.Time) *db.Fragment {
+	t.Helper()
+	fragment := &db.Fragment{
+		ID:        id.New(),
+		ActorID:   actor.ID,
+		SessionID: session.ID,
+		Content:   content,
+		Metadata:  db.Metadata{},
+		Embedding: pgvector.NewVector(llm.MockEmbedding(content)),
+		CreatedAt: createdAt,
+	}
+	if err := env.InteractionFragmentStore.Create(fragment); err != nil {
+		t.Fatalf("failed to create fragment: %v", err)
+	}
+	fragment.Actor = actor
+	fragment.Session = session
+	return fragment
+}
+
+// contents returns the contents of fragments
+func contents(fragments []db.Fragment) []string {
+	result := make([]string, len(fragments))
+	for i, fragment := range fragments {
+		result[i] = fragment.Content
+	}
+	return result
+}
+
+func TestMemoryManagerConformance(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	managertest.RunConformance(t, newTestManager(t, env))
+}
+
+func TestMemoryManagerRecent(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[MemoryManager]
+		want []string
+	}{
+		{
+			name: "default",
+			want: []string{"first", "second", "third", "input"},
+		},
+		{
+			name: "limited",
+			opts: []options.Option[MemoryManager]{WithRecentLimit(2)},
+			want: []string{"third", "input"},
+		},
+		{
+			name: "disabled",
+			opts: []options.Option[MemoryManager]{WithRecentLimit(0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			user := env.NewActor("Alice", false)
+			session, other := env.NewSession(), env.NewSession()
+
+			start := time.Now().Add(-time.Hour)
+			for i, content := range []string{"first", "second", "third"} {
+				newFragmentAt(t, env, user, session, content, start.Add(time.Duration(i)*time.Minute))
+			}
+			newFragmentAt(t, env, user, other, "elsewhere", start)
+			input := newFragmentAt(t, env, user, session, "input", start.Add(time.Hour))
+
+			m := newTestManager(t, env, append(tt.opts, WithRelevantLimit(0))...)
+			s := env.NewState(input)
+			if err := m.Process(context.Background(), s); err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(s.GetRecentInteractions()); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("recent interactions = %v, want %v", got, tt.want)
+			}
+			for _, fragment := range s.GetRecentInteractions() {
+				if fragment.Actor == nil {
+					t.Errorf("recent interaction %q has no actor", fragment.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryManagerRelevant(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[MemoryManager]
+		want []string
+	}{
+		{
+			name: "cross session",
+			want: []string{"current session", "past session"},
+		},
+		{
+			name: "input session only",
+			opts: []options.Option[MemoryManager]{WithCrossSession(false)},
+			want: []string{"current session"},
+		},
+		{
+			name: "disabled",
+			opts: []options.Option[MemoryManager]{WithRelevantLimit(0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			user := env.NewActor("Alice", false)
+			session, past := env.NewSession(), env.NewSession()
+
+			// Equal contents have equal embeddings, so the fragments asking what the
+			// input asks are the relevant ones; neither is among the recent ones
+			start := time.Now().Add(-time.Hour)
+			current := newFragmentAt(t, env, user, session, "What is Thor?", start)
+			current.Metadata["label"] = "current session"
+			if err := env.InteractionFragmentStore.Upsert(current); err != nil {
+				t.Fatal(err)
+			}
+			newFragmentAt(t, env, user, session, "Unrelated chatter", start.Add(time.Minute))
+			older := newFragmentAt(t, env, user, past, "What is Thor?", start.Add(-time.Hour))
+			older.Metadata["label"] = "past session"
+			if err := env.InteractionFragmentStore.Upsert(older); err != nil {
+				t.Fatal(err)
+			}
+			newFragmentAt(t, env, user, past, "Something else", start)
+			input := newFragmentAt(t, env, user, session, "What is Thor?", start.Add(time.Hour))
+
+			m := newTestManager(t, env, append([]options.Option[MemoryManager]{WithRecentLimit(2)}, tt.opts...)...)
+			s := env.NewState(input)
+			if err := m.Process(context.Background(), s); err != nil {
+				t.Fatal(err)
+			}
+
+			relevant := s.GetRelevantInteractions()
+			got := make([]string, len(relevant))
+			for i, fragment := range relevant {
+				got[i], _ = fragment.Metadata["label"].(string)
+				if similarity, ok := fragment.Metadata[SimilarityKey].(float64); !ok || similarity < 0.99 {
+					t.Errorf("similarity of %q = %v, want about 1", got[i], fragment.Metadata[SimilarityKey])
+				}
+			}
+			// Both are equally similar, so their order is unspecified
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("relevant interactions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryManagerThreshold(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	user := env.NewActor("Alice", false)
+	newFragmentAt(t, env, user, env.NewSession(), "Something else entirely", time.Now().Add(-time.Hour))
+	input := env.NewFragment(user, env.NewSession(), "What is Thor?")
+
+	// Unrelated mock embeddings are nearly orthogonal, far below the default threshold
+	m := newTestManager(t, env)
+	s := env.NewState(input)
+	if err := m.Process(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetRelevantInteractions(); len(got) != 0 {
+		t.Errorf("relevant interactions = %v, want none above the threshold", contents(got))
+	}
+
+	// Without a threshold anything similar at all qualifies
+	m = newTestManager(t, env, WithSimilarityThreshold(-1))
+	if err := m.Process(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(s.GetRelevantInteractions()); len(got) != 1 {
+		t.Errorf("relevant interactions = %v, want the other fragment", got)
+	}
+}
+
+func TestMemoryManagerEmbedsInput(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	user := env.NewActor("Alice", false)
+	newFragmentAt(t, env, user, env.NewSession(), "What is Thor?", time.Now().Add(-time.Hour))
+
+	input := env.NewFragment(user, env.NewSession(), "What is Thor?")
+	input.Embedding = pgvector.Vector{}
+
+	m := newTestManager(t, env, WithRecentLimit(0))
+	s := env.NewState(input)
+	if err := m.Process(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.Provider.EmbeddedTexts(); len(got) != 1 || got[0] != "What is Thor?" {
+		t.Errorf("embedded texts = %q, want the input", got)
+	}
+	if got := contents(s.GetRelevantInteractions()); len(got) != 1 {
+		t.Errorf("relevant interactions = %v, want the past fragment", got)
+	}
+}
+
+func TestMemoryManagerRecencyWeighting(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	user := env.NewActor("Alice", false)
+	now := time.Now()
+	newFragmentAt(t, env, user, env.NewSession(), "What is Thor?", now.Add(-30*24*time.Hour))
+	// Less similar but recent
+	newFragmentAt(t, env, user, env.NewSession(), "What is Thor? Asking again", now.Add(-time.Minute))
+	input := env.NewFragment(user, env.NewSession(), "What is Thor?")
+
+	tests := []struct {
+		name string
+		opts []options.Option[MemoryManager]
+		want string
+	}{
+		{name: "similarity only", want: "What is Thor?"},
+		{
+			name: "recency weighted",
+			opts: []options.Option[MemoryManager]{WithRecencyWeighting(0.9, time.Hour)},
+			want: "What is Thor? Asking again",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, env, append([]options.Option[MemoryManager]{WithSimilarityThreshold(-1), WithRelevantLimit(1)}, tt.opts...)...)
+			s := env.NewState(input)
+			if err := m.Process(context.Background(), s); err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(s.GetRelevantInteractions()); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("relevant interactions = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryManagerScore(t *testing.T) {
+	tests := []struct {
+		name       string
+		weight     float64
+		similarity float64
+		age        time.Duration
+		want       float64
+	}{
+		{name: "unweighted", similarity: 0.8, age: time.Hour, want: 0.8},
+		{name: "new", weight: 0.5, similarity: 0.8, want: 0.9},
+		{name: "one half-life", weight: 0.5, similarity: 0.8, age: time.Hour, want: 0.65},
+		{name: "future", weight: 0.5, similarity: 0.8, age: -time.Hour, want: 0.9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MemoryManager{recencyWeight: tt.weight, recencyHalfLife: time.Hour}
+			if got := m.score(tt.similarity, tt.age); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryManagerContext(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	user := env.NewActor("Alice", false)
+	session := env.NewSession()
+	env.NewFragment(user, session, "Earlier")
+	input := env.NewFragment(user, session, "Hello")
+
+	m := newTestManager(t, env)
+	s := env.NewState(input)
+	if err := m.Process(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Context(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]interface{}, len(data))
+	for _, d := range data {
+		values[string(d.Key)] = d.Value
+	}
+	recent, ok := values[string(RecentInteractionsKey)].([]db.Fragment)
+	if !ok || len(recent) != 2 {
+		t.Errorf("%s = %v, want both fragments of the session", RecentInteractionsKey, values[string(RecentInteractionsKey)])
+	}
+	if _, ok := values[string(RelevantInteractionsKey)].([]db.Fragment); !ok {
+		t.Errorf("%s = %v, want []db.Fragment", RelevantInteractionsKey, values[string(RelevantInteractionsKey)])
+	}
+}
+
+func TestMemoryManagerOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   options.Option[MemoryManager]
+		valid bool
+	}{
+		{name: "recent limit", opt: WithRecentLimit(10), valid: true},
+		{name: "negative recent limit", opt: WithRecentLimit(-1)},
+		{name: "relevant limit", opt: WithRelevantLimit(3), valid: true},
+		{name: "negative relevant limit", opt: WithRelevantLimit(-1)},
+		{name: "threshold", opt: WithSimilarityThreshold(0.5), valid: true},
+		{name: "threshold above 1", opt: WithSimilarityThreshold(1.5)},
+		{name: "recency weighting", opt: WithRecencyWeighting(0.3, time.Hour), valid: true},
+		{name: "recency weight above 1", opt: WithRecencyWeighting(1.5, time.Hour)},
+		{name: "no half-life", opt: WithRecencyWeighting(0.3, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			_, err := NewMemoryManager(env.BaseOptions(), tt.opt)
+			if (err == nil) != tt.valid {
+				t.Errorf("NewMemoryManager = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
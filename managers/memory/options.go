@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// WithRecentLimit sets how many of the session's most recent fragments are loaded
+// Zero disables recent interactions
+func WithRecentLimit(limit int) options.Option[MemoryManager] {
+	return func(m *MemoryManager) error {
+		if limit < 0 {
+			return fmt.Errorf("recent limit must not be negative")
+		}
+		m.recentLimit = limit
+		return nil
+	}
+}
+
+// WithRelevantLimit sets how many fragments relevant to the input are loaded
+// Zero disables relevant interactions
+func WithRelevantLimit(limit int) options.Option[MemoryManager] {
+	return func(m *MemoryManager) error {
+		if limit < 0 {
+			return fmt.Errorf("relevant limit must not be negative")
+		}
+		m.relevantLimit = limit
+		return nil
+	}
+}
+
+// WithSimilarityThreshold sets the minimum cosine similarity of relevant fragments
+func WithSimilarityThreshold(threshold float64) options.Option[MemoryManager] {
+	return func(m *MemoryManager) error {
+		if threshold < -1 || threshold > 1 {
+			return fmt.Errorf("similarity threshold must be between -1 and 1")
+		}
+		m.threshold = threshold
+		return nil
+	}
+}
+
+// WithCrossSession sets whether relevant fragments are searched in all sessions
+// or only in the input's session
+func WithCrossSession(crossSession bool) options.Option[MemoryManager] {
+	return func(m *MemoryManager) error {
+		m.crossSession = crossSession
+		return nil
+	}
+}
+
+// WithRecencyWeighting ranks relevant fragments by a blend of similarity and
+// recency. weight is the share of recency in the score, between 0 and 1, and
+// halfLife the age at which a fragment's recency score halves.
+func WithRecencyWeighting(weight float64, halfLife time.Duration) options.Option[MemoryManager] {
+	return func(m *MemoryManager) error {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("recency weight must be between 0 and 1")
+		}
+		if halfLife <= 0 {
+			return fmt.Errorf("recency half-life must be positive")
+		}
+		m.recencyWeight = weight
+		m.recencyHalfLife = halfLife
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/velumlabs/thor/manager"
+)
+
+const (
+	// MemoryManagerID identifies the memory manager
+	MemoryManagerID manager.ManagerID = "memory"
+)
+
+var (
+	// RecentInteractionsKey holds the session's most recent fragments, oldest
+	// first, as []db.Fragment in the manager's context data
+	RecentInteractionsKey = manager.NamespacedKey(MemoryManagerID, "recent_interactions")
+
+	// RelevantInteractionsKey holds the fragments most relevant to the input,
+	// most relevant first, as []db.Fragment in the manager's context data.
+	// Each fragment carries its similarity under the "similarity" metadata key.
+	RelevantInteractionsKey = manager.NamespacedKey(MemoryManagerID, "relevant_interactions")
+)
+
+// SimilarityKey is the fragment metadata key holding the cosine similarity of a
+// relevant fragment to the input
+const SimilarityKey = "similarity"
+
+// MemoryManager loads the conversation memory of each input: the most recent
+// fragments of its session and the past fragments most similar to it
+type MemoryManager struct {
+	*manager.BaseManager
+
+	// Number of recent fragments of the session to load, 0 disables them
+	recentLimit int
+
+	// Number of relevant fragments to load, 0 disables them
+	relevantLimit int
+	// Minimum cosine similarity of a relevant fragment
+	threshold float64
+	// Search all sessions rather than only the input's session
+	crossSession bool
+
+	// Weight of recency against similarity when ranking relevant fragments,
+	// between 0 (similarity only) and 1, and the age at which recency halves
+	recencyWeight   float64
+	recencyHalfLife time.Duration
+}
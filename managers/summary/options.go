@@ -0,0 +1,46 @@
+package summary
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// WithExchangeInterval sets after how many new exchanges, an input and its
+// response, the session summary is updated
+func WithExchangeInterval(exchanges int) options.Option[SummaryManager] {
+	return func(m *SummaryManager) error {
+		if exchanges <= 0 {
+			return fmt.Errorf("exchange interval must be positive")
+		}
+		m.exchangeInterval = exchanges
+		return nil
+	}
+}
+
+// WithTokenThreshold updates the session summary as soon as the new turns are
+// estimated to exceed the given number of tokens, even before the exchange
+// interval is reached. Zero disables the threshold.
+func WithTokenThreshold(tokens int) options.Option[SummaryManager] {
+	return func(m *SummaryManager) error {
+		if tokens < 0 {
+			return fmt.Errorf("token threshold must not be negative")
+		}
+		m.tokenThreshold = tokens
+		return nil
+	}
+}
+
+// WithBackgroundSummarization moves summarization out of PostProcess: sessions
+// with new turns are summarized by a periodic task running every interval while
+// the manager's background processes run
+func WithBackgroundSummarization(interval time.Duration) options.Option[SummaryManager] {
+	return func(m *SummaryManager) error {
+		if interval <= 0 {
+			return fmt.Errorf("background interval must be positive")
+		}
+		m.backgroundInterval = interval
+		return nil
+	}
+}
@@ -0,0 +1,318 @@
+// Package summary provides a manager that keeps a rolling summary of each
+// session. Every few exchanges, or once the new turns grow too long, the new
+// turns are folded into the previous summary by the fast model, and the result
+// is stored as a single fragment per session in the store given with
+// manager.WithFragmentStore, tagged with the "session_summary" type.
+//
+// Prompts can include the summary under SummaryKey in place of the full history:
+//
+//	builder.WithManagerData(summary.SummaryKey)
+//
+// Summarization runs inline in PostProcess by default, or in the background
+// with WithBackgroundSummarization.
+package summary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultExchangeInterval = 10
+	defaultTokenThreshold   = 4000
+
+	// charsPerToken is the rough number of characters per token used to
+	// estimate the length of new turns
+	charsPerToken = 4
+
+	// summarizationTask names the background summarization task
+	summarizationTask = "summarize_sessions"
+)
+
+// summaryPrompt instructs the model updating a session summary
+const summaryPrompt = `You maintain a running summary of a conversation. Update the previous summary
+with the new turns. Keep names, facts, decisions, open questions and commitments; drop greetings and
+small talk. Write in the third person and keep the summary under 300 words. Reply with the updated
+summary only.`
+
+// NewSummaryManager creates a new SummaryManager with the given base and summary options
+// By default it summarizes inline every 10 exchanges, or once the new turns
+// exceed an estimated 4000 tokens
+func NewSummaryManager(baseOpts []options.Option[manager.BaseManager], summaryOpts ...options.Option[SummaryManager]) (*SummaryManager, error) {
+	// The fast model is the default; base options given by the caller take precedence
+	baseOpts = append([]options.Option[manager.BaseManager]{manager.WithDefaultModelType(llm.ModelTypeFast)}, baseOpts...)
+	base, err := manager.NewBaseManager(baseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SummaryManager{
+		BaseManager:      base,
+		exchangeInterval: defaultExchangeInterval,
+		tokenThreshold:   defaultTokenThreshold,
+		pending:          make(map[id.ID]id.ID),
+	}
+	if err := options.ApplyOptions(m, summaryOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create summary manager: %w", err)
+	}
+
+	if m.backgroundInterval > 0 {
+		if err := m.RunPeriodic(summarizationTask, m.backgroundInterval, m.summarizePending); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// GetID returns the manager's identifier
+func (m *SummaryManager) GetID() manager.ManagerID {
+	return SummaryManagerID
+}
+
+// Capabilities declares that the manager takes part in PostProcess and Context,
+// and in the background when summarizing there
+func (m *SummaryManager) Capabilities() []manager.Capability {
+	capabilities := []manager.Capability{manager.CapabilityPostProcess, manager.CapabilityContext}
+	if m.backgroundInterval > 0 {
+		capabilities = append(capabilities, manager.CapabilityBackground)
+	}
+	return capabilities
+}
+
+// ProvidedKeys declares the types of the session summary in the context data
+func (m *SummaryManager) ProvidedKeys() []manager.StateDataContract {
+	return []manager.StateDataContract{
+		manager.TypedContract[string](SummaryKey),
+	}
+}
+
+// Restore the context data of state snapshots with its type
+func init() {
+	state.RegisterDataType[string](SummaryKey)
+}
+
+// PostProcess updates the summary of the input's session if it is due, or
+// queues the session for the background summarization
+func (m *SummaryManager) PostProcess(currentState *state.State) error {
+	input := currentState.Input
+	if input == nil {
+		return nil
+	}
+
+	assistantID := m.AssistantID
+	if currentState.Assistant != nil {
+		assistantID = currentState.Assistant.ID
+	}
+
+	if m.backgroundInterval > 0 {
+		m.pendingMu.Lock()
+		m.pending[input.SessionID] = assistantID
+		m.pendingMu.Unlock()
+		return nil
+	}
+
+	return m.summarize(m.Ctx, input.SessionID, assistantID, currentState.Transaction())
+}
+
+// Context returns the current summary of the input's session
+func (m *SummaryManager) Context(currentState *state.State) ([]state.StateData, error) {
+	input := currentState.Input
+	if input == nil {
+		return nil, nil
+	}
+
+	summary, err := m.GetSummary(input.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session summary: %w", err)
+	}
+
+	content := ""
+	if summary != nil {
+		content = summary.Content
+	}
+	return []state.StateData{{Key: SummaryKey, Value: content}}, nil
+}
+
+// GetSummary returns the stored summary fragment of a session, or nil if the
+// session has not been summarized yet
+func (m *SummaryManager) GetSummary(sessionID id.ID) (*db.Fragment, error) {
+	summary, err := m.FragmentStore.WithContext(m.Ctx).GetLatestByMetadata(sessionID, TypeKey, SummaryType)
+	if errors.Is(err, stores.ErrNotFound) {
+		return nil, nil
+	}
+	return summary, err
+}
+
+// summarizePending summarizes the sessions queued by PostProcess. Sessions that
+// fail are queued again for the next run.
+func (m *SummaryManager) summarizePending(ctx context.Context) error {
+	m.pendingMu.Lock()
+	pending := m.pending
+	m.pending = make(map[id.ID]id.ID)
+	m.pendingMu.Unlock()
+
+	var failures []error
+	for sessionID, assistantID := range pending {
+		if ctx.Err() != nil {
+			m.requeue(sessionID, assistantID)
+			continue
+		}
+		if err := m.summarize(ctx, sessionID, assistantID, nil); err != nil {
+			m.requeue(sessionID, assistantID)
+			failures = append(failures, fmt.Errorf("session %s: %w", sessionID, err))
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+// requeue queues a session for the next background run unless a newer input queued it already
+func (m *SummaryManager) requeue(sessionID, assistantID id.ID) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if _, ok := m.pending[sessionID]; !ok {
+		m.pending[sessionID] = assistantID
+	}
+}
+
+// summarize folds the turns of a session since its last summary into the
+// summary if enough of them have accumulated. A session already being
+// summarized is skipped, since that run or the next one covers the new turns.
+func (m *SummaryManager) summarize(ctx context.Context, sessionID, assistantID id.ID, tx *gorm.DB) error {
+	lock := m.sessionLock(sessionID)
+	if !lock.TryLock() {
+		return nil
+	}
+	defer lock.Unlock()
+
+	store := m.FragmentStore.WithContext(ctx).WithTx(tx)
+	current, err := store.GetLatestByMetadata(sessionID, TypeKey, SummaryType)
+	if err != nil && !errors.Is(err, stores.ErrNotFound) {
+		return fmt.Errorf("failed to load session summary: %w", err)
+	}
+
+	var coveredUntil time.Time
+	if current != nil {
+		coveredUntil, _ = time.Parse(time.RFC3339Nano, current.Metadata.GetString(CoveredUntilKey))
+	}
+
+	turns, err := m.InteractionFragmentStore.WithContext(ctx).WithTx(tx).GetSessionHistory(sessionID, stores.HistoryQuery{
+		After:        coveredUntil,
+		PreloadActor: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load new turns: %w", err)
+	}
+	if !m.due(turns) {
+		return nil
+	}
+
+	previous := ""
+	if current != nil {
+		previous = current.Content
+	}
+	content, err := m.generateSummary(previous, turns)
+	if err != nil {
+		return err
+	}
+
+	summary := current
+	if summary == nil {
+		summary = &db.Fragment{
+			ID:        id.New(),
+			ActorID:   assistantID,
+			SessionID: sessionID,
+		}
+	}
+	metadata := summary.Metadata.Clone()
+	if metadata == nil {
+		metadata = db.Metadata{}
+	}
+	metadata[TypeKey] = SummaryType
+	metadata[CoveredUntilKey] = turns[len(turns)-1].CreatedAt.Format(time.RFC3339Nano)
+	metadata[TurnsKey] = metadata.GetFloat(TurnsKey) + float64(len(turns))
+
+	summary.Content = content
+	summary.Metadata = metadata
+	summary.UpdatedAt = time.Now()
+	summary.Actor, summary.Session = nil, nil
+
+	if err := store.Upsert(summary); err != nil {
+		return fmt.Errorf("failed to store session summary: %w", err)
+	}
+	return nil
+}
+
+// due reports whether the new turns warrant updating the summary: enough
+// exchanges have happened or they are estimated to exceed the token threshold
+func (m *SummaryManager) due(turns []db.Fragment) bool {
+	if len(turns) == 0 {
+		return false
+	}
+
+	exchanges, chars := 0, 0
+	for _, turn := range turns {
+		if turn.Actor != nil && turn.Actor.Assistant {
+			exchanges++
+		}
+		chars += len(turn.Content)
+	}
+
+	if exchanges >= m.exchangeInterval {
+		return true
+	}
+	return m.tokenThreshold > 0 && chars/charsPerToken >= m.tokenThreshold
+}
+
+// generateSummary asks the fast model to fold the turns into the previous summary
+func (m *SummaryManager) generateSummary(previous string, turns []db.Fragment) (string, error) {
+	var transcript strings.Builder
+	for _, turn := range turns {
+		name := "Unknown"
+		if turn.Actor != nil {
+			name = turn.Actor.Name
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", name, turn.Content)
+	}
+
+	if previous == "" {
+		previous = "(none)"
+	}
+
+	response, err := m.GenerateCompletion(llm.CompletionRequest{
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: summaryPrompt},
+			{Role: llm.RoleUser, Content: fmt.Sprintf("Previous summary:\n%s\n\nNew turns:\n%s", previous, transcript.String())},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session summary: %w", err)
+	}
+
+	content := strings.TrimSpace(response.Content)
+	if content == "" {
+		return "", fmt.Errorf("failed to generate session summary: empty response")
+	}
+	return content, nil
+}
+
+// sessionLock returns the lock serializing the summarization of a session
+func (m *SummaryManager) sessionLock(sessionID id.ID) *sync.Mutex {
+	lock, _ := m.sessionLocks.LoadOrStore(sessionID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
@@ -0,0 +1,139 @@
+package summary
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+)
+
+func TestSummaryManagerConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[SummaryManager]
+	}{
+		{name: "inline"},
+		{name: "background", opts: []options.Option[SummaryManager]{WithBackgroundSummarization(time.Hour)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m, err := NewSummaryManager(env.BaseOptions(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			managertest.RunConformance(t, m)
+		})
+	}
+}
+
+func TestSummaryManagerPostProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []options.Option[SummaryManager]
+		exchanges int
+		want      string
+	}{
+		{
+			name:      "not due",
+			opts:      []options.Option[SummaryManager]{WithExchangeInterval(3)},
+			exchanges: 2,
+		},
+		{
+			name:      "due after exchanges",
+			opts:      []options.Option[SummaryManager]{WithExchangeInterval(3)},
+			exchanges: 3,
+			want:      "Alice greeted the assistant.",
+		},
+		{
+			name:      "due after tokens",
+			opts:      []options.Option[SummaryManager]{WithExchangeInterval(10), WithTokenThreshold(5)},
+			exchanges: 1,
+			want:      "Alice greeted the assistant.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+				return llm.Message{Role: llm.RoleAssistant, Content: " Alice greeted the assistant. "}, nil
+			}
+			m, err := NewSummaryManager(env.BaseOptions(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user := env.NewActor("Alice", false)
+			session := env.NewSession()
+			for i := 0; i < tt.exchanges; i++ {
+				s := env.NewState(env.NewFragment(user, session, "Hello there, how are you today?"))
+				s.Output = env.NewFragment(env.Assistant, session, "Hi Alice, doing well!")
+				if err := m.PostProcess(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := m.Context(env.NewState(env.NewFragment(user, session, "Anyway")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := data[0].Value; got != tt.want {
+				t.Errorf("summary = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSummary(t *testing.T) {
+	tests := []struct {
+		name      string
+		exchanges int
+		want      string
+	}{
+		// Sessions not summarized yet have no summary rather than an error
+		{name: "not summarized"},
+		{name: "summarized", exchanges: 1, want: "Alice greeted the assistant."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			env.Provider.CompletionFunc = func(ctx context.Context, req llm.CompletionRequest) (llm.Message, error) {
+				return llm.Message{Role: llm.RoleAssistant, Content: "Alice greeted the assistant."}, nil
+			}
+			m, err := NewSummaryManager(env.BaseOptions(), WithExchangeInterval(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user := env.NewActor("Alice", false)
+			session := env.NewSession()
+			for i := 0; i < tt.exchanges; i++ {
+				s := env.NewState(env.NewFragment(user, session, "Hello"))
+				s.Output = env.NewFragment(env.Assistant, session, "Hi Alice!")
+				if err := m.PostProcess(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			summary, err := m.GetSummary(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if summary != nil {
+					t.Errorf("summary = %q, want none", summary.Content)
+				}
+				return
+			}
+			if summary == nil || summary.Content != tt.want {
+				t.Errorf("summary = %v, want %q", summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package summary
+
+import (
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/manager"
+)
+
+const (
+	// SummaryManagerID identifies the summary manager
+	SummaryManagerID manager.ManagerID = "summary"
+)
+
+var (
+	// SummaryKey holds the rolling summary of the input's session as a string in
+	// the manager's context data, empty while the session has none
+	SummaryKey = manager.NamespacedKey(SummaryManagerID, "session_summary")
+)
+
+// Metadata keys and values of stored summary fragments
+const (
+	TypeKey         = "type"            // Kind of fragment
+	SummaryType     = "session_summary" // Value of TypeKey for summaries
+	CoveredUntilKey = "covered_until"   // Creation time of the last summarized fragment, RFC 3339
+	TurnsKey        = "turns"           // Number of fragments the summary covers
+)
+
+// SummaryManager maintains a rolling summary of each session, so prompts can
+// include it instead of the full history
+type SummaryManager struct {
+	*manager.BaseManager
+
+	// Number of new exchanges after which the summary is updated
+	exchangeInterval int
+	// Estimated tokens of new turns after which the summary is updated regardless
+	// of the exchange count, 0 disables it
+	tokenThreshold int
+
+	// Interval of the background summarization, 0 summarizes inline in PostProcess
+	backgroundInterval time.Duration
+
+	// Sessions waiting for the background summarization, with the assistant
+	// answering in them
+	pendingMu sync.Mutex
+	pending   map[id.ID]id.ID
+
+	// Per-session locks, so each session is summarized by one caller at a time
+	// while different sessions are summarized independently
+	sessionLocks sync.Map // id.ID -> *sync.Mutex
+}
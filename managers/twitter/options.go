@@ -0,0 +1,57 @@
+package twitter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// WithAccountID sets the platform ID of the assistant's own account, so its
+// tweets are stored as the assistant's rather than as another user's
+func WithAccountID(accountID string) options.Option[TwitterManager] {
+	return func(m *TwitterManager) error {
+		m.accountID = accountID
+		return nil
+	}
+}
+
+// WithMentionsLimit sets how many recent mentions Context returns
+func WithMentionsLimit(limit int) options.Option[TwitterManager] {
+	return func(m *TwitterManager) error {
+		if limit < 0 {
+			return fmt.Errorf("mentions limit must not be negative")
+		}
+		m.mentionsLimit = limit
+		return nil
+	}
+}
+
+// WithThreadDepth sets how many tweets Context follows up the reply chain of the input
+func WithThreadDepth(depth int) options.Option[TwitterManager] {
+	return func(m *TwitterManager) error {
+		if depth < 0 {
+			return fmt.Errorf("thread depth must not be negative")
+		}
+		m.threadDepth = depth
+		return nil
+	}
+}
+
+// WithFetcher polls the fetcher for new mentions every interval while the
+// manager's background processes run. New mentions are stored and passed to
+// the handler, which may be nil to only store them.
+func WithFetcher(fetcher Fetcher, interval time.Duration, handler TweetHandler) options.Option[TwitterManager] {
+	return func(m *TwitterManager) error {
+		if fetcher == nil {
+			return fmt.Errorf("fetcher is required")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("poll interval must be positive")
+		}
+		m.fetcher = fetcher
+		m.pollInterval = interval
+		m.handler = handler
+		return nil
+	}
+}
@@ -0,0 +1,351 @@
+// Package twitter provides a manager that ingests tweets into the twitter
+// fragment table and gives reply prompts the conversation around a tweet: the
+// reply chain leading to it under ThreadKey and the assistant's recent mentions
+// under RecentMentionsKey.
+//
+// Tweets map to the store's model deterministically: each conversation is a
+// session, each author an actor and each tweet a fragment whose parent is the
+// tweet it replies to, so ingesting a tweet twice stores it once. The app
+// provides the API client as a Fetcher and handles new mentions, typically by
+// processing them with the engine and replying:
+//
+//	twitterStore := stores.NewFragmentStore(ctx, database, db.FragmentTableTwitter)
+//
+//	twitterManager, err := twitter.NewTwitterManager(
+//	    []options.Option[manager.BaseManager]{
+//	        manager.WithContext(ctx),
+//	        manager.WithLogger(log),
+//	        manager.WithLLM(llmClient),
+//	        manager.WithFragmentStore(twitterStore),
+//	        manager.WithInteractionFragmentStore(interactionStore),
+//	        manager.WithActorStore(actorStore),
+//	        manager.WithSessionStore(sessionStore),
+//	        manager.WithAssistantDetails("Thor", assistantID),
+//	    },
+//	    twitter.WithAccountID(botUserID),
+//	    twitter.WithFetcher(apiClient, time.Minute, func(ctx context.Context, tweet *db.Fragment) error {
+//	        response, err := eng.Reply(ctx, tweet, buildPrompt)
+//	        if err != nil {
+//	            return err
+//	        }
+//	        return apiClient.PostReply(ctx, tweet.Metadata.GetString(twitter.TweetIDKey), response.Content)
+//	    }),
+//	)
+package twitter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+)
+
+const (
+	defaultMentionsLimit = 10
+	defaultThreadDepth   = 25
+
+	// pollTask names the background mentions poller
+	pollTask = "poll_mentions"
+)
+
+// namespace derives the IDs of tweets, conversations and authors
+var namespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://x.com"))
+
+// NewTwitterManager creates a new TwitterManager with the given base and twitter options
+// The mentions poller is registered with RunPeriodic when a fetcher is set and
+// runs while the manager's background processes run
+func NewTwitterManager(baseOpts []options.Option[manager.BaseManager], twitterOpts ...options.Option[TwitterManager]) (*TwitterManager, error) {
+	base, err := manager.NewBaseManager(baseOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &TwitterManager{
+		BaseManager:   base,
+		mentionsLimit: defaultMentionsLimit,
+		threadDepth:   defaultThreadDepth,
+	}
+	if err := options.ApplyOptions(m, twitterOpts...); err != nil {
+		return nil, fmt.Errorf("failed to create twitter manager: %w", err)
+	}
+
+	if m.fetcher != nil {
+		if err := m.RunPeriodic(pollTask, m.pollInterval, m.poll); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// GetID returns the manager's identifier
+func (m *TwitterManager) GetID() manager.ManagerID {
+	return TwitterManagerID
+}
+
+// Capabilities declares that the manager provides context, and polls in the
+// background when a fetcher is set
+func (m *TwitterManager) Capabilities() []manager.Capability {
+	capabilities := []manager.Capability{manager.CapabilityContext}
+	if m.fetcher != nil {
+		capabilities = append(capabilities, manager.CapabilityBackground)
+	}
+	return capabilities
+}
+
+// ProvidedKeys declares the types of the mentions and thread in the context data
+func (m *TwitterManager) ProvidedKeys() []manager.StateDataContract {
+	return []manager.StateDataContract{
+		manager.TypedContract[[]db.Fragment](RecentMentionsKey),
+		manager.TypedContract[[]db.Fragment](ThreadKey),
+	}
+}
+
+// Restore the context data of state snapshots with its type
+func init() {
+	state.RegisterDataType[[]db.Fragment](RecentMentionsKey)
+	state.RegisterDataType[[]db.Fragment](ThreadKey)
+}
+
+// Context returns the assistant's recent mentions and, for tweet inputs, the
+// reply chain leading to the input
+func (m *TwitterManager) Context(currentState *state.State) ([]state.StateData, error) {
+	input := currentState.Input
+	if input == nil {
+		return nil, nil
+	}
+	store := m.FragmentStore.WithContext(m.Ctx)
+
+	var mentions []db.Fragment
+	if m.mentionsLimit > 0 {
+		var err error
+		mentions, err = store.ListByMetadata(MentionKey, "true", m.mentionsLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load recent mentions: %w", err)
+		}
+	}
+
+	var thread []db.Fragment
+	if input.Metadata.GetString(PlatformKey) == Platform {
+		var err error
+		thread, err = store.GetThread(input.ID, m.threadDepth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load thread: %w", err)
+		}
+	}
+
+	return []state.StateData{
+		{Key: RecentMentionsKey, Value: mentions},
+		{Key: ThreadKey, Value: thread},
+	}, nil
+}
+
+// Ingest stores tweets, e.g. the assistant's own replies once posted or tweets
+// from a timeline. Tweets already stored are skipped. Returns the newly stored
+// tweets.
+func (m *TwitterManager) Ingest(ctx context.Context, tweets ...Tweet) ([]*db.Fragment, error) {
+	var stored []*db.Fragment
+	for _, tweet := range tweets {
+		fragment, inserted, err := m.ingest(ctx, tweet, false)
+		if err != nil {
+			return stored, err
+		}
+		if inserted {
+			stored = append(stored, fragment)
+		}
+	}
+	return stored, nil
+}
+
+// TweetFragmentID returns the ID of the fragment storing a tweet
+func TweetFragmentID(tweetID string) id.ID {
+	return derivedID("tweet", tweetID)
+}
+
+// ConversationSessionID returns the ID of the session of a conversation
+func ConversationSessionID(conversationID string) id.ID {
+	return derivedID("conversation", conversationID)
+}
+
+// AuthorActorID returns the ID of the actor of a tweet author
+func AuthorActorID(authorID string) id.ID {
+	return derivedID("user", authorID)
+}
+
+// ingest stores a tweet with its author and conversation, returning the stored
+// fragment and whether it was new
+func (m *TwitterManager) ingest(ctx context.Context, tweet Tweet, mention bool) (*db.Fragment, bool, error) {
+	if tweet.ID == "" {
+		return nil, false, fmt.Errorf("tweet ID is required")
+	}
+	if strings.TrimSpace(tweet.Text) == "" {
+		return nil, false, fmt.Errorf("tweet %s has no text", tweet.ID)
+	}
+
+	store := m.FragmentStore.WithContext(ctx)
+	fragmentID := TweetFragmentID(tweet.ID)
+
+	// Skip known tweets before paying for their embedding
+	exists, err := store.Exists(fragmentID)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists {
+		return nil, false, nil
+	}
+
+	actor, err := m.author(tweet)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conversationID := tweet.ConversationID
+	if conversationID == "" {
+		conversationID = tweet.ID
+	}
+	sessionID := ConversationSessionID(conversationID)
+	sessions := m.SessionStore.WithContext(ctx)
+	if err := sessions.Touch(sessionID); err != nil {
+		return nil, false, err
+	}
+	if err := sessions.MergeMetadata(sessionID, db.Metadata{
+		PlatformKey:       Platform,
+		ConversationIDKey: conversationID,
+	}); err != nil {
+		return nil, false, err
+	}
+
+	vector, err := m.LLM.WithContext(ctx).EmbedText(tweet.Text)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to embed tweet %s: %w", tweet.ID, err)
+	}
+
+	metadata := db.Metadata{
+		PlatformKey:       Platform,
+		TweetIDKey:        tweet.ID,
+		ConversationIDKey: conversationID,
+		AuthorHandleKey:   tweet.AuthorHandle,
+	}
+	fragment := &db.Fragment{
+		ID:        fragmentID,
+		ActorID:   actor.ID,
+		SessionID: sessionID,
+		Content:   tweet.Text,
+		Metadata:  metadata,
+		Embedding: pgvector.NewVector(vector),
+		CreatedAt: tweet.CreatedAt,
+	}
+	if tweet.InReplyToID != "" {
+		metadata[InReplyToKey] = tweet.InReplyToID
+		parentID := TweetFragmentID(tweet.InReplyToID)
+		fragment.ParentID = &parentID
+	}
+	if mention {
+		metadata[MentionKey] = true
+	}
+
+	inserted, err := store.CreateIfNotExists(fragment)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store tweet %s: %w", tweet.ID, err)
+	}
+	fragment.Actor = actor
+	return fragment, inserted, nil
+}
+
+// author returns the actor of a tweet's author, storing other users as they
+// appear so renamed handles are picked up
+func (m *TwitterManager) author(tweet Tweet) (*db.Actor, error) {
+	if m.accountID != "" && tweet.AuthorID == m.accountID {
+		return &db.Actor{
+			ID:        m.AssistantID,
+			Name:      m.AssistantName,
+			Assistant: true,
+		}, nil
+	}
+	if tweet.AuthorID == "" {
+		return nil, fmt.Errorf("tweet %s has no author", tweet.ID)
+	}
+
+	name := tweet.AuthorHandle
+	if name == "" {
+		name = tweet.AuthorID
+	}
+	actor := &db.Actor{
+		ID:   AuthorActorID(tweet.AuthorID),
+		Name: name,
+	}
+	if err := m.ActorStore.Upsert(actor); err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// poll fetches new mentions, stores them and passes the new ones to the
+// handler, oldest first. Handler errors are logged and returned together once
+// all mentions are handled.
+func (m *TwitterManager) poll(ctx context.Context) error {
+	m.sinceMu.Lock()
+	sinceID := m.sinceID
+	m.sinceMu.Unlock()
+
+	tweets, err := m.fetcher.FetchMentions(ctx, sinceID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch mentions: %w", err)
+	}
+
+	sort.SliceStable(tweets, func(i, j int) bool {
+		return tweets[i].CreatedAt.Before(tweets[j].CreatedAt)
+	})
+
+	var failures []error
+	for _, tweet := range tweets {
+		fragment, inserted, err := m.ingest(ctx, tweet, true)
+		if err != nil {
+			return errors.Join(append(failures, err)...)
+		}
+		m.advanceSince(tweet.ID)
+
+		if !inserted || m.handler == nil {
+			continue
+		}
+		if err := m.handler(ctx, fragment); err != nil {
+			m.Logger.WithError(err).WithField("tweet", tweet.ID).Error("Failed to handle mention")
+			failures = append(failures, fmt.Errorf("tweet %s: %w", tweet.ID, err))
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+// advanceSince records a fetched tweet, keeping the newest tweet ID seen
+func (m *TwitterManager) advanceSince(tweetID string) {
+	m.sinceMu.Lock()
+	defer m.sinceMu.Unlock()
+	if newerTweetID(tweetID, m.sinceID) {
+		m.sinceID = tweetID
+	}
+}
+
+// newerTweetID reports whether a tweet ID is newer than another. Tweet IDs are
+// increasing numbers, compared as strings to avoid overflow.
+func newerTweetID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+// derivedID returns the ID derived from a platform ID of the given kind
+func derivedID(kind, platformID string) id.ID {
+	return id.ID(uuid.NewSHA1(namespace, []byte(kind+":"+platformID)).String())
+}
@@ -0,0 +1,497 @@
+package twitter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/managertest"
+	"github.com/velumlabs/thor/options"
+
+	"github.com/google/uuid"
+)
+
+// fetchResult is the response of a fakeFetcher to a single call
+type fetchResult struct {
+	tweets []Tweet
+	err    error
+}
+
+// fakeFetcher returns its results in turn, nothing once they run out, and
+// records the since IDs it is called with
+type fakeFetcher struct {
+	mu       sync.Mutex
+	results  []fetchResult
+	sinceIDs []string
+}
+
+func (f *fakeFetcher) FetchMentions(ctx context.Context, sinceID string) ([]Tweet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sinceIDs = append(f.sinceIDs, sinceID)
+	if len(f.results) == 0 {
+		return nil, nil
+	}
+	result := f.results[0]
+	f.results = f.results[1:]
+	return result.tweets, result.err
+}
+
+// testTime is the time of the first test tweet; tweet n is n minutes later
+var testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newTweet returns a tweet of author n minutes after testTime, replying to
+// inReplyTo in the conversation started by it if set
+func newTweet(tweetID, author string, n int, inReplyTo string) Tweet {
+	tweet := Tweet{
+		ID:           tweetID,
+		AuthorID:     author,
+		AuthorHandle: "@" + author,
+		Text:         "tweet " + tweetID,
+		InReplyToID:  inReplyTo,
+		CreatedAt:    testTime.Add(time.Duration(n) * time.Minute),
+	}
+	if inReplyTo != "" {
+		tweet.ConversationID = "100"
+	}
+	return tweet
+}
+
+// newTestManager returns a twitter manager of env whose account is "bot"
+func newTestManager(t *testing.T, env *managertest.TestEnvironment, opts ...options.Option[TwitterManager]) *TwitterManager {
+	t.Helper()
+	m, err := NewTwitterManager(env.BaseOptions(), append([]options.Option[TwitterManager]{WithAccountID("bot")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// contents returns the content of fragments
+func contents(fragments []db.Fragment) string {
+	var c []string
+	for _, fragment := range fragments {
+		c = append(c, fragment.Content)
+	}
+	return strings.Join(c, ", ")
+}
+
+func TestTwitterManagerConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []options.Option[TwitterManager]
+	}{
+		{name: "context only"},
+		{name: "poller", opts: []options.Option[TwitterManager]{WithFetcher(&fakeFetcher{}, time.Hour, nil)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			managertest.RunConformance(t, newTestManager(t, env, tt.opts...))
+		})
+	}
+}
+
+func TestDerivedIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b id.ID
+		// same is whether the IDs must be equal
+		same bool
+	}{
+		{name: "tweet is stable", a: TweetFragmentID("1"), b: TweetFragmentID("1"), same: true},
+		{name: "conversation is stable", a: ConversationSessionID("1"), b: ConversationSessionID("1"), same: true},
+		{name: "author is stable", a: AuthorActorID("1"), b: AuthorActorID("1"), same: true},
+		{name: "tweets differ", a: TweetFragmentID("1"), b: TweetFragmentID("2")},
+		{name: "tweet and conversation differ", a: TweetFragmentID("1"), b: ConversationSessionID("1")},
+		{name: "tweet and author differ", a: TweetFragmentID("1"), b: AuthorActorID("1")},
+		{name: "conversation and author differ", a: ConversationSessionID("1"), b: AuthorActorID("1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, derived := range []id.ID{tt.a, tt.b} {
+				if _, err := uuid.Parse(string(derived)); err != nil {
+					t.Errorf("derived ID %s is not a UUID: %v", derived, err)
+				}
+			}
+			if (tt.a == tt.b) != tt.same {
+				t.Errorf("%s and %s equal %v, want %v", tt.a, tt.b, tt.a == tt.b, tt.same)
+			}
+		})
+	}
+}
+
+func TestIngest(t *testing.T) {
+	root := newTweet("100", "alice", 0, "")
+	reply := newTweet("101", "bot", 1, "100")
+
+	tests := []struct {
+		name string
+		// batches are ingested in turn
+		batches [][]Tweet
+		// stored are the contents stored by each batch
+		stored []string
+		// embedded is the number of tweets embedded
+		embedded int
+		err      string
+	}{
+		{
+			name:     "new tweets",
+			batches:  [][]Tweet{{root, reply}},
+			stored:   []string{"tweet 100, tweet 101"},
+			embedded: 2,
+		},
+		{
+			name:     "known tweets skipped before embedding",
+			batches:  [][]Tweet{{root}, {root, reply}, {reply}},
+			stored:   []string{"tweet 100", "tweet 101", ""},
+			embedded: 2,
+		},
+		{
+			name:     "duplicate in a batch",
+			batches:  [][]Tweet{{root, root}},
+			stored:   []string{"tweet 100"},
+			embedded: 1,
+		},
+		{
+			name:    "no ID",
+			batches: [][]Tweet{{{AuthorID: "alice", Text: "hi"}}},
+			stored:  []string{""},
+			err:     "tweet ID is required",
+		},
+		{
+			name:    "no text",
+			batches: [][]Tweet{{{ID: "1", AuthorID: "alice", Text: "  "}}},
+			stored:  []string{""},
+			err:     "tweet 1 has no text",
+		},
+		{
+			name:    "no author",
+			batches: [][]Tweet{{{ID: "1", Text: "hi"}}},
+			stored:  []string{""},
+			err:     "tweet 1 has no author",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			m := newTestManager(t, env)
+
+			var err error
+			for i, batch := range tt.batches {
+				var stored []*db.Fragment
+				stored, err = m.Ingest(context.Background(), batch...)
+				var got []db.Fragment
+				for _, fragment := range stored {
+					got = append(got, *fragment)
+				}
+				if contents(got) != tt.stored[i] {
+					t.Errorf("batch %d stored %q, want %q", i, contents(got), tt.stored[i])
+				}
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(env.Provider.EmbeddedTexts()); n != tt.embedded {
+				t.Errorf("%d tweets embedded, want %d", n, tt.embedded)
+			}
+		})
+	}
+}
+
+func TestIngestMapping(t *testing.T) {
+	env := managertest.NewTestEnvironment(t)
+	m := newTestManager(t, env)
+	if _, err := m.Ingest(context.Background(), newTweet("100", "alice", 0, ""), newTweet("101", "bot", 1, "100")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tweetID   string
+		actorID   id.ID
+		parentID  *id.ID
+		inReplyTo string
+	}{
+		{name: "conversation start", tweetID: "100", actorID: AuthorActorID("alice")},
+		{name: "reply of the assistant", tweetID: "101", actorID: env.Assistant.ID, parentID: ptr(TweetFragmentID("100")), inReplyTo: "100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fragment, err := env.FragmentStore.GetByID(TweetFragmentID(tt.tweetID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fragment.ActorID != tt.actorID {
+				t.Errorf("actor = %s, want %s", fragment.ActorID, tt.actorID)
+			}
+			// Replies join the session of the conversation they belong to
+			if want := ConversationSessionID("100"); fragment.SessionID != want {
+				t.Errorf("session = %s, want %s", fragment.SessionID, want)
+			}
+			if (fragment.ParentID == nil) != (tt.parentID == nil) || (tt.parentID != nil && *fragment.ParentID != *tt.parentID) {
+				t.Errorf("parent = %v, want %v", fragment.ParentID, tt.parentID)
+			}
+			if got := fragment.Metadata.GetString(InReplyToKey); got != tt.inReplyTo {
+				t.Errorf("in reply to = %q, want %q", got, tt.inReplyTo)
+			}
+			if got := fragment.Metadata.GetString(TweetIDKey); got != tt.tweetID {
+				t.Errorf("tweet ID = %q, want %q", got, tt.tweetID)
+			}
+		})
+	}
+}
+
+func ptr(v id.ID) *id.ID {
+	return &v
+}
+
+func TestNewerTweetID(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "2", b: "1", want: true},
+		{a: "1", b: "2"},
+		{a: "1", b: "1"},
+		{a: "10", b: "9", want: true},
+		{a: "9", b: "10"},
+		{a: "1", b: "", want: true},
+		{a: "", b: "1"},
+		// Beyond the range of int64
+		{a: "99999999999999999999", b: "99999999999999999998", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := newerTweetID(tt.a, tt.b); got != tt.want {
+				t.Errorf("newerTweetID(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollSinceID(t *testing.T) {
+	fetchErr := errors.New("rate limited")
+
+	tests := []struct {
+		name    string
+		results []fetchResult
+		// failing are the tweets the handler fails on
+		failing map[string]bool
+		// sinceIDs are the since IDs of the fetches, one per poll
+		sinceIDs []string
+		// errs are parts of the errors of the polls, empty for none
+		errs []string
+		// handled are the tweets handled
+		handled string
+	}{
+		{
+			name: "advanced to the newest mention",
+			results: []fetchResult{
+				// Mentions come newest first and are handled oldest first
+				{tweets: []Tweet{newTweet("12", "carol", 2, ""), newTweet("9", "alice", 0, ""), newTweet("10", "bob", 1, "")}},
+				{tweets: []Tweet{newTweet("13", "alice", 3, "")}},
+			},
+			sinceIDs: []string{"", "12", "13"},
+			errs:     []string{"", "", ""},
+			handled:  "tweet 9, tweet 10, tweet 12, tweet 13",
+		},
+		{
+			name: "kept on a failed fetch",
+			results: []fetchResult{
+				{tweets: []Tweet{newTweet("10", "alice", 0, "")}},
+				{err: fetchErr},
+				{tweets: []Tweet{newTweet("11", "bob", 1, "")}},
+			},
+			sinceIDs: []string{"", "10", "10", "11"},
+			errs:     []string{"", "rate limited", "", ""},
+			handled:  "tweet 10, tweet 11",
+		},
+		{
+			name: "advanced past mentions the handler fails on",
+			results: []fetchResult{
+				{tweets: []Tweet{newTweet("10", "alice", 0, ""), newTweet("11", "bob", 1, "")}},
+			},
+			failing:  map[string]bool{"10": true},
+			sinceIDs: []string{"", "11"},
+			errs:     []string{"tweet 10: reply failed", ""},
+			handled:  "tweet 10, tweet 11",
+		},
+		{
+			name: "kept before a mention that can't be stored",
+			results: []fetchResult{
+				{tweets: []Tweet{newTweet("10", "alice", 0, ""), {ID: "11", AuthorID: "bob", CreatedAt: testTime.Add(time.Minute)}, newTweet("12", "carol", 2, "")}},
+				// Fetched again from the failing mention on
+				{tweets: []Tweet{newTweet("12", "carol", 2, "")}},
+			},
+			sinceIDs: []string{"", "10", "12"},
+			errs:     []string{"tweet 11 has no text", "", ""},
+			handled:  "tweet 10, tweet 12",
+		},
+		{
+			name: "known mentions advance without being handled again",
+			results: []fetchResult{
+				{tweets: []Tweet{newTweet("10", "alice", 0, "")}},
+				{tweets: []Tweet{newTweet("10", "alice", 0, ""), newTweet("11", "bob", 1, "")}},
+			},
+			sinceIDs: []string{"", "10", "11"},
+			errs:     []string{"", "", ""},
+			handled:  "tweet 10, tweet 11",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			fetcher := &fakeFetcher{results: tt.results}
+			var handled []db.Fragment
+			m := newTestManager(t, env, WithFetcher(fetcher, time.Hour, func(ctx context.Context, tweet *db.Fragment) error {
+				handled = append(handled, *tweet)
+				if tt.failing[tweet.Metadata.GetString(TweetIDKey)] {
+					return errors.New("reply failed")
+				}
+				return nil
+			}))
+
+			for i, want := range tt.errs {
+				err := m.poll(context.Background())
+				if want == "" && err != nil {
+					t.Errorf("poll %d failed: %v", i, err)
+				}
+				if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+					t.Errorf("poll %d returned %v, want %q", i, err, want)
+				}
+			}
+
+			if strings.Join(fetcher.sinceIDs, ",") != strings.Join(tt.sinceIDs, ",") {
+				t.Errorf("fetched since %q, want %q", fetcher.sinceIDs, tt.sinceIDs)
+			}
+			if contents(handled) != tt.handled {
+				t.Errorf("handled %q, want %q", contents(handled), tt.handled)
+			}
+			for _, tweet := range handled {
+				if !tweet.Metadata.GetBool(MentionKey) {
+					t.Errorf("tweet %s not stored as a mention", tweet.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestTwitterManagerContext(t *testing.T) {
+	// A conversation of alice and the assistant with a side branch of bob, and
+	// a mention in another conversation
+	conversation := []Tweet{
+		newTweet("100", "alice", 0, ""),
+		newTweet("101", "bot", 1, "100"),
+		newTweet("102", "alice", 2, "101"),
+		newTweet("103", "bob", 3, "100"),
+		newTweet("104", "bot", 4, "102"),
+	}
+	mentions := []Tweet{newTweet("200", "carol", 5, ""), newTweet("102", "alice", 2, "101")}
+
+	tests := []struct {
+		name string
+		opts []options.Option[TwitterManager]
+		// input is the ID of the input tweet, input a non tweet if empty
+		input    string
+		thread   string
+		mentions string
+	}{
+		{
+			name:     "reply chain",
+			input:    "104",
+			thread:   "tweet 100, tweet 101, tweet 102, tweet 104",
+			mentions: "tweet 200, tweet 102",
+		},
+		{
+			name:     "side branch",
+			input:    "103",
+			thread:   "tweet 100, tweet 103",
+			mentions: "tweet 200, tweet 102",
+		},
+		{
+			name:     "conversation start",
+			input:    "100",
+			thread:   "tweet 100",
+			mentions: "tweet 200, tweet 102",
+		},
+		{
+			name:     "thread depth",
+			opts:     []options.Option[TwitterManager]{WithThreadDepth(2)},
+			input:    "104",
+			thread:   "tweet 101, tweet 102, tweet 104",
+			mentions: "tweet 200, tweet 102",
+		},
+		{
+			name:     "mentions limit",
+			opts:     []options.Option[TwitterManager]{WithMentionsLimit(1)},
+			input:    "104",
+			thread:   "tweet 100, tweet 101, tweet 102, tweet 104",
+			mentions: "tweet 200",
+		},
+		{
+			name:   "no mentions",
+			opts:   []options.Option[TwitterManager]{WithMentionsLimit(0)},
+			input:  "101",
+			thread: "tweet 100, tweet 101",
+		},
+		{
+			name:     "not a tweet",
+			mentions: "tweet 200, tweet 102",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := managertest.NewTestEnvironment(t)
+			fetcher := &fakeFetcher{results: []fetchResult{{tweets: mentions}}}
+			m := newTestManager(t, env, append(tt.opts, WithFetcher(fetcher, time.Hour, nil))...)
+			if err := m.poll(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Ingest(context.Background(), conversation...); err != nil {
+				t.Fatal(err)
+			}
+
+			input := env.NewFragment(env.NewActor("Alice", false), env.NewSession(), "Hello")
+			if tt.input != "" {
+				var err error
+				if input, err = env.FragmentStore.GetByID(TweetFragmentID(tt.input)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := m.Context(env.NewState(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			for _, item := range data {
+				got[string(item.Key)] = contents(item.Value.([]db.Fragment))
+			}
+			if got[string(ThreadKey)] != tt.thread {
+				t.Errorf("thread = %q, want %q", got[string(ThreadKey)], tt.thread)
+			}
+			if got[string(RecentMentionsKey)] != tt.mentions {
+				t.Errorf("mentions = %q, want %q", got[string(RecentMentionsKey)], tt.mentions)
+			}
+		})
+	}
+}
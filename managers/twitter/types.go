@@ -0,0 +1,85 @@
+package twitter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/manager"
+)
+
+const (
+	// TwitterManagerID identifies the twitter manager
+	TwitterManagerID manager.ManagerID = "twitter"
+)
+
+var (
+	// RecentMentionsKey holds the most recent tweets mentioning the assistant's
+	// account, newest first, as []db.Fragment in the manager's context data
+	RecentMentionsKey = manager.NamespacedKey(TwitterManagerID, "recent_mentions")
+
+	// ThreadKey holds the reply chain leading to the input tweet, from the
+	// conversation's first tweet down to the input, as []db.Fragment in the
+	// manager's context data. It is empty for inputs that aren't tweets.
+	ThreadKey = manager.NamespacedKey(TwitterManagerID, "thread")
+)
+
+// Metadata keys of stored tweet fragments and twitter sessions
+const (
+	PlatformKey       = "platform"        // "twitter" on tweets and their sessions
+	TweetIDKey        = "tweet_id"        // ID of the tweet on the platform
+	ConversationIDKey = "conversation_id" // ID of the tweet's conversation on the platform
+	AuthorHandleKey   = "author"          // Handle of the tweet's author
+	InReplyToKey      = "in_reply_to"     // ID of the tweet replied to, if any
+	MentionKey        = "mention"         // Set on tweets fetched as mentions of the assistant
+)
+
+// Platform is the value of PlatformKey
+const Platform = "twitter"
+
+// Tweet is a normalized tweet, as provided by the app's API client
+type Tweet struct {
+	ID             string
+	AuthorID       string
+	AuthorHandle   string
+	Text           string
+	ConversationID string // ID of the conversation's first tweet, the tweet's own ID if empty
+	InReplyToID    string // ID of the tweet replied to, empty for a new conversation
+	CreatedAt      time.Time
+}
+
+// Fetcher retrieves the tweets mentioning the assistant's account. It is
+// implemented by the app on top of its API client.
+type Fetcher interface {
+	// FetchMentions returns the mentions newer than the tweet with ID sinceID,
+	// all available recent mentions if sinceID is empty
+	FetchMentions(ctx context.Context, sinceID string) ([]Tweet, error)
+}
+
+// TweetHandler is called by the poller for each newly stored mention, e.g. to
+// process it with the engine and reply
+type TweetHandler func(ctx context.Context, fragment *db.Fragment) error
+
+// TwitterManager stores tweets in the twitter fragment table and provides the
+// mentions and thread of a tweet to reply prompts
+type TwitterManager struct {
+	*manager.BaseManager
+
+	// Platform ID of the assistant's own account, whose tweets are stored as the assistant's
+	accountID string
+
+	// Number of recent mentions Context returns
+	mentionsLimit int
+	// Maximum number of tweets followed up a reply chain
+	threadDepth int
+
+	// Mentions poller, disabled without a fetcher
+	fetcher      Fetcher
+	pollInterval time.Duration
+	handler      TweetHandler
+
+	// ID of the newest mention fetched so far
+	sinceMu sync.Mutex
+	sinceID string
+}
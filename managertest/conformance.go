@@ -0,0 +1,163 @@
+package managertest
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/state"
+)
+
+// stopTimeout is how long StopBackgroundProcesses may take to return
+const stopTimeout = 5 * time.Second
+
+// RunConformance checks the behavior every manager must have, as subtests:
+//   - it has an ID;
+//   - its phases don't panic on an empty state, without an input;
+//   - its phases don't panic when none of its declared dependencies resolve;
+//   - its background processes stop promptly once cancelled.
+//
+// Phases the manager doesn't declare in its Capabilities are skipped. Errors
+// returned by the phases are logged but don't fail the suite.
+func RunConformance(t *testing.T, m manager.Manager) {
+	t.Helper()
+
+	t.Run("ID", func(t *testing.T) {
+		if m.GetID() == "" {
+			t.Error("GetID returned an empty ID")
+		}
+	})
+
+	t.Run("EmptyState", func(t *testing.T) {
+		runPhases(t, m, state.NewState)
+	})
+
+	t.Run("MissingDependencies", func(t *testing.T) {
+		dependencies := m.GetDependencies()
+		if len(dependencies) == 0 {
+			t.Skip("manager declares no dependencies")
+		}
+		receiver, ok := m.(manager.ResolverReceiver)
+		if !ok {
+			t.Skip("manager does not accept a manager resolver")
+		}
+
+		receiver.SetManagerResolver(missingResolver{})
+		defer receiver.SetManagerResolver(nil)
+
+		runPhases(t, m, conformanceState)
+	})
+
+	t.Run("ContextCancellation", func(t *testing.T) {
+		if !manager.Supports(m, manager.CapabilityBackground) {
+			t.Skip("manager has no background processes")
+		}
+
+		m.StartBackgroundProcesses()
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			m.StopBackgroundProcesses()
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(stopTimeout):
+			t.Fatalf("StopBackgroundProcesses did not return within %s", stopTimeout)
+		}
+	})
+}
+
+// runPhases calls each phase the manager supports on a fresh state, failing the
+// test if it panics
+func runPhases(t *testing.T, m manager.Manager, newState func() *state.State) {
+	t.Helper()
+
+	phases := []struct {
+		capability manager.Capability
+		run        func(*state.State) error
+	}{
+		{manager.CapabilityProcess, func(s *state.State) error {
+			return m.Process(context.Background(), s)
+		}},
+		{manager.CapabilityPostProcess, m.PostProcess},
+		{manager.CapabilityContext, func(s *state.State) error {
+			_, err := m.Context(s)
+			return err
+		}},
+	}
+
+	for _, phase := range phases {
+		if !manager.Supports(m, phase.capability) {
+			continue
+		}
+		if err := callPhase(phase.run, newState()); err != nil {
+			if _, panicked := err.(phasePanic); panicked {
+				t.Errorf("%s: %v", phase.capability, err)
+				continue
+			}
+			t.Logf("%s returned an error: %v", phase.capability, err)
+		}
+	}
+}
+
+// phasePanic is a panic recovered from a phase
+type phasePanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p phasePanic) Error() string {
+	return fmt.Sprintf("panicked: %v\n%s", p.value, p.stack)
+}
+
+// callPhase calls a phase, turning a panic into a phasePanic error
+func callPhase(run func(*state.State) error, s *state.State) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = phasePanic{value: r, stack: debug.Stack()}
+		}
+	}()
+	return run(s)
+}
+
+// conformanceState returns a state with an input from a user to an assistant
+func conformanceState() *state.State {
+	user := &db.Actor{ID: id.New(), Name: "Conformance User"}
+	assistant := &db.Actor{ID: id.New(), Name: AssistantName, Assistant: true}
+	sessionID := id.New()
+
+	s := state.NewState()
+	s.Actor = user
+	s.Assistant = assistant
+	s.Input = &db.Fragment{
+		ID:        id.New(),
+		ActorID:   user.ID,
+		SessionID: sessionID,
+		Content:   "Hello",
+		Metadata:  db.Metadata{},
+		Actor:     user,
+	}
+	s.Output = &db.Fragment{
+		ID:        id.New(),
+		ActorID:   assistant.ID,
+		SessionID: sessionID,
+		Content:   "Hi, how can I help?",
+		Metadata:  db.Metadata{},
+		Actor:     assistant,
+	}
+	return s
+}
+
+// missingResolver resolves no manager
+type missingResolver struct{}
+
+func (missingResolver) Resolve(managerID manager.ManagerID) (manager.Manager, error) {
+	return nil, fmt.Errorf("%w: %s", manager.ErrManagerNotFound, managerID)
+}
@@ -0,0 +1,68 @@
+package managertest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/state"
+)
+
+func TestCallPhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(*state.State) error
+		wantErr  string
+		panicked bool
+	}{
+		{
+			name: "success",
+			run:  func(*state.State) error { return nil },
+		},
+		{
+			name:    "error",
+			run:     func(*state.State) error { return errors.New("store unavailable") },
+			wantErr: "store unavailable",
+		},
+		{
+			name: "panic",
+			run: func(s *state.State) error {
+				_ = s.Input.Content
+				return nil
+			},
+			wantErr:  "panicked: runtime error: invalid memory address",
+			panicked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callPhase(tt.run, state.NewState())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("callPhase = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("callPhase = %v, want %q", err, tt.wantErr)
+			}
+			if _, panicked := err.(phasePanic); panicked != tt.panicked {
+				t.Errorf("panicked = %v, want %v", panicked, tt.panicked)
+			}
+		})
+	}
+}
+
+func TestConformanceState(t *testing.T) {
+	s := conformanceState()
+	if s.Input == nil || s.Output == nil || s.Actor == nil || s.Assistant == nil {
+		t.Fatalf("state = %+v, want an input, an output and both actors", s)
+	}
+	if s.Input.SessionID != s.Output.SessionID {
+		t.Error("input and output are in different sessions")
+	}
+	if s.Input.ActorID != s.Actor.ID || s.Output.ActorID != s.Assistant.ID || !s.Assistant.Assistant {
+		t.Error("input and output don't belong to the user and the assistant")
+	}
+}
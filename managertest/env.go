@@ -0,0 +1,219 @@
+// Package managertest provides helpers for testing managers: an environment
+// with stores, an LLM client backed by llm.MockProvider and a silenced logger,
+// builders for actors, sessions and fragments, and a conformance suite every
+// manager should pass.
+//
+//	func TestMyManager(t *testing.T) {
+//	    env := managertest.NewTestEnvironment(t)
+//	    m, err := NewMyManager(env.BaseOptions())
+//	    if err != nil {
+//	        t.Fatal(err)
+//	    }
+//	    managertest.RunConformance(t, m)
+//
+//	    input := env.NewFragment(env.NewActor("Alice", false), env.NewSession(), "Hello")
+//	    if err := m.Process(context.Background(), env.NewState(input)); err != nil {
+//	        t.Fatal(err)
+//	    }
+//	}
+//
+// The stores run against the Postgres database named by DatabaseURLEnv when it
+// is set, e.g. a throwaway pgvector container, inside a transaction rolled
+// back when the test ends. Otherwise they run in memory, see
+// stores.NewMemoryStores, so tests still read back what they write.
+package managertest
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/logger"
+	"github.com/velumlabs/thor/manager"
+	"github.com/velumlabs/thor/options"
+	"github.com/velumlabs/thor/state"
+	"github.com/velumlabs/thor/stores"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// DatabaseURLEnv names the environment variable holding the URL of the Postgres
+// database test environments use
+const DatabaseURLEnv = "THOR_TEST_DATABASE_URL"
+
+// AssistantName is the name of the assistant of test environments
+const AssistantName = "Test Assistant"
+
+// TestEnvironment holds everything a manager needs to run in a test
+type TestEnvironment struct {
+	Ctx    context.Context
+	Cancel context.CancelFunc
+
+	// DB is the transaction the stores use, or in memory a database building
+	// queries without running them
+	DB *gorm.DB
+	// Live reports whether the stores are backed by a real database
+	Live bool
+
+	// FragmentStore targets the insight table; managers storing their
+	// fragments elsewhere can be given a store of their table instead
+	FragmentStore            *stores.FragmentStore
+	InteractionFragmentStore *stores.FragmentStore
+	ActorStore               *stores.ActorStore
+	SessionStore             *stores.SessionStore
+
+	LLM      *llm.LLMClient
+	Provider *llm.MockProvider
+	Logger   *logger.Logger
+
+	// The assistant the environment's managers work for, created in the ActorStore
+	Assistant *db.Actor
+
+	t testing.TB
+}
+
+// NewTestEnvironment creates a test environment that is torn down when the test ends
+func NewTestEnvironment(t testing.TB) *TestEnvironment {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	env := &TestEnvironment{
+		Ctx:      ctx,
+		Cancel:   cancel,
+		Provider: llm.NewMockProvider(),
+		t:        t,
+	}
+
+	log, err := logger.New(&logger.Config{Level: "debug", TimeFormat: time.RFC3339})
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+	log.SetOutput(io.Discard)
+	env.Logger = log
+
+	env.LLM = llm.NewLLMClientFromProvider(env.Provider, llm.Config{
+		Logger:  log,
+		Context: ctx,
+	})
+
+	var bundle *stores.Stores
+	if url := os.Getenv(DatabaseURLEnv); url != "" {
+		bundle = stores.NewStores(ctx, openLiveDatabase(t, url))
+		env.Live = true
+	} else {
+		bundle = stores.NewMemoryStores(ctx)
+	}
+
+	env.DB = bundle.DB()
+	env.FragmentStore = bundle.Fragments(db.FragmentTableInsight)
+	env.InteractionFragmentStore = bundle.Fragments(db.FragmentTableInteraction)
+	env.ActorStore = bundle.Actors()
+	env.SessionStore = bundle.Sessions()
+
+	env.Assistant = env.NewActor(AssistantName, true)
+
+	return env
+}
+
+// openLiveDatabase connects to the database at url and returns a transaction
+// rolled back when the test ends, so tests never see each other's data
+func openLiveDatabase(t testing.TB, url string) *gorm.DB {
+	t.Helper()
+
+	database, err := db.NewDatabase(url)
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	tx := database.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin test transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() {
+		tx.Rollback()
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return tx
+}
+
+// BaseOptions returns the base manager options wiring a manager to the environment
+func (env *TestEnvironment) BaseOptions() []options.Option[manager.BaseManager] {
+	return []options.Option[manager.BaseManager]{
+		manager.WithContext(env.Ctx),
+		manager.WithLogger(env.Logger),
+		manager.WithLLM(env.LLM),
+		manager.WithFragmentStore(env.FragmentStore),
+		manager.WithInteractionFragmentStore(env.InteractionFragmentStore),
+		manager.WithActorStore(env.ActorStore),
+		manager.WithSessionStore(env.SessionStore),
+		manager.WithAssistantDetails(env.Assistant.Name, env.Assistant.ID),
+	}
+}
+
+// NewActor creates an actor in the ActorStore
+func (env *TestEnvironment) NewActor(name string, assistant bool) *db.Actor {
+	env.t.Helper()
+	actor := &db.Actor{
+		ID:        id.New(),
+		Name:      name,
+		Assistant: assistant,
+	}
+	if err := env.ActorStore.Create(actor); err != nil {
+		env.t.Fatalf("failed to create actor: %v", err)
+	}
+	return actor
+}
+
+// NewSession creates a session in the SessionStore
+func (env *TestEnvironment) NewSession() *db.Session {
+	env.t.Helper()
+	session := &db.Session{
+		ID:             id.New(),
+		Metadata:       db.Metadata{},
+		LastActivityAt: time.Now(),
+	}
+	if err := env.SessionStore.Create(session); err != nil {
+		env.t.Fatalf("failed to create session: %v", err)
+	}
+	return session
+}
+
+// NewFragment creates a fragment of an actor in a session in the
+// InteractionFragmentStore, embedded by the mock provider
+func (env *TestEnvironment) NewFragment(actor *db.Actor, session *db.Session, content string) *db.Fragment {
+	env.t.Helper()
+	fragment := &db.Fragment{
+		ID:        id.New(),
+		ActorID:   actor.ID,
+		SessionID: session.ID,
+		Content:   content,
+		Metadata:  db.Metadata{},
+		Embedding: pgvector.NewVector(llm.MockEmbedding(content)),
+	}
+	if err := env.InteractionFragmentStore.Create(fragment); err != nil {
+		env.t.Fatalf("failed to create fragment: %v", err)
+	}
+	fragment.Actor = actor
+	fragment.Session = session
+	return fragment
+}
+
+// NewState returns a state for an input, with its actor and the environment's assistant
+func (env *TestEnvironment) NewState(input *db.Fragment) *state.State {
+	s := state.NewState()
+	s.Input = input
+	if input != nil {
+		s.Actor = input.Actor
+	}
+	s.Assistant = env.Assistant
+	return s
+}
@@ -0,0 +1,59 @@
+package managertest
+
+import (
+	"testing"
+
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/manager"
+)
+
+func TestNewTestEnvironment(t *testing.T) {
+	env := NewTestEnvironment(t)
+	if env.Assistant.Name != AssistantName || !env.Assistant.Assistant {
+		t.Errorf("assistant = %+v, want %q", env.Assistant, AssistantName)
+	}
+
+	user := env.NewActor("Alice", false)
+	session := env.NewSession()
+	input := env.NewFragment(user, session, "Hello")
+
+	tests := []struct {
+		name string
+		read func() error
+	}{
+		{name: "actor", read: func() error { _, err := env.ActorStore.GetByID(user.ID); return err }},
+		{name: "assistant", read: func() error { _, err := env.ActorStore.GetByID(env.Assistant.ID); return err }},
+		{name: "session", read: func() error { _, err := env.SessionStore.GetByID(session.ID); return err }},
+		{name: "fragment", read: func() error { _, err := env.InteractionFragmentStore.GetByID(input.ID); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.read(); err != nil {
+				t.Errorf("failed to read back the %s: %v", tt.name, err)
+			}
+		})
+	}
+
+	if got, want := input.Embedding.Slice(), llm.MockEmbedding("Hello"); len(got) != len(want) || got[0] != want[0] {
+		t.Error("fragment is not embedded by the mock provider")
+	}
+	if input.Actor != user || input.Session != session {
+		t.Error("fragment has no actor and session loaded")
+	}
+
+	s := env.NewState(input)
+	if s.Input != input || s.Actor != user || s.Assistant != env.Assistant {
+		t.Errorf("state = %+v, want the input, its actor and the assistant", s)
+	}
+}
+
+func TestBaseOptions(t *testing.T) {
+	env := NewTestEnvironment(t)
+	base, err := manager.NewBaseManager(env.BaseOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.AssistantID != env.Assistant.ID || base.LLM != env.LLM || base.FragmentStore != env.FragmentStore {
+		t.Error("base manager is not wired to the environment")
+	}
+}
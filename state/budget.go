@@ -0,0 +1,208 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/options"
+)
+
+// ErrPromptBudgetExceeded is returned by ComposeWithBudget when the prompt
+// still exceeds the budget once every trimmable section is dropped
+var ErrPromptBudgetExceeded = errors.New("prompt exceeds token budget")
+
+// WithPriority sets the priority of a section; trimmable sections with the
+// lowest priority are trimmed first. Sections default to priority 0.
+func WithPriority(priority int) options.Option[PromptSection] {
+	return func(s *PromptSection) error {
+		s.Priority = priority
+		return nil
+	}
+}
+
+// WithTrimmable lets ComposeWithBudget truncate or drop the section to fit the
+// budget. Truncation keeps the end of the section, so history rendered oldest
+// first loses its oldest turns.
+func WithTrimmable() options.Option[PromptSection] {
+	return func(s *PromptSection) error {
+		s.Trimmable = true
+		return nil
+	}
+}
+
+// WithMaxTokens caps the rendered section at the given number of tokens when
+// composing with a budget, truncating it like a trimmable section
+func WithMaxTokens(maxTokens int) options.Option[PromptSection] {
+	return func(s *PromptSection) error {
+		if maxTokens <= 0 {
+			return fmt.Errorf("max tokens must be positive")
+		}
+		s.MaxTokens = maxTokens
+		return nil
+	}
+}
+
+// WithSectionName sets the name of the section's participant
+func WithSectionName(name string) options.Option[PromptSection] {
+	return func(s *PromptSection) error {
+		s.Name = name
+		return nil
+	}
+}
+
+// ComposeWithBudget composes the prompt like Compose and fits it into maxTokens
+// as measured by the builder's token counter. Sections are first capped at
+// their MaxTokens; then, while the prompt exceeds the budget, trimmable
+// sections are truncated or dropped, lowest priority first and earliest first
+// among equal priorities; sections added together, such as the input and
+// output of an example, are dropped together. System sections and the latest
+// user section are never trimmed for the budget. Returns the messages with a report of the
+// trimmed sections; if the prompt can't be made to fit, both are returned along
+// with ErrPromptBudgetExceeded.
+func (tb *PromptBuilder) ComposeWithBudget(maxTokens int) ([]llm.Message, *TrimReport, error) {
+	if tb.err != nil {
+		return nil, nil, tb.err
+	}
+	if maxTokens <= 0 {
+		return nil, nil, fmt.Errorf("token budget must be positive")
+	}
+
+	messages, err := tb.render()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := &TrimReport{BudgetTokens: maxTokens}
+	trimmedAt := make(map[int]int) // Section index -> position in report.Trimmed
+	tokens := make([]int, len(messages))
+	dropped := make([]bool, len(messages))
+
+	trim := func(i, limit int, reason TrimReason) {
+		original := tokens[i]
+		if limit > 0 {
+			messages[i].Content = truncateStart(tb.counter, messages[i].Content, limit)
+			tokens[i] = tb.counter.CountTokens(messages[i].Content)
+		}
+		if limit <= 0 || messages[i].Content == "" {
+			dropped[i] = true
+			tokens[i] = 0
+		}
+
+		if pos, ok := trimmedAt[i]; ok {
+			entry := &report.Trimmed[pos]
+			entry.Tokens, entry.Dropped, entry.Reason = tokens[i], dropped[i], reason
+			return
+		}
+		trimmedAt[i] = len(report.Trimmed)
+		report.Trimmed = append(report.Trimmed, TrimmedSection{
+			Index:          i,
+			Role:           messages[i].Role,
+			Name:           messages[i].Name,
+			OriginalTokens: original,
+			Tokens:         tokens[i],
+			Dropped:        dropped[i],
+			Reason:         reason,
+		})
+	}
+
+	total := 0
+	for i, section := range tb.sections {
+		tokens[i] = tb.counter.CountTokens(messages[i].Content)
+		report.OriginalTokens += tokens[i]
+		if section.MaxTokens > 0 && tokens[i] > section.MaxTokens {
+			trim(i, section.MaxTokens, TrimReasonMaxTokens)
+		}
+		total += tokens[i]
+	}
+
+	for _, i := range tb.trimOrder() {
+		if total <= maxTokens {
+			break
+		}
+		if dropped[i] {
+			continue
+		}
+		if group := tb.sections[i].group; group != 0 {
+			for j, section := range tb.sections {
+				if section.group == group && !dropped[j] {
+					before := tokens[j]
+					trim(j, 0, TrimReasonBudget)
+					total -= before
+				}
+			}
+			continue
+		}
+		before := tokens[i]
+		trim(i, before-(total-maxTokens), TrimReasonBudget)
+		total -= before - tokens[i]
+	}
+
+	kept := make([]llm.Message, 0, len(messages))
+	for i, message := range messages {
+		if !dropped[i] {
+			kept = append(kept, message)
+		}
+	}
+	sort.Slice(report.Trimmed, func(a, b int) bool {
+		return report.Trimmed[a].Index < report.Trimmed[b].Index
+	})
+	report.Tokens = total
+
+	if total > maxTokens {
+		return kept, report, fmt.Errorf("%w: %d tokens after trimming, budget is %d", ErrPromptBudgetExceeded, total, maxTokens)
+	}
+	return kept, report, nil
+}
+
+// trimOrder returns the indexes of the sections ComposeWithBudget may trim for
+// the budget, in the order it trims them
+func (tb *PromptBuilder) trimOrder() []int {
+	latestUser := -1
+	for i, section := range tb.sections {
+		if section.Role == llm.RoleUser {
+			latestUser = i
+		}
+	}
+
+	var order []int
+	for i, section := range tb.sections {
+		if section.Trimmable && section.Role != llm.RoleSystem && i != latestUser {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tb.sections[order[a]].Priority < tb.sections[order[b]].Priority
+	})
+	return order
+}
+
+// truncateStart cuts the beginning of text so that it counts at most limit
+// tokens, at a line break when the kept text spans several lines
+func truncateStart(counter llm.TokenCounter, text string, limit int) string {
+	if counter.CountTokens(text) <= limit {
+		return text
+	}
+
+	// Rune boundaries the text can be cut at, up to the empty remainder
+	cuts := make([]int, 0, len(text)+1)
+	for i := range text {
+		cuts = append(cuts, i)
+	}
+	cuts = append(cuts, len(text))
+
+	// Smallest cut whose remainder fits, assuming counts shrink with the text
+	k := sort.Search(len(cuts), func(k int) bool {
+		return counter.CountTokens(text[cuts[k]:]) <= limit
+	})
+	kept := text[cuts[k]:]
+
+	if cuts[k] > 0 && text[cuts[k]-1] != '\n' {
+		if newline := strings.IndexByte(kept, '\n'); newline >= 0 && newline < len(kept)-1 {
+			kept = kept[newline+1:]
+		}
+	}
+	return strings.TrimLeft(kept, " \t")
+}
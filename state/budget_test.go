@@ -0,0 +1,163 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/llm"
+)
+
+// wordCounter counts one token per word, so budgets are easy to follow
+var wordCounter = llm.TokenCounterFunc(func(text string) int {
+	return len(strings.Fields(text))
+})
+
+// newBudgetBuilder returns a builder of 14 tokens: a system section, trimmable
+// history and reply sections and the latest user turn
+func newBudgetBuilder() *PromptBuilder {
+	return NewPromptBuilder(NewState()).
+		WithTokenCounter(wordCounter).
+		AddSystemSection("You are Thor").
+		AddSectionWithOptions(llm.RoleUser, "one two\nthree four\nfive six", WithTrimmable()).
+		AddSectionWithOptions(llm.RoleAssistant, "seven eight nine", WithTrimmable(), WithPriority(1)).
+		AddSectionWithOptions(llm.RoleUser, "What now?", WithTrimmable())
+}
+
+func TestComposeWithBudget(t *testing.T) {
+	tests := []struct {
+		name        string
+		budget      int
+		want        []string
+		wantTrimmed []TrimmedSection
+		wantTokens  int
+		wantErr     error
+	}{
+		{
+			name:       "fits",
+			budget:     14,
+			want:       []string{"You are Thor", "one two\nthree four\nfive six", "seven eight nine", "What now?"},
+			wantTokens: 14,
+		},
+		{
+			name:   "truncates the lowest priority at a line break",
+			budget: 11,
+			want:   []string{"You are Thor", "five six", "seven eight nine", "What now?"},
+			wantTrimmed: []TrimmedSection{
+				{Index: 1, Role: llm.RoleUser, OriginalTokens: 6, Tokens: 2, Reason: TrimReasonBudget},
+			},
+			wantTokens: 10,
+		},
+		{
+			name:   "drops then truncates the next priority",
+			budget: 7,
+			want:   []string{"You are Thor", "eight nine", "What now?"},
+			wantTrimmed: []TrimmedSection{
+				{Index: 1, Role: llm.RoleUser, OriginalTokens: 6, Dropped: true, Reason: TrimReasonBudget},
+				{Index: 2, Role: llm.RoleAssistant, OriginalTokens: 3, Tokens: 2, Reason: TrimReasonBudget},
+			},
+			wantTokens: 7,
+		},
+		{
+			name:   "keeps the system section and the latest user turn",
+			budget: 4,
+			want:   []string{"You are Thor", "What now?"},
+			wantTrimmed: []TrimmedSection{
+				{Index: 1, Role: llm.RoleUser, OriginalTokens: 6, Dropped: true, Reason: TrimReasonBudget},
+				{Index: 2, Role: llm.RoleAssistant, OriginalTokens: 3, Dropped: true, Reason: TrimReasonBudget},
+			},
+			wantTokens: 5,
+			wantErr:    ErrPromptBudgetExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, report, err := newBudgetBuilder().ComposeWithBudget(tt.budget)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			got := make([]string, len(messages))
+			for i, message := range messages {
+				got[i] = message.Content
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if report.OriginalTokens != 14 || report.Tokens != tt.wantTokens || report.BudgetTokens != tt.budget {
+				t.Errorf("report = %+v, want %d of 14 tokens", report, tt.wantTokens)
+			}
+			if fmt.Sprintf("%+v", report.Trimmed) != fmt.Sprintf("%+v", tt.wantTrimmed) {
+				t.Errorf("trimmed = %+v, want %+v", report.Trimmed, tt.wantTrimmed)
+			}
+		})
+	}
+}
+
+func TestComposeWithBudgetMaxTokens(t *testing.T) {
+	messages, report, err := NewPromptBuilder(NewState()).
+		WithTokenCounter(wordCounter).
+		AddSectionWithOptions(llm.RoleSystem, "one two three four", WithMaxTokens(2)).
+		AddUserSection("Hi", "").
+		ComposeWithBudget(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages[0].Content != "three four" {
+		t.Errorf("capped section = %q, want %q", messages[0].Content, "three four")
+	}
+	want := []TrimmedSection{{Index: 0, Role: llm.RoleSystem, OriginalTokens: 4, Tokens: 2, Reason: TrimReasonMaxTokens}}
+	if fmt.Sprintf("%+v", report.Trimmed) != fmt.Sprintf("%+v", want) {
+		t.Errorf("trimmed = %+v, want %+v", report.Trimmed, want)
+	}
+}
+
+func TestComposeWithBudgetInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *PromptBuilder
+		budget  int
+	}{
+		{name: "no budget", builder: newBudgetBuilder()},
+		{name: "negative budget", builder: newBudgetBuilder(), budget: -1},
+		{
+			name:    "invalid max tokens",
+			builder: NewPromptBuilder(NewState()).AddSectionWithOptions(llm.RoleSystem, "Hi", WithMaxTokens(0)),
+			budget:  10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tt.builder.ComposeWithBudget(tt.budget); err == nil {
+				t.Error("ComposeWithBudget succeeded")
+			}
+		})
+	}
+}
+
+func TestTruncateStart(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{name: "fits", text: "one two", limit: 2, want: "one two"},
+		{name: "words", text: "one two three", limit: 2, want: "two three"},
+		{name: "line break", text: "one two\nthree four", limit: 3, want: "three four"},
+		{name: "single line kept whole", text: "one two\nthree four five", limit: 3, want: "three four five"},
+		{name: "partial last line", text: "one\ntwo three four", limit: 2, want: "three four"},
+		{name: "nothing", text: "one two", limit: 0, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateStart(wordCounter, tt.text, tt.limit); got != tt.want {
+				t.Errorf("truncateStart(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// redactedContent replaces the rendered text of redacted sections
+const redactedContent = "[redacted]"
+
+// Debug renders the builder's sections as Compose does and reports, for each,
+// the template, the data keys it references, the rendered text and its tokens.
+// The builder is left unchanged, so Compose returns the same messages after:
+//
+//	report, err := builder.Debug()
+//	...
+//	fmt.Println(report.Redact("Actor"))
+func (tb *PromptBuilder) Debug() (*RenderReport, error) {
+	if tb.err != nil {
+		return nil, tb.err
+	}
+
+	data := tb.templateData()
+	report := &RenderReport{Sections: make([]SectionReport, 0, len(tb.sections))}
+	for i, section := range tb.sections {
+		rendered, err := tb.renderSection(i, section, data, tb.strict)
+		if err != nil {
+			return nil, err
+		}
+
+		referenced := make(map[string]bool)
+		if err := tb.sectionReferences(section, referenced); err != nil {
+			return nil, err
+		}
+		var keys []string
+		for key := range referenced {
+			if _, ok := data[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		text := section.Template
+		if section.Ref != "" {
+			text = section.compiled.text
+		}
+
+		sectionReport := SectionReport{
+			Index:    i,
+			Role:     section.Role,
+			Name:     section.Name,
+			Template: text,
+			Ref:      section.Ref,
+			Literal:  section.Literal,
+			Keys:     keys,
+			Rendered: rendered,
+			Tokens:   tb.counter.CountTokens(rendered),
+		}
+		report.Sections = append(report.Sections, sectionReport)
+		report.Tokens += sectionReport.Tokens
+	}
+	return report, nil
+}
+
+// Redact returns a copy of the report without the template and rendered text
+// of the sections referencing any of keys, e.g. to log a prompt without
+// personal data. Token counts are kept.
+func (r *RenderReport) Redact(keys ...string) *RenderReport {
+	redact := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		redact[key] = true
+	}
+
+	redacted := &RenderReport{
+		Sections: make([]SectionReport, len(r.Sections)),
+		Tokens:   r.Tokens,
+	}
+	for i, section := range r.Sections {
+		for _, key := range section.Keys {
+			if redact[key] {
+				section.Template = redactedContent
+				section.Rendered = redactedContent
+				section.Redacted = true
+				break
+			}
+		}
+		redacted.Sections[i] = section
+	}
+	return redacted
+}
+
+// String formats the report as plain text, one block per section
+func (r *RenderReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d sections, %d tokens\n", len(r.Sections), r.Tokens)
+	for _, section := range r.Sections {
+		fmt.Fprintf(&b, "\n--- section %d: %s", section.Index, section.Role)
+		if section.Name != "" {
+			fmt.Fprintf(&b, " (%s)", section.Name)
+		}
+		fmt.Fprintf(&b, ", %d tokens", section.Tokens)
+		switch {
+		case section.Redacted:
+			b.WriteString(", redacted")
+		case section.Literal:
+			b.WriteString(", literal")
+		}
+		b.WriteString("\n")
+
+		if section.Ref != "" {
+			fmt.Fprintf(&b, "template ref: %s\n", section.Ref)
+		}
+		if len(section.Keys) > 0 {
+			fmt.Fprintf(&b, "keys: %s\n", strings.Join(section.Keys, ", "))
+		}
+		if !section.Literal && !section.Redacted {
+			fmt.Fprintf(&b, "template:\n%s\n", section.Template)
+		}
+		fmt.Fprintf(&b, "rendered:\n%s\n", section.Rendered)
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/options"
+)
+
+// WithExamples sets the few-shot examples AddExampleSection renders
+func (tb *PromptBuilder) WithExamples(examples []Example) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	tb.examples = append([]Example(nil), examples...)
+	return tb
+}
+
+// WithRelevantExamples renders only the k examples most similar to the state's
+// input, embedding the input, and examples without an embedding, with embedder
+func WithRelevantExamples(k int, embedder Embedder) options.Option[ExampleRenderOptions] {
+	return func(o *ExampleRenderOptions) error {
+		if k <= 0 {
+			return fmt.Errorf("number of examples must be positive")
+		}
+		if embedder == nil {
+			return fmt.Errorf("embedder is required")
+		}
+		o.K = k
+		o.Embedder = embedder
+		return nil
+	}
+}
+
+// WithExamplePriority sets the priority of the example sections under a budget
+func WithExamplePriority(priority int) options.Option[ExampleRenderOptions] {
+	return func(o *ExampleRenderOptions) error {
+		o.Priority = priority
+		return nil
+	}
+}
+
+// WithTrimmableExamples lets ComposeWithBudget drop examples to fit the budget
+func WithTrimmableExamples() options.Option[ExampleRenderOptions] {
+	return func(o *ExampleRenderOptions) error {
+		o.Trimmable = true
+		return nil
+	}
+}
+
+// AddExampleSection adds the builder's examples as pairs of user and assistant
+// messages. Examples render in the order given to WithExamples; when only the
+// most relevant are selected, they render least similar first, so the closest
+// example sits next to the input, with ties kept in the given order:
+//
+//	builder.AddSystemSection(systemPrompt).
+//	    WithExamples(examples).
+//	    AddExampleSection(state.WithRelevantExamples(3, llmClient), state.WithTrimmableExamples()).
+//	    AddUserSection("{{.Input.Content}}", "")
+//
+// Examples are added verbatim, not executed as templates.
+func (tb *PromptBuilder) AddExampleSection(opts ...options.Option[ExampleRenderOptions]) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+
+	var renderOpts ExampleRenderOptions
+	if err := options.ApplyOptions(&renderOpts, opts...); err != nil {
+		tb.err = fmt.Errorf("invalid example section: %w", err)
+		return tb
+	}
+
+	examples := tb.examples
+	if renderOpts.K > 0 && len(examples) > 0 {
+		var err error
+		examples, err = tb.relevantExamples(renderOpts.K, renderOpts.Embedder)
+		if err != nil {
+			tb.err = err
+			return tb
+		}
+	}
+
+	for _, example := range examples {
+		tb.groups++
+		for _, section := range []PromptSection{
+			{Role: llm.RoleUser, Template: example.Input, Name: messageName(example.Name)},
+			{Role: llm.RoleAssistant, Template: example.Output},
+		} {
+			section.Literal = true
+			section.Priority = renderOpts.Priority
+			section.Trimmable = renderOpts.Trimmable
+			section.group = tb.groups
+			tb.sections = append(tb.sections, section)
+		}
+	}
+	return tb
+}
+
+// EmbedExamples computes the embedding of the examples that have none, so
+// selecting relevant examples doesn't embed them on every prompt
+func EmbedExamples(embedder Embedder, examples []Example) error {
+	for i := range examples {
+		if len(examples[i].Embedding) > 0 {
+			continue
+		}
+		embedding, err := embedder.EmbedText(examples[i].Input)
+		if err != nil {
+			return fmt.Errorf("failed to embed example %d: %w", i, err)
+		}
+		examples[i].Embedding = embedding
+	}
+	return nil
+}
+
+// relevantExamples returns the k examples most similar to the state's input,
+// least similar first
+func (tb *PromptBuilder) relevantExamples(k int, embedder Embedder) ([]Example, error) {
+	input := tb.state.Input
+	if input == nil {
+		return nil, fmt.Errorf("selecting relevant examples requires an input")
+	}
+
+	query := input.Embedding.Slice()
+	if len(query) == 0 {
+		var err error
+		query, err = embedder.EmbedText(input.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed input: %w", err)
+		}
+	}
+
+	examples := append([]Example(nil), tb.examples...)
+	if err := EmbedExamples(embedder, examples); err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		index      int
+		similarity float64
+	}
+	ranked := make([]scored, len(examples))
+	for i, example := range examples {
+		ranked[i] = scored{index: i, similarity: cosineSimilarity(query, example.Embedding)}
+	}
+
+	// Most similar first, ties in the given order
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return ranked[a].similarity > ranked[b].similarity
+	})
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+
+	// Render least similar first, ties still in the given order
+	sort.Slice(ranked, func(a, b int) bool {
+		if ranked[a].similarity != ranked[b].similarity {
+			return ranked[a].similarity < ranked[b].similarity
+		}
+		return ranked[a].index < ranked[b].index
+	})
+
+	selected := make([]Example, len(ranked))
+	for i, r := range ranked {
+		selected[i] = examples[r.index]
+	}
+	return selected, nil
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, 0 if they
+// differ in length or either is zero
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,130 @@
+package state
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/velumlabs/thor/db"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+// fork records the writes made to a forked state so Join can replay them
+type fork struct {
+	parent *State
+	// Metadata of the parent's input and output when forked, to find what the
+	// fork changed
+	input  db.Metadata
+	output db.Metadata
+	writes []func(*State) error
+}
+
+// Fork returns a copy of the state for a manager that may still be running
+// once the pipeline has moved on, e.g. one cut off by its timeout. The fork
+// starts with the state's data and fragment metadata, and its writes only reach
+// the state when joined with Join, so those of a fork never joined are
+// discarded. Writes through the setters and to the metadata of the input and
+// output are carried over; other fields of the fork are its own.
+func (s *State) Fork() *State {
+	s.mu.RLock()
+	f := &State{
+		Actor:                s.Actor,
+		Assistant:            s.Assistant,
+		RecentInteractions:   append([]db.Fragment(nil), s.RecentInteractions...),
+		RelevantInteractions: append([]db.Fragment(nil), s.RelevantInteractions...),
+		Tools:                append([]toolkit.Tool(nil), s.Tools...),
+		Participants:         append([]db.SessionActor(nil), s.Participants...),
+		FailedManagers:       append([]string(nil), s.FailedManagers...),
+		managerData:          make(map[StateDataKey]interface{}, len(s.managerData)),
+		keyOwners:            make(map[StateDataKey]string, len(s.keyOwners)),
+		strictKeys:           s.strictKeys,
+		customData:           make(map[string]interface{}, len(s.customData)),
+	}
+	for k, v := range s.managerData {
+		f.managerData[k] = v
+	}
+	for k, v := range s.keyOwners {
+		f.keyOwners[k] = v
+	}
+	for k, v := range s.customData {
+		f.customData[k] = v
+	}
+	f.journal = &fork{parent: s}
+	f.Input, f.journal.input = forkFragment(s.Input)
+	f.Output, f.journal.output = forkFragment(s.Output)
+	s.mu.RUnlock()
+
+	f.tx = s.Transaction()
+	return f
+}
+
+// Join applies the writes made to a fork of s, in order, as if they had been
+// made to s. Returns the key conflicts rejected under strict keys, as
+// MergeManagerData does.
+func (s *State) Join(forked *State) error {
+	if forked.journal == nil || forked.journal.parent != s {
+		return errors.New("state is not a fork of this state")
+	}
+
+	forked.journalMu.Lock()
+	writes := forked.journal.writes
+	forked.journal.writes = nil
+	forked.journalMu.Unlock()
+
+	var rejected []error
+	for _, write := range writes {
+		if err := write(s); err != nil {
+			rejected = append(rejected, err)
+		}
+	}
+
+	// Joins of forks running in parallel write the metadata in turn
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Input != nil && forked.Input != nil {
+		joinMetadata(&s.Input.Metadata, forked.journal.input, forked.Input.Metadata)
+	}
+	if s.Output != nil && forked.Output != nil {
+		joinMetadata(&s.Output.Metadata, forked.journal.output, forked.Output.Metadata)
+	}
+	return errors.Join(rejected...)
+}
+
+// record adds a write to the journal of a fork, for Join to replay
+func (s *State) record(write func(*State) error) {
+	if s.journal == nil {
+		return
+	}
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+	s.journal.writes = append(s.journal.writes, write)
+}
+
+// forkFragment returns a copy of a fragment with its own metadata, and a copy
+// of that metadata as forked
+func forkFragment(fragment *db.Fragment) (*db.Fragment, db.Metadata) {
+	if fragment == nil {
+		return nil, nil
+	}
+	forked := *fragment
+	forked.Metadata = fragment.Metadata.Clone()
+	return &forked, fragment.Metadata.Clone()
+}
+
+// joinMetadata applies to metadata the keys changed from base to forked
+func joinMetadata(metadata *db.Metadata, base, forked db.Metadata) {
+	for k, v := range forked {
+		if previous, ok := base[k]; ok && reflect.DeepEqual(previous, v) {
+			continue
+		}
+		if *metadata == nil {
+			*metadata = make(db.Metadata)
+		}
+		(*metadata)[k] = v
+	}
+	for k := range base {
+		if _, ok := forked[k]; !ok {
+			delete(*metadata, k)
+		}
+	}
+}
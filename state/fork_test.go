@@ -0,0 +1,152 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+// newForkState returns a state with some data held by the memory manager and
+// input metadata
+func newForkState(strict bool) *State {
+	s := NewState().
+		AddManagerData([]StateData{{Key: "memory/recent", Value: "hello", Owner: "memory"}}).
+		AddCustomData("platform", "discord").
+		SetStrictKeys(strict)
+	s.Input = &db.Fragment{Content: "Hello", Metadata: db.Metadata{"lang": "en", "draft": true}}
+	return s
+}
+
+func TestForkJoin(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		// write writes to the fork
+		write func(f *State)
+		// join is whether the fork is joined
+		join bool
+		// want are the manager data, custom data and input metadata expected
+		want     string
+		wantErr  bool
+		wantTool bool
+	}{
+		{
+			name:  "no writes",
+			write: func(f *State) {},
+			join:  true,
+			want:  "map[memory/recent:hello] map[platform:discord] map[draft:true lang:en]",
+		},
+		{
+			name: "joined",
+			write: func(f *State) {
+				f.AddManagerData([]StateData{{Key: "insight/insights", Value: "likes go", Owner: "insight"}})
+				f.AddCustomData("platform", "slack")
+				f.Input.Metadata["sentiment"] = "positive"
+				f.Input.Metadata["lang"] = "fr"
+				delete(f.Input.Metadata, "draft")
+				f.AddTools(toolkit.Tool(nil))
+			},
+			join:     true,
+			want:     "map[insight/insights:likes go memory/recent:hello] map[platform:slack] map[lang:fr sentiment:positive]",
+			wantTool: true,
+		},
+		{
+			name: "not joined",
+			write: func(f *State) {
+				f.AddManagerData([]StateData{{Key: "insight/insights", Value: "likes go", Owner: "insight"}})
+				f.AddCustomData("platform", "slack")
+				f.Input.Metadata["sentiment"] = "positive"
+				f.AddTools(toolkit.Tool(nil))
+			},
+			want: "map[memory/recent:hello] map[platform:discord] map[draft:true lang:en]",
+		},
+		{
+			name:  "conflict",
+			write: func(f *State) { f.AddManagerData([]StateData{{Key: "memory/recent", Value: "bye", Owner: "insight"}}) },
+			join:  true,
+			want:  "map[memory/recent:bye] map[platform:discord] map[draft:true lang:en]",
+		},
+		{
+			name:    "conflict rejected under strict keys",
+			strict:  true,
+			write:   func(f *State) { f.AddManagerData([]StateData{{Key: "memory/recent", Value: "bye", Owner: "insight"}}) },
+			join:    true,
+			want:    "map[memory/recent:hello] map[platform:discord] map[draft:true lang:en]",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newForkState(tt.strict)
+			f := s.Fork()
+			tt.write(f)
+
+			if tt.join {
+				err := s.Join(f)
+				if tt.wantErr != errors.Is(err, ErrKeyConflict) {
+					t.Errorf("Join returned %v, want a key conflict %v", err, tt.wantErr)
+				}
+			}
+			got := fmt.Sprint(s.ManagerDataSnapshot(), " ", s.CustomDataSnapshot(), " ", s.Input.Metadata)
+			if got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+			if hasTool := len(s.GetTools()) > 0; hasTool != tt.wantTool {
+				t.Errorf("state has tools %v, want %v", hasTool, tt.wantTool)
+			}
+		})
+	}
+}
+
+func TestJoinNotAFork(t *testing.T) {
+	s := newForkState(false)
+	tests := []struct {
+		name  string
+		state *State
+	}{
+		{name: "state", state: NewState()},
+		{name: "fork of another state", state: newForkState(false).Fork()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.Join(tt.state); err == nil {
+				t.Error("Join succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestJoinParallel(t *testing.T) {
+	s := newForkState(false)
+
+	// Forks written and joined concurrently, as by managers of one stage
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f := s.Fork()
+			key := fmt.Sprintf("m%d", i)
+			f.Input.Metadata[key] = i
+			f.AddCustomData(key, i)
+			if err := s.Join(f); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(s.CustomDataSnapshot()); n != 9 {
+		t.Errorf("custom data has %d keys, want 9", n)
+	}
+	if n := len(s.Input.Metadata); n != 10 {
+		t.Errorf("metadata has %d keys, want 10", n)
+	}
+}
@@ -0,0 +1,165 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/llm"
+)
+
+// maxMessageNameLength is the longest participant name providers accept
+const maxMessageNameLength = 64
+
+// AddConversationHistory adds fragments as conversation turns, in chronological
+// order: fragments of assistant actors become assistant messages and all others
+// user messages named after their actor, or for fragments without their actor
+// loaded after the session participant. The state's input is left out, so it
+// can be added as the latest user section. Nil fragments render the state's
+// RecentInteractions:
+//
+//	builder.AddSystemSection(systemPrompt).
+//	    AddConversationHistory(nil, state.HistoryRenderOptions{MaxMessages: 20}).
+//	    AddUserSection("{{.Input.Content}}", "")
+//
+// Fragments are added verbatim, not executed as templates.
+func (tb *PromptBuilder) AddConversationHistory(fragments []db.Fragment, opts HistoryRenderOptions) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	if opts.MaxMessages < 0 {
+		tb.err = fmt.Errorf("max messages must not be negative")
+		return tb
+	}
+
+	if fragments == nil {
+		fragments = tb.state.GetRecentInteractions()
+	} else {
+		fragments = append([]db.Fragment(nil), fragments...)
+	}
+
+	if tb.state.Input != nil {
+		kept := fragments[:0]
+		for _, fragment := range fragments {
+			if fragment.ID != tb.state.Input.ID {
+				kept = append(kept, fragment)
+			}
+		}
+		fragments = kept
+	}
+
+	sort.SliceStable(fragments, func(i, j int) bool {
+		return fragments[i].CreatedAt.Before(fragments[j].CreatedAt)
+	})
+	if opts.MaxMessages > 0 && len(fragments) > opts.MaxMessages {
+		fragments = fragments[len(fragments)-opts.MaxMessages:]
+	}
+
+	if opts.Transcript {
+		if len(fragments) == 0 {
+			return tb
+		}
+		lines := make([]string, len(fragments))
+		for i := range fragments {
+			lines[i] = tb.speaker(&fragments[i]) + ": " + fragments[i].Content
+		}
+		return tb.addHistorySection(llm.RoleUser, strings.Join(lines, "\n"), "", opts)
+	}
+
+	var messages []llm.Message
+	for i := range fragments {
+		message := llm.Message{
+			Role:    tb.historyRole(&fragments[i]),
+			Content: fragments[i].Content,
+		}
+		if actor := tb.actorOf(&fragments[i]); actor != nil {
+			message.Name = messageName(actor.Name)
+		}
+
+		if last := len(messages) - 1; opts.CollapseConsecutive && last >= 0 && messages[last].Role == message.Role {
+			messages[last].Content += "\n\n" + message.Content
+			if messages[last].Name != message.Name {
+				messages[last].Name = ""
+			}
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	for _, message := range messages {
+		tb.addHistorySection(message.Role, message.Content, message.Name, opts)
+	}
+	return tb
+}
+
+// addHistorySection adds a literal section of the conversation history
+func (tb *PromptBuilder) addHistorySection(role llm.Role, content, name string, opts HistoryRenderOptions) *PromptBuilder {
+	tb.sections = append(tb.sections, PromptSection{
+		Role:      role,
+		Template:  content,
+		Name:      name,
+		Literal:   true,
+		Priority:  opts.Priority,
+		Trimmable: opts.Trimmable,
+	})
+	return tb
+}
+
+// historyRole returns the role of a fragment's message. Fragments whose actor
+// isn't loaded are attributed by comparing with the state's assistant.
+func (tb *PromptBuilder) historyRole(fragment *db.Fragment) llm.Role {
+	if actor := tb.actorOf(fragment); actor != nil {
+		if actor.Assistant {
+			return llm.RoleAssistant
+		}
+		return llm.RoleUser
+	}
+	if tb.state.Assistant != nil && fragment.ActorID == tb.state.Assistant.ID {
+		return llm.RoleAssistant
+	}
+	return llm.RoleUser
+}
+
+// actorOf returns the actor of a fragment, from the fragment or the state's
+// session participants, or nil if neither has it
+func (tb *PromptBuilder) actorOf(fragment *db.Fragment) *db.Actor {
+	if fragment.Actor != nil {
+		return fragment.Actor
+	}
+	for _, participant := range tb.state.GetParticipants() {
+		if participant.ActorID == fragment.ActorID && participant.Actor != nil {
+			return participant.Actor
+		}
+	}
+	return nil
+}
+
+// speaker returns the name a fragment's line starts with in a transcript
+func (tb *PromptBuilder) speaker(fragment *db.Fragment) string {
+	if actor := tb.actorOf(fragment); actor != nil && actor.Name != "" {
+		return actor.Name
+	}
+	if tb.historyRole(fragment) == llm.RoleAssistant {
+		return "Assistant"
+	}
+	return "User"
+}
+
+// messageName turns an actor name into a participant name providers accept:
+// letters, digits, underscores and hyphens, at most 64 characters
+func messageName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSpace(name))
+
+	if len(sanitized) > maxMessageNameLength {
+		sanitized = sanitized[:maxMessageNameLength]
+	}
+	return sanitized
+}
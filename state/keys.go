@@ -0,0 +1,149 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// namespaceSeparator separates the owner of a namespaced key from its name
+const namespaceSeparator = "/"
+
+// ErrKeyConflict is matched by the KeyConflictError of manager data written
+// to a key another owner holds
+var ErrKeyConflict = errors.New("state data key conflict")
+
+// KeyConflictError reports manager data written to a key held by another owner
+type KeyConflictError struct {
+	Key           StateDataKey
+	Owner         string // Owner of the rejected or overwriting write
+	PreviousOwner string // Owner holding the key
+}
+
+func (e *KeyConflictError) Error() string {
+	return fmt.Sprintf("state data key %s of %s written by %s", e.Key, e.PreviousOwner, e.Owner)
+}
+
+func (e *KeyConflictError) Is(target error) bool {
+	return target == ErrKeyConflict
+}
+
+// NamespacedKey returns the key of a manager data entry named after its owner,
+// so managers publishing the same name don't collide:
+//
+//	NamespacedKey("insight", "insights") // "insight/insights"
+//
+// GetManagerData and prompt templates also accept the bare name as long as a
+// single namespaced key has it.
+func NamespacedKey(owner, name string) StateDataKey {
+	return StateDataKey(owner + namespaceSeparator + name)
+}
+
+// Namespace returns the owner and name of a namespaced key, and an empty owner
+// with the whole key for others
+func (k StateDataKey) Namespace() (owner, name string) {
+	if i := strings.Index(string(k), namespaceSeparator); i > 0 {
+		return string(k[:i]), string(k[i+len(namespaceSeparator):])
+	}
+	return "", string(k)
+}
+
+// SetStrictKeys makes MergeManagerData reject manager data written to a key
+// held by another owner instead of overwriting it
+func (s *State) SetStrictKeys(strict bool) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strictKeys = strict
+	return s
+}
+
+// StrictKeys reports whether conflicting manager data is rejected
+func (s *State) StrictKeys() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.strictKeys
+}
+
+// MergeManagerData adds StateData entries to the state's manager data,
+// recording their owners. An entry written to a key held by another owner is a
+// conflict, recorded in KeyConflicts: with strict keys it is rejected and its
+// KeyConflictError returned, joined with the others, otherwise it overwrites
+// the key. Entries without owner never conflict.
+func (s *State) MergeManagerData(data []StateData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.managerData == nil {
+		s.managerData = make(map[StateDataKey]interface{})
+	}
+	if s.keyOwners == nil {
+		s.keyOwners = make(map[StateDataKey]string)
+	}
+
+	var rejected []error
+	for _, d := range data {
+		if previous := s.keyOwners[d.Key]; d.Owner != "" && previous != "" && previous != d.Owner {
+			conflict := KeyConflictError{Key: d.Key, Owner: d.Owner, PreviousOwner: previous}
+			s.keyConflicts = append(s.keyConflicts, conflict)
+			if s.strictKeys {
+				rejected = append(rejected, &conflict)
+				continue
+			}
+		}
+
+		s.managerData[d.Key] = d.Value
+		if d.Owner != "" {
+			s.keyOwners[d.Key] = d.Owner
+		}
+	}
+	s.record(func(parent *State) error {
+		return parent.MergeManagerData(data)
+	})
+	return errors.Join(rejected...)
+}
+
+// KeyOwner returns the owner of a manager data key, resolving bare names as
+// GetManagerData does
+func (s *State) KeyOwner(key StateDataKey) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.managerData[key]; !exists {
+		resolved, ok := s.resolveKey(key)
+		if !ok {
+			return "", false
+		}
+		key = resolved
+	}
+	owner, ok := s.keyOwners[key]
+	return owner, ok
+}
+
+// KeyConflicts returns the conflicting manager data writes, in order
+func (s *State) KeyConflicts() []KeyConflictError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]KeyConflictError(nil), s.keyConflicts...)
+}
+
+// resolveKey returns the single namespaced key named key. Must be called with
+// the lock held.
+func (s *State) resolveKey(key StateDataKey) (StateDataKey, bool) {
+	if owner, _ := key.Namespace(); owner != "" {
+		return "", false
+	}
+
+	var resolved StateDataKey
+	found := false
+	for candidate := range s.managerData {
+		owner, name := candidate.Namespace()
+		if owner == "" || name != string(key) {
+			continue
+		}
+		if found {
+			return "", false
+		}
+		resolved, found = candidate, true
+	}
+	return resolved, found
+}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"reflect"
 
 	"github.com/velumlabs/thor/llm"
+	"github.com/velumlabs/thor/options"
 
 	toolkit "github.com/velumlabs/kit/go"
 )
@@ -18,10 +18,79 @@ func NewPromptBuilder(s *State) *PromptBuilder {
 		state:     s,
 		sections:  make([]PromptSection, 0),
 		stateData: make(map[StateDataKey]interface{}),
+		optional:  make(map[StateDataKey]interface{}),
 		helpers:   make(template.FuncMap),
+		counter:   llm.EstimatingTokenCounter,
 	}
 }
 
+// Clone returns an independent copy of the builder bound to the same state, so
+// a base prompt can be built once and forked per request. Sections, helpers,
+// manager data and examples are copied: adding to the clone never changes the
+// original, which remains usable. Tools are held by the state and stay shared.
+//
+//	base := state.NewPromptBuilder(s).AddSystemSection(persona).WithHelper("upper", strings.ToUpper)
+//	reply := base.Clone().AddUserSection("{{.Input.Content}}", "")
+func (tb *PromptBuilder) Clone() *PromptBuilder {
+	clone := *tb
+	// Full slice expressions make appends to either builder reallocate
+	clone.sections = tb.sections[:len(tb.sections):len(tb.sections)]
+	clone.examples = tb.examples[:len(tb.examples):len(tb.examples)]
+
+	clone.stateData = make(map[StateDataKey]interface{}, len(tb.stateData))
+	for key, value := range tb.stateData {
+		clone.stateData[key] = value
+	}
+	clone.optional = make(map[StateDataKey]interface{}, len(tb.optional))
+	for key, value := range tb.optional {
+		clone.optional[key] = value
+	}
+	clone.helpers = make(template.FuncMap, len(tb.helpers))
+	for name, fn := range tb.helpers {
+		clone.helpers[name] = fn
+	}
+	return &clone
+}
+
+// CloneWithState returns a copy of the builder, as Clone does, rendering
+// another state. Manager data added with WithManagerData is read again from
+// that state, failing the clone if it doesn't have a key, and optional manager
+// data falls back to its default.
+func (tb *PromptBuilder) CloneWithState(s *State) *PromptBuilder {
+	clone := tb.Clone()
+	clone.state = s
+	if clone.err != nil {
+		return clone
+	}
+
+	for key := range clone.stateData {
+		value, exists := s.GetManagerData(key)
+		if def, optional := clone.optional[key]; optional && !exists {
+			value, exists = def, true
+		}
+		if !exists {
+			clone.err = fmt.Errorf("manager data for key %s not found", key)
+			return clone
+		}
+		clone.stateData[key] = value
+	}
+	return clone
+}
+
+// WithTokenCounter sets the counter ComposeWithBudget measures sections with,
+// llm.EstimatingTokenCounter by default
+func (tb *PromptBuilder) WithTokenCounter(counter llm.TokenCounter) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	if counter == nil {
+		tb.err = fmt.Errorf("token counter is required")
+		return tb
+	}
+	tb.counter = counter
+	return tb
+}
+
 // Method to register template functions
 func (tb *PromptBuilder) WithHelper(name string, fn interface{}) *PromptBuilder {
 	if tb.err != nil {
@@ -52,6 +121,72 @@ func (tb *PromptBuilder) AddSectionWithName(role llm.Role, templateText, name st
 	return tb
 }
 
+// AddSectionWithOptions adds a new template section with the specified role,
+// configured for ComposeWithBudget by the given section options:
+//
+//	builder.AddSectionWithOptions(llm.RoleUser, historyTemplate,
+//	    state.WithPriority(-1), state.WithTrimmable(), state.WithMaxTokens(2000))
+func (tb *PromptBuilder) AddSectionWithOptions(role llm.Role, templateText string, opts ...options.Option[PromptSection]) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+
+	section := PromptSection{
+		Role:     role,
+		Template: templateText,
+	}
+	if err := options.ApplyOptions(&section, opts...); err != nil {
+		tb.err = fmt.Errorf("invalid prompt section: %w", err)
+		return tb
+	}
+
+	tb.sections = append(tb.sections, section)
+	return tb
+}
+
+// WithRegistry sets the registry AddSectionRef looks templates up in
+func (tb *PromptBuilder) WithRegistry(registry *PromptRegistry) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	tb.registry = registry
+	return tb
+}
+
+// AddSectionRef adds a section rendering a template of the builder's registry,
+// configured like AddSectionWithOptions:
+//
+//	builder.WithRegistry(registry).AddSectionRef(llm.RoleSystem, "persona_v2")
+//
+// Returns an error on Compose if the template isn't registered.
+func (tb *PromptBuilder) AddSectionRef(role llm.Role, name string, opts ...options.Option[PromptSection]) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	if tb.registry == nil {
+		tb.err = fmt.Errorf("prompt template %s referenced without a registry", name)
+		return tb
+	}
+	compiled, ok := tb.registry.lookup(name)
+	if !ok {
+		tb.err = fmt.Errorf("prompt template %s is not registered", name)
+		return tb
+	}
+
+	section := PromptSection{
+		Role:     role,
+		Ref:      name,
+		compiled: compiled,
+	}
+	if err := options.ApplyOptions(&section, opts...); err != nil {
+		tb.err = fmt.Errorf("invalid prompt section: %w", err)
+		return tb
+	}
+
+	tb.sections = append(tb.sections, section)
+	return tb
+}
+
 // Helper methods for common message types
 // Each returns the builder for method chaining
 func (tb *PromptBuilder) AddSystemSection(templateText string) *PromptBuilder {
@@ -81,6 +216,7 @@ func (tb *PromptBuilder) WithManagerData(key StateDataKey) *PromptBuilder {
 
 	// Store the value with its original key
 	tb.stateData[key] = value
+	delete(tb.optional, key)
 	return tb
 }
 
@@ -96,20 +232,43 @@ func (tb *PromptBuilder) WithManagerDataBatch(keys ...StateDataKey) *PromptBuild
 	return tb
 }
 
+// WithManagerDataOptional adds manager data that may be missing from the
+// state, e.g. relevant memories. A missing key is set to nil, so templates
+// guard it with {{if .key}}, in strict mode too.
+func (tb *PromptBuilder) WithManagerDataOptional(key StateDataKey) *PromptBuilder {
+	return tb.WithManagerDataDefault(key, nil)
+}
+
+// WithManagerDataDefault adds manager data that may be missing from the
+// state, rendering def in its place when it is
+func (tb *PromptBuilder) WithManagerDataDefault(key StateDataKey, def interface{}) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+
+	value, exists := tb.state.GetManagerData(key)
+	if !exists {
+		value = def
+	}
+	tb.stateData[key] = value
+	tb.optional[key] = def
+	return tb
+}
+
 // WithTools adds a list of tools to the state
 func (tb *PromptBuilder) WithTools(tools ...toolkit.Tool) *PromptBuilder {
-	tb.state.Tools = append(tb.state.Tools, tools...)
+	tb.state.AddTools(tools...)
 	return tb
 }
 
 // WithToolkit adds a toolkit to the state
 func (tb *PromptBuilder) WithToolkit(toolkit *toolkit.Toolkit) *PromptBuilder {
-	tb.state.Tools = append(tb.state.Tools, toolkit.GetTools()...)
+	tb.state.AddTools(toolkit.GetTools()...)
 	return tb
 }
 
 func (tb *PromptBuilder) GetTools() []toolkit.Tool {
-	return tb.state.Tools
+	return tb.state.GetTools()
 }
 
 // Compose processes all template sections and returns an array of formatted messages
@@ -119,49 +278,135 @@ func (tb *PromptBuilder) Compose() ([]llm.Message, error) {
 		return nil, tb.err
 	}
 
-	messages := make([]llm.Message, 0, len(tb.sections))
+	return tb.render()
+}
 
-	for _, section := range tb.sections {
-		// Create data map and automatically add all exported State fields
-		data := make(map[string]interface{})
-
-		// Add State fields
-		stateValue := reflect.ValueOf(tb.state).Elem()
-		stateType := stateValue.Type()
-		for i := 0; i < stateValue.NumField(); i++ {
-			field := stateType.Field(i)
-			if field.IsExported() {
-				data[field.Name] = stateValue.Field(i).Interface()
-			}
-		}
+// ComposeFlat renders all sections into a single prompt, for models that take
+// no chat roles, ending with the renderer's cue for the assistant. A nil
+// renderer is llm.DefaultRoleRenderer.
+func (tb *PromptBuilder) ComposeFlat(renderer llm.RoleRenderer) (string, error) {
+	return tb.ComposeFlatWithOptions(llm.FlatPromptOptions{Renderer: renderer})
+}
 
-		// Add manager data with proper key names
-		for k, v := range tb.stateData {
-			data[string(k)] = v
-		}
+// ComposeFlatWithOptions renders all sections into a single prompt as
+// ComposeFlat does, appending the state's tools if opts include them
+func (tb *PromptBuilder) ComposeFlatWithOptions(opts llm.FlatPromptOptions) (string, error) {
+	messages, err := tb.Compose()
+	if err != nil {
+		return "", err
+	}
+	return llm.FlattenMessages(messages, tb.state.GetTools(), opts)
+}
 
-		// Add custom data
-		for k, v := range tb.state.customData {
-			data[k] = v
-		}
+// render executes the template of every section, in order
+func (tb *PromptBuilder) render() ([]llm.Message, error) {
+	messages := make([]llm.Message, 0, len(tb.sections))
 
-		// Create and execute template
-		tmpl, err := template.New("section").Funcs(tb.helpers).Parse(section.Template)
+	data := tb.templateDataFor(tb.referencedData())
+	for i, section := range tb.sections {
+		content, err := tb.renderSection(i, section, data, tb.strict)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse template section: %w", err)
-		}
-
-		var buf bytes.Buffer
-		if err := tmpl.Execute(&buf, data); err != nil {
-			return nil, fmt.Errorf("failed to execute template section (role=%s): %w", section.Role, err)
+			return nil, err
 		}
 
 		messages = append(messages, llm.Message{
 			Role:    section.Role,
-			Content: buf.String(),
+			Content: content,
 			Name:    section.Name,
 		})
 	}
 
 	return messages, nil
 }
+
+// templateData returns the data sections are executed against
+func (tb *PromptBuilder) templateData() map[string]interface{} {
+	return tb.templateDataFor(nil)
+}
+
+// referencedData returns the top-level keys the sections may read, or nil if
+// any may be read, e.g. by a section passing the whole data to a helper or
+// failing to parse
+func (tb *PromptBuilder) referencedData() map[string]bool {
+	referenced := make(map[string]bool)
+	for _, section := range tb.sections {
+		if err := tb.sectionReferences(section, referenced); err != nil {
+			return nil
+		}
+	}
+	if referenced[wholeDataKey] {
+		return nil
+	}
+	return referenced
+}
+
+// templateDataFor returns the data sections are executed against, leaving
+// out the state's interactions unless referenced, or nothing if it is nil
+func (tb *PromptBuilder) templateDataFor(referenced map[string]bool) map[string]interface{} {
+	// Create data map from a snapshot of the exported State fields, so managers
+	// still writing to the state don't race with the templates
+	data := tb.state.templateData(referenced)
+
+	// Add manager data with proper key names, and namespaced keys under their
+	// bare name too unless several share it
+	names := make(map[string]int)
+	for k, v := range tb.stateData {
+		data[string(k)] = v
+		if owner, name := k.Namespace(); owner != "" {
+			names[name]++
+		}
+	}
+	for k, v := range tb.stateData {
+		if owner, name := k.Namespace(); owner != "" && names[name] == 1 {
+			if _, exists := data[name]; !exists {
+				data[name] = v
+			}
+		}
+	}
+
+	// Add custom data
+	for k, v := range tb.state.CustomDataSnapshot() {
+		data[k] = v
+	}
+
+	return data
+}
+
+// renderSection returns the content of a section, executing its template
+// against data
+func (tb *PromptBuilder) renderSection(index int, section PromptSection, data map[string]interface{}, strict bool) (string, error) {
+	if section.Literal {
+		return section.Template, nil
+	}
+
+	compiled, err := tb.compiledSection(section)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := compiled.bind(tb.helpers, strict)
+	if err != nil {
+		return "", fmt.Errorf("failed to bind template section helpers: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		if key, ok := missingKey(err); ok {
+			return "", &MissingKeyError{Section: index, Role: section.Role, Key: key, Err: err}
+		}
+		return "", fmt.Errorf("failed to execute template section %d (role=%s): %w", index, section.Role, err)
+	}
+	return buf.String(), nil
+}
+
+// compiledSection returns the parsed template of a section: the registry's,
+// or its text parsed once per text
+func (tb *PromptBuilder) compiledSection(section PromptSection) (*compiledTemplate, error) {
+	if section.compiled != nil {
+		return section.compiled, nil
+	}
+	compiled, err := cachedTemplate(section.Template, tb.helpers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template section: %w", err)
+	}
+	return compiled, nil
+}
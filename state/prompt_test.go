@@ -0,0 +1,508 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/llm"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+// contentsOf composes a builder and returns the contents of its messages
+func contentsOf(t *testing.T, builder *PromptBuilder) []string {
+	t.Helper()
+	messages, err := builder.Compose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make([]string, len(messages))
+	for i, message := range messages {
+		contents[i] = message.Content
+	}
+	return contents
+}
+
+func TestCloneIsolation(t *testing.T) {
+	s := NewState().AddManagerData([]StateData{
+		{Key: "persona", Value: "Thor"},
+		{Key: "first", Value: "one"},
+		{Key: "second", Value: "two"},
+	})
+	base := NewPromptBuilder(s).
+		WithManagerData("persona").
+		WithHelper("decorate", strings.ToUpper).
+		AddSystemSection("You are {{decorate .persona}}")
+	// Spare capacity in the base's sections would be shared by naive appends
+	base.AddSystemSection("Be brief").AddSystemSection("Be kind")
+
+	tests := []struct {
+		name   string
+		key    StateDataKey
+		suffix string
+		want   []string
+	}{
+		{name: "first", key: "first", suffix: "!", want: []string{"You are Thor!", "Be brief", "Be kind", "one!"}},
+		{name: "second", key: "second", suffix: "?", want: []string{"You are Thor?", "Be brief", "Be kind", "two?"}},
+	}
+
+	clones := make([]*PromptBuilder, len(tests))
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func(i int, key StateDataKey, suffix string) {
+			defer wg.Done()
+			clones[i] = base.Clone().
+				WithManagerData(key).
+				WithHelper("decorate", func(text string) string { return text + suffix }).
+				AddSystemSection(fmt.Sprintf("{{decorate .%s}}", key))
+			for j := 0; j < 100; j++ {
+				if _, err := clones[i].Compose(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i, tt.key, tt.suffix)
+	}
+	wg.Wait()
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := contentsOf(t, clones[i])
+			// The base's sections render with the clone's helper
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("clone messages = %q, want %q", got, tt.want)
+			}
+			if _, ok := clones[i].stateData[tests[1-i].key]; ok {
+				t.Errorf("clone has the manager data of the other clone")
+			}
+		})
+	}
+
+	// The original remains usable and unchanged
+	want := []string{"You are THOR", "Be brief", "Be kind"}
+	if got := contentsOf(t, base); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Errorf("base messages = %q, want %q", got, want)
+	}
+	base.AddUserSection("Hello", "")
+	for i := range tests {
+		if got := len(clones[i].sections); got != 4 {
+			t.Errorf("clone %d has %d sections after adding to the base, want 4", i, got)
+		}
+	}
+}
+
+func TestCloneWithState(t *testing.T) {
+	base := NewPromptBuilder(NewState().AddManagerData([]StateData{{Key: "summary", Value: "old"}})).
+		WithManagerData("summary").
+		WithManagerDataDefault("memories", "none").
+		AddSystemSection("{{.summary}} / {{.memories}}")
+
+	tests := []struct {
+		name    string
+		data    []StateData
+		want    string
+		wantErr string
+	}{
+		{
+			name: "data read again",
+			data: []StateData{{Key: "summary", Value: "new"}, {Key: "memories", Value: "likes tea"}},
+			want: "new / likes tea",
+		},
+		{
+			name: "optional data missing",
+			data: []StateData{{Key: "summary", Value: "new"}},
+			want: "new / none",
+		},
+		{
+			name:    "required data missing",
+			data:    []StateData{{Key: "memories", Value: "likes tea"}},
+			wantErr: "manager data for key summary not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clone := base.CloneWithState(NewState().AddManagerData(tt.data))
+			messages, err := clone.Compose()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Compose = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messages[0].Content != tt.want {
+				t.Errorf("message = %q, want %q", messages[0].Content, tt.want)
+			}
+		})
+	}
+
+	if got := contentsOf(t, base); got[0] != "old / none" {
+		t.Errorf("base message = %q after cloning, want the original state's", got[0])
+	}
+}
+
+func TestCloneError(t *testing.T) {
+	failed := NewPromptBuilder(NewState()).WithManagerData("missing")
+	clone := failed.Clone().AddSection(llm.RoleUser, "Hello")
+	if _, err := clone.Compose(); err == nil {
+		t.Error("clone of a failed builder composed")
+	}
+}
+
+func TestWithManagerDataOptional(t *testing.T) {
+	const guarded = "{{if .memories}}Memories: {{.memories}}{{else}}No memories{{end}}"
+
+	tests := []struct {
+		name     string
+		data     []StateData
+		strict   bool
+		add      func(tb *PromptBuilder) *PromptBuilder
+		template string
+		want     string
+		// wantErr is part of the error expected instead of a message
+		wantErr    string
+		missingKey bool
+	}{
+		{
+			name:     "optional present",
+			data:     []StateData{{Key: "memories", Value: "likes tea"}},
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataOptional("memories") },
+			template: guarded,
+			want:     "Memories: likes tea",
+		},
+		{
+			name:     "optional missing",
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataOptional("memories") },
+			template: guarded,
+			want:     "No memories",
+		},
+		{
+			name:     "optional missing in strict mode",
+			strict:   true,
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataOptional("memories") },
+			template: guarded,
+			want:     "No memories",
+		},
+		{
+			name:     "default present",
+			data:     []StateData{{Key: "memories", Value: "likes tea"}},
+			strict:   true,
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataDefault("memories", "nothing yet") },
+			template: "{{.memories}}",
+			want:     "likes tea",
+		},
+		{
+			name:     "default missing in strict mode",
+			strict:   true,
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataDefault("memories", "nothing yet") },
+			template: "{{.memories}}",
+			want:     "nothing yet",
+		},
+		{
+			name:     "bare name of a namespaced key",
+			data:     []StateData{{Key: "memory/memories", Value: "likes tea"}},
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerDataOptional("memories") },
+			template: guarded,
+			want:     "Memories: likes tea",
+		},
+		{
+			name: "required after optional",
+			add: func(tb *PromptBuilder) *PromptBuilder {
+				return tb.WithManagerDataOptional("memories").WithManagerData("memories")
+			},
+			template: guarded,
+			wantErr:  "manager data for key memories not found",
+		},
+		{
+			name:     "required missing",
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb.WithManagerData("memories") },
+			template: guarded,
+			wantErr:  "manager data for key memories not found",
+		},
+		{
+			name:       "not added in strict mode",
+			strict:     true,
+			add:        func(tb *PromptBuilder) *PromptBuilder { return tb },
+			template:   guarded,
+			wantErr:    `references missing key "memories"`,
+			missingKey: true,
+		},
+		{
+			name:     "not added",
+			add:      func(tb *PromptBuilder) *PromptBuilder { return tb },
+			template: guarded,
+			want:     "No memories",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := NewPromptBuilder(NewState().AddManagerData(tt.data))
+			if tt.strict {
+				builder = builder.WithStrictTemplates()
+			}
+			messages, err := tt.add(builder).AddSystemSection(tt.template).Compose()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Compose = %v, want %q", err, tt.wantErr)
+				}
+				var missing *MissingKeyError
+				if errors.As(err, &missing) != tt.missingKey {
+					t.Errorf("Compose = %v, want a MissingKeyError %v", err, tt.missingKey)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messages[0].Content != tt.want {
+				t.Errorf("message = %q, want %q", messages[0].Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferencedData(t *testing.T) {
+	history := []db.Fragment{{Content: "{{.RecentInteractions}}"}}
+	tests := []struct {
+		name     string
+		build    func(builder *PromptBuilder) *PromptBuilder
+		recent   bool
+		relevant bool
+	}{
+		{
+			name: "none",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("Summary: {{.summary}}")
+			},
+		},
+		{
+			name: "field",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("{{len .RecentInteractions}} recent messages")
+			},
+			recent: true,
+		},
+		{
+			name: "range",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("{{range .RelevantInteractions}}{{.Content}}{{end}}")
+			},
+			relevant: true,
+		},
+		{
+			name: "root variable",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("{{with .summary}}{{len $.RelevantInteractions}}{{end}}")
+			},
+			relevant: true,
+		},
+		{
+			name: "index",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection(`{{len (index $ "RecentInteractions")}}`)
+			},
+			recent:   true,
+			relevant: true,
+		},
+		{
+			name: "whole data",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("{{printf \"%d\" (len .)}}")
+			},
+			recent:   true,
+			relevant: true,
+		},
+		{
+			name: "literal section",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddConversationHistory(history, HistoryRenderOptions{})
+			},
+		},
+		{
+			name: "parse error",
+			build: func(builder *PromptBuilder) *PromptBuilder {
+				return builder.AddSystemSection("{{.summary")
+			},
+			recent:   true,
+			relevant: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState().
+				AddManagerData([]StateData{{Key: "summary", Value: "Alice greeted the assistant."}}).
+				SetRecentInteractions(make([]db.Fragment, 2))
+			builder := tt.build(NewPromptBuilder(s).WithManagerData("summary"))
+
+			data := builder.templateDataFor(builder.referencedData())
+			if _, ok := data["RecentInteractions"]; ok != tt.recent {
+				t.Errorf("RecentInteractions in data = %v, want %v", ok, tt.recent)
+			}
+			if _, ok := data["RelevantInteractions"]; ok != tt.relevant {
+				t.Errorf("RelevantInteractions in data = %v, want %v", ok, tt.relevant)
+			}
+			if data["summary"] != "Alice greeted the assistant." {
+				t.Errorf("summary = %v, want the manager data", data["summary"])
+			}
+		})
+	}
+}
+
+// tenSectionBuilder returns a builder of ten sections over a state with many
+// interactions, reading them if interactions is set
+func tenSectionBuilder(interactions bool) *PromptBuilder {
+	fragments := make([]db.Fragment, 200)
+	for i := range fragments {
+		fragments[i].Content = fmt.Sprintf("message %d", i)
+	}
+	s := NewState().
+		AddManagerData([]StateData{{Key: "summary", Value: "Alice greeted the assistant."}}).
+		AddCustomData("platform", "discord").
+		SetRecentInteractions(fragments).
+		SetRelevantInteractions(fragments)
+
+	builder := NewPromptBuilder(s).WithManagerData("summary")
+	for i := 0; i < 9; i++ {
+		builder.AddSystemSection(fmt.Sprintf("Section %d: {{.summary}} on {{.platform}}", i))
+	}
+	if interactions {
+		return builder.AddSystemSection("{{len .RecentInteractions}} recent, {{len .RelevantInteractions}} relevant")
+	}
+	return builder.AddSystemSection("Section 9: {{.summary}}")
+}
+
+func TestRenderMatchesFullData(t *testing.T) {
+	for _, interactions := range []bool{false, true} {
+		builder := tenSectionBuilder(interactions)
+		got := contentsOf(t, builder)
+		for i, section := range builder.sections {
+			want, err := builder.renderSection(i, section, builder.templateData(), builder.strict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[i] != want {
+				t.Errorf("section %d = %q, want %q as rendered with all the data", i, got[i], want)
+			}
+		}
+	}
+}
+
+func BenchmarkCompose10Sections(b *testing.B) {
+	for _, bench := range []struct {
+		name         string
+		interactions bool
+	}{
+		{name: "unreferenced interactions"},
+		{name: "referenced interactions", interactions: true},
+	} {
+		builder := tenSectionBuilder(bench.interactions)
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := builder.Compose(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// The data built for every section, as before it was built once per call
+	builder := tenSectionBuilder(true)
+	b.Run("data per section", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j, section := range builder.sections {
+				if _, err := builder.renderSection(j, section, builder.templateData(), builder.strict); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// searchTool is a tool for flat prompts, which only read its definition
+type searchTool struct{}
+
+func (searchTool) GetName() string        { return "search" }
+func (searchTool) GetDescription() string { return "Searches the web" }
+func (searchTool) GetSchema() toolkit.Schema {
+	return toolkit.Schema{Parameters: json.RawMessage(`{"type":"object"}`)}
+}
+
+func (searchTool) Execute(ctx context.Context, params json.RawMessage) (json.RawMessage, error) {
+	return nil, nil
+}
+
+func TestComposeFlat(t *testing.T) {
+	s := NewState().
+		AddManagerData([]StateData{{Key: "persona", Value: "Thor"}}).
+		AddTools(searchTool{})
+	builder := NewPromptBuilder(s).
+		WithManagerData("persona").
+		AddSystemSection("You are {{.persona}}.").
+		AddUserSection("Hello", "alice")
+
+	tests := []struct {
+		name string
+		opts llm.FlatPromptOptions
+		want string
+	}{
+		{
+			name: "default renderer",
+			want: "<|im_start|>system\nYou are Thor.<|im_end|>\n" +
+				"<|im_start|>user (alice)\nHello<|im_end|>\n" +
+				"<|im_start|>assistant\n",
+		},
+		{
+			name: "heading renderer",
+			opts: llm.FlatPromptOptions{Renderer: llm.HeadingRenderer{}},
+			want: "### System:\nYou are Thor.\n\n### User (alice):\nHello\n\n### Assistant:\n",
+		},
+		{
+			name: "state tools",
+			opts: llm.FlatPromptOptions{Renderer: llm.HeadingRenderer{}, IncludeTools: true},
+			want: "### System:\nYou are Thor.\n\n### User (alice):\nHello\n\n" +
+				"### System:\nAvailable tools:\n```json\n[\n  {\n    \"name\": \"search\",\n    \"description\": \"Searches the web\",\n    \"parameters\": {\n      \"type\": \"object\"\n    }\n  }\n]\n```\n\n" +
+				"### Assistant:\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := builder.ComposeFlatWithOptions(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("flat prompt =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	got, err := builder.ComposeFlat(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != tests[0].want {
+		t.Errorf("ComposeFlat(nil) =\n%s\nwant the default renderer's\n%s", got, tests[0].want)
+	}
+}
+
+func TestComposeFlatError(t *testing.T) {
+	builder := NewPromptBuilder(NewState()).AddSystemSection("{{.missing")
+	if _, err := builder.ComposeFlat(llm.HeadingRenderer{}); err == nil {
+		t.Error("ComposeFlat succeeded with an invalid template")
+	}
+}
@@ -0,0 +1,156 @@
+package state
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/velumlabs/thor/options"
+)
+
+// PromptRegistry holds named section templates parsed once, typically at
+// startup, so prompts reference them by name with AddSectionRef instead of
+// carrying their text around. Templates are validated when registered. It is
+// safe for concurrent use.
+type PromptRegistry struct {
+	helpers template.FuncMap
+	version string
+
+	mu        sync.RWMutex
+	templates map[string]*compiledTemplate
+}
+
+// NewPromptRegistry creates an empty PromptRegistry
+func NewPromptRegistry(opts ...options.Option[PromptRegistry]) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		helpers:   make(template.FuncMap),
+		templates: make(map[string]*compiledTemplate),
+	}
+	if err := options.ApplyOptions(r, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create prompt registry: %w", err)
+	}
+	return r, nil
+}
+
+// WithRegistryHelper makes a template function available to the registry's
+// templates. Builders using them may override it with WithHelper.
+func WithRegistryHelper(name string, fn interface{}) options.Option[PromptRegistry] {
+	return func(r *PromptRegistry) error {
+		if name == "" || fn == nil {
+			return fmt.Errorf("helper name and function are required")
+		}
+		r.helpers[name] = fn
+		return nil
+	}
+}
+
+// WithRegistryVersion sets the version of the registry's prompts, reported by
+// the PromptInfo of builders using it
+func WithRegistryVersion(version string) options.Option[PromptRegistry] {
+	return func(r *PromptRegistry) error {
+		r.version = version
+		return nil
+	}
+}
+
+// Version returns the version of the registry's prompts
+func (r *PromptRegistry) Version() string {
+	return r.version
+}
+
+// Register parses a template under a name. Returns an error if the name is
+// taken or the template doesn't parse.
+func (r *PromptRegistry) Register(name, text string) error {
+	if name == "" {
+		return fmt.Errorf("template name is required")
+	}
+
+	compiled, err := compileTemplate(name, text, r.helpers)
+	if err != nil {
+		return fmt.Errorf("failed to parse prompt template %s: %w", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.templates[name]; exists {
+		return fmt.Errorf("prompt template %s is already registered", name)
+	}
+	r.templates[name] = compiled
+	return nil
+}
+
+// LoadFS registers the files of fsys matching pattern, named after their path
+// without extension, so prompts can live in files embedded into the binary:
+//
+//	//go:embed prompts
+//	var promptFiles embed.FS
+//
+//	registry, err := state.NewPromptRegistry()
+//	...
+//	// prompts/persona_v2.tmpl is registered as "prompts/persona_v2"
+//	err = registry.LoadFS(promptFiles, "prompts/*.tmpl")
+//
+// Nothing is registered if any file fails to load.
+func (r *PromptRegistry) LoadFS(fsys fs.FS, pattern string) error {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to list prompt templates: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no prompt templates match %s", pattern)
+	}
+
+	loaded := make(map[string]*compiledTemplate, len(paths))
+	for _, p := range paths {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("failed to read prompt template %s: %w", p, err)
+		}
+		name := strings.TrimSuffix(p, path.Ext(p))
+		if _, exists := loaded[name]; exists {
+			return fmt.Errorf("prompt template %s is defined by several files", name)
+		}
+		compiled, err := compileTemplate(name, string(content), r.helpers)
+		if err != nil {
+			return fmt.Errorf("failed to parse prompt template %s: %w", p, err)
+		}
+		loaded[name] = compiled
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range loaded {
+		if _, exists := r.templates[name]; exists {
+			return fmt.Errorf("prompt template %s is already registered", name)
+		}
+	}
+	for name, compiled := range loaded {
+		r.templates[name] = compiled
+	}
+	return nil
+}
+
+// Names returns the names of the registered templates, sorted
+func (r *PromptRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns a registered template
+func (r *PromptRegistry) lookup(name string) (*compiledTemplate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	compiled, ok := r.templates[name]
+	return compiled, ok
+}
@@ -0,0 +1,254 @@
+package state
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/velumlabs/thor/llm"
+)
+
+// composeOne composes a builder's single section
+func composeOne(t *testing.T, builder *PromptBuilder) string {
+	t.Helper()
+	messages, err := builder.Compose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("messages = %+v, want one", messages)
+	}
+	return messages[0].Content
+}
+
+func TestPromptRegistryRegister(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		valid bool
+	}{
+		{name: "persona", text: "You are {{.name}}", valid: true},
+		{name: "helper", text: "You are {{upper .name}}", valid: true},
+		{name: "", text: "Unnamed"},
+		{name: "broken", text: "You are {{.name"},
+		{name: "unknown helper", text: "You are {{lower .name}}"},
+		{name: "existing", text: "Taken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewPromptRegistry(WithRegistryHelper("upper", strings.ToUpper))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := registry.Register("existing", "Hi"); err != nil {
+				t.Fatal(err)
+			}
+			if err := registry.Register(tt.name, tt.text); (err == nil) != tt.valid {
+				t.Errorf("Register(%q, %q) = %v, want valid %v", tt.name, tt.text, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestPromptRegistryLoadFS(t *testing.T) {
+	files := fstest.MapFS{
+		"prompts/persona_v2.tmpl": {Data: []byte("You are {{.name}}")},
+		"prompts/rules.tmpl":      {Data: []byte("Be brief")},
+		"prompts/readme.md":       {Data: []byte("Not a template")},
+		"broken/bad.tmpl":         {Data: []byte("{{.name")},
+		"broken/good.tmpl":        {Data: []byte("Fine")},
+		"twice/rules.tmpl":        {Data: []byte("Be brief")},
+		"twice/rules.txt":         {Data: []byte("Be brief")},
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{name: "templates", pattern: "prompts/*.tmpl", want: []string{"prompts/persona_v2", "prompts/rules"}},
+		{name: "no match", pattern: "missing/*.tmpl"},
+		{name: "invalid pattern", pattern: "prompts/[.tmpl"},
+		{name: "broken file registers nothing", pattern: "broken/*.tmpl"},
+		{name: "same name twice", pattern: "twice/*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := NewPromptRegistry()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = registry.LoadFS(files, tt.pattern)
+			if (err == nil) != (tt.want != nil) {
+				t.Fatalf("LoadFS(%q) = %v, want success %v", tt.pattern, err, tt.want != nil)
+			}
+			if got := registry.Names(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddSectionRef(t *testing.T) {
+	registry, err := NewPromptRegistry(WithRegistryHelper("upper", strings.ToUpper))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("persona_v2", "You are {{upper .name}}"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewState().AddCustomData("name", "Thor")
+
+	tests := []struct {
+		name    string
+		builder func() *PromptBuilder
+		want    string
+		wantErr string
+	}{
+		{
+			name: "registered",
+			builder: func() *PromptBuilder {
+				return NewPromptBuilder(s).WithRegistry(registry).AddSectionRef(llm.RoleSystem, "persona_v2")
+			},
+			want: "You are THOR",
+		},
+		{
+			name: "helper overridden by the builder",
+			builder: func() *PromptBuilder {
+				return NewPromptBuilder(s).WithRegistry(registry).
+					WithHelper("upper", func(text string) string { return "[" + text + "]" }).
+					AddSectionRef(llm.RoleSystem, "persona_v2")
+			},
+			want: "You are [Thor]",
+		},
+		{
+			name: "not registered",
+			builder: func() *PromptBuilder {
+				return NewPromptBuilder(s).WithRegistry(registry).AddSectionRef(llm.RoleSystem, "persona_v3")
+			},
+			wantErr: "prompt template persona_v3 is not registered",
+		},
+		{
+			name: "without registry",
+			builder: func() *PromptBuilder {
+				return NewPromptBuilder(s).AddSectionRef(llm.RoleSystem, "persona_v2")
+			},
+			wantErr: "without a registry",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.wantErr != "" {
+				if _, err := tt.builder().Compose(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Compose = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if got := composeOne(t, tt.builder()); got != tt.want {
+				t.Errorf("section = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedTemplate(t *testing.T) {
+	upper := template.FuncMap{"upper": strings.ToUpper}
+	first, err := cachedTemplate("Hello {{.name}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		helpers template.FuncMap
+		same    bool
+	}{
+		{name: "same text", text: "Hello {{.name}}", same: true},
+		{name: "other text", text: "Bye {{.name}}"},
+		{name: "other helpers", text: "Hello {{.name}}", helpers: upper},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cachedTemplate(tt.text, tt.helpers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == first) != tt.same {
+				t.Errorf("cached template shared = %v, want %v", got == first, tt.same)
+			}
+		})
+	}
+}
+
+func TestCachedTemplateHelpers(t *testing.T) {
+	// Builders sharing a cached template still execute their own helpers
+	s := NewState().AddCustomData("name", "thor")
+	for _, tt := range []struct {
+		helper func(string) string
+		want   string
+	}{
+		{helper: strings.ToUpper, want: "Hello THOR"},
+		{helper: strings.ToLower, want: "Hello thor"},
+		{helper: func(text string) string { return text + "!" }, want: "Hello thor!"},
+	} {
+		builder := NewPromptBuilder(s).WithHelper("shout", tt.helper).AddSystemSection("Hello {{shout .name}}")
+		if got := composeOne(t, builder); got != tt.want {
+			t.Errorf("section = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func BenchmarkComposeTemplates(b *testing.B) {
+	s := NewState().AddCustomData("name", "Thor").AddCustomData("platform", "discord")
+	const persona = `You are {{.name}}, an assistant on {{.platform}}.
+{{if eq .platform "discord"}}Keep answers short and use markdown sparingly.{{else}}Answer in full sentences.{{end}}
+{{range $i, $rule := .rules}}{{$i}}. {{$rule}}
+{{end}}`
+	s.AddCustomData("rules", []string{"Be kind", "Be accurate", "Cite sources", "Never reveal secrets"})
+
+	registry, err := NewPromptRegistry()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := registry.Register("persona", persona); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("parsed on every call", func(b *testing.B) {
+		b.ReportAllocs()
+		data := NewPromptBuilder(s).templateData()
+		for i := 0; i < b.N; i++ {
+			tmpl, err := template.New("section").Parse(persona)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var buf strings.Builder
+			if err := tmpl.Execute(&buf, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		builder := NewPromptBuilder(s).AddSystemSection(persona)
+		for i := 0; i < b.N; i++ {
+			if _, err := builder.Compose(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("registry", func(b *testing.B) {
+		b.ReportAllocs()
+		builder := NewPromptBuilder(s).WithRegistry(registry).AddSectionRef(llm.RoleSystem, "persona")
+		for i := 0; i < b.N; i++ {
+			if _, err := builder.Compose(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"github.com/velumlabs/thor/options"
+)
+
+// ResetOptions controls what Reset keeps
+type ResetOptions struct {
+	KeepTools bool
+	KeepInput bool     // Keep the input and its actor
+	KeepKeys  []string // Manager or custom data keys to keep
+}
+
+// ResetOption configures a Reset call
+type ResetOption = options.Option[ResetOptions]
+
+// KeepTools keeps the state's tools
+func KeepTools() ResetOption {
+	return func(o *ResetOptions) error {
+		o.KeepTools = true
+		return nil
+	}
+}
+
+// KeepInput keeps the state's input and its actor
+func KeepInput() ResetOption {
+	return func(o *ResetOptions) error {
+		o.KeepInput = true
+		return nil
+	}
+}
+
+// KeepKeys keeps the manager data and custom data under the given keys, e.g.
+// a trace ID
+func KeepKeys(keys ...string) ResetOption {
+	return func(o *ResetOptions) error {
+		o.KeepKeys = append(o.KeepKeys, keys...)
+		return nil
+	}
+}
+
+// Reset clears the state for another attempt at the same turn, e.g. in a retry
+// loop: the input and its actor, the output, the interactions, the tools, the
+// failed managers and all manager and custom data, except what the options
+// keep. The assistant, the transaction and the compensations are left to the
+// pipeline owning them. The engine creates a state per input and doesn't reset
+// it between phases, so Reset is only called by code reusing a state:
+//
+//	s.Reset(state.KeepInput(), state.KeepTools(), state.KeepKeys("trace_id"))
+func (s *State) Reset(opts ...ResetOption) error {
+	var resetOpts ResetOptions
+	if err := options.ApplyOptions(&resetOpts, opts...); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !resetOpts.KeepInput {
+		s.Input = nil
+		s.Actor = nil
+	}
+	if !resetOpts.KeepTools {
+		s.Tools = nil
+	}
+	s.Output = nil
+	s.RecentInteractions = nil
+	s.RelevantInteractions = nil
+	s.Participants = nil
+	s.FailedManagers = nil
+
+	s.resetManagerData(resetOpts.KeepKeys)
+	s.resetCustomData(resetOpts.KeepKeys)
+	return nil
+}
+
+// ResetManagerData clears the manager data, with the owners and conflicts of
+// its keys, keeping everything else
+func (s *State) ResetManagerData() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetManagerData(nil)
+	return s
+}
+
+// ResetCustomData clears the custom data except the given keys
+func (s *State) ResetCustomData(except ...string) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resetCustomData(except)
+	return s
+}
+
+// resetManagerData clears the manager data except the given keys. Must be
+// called with the lock held.
+func (s *State) resetManagerData(keep []string) {
+	managerData := make(map[StateDataKey]interface{})
+	keyOwners := make(map[StateDataKey]string)
+	for _, key := range keep {
+		if value, exists := s.managerData[StateDataKey(key)]; exists {
+			managerData[StateDataKey(key)] = value
+		}
+		if owner, exists := s.keyOwners[StateDataKey(key)]; exists {
+			keyOwners[StateDataKey(key)] = owner
+		}
+	}
+	s.managerData = managerData
+	s.keyOwners = keyOwners
+	s.keyConflicts = nil
+}
+
+// resetCustomData clears the custom data except the given keys. Must be
+// called with the lock held.
+func (s *State) resetCustomData(keep []string) {
+	customData := make(map[string]interface{})
+	for _, key := range keep {
+		if value, exists := s.customData[key]; exists {
+			customData[key] = value
+		}
+	}
+	s.customData = customData
+}
@@ -0,0 +1,204 @@
+package state
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+
+	toolkit "github.com/velumlabs/kit/go"
+	"gorm.io/gorm"
+)
+
+// newResetState returns a state with every field and some manager and custom
+// data set, with a conflicting write of the summary key
+func newResetState() *State {
+	var tool toolkit.Tool
+	s := NewState().
+		AddManagerData([]StateData{
+			{Key: "summary", Value: "Alice greeted the assistant.", Owner: "summary"},
+			{Key: "trace_id", Value: "manager-trace", Owner: "tracer"},
+		}).
+		AddManagerData([]StateData{{Key: "summary", Value: "overwritten", Owner: "insight"}}).
+		AddCustomData("trace_id", "abc").
+		AddCustomData("platform", "discord").
+		AddCustomData("mood", "happy").
+		SetRecentInteractions(make([]db.Fragment, 1)).
+		SetRelevantInteractions(make([]db.Fragment, 1)).
+		SetParticipants(make([]db.SessionActor, 1)).
+		AddTools(tool).
+		AddCompensation(func() error { return nil })
+	s.Input = &db.Fragment{Content: "Hello"}
+	s.Output = &db.Fragment{Content: "Hi"}
+	s.Actor = &db.Actor{Name: "alice"}
+	s.Assistant = &db.Actor{Name: "thor"}
+	s.FailedManagers = []string{"memory"}
+	s.SetTransaction(&gorm.DB{})
+	return s
+}
+
+// survivors lists what is set in the state, sorted
+func survivors(s *State) []string {
+	var set []string
+	fields := map[string]bool{
+		"Input":                s.Input != nil,
+		"Output":               s.Output != nil,
+		"Actor":                s.Actor != nil,
+		"Assistant":            s.Assistant != nil,
+		"RecentInteractions":   len(s.GetRecentInteractions()) > 0,
+		"RelevantInteractions": len(s.GetRelevantInteractions()) > 0,
+		"Participants":         len(s.GetParticipants()) > 0,
+		"Tools":                len(s.GetTools()) > 0,
+		"FailedManagers":       len(s.FailedManagers) > 0,
+		"transaction":          s.Transaction() != nil,
+		"conflicts":            len(s.KeyConflicts()) > 0,
+	}
+	for name, ok := range fields {
+		if ok {
+			set = append(set, name)
+		}
+	}
+	for key := range s.ManagerDataSnapshot() {
+		owner, _ := s.KeyOwner(key)
+		set = append(set, "manager:"+string(key)+" by "+owner)
+	}
+	for key := range s.CustomDataSnapshot() {
+		set = append(set, "custom:"+key)
+	}
+	sort.Strings(set)
+	return set
+}
+
+func TestReset(t *testing.T) {
+	// Reset never touches what the pipeline owns
+	owned := []string{"Assistant", "transaction"}
+
+	tests := []struct {
+		name  string
+		reset func(s *State) error
+		want  []string
+	}{
+		{
+			name:  "everything",
+			reset: func(s *State) error { return s.Reset() },
+			want:  owned,
+		},
+		{
+			name:  "keep tools",
+			reset: func(s *State) error { return s.Reset(KeepTools()) },
+			want:  append([]string{"Tools"}, owned...),
+		},
+		{
+			name:  "keep input",
+			reset: func(s *State) error { return s.Reset(KeepInput()) },
+			want:  append([]string{"Input", "Actor"}, owned...),
+		},
+		{
+			name:  "keep keys",
+			reset: func(s *State) error { return s.Reset(KeepKeys("trace_id", "platform"), KeepKeys("summary")) },
+			want: append([]string{
+				"manager:summary by insight",
+				"manager:trace_id by tracer",
+				"custom:trace_id",
+				"custom:platform",
+			}, owned...),
+		},
+		{
+			name:  "keep missing key",
+			reset: func(s *State) error { return s.Reset(KeepKeys("missing")) },
+			want:  owned,
+		},
+		{
+			name:  "keep all",
+			reset: func(s *State) error { return s.Reset(KeepInput(), KeepTools(), KeepKeys("trace_id")) },
+			want:  append([]string{"Input", "Actor", "Tools", "manager:trace_id by tracer", "custom:trace_id"}, owned...),
+		},
+		{
+			name: "manager data",
+			reset: func(s *State) error {
+				s.ResetManagerData()
+				return nil
+			},
+			want: []string{
+				"Input", "Output", "Actor", "Assistant", "RecentInteractions", "RelevantInteractions",
+				"Participants", "Tools", "FailedManagers", "transaction",
+				"custom:trace_id", "custom:platform", "custom:mood",
+			},
+		},
+		{
+			name: "custom data",
+			reset: func(s *State) error {
+				s.ResetCustomData("platform", "missing")
+				return nil
+			},
+			want: []string{
+				"Input", "Output", "Actor", "Assistant", "RecentInteractions", "RelevantInteractions",
+				"Participants", "Tools", "FailedManagers", "transaction", "conflicts",
+				"manager:summary by insight", "manager:trace_id by tracer",
+				"custom:platform",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newResetState()
+			if err := tt.reset(s); err != nil {
+				t.Fatal(err)
+			}
+
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if got := survivors(s); strings.Join(got, ", ") != strings.Join(want, ", ") {
+				t.Errorf("survivors = %v, want %v", got, want)
+			}
+			if got := len(s.TakeCompensations()); got != 1 {
+				t.Errorf("compensations = %d, want the one added", got)
+			}
+		})
+	}
+}
+
+func TestResetValues(t *testing.T) {
+	s := newResetState().SetStrictKeys(true)
+	if err := s.Reset(KeepKeys("summary", "platform")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetManagerData("summary"); got != "overwritten" {
+		t.Errorf("summary = %v, want the last value written", got)
+	}
+	if got, _ := s.GetCustomData("platform"); got != "discord" {
+		t.Errorf("platform = %v, want discord", got)
+	}
+	if !s.StrictKeys() {
+		t.Error("Reset cleared strict keys")
+	}
+
+	// The kept key is still owned, and the state takes other data again
+	err := s.MergeManagerData([]StateData{
+		{Key: "summary", Value: "rewritten", Owner: "summary"},
+		{Key: "insight", Value: "likes tea", Owner: "insight"},
+	})
+	if !errors.Is(err, ErrKeyConflict) {
+		t.Errorf("writing the kept key of another owner returned %v, want a conflict", err)
+	}
+	if got := s.KeyConflicts(); len(got) != 1 {
+		t.Errorf("conflicts = %v, want only the new one", got)
+	}
+	if got, _ := s.GetManagerData("insight"); got != "likes tea" {
+		t.Errorf("insight = %v, want likes tea", got)
+	}
+}
+
+func TestResetOptionError(t *testing.T) {
+	s := newResetState()
+	failing := func(o *ResetOptions) error { return errors.New("invalid option") }
+	if err := s.Reset(KeepInput(), failing); err == nil {
+		t.Fatal("Reset succeeded with a failing option")
+	}
+	if got := survivors(s); len(got) != len(survivors(newResetState())) {
+		t.Errorf("survivors = %v after a failed reset, want the state untouched", got)
+	}
+}
@@ -0,0 +1,260 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/velumlabs/thor/db"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// snapshotVersion is the version of the snapshot format written by Snapshot
+const snapshotVersion = 1
+
+// StateSnapshot is the JSON form of a State written by Snapshot. Tools, the
+// transaction and compensations are not part of it.
+type StateSnapshot struct {
+	Version int `json:"version"`
+
+	Input     *db.Fragment `json:"input,omitempty"`
+	Output    *db.Fragment `json:"output,omitempty"`
+	Actor     *db.Actor    `json:"actor,omitempty"`
+	Assistant *db.Actor    `json:"assistant,omitempty"`
+
+	RecentInteractions   []db.Fragment     `json:"recent_interactions,omitempty"`
+	RelevantInteractions []db.Fragment     `json:"relevant_interactions,omitempty"`
+	Participants         []db.SessionActor `json:"participants,omitempty"`
+	FailedManagers       []string          `json:"failed_managers,omitempty"`
+
+	ManagerData map[StateDataKey]json.RawMessage `json:"manager_data,omitempty"`
+	KeyOwners   map[StateDataKey]string          `json:"key_owners,omitempty"`
+	CustomData  map[string]json.RawMessage       `json:"custom_data,omitempty"`
+}
+
+// SnapshotOptions configures Snapshot
+type SnapshotOptions struct {
+	// Keep the embeddings of fragments, which dominate the snapshot's size
+	IncludeEmbeddings bool
+}
+
+// DataCodec converts the manager data of a key to and from JSON
+type DataCodec interface {
+	Encode(value interface{}) (json.RawMessage, error)
+	Decode(data json.RawMessage) (interface{}, error)
+}
+
+var (
+	dataCodecsMu sync.RWMutex
+	dataCodecs   = make(map[StateDataKey]DataCodec)
+)
+
+// RegisterDataCodec sets the codec of a manager data key, replacing any
+// previous one. Manager data without a codec is encoded with encoding/json and
+// restored as generic JSON values, maps and slices of interface{}.
+func RegisterDataCodec(key StateDataKey, codec DataCodec) {
+	dataCodecsMu.Lock()
+	defer dataCodecsMu.Unlock()
+	dataCodecs[key] = codec
+}
+
+// RegisterDataType registers a codec restoring the manager data of a key as a
+// T, typically in the init function of the manager providing it:
+//
+//	func init() {
+//		state.RegisterDataType[[]db.Fragment](RecentInteractionsKey)
+//	}
+func RegisterDataType[T any](key StateDataKey) {
+	RegisterDataCodec(key, typedCodec[T]{})
+}
+
+// typedCodec encodes values with encoding/json and decodes them into a T
+type typedCodec[T any] struct{}
+
+func (typedCodec[T]) Encode(value interface{}) (json.RawMessage, error) {
+	return json.Marshal(value)
+}
+
+func (typedCodec[T]) Decode(data json.RawMessage) (interface{}, error) {
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// dataCodec returns the codec of a manager data key, if one is registered
+func dataCodec(key StateDataKey) (DataCodec, bool) {
+	dataCodecsMu.RLock()
+	defer dataCodecsMu.RUnlock()
+	codec, ok := dataCodecs[key]
+	return codec, ok
+}
+
+// Snapshot serializes the state to JSON, e.g. to debug a turn or to retry a
+// failed PostProcess later with RestoreState. Manager data is encoded with the
+// codec registered for its key.
+func (s *State) Snapshot(opts SnapshotOptions) ([]byte, error) {
+	s.mu.RLock()
+	snapshot := StateSnapshot{
+		Version:              snapshotVersion,
+		Input:                snapshotFragment(s.Input, opts),
+		Output:               snapshotFragment(s.Output, opts),
+		Actor:                s.Actor,
+		Assistant:            s.Assistant,
+		RecentInteractions:   snapshotFragments(s.RecentInteractions, opts),
+		RelevantInteractions: snapshotFragments(s.RelevantInteractions, opts),
+		Participants:         append([]db.SessionActor(nil), s.Participants...),
+		FailedManagers:       append([]string(nil), s.FailedManagers...),
+		ManagerData:          make(map[StateDataKey]json.RawMessage, len(s.managerData)),
+		KeyOwners:            make(map[StateDataKey]string, len(s.keyOwners)),
+		CustomData:           make(map[string]json.RawMessage, len(s.customData)),
+	}
+	managerData := make(map[StateDataKey]interface{}, len(s.managerData))
+	for key, value := range s.managerData {
+		managerData[key] = value
+	}
+	for key, owner := range s.keyOwners {
+		snapshot.KeyOwners[key] = owner
+	}
+	customData := make(map[string]interface{}, len(s.customData))
+	for key, value := range s.customData {
+		customData[key] = value
+	}
+	s.mu.RUnlock()
+
+	for key, value := range managerData {
+		if !opts.IncludeEmbeddings {
+			value = withoutEmbeddings(value)
+		}
+
+		var (
+			encoded json.RawMessage
+			err     error
+		)
+		if codec, ok := dataCodec(key); ok {
+			encoded, err = codec.Encode(value)
+		} else {
+			encoded, err = json.Marshal(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode manager data %s: %w", key, err)
+		}
+		snapshot.ManagerData[key] = encoded
+	}
+
+	for key, value := range customData {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode custom data %s: %w", key, err)
+		}
+		snapshot.CustomData[key] = encoded
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// RestoreState creates a state from a snapshot written by Snapshot
+func RestoreState(data []byte) (*State, error) {
+	s := NewState()
+	if err := s.Restore(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Restore replaces the state's conversation, interactions, manager data and
+// custom data with those of a snapshot written by Snapshot. Its tools,
+// transaction and compensations are kept.
+func (s *State) Restore(data []byte) error {
+	var snapshot StateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode state snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported state snapshot version %d", snapshot.Version)
+	}
+
+	managerData := make(map[StateDataKey]interface{}, len(snapshot.ManagerData))
+	for key, encoded := range snapshot.ManagerData {
+		var (
+			value interface{}
+			err   error
+		)
+		if codec, ok := dataCodec(key); ok {
+			value, err = codec.Decode(encoded)
+		} else {
+			err = json.Unmarshal(encoded, &value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode manager data %s: %w", key, err)
+		}
+		managerData[key] = value
+	}
+
+	customData := make(map[string]interface{}, len(snapshot.CustomData))
+	for key, encoded := range snapshot.CustomData {
+		var value interface{}
+		if err := json.Unmarshal(encoded, &value); err != nil {
+			return fmt.Errorf("failed to decode custom data %s: %w", key, err)
+		}
+		customData[key] = value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Input = snapshot.Input
+	s.Output = snapshot.Output
+	s.Actor = snapshot.Actor
+	s.Assistant = snapshot.Assistant
+	s.RecentInteractions = snapshot.RecentInteractions
+	s.RelevantInteractions = snapshot.RelevantInteractions
+	s.Participants = snapshot.Participants
+	s.FailedManagers = snapshot.FailedManagers
+	s.managerData = managerData
+	s.keyOwners = make(map[StateDataKey]string, len(snapshot.KeyOwners))
+	for key, owner := range snapshot.KeyOwners {
+		s.keyOwners[key] = owner
+	}
+	s.keyConflicts = nil
+	s.customData = customData
+	return nil
+}
+
+// snapshotFragment returns the fragment to write into a snapshot
+func snapshotFragment(fragment *db.Fragment, opts SnapshotOptions) *db.Fragment {
+	if fragment == nil || opts.IncludeEmbeddings {
+		return fragment
+	}
+	stripped := *fragment
+	stripped.Embedding = pgvector.Vector{}
+	return &stripped
+}
+
+// snapshotFragments returns the fragments to write into a snapshot
+func snapshotFragments(fragments []db.Fragment, opts SnapshotOptions) []db.Fragment {
+	if fragments == nil || opts.IncludeEmbeddings {
+		return fragments
+	}
+	stripped := make([]db.Fragment, len(fragments))
+	for i, fragment := range fragments {
+		fragment.Embedding = pgvector.Vector{}
+		stripped[i] = fragment
+	}
+	return stripped
+}
+
+// withoutEmbeddings strips the embeddings of manager data holding fragments
+func withoutEmbeddings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []db.Fragment:
+		return snapshotFragments(v, SnapshotOptions{})
+	case *db.Fragment:
+		return snapshotFragment(v, SnapshotOptions{})
+	case db.Fragment:
+		return *snapshotFragment(&v, SnapshotOptions{})
+	default:
+		return value
+	}
+}
@@ -0,0 +1,227 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+const (
+	snapshotFragmentsKey StateDataKey = "snapshottest/fragments"
+	snapshotMoodKey      StateDataKey = "snapshottest/mood"
+	snapshotPlainKey     StateDataKey = "snapshottest/plain"
+)
+
+// mood is manager data stored as its label alone by moodCodec
+type mood struct {
+	Label string
+}
+
+// moodCodec encodes a mood as a JSON string
+type moodCodec struct{}
+
+func (moodCodec) Encode(value interface{}) (json.RawMessage, error) {
+	return json.Marshal(value.(mood).Label)
+}
+
+func (moodCodec) Decode(data json.RawMessage) (interface{}, error) {
+	var label string
+	if err := json.Unmarshal(data, &label); err != nil {
+		return nil, err
+	}
+	if label == "" {
+		return nil, errors.New("empty mood")
+	}
+	return mood{Label: label}, nil
+}
+
+func init() {
+	RegisterDataType[[]db.Fragment](snapshotFragmentsKey)
+	RegisterDataCodec(snapshotMoodKey, moodCodec{})
+}
+
+// newSnapshotState returns a state with every part a snapshot covers
+func newSnapshotState() *State {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	user := &db.Actor{ID: id.New(), Name: "Alice", CreatedAt: createdAt}
+	assistant := &db.Actor{ID: id.New(), Name: "Thor", Assistant: true, CreatedAt: createdAt}
+	sessionID := id.New()
+	fragment := func(actor *db.Actor, content string) db.Fragment {
+		return db.Fragment{
+			ID:        id.New(),
+			ActorID:   actor.ID,
+			SessionID: sessionID,
+			Content:   content,
+			Metadata:  db.Metadata{"lang": "en"},
+			Embedding: pgvector.NewVector([]float32{0.1, 0.2, 0.3}),
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+	}
+	input := fragment(user, "What is Thor?")
+	output := fragment(assistant, "An agent framework.")
+
+	s := NewState()
+	s.Input, s.Output, s.Actor, s.Assistant = &input, &output, user, assistant
+	s.FailedManagers = []string{"insight"}
+	return s.
+		SetRecentInteractions([]db.Fragment{fragment(user, "Hi"), fragment(assistant, "Hello!")}).
+		SetRelevantInteractions([]db.Fragment{fragment(user, "Tell me about Thor")}).
+		AddManagerData([]StateData{
+			{Key: snapshotFragmentsKey, Value: []db.Fragment{fragment(user, "Thor is an agent")}, Owner: "memory"},
+			{Key: snapshotMoodKey, Value: mood{Label: "curious"}, Owner: "classifier"},
+			{Key: snapshotPlainKey, Value: map[string]interface{}{"count": 3, "tags": []string{"a", "b"}}},
+		}).
+		AddCustomData("platform", "discord").
+		AddCustomData("limits", map[string]interface{}{"max": 2})
+}
+
+// snapshotPrompt renders every part of a state a snapshot covers
+const snapshotPrompt = `{{.Actor.Name}} asked {{.Input.Content}} at {{.Input.CreatedAt}} ({{.Input.Metadata}}); {{.Assistant.Name}} answered {{.Output.Content}}.
+Recent: {{range .RecentInteractions}}{{.Content}} {{.CreatedAt}}|{{end}}
+Relevant: {{range .RelevantInteractions}}{{.Content}}|{{end}}
+Failed: {{.FailedManagers}}
+Memory: {{range index . "snapshottest/fragments"}}{{.Content}} {{.Metadata}}|{{end}}
+Mood: {{(index . "snapshottest/mood").Label}}
+Plain: {{index . "snapshottest/plain"}}
+Custom: {{.platform}} {{.limits}}`
+
+// composeSnapshotPrompt composes snapshotPrompt for a state
+func composeSnapshotPrompt(t *testing.T, s *State) string {
+	t.Helper()
+	return composeOne(t, NewPromptBuilder(s).
+		WithManagerDataBatch(snapshotFragmentsKey, snapshotMoodKey, snapshotPlainKey).
+		AddSystemSection(snapshotPrompt))
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SnapshotOptions
+	}{
+		{name: "without embeddings"},
+		{name: "with embeddings", opts: SnapshotOptions{IncludeEmbeddings: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := newSnapshotState()
+			data, err := original.Snapshot(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := RestoreState(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := composeSnapshotPrompt(t, original)
+			if got := composeSnapshotPrompt(t, restored); got != want {
+				t.Errorf("restored prompt =\n%s\nwant\n%s", got, want)
+			}
+
+			if got, _ := restored.GetManagerData(snapshotMoodKey); got != (mood{Label: "curious"}) {
+				t.Errorf("mood = %#v, want it decoded by its codec", got)
+			}
+			if owner, _ := restored.KeyOwner(snapshotFragmentsKey); owner != "memory" {
+				t.Errorf("owner of %s = %q, want memory", snapshotFragmentsKey, owner)
+			}
+
+			// A snapshot of the restored state is the same snapshot
+			again, err := restored.Snapshot(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(data) {
+				t.Errorf("snapshot of the restored state differs:\n%s\nwant\n%s", again, data)
+			}
+		})
+	}
+}
+
+func TestSnapshotEmbeddings(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SnapshotOptions
+		want int
+	}{
+		{name: "left out", want: 0},
+		{name: "included", opts: SnapshotOptions{IncludeEmbeddings: true}, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := newSnapshotState()
+			data, err := original.Snapshot(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := RestoreState(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			memory, _ := restored.GetManagerData(snapshotFragmentsKey)
+			embeddings := map[string]pgvector.Vector{
+				"input":                 restored.Input.Embedding,
+				"output":                restored.Output.Embedding,
+				"recent interaction":    restored.GetRecentInteractions()[0].Embedding,
+				"relevant interaction":  restored.GetRelevantInteractions()[0].Embedding,
+				"manager data fragment": memory.([]db.Fragment)[0].Embedding,
+			}
+			for name, embedding := range embeddings {
+				if got := len(embedding.Slice()); got != tt.want {
+					t.Errorf("%s embedding has %d dimensions, want %d", name, got, tt.want)
+				}
+			}
+
+			// Leaving embeddings out of the snapshot keeps those of the state
+			if got := len(original.Input.Embedding.Slice()); got != 3 {
+				t.Errorf("original input embedding has %d dimensions after the snapshot, want 3", got)
+			}
+			if got := len(original.GetRecentInteractions()[0].Embedding.Slice()); got != 3 {
+				t.Errorf("original recent interaction embedding has %d dimensions after the snapshot, want 3", got)
+			}
+		})
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "not JSON", data: "{", wantErr: "failed to decode state snapshot"},
+		{name: "unsupported version", data: `{"version":2}`, wantErr: "unsupported state snapshot version 2"},
+		{
+			name:    "codec failure",
+			data:    `{"version":1,"manager_data":{"snapshottest/mood":""}}`,
+			wantErr: "failed to decode manager data snapshottest/mood",
+		},
+		{
+			name:    "typed data mismatch",
+			data:    `{"version":1,"manager_data":{"snapshottest/fragments":"text"}}`,
+			wantErr: "failed to decode manager data snapshottest/fragments",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewState().AddCustomData("kept", true)
+			if err := s.Restore([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Restore = %v, want %q", err, tt.wantErr)
+			}
+			if _, ok := s.GetCustomData("kept"); !ok {
+				t.Error("failed restore changed the state")
+			}
+		})
+	}
+}
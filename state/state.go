@@ -1,37 +1,71 @@
 package state
 
+import (
+	"reflect"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/stores"
+
+	toolkit "github.com/velumlabs/kit/go"
+	"gorm.io/gorm"
+)
+
 // Package state provides core functionality for managing conversation state and context
 // in the agent system. It handles both structured manager data and custom runtime data,
 // while providing methods for state manipulation and template-based prompt generation.
 
 // AddManagerData adds a slice of StateData entries to the state's manager data store.
-// If the manager data map hasn't been initialized, it creates a new one.
+// Entries are applied as by MergeManagerData, whose key conflicts are only
+// recorded in KeyConflicts.
 func (s *State) AddManagerData(data []StateData) *State {
-	if s.managerData == nil {
-		s.managerData = make(map[StateDataKey]interface{})
-	}
-
-	for _, d := range data {
-		s.managerData[d.Key] = d.Value
-	}
-
+	_ = s.MergeManagerData(data)
 	return s
 }
 
 // GetManagerData retrieves manager-specific data by its key.
 // Returns the value and a boolean indicating if the key exists.
+// A key without namespace also finds the single namespaced key of that name,
+// so "insights" finds "insight/insights".
 func (s *State) GetManagerData(key StateDataKey) (interface{}, bool) {
-	value, exists := s.managerData[key]
-	return value, exists
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if value, exists := s.managerData[key]; exists {
+		return value, true
+	}
+	if resolved, ok := s.resolveKey(key); ok {
+		return s.managerData[resolved], true
+	}
+	return nil, false
+}
+
+// ManagerDataSnapshot returns a copy of the manager data, safe to iterate while
+// managers keep adding to the state
+func (s *State) ManagerDataSnapshot() map[StateDataKey]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := make(map[StateDataKey]interface{}, len(s.managerData))
+	for k, v := range s.managerData {
+		snapshot[k] = v
+	}
+	return snapshot
 }
 
 // AddCustomData adds a custom key-value pair to the state's custom data store.
 // This is useful for platform-specific or temporary data that doesn't fit into manager data.
 func (s *State) AddCustomData(key string, value interface{}) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.customData == nil {
 		s.customData = make(map[string]interface{})
 	}
 	s.customData[key] = value
+	s.record(func(parent *State) error {
+		parent.AddCustomData(key, value)
+		return nil
+	})
 
 	return s
 }
@@ -39,6 +73,9 @@ func (s *State) AddCustomData(key string, value interface{}) *State {
 // GetCustomData retrieves a custom data value by its key.
 // Returns the value and a boolean indicating if the key exists.
 func (s *State) GetCustomData(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.customData == nil {
 		return nil, false
 	}
@@ -46,9 +83,195 @@ func (s *State) GetCustomData(key string) (interface{}, bool) {
 	return value, exists
 }
 
-// Reset clears all manager and custom data from the state.
-// This is typically called before updating the state with fresh data.
-func (s *State) Reset() {
-	s.managerData = make(map[StateDataKey]interface{})
-	s.customData = make(map[string]interface{})
+// CustomDataSnapshot returns a copy of the custom data, safe to iterate while
+// managers keep adding to the state
+func (s *State) CustomDataSnapshot() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(s.customData))
+	for k, v := range s.customData {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// SetRecentInteractions replaces the recent interactions of the state
+func (s *State) SetRecentInteractions(fragments []db.Fragment) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RecentInteractions = fragments
+	s.record(func(parent *State) error {
+		parent.SetRecentInteractions(fragments)
+		return nil
+	})
+
+	return s
+}
+
+// GetRecentInteractions returns a copy of the recent interactions of the state
+func (s *State) GetRecentInteractions() []db.Fragment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]db.Fragment(nil), s.RecentInteractions...)
+}
+
+// SetRelevantInteractions replaces the relevant interactions of the state
+func (s *State) SetRelevantInteractions(fragments []db.Fragment) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.RelevantInteractions = fragments
+	s.record(func(parent *State) error {
+		parent.SetRelevantInteractions(fragments)
+		return nil
+	})
+
+	return s
+}
+
+// GetRelevantInteractions returns a copy of the relevant interactions of the state
+func (s *State) GetRelevantInteractions() []db.Fragment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]db.Fragment(nil), s.RelevantInteractions...)
+}
+
+// SetParticipants replaces the session participants of the state
+func (s *State) SetParticipants(participants []db.SessionActor) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Participants = participants
+	s.record(func(parent *State) error {
+		parent.SetParticipants(participants)
+		return nil
+	})
+
+	return s
+}
+
+// GetParticipants returns a copy of the session participants of the state
+func (s *State) GetParticipants() []db.SessionActor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]db.SessionActor(nil), s.Participants...)
+}
+
+// AddTools makes tools available to the response generation
+func (s *State) AddTools(tools ...toolkit.Tool) *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Tools = append(s.Tools, tools...)
+	s.record(func(parent *State) error {
+		parent.AddTools(tools...)
+		return nil
+	})
+
+	return s
+}
+
+// GetTools returns a copy of the tools available to the response generation
+func (s *State) GetTools() []toolkit.Tool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]toolkit.Tool(nil), s.Tools...)
+}
+
+// stateFields are the exported fields of State, resolved once for the
+// reflection pass of PromptBuilder
+var stateFields = exportedFields(reflect.TypeOf(State{}))
+
+// exportedFields returns the exported fields of a struct type
+func exportedFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.IsExported() {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// templateData returns the exported fields of the state by name, with the
+// guarded slices copied, for the reflection pass of PromptBuilder. The
+// interaction slices are left out unless referenced, or all fields if it is nil.
+func (s *State) templateData(referenced map[string]bool) map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := make(map[string]interface{}, len(stateFields))
+	stateValue := reflect.ValueOf(s).Elem()
+	for _, field := range stateFields {
+		switch field.Name {
+		case "RecentInteractions", "RelevantInteractions", "Tools":
+			// Copied below
+		default:
+			data[field.Name] = stateValue.FieldByIndex(field.Index).Interface()
+		}
+	}
+
+	if referenced == nil || referenced["RecentInteractions"] {
+		data["RecentInteractions"] = append([]db.Fragment(nil), s.RecentInteractions...)
+	}
+	if referenced == nil || referenced["RelevantInteractions"] {
+		data["RelevantInteractions"] = append([]db.Fragment(nil), s.RelevantInteractions...)
+	}
+	data["Tools"] = append([]toolkit.Tool(nil), s.Tools...)
+	return data
+}
+
+// Transaction returns the database transaction of the current pipeline phase,
+// or nil if the engine is not running transactionally. Managers should bind their
+// stores to it (e.g. store.WithTx(state.Transaction())) so their writes commit or
+// roll back together with the pipeline.
+func (s *State) Transaction() *gorm.DB {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return s.tx
+}
+
+// Stores returns stores bound to the transaction of the current pipeline phase,
+// or nil if the engine is not running transactionally, for managers writing
+// through several stores:
+//
+//	if tx := currentState.Stores(); tx != nil {
+//		insights = tx.Fragments(db.FragmentTableInsight)
+//	}
+func (s *State) Stores() *stores.Stores {
+	tx := s.Transaction()
+	if tx == nil {
+		return nil
+	}
+	return stores.ForTx(tx)
+}
+
+// SetTransaction sets the database transaction of the current pipeline phase.
+// It is called by the engine; passing nil clears it.
+func (s *State) SetTransaction(tx *gorm.DB) {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	s.tx = tx
+}
+
+// AddCompensation registers a callback that undoes a side effect made outside the
+// database, such as a call to an external API. Compensations run in reverse order
+// if the pipeline transaction is rolled back.
+func (s *State) AddCompensation(fn func() error) *State {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	s.compensations = append(s.compensations, fn)
+	s.record(func(parent *State) error {
+		parent.AddCompensation(fn)
+		return nil
+	})
+
+	return s
+}
+
+// TakeCompensations returns the registered compensations and clears them.
+func (s *State) TakeCompensations() []func() error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	compensations := s.compensations
+	s.compensations = nil
+	return compensations
 }
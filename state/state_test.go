@@ -0,0 +1,160 @@
+package state
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+
+	toolkit "github.com/velumlabs/kit/go"
+)
+
+func TestStateConcurrentAccess(t *testing.T) {
+	const (
+		writers = 10
+		writes  = 100
+	)
+	s := NewState()
+	var tool toolkit.Tool
+
+	var writersWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWG.Add(1)
+		go func(w int) {
+			defer writersWG.Done()
+			key := fmt.Sprintf("writer_%d", w)
+			for i := 0; i < writes; i++ {
+				s.AddManagerData([]StateData{{Key: StateDataKey(key), Value: i}}).
+					AddCustomData(key, i).
+					AddTools(tool).
+					SetRecentInteractions(make([]db.Fragment, i)).
+					SetRelevantInteractions(make([]db.Fragment, i))
+			}
+		}(w)
+	}
+
+	// One composer renders the state while the writers run
+	stop := make(chan struct{})
+	var composerWG sync.WaitGroup
+	composerWG.Add(1)
+	go func() {
+		defer composerWG.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			builder := NewPromptBuilder(s).
+				AddSystemSection("{{len .RecentInteractions}} recent, {{len .RelevantInteractions}} relevant, {{len .Tools}} tools, {{.writer_0}}")
+			if _, err := builder.Compose(); err != nil {
+				t.Error(err)
+				return
+			}
+			for range s.ManagerDataSnapshot() {
+			}
+			for range s.CustomDataSnapshot() {
+			}
+			_ = builder.GetTools()
+		}
+	}()
+
+	writersWG.Wait()
+	close(stop)
+	composerWG.Wait()
+
+	if got := len(s.ManagerDataSnapshot()); got != writers {
+		t.Errorf("manager data keys = %d, want %d", got, writers)
+	}
+	if got := len(s.CustomDataSnapshot()); got != writers {
+		t.Errorf("custom data keys = %d, want %d", got, writers)
+	}
+	if got := len(s.GetTools()); got != writers*writes {
+		t.Errorf("tools = %d, want %d", got, writers*writes)
+	}
+}
+
+func TestStateSnapshots(t *testing.T) {
+	tests := []struct {
+		name string
+		// modify changes what an accessor returned
+		modify func(s *State)
+		// check reports whether the state is unchanged
+		check func(s *State) bool
+	}{
+		{
+			name:   "manager data",
+			modify: func(s *State) { s.ManagerDataSnapshot()["key"] = "changed" },
+			check: func(s *State) bool {
+				value, _ := s.GetManagerData("key")
+				return value == "value"
+			},
+		},
+		{
+			name:   "custom data",
+			modify: func(s *State) { s.CustomDataSnapshot()["key"] = "changed" },
+			check: func(s *State) bool {
+				value, _ := s.GetCustomData("key")
+				return value == "value"
+			},
+		},
+		{
+			name:   "recent interactions",
+			modify: func(s *State) { s.GetRecentInteractions()[0].Content = "changed" },
+			check:  func(s *State) bool { return s.GetRecentInteractions()[0].Content == "value" },
+		},
+		{
+			name:   "relevant interactions",
+			modify: func(s *State) { s.GetRelevantInteractions()[0].Content = "changed" },
+			check:  func(s *State) bool { return s.GetRelevantInteractions()[0].Content == "value" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tool toolkit.Tool
+			s := NewState().
+				AddManagerData([]StateData{{Key: "key", Value: "value"}}).
+				AddCustomData("key", "value").
+				SetRecentInteractions([]db.Fragment{{Content: "value"}}).
+				SetRelevantInteractions([]db.Fragment{{Content: "value"}}).
+				AddTools(tool)
+
+			tt.modify(s)
+			if !tt.check(s) {
+				t.Error("changing the returned copy changed the state")
+			}
+		})
+	}
+}
+
+func BenchmarkAddManagerData(b *testing.B) {
+	s := NewState()
+	data := []StateData{{Key: "insight/insights", Value: "value"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.AddManagerData(data)
+		if _, ok := s.GetManagerData("insights"); !ok {
+			b.Fatal("manager data not found")
+		}
+	}
+}
+
+func BenchmarkCompose(b *testing.B) {
+	s := NewState().
+		AddManagerData([]StateData{{Key: "summary", Value: "Alice greeted the assistant."}}).
+		AddCustomData("platform", "discord").
+		SetRecentInteractions(make([]db.Fragment, 20))
+	builder := NewPromptBuilder(s).
+		WithManagerData("summary").
+		AddSystemSection("Summary: {{.summary}} on {{.platform}}").
+		AddSystemSection("{{len .RecentInteractions}} recent messages")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := builder.Compose(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"text/template/parse"
+
+	"github.com/velumlabs/thor/llm"
+)
+
+// missingKeyPattern extracts the key from the error of a template executed
+// with missingkey=error
+var missingKeyPattern = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// wholeDataKey marks templates that may read any key of the data
+const wholeDataKey = "."
+
+// MissingKeyError is returned by strict builders when a section references a
+// key the template data doesn't have, e.g. a misspelled state field or manager
+// data that wasn't added with WithManagerData
+type MissingKeyError struct {
+	Section int // Position of the section in the builder
+	Role    llm.Role
+	Key     string
+	Err     error // Error of the template execution
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("prompt section %d (role=%s) references missing key %q: %v", e.Section, e.Role, e.Key, e.Err)
+}
+
+func (e *MissingKeyError) Unwrap() error {
+	return e.Err
+}
+
+// WithStrictTemplates makes Compose fail with a MissingKeyError when a section
+// references a key missing from the template data, instead of rendering it
+// empty. Optional manager data must then be added with WithManagerDataOptional,
+// which sets missing keys to nil.
+func (tb *PromptBuilder) WithStrictTemplates() *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	tb.strict = true
+	return tb
+}
+
+// Validate parses every section and executes it strictly against the current
+// data, without producing messages, so prompts can be checked in tests.
+// Returns the errors of all failing sections joined.
+func (tb *PromptBuilder) Validate() error {
+	if tb.err != nil {
+		return tb.err
+	}
+
+	data := tb.templateData()
+	var failures []error
+	for i, section := range tb.sections {
+		if _, err := tb.renderSection(i, section, data, true); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// UnusedManagerData returns the manager data keys added with WithManagerData
+// that no section references, by key or bare name, sorted. References are
+// found by walking the parsed templates, so keys only reached dynamically count
+// as unused.
+func (tb *PromptBuilder) UnusedManagerData() ([]StateDataKey, error) {
+	if tb.err != nil {
+		return nil, tb.err
+	}
+
+	referenced := make(map[string]bool)
+	for _, section := range tb.sections {
+		if err := tb.sectionReferences(section, referenced); err != nil {
+			return nil, err
+		}
+	}
+
+	var unused []StateDataKey
+	for key := range tb.stateData {
+		_, name := key.Namespace()
+		if !referenced[string(key)] && !referenced[name] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool {
+		return unused[i] < unused[j]
+	})
+	return unused, nil
+}
+
+// sectionReferences records the top-level keys the template of a section may
+// read. Literal sections read none.
+func (tb *PromptBuilder) sectionReferences(section PromptSection, referenced map[string]bool) error {
+	if section.Literal {
+		return nil
+	}
+	compiled, err := tb.compiledSection(section)
+	if err != nil {
+		return err
+	}
+	for key := range compiled.refs {
+		referenced[key] = true
+	}
+	return nil
+}
+
+// collectReferences records the top-level keys a template node may read:
+// fields of the dot or of $, and keys passed to index. A dot or $ used whole,
+// which may read any key, is recorded as wholeDataKey.
+func collectReferences(node parse.Node, referenced map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectReferences(child, referenced)
+		}
+	case *parse.ActionNode:
+		collectReferences(n.Pipe, referenced)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectReferences(cmd, referenced)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 3 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "index" {
+				if key, ok := n.Args[2].(*parse.StringNode); ok {
+					referenced[key.Text] = true
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			collectReferences(arg, referenced)
+		}
+	case *parse.FieldNode:
+		referenced[n.Ident[0]] = true
+	case *parse.DotNode:
+		referenced[wholeDataKey] = true
+	case *parse.VariableNode:
+		switch {
+		case len(n.Ident) > 1 && n.Ident[0] == "$":
+			referenced[n.Ident[1]] = true
+		case len(n.Ident) == 1 && n.Ident[0] == "$":
+			referenced[wholeDataKey] = true
+		}
+	case *parse.ChainNode:
+		collectReferences(n.Node, referenced)
+	case *parse.IfNode:
+		collectBranchReferences(&n.BranchNode, referenced)
+	case *parse.RangeNode:
+		collectBranchReferences(&n.BranchNode, referenced)
+	case *parse.WithNode:
+		collectBranchReferences(&n.BranchNode, referenced)
+	case *parse.TemplateNode:
+		collectReferences(n.Pipe, referenced)
+	}
+}
+
+// collectBranchReferences records the keys read by an if, range or with node
+func collectBranchReferences(n *parse.BranchNode, referenced map[string]bool) {
+	collectReferences(n.Pipe, referenced)
+	collectReferences(n.List, referenced)
+	collectReferences(n.ElseList, referenced)
+}
+
+// missingKey returns the key a strict template execution failed on
+func missingKey(err error) (string, bool) {
+	match := missingKeyPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
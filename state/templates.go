@@ -0,0 +1,96 @@
+package state
+
+import (
+	"html/template"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCachedTemplates bounds the package-level template cache, so prompts built
+// from ever-changing template text can't grow it without limit. Templates past
+// the bound are parsed on every use.
+const maxCachedTemplates = 1024
+
+var (
+	// templateCache holds parsed section templates by helper names and text
+	templateCache       sync.Map // string -> *compiledTemplate
+	cachedTemplateCount int64
+)
+
+// compiledTemplate is a parsed section template. Executed html templates can't
+// be cloned, so the parse result is kept unexecuted for binding other helpers,
+// next to a copy that is executed directly.
+type compiledTemplate struct {
+	text  string
+	base  *template.Template
+	ready *template.Template
+	refs  map[string]bool // Top-level data keys the template may read
+}
+
+// compileTemplate parses a section template with the given helpers
+func compileTemplate(name, text string, helpers template.FuncMap) (*compiledTemplate, error) {
+	base, err := template.New(name).Funcs(helpers).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	ready, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]bool)
+	for _, tmpl := range base.Templates() {
+		if tmpl.Tree != nil {
+			collectReferences(tmpl.Tree.Root, refs)
+		}
+	}
+	return &compiledTemplate{text: text, base: base, ready: ready, refs: refs}, nil
+}
+
+// cachedTemplate returns the parsed template of a section, parsing it on the
+// first use of its text with helpers of the same names
+func cachedTemplate(text string, helpers template.FuncMap) (*compiledTemplate, error) {
+	names := make([]string, 0, len(helpers))
+	for name := range helpers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := strings.Join(names, ",") + "\x00" + text
+
+	if cached, ok := templateCache.Load(key); ok {
+		return cached.(*compiledTemplate), nil
+	}
+
+	compiled, err := compileTemplate("section", text, helpers)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.AddInt64(&cachedTemplateCount, 1) <= maxCachedTemplates {
+		if cached, loaded := templateCache.LoadOrStore(key, compiled); loaded {
+			atomic.AddInt64(&cachedTemplateCount, -1)
+			return cached.(*compiledTemplate), nil
+		}
+	} else {
+		atomic.AddInt64(&cachedTemplateCount, -1)
+	}
+	return compiled, nil
+}
+
+// bind returns the template to execute with the given helpers, failing on
+// missing keys if strict. The template is shared when there are no helpers and
+// it isn't strict; otherwise a copy is bound to them, since helpers of the same
+// name may be different closures.
+func (c *compiledTemplate) bind(helpers template.FuncMap, strict bool) (*template.Template, error) {
+	if len(helpers) == 0 && !strict {
+		return c.ready, nil
+	}
+	clone, err := c.base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if strict {
+		clone.Option("missingkey=error")
+	}
+	return clone.Funcs(helpers), nil
+}
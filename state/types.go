@@ -2,11 +2,13 @@ package state
 
 import (
 	"html/template"
+	"sync"
 
 	"github.com/velumlabs/thor/db"
 	"github.com/velumlabs/thor/llm"
 
 	toolkit "github.com/velumlabs/kit/go"
+	"gorm.io/gorm"
 )
 
 // Package state provides core state management functionality for the agent system
@@ -18,6 +20,7 @@ type StateDataKey string
 type StateData struct {
 	Key   StateDataKey
 	Value interface{}
+	Owner string // ID of the manager providing the data, if known
 }
 
 // State represents the current context and state of a conversation
@@ -28,25 +31,56 @@ type State struct {
 	Output *db.Fragment // The LLM response
 
 	// Actor information
-	Actor *db.Actor // Information about where it came from
+	Actor     *db.Actor // Information about where it came from
+	Assistant *db.Actor // The assistant answering in this conversation turn
 
 	// Recent data
+	// Managers run concurrently during Process, so while they run these are
+	// set and read through SetRecentInteractions, GetRecentInteractions and
+	// their siblings rather than directly
 	RecentInteractions   []db.Fragment
 	RelevantInteractions []db.Fragment
 	Tools                []toolkit.Tool
+	// Actors taking part in the session, most recently seen first
+	Participants []db.SessionActor
+
+	// IDs of managers that failed during the current pipeline phase, so prompts
+	// can degrade gracefully when their data is missing
+	FailedManagers []string
+
+	// Guards the recent data above and the manager and custom data below
+	mu sync.RWMutex
+
 	// Manager-specific data storage
 	// Stores data provided by various managers keyed by StateDataKey
 	managerData map[StateDataKey]interface{}
 
+	// Owners of the manager data keys, conflicting writes by other owners, and
+	// whether those are rejected rather than applied
+	keyOwners    map[StateDataKey]string
+	keyConflicts []KeyConflictError
+	strictKeys   bool
+
 	// Custom data storage for arbitrary key-value pairs
 	// Used for platform-specific or temporary data storage
 	customData map[string]interface{}
+
+	// Database transaction of the current pipeline phase, if the engine runs
+	// transactionally, and compensations to run if it is rolled back
+	txMu          sync.Mutex
+	tx            *gorm.DB
+	compensations []func() error
+
+	// Writes of a state forked with Fork, replayed by Join
+	journalMu sync.Mutex
+	journal   *fork
 }
 
 // NewState creates and initializes a new State instance with empty data stores
 func NewState() *State {
 	return &State{
 		managerData: make(map[StateDataKey]interface{}),
+		keyOwners:   make(map[StateDataKey]string),
 		customData:  make(map[string]interface{}),
 	}
 }
@@ -56,6 +90,121 @@ type PromptSection struct {
 	Role     llm.Role // The role of this section (system, user, assistant, etc)
 	Template string   // The template text for this section
 	Name     string   // Optional name for the role (e.g., specific user identifiers)
+	Literal  bool     // Use Template verbatim instead of executing it, e.g. for stored messages
+	Ref      string   // Name of the registry template rendered instead of Template, if set
+
+	// Trimming under ComposeWithBudget. Trimmable sections with the lowest
+	// priority are trimmed first; MaxTokens caps the rendered section, 0 for no cap.
+	Priority  int
+	Trimmable bool
+	MaxTokens int
+
+	// Parsed registry template of Ref
+	compiled *compiledTemplate
+	// Sections sharing a non-zero group are dropped together under a budget
+	// rather than truncated, e.g. the input and output of an example
+	group int
+}
+
+// Example is a few-shot example: an input and the output the assistant should
+// give for it
+type Example struct {
+	Input  string
+	Output string
+	Name   string // Optional name of the example's user
+
+	// Embedding of Input used to select relevant examples, computed on
+	// selection if empty; see EmbedExamples
+	Embedding []float32
+}
+
+// ExampleRenderOptions configures how AddExampleSection renders examples
+type ExampleRenderOptions struct {
+	// Render only the K examples most similar to the input, embedded with
+	// Embedder; 0 renders all examples
+	K        int
+	Embedder Embedder
+
+	// Budget settings of the emitted sections, see AddSectionWithOptions.
+	// Trimmable examples are dropped whole, earliest first.
+	Priority  int
+	Trimmable bool
+}
+
+// Embedder embeds text to select relevant examples. *llm.LLMClient implements it.
+type Embedder interface {
+	EmbedText(text string) ([]float32, error)
+}
+
+// HistoryRenderOptions configures how AddConversationHistory renders fragments
+type HistoryRenderOptions struct {
+	// Render only the latest MaxMessages fragments, 0 renders all
+	MaxMessages int
+	// Merge consecutive fragments of the same role into one message
+	CollapseConsecutive bool
+	// Render a single user section with one "Name: content" line per fragment,
+	// for providers that don't take prior turns as separate messages
+	Transcript bool
+
+	// Budget settings of the emitted sections, see AddSectionWithOptions.
+	// Trimmable history loses its oldest messages first.
+	Priority  int
+	Trimmable bool
+}
+
+// TrimReason tells why ComposeWithBudget trimmed a section
+type TrimReason string
+
+const (
+	TrimReasonMaxTokens TrimReason = "max_tokens" // The section exceeded its own MaxTokens
+	TrimReasonBudget    TrimReason = "budget"     // The prompt exceeded the budget
+)
+
+// TrimmedSection describes a section ComposeWithBudget truncated or dropped
+type TrimmedSection struct {
+	Index          int // Position of the section in the builder
+	Role           llm.Role
+	Name           string
+	OriginalTokens int // Tokens of the rendered section
+	Tokens         int // Tokens left after trimming, 0 if dropped
+	Dropped        bool
+	Reason         TrimReason
+}
+
+// TrimReport describes how ComposeWithBudget fitted a prompt into its budget
+type TrimReport struct {
+	BudgetTokens   int
+	OriginalTokens int // Tokens of the prompt before trimming
+	Tokens         int // Tokens of the composed prompt
+	Trimmed        []TrimmedSection
+}
+
+// SectionReport describes how a prompt section rendered
+type SectionReport struct {
+	Index    int // Position of the section in the builder
+	Role     llm.Role
+	Name     string
+	Template string   // Raw template, or the literal content
+	Ref      string   // Registry template the section renders, if any
+	Literal  bool     // Added verbatim rather than executed
+	Keys     []string // Template data keys the section references, sorted
+	Rendered string
+	Tokens   int // Tokens of the rendered text, per the builder's counter
+	Redacted bool
+}
+
+// RenderReport describes the messages a PromptBuilder composes, section by
+// section, to inspect what a model was sent
+type RenderReport struct {
+	Sections []SectionReport
+	Tokens   int
+}
+
+// PromptInfo identifies the prompt a builder composes, to record which variant
+// produced a response
+type PromptInfo struct {
+	Version string // Set with WithPromptVersion or the registry's version
+	Hash    string // Hash of the section templates
 }
 
 // PromptBuilder facilitates the construction of structured prompts
@@ -64,6 +213,13 @@ type PromptBuilder struct {
 	state     *State                       // Reference to the current state
 	sections  []PromptSection              // Ordered list of prompt sections
 	stateData map[StateDataKey]interface{} // Manager-provided data for template rendering
+	optional  map[StateDataKey]interface{} // Defaults of optional manager data
 	helpers   template.FuncMap             // Function map for custom template functions
+	counter   llm.TokenCounter             // Measures sections for ComposeWithBudget
+	registry  *PromptRegistry              // Templates referenced by AddSectionRef
+	strict    bool                         // Fail on keys missing from the data
+	examples  []Example                    // Few-shot examples rendered by AddExampleSection
+	groups    int                          // Last section group assigned
+	version   string                       // Version of the prompt, for PromptInfo
 	err       error                        // Tracks any errors during building
 }
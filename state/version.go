@@ -0,0 +1,48 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// promptHashLength is the number of hex characters of a prompt hash
+const promptHashLength = 16
+
+// WithPromptVersion sets the version reported by PromptInfo, overriding the
+// registry's, e.g. to label the variant of an A/B test
+func (tb *PromptBuilder) WithPromptVersion(version string) *PromptBuilder {
+	if tb.err != nil {
+		return tb
+	}
+	tb.version = version
+	return tb
+}
+
+// PromptInfo returns the version of the prompt and a hash of its section
+// templates: their roles, names and text, in order. Literal sections such as
+// the conversation history and examples change with every turn, so they are
+// left out. The hash only depends on the templates, so it is stable across
+// runs.
+func (tb *PromptBuilder) PromptInfo() PromptInfo {
+	info := PromptInfo{Version: tb.version}
+	if info.Version == "" && tb.registry != nil {
+		info.Version = tb.registry.Version()
+	}
+
+	hash := sha256.New()
+	for _, section := range tb.sections {
+		if section.Literal {
+			continue
+		}
+		text := section.Template
+		if section.compiled != nil {
+			text = section.compiled.text
+		}
+		for _, part := range []string{string(section.Role), section.Name, text} {
+			hash.Write([]byte(part))
+			hash.Write([]byte{0})
+		}
+	}
+	info.Hash = hex.EncodeToString(hash.Sum(nil))[:promptHashLength]
+	return info
+}
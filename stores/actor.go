@@ -0,0 +1,430 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ActorStore provides persistence for actors
+type ActorStore struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// dryRun turns all writes into no-ops
+	dryRun bool
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the actors of a tenant, see WithTenant
+	tenant id.ID
+	// cache caches the records looked up by ID, see WithCache
+	cache *storeCache
+}
+
+// NewActorStore creates a new ActorStore backed by the given database, scoped
+// to its tenant if it is marked with one
+func NewActorStore(ctx context.Context, db *gorm.DB) *ActorStore {
+	return &ActorStore{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *ActorStore) WithTx(tx *gorm.DB) *ActorStore {
+	if tx == nil {
+		return s
+	}
+	return &ActorStore{
+		db:     tx,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache.inTx(),
+	}
+}
+
+// DryRun returns a copy of the store whose writes are no-ops, while reads still
+// hit the database
+func (s *ActorStore) DryRun() *ActorStore {
+	return &ActorStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: true,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *ActorStore) WithContext(ctx context.Context) *ActorStore {
+	return &ActorStore{
+		db:     s.db,
+		ctx:    ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the actors of a tenant, see
+// the WithTenant function
+func (s *ActorStore) WithTenant(tenantID id.ID) *ActorStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &ActorStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+		cache:  s.cache,
+	}
+}
+
+// WithCache returns a copy of the store that caches the actors GetByID looks
+// up in c, dropping them when they are written through the store or its
+// copies. Missing actors are cached for notFoundTTL, DefaultNotFoundTTL if
+// zero, or not at all if negative.
+//
+// Copies bound to a transaction with WithTx don't use the cache for lookups.
+// Writes made to the database bypassing the store's copies aren't seen until
+// the cache's TTL expires.
+func (s *ActorStore) WithCache(c *cache.Cache, notFoundTTL time.Duration) *ActorStore {
+	return &ActorStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  newStoreCache(c, "actors", notFoundTTL),
+	}
+}
+
+// CacheStats returns the lookups of the store's cache, shared with its copies,
+// see WithCache
+func (s *ActorStore) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// Create inserts a new actor
+func (s *ActorStore) Create(actor *db.Actor) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(actor.ID)
+	if err := stampTenant("actor", actor.ID, &actor.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to create actor: %w", err)
+	}
+	if s.mem != nil {
+		if err := s.mem.createActor(actor, false); err != nil {
+			return fmt.Errorf("failed to create actor: %w", err)
+		}
+		return nil
+	}
+	if err := s.db.WithContext(s.ctx).Create(actor).Error; err != nil {
+		return fmt.Errorf("failed to create actor: %w", err)
+	}
+	return nil
+}
+
+// Upsert inserts an actor or updates it if the ID already exists. The metadata
+// of an existing actor is merged with the actor's rather than replaced, so
+// upserts that don't know it keep it.
+func (s *ActorStore) Upsert(actor *db.Actor) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(actor.ID)
+	if err := stampTenant("actor", actor.ID, &actor.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to upsert actor: %w", err)
+	}
+	if s.mem != nil {
+		return s.mem.createActor(actor, true)
+	}
+	updates := clause.AssignmentColumns([]string{"name", "assistant", "updated_at", "deleted_at"})
+	updates = append(updates, clause.Assignment{
+		Column: clause.Column{Name: "metadata"},
+		Value:  gorm.Expr("actors.metadata || excluded.metadata"),
+	})
+	result := s.db.WithContext(s.ctx).Clauses(conflictScope(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: updates,
+	}, "actors", s.tenant)).Create(actor)
+	if result.Error != nil {
+		return fmt.Errorf("failed to upsert actor: %w", result.Error)
+	}
+	if s.tenant != "" && result.RowsAffected == 0 {
+		return fmt.Errorf("failed to upsert actor: actor %s: %w", actor.ID, ErrOtherTenant)
+	}
+	return nil
+}
+
+// GetByID retrieves an actor by its ID, or an error matching ErrNotFound if it
+// doesn't exist
+func (s *ActorStore) GetByID(actorID id.ID) (*db.Actor, error) {
+	return cachedLookup(s.cache, actorID, s.tenant, func(actor db.Actor) *id.ID {
+		return actor.TenantID
+	}, func() (*db.Actor, error) {
+		if s.mem != nil {
+			return s.mem.getActor(actorID)
+		}
+		var actor db.Actor
+		if err := s.query().Where("id = ?", actorID).First(&actor).Error; err != nil {
+			return nil, lookupError(err, "actor", actorID)
+		}
+		return &actor, nil
+	}, cloneActor)
+}
+
+// UpdateMetadata merges patch into the metadata of an actor in one statement,
+// overwriting existing values of the same keys, so concurrent patches of
+// different keys are all kept. Patching a missing actor returns an error
+// matching ErrNotFound.
+func (s *ActorStore) UpdateMetadata(actorID id.ID, patch db.Metadata) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(actorID)
+	if s.mem != nil {
+		return s.mem.patchActor(actorID, patch)
+	}
+	result := s.query().
+		Model(&db.Actor{}).
+		Where("id = ?", actorID).
+		Update("metadata", gorm.Expr("metadata || ?", patch))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update actor metadata: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("actor %s: %w", actorID, ErrNotFound)
+	}
+	return nil
+}
+
+// FindByMetadata returns the actors whose metadata holds value under key, e.g.
+// the actor of a platform handle, oldest first. value is compared as JSON, so
+// the string "42" doesn't match the number 42.
+func (s *ActorStore) FindByMetadata(key string, value interface{}) ([]db.Actor, error) {
+	filter := db.Metadata{key: value}
+	if s.mem != nil {
+		return s.mem.findActors(filter)
+	}
+	var actors []db.Actor
+	if err := s.query().
+		Where("metadata @> ?::jsonb", filter).
+		Order("created_at").
+		Order("id").
+		Find(&actors).Error; err != nil {
+		return nil, fmt.Errorf("failed to find actors by metadata: %w", err)
+	}
+	return actors, nil
+}
+
+// GetSessions returns the sessions an actor takes part in with their sessions
+// loaded, most recently seen first
+func (s *ActorStore) GetSessions(actorID id.ID) ([]db.SessionActor, error) {
+	if s.mem != nil {
+		return s.mem.listParticipants(func(participant db.SessionActor) bool {
+			return participant.ActorID == actorID
+		}, false, true), nil
+	}
+	var sessions []db.SessionActor
+	q := scopeSessionTenant(s.db.WithContext(s.ctx), s.tenant, "session_id")
+	if err := preloadTenant(q, s.tenant, "Session").
+		Where("actor_id = ?", actorID).
+		Order("last_seen_at DESC").
+		Order("session_id").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get actor sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// MergedActorsKey is the metadata key under which Merge records the actors
+// merged into an actor, as a list of objects with their "id", "name" and
+// "merged_at" time
+const MergedActorsKey = "merged_actors"
+
+// Merge merges actors that turned out to be the same, e.g. one person with
+// accounts on several platforms, into the actor keepID, in one transaction:
+//
+//	err := actors.Merge(aliceID, aliceTwitterID, aliceDiscordID)
+//
+// The fragments of the merged actors in every fragment table and their session
+// participations move to the kept actor, and their metadata is merged into
+// its. Keys the kept actor already has keep its values, and earlier merged
+// actors take precedence over later ones. The merged actors are soft-deleted
+// and recorded in the kept actor's metadata under MergedActorsKey.
+//
+// A missing actor fails the merge with an error matching ErrNotFound. Records
+// caching the merged actors, such as fragments cached with WithCache by other
+// stores, keep them until they expire.
+func (s *ActorStore) Merge(keepID id.ID, mergeIDs ...id.ID) error {
+	if s.dryRun {
+		return nil
+	}
+	mergeIDs, err := mergeActorIDs(keepID, mergeIDs)
+	if err != nil {
+		return fmt.Errorf("failed to merge actors: %w", err)
+	}
+	if len(mergeIDs) == 0 {
+		return nil
+	}
+	defer s.invalidate(append([]id.ID{keepID}, mergeIDs...)...)
+	if s.mem != nil {
+		if err := s.mem.mergeActors(keepID, mergeIDs, time.Now()); err != nil {
+			return fmt.Errorf("failed to merge actors: %w", err)
+		}
+		return nil
+	}
+
+	err = s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the actors in ID order, so concurrent merges of the same
+		// actors wait for each other rather than deadlock
+		var actors []db.Actor
+		if err := scopeTenant(tx, s.tenant, "tenant_id").
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", append([]id.ID{keepID}, mergeIDs...)).
+			Order("id").
+			Find(&actors).Error; err != nil {
+			return fmt.Errorf("failed to get actors: %w", err)
+		}
+		keep, merged, err := splitMergedActors(actors, keepID, mergeIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range db.FragmentTables() {
+			if err := scopeTenant(tx.Table(string(table)).Unscoped(), s.tenant, "tenant_id").
+				Where("actor_id IN ?", mergeIDs).
+				Update("actor_id", keepID).Error; err != nil {
+				return fmt.Errorf("failed to move %s fragments: %w", table, err)
+			}
+		}
+
+		args := map[string]interface{}{"keep": keepID, "merged": mergeIDs}
+		if err := tx.Exec(`
+			INSERT INTO session_actors (session_id, actor_id, first_seen_at, last_seen_at)
+			SELECT session_id, @keep::uuid, MIN(first_seen_at), MAX(last_seen_at)
+			FROM session_actors
+			WHERE actor_id IN @merged
+			GROUP BY session_id
+			ON CONFLICT (session_id, actor_id) DO UPDATE SET
+				first_seen_at = LEAST(session_actors.first_seen_at, excluded.first_seen_at),
+				last_seen_at = GREATEST(session_actors.last_seen_at, excluded.last_seen_at)`, args).Error; err != nil {
+			return fmt.Errorf("failed to move session participations: %w", err)
+		}
+		if err := tx.Where("actor_id IN ?", mergeIDs).Delete(&db.SessionActor{}).Error; err != nil {
+			return fmt.Errorf("failed to remove session participations: %w", err)
+		}
+
+		if err := tx.Model(&db.Actor{}).
+			Where("id = ?", keepID).
+			Update("metadata", mergedActorMetadata(keep, merged, time.Now())).Error; err != nil {
+			return fmt.Errorf("failed to update actor metadata: %w", err)
+		}
+		if err := tx.Where("id IN ?", mergeIDs).Delete(&db.Actor{}).Error; err != nil {
+			return fmt.Errorf("failed to delete merged actors: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge actors: %w", err)
+	}
+	return nil
+}
+
+// mergeActorIDs returns the distinct IDs of the actors to merge into keepID
+func mergeActorIDs(keepID id.ID, mergeIDs []id.ID) ([]id.ID, error) {
+	if keepID == "" {
+		return nil, fmt.Errorf("actor to keep is required")
+	}
+	seen := make(map[id.ID]bool, len(mergeIDs))
+	distinct := make([]id.ID, 0, len(mergeIDs))
+	for _, mergeID := range mergeIDs {
+		if mergeID == keepID {
+			return nil, fmt.Errorf("actor %s cannot be merged into itself", keepID)
+		}
+		if !seen[mergeID] {
+			seen[mergeID] = true
+			distinct = append(distinct, mergeID)
+		}
+	}
+	return distinct, nil
+}
+
+// splitMergedActors returns the kept actor and the merged ones, in the order of
+// mergeIDs, from the actors found for them
+func splitMergedActors(actors []db.Actor, keepID id.ID, mergeIDs []id.ID) (db.Actor, []db.Actor, error) {
+	byID := make(map[id.ID]db.Actor, len(actors))
+	for _, actor := range actors {
+		byID[actor.ID] = actor
+	}
+	keep, ok := byID[keepID]
+	if !ok {
+		return db.Actor{}, nil, fmt.Errorf("actor %s: %w", keepID, ErrNotFound)
+	}
+	merged := make([]db.Actor, len(mergeIDs))
+	for i, mergeID := range mergeIDs {
+		actor, ok := byID[mergeID]
+		if !ok {
+			return db.Actor{}, nil, fmt.Errorf("actor %s: %w", mergeID, ErrNotFound)
+		}
+		merged[i] = actor
+	}
+	return keep, merged, nil
+}
+
+// mergedActorMetadata returns the metadata of keep once the merged actors are
+// merged into it at mergedAt, see Merge
+func mergedActorMetadata(keep db.Actor, merged []db.Actor, mergedAt time.Time) db.Metadata {
+	metadata := keep.Metadata.Clone()
+	if metadata == nil {
+		metadata = db.Metadata{}
+	}
+	history := mergeHistory(metadata[MergedActorsKey])
+	for _, actor := range merged {
+		for key, value := range actor.Metadata {
+			if _, exists := metadata[key]; !exists && key != MergedActorsKey {
+				metadata[key] = value
+			}
+		}
+		history = append(history, mergeHistory(actor.Metadata[MergedActorsKey])...)
+		history = append(history, map[string]interface{}{
+			"id":        string(actor.ID),
+			"name":      actor.Name,
+			"merged_at": mergedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	metadata[MergedActorsKey] = history
+	return metadata
+}
+
+// mergeHistory returns the entries recorded under MergedActorsKey, none if the
+// value isn't a list
+func mergeHistory(value interface{}) []interface{} {
+	entries, _ := value.([]interface{})
+	return append([]interface{}(nil), entries...)
+}
+
+// invalidate drops actors from the store's cache after writing them
+func (s *ActorStore) invalidate(actorIDs ...id.ID) {
+	s.cache.invalidate(actorIDs...)
+}
+
+// query returns the store's database with its context, scoped to its tenant
+func (s *ActorStore) query() *gorm.DB {
+	return scopeTenant(s.db.WithContext(s.ctx), s.tenant, "tenant_id")
+}
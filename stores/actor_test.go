@@ -0,0 +1,499 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+func TestActorStoreNotFound(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		store := bundle.Actors()
+		existing := &db.Actor{ID: id.New(), Name: "alice"}
+		if err := store.Create(existing); err != nil {
+			t.Fatal(err)
+		}
+		missing := id.New()
+
+		tests := []struct {
+			name string
+			call func(actorID id.ID) error
+		}{
+			{name: "GetByID", call: func(actorID id.ID) error {
+				_, err := store.GetByID(actorID)
+				return err
+			}},
+			{name: "UpdateMetadata", call: func(actorID id.ID) error {
+				return store.UpdateMetadata(actorID, db.Metadata{"timezone": "UTC"})
+			}},
+			{name: "Merge into", call: func(actorID id.ID) error {
+				other := &db.Actor{ID: id.New(), Name: "bob"}
+				if err := store.Create(other); err != nil {
+					return err
+				}
+				return store.Merge(actorID, other.ID)
+			}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := tt.call(existing.ID); err != nil {
+					t.Fatalf("%s of an existing actor failed: %v", tt.name, err)
+				}
+				if err := tt.call(missing); !errors.Is(err, ErrNotFound) {
+					t.Errorf("%s of a missing actor returned %v, want ErrNotFound", tt.name, err)
+				}
+			})
+		}
+
+		if err := store.Merge(existing.ID, missing); !errors.Is(err, ErrNotFound) {
+			t.Errorf("merging a missing actor returned %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestActorUpsertMergesMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing db.Metadata
+		upsert   db.Metadata
+		want     db.Metadata
+	}{
+		{name: "new actor", upsert: db.Metadata{"handle": "@alice"}, want: db.Metadata{"handle": "@alice"}},
+		{name: "kept without metadata", existing: db.Metadata{"handle": "@alice"}, want: db.Metadata{"handle": "@alice"}},
+		{
+			name:     "merged",
+			existing: db.Metadata{"handle": "@alice", "locale": "en"},
+			upsert:   db.Metadata{"locale": "fr", "avatar": "alice.png"},
+			want:     db.Metadata{"handle": "@alice", "locale": "fr", "avatar": "alice.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStores(t, func(t *testing.T, bundle *Stores) {
+				store := bundle.Actors()
+				actorID := id.New()
+				if tt.existing != nil {
+					if err := store.Create(&db.Actor{ID: actorID, Name: "alice", Metadata: tt.existing}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := store.Upsert(&db.Actor{ID: actorID, Name: "Alice", Metadata: tt.upsert}); err != nil {
+					t.Fatal(err)
+				}
+
+				actor, err := store.GetByID(actorID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if actor.Name != "Alice" {
+					t.Errorf("name = %q, want Alice", actor.Name)
+				}
+				if fmt.Sprint(actor.Metadata) != fmt.Sprint(tt.want) {
+					t.Errorf("metadata = %v, want %v", actor.Metadata, tt.want)
+				}
+			})
+		})
+	}
+}
+
+// testConcurrentMetadataPatches creates an actor in store and patches its
+// metadata from many goroutines, each with a key of its own and a shared one
+func testConcurrentMetadataPatches(t *testing.T, store *ActorStore, actorID id.ID) {
+	const patches = 20
+	actor := &db.Actor{ID: actorID, Name: "alice", Metadata: db.Metadata{"handle": "@alice"}}
+	if err := store.Create(actor); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, patches)
+	for i := 0; i < patches; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- store.UpdateMetadata(actor.ID, db.Metadata{fmt.Sprintf("key_%d", i): i, "last": i})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := store.GetByID(actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Metadata["handle"] != "@alice" {
+		t.Errorf("handle = %v, want the metadata set before the patches", stored.Metadata["handle"])
+	}
+	for i := 0; i < patches; i++ {
+		if _, ok := stored.Metadata[fmt.Sprintf("key_%d", i)]; !ok {
+			t.Errorf("key_%d was lost", i)
+		}
+	}
+	if _, ok := stored.Metadata["last"]; !ok {
+		t.Error("the shared key was lost")
+	}
+}
+
+func TestActorMetadataConcurrentPatches(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testConcurrentMetadataPatches(t, NewMemoryStores(context.Background()).Actors(), id.New())
+	})
+	// Concurrent patches need connections of their own, so they run outside
+	// of a test transaction and remove their actor afterwards
+	t.Run("live", func(t *testing.T) {
+		database := openTestPool(t)
+		if database == nil {
+			t.Skipf("%s is not set", testDatabaseURLEnv)
+		}
+		actorID := id.New()
+		t.Cleanup(func() {
+			database.Unscoped().Where("id = ?", actorID).Delete(&db.Actor{})
+		})
+		testConcurrentMetadataPatches(t, NewStores(context.Background(), database).Actors(), actorID)
+	})
+}
+
+func TestActorFindByMetadata(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		store := bundle.Actors()
+		start := time.Now().Add(-time.Hour)
+		for i, actor := range []*db.Actor{
+			{Name: "alice", Metadata: db.Metadata{"discord_id": "42", "locale": "en"}},
+			{Name: "bob", Metadata: db.Metadata{"discord_id": 42, "preferences": map[string]interface{}{"tone": "formal"}}},
+			{Name: "carol", Metadata: db.Metadata{"locale": "en"}},
+		} {
+			actor.ID = id.New()
+			actor.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+			if err := store.Create(actor); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name  string
+			key   string
+			value interface{}
+			want  []string
+		}{
+			{name: "string", key: "discord_id", value: "42", want: []string{"alice"}},
+			{name: "number", key: "discord_id", value: 42, want: []string{"bob"}},
+			{name: "oldest first", key: "locale", value: "en", want: []string{"alice", "carol"}},
+			{name: "nested", key: "preferences", value: map[string]interface{}{"tone": "formal"}, want: []string{"bob"}},
+			{name: "none", key: "locale", value: "fr"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				actors, err := store.FindByMetadata(tt.key, tt.value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, actor := range actors {
+					got = append(got, actor.Name)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("actors with %s %v = %v, want %v", tt.key, tt.value, got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+// mergeFixture is a kept actor and the actors merged into it, alice on
+// twitter and discord, with bob left alone
+type mergeFixture struct {
+	keep, twitter, discord, bob *db.Actor
+	// shared is taken part in by keep and twitter, discord's by discord only
+	shared, discordSession *db.Session
+	start                  time.Time
+}
+
+// newMergeFixture writes the actors of a merge with fragments in several
+// fragment tables and session participations. Alice and her discord account
+// have a conversation each, the other actors write to alice's.
+func newMergeFixture(t *testing.T, bundle *Stores) *mergeFixture {
+	t.Helper()
+	c := seedConversations(t, bundle, []seedFragment{{name: "keep"}, {name: "discord", other: true}})
+	f := &mergeFixture{
+		keep:           c.actor,
+		twitter:        &db.Actor{ID: id.New(), Name: "alice_tw", Metadata: db.Metadata{"locale": "fr", "twitter": "@al"}},
+		discord:        c.other,
+		bob:            &db.Actor{ID: id.New(), Name: "bob"},
+		shared:         c.session,
+		discordSession: c.otherSession,
+		start:          c.start,
+	}
+	for _, actor := range []*db.Actor{f.twitter, f.bob} {
+		if err := bundle.Actors().Create(actor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for actor, metadata := range map[*db.Actor]db.Metadata{
+		f.keep:    {"locale": "en", "handle": "@alice"},
+		f.discord: {"discord": "alice#1", "twitter": "@other"},
+	} {
+		if err := bundle.Actors().UpdateMetadata(actor.ID, metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, seen := range []struct {
+		session *db.Session
+		actor   *db.Actor
+		minutes []int
+	}{
+		{session: f.shared, actor: f.keep, minutes: []int{10, 20}},
+		{session: f.shared, actor: f.twitter, minutes: []int{5, 30}},
+		{session: f.discordSession, actor: f.discord, minutes: []int{15}},
+	} {
+		for _, minute := range seen.minutes {
+			if err := bundle.Sessions().AddParticipant(seen.session.ID, seen.actor.ID, f.start.Add(time.Duration(minute)*time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, authored := range []struct {
+		table   db.FragmentTable
+		actor   *db.Actor
+		session *db.Session
+	}{
+		{table: db.FragmentTableInteraction, actor: f.twitter, session: f.shared},
+		{table: db.FragmentTableInteraction, actor: f.bob, session: f.shared},
+		{table: db.FragmentTableInsight, actor: f.twitter, session: f.shared},
+		{table: db.FragmentTableTwitter, actor: f.twitter, session: f.shared},
+		{table: db.FragmentTableTwitter, actor: f.bob, session: f.shared},
+	} {
+		fragment := batchFragment(authored.actor, authored.session, authored.actor.Name)
+		if err := bundle.Fragments(authored.table).Create(fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+// authors returns the authors of the fragments of a table, by name
+func authors(t *testing.T, bundle *Stores, table db.FragmentTable, f *mergeFixture) map[string]int {
+	t.Helper()
+	names := map[id.ID]string{f.keep.ID: "keep", f.twitter.ID: "twitter", f.discord.ID: "discord", f.bob.ID: "bob"}
+	counts := make(map[string]int)
+	for _, session := range []*db.Session{f.shared, f.discordSession} {
+		fragments, err := bundle.Fragments(table).GetSessionHistory(session.ID, HistoryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fragment := range fragments {
+			counts[names[fragment.ActorID]]++
+		}
+	}
+	return counts
+}
+
+func TestActorMerge(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		f := newMergeFixture(t, bundle)
+		if err := bundle.Actors().Merge(f.keep.ID, f.twitter.ID, f.discord.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("fragments", func(t *testing.T) {
+			tests := []struct {
+				table db.FragmentTable
+				want  map[string]int
+			}{
+				{table: db.FragmentTableInteraction, want: map[string]int{"keep": 3, "bob": 1}},
+				{table: db.FragmentTableInsight, want: map[string]int{"keep": 1}},
+				{table: db.FragmentTableTwitter, want: map[string]int{"keep": 1, "bob": 1}},
+			}
+			for _, tt := range tests {
+				if got := authors(t, bundle, tt.table, f); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("authors of %s = %v, want %v", tt.table, got, tt.want)
+				}
+			}
+		})
+
+		t.Run("participations", func(t *testing.T) {
+			tests := []struct {
+				session     *db.Session
+				first, last int
+			}{
+				// The earliest and latest sightings of keep and twitter
+				{session: f.shared, first: 5, last: 30},
+				{session: f.discordSession, first: 15, last: 15},
+			}
+			for _, tt := range tests {
+				participants, err := bundle.Sessions().GetParticipants(tt.session.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(participants) != 1 || participants[0].ActorID != f.keep.ID {
+					t.Errorf("participants = %v, want the kept actor only", participants)
+					continue
+				}
+				first, last := f.start.Add(time.Duration(tt.first)*time.Minute), f.start.Add(time.Duration(tt.last)*time.Minute)
+				if got := participants[0]; !got.FirstSeenAt.Equal(first) || !got.LastSeenAt.Equal(last) {
+					t.Errorf("seen from %s to %s, want %s to %s", got.FirstSeenAt, got.LastSeenAt, first, last)
+				}
+			}
+		})
+
+		t.Run("metadata", func(t *testing.T) {
+			kept, err := bundle.Actors().GetByID(f.keep.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tests := []struct {
+				key  string
+				want interface{}
+			}{
+				// The kept actor's values win, then those of earlier merged actors
+				{key: "locale", want: "en"},
+				{key: "handle", want: "@alice"},
+				{key: "twitter", want: "@al"},
+				{key: "discord", want: "alice#1"},
+			}
+			for _, tt := range tests {
+				if got := kept.Metadata[tt.key]; got != tt.want {
+					t.Errorf("metadata %s = %v, want %v", tt.key, got, tt.want)
+				}
+			}
+
+			history, ok := kept.Metadata[MergedActorsKey].([]interface{})
+			if !ok || len(history) != 2 {
+				t.Fatalf("merge history = %v, want both merged actors", kept.Metadata[MergedActorsKey])
+			}
+			for i, actor := range []*db.Actor{f.twitter, f.discord} {
+				entry, _ := history[i].(map[string]interface{})
+				if entry["id"] != string(actor.ID) || entry["name"] != actor.Name || entry["merged_at"] == nil {
+					t.Errorf("merge history entry %d = %v, want %s", i, history[i], actor.Name)
+				}
+			}
+		})
+
+		t.Run("merged actors deleted", func(t *testing.T) {
+			for _, actor := range []*db.Actor{f.twitter, f.discord} {
+				if _, err := bundle.Actors().GetByID(actor.ID); !errors.Is(err, ErrNotFound) {
+					t.Errorf("merged actor %s: %v, want not found", actor.Name, err)
+				}
+			}
+			if _, err := bundle.Actors().GetByID(f.bob.ID); err != nil {
+				t.Errorf("unmerged actor: %v", err)
+			}
+		})
+	})
+}
+
+func TestActorMergeHistoryChained(t *testing.T) {
+	store := NewMemoryStores(context.Background()).Actors()
+	var actors []*db.Actor
+	for _, name := range []string{"a", "b", "c"} {
+		actor := &db.Actor{ID: id.New(), Name: name}
+		if err := store.Create(actor); err != nil {
+			t.Fatal(err)
+		}
+		actors = append(actors, actor)
+	}
+
+	// b absorbs c, then a absorbs b, keeping the history of both merges
+	if err := store.Merge(actors[1].ID, actors[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Merge(actors[0].ID, actors[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := store.GetByID(actors[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []interface{}
+	for _, entry := range kept.Metadata[MergedActorsKey].([]interface{}) {
+		names = append(names, entry.(map[string]interface{})["name"])
+	}
+	if fmt.Sprint(names) != "[c b]" {
+		t.Errorf("merged actors = %v, want [c b]", names)
+	}
+}
+
+func TestActorMergeInvalid(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		f := newMergeFixture(t, bundle)
+		missing := id.New()
+		tests := []struct {
+			name     string
+			keep     id.ID
+			merge    []id.ID
+			want     string
+			notFound bool
+		}{
+			{name: "no actor to keep", merge: []id.ID{f.twitter.ID}, want: "actor to keep is required"},
+			{name: "into itself", keep: f.keep.ID, merge: []id.ID{f.twitter.ID, f.keep.ID}, want: "cannot be merged into itself"},
+			{name: "missing actor to keep", keep: missing, merge: []id.ID{f.twitter.ID}, want: string(missing), notFound: true},
+			{name: "missing merged actor", keep: f.keep.ID, merge: []id.ID{f.twitter.ID, missing}, want: string(missing), notFound: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := bundle.Actors().Merge(tt.keep, tt.merge...)
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("error = %v, want %q", err, tt.want)
+				}
+				if errors.Is(err, ErrNotFound) != tt.notFound {
+					t.Errorf("error %v matches ErrNotFound = %v, want %v", err, !tt.notFound, tt.notFound)
+				}
+			})
+		}
+
+		// Failed merges change nothing
+		if got := authors(t, bundle, db.FragmentTableInsight, f); got["twitter"] != 1 {
+			t.Errorf("authors of insights = %v after failed merges, want twitter's fragment kept", got)
+		}
+		if _, err := bundle.Actors().GetByID(f.twitter.ID); err != nil {
+			t.Errorf("actor deleted by a failed merge: %v", err)
+		}
+		// Merging nothing, or the same actor twice, is fine
+		if err := bundle.Actors().Merge(f.keep.ID); err != nil {
+			t.Errorf("merging no actors: %v", err)
+		}
+		if err := bundle.Actors().Merge(f.keep.ID, f.twitter.ID, f.twitter.ID); err != nil {
+			t.Errorf("merging an actor twice: %v", err)
+		}
+	})
+}
+
+func TestActorMergeInvalidatesCache(t *testing.T) {
+	bundle := NewMemoryStores(context.Background())
+	f := newMergeFixture(t, bundle)
+	store := bundle.Actors().WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+	for _, actor := range []*db.Actor{f.keep, f.twitter} {
+		if _, err := store.GetByID(actor.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.WithContext(context.Background()).Merge(f.keep.ID, f.twitter.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetByID(f.twitter.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("merged actor after the merge: %v, want not found", err)
+	}
+	kept, err := store.GetByID(f.keep.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Metadata["twitter"] != "@al" {
+		t.Errorf("kept actor metadata = %v, want the merged metadata", kept.Metadata)
+	}
+}
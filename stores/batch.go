@@ -0,0 +1,204 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultUpsertChunkSize is the number of fragments UpsertBatch writes per
+// statement when no chunk size is given
+const DefaultUpsertChunkSize = 500
+
+// BatchError reports the fragments of a batch that couldn't be written, by
+// their index in the batch. The other fragments were written.
+type BatchError struct {
+	Errors map[int]error
+	Total  int // Number of fragments in the batch
+}
+
+func (e *BatchError) Error() string {
+	indexes := e.indexes()
+	first := indexes[0]
+	return fmt.Sprintf("%d of %d fragments failed, first at %d: %v", len(indexes), e.Total, first, e.Errors[first])
+}
+
+// Unwrap returns the errors of the failed fragments in batch order
+func (e *BatchError) Unwrap() []error {
+	indexes := e.indexes()
+	errs := make([]error, len(indexes))
+	for i, index := range indexes {
+		errs[i] = e.Errors[index]
+	}
+	return errs
+}
+
+// indexes returns the indexes of the failed fragments in order
+func (e *BatchError) indexes() []int {
+	indexes := make([]int, 0, len(e.Errors))
+	for index := range e.Errors {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// UpsertBatch inserts fragments or updates those whose ID already exists, with
+// one multi-row INSERT ... ON CONFLICT per chunk of chunkSize fragments
+// (DefaultUpsertChunkSize if not positive), for bulk ingestion:
+//
+//	if err := store.UpsertBatch(tweets, 1000); err != nil {
+//		var batchErr *stores.BatchError
+//		if errors.As(err, &batchErr) {
+//			for index, err := range batchErr.Errors { ... }
+//		}
+//	}
+//
+// Fragments without an ID, with an embedding of the wrong size or repeating an
+// ID of the batch fail without reaching the database. A chunk the database
+// rejects is retried one fragment at a time to find the failing ones. Failed
+// fragments are reported in a *BatchError; the others are written.
+//
+// Each chunk runs in its own transaction, or in a savepoint of the store's
+// transaction when bound to one with WithTx.
+func (s *FragmentStore) UpsertBatch(fragments []*db.Fragment, chunkSize int) error {
+	if s.dryRun || len(fragments) == 0 {
+		return nil
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultUpsertChunkSize
+	}
+
+	failed := make(map[int]error)
+	seen := make(map[id.ID]bool, len(fragments))
+	defer func() {
+		var written []id.ID
+		for _, fragment := range fragments {
+			if fragment != nil {
+				written = append(written, fragment.ID)
+			}
+		}
+		s.invalidate(written...)
+	}()
+
+	// Rows without an embedding omit the column, as Upsert does, so they are
+	// written in statements of their own
+	var embedded, plain []int
+	for i, fragment := range fragments {
+		if err := validateBatchFragment(fragment, seen); err != nil {
+			failed[i] = err
+			continue
+		}
+		if err := stampTenant("fragment", fragment.ID, &fragment.TenantID, s.tenant); err != nil {
+			failed[i] = err
+			continue
+		}
+		seen[fragment.ID] = true
+		if len(fragment.Embedding.Slice()) == 0 {
+			plain = append(plain, i)
+		} else {
+			embedded = append(embedded, i)
+		}
+	}
+
+	for _, indexes := range [][]int{embedded, plain} {
+		for start := 0; start < len(indexes); start += chunkSize {
+			end := start + chunkSize
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+			if err := s.upsertChunk(fragments, indexes[start:end], failed); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BatchError{Errors: failed, Total: len(fragments)}
+	}
+	return nil
+}
+
+// validateBatchFragment checks a fragment can be written in a batch with the
+// IDs seen before it
+func validateBatchFragment(fragment *db.Fragment, seen map[id.ID]bool) error {
+	if fragment == nil {
+		return fmt.Errorf("fragment is nil")
+	}
+	if fragment.ID == "" {
+		return fmt.Errorf("fragment has no ID")
+	}
+	if seen[fragment.ID] {
+		return fmt.Errorf("fragment %s appears more than once in the batch", fragment.ID)
+	}
+	if n := len(fragment.Embedding.Slice()); n != 0 && n != db.EmbeddingDimensions {
+		return fmt.Errorf("fragment %s has an embedding of %d dimensions, want %d", fragment.ID, n, db.EmbeddingDimensions)
+	}
+	return nil
+}
+
+// upsertChunk writes the fragments at the given indexes in one statement,
+// falling back to one statement per fragment if it fails and recording the
+// fragments that still fail. Only context errors are returned, since they fail
+// the rest of the batch too.
+func (s *FragmentStore) upsertChunk(fragments []*db.Fragment, indexes []int, failed map[int]error) error {
+	chunk := make([]*db.Fragment, len(indexes))
+	for i, index := range indexes {
+		chunk[i] = fragments[index]
+	}
+
+	err := s.upsertRows(chunk)
+	if err == nil {
+		return nil
+	}
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("failed to upsert fragments: %w", ctxErr)
+	}
+	if len(chunk) == 1 {
+		failed[indexes[0]] = fmt.Errorf("failed to upsert fragment: %w", err)
+		return nil
+	}
+
+	for i, fragment := range chunk {
+		if err := s.upsertRows([]*db.Fragment{fragment}); err != nil {
+			if ctxErr := s.ctx.Err(); ctxErr != nil {
+				return fmt.Errorf("failed to upsert fragments: %w", ctxErr)
+			}
+			failed[indexes[i]] = fmt.Errorf("failed to upsert fragment: %w", err)
+		}
+	}
+	return nil
+}
+
+// upsertRows writes fragments in one INSERT ... ON CONFLICT statement inside a
+// transaction, or a savepoint when the store is bound to one, so a failure
+// leaves an ambient transaction usable
+func (s *FragmentStore) upsertRows(chunk []*db.Fragment) error {
+	if s.mem != nil {
+		for _, fragment := range chunk {
+			if _, err := s.mem.writeFragment(s.table, fragment, true, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		result := s.WithTx(tx).write(chunk[0]).Clauses(conflictScope(clause.OnConflict{
+			Columns:   s.conflictColumns(),
+			UpdateAll: true,
+		}, string(s.table), s.tenant)).Create(&chunk)
+		if result.Error != nil {
+			return result.Error
+		}
+		// Rows of other tenants are left as they are, failing the chunk
+		if s.tenant != "" && result.RowsAffected < int64(len(chunk)) {
+			return fmt.Errorf("%d of %d fragments: %w", int64(len(chunk))-result.RowsAffected, len(chunk), ErrOtherTenant)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,271 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// batchRow is the kind of a fragment of a batch in TestUpsertBatch
+type batchRow string
+
+const (
+	rowNew         batchRow = "new"          // A new fragment with an embedding
+	rowPlain       batchRow = "plain"        // A new fragment without embedding
+	rowUpdate      batchRow = "update"       // A new version of an existing fragment
+	rowNil         batchRow = "nil"          // A nil fragment
+	rowNoID        batchRow = "no id"        // A fragment without ID
+	rowBadDims     batchRow = "bad dims"     // A fragment with a 2 dimension embedding
+	rowDuplicate   batchRow = "duplicate"    // The ID of the previous fragment again
+	rowBadMetadata batchRow = "bad metadata" // A fragment whose metadata can't be encoded
+)
+
+// batchConversation creates an actor and a session for the fragments of a
+// batch in bundle
+func batchConversation(tb testing.TB, bundle *Stores) (*db.Actor, *db.Session) {
+	tb.Helper()
+	actor := &db.Actor{ID: id.New(), Name: "alice"}
+	if err := bundle.Actors().Create(actor); err != nil {
+		tb.Fatal(err)
+	}
+	session := &db.Session{ID: id.New(), Metadata: db.Metadata{}, LastActivityAt: time.Now()}
+	if err := bundle.Sessions().Create(session); err != nil {
+		tb.Fatal(err)
+	}
+	return actor, session
+}
+
+// batchFragment returns a new fragment of a conversation with content
+func batchFragment(actor *db.Actor, session *db.Session, content string) *db.Fragment {
+	return &db.Fragment{
+		ID:        id.New(),
+		ActorID:   actor.ID,
+		SessionID: session.ID,
+		Content:   content,
+		Metadata:  db.Metadata{},
+		Embedding: embedding(1),
+	}
+}
+
+// testUpsertBatch runs the UpsertBatch cases in bundle
+func testUpsertBatch(t *testing.T, bundle *Stores) {
+	tests := []struct {
+		name      string
+		chunkSize int
+		rows      []batchRow
+		// failed are the indexes of the rows expected to fail
+		failed []int
+	}{
+		{name: "empty"},
+		{name: "valid", chunkSize: 2, rows: []batchRow{rowNew, rowNew, rowPlain, rowNew, rowPlain}},
+		{name: "default chunk size", rows: []batchRow{rowNew, rowPlain, rowNew}},
+		{name: "update", chunkSize: 2, rows: []batchRow{rowUpdate, rowNew}},
+		{
+			name:      "invalid rows",
+			chunkSize: 2,
+			rows:      []batchRow{rowNew, rowNil, rowNoID, rowBadDims, rowNew, rowDuplicate},
+			failed:    []int{1, 2, 3, 5},
+		},
+		{
+			name:      "rejected row in a chunk",
+			chunkSize: 3,
+			rows:      []batchRow{rowNew, rowBadMetadata, rowNew, rowNew},
+			failed:    []int{1},
+		},
+		{
+			name:      "rejected row alone",
+			chunkSize: 1,
+			rows:      []batchRow{rowNew, rowBadMetadata, rowPlain},
+			failed:    []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := bundle.Fragments(db.FragmentTableInteraction)
+			actor, session := batchConversation(t, bundle)
+			existing := batchFragment(actor, session, "existing")
+			if err := store.Create(existing); err != nil {
+				t.Fatal(err)
+			}
+
+			fragments := make([]*db.Fragment, len(tt.rows))
+			for i, row := range tt.rows {
+				fragment := batchFragment(actor, session, fmt.Sprintf("row %d", i))
+				switch row {
+				case rowPlain:
+					fragment.Embedding = pgvector.Vector{}
+				case rowUpdate:
+					fragment.ID = existing.ID
+				case rowNil:
+					fragment = nil
+				case rowNoID:
+					fragment.ID = ""
+				case rowBadDims:
+					fragment.Embedding = pgvector.NewVector([]float32{1, 2})
+				case rowDuplicate:
+					fragment.ID = fragments[i-1].ID
+				case rowBadMetadata:
+					fragment.Metadata = db.Metadata{"callback": func() {}}
+				}
+				fragments[i] = fragment
+			}
+
+			err := store.UpsertBatch(fragments, tt.chunkSize)
+			var batchErr *BatchError
+			if len(tt.failed) == 0 {
+				if err != nil {
+					t.Fatalf("UpsertBatch failed: %v", err)
+				}
+			} else {
+				if !errors.As(err, &batchErr) {
+					t.Fatalf("UpsertBatch returned %v, want a BatchError", err)
+				}
+				if fmt.Sprint(batchErr.indexes()) != fmt.Sprint(tt.failed) || batchErr.Total != len(tt.rows) {
+					t.Errorf("failed rows = %v of %d, want %v of %d", batchErr.indexes(), batchErr.Total, tt.failed, len(tt.rows))
+				}
+			}
+
+			for i, row := range tt.rows {
+				if batchErr != nil && batchErr.Errors[i] != nil {
+					if row == rowBadMetadata {
+						if _, err := store.GetByID(fragments[i].ID); err == nil {
+							t.Errorf("rejected row %d was stored", i)
+						}
+					}
+					continue
+				}
+				stored, err := store.GetByID(fragments[i].ID)
+				if err != nil {
+					t.Fatalf("row %d: %v", i, err)
+				}
+				if want := fmt.Sprintf("row %d", i); stored.Content != want {
+					t.Errorf("row %d stored as %q, want %q", i, stored.Content, want)
+				}
+				if got, want := len(stored.Embedding.Slice()), len(fragments[i].Embedding.Slice()); got != want {
+					t.Errorf("row %d stored with %d dimensions, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpsertBatch(t *testing.T) {
+	forEachStores(t, testUpsertBatch)
+}
+
+func TestUpsertBatchOtherTenant(t *testing.T) {
+	bundle := NewMemoryStores(context.Background())
+	actor, session := batchConversation(t, bundle)
+	theirs := batchFragment(actor, session, "theirs")
+	if err := bundle.WithTenant("tenant-a").Fragments(db.FragmentTableInteraction).Create(theirs); err != nil {
+		t.Fatal(err)
+	}
+
+	store := bundle.WithTenant("tenant-b").Fragments(db.FragmentTableInteraction)
+	mine := batchFragment(actor, session, "mine")
+	overwrite := batchFragment(actor, session, "overwritten")
+	overwrite.ID = theirs.ID
+	err := store.UpsertBatch([]*db.Fragment{overwrite, mine}, 2)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 1 || !errors.Is(batchErr.Errors[0], ErrOtherTenant) {
+		t.Fatalf("UpsertBatch returned %v, want the first row to fail with ErrOtherTenant", err)
+	}
+	if _, err := store.GetByID(mine.ID); err != nil {
+		t.Errorf("the valid row was not written: %v", err)
+	}
+	stored, err := bundle.WithTenant("tenant-a").Fragments(db.FragmentTableInteraction).GetByID(theirs.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "theirs" {
+		t.Errorf("the other tenant's fragment was overwritten with %q", stored.Content)
+	}
+}
+
+func TestUpsertBatchDryRun(t *testing.T) {
+	bundle := NewMemoryStores(context.Background())
+	actor, session := batchConversation(t, bundle)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+
+	fragment := batchFragment(actor, session, "dry")
+	if err := store.DryRun().UpsertBatch([]*db.Fragment{fragment, nil}, 0); err != nil {
+		t.Fatalf("dry run UpsertBatch failed: %v", err)
+	}
+	if _, err := store.GetByID(fragment.ID); err == nil {
+		t.Error("dry run UpsertBatch wrote a fragment")
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	errEncode := errors.New("invalid metadata")
+	errNoID := errors.New("fragment has no ID")
+	err := &BatchError{Errors: map[int]error{7: errEncode, 2: errNoID}, Total: 10}
+
+	if want := "2 of 10 fragments failed, first at 2: fragment has no ID"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if got := err.Unwrap(); len(got) != 2 || got[0] != errNoID || got[1] != errEncode {
+		t.Errorf("Unwrap() = %v, want the errors in batch order", got)
+	}
+	for _, target := range []error{errEncode, errNoID} {
+		if !errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = false", err, target)
+		}
+	}
+}
+
+// BenchmarkUpsertBatch compares writing 10k fragments one Upsert at a time with
+// UpsertBatch, in the database named by THOR_TEST_DATABASE_URL or, without it,
+// in memory stores
+func BenchmarkUpsertBatch(b *testing.B) {
+	const rows = 10000
+	bundle := NewMemoryStores(context.Background())
+	if tx := openTestDatabase(b); tx != nil {
+		bundle = NewStores(context.Background(), tx)
+	} else {
+		b.Logf("%s is not set, writing to memory stores", testDatabaseURLEnv)
+	}
+	actor, session := batchConversation(b, bundle)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+
+	fragments := func() []*db.Fragment {
+		batch := make([]*db.Fragment, rows)
+		for i := range batch {
+			batch[i] = batchFragment(actor, session, strings.Repeat("tweet ", 20))
+		}
+		return batch
+	}
+
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			batch := fragments()
+			b.StartTimer()
+			for _, fragment := range batch {
+				if err := store.Upsert(fragment); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			batch := fragments()
+			b.StartTimer()
+			if err := store.UpsertBatch(batch, DefaultUpsertChunkSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package stores
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+)
+
+// DefaultNotFoundTTL is how long a caching store remembers that a record
+// doesn't exist, see ActorStore.WithCache
+const DefaultNotFoundTTL = 5 * time.Second
+
+// CacheStats holds the lookups of a caching store, see ActorStore.WithCache
+type CacheStats struct {
+	Hits         int64 // Lookups answered with a cached record
+	NotFoundHits int64 // Lookups answered with a cached miss
+	Misses       int64 // Lookups that read the database
+}
+
+// HitRate returns the share of lookups answered from the cache, or 0 before
+// any lookup
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.NotFoundHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.NotFoundHits) / float64(total)
+}
+
+// storeCache caches the records a store looks up by ID. The copies of a store
+// share its cache state, so writes through any of them invalidate the records
+// the others cached.
+type storeCache struct {
+	*cacheState
+
+	// bypass skips the cache for lookups, for stores bound to a transaction
+	// whose uncommitted records must not be cached. Writes still invalidate.
+	bypass bool
+}
+
+// cacheState is the state shared by the copies of a caching store
+type cacheState struct {
+	// records is the store's namespace of the cache, see newStoreCache
+	records     cache.Namespace
+	notFoundTTL time.Duration
+
+	// mu orders filling the cache after a lookup with invalidations, and
+	// generation counts the invalidations, so a lookup racing with a write
+	// can't cache the record the write replaced
+	mu         sync.Mutex
+	generation uint64
+
+	hits         int64
+	notFoundHits int64
+	misses       int64
+}
+
+// cachedRecord is what a store caches under the key of a record: the record,
+// or the error of a store of tenant that didn't find it
+type cachedRecord[T any] struct {
+	record   T
+	found    bool
+	tenant   id.ID
+	notFound error
+}
+
+// newStoreCache returns the cache of a store of the records of table, kept in
+// the namespace "stores:<table>" of c. Stores of all tenants share the
+// namespace, so a write through any of them invalidates the record for all.
+// Misses are cached for notFoundTTL, DefaultNotFoundTTL if zero, or not at all
+// if negative.
+func newStoreCache(c *cache.Cache, table string, notFoundTTL time.Duration) *storeCache {
+	if notFoundTTL == 0 {
+		notFoundTTL = DefaultNotFoundTTL
+	}
+	return &storeCache{cacheState: &cacheState{
+		records:     cache.NamespaceOf(c, "stores").Namespace(table),
+		notFoundTTL: notFoundTTL,
+	}}
+}
+
+// inTx returns the cache of a copy of the store bound to a transaction
+func (c *storeCache) inTx() *storeCache {
+	if c == nil {
+		return nil
+	}
+	return &storeCache{cacheState: c.cacheState, bypass: true}
+}
+
+// stats returns the lookups of the store, zero if it doesn't cache
+func (c *storeCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:         atomic.LoadInt64(&c.hits),
+		NotFoundHits: atomic.LoadInt64(&c.notFoundHits),
+		Misses:       atomic.LoadInt64(&c.misses),
+	}
+}
+
+// invalidate drops the cached records with IDs after a write
+func (c *storeCache) invalidate(recordIDs ...id.ID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, recordID := range recordIDs {
+		c.records.Delete(cache.CacheKey(recordID))
+	}
+}
+
+// clear drops all the cached records of the store's table after writes whose
+// records aren't known
+func (c *storeCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.records.Clear()
+}
+
+// fill caches value under key of records, unless the cache was invalidated
+// since generation was read before the lookup
+func fill[T any](c *storeCache, records cache.View[cachedRecord[T]], key cache.CacheKey, generation uint64, value cachedRecord[T], ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if ttl > 0 {
+		records.SetWithTTL(key, value, ttl)
+	} else {
+		records.Set(key, value)
+	}
+}
+
+// currentGeneration returns the number of invalidations so far
+func (c *storeCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// cachedLookup returns the record with ID recordID from the cache of a store
+// scoped to tenant, or looks it up with load and caches the result. Cached records
+// the store's tenant can't see, and misses of stores of other tenants, are
+// looked up again. Records are cloned going in and out of the cache, so
+// callers can't modify the cached ones.
+func cachedLookup[T any](c *storeCache, recordID id.ID, tenant id.ID, owner func(T) *id.ID, load func() (*T, error), clone func(T) T) (*T, error) {
+	if c == nil || c.bypass {
+		return load()
+	}
+	key := cache.CacheKey(recordID)
+	records := cache.ViewOfNamespace[cachedRecord[T]](c.records)
+	if cached, ok := records.Get(key); ok {
+		switch {
+		case cached.found && tenantOwns(tenant, owner(cached.record)):
+			atomic.AddInt64(&c.hits, 1)
+			record := clone(cached.record)
+			return &record, nil
+		case !cached.found && cached.tenant == tenant:
+			atomic.AddInt64(&c.notFoundHits, 1)
+			return nil, cached.notFound
+		}
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	generation := c.currentGeneration()
+	record, err := load()
+	switch {
+	case err == nil:
+		fill(c, records, key, generation, cachedRecord[T]{record: clone(*record), found: true}, 0)
+	case errors.Is(err, ErrNotFound) && c.notFoundTTL > 0:
+		fill(c, records, key, generation, cachedRecord[T]{tenant: tenant, notFound: err}, c.notFoundTTL)
+	}
+	return record, err
+}
+
+// tenantOwns reports whether a store scoped to tenant, if set, can see a record
+// owned by owner
+func tenantOwns(tenant id.ID, owner *id.ID) bool {
+	return tenant == "" || (owner != nil && *owner == tenant)
+}
+
+// cloneActor returns a copy of an actor sharing no maps with it
+func cloneActor(actor db.Actor) db.Actor {
+	actor.Metadata = actor.Metadata.Clone()
+	actor.TenantID = cloneFragmentID(actor.TenantID)
+	return actor
+}
+
+// cloneSession returns a copy of a session sharing no maps with it
+func cloneSession(session db.Session) db.Session {
+	session.Metadata = session.Metadata.Clone()
+	session.TenantID = cloneFragmentID(session.TenantID)
+	if session.ClosedAt != nil {
+		closedAt := *session.ClosedAt
+		session.ClosedAt = &closedAt
+	}
+	return session
+}
+
+// cloneFragment returns a copy of a fragment and its actor and session sharing
+// no maps or slices with them
+func cloneFragment(fragment db.Fragment) db.Fragment {
+	fragment.Metadata = fragment.Metadata.Clone()
+	fragment.Embedding = pgvector.NewVector(append([]float32(nil), fragment.Embedding.Slice()...))
+	fragment.TenantID = cloneFragmentID(fragment.TenantID)
+	fragment.ParentID = cloneFragmentID(fragment.ParentID)
+	if fragment.Actor != nil {
+		actor := cloneActor(*fragment.Actor)
+		fragment.Actor = &actor
+	}
+	if fragment.Session != nil {
+		session := cloneSession(*fragment.Session)
+		fragment.Session = &session
+	}
+	return fragment
+}
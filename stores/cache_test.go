@@ -0,0 +1,522 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+// cacheClock is a clock tests move forward by hand
+type cacheClock struct {
+	now time.Time
+}
+
+func (c *cacheClock) Now() time.Time {
+	return c.now
+}
+
+// newTestCache returns a cache without background cleanup on clock
+func newTestCache(t testing.TB, clock *cacheClock) *cache.Cache {
+	t.Helper()
+	c, err := cache.NewCache(cache.WithCleanupPeriod(0), cache.WithClock(clock.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCacheStatsHitRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats CacheStats
+		want  float64
+	}{
+		{name: "no lookups"},
+		{name: "misses", stats: CacheStats{Misses: 4}},
+		{name: "hits", stats: CacheStats{Hits: 3, Misses: 1}, want: 0.75},
+		{name: "not found hits", stats: CacheStats{Hits: 1, NotFoundHits: 2, Misses: 1}, want: 0.75},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.HitRate(); got != tt.want {
+				t.Errorf("HitRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActorStoreCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// lookups looks up actor through store, a caching store
+		lookups func(t *testing.T, store *ActorStore, actor *db.Actor)
+		want    CacheStats
+	}{
+		{
+			name: "read through",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				for i := 0; i < 3; i++ {
+					if _, err := store.GetByID(actor.ID); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			want: CacheStats{Hits: 2, Misses: 1},
+		},
+		{
+			name: "upsert invalidates",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				if _, err := store.GetByID(actor.ID); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Upsert(&db.Actor{ID: actor.ID, Name: "bob"}); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := store.GetByID(actor.ID); err != nil || got.Name != "bob" {
+					t.Fatalf("GetByID after upsert = %v, %v, want bob", got, err)
+				}
+			},
+			want: CacheStats{Misses: 2},
+		},
+		{
+			name: "write through a copy invalidates",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				if _, err := store.GetByID(actor.ID); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.WithContext(context.Background()).UpdateMetadata(actor.ID, db.Metadata{"locale": "fr"}); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := store.GetByID(actor.ID); err != nil || got.Metadata["locale"] != "fr" {
+					t.Fatalf("GetByID after patch = %v, %v, want locale fr", got, err)
+				}
+			},
+			want: CacheStats{Misses: 2},
+		},
+		{
+			name: "transactions bypass the cache",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				tx := store.WithTx(offlineDatabase())
+				for i := 0; i < 2; i++ {
+					if _, err := tx.GetByID(actor.ID); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "not found",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				missing := id.New()
+				for i := 0; i < 2; i++ {
+					if _, err := store.GetByID(missing); !errors.Is(err, ErrNotFound) {
+						t.Fatalf("error = %v, want not found", err)
+					}
+				}
+			},
+			want: CacheStats{NotFoundHits: 1, Misses: 1},
+		},
+		{
+			name: "created after not found",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				created := &db.Actor{ID: id.New(), Name: "carol"}
+				if _, err := store.GetByID(created.ID); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("error = %v, want not found", err)
+				}
+				if err := store.Create(created); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := store.GetByID(created.ID); err != nil {
+					t.Fatalf("created actor not found: %v", err)
+				}
+			},
+			want: CacheStats{Misses: 2},
+		},
+		{
+			name: "returns copies",
+			lookups: func(t *testing.T, store *ActorStore, actor *db.Actor) {
+				got, err := store.GetByID(actor.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got.Name = "mallory"
+				got.Metadata["plan"] = "free"
+				if got, err = store.GetByID(actor.ID); err != nil || got.Name != "alice" || got.Metadata["plan"] != "enterprise" {
+					t.Fatalf("GetByID after changing a copy = %v, %v", got, err)
+				}
+			},
+			want: CacheStats{Hits: 1, Misses: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := NewMemoryStores(context.Background())
+			actor := &db.Actor{ID: id.New(), Name: "alice", Metadata: db.Metadata{"plan": "enterprise"}}
+			if err := bundle.Actors().Create(actor); err != nil {
+				t.Fatal(err)
+			}
+			store := bundle.Actors().WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+
+			tt.lookups(t, store, actor)
+			if got := store.CacheStats(); got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreCacheNotFoundTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		after time.Duration
+		// cached is whether the second lookup is answered by the cache
+		cached bool
+	}{
+		{name: "default", after: DefaultNotFoundTTL - time.Second, cached: true},
+		{name: "default expired", after: DefaultNotFoundTTL + time.Second},
+		{name: "custom", ttl: time.Minute, after: 30 * time.Second, cached: true},
+		{name: "custom expired", ttl: time.Second, after: 2 * time.Second},
+		{name: "disabled", ttl: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &cacheClock{now: time.Now()}
+			store := NewMemoryStores(context.Background()).Sessions().WithCache(newTestCache(t, clock), tt.ttl)
+			missing := id.New()
+			for i := 0; i < 2; i++ {
+				if _, err := store.GetByID(missing); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("error = %v, want not found", err)
+				}
+				clock.now = clock.now.Add(tt.after)
+			}
+
+			want := CacheStats{Misses: 2}
+			if tt.cached {
+				want = CacheStats{NotFoundHits: 1, Misses: 1}
+			}
+			if got := store.CacheStats(); got != want {
+				t.Errorf("stats = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionStoreCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(store *SessionStore, sessionID id.ID) error
+		// check reports what is wrong with the session read after the write
+		check func(session *db.Session) string
+	}{
+		{
+			name: "upsert",
+			write: func(store *SessionStore, sessionID id.ID) error {
+				return store.Upsert(&db.Session{ID: sessionID, Title: "upserted", Metadata: db.Metadata{}, LastActivityAt: time.Now()})
+			},
+			check: func(session *db.Session) string {
+				return expect("title", session.Title, "upserted")
+			},
+		},
+		{
+			name: "title",
+			write: func(store *SessionStore, sessionID id.ID) error {
+				return store.UpdateTitle(sessionID, "renamed")
+			},
+			check: func(session *db.Session) string {
+				return expect("title", session.Title, "renamed")
+			},
+		},
+		{
+			name: "metadata",
+			write: func(store *SessionStore, sessionID id.ID) error {
+				return store.MergeMetadata(sessionID, db.Metadata{"platform": "discord"})
+			},
+			check: func(session *db.Session) string {
+				return expect("platform", fmt.Sprint(session.Metadata["platform"]), "discord")
+			},
+		},
+		{
+			name: "close",
+			write: func(store *SessionStore, sessionID id.ID) error {
+				return store.Close(sessionID)
+			},
+			check: func(session *db.Session) string {
+				return expect("closed", session.IsClosed(), true)
+			},
+		},
+		{
+			name: "touch",
+			write: func(store *SessionStore, sessionID id.ID) error {
+				time.Sleep(time.Millisecond)
+				return store.Touch(sessionID)
+			},
+			check: func(session *db.Session) string {
+				return expect("touched", session.LastActivityAt.After(session.CreatedAt), true)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStores(context.Background()).Sessions().WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+			session := &db.Session{ID: id.New(), Metadata: db.Metadata{}}
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetByID(session.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.write(store, session.ID); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.GetByID(session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problem := tt.check(got); problem != "" {
+				t.Error(problem)
+			}
+			if stats := store.CacheStats(); stats.Hits != 0 {
+				t.Errorf("stats = %+v, want the session read again after the write", stats)
+			}
+		})
+	}
+}
+
+// expect returns a description of a value differing from the one wanted, or
+// an empty string
+func expect(name string, got, want interface{}) string {
+	if got != want {
+		return fmt.Sprintf("%s = %v, want %v", name, got, want)
+	}
+	return ""
+}
+
+func TestFragmentStoreCacheInvalidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(store *FragmentStore, fragment *db.Fragment) error
+		// want is the content read after the write, empty if deleted
+		want string
+	}{
+		{
+			name: "upsert",
+			write: func(store *FragmentStore, fragment *db.Fragment) error {
+				fragment.Content = "upserted"
+				return store.Upsert(fragment)
+			},
+			want: "upserted",
+		},
+		{
+			name: "batch",
+			write: func(store *FragmentStore, fragment *db.Fragment) error {
+				fragment.Content = "batched"
+				return store.UpsertBatch([]*db.Fragment{fragment}, 10)
+			},
+			want: "batched",
+		},
+		{
+			name: "delete",
+			write: func(store *FragmentStore, fragment *db.Fragment) error {
+				return store.Delete(fragment.ID)
+			},
+		},
+		{
+			name: "session purge",
+			write: func(store *FragmentStore, fragment *db.Fragment) error {
+				_, err := store.DeleteBySession(fragment.SessionID, PurgeOptions{})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := NewMemoryStores(context.Background())
+			actor, session := batchConversation(t, bundle)
+			store := bundle.Fragments(db.FragmentTableInteraction).WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+			fragment := batchFragment(actor, session, "original")
+			if err := store.Create(fragment); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetByID(fragment.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.write(store, fragment); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.GetByID(fragment.ID)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("GetByID after the write = %v, %v, want not found", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Content != tt.want {
+				t.Errorf("content = %q, want %q", got.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreCacheTenants(t *testing.T) {
+	tenantA, tenantB := id.New(), id.New()
+	cached := NewMemoryStores(context.Background()).Actors().WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+	actor := &db.Actor{ID: id.New(), Name: "alice"}
+	if err := cached.WithTenant(tenantA).Create(actor); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lookups share the cache, in order
+	tests := []struct {
+		name  string
+		store *ActorStore
+		found bool
+	}{
+		{name: "owner", store: cached.WithTenant(tenantA), found: true},
+		{name: "other tenant after the owner", store: cached.WithTenant(tenantB)},
+		{name: "other tenant again", store: cached.WithTenant(tenantB)},
+		{name: "owner after the other tenant", store: cached.WithTenant(tenantA), found: true},
+		{name: "unscoped", store: cached, found: true},
+		{name: "other tenant after unscoped", store: cached.WithTenant(tenantB)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.store.GetByID(actor.ID)
+			if tt.found && err != nil {
+				t.Errorf("lookup failed: %v", err)
+			}
+			if !tt.found && !errors.Is(err, ErrNotFound) {
+				t.Errorf("lookup returned %v, want not found", err)
+			}
+		})
+	}
+}
+
+// TestStoreCacheConcurrentUpserts checks that lookups racing with upserts
+// never leave a replaced actor in the cache
+func TestStoreCacheConcurrentUpserts(t *testing.T) {
+	const writers, readers, upserts = 4, 8, 100
+
+	bundle := NewMemoryStores(context.Background())
+	uncached := bundle.Actors()
+	cached := uncached.WithCache(newTestCache(t, &cacheClock{now: time.Now()}), 0)
+	actor := &db.Actor{ID: id.New(), Name: "initial"}
+	if err := cached.Create(actor); err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 5; round++ {
+		var wg sync.WaitGroup
+		done := make(chan struct{})
+		var readersWG sync.WaitGroup
+		for r := 0; r < readers; r++ {
+			readersWG.Add(1)
+			go func() {
+				defer readersWG.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if _, err := cached.GetByID(actor.ID); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < upserts; i++ {
+					name := fmt.Sprintf("round %d writer %d upsert %d", round, w, i)
+					if err := cached.Upsert(&db.Actor{ID: actor.ID, Name: name}); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(done)
+		readersWG.Wait()
+
+		want, err := uncached.GetByID(actor.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := cached.GetByID(actor.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != want.Name {
+			t.Fatalf("round %d: cached actor = %q, want the last upsert %q", round, got.Name, want.Name)
+		}
+	}
+	if stats := cached.CacheStats(); stats.Hits == 0 {
+		t.Errorf("stats = %+v, want lookups answered by the cache", stats)
+	}
+}
+
+// TestCachedLookupRacingWrite checks that a lookup doesn't cache the record it
+// read if a write invalidated it meanwhile
+func TestCachedLookupRacingWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		// write runs while the lookup reads the record
+		write func(c *storeCache, recordID id.ID)
+		// cached is whether the record read is cached
+		cached bool
+	}{
+		{name: "no write", write: func(c *storeCache, recordID id.ID) {}, cached: true},
+		{name: "record written", write: func(c *storeCache, recordID id.ID) { c.invalidate(recordID) }},
+		// Any write since the lookup started skips caching, to be safe
+		{name: "other record written", write: func(c *storeCache, recordID id.ID) { c.invalidate(id.New()) }},
+		{name: "table purged", write: func(c *storeCache, recordID id.ID) { c.clear() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newStoreCache(newTestCache(t, &cacheClock{now: time.Now()}), "actors", 0)
+			actor := db.Actor{ID: id.New(), Name: "stale"}
+			lookup := func(load func() (*db.Actor, error)) {
+				if _, err := cachedLookup(c, actor.ID, "", func(a db.Actor) *id.ID { return a.TenantID }, load, cloneActor); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lookup(func() (*db.Actor, error) {
+				tt.write(c, actor.ID)
+				return &actor, nil
+			})
+			loaded := false
+			lookup(func() (*db.Actor, error) {
+				loaded = true
+				return &actor, nil
+			})
+			if loaded == tt.cached {
+				t.Errorf("second lookup read the record = %v, want %v", loaded, !tt.cached)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package stores
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is matched by the errors of lookups and updates of records that
+// don't exist, so callers can tell them from database failures:
+//
+//	actor, err := actorStore.GetByID(actorID)
+//	if errors.Is(err, stores.ErrNotFound) {
+//		// create the actor
+//	}
+var ErrNotFound = errors.New("not found")
+
+// lookupError wraps the error of a lookup of the named record, turning gorm's
+// missing record error into ErrNotFound
+func lookupError(err error, record string, recordID interface{}) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%s %v: %w", record, recordID, ErrNotFound)
+	}
+	return fmt.Errorf("failed to get %s %v: %w", record, recordID, err)
+}
+
+// ErrOtherTenant is matched by the errors of writes of a scoped store to
+// records of another tenant, see WithTenant
+var ErrOtherTenant = errors.New("belongs to another tenant")
@@ -0,0 +1,52 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// forEachStores runs test on memory stores, and on stores of the database
+// named by THOR_TEST_DATABASE_URL if it is set
+func forEachStores(t *testing.T, test func(t *testing.T, bundle *Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStores(context.Background()))
+	})
+	t.Run("live", func(t *testing.T) {
+		tx := openTestDatabase(t)
+		if tx == nil {
+			t.Skipf("%s is not set", testDatabaseURLEnv)
+		}
+		test(t, NewStores(context.Background(), tx))
+	})
+}
+
+func TestLookupError(t *testing.T) {
+	errBroken := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		want     string
+		notFound bool
+	}{
+		{name: "missing", err: gorm.ErrRecordNotFound, want: "actor abc: not found", notFound: true},
+		{name: "database failure", err: errBroken, want: "failed to get actor abc: connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := lookupError(tt.err, "actor", "abc")
+			if err.Error() != tt.want {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+			if errors.Is(err, ErrNotFound) != tt.notFound {
+				t.Errorf("errors.Is(%v, ErrNotFound) = %v, want %v", err, !tt.notFound, tt.notFound)
+			}
+			if !tt.notFound && !errors.Is(err, tt.err) {
+				t.Errorf("error %v does not wrap %v", err, tt.err)
+			}
+		})
+	}
+}
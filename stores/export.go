@@ -0,0 +1,307 @@
+package stores
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultExportBatchSize is the number of fragments Export reads per query
+// when ExportOptions sets no batch size
+const DefaultExportBatchSize = 1000
+
+// ExportOptions configures Export
+type ExportOptions struct {
+	// Include each fragment's embedding, which makes up most of the output
+	IncludeEmbeddings bool
+	// Compress the output with gzip
+	Gzip bool
+	// Fragments read per query, DefaultExportBatchSize if zero
+	BatchSize int
+	// Called with the number of fragments written so far after every
+	// ProgressEvery fragments, and with the total when the export is done
+	OnProgress    func(exported int64)
+	ProgressEvery int64
+}
+
+// exportedFragment is a line of a fragment export
+type exportedFragment struct {
+	ID        id.ID       `json:"id"`
+	ActorID   id.ID       `json:"actor_id"`
+	SessionID id.ID       `json:"session_id"`
+	TenantID  *id.ID      `json:"tenant_id,omitempty"`
+	ParentID  *id.ID      `json:"parent_id,omitempty"`
+	Content   string      `json:"content"`
+	Metadata  db.Metadata `json:"metadata"`
+	Embedding []float32   `json:"embedding,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty"`
+}
+
+// Export writes the fragments matching filter to w as JSONL, one fragment per
+// line in the order List returns them, e.g. for a warehouse dump:
+//
+//	err := store.WithContext(ctx).Export(stores.FragmentFilter{
+//		After: since,
+//	}, w, stores.ExportOptions{Gzip: true})
+//
+// Fragments are read a batch at a time continuing from the last one, so memory
+// stays flat however many are exported. The filter's Limit is ignored; the
+// export starts after its Cursor, if set. Export returns the number of
+// fragments written, which is accurate even when it fails part way.
+func (s *FragmentStore) Export(filter FragmentFilter, w io.Writer, opts ExportOptions) (int64, error) {
+	filter.Limit = opts.BatchSize
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultExportBatchSize
+	}
+	filter.PreloadActor = false
+
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	var exported int64
+	for {
+		page, err := s.List(filter)
+		if err != nil {
+			return exported, fmt.Errorf("failed to export fragments: %w", err)
+		}
+		for _, fragment := range page.Fragments {
+			if err := encoder.Encode(exportFragment(fragment, opts.IncludeEmbeddings)); err != nil {
+				return exported, fmt.Errorf("failed to write fragment %s: %w", fragment.ID, err)
+			}
+			exported++
+			if opts.OnProgress != nil && opts.ProgressEvery > 0 && exported%opts.ProgressEvery == 0 {
+				opts.OnProgress(exported)
+			}
+		}
+		if page.Next == nil {
+			break
+		}
+		filter.Cursor = page.Next
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return exported, fmt.Errorf("failed to export fragments: %w", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return exported, fmt.Errorf("failed to export fragments: %w", err)
+		}
+	}
+	if opts.OnProgress != nil && (opts.ProgressEvery <= 0 || exported%opts.ProgressEvery != 0) {
+		opts.OnProgress(exported)
+	}
+	return exported, nil
+}
+
+// exportFragment returns the line of a fragment in an export
+func exportFragment(fragment db.Fragment, includeEmbedding bool) exportedFragment {
+	line := exportedFragment{
+		ID:        fragment.ID,
+		ActorID:   fragment.ActorID,
+		SessionID: fragment.SessionID,
+		TenantID:  fragment.TenantID,
+		ParentID:  fragment.ParentID,
+		Content:   fragment.Content,
+		Metadata:  fragment.Metadata,
+		CreatedAt: fragment.CreatedAt,
+		UpdatedAt: fragment.UpdatedAt,
+	}
+	if line.Metadata == nil {
+		line.Metadata = db.Metadata{}
+	}
+	if includeEmbedding {
+		line.Embedding = fragment.Embedding.Slice()
+	}
+	if fragment.DeletedAt.Valid {
+		deletedAt := fragment.DeletedAt.Time
+		line.DeletedAt = &deletedAt
+	}
+	return line
+}
+
+// Import reads fragments written by Export, gzipped or not, and inserts them
+// or replaces the fragments with their IDs, timestamps included, e.g. to copy
+// an environment. Their actors and sessions must already exist. Fragments
+// exported without embeddings keep the embeddings of the fragments they
+// replace.
+//
+// Fragments are written DefaultUpsertChunkSize at a time, each chunk in its
+// own transaction, or in a savepoint of the store's transaction when bound to
+// one with WithTx. Import returns the number of fragments written, which is
+// accurate even when it fails part way.
+func (s *FragmentStore) Import(r io.Reader) (int64, error) {
+	if s.dryRun {
+		return 0, nil
+	}
+	buffered := bufio.NewReader(r)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, fmt.Errorf("failed to import fragments: %w", err)
+		}
+		defer gz.Close()
+		buffered = bufio.NewReader(gz)
+	}
+	decoder := json.NewDecoder(buffered)
+
+	var (
+		imported int64
+		line     int64
+		chunk    []*db.Fragment
+	)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := s.importChunk(chunk); err != nil {
+			return fmt.Errorf("failed to import fragments before line %d: %w", line+1, err)
+		}
+		imported += int64(len(chunk))
+		chunk = chunk[:0]
+		return nil
+	}
+
+	seen := make(map[id.ID]bool, DefaultUpsertChunkSize)
+	for {
+		var record exportedFragment
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return imported, fmt.Errorf("failed to read fragment at line %d: %w", line, err)
+		}
+
+		fragment := importedFragment(record)
+		// A chunk can't update a row twice, so a repeated ID starts a new one
+		if seen[fragment.ID] {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+			seen = make(map[id.ID]bool, DefaultUpsertChunkSize)
+		}
+		if err := validateBatchFragment(fragment, seen); err != nil {
+			return imported, fmt.Errorf("invalid fragment at line %d: %w", line, err)
+		}
+		if err := stampTenant("fragment", fragment.ID, &fragment.TenantID, s.tenant); err != nil {
+			return imported, fmt.Errorf("invalid fragment at line %d: %w", line, err)
+		}
+		seen[fragment.ID] = true
+		chunk = append(chunk, fragment)
+
+		if len(chunk) == DefaultUpsertChunkSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+			seen = make(map[id.ID]bool, DefaultUpsertChunkSize)
+		}
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+	return imported, nil
+}
+
+// importedFragment returns the fragment of a line of an export
+func importedFragment(record exportedFragment) *db.Fragment {
+	fragment := &db.Fragment{
+		ID:        record.ID,
+		ActorID:   record.ActorID,
+		SessionID: record.SessionID,
+		TenantID:  record.TenantID,
+		ParentID:  record.ParentID,
+		Content:   record.Content,
+		Metadata:  record.Metadata,
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+	if fragment.Metadata == nil {
+		fragment.Metadata = db.Metadata{}
+	}
+	if len(record.Embedding) > 0 {
+		fragment.Embedding = pgvector.NewVector(record.Embedding)
+	}
+	if record.DeletedAt != nil {
+		fragment.DeletedAt = gorm.DeletedAt{Time: *record.DeletedAt, Valid: true}
+	}
+	return fragment
+}
+
+// importChunk writes imported fragments with distinct IDs in one transaction,
+// replacing every column of the fragments they conflict with, timestamps
+// included, unlike UpsertBatch
+func (s *FragmentStore) importChunk(chunk []*db.Fragment) error {
+	defer s.invalidate(fragmentPointerIDs(chunk)...)
+	if s.mem != nil {
+		for _, fragment := range chunk {
+			if err := s.mem.restoreFragment(s.table, fragment); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Rows without an embedding omit the column, as Upsert does, so they are
+	// written in a statement of their own
+	var embedded, plain []*db.Fragment
+	for _, fragment := range chunk {
+		if len(fragment.Embedding.Slice()) == 0 {
+			plain = append(plain, fragment)
+		} else {
+			embedded = append(embedded, fragment)
+		}
+	}
+	columns := []string{"actor_id", "session_id", "tenant_id", "parent_id", "content", "metadata", "created_at", "updated_at", "deleted_at"}
+
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for _, rows := range [][]*db.Fragment{embedded, plain} {
+			if len(rows) == 0 {
+				continue
+			}
+			updates := columns
+			if len(rows[0].Embedding.Slice()) > 0 {
+				updates = append(updates[:len(updates):len(updates)], "embedding")
+			}
+			result := s.WithTx(tx).write(rows[0]).Clauses(conflictScope(clause.OnConflict{
+				Columns:   s.conflictColumns(),
+				DoUpdates: clause.AssignmentColumns(updates),
+			}, string(s.table), s.tenant)).Create(&rows)
+			if result.Error != nil {
+				return result.Error
+			}
+			// Rows of other tenants are left as they are, failing the chunk
+			if s.tenant != "" && result.RowsAffected < int64(len(rows)) {
+				return fmt.Errorf("%d of %d fragments: %w", int64(len(rows))-result.RowsAffected, len(rows), ErrOtherTenant)
+			}
+		}
+		return nil
+	})
+}
+
+// fragmentPointerIDs returns the IDs of fragments
+func fragmentPointerIDs(fragments []*db.Fragment) []id.ID {
+	ids := make([]id.ID, len(fragments))
+	for i, fragment := range fragments {
+		ids[i] = fragment.ID
+	}
+	return ids
+}
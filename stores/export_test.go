@@ -0,0 +1,401 @@
+package stores
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// exportFixtureSize is the number of fragments of the round trip tests, more
+// than an import chunk and an export batch
+const exportFixtureSize = 2500
+
+// seedExport restores synthetic fragments of a conversation with varied
+// metadata, parents, deletions, embeddings and timestamps with microseconds in
+// a memory store, and returns them by ID
+func seedExport(t *testing.T, bundle *Stores, actor *db.Actor, session *db.Session) map[id.ID]*db.Fragment {
+	t.Helper()
+	fragments := make(map[id.ID]*db.Fragment, exportFixtureSize)
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var previous *db.Fragment
+	for i := 0; i < exportFixtureSize; i++ {
+		fragment := batchFragment(actor, session, fmt.Sprintf("fragment %d: \"quoted\"\n\ttext é", i))
+		// Embeddings make up most of an export, so only some fragments have one
+		fragment.Embedding = pgvector.Vector{}
+		if i%10 == 0 {
+			fragment.Embedding = embedding(float32(i), 0.5)
+		}
+		fragment.Metadata = db.Metadata{
+			"index":      float64(i),
+			"confidence": float64(i) / 7,
+			"type":       []string{"insight", "note"}[i%2],
+			"source":     map[string]interface{}{"platform": "discord", "thread": fmt.Sprint(i / 10)},
+			"tags":       []interface{}{"a", float64(i % 3), nil, true},
+			"empty":      map[string]interface{}{},
+		}
+		if i%3 == 0 {
+			fragment.Metadata = db.Metadata{}
+		}
+		if i%5 == 0 && previous != nil {
+			fragment.ParentID = &previous.ID
+		}
+		fragment.CreatedAt = start.Add(time.Duration(i)*time.Second + time.Duration(i%1000)*time.Microsecond)
+		fragment.UpdatedAt = fragment.CreatedAt.Add(time.Duration(i%4) * time.Hour)
+		if i%11 == 0 {
+			fragment.DeletedAt = gorm.DeletedAt{Time: fragment.UpdatedAt.Add(time.Minute), Valid: true}
+		}
+		if err := bundle.mem.restoreFragment(db.FragmentTableInteraction, fragment); err != nil {
+			t.Fatal(err)
+		}
+		fragments[fragment.ID] = fragment
+		previous = fragment
+	}
+	return fragments
+}
+
+// decodeExport returns the lines of an export
+func decodeExport(t *testing.T, data []byte, gzipped bool) []exportedFragment {
+	t.Helper()
+	var r interface{ Read([]byte) (int, error) } = bytes.NewReader(data)
+	if gzipped {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("export is not gzipped: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var lines []exportedFragment
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var line exportedFragment
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// checkFragment reports the differences between an exported or imported
+// fragment and the seeded one with its ID
+func checkFragment(t *testing.T, fragments map[id.ID]*db.Fragment, got exportedFragment, embeddings bool) {
+	t.Helper()
+	want, ok := fragments[got.ID]
+	if !ok {
+		t.Fatalf("fragment %s was not seeded", got.ID)
+	}
+	var diffs []string
+	if got.Content != want.Content || got.ActorID != want.ActorID || got.SessionID != want.SessionID {
+		diffs = append(diffs, fmt.Sprintf("content %q of %s in %s", got.Content, got.ActorID, got.SessionID))
+	}
+	if parentOf(got.ParentID) != parentOf(want.ParentID) {
+		diffs = append(diffs, fmt.Sprintf("parent %v, want %v", got.ParentID, want.ParentID))
+	}
+	if fmt.Sprint(got.Metadata) != fmt.Sprint(want.Metadata) {
+		diffs = append(diffs, fmt.Sprintf("metadata %v, want %v", got.Metadata, want.Metadata))
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		diffs = append(diffs, fmt.Sprintf("timestamps %s and %s, want %s and %s", got.CreatedAt, got.UpdatedAt, want.CreatedAt, want.UpdatedAt))
+	}
+	if (got.DeletedAt != nil) != want.DeletedAt.Valid || (got.DeletedAt != nil && !got.DeletedAt.Equal(want.DeletedAt.Time)) {
+		diffs = append(diffs, fmt.Sprintf("deleted at %v, want %v", got.DeletedAt, want.DeletedAt))
+	}
+	if embeddings && fmt.Sprint(got.Embedding) != fmt.Sprint(want.Embedding.Slice()) {
+		diffs = append(diffs, "embedding differs")
+	}
+	if !embeddings && got.Embedding != nil {
+		diffs = append(diffs, "embedding exported")
+	}
+	if len(diffs) > 0 {
+		t.Errorf("fragment %s: %s", got.ID, strings.Join(diffs, "; "))
+	}
+}
+
+// parentOf returns the ID of an optional parent, empty if there is none
+func parentOf(parentID *id.ID) id.ID {
+	if parentID == nil {
+		return ""
+	}
+	return *parentID
+}
+
+func TestFragmentExport(t *testing.T) {
+	bundle := NewMemoryStores(context.Background())
+	actor, session := batchConversation(t, bundle)
+	fragments := seedExport(t, bundle, actor, session)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	deleted := 0
+	for _, fragment := range fragments {
+		if fragment.DeletedAt.Valid {
+			deleted++
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts ExportOptions
+		// includeDeleted exports the soft-deleted fragments too
+		includeDeleted bool
+		// progress are the counts OnProgress is expected to be called with
+		progress []int64
+	}{
+		{name: "plain"},
+		{name: "gzip", opts: ExportOptions{Gzip: true}},
+		{name: "embeddings", opts: ExportOptions{IncludeEmbeddings: true, BatchSize: 300}},
+		{name: "deleted", opts: ExportOptions{BatchSize: 70}, includeDeleted: true},
+		{
+			name:     "progress",
+			opts:     ExportOptions{BatchSize: 700, ProgressEvery: 1000},
+			progress: []int64{1000, 2000, int64(exportFixtureSize - deleted)},
+		},
+		{
+			name:           "progress on the total",
+			opts:           ExportOptions{ProgressEvery: exportFixtureSize},
+			includeDeleted: true,
+			progress:       []int64{exportFixtureSize},
+		},
+		{name: "progress without interval", opts: ExportOptions{ProgressEvery: -1}, progress: []int64{int64(exportFixtureSize - deleted)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var progress []int64
+			if tt.progress != nil {
+				tt.opts.OnProgress = func(exported int64) { progress = append(progress, exported) }
+			}
+			var out bytes.Buffer
+			n, err := store.Export(FragmentFilter{IncludeDeleted: tt.includeDeleted}, &out, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := exportFixtureSize
+			if !tt.includeDeleted {
+				want -= deleted
+			}
+			lines := decodeExport(t, out.Bytes(), tt.opts.Gzip)
+			if n != int64(want) || len(lines) != want {
+				t.Fatalf("exported %d fragments in %d lines, want %d", n, len(lines), want)
+			}
+			for i, line := range lines {
+				if i > 0 && line.CreatedAt.Before(lines[i-1].CreatedAt) {
+					t.Fatalf("line %d created before line %d", i+1, i)
+				}
+				checkFragment(t, fragments, line, tt.opts.IncludeEmbeddings)
+			}
+			if fmt.Sprint(progress) != fmt.Sprint(tt.progress) {
+				t.Errorf("progress = %v, want %v", progress, tt.progress)
+			}
+		})
+	}
+}
+
+func TestFragmentExportFilter(t *testing.T) {
+	bundle := NewMemoryStores(context.Background())
+	actor, session := batchConversation(t, bundle)
+	fragments := seedExport(t, bundle, actor, session)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	var all bytes.Buffer
+	if _, err := store.Export(FragmentFilter{}, &all, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := decodeExport(t, all.Bytes(), false)
+	middle := lines[len(lines)/2]
+
+	tests := []struct {
+		name   string
+		filter FragmentFilter
+		want   int
+	}{
+		// The limit is the page size of a listing, not of the export
+		{name: "limit ignored", filter: FragmentFilter{Limit: 10}, want: len(lines)},
+		{name: "after", filter: FragmentFilter{After: middle.CreatedAt}, want: len(lines) - len(lines)/2 - 1},
+		{name: "cursor", filter: FragmentFilter{Cursor: &FragmentCursor{CreatedAt: middle.CreatedAt, ID: middle.ID}}, want: len(lines) - len(lines)/2 - 1},
+		{name: "metadata", filter: FragmentFilter{MetadataWhere: []MetadataCondition{Meta("type").Equals("note")}}},
+		{name: "other session", filter: FragmentFilter{SessionID: id.New()}},
+	}
+	for _, fragment := range fragments {
+		if fragment.Metadata["type"] == "note" && !fragment.DeletedAt.Valid {
+			tests[3].want++
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := store.Export(tt.filter, &out, ExportOptions{BatchSize: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(decodeExport(t, out.Bytes(), false)); n != int64(tt.want) || got != tt.want {
+				t.Errorf("exported %d fragments in %d lines, want %d", n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFragmentExportImportRoundTrip(t *testing.T) {
+	sourceStores := NewMemoryStores(context.Background())
+	actor, session := batchConversation(t, sourceStores)
+	fragments := seedExport(t, sourceStores, actor, session)
+	source := sourceStores.Fragments(db.FragmentTableInteraction)
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		// The copied environment has the same actor and session
+		if err := bundle.Actors().Create(&db.Actor{ID: actor.ID, Name: actor.Name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.Sessions().Create(&db.Session{ID: session.ID, Metadata: db.Metadata{}, LastActivityAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		target := bundle.Fragments(db.FragmentTableInteraction)
+
+		tests := []struct {
+			name string
+			opts ExportOptions
+		}{
+			{name: "plain", opts: ExportOptions{IncludeEmbeddings: true}},
+			// Importing again replaces the fragments with the same ones, keeping
+			// the embeddings left out of the export
+			{name: "gzip again", opts: ExportOptions{Gzip: true}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var dump bytes.Buffer
+				exported, err := source.Export(FragmentFilter{IncludeDeleted: true}, &dump, tt.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				imported, err := target.Import(&dump)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if imported != exported || imported != exportFixtureSize {
+					t.Fatalf("imported %d of %d fragments, want %d", imported, exported, exportFixtureSize)
+				}
+
+				var copied bytes.Buffer
+				if _, err := target.Export(FragmentFilter{IncludeDeleted: true}, &copied, ExportOptions{IncludeEmbeddings: true}); err != nil {
+					t.Fatal(err)
+				}
+				lines := decodeExport(t, copied.Bytes(), false)
+				if len(lines) != exportFixtureSize {
+					t.Fatalf("%d fragments after the import, want %d", len(lines), exportFixtureSize)
+				}
+				for _, line := range lines {
+					checkFragment(t, fragments, line, true)
+				}
+			})
+		}
+	})
+}
+
+func TestFragmentImportUpsert(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		actor, session := batchConversation(t, bundle)
+		store := bundle.Fragments(db.FragmentTableInteraction)
+		existing := batchFragment(actor, session, "before")
+		existing.Embedding = embedding(1, 2)
+		existing.Metadata = db.Metadata{"old": true}
+		if err := store.Create(existing); err != nil {
+			t.Fatal(err)
+		}
+
+		createdAt := time.Date(2025, 1, 2, 3, 4, 5, 6000, time.UTC)
+		line := exportFragment(*existing, false)
+		line.Content, line.Metadata = "after", db.Metadata{"new": "yes"}
+		line.CreatedAt, line.UpdatedAt = createdAt, createdAt.Add(time.Hour)
+		added := exportFragment(*batchFragment(actor, session, "added"), true)
+		added.CreatedAt, added.UpdatedAt = createdAt, createdAt
+
+		var dump bytes.Buffer
+		encoder := json.NewEncoder(&dump)
+		for _, record := range []exportedFragment{line, added} {
+			if err := encoder.Encode(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n, err := store.Import(&dump); err != nil || n != 2 {
+			t.Fatalf("Import = %d, %v, want 2 fragments", n, err)
+		}
+
+		got, err := store.GetByID(existing.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			name      string
+			got, want interface{}
+		}{
+			{name: "content", got: got.Content, want: "after"},
+			{name: "metadata", got: fmt.Sprint(got.Metadata), want: "map[new:yes]"},
+			{name: "created at", got: got.CreatedAt.UTC(), want: createdAt},
+			{name: "updated at", got: got.UpdatedAt.UTC(), want: createdAt.Add(time.Hour)},
+			// The line has no embedding, so the stored one is kept
+			{name: "embedding", got: fmt.Sprint(got.Embedding.Slice()[:3]), want: "[1 2 0]"},
+		}
+		for _, tt := range tests {
+			if tt.got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+			}
+		}
+		if _, err := store.GetByID(added.ID); err != nil {
+			t.Errorf("added fragment: %v", err)
+		}
+	})
+}
+
+func TestFragmentImportInvalid(t *testing.T) {
+	actor := &db.Actor{ID: id.New()}
+	session := &db.Session{ID: id.New()}
+	valid := func() string {
+		data, _ := json.Marshal(exportFragment(*batchFragment(actor, session, "ok"), false))
+		return string(data)
+	}
+	tests := []struct {
+		name  string
+		input string
+		// imported is the number of fragments imported before the failure
+		imported int64
+		want     string
+	}{
+		{name: "empty", input: ""},
+		{name: "not json", input: valid() + "\nnot json\n", want: "failed to read fragment at line 2"},
+		{name: "no id", input: valid() + "\n" + `{"content":"x"}` + "\n", want: "invalid fragment at line 2: fragment has no ID"},
+		{
+			name:  "bad embedding",
+			input: fmt.Sprintf(`{"id":%q,"embedding":[1,2]}`, id.New()),
+			want:  "has an embedding of 2 dimensions, want 1536",
+		},
+		{name: "gzip header only", input: "\x1f\x8b", want: "failed to import fragments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := NewMemoryStores(context.Background())
+			n, err := bundle.Fragments(db.FragmentTableInteraction).Import(strings.NewReader(tt.input))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if n != tt.imported {
+				t.Errorf("imported %d fragments, want %d", n, tt.imported)
+			}
+		})
+	}
+}
@@ -0,0 +1,941 @@
+package stores
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FragmentStore provides persistence for the fragments of one fragment table
+type FragmentStore struct {
+	db    *gorm.DB
+	ctx   context.Context
+	table db.FragmentTable
+
+	// dryRun turns all writes into no-ops
+	dryRun bool
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the fragments of a tenant, see WithTenant
+	tenant id.ID
+	// cache caches the records looked up by ID, see WithCache
+	cache *storeCache
+}
+
+// NewFragmentStore creates a new FragmentStore backed by the given database,
+// reading and writing the given fragment table, scoped to the database's
+// tenant if it is marked with one
+func NewFragmentStore(ctx context.Context, db *gorm.DB, table db.FragmentTable) *FragmentStore {
+	return &FragmentStore{
+		db:     db,
+		ctx:    ctx,
+		table:  table,
+		tenant: tenantOf(db),
+	}
+}
+
+// Table returns the fragment table the store reads and writes
+func (s *FragmentStore) Table() db.FragmentTable {
+	return s.table
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *FragmentStore) WithTx(tx *gorm.DB) *FragmentStore {
+	if tx == nil {
+		return s
+	}
+	return &FragmentStore{
+		db:     tx,
+		ctx:    s.ctx,
+		table:  s.table,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache.inTx(),
+	}
+}
+
+// DryRun returns a copy of the store whose writes are no-ops, while reads still
+// hit the database
+func (s *FragmentStore) DryRun() *FragmentStore {
+	return &FragmentStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		table:  s.table,
+		dryRun: true,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *FragmentStore) WithContext(ctx context.Context) *FragmentStore {
+	return &FragmentStore{
+		db:     s.db,
+		ctx:    ctx,
+		table:  s.table,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the fragments of a tenant,
+// see the WithTenant function
+func (s *FragmentStore) WithTenant(tenantID id.ID) *FragmentStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &FragmentStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		table:  s.table,
+		dryRun: s.dryRun,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+		cache:  s.cache,
+	}
+}
+
+// WithCache returns a copy of the store that caches the fragments GetByID
+// looks up in c, dropping them when they are written through the store or its
+// copies. Missing fragments are cached for notFoundTTL, DefaultNotFoundTTL if
+// zero, or not at all if negative. The actors and sessions loaded with cached
+// fragments are kept as they were when the fragments were cached.
+//
+// Copies bound to a transaction with WithTx don't use the cache for lookups.
+// Writes made to the database bypassing the store's copies aren't seen until
+// the cache's TTL expires.
+func (s *FragmentStore) WithCache(c *cache.Cache, notFoundTTL time.Duration) *FragmentStore {
+	return &FragmentStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		table:  s.table,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  newStoreCache(c, string(s.table), notFoundTTL),
+	}
+}
+
+// CacheStats returns the lookups of the store's cache, shared with its copies,
+// see WithCache
+func (s *FragmentStore) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// Create inserts a new fragment
+func (s *FragmentStore) Create(fragment *db.Fragment) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(fragment.ID)
+	if err := stampTenant("fragment", fragment.ID, &fragment.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to create fragment: %w", err)
+	}
+	if s.mem != nil {
+		if _, err := s.mem.writeFragment(s.table, fragment, false, false); err != nil {
+			return fmt.Errorf("failed to create fragment: %w", err)
+		}
+		return nil
+	}
+	if err := s.write(fragment).Create(fragment).Error; err != nil {
+		return fmt.Errorf("failed to create fragment: %w", err)
+	}
+	return nil
+}
+
+// Upsert inserts a fragment or updates it if the ID already exists. In
+// partitioned tables fragments are matched by ID and creation time, see
+// db.PartitionFragmentTable.
+func (s *FragmentStore) Upsert(fragment *db.Fragment) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(fragment.ID)
+	if err := stampTenant("fragment", fragment.ID, &fragment.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to upsert fragment: %w", err)
+	}
+	if s.mem != nil {
+		if _, err := s.mem.writeFragment(s.table, fragment, true, false); err != nil {
+			return fmt.Errorf("failed to upsert fragment: %w", err)
+		}
+		return nil
+	}
+	result := s.write(fragment).Clauses(conflictScope(clause.OnConflict{
+		Columns:   s.conflictColumns(),
+		UpdateAll: true,
+	}, string(s.table), s.tenant)).Create(fragment)
+	if result.Error != nil {
+		return fmt.Errorf("failed to upsert fragment: %w", result.Error)
+	}
+	if s.tenant != "" && result.RowsAffected == 0 {
+		return fmt.Errorf("failed to upsert fragment: fragment %s: %w", fragment.ID, ErrOtherTenant)
+	}
+	return nil
+}
+
+// GetByID retrieves a fragment by its ID along with its actor and session, or
+// an error matching ErrNotFound if it doesn't exist
+func (s *FragmentStore) GetByID(fragmentID id.ID) (*db.Fragment, error) {
+	return cachedLookup(s.cache, fragmentID, s.tenant, func(fragment db.Fragment) *id.ID {
+		return fragment.TenantID
+	}, func() (*db.Fragment, error) {
+		if s.mem != nil {
+			fragments := s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+				return fragment.ID == fragmentID
+			}}, true, true)
+			if len(fragments) == 0 {
+				return nil, fmt.Errorf("fragment %v: %w", fragmentID, ErrNotFound)
+			}
+			return &fragments[0], nil
+		}
+		var fragment db.Fragment
+		q := preloadTenant(s.query(), s.tenant, "Actor")
+		if err := preloadTenant(q, s.tenant, "Session").
+			Where("id = ?", fragmentID).
+			First(&fragment).Error; err != nil {
+			return nil, lookupError(err, "fragment", fragmentID)
+		}
+		return &fragment, nil
+	}, cloneFragment)
+}
+
+// Exists reports whether a fragment with the given ID exists
+func (s *FragmentStore) Exists(fragmentID id.ID) (bool, error) {
+	if s.mem != nil {
+		_, err := s.GetByID(fragmentID)
+		return err == nil, nil
+	}
+	var count int64
+	if err := s.query().Model(&db.Fragment{}).Where("id = ?", fragmentID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check fragment existence: %w", err)
+	}
+	return count > 0, nil
+}
+
+// CreateIfNotExists inserts a fragment unless one with the same ID already exists.
+// Returns whether the fragment was inserted. The check and insert are a single
+// statement, so concurrent callers cannot both insert the same fragment.
+func (s *FragmentStore) CreateIfNotExists(fragment *db.Fragment) (bool, error) {
+	if s.dryRun {
+		return true, nil
+	}
+	defer s.invalidate(fragment.ID)
+	if err := stampTenant("fragment", fragment.ID, &fragment.TenantID, s.tenant); err != nil {
+		return false, fmt.Errorf("failed to create fragment: %w", err)
+	}
+	if s.mem != nil {
+		created, err := s.mem.writeFragment(s.table, fragment, false, true)
+		if err != nil {
+			return false, fmt.Errorf("failed to create fragment: %w", err)
+		}
+		return created, nil
+	}
+	result := s.write(fragment).Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(fragment)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to create fragment: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// HistoryQuery selects the fragments of a session for its conversation history
+type HistoryQuery struct {
+	Limit          int       // Maximum number of fragments (the most recent ones), 0 for no limit
+	Before         time.Time // Only fragments created before this time, if set
+	After          time.Time // Only fragments created after this time, if set
+	IncludeDeleted bool      // Include soft-deleted fragments
+	PreloadActor   bool      // Load each fragment's Actor
+}
+
+// GetSessionHistory returns the fragments of a session in chronological order.
+// When a limit is set, the most recent fragments are returned.
+func (s *FragmentStore) GetSessionHistory(sessionID id.ID, query HistoryQuery) ([]db.Fragment, error) {
+	if s.mem != nil {
+		fragments := s.mem.findFragments(s.table, memoryFragmentFilter{
+			includeDeleted: query.IncludeDeleted,
+			match: func(fragment db.Fragment) bool {
+				return fragment.SessionID == sessionID && SearchFilter{After: query.After, Before: query.Before}.match(fragment)
+			},
+		}, query.PreloadActor, false)
+		if query.Limit > 0 && len(fragments) > query.Limit {
+			fragments = fragments[len(fragments)-query.Limit:]
+		}
+		return fragments, nil
+	}
+
+	q := s.query().Where("session_id = ?", sessionID)
+
+	if query.IncludeDeleted {
+		q = q.Unscoped()
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("created_at < ?", query.Before)
+	}
+	if !query.After.IsZero() {
+		q = q.Where("created_at > ?", query.After)
+	}
+	if query.PreloadActor {
+		q = preloadTenant(q, s.tenant, "Actor")
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	// Select newest first so the limit keeps the most recent fragments
+	var fragments []db.Fragment
+	if err := q.Order("created_at DESC").Order("id DESC").Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get session history: %w", err)
+	}
+
+	for i, j := 0, len(fragments)-1; i < j; i, j = i+1, j-1 {
+		fragments[i], fragments[j] = fragments[j], fragments[i]
+	}
+
+	return fragments, nil
+}
+
+// defaultFragmentPageSize is the page size of List without a limit
+const defaultFragmentPageSize = 100
+
+// FragmentCursor is the position of a fragment in a listing, used to continue
+// it after that fragment
+type FragmentCursor struct {
+	CreatedAt time.Time
+	ID        id.ID
+}
+
+// String encodes the cursor into an opaque token, e.g. for an API response
+func (c FragmentCursor) String() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + string(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseFragmentCursor decodes a cursor encoded with FragmentCursor.String
+func ParseFragmentCursor(token string) (FragmentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return FragmentCursor{}, fmt.Errorf("invalid fragment cursor: %w", err)
+	}
+	createdAt, fragmentID, ok := strings.Cut(string(raw), "|")
+	if !ok || fragmentID == "" {
+		return FragmentCursor{}, fmt.Errorf("invalid fragment cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return FragmentCursor{}, fmt.Errorf("invalid fragment cursor: %w", err)
+	}
+	return FragmentCursor{CreatedAt: t, ID: id.ID(fragmentID)}, nil
+}
+
+// FragmentFilter selects the fragments List returns
+type FragmentFilter struct {
+	SessionID id.ID       // Only fragments of this session, if set
+	ActorID   id.ID       // Only fragments of this actor, if set
+	After     time.Time   // Only fragments created after this time, if set
+	Before    time.Time   // Only fragments created before this time, if set
+	Metadata  db.Metadata // Only fragments whose metadata contains these keys and values
+	// Only fragments whose metadata meets all these conditions, see Meta
+	MetadataWhere []MetadataCondition
+
+	IncludeDeleted bool // Include soft-deleted fragments
+	PreloadActor   bool // Load each fragment's Actor
+
+	Descending bool            // Newest first instead of oldest first
+	Limit      int             // Page size, 100 if not set
+	Cursor     *FragmentCursor // Continue after this fragment, from a previous page's Next
+}
+
+// FragmentPage is a page of a fragment listing
+type FragmentPage struct {
+	Fragments []db.Fragment
+	Next      *FragmentCursor // Cursor of the next page, nil on the last page
+}
+
+// List returns a page of the fragments matching filter, ordered by creation
+// time and ID. Pages continue from a cursor rather than an offset, so deep
+// pages stay fast and fragments created while paging don't shift them:
+//
+//	filter := stores.FragmentFilter{SessionID: sessionID, Limit: 50}
+//	for {
+//	    page, err := store.List(filter)
+//	    ...
+//	    if page.Next == nil {
+//	        break
+//	    }
+//	    filter.Cursor = page.Next
+//	}
+func (s *FragmentStore) List(filter FragmentFilter) (*FragmentPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultFragmentPageSize
+	}
+
+	conditions, err := compileMetadataConditions(filter.MetadataWhere)
+	if err != nil {
+		return nil, err
+	}
+	if s.mem != nil {
+		return s.listMemory(filter, conditions, limit), nil
+	}
+
+	q := s.query()
+	if filter.IncludeDeleted {
+		q = q.Unscoped()
+	}
+	if filter.SessionID != "" {
+		q = q.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.ActorID != "" {
+		q = q.Where("actor_id = ?", filter.ActorID)
+	}
+	if !filter.After.IsZero() {
+		q = q.Where("created_at > ?", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		q = q.Where("created_at < ?", filter.Before)
+	}
+	if len(filter.Metadata) > 0 {
+		q = q.Where("metadata @> ?::jsonb", filter.Metadata)
+	}
+	q = applyMetadataConditions(q, conditions)
+	if filter.PreloadActor {
+		q = preloadTenant(q, s.tenant, "Actor")
+	}
+
+	order := "created_at, id"
+	if filter.Descending {
+		order = "created_at DESC, id DESC"
+	}
+	if filter.Cursor != nil {
+		if filter.Descending {
+			q = q.Where("(created_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
+		} else {
+			q = q.Where("(created_at, id) > (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
+		}
+	}
+
+	// Fetch one more fragment to know whether there is a next page
+	var fragments []db.Fragment
+	if err := q.Order(order).Limit(limit + 1).Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to list fragments: %w", err)
+	}
+
+	page := &FragmentPage{Fragments: fragments}
+	if len(fragments) > limit {
+		page.Fragments = fragments[:limit]
+		last := page.Fragments[limit-1]
+		page.Next = &FragmentCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	return page, nil
+}
+
+// IterateSession calls fn with the fragments of a session in chronological order,
+// batchSize fragments at a time and with their actors loaded, so large sessions
+// are never loaded into memory at once. Iteration stops at the first error fn returns.
+func (s *FragmentStore) IterateSession(sessionID id.ID, batchSize int, fn func([]db.Fragment) error) error {
+	var (
+		lastCreatedAt time.Time
+		lastID        id.ID
+	)
+
+	for {
+		var fragments []db.Fragment
+		if s.mem != nil {
+			fragments = page(s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+				return fragment.SessionID == sessionID && (lastID == "" || fragmentAfter(fragment, lastCreatedAt, lastID))
+			}}, true, false), 0, batchSize)
+		} else {
+			q := preloadTenant(s.query(), s.tenant, "Actor").
+				Where("session_id = ?", sessionID)
+			if lastID != "" {
+				q = q.Where("(created_at, id) > (?, ?)", lastCreatedAt, lastID)
+			}
+			if err := q.Order("created_at").Order("id").Limit(batchSize).Find(&fragments).Error; err != nil {
+				return fmt.Errorf("failed to get session fragments: %w", err)
+			}
+		}
+		if len(fragments) == 0 {
+			return nil
+		}
+
+		if err := fn(fragments); err != nil {
+			return err
+		}
+
+		if len(fragments) < batchSize {
+			return nil
+		}
+		last := fragments[len(fragments)-1]
+		lastCreatedAt, lastID = last.CreatedAt, last.ID
+	}
+}
+
+// GetLatestByMetadata returns the most recent fragment of a session whose
+// metadata holds value under key, or an error matching ErrNotFound if there is
+// none
+func (s *FragmentStore) GetLatestByMetadata(sessionID id.ID, key, value string) (*db.Fragment, error) {
+	var fragments []db.Fragment
+	if s.mem != nil {
+		fragments = reversed(s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+			return fragment.SessionID == sessionID && metadataEquals(fragment.Metadata, key, value)
+		}}, false, false))
+	} else if err := s.query().
+		Where("session_id = ?", sessionID).
+		Where("metadata ->> ? = ?", key, value).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get fragment by metadata: %w", err)
+	}
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("fragment with %s %q in session %s: %w", key, value, sessionID, ErrNotFound)
+	}
+	return &fragments[0], nil
+}
+
+// ListByMetadata returns up to limit fragments across all sessions whose
+// metadata holds value under key, newest first
+func (s *FragmentStore) ListByMetadata(key, value string, limit int) ([]db.Fragment, error) {
+	if s.mem != nil {
+		return page(reversed(s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+			return metadataEquals(fragment.Metadata, key, value)
+		}}, true, false)), 0, limit), nil
+	}
+	var fragments []db.Fragment
+	if err := preloadTenant(s.query(), s.tenant, "Actor").
+		Where("metadata ->> ? = ?", key, value).
+		Order("created_at DESC").
+		Order("id DESC").
+		Limit(limit).
+		Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to list fragments by metadata: %w", err)
+	}
+	return fragments, nil
+}
+
+// CountByMetadata returns the number of fragments across all sessions by the
+// value their metadata holds under key, e.g. to compare the responses of
+// prompt versions. Fragments without the key aren't counted.
+func (s *FragmentStore) CountByMetadata(key string) (map[string]int64, error) {
+	if s.mem != nil {
+		counts := make(map[string]int64)
+		for _, fragment := range s.mem.findFragments(s.table, memoryFragmentFilter{}, false, false) {
+			if value, ok := metadataText(fragment.Metadata, key); ok {
+				counts[value]++
+			}
+		}
+		return counts, nil
+	}
+	var rows []struct {
+		Value string
+		Count int64
+	}
+	if err := s.query().
+		Model(&db.Fragment{}).
+		Select("metadata ->> ? AS value, COUNT(*) AS count", key).
+		Where("metadata ->> ? IS NOT NULL", key).
+		Group("value").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count fragments by metadata: %w", err)
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Value] = row.Count
+	}
+	return counts, nil
+}
+
+// GetChildren returns the fragments replying to a fragment, oldest first
+func (s *FragmentStore) GetChildren(parentID id.ID) ([]db.Fragment, error) {
+	if s.mem != nil {
+		return s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+			return fragment.ParentID != nil && *fragment.ParentID == parentID
+		}}, false, false), nil
+	}
+	var fragments []db.Fragment
+	if err := s.query().
+		Where("parent_id = ?", parentID).
+		Order("created_at").
+		Order("id").
+		Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get child fragments: %w", err)
+	}
+	return fragments, nil
+}
+
+// GetThread returns a fragment and the chain of fragments it replies to, from the
+// root down to the fragment itself. At most maxDepth ancestors are followed, so
+// the returned thread holds up to maxDepth+1 fragments.
+func (s *FragmentStore) GetThread(fragmentID id.ID, maxDepth int) ([]db.Fragment, error) {
+	if s.mem != nil {
+		return s.mem.thread(s.table, fragmentID, maxDepth), nil
+	}
+
+	table := s.tableName()
+
+	// Scoped stores walk the fragments of their tenant only
+	tenant, parentTenant := "", ""
+	if s.tenant != "" {
+		tenant, parentTenant = " AND tenant_id = @tenant", " AND parent.tenant_id = @tenant"
+	}
+
+	var ids []struct {
+		ID    id.ID
+		Depth int
+	}
+	if err := s.db.WithContext(s.ctx).Raw(`
+		WITH RECURSIVE thread AS (
+			SELECT id, parent_id, 0 AS depth FROM `+table+`
+			WHERE id = @fragment AND deleted_at IS NULL`+tenant+`
+			UNION ALL
+			SELECT parent.id, parent.parent_id, thread.depth + 1 FROM `+table+` parent
+			JOIN thread ON parent.id = thread.parent_id
+			WHERE thread.depth < @depth AND parent.deleted_at IS NULL`+parentTenant+`
+		)
+		SELECT id, depth FROM thread ORDER BY depth DESC`,
+		map[string]interface{}{"fragment": fragmentID, "depth": maxDepth, "tenant": s.tenant},
+	).Scan(&ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	threadIDs := make([]id.ID, len(ids))
+	for i, row := range ids {
+		threadIDs[i] = row.ID
+	}
+
+	var fragments []db.Fragment
+	if err := preloadTenant(s.query(), s.tenant, "Actor").
+		Where("id IN ?", threadIDs).
+		Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get thread: %w", err)
+	}
+
+	// Restore the root-first order of the walk
+	byID := make(map[id.ID]db.Fragment, len(fragments))
+	for _, fragment := range fragments {
+		byID[fragment.ID] = fragment
+	}
+	thread := make([]db.Fragment, 0, len(fragments))
+	for _, fragmentID := range threadIDs {
+		if fragment, ok := byID[fragmentID]; ok {
+			thread = append(thread, fragment)
+		}
+	}
+
+	return thread, nil
+}
+
+// SimilarFragment is a fragment together with its cosine similarity to a query embedding
+type SimilarFragment struct {
+	db.Fragment
+	Similarity float64
+}
+
+// FindSimilar returns up to limit fragments ordered by cosine similarity to the embedding,
+// most similar first. An empty session ID searches across all sessions.
+func (s *FragmentStore) FindSimilar(embedding pgvector.Vector, sessionID id.ID, limit int) ([]SimilarFragment, error) {
+	if s.mem != nil {
+		return s.findSimilarMemory(embedding, SearchFilter{SessionID: sessionID}, limit), nil
+	}
+	q := s.query().
+		Model(&db.Fragment{}).
+		Select("*, 1 - (embedding <=> ?) AS similarity", embedding).
+		Where("embedding IS NOT NULL AND vector_norm(embedding) > 0")
+
+	if sessionID != "" {
+		q = q.Where("session_id = ?", sessionID)
+	}
+
+	var results []SimilarFragment
+	if err := q.Clauses(clause.OrderBy{
+		Expression: clause.Expr{SQL: "embedding <=> ?", Vars: []interface{}{embedding}},
+	}).Limit(limit).Find(&results).Error; err != nil {
+		return nil, fmt.Errorf("failed to search similar fragments: %w", err)
+	}
+
+	return results, nil
+}
+
+// FindSimilarForActor returns up to limit fragments of an actor ordered by cosine
+// similarity to the embedding, most similar first, across all sessions
+func (s *FragmentStore) FindSimilarForActor(embedding pgvector.Vector, actorID id.ID, limit int) ([]SimilarFragment, error) {
+	if s.mem != nil {
+		return s.findSimilarMemory(embedding, SearchFilter{ActorID: actorID}, limit), nil
+	}
+	var results []SimilarFragment
+	if err := s.query().
+		Model(&db.Fragment{}).
+		Select("*, 1 - (embedding <=> ?) AS similarity", embedding).
+		Where("embedding IS NOT NULL AND vector_norm(embedding) > 0").
+		Where("actor_id = ?", actorID).
+		Clauses(clause.OrderBy{
+			Expression: clause.Expr{SQL: "embedding <=> ?", Vars: []interface{}{embedding}},
+		}).
+		Limit(limit).
+		Find(&results).Error; err != nil {
+		return nil, fmt.Errorf("failed to search similar fragments: %w", err)
+	}
+	return results, nil
+}
+
+// DistanceMetric is the pgvector distance fragments are searched by
+type DistanceMetric string
+
+const (
+	DistanceCosine       DistanceMetric = "cosine"        // Cosine distance, <=>
+	DistanceL2           DistanceMetric = "l2"            // Euclidean distance, <->
+	DistanceInnerProduct DistanceMetric = "inner_product" // Negative inner product, <#>
+)
+
+// operator returns the pgvector operator of a metric
+func (m DistanceMetric) operator() (string, error) {
+	switch m {
+	case DistanceCosine, "":
+		return "<=>", nil
+	case DistanceL2:
+		return "<->", nil
+	case DistanceInnerProduct:
+		return "<#>", nil
+	default:
+		return "", fmt.Errorf("unknown distance metric %q", m)
+	}
+}
+
+// SearchQuery selects the fragments SearchSimilar returns
+type SearchQuery struct {
+	Embedding pgvector.Vector
+	Limit     int
+	Metric    DistanceMetric // DistanceCosine if empty
+	SearchFilter
+
+	// Only fragments at most this distance away, in the metric's unit. With
+	// DistanceInnerProduct, distances are negated inner products.
+	MaxDistance *float64
+}
+
+// FragmentMatch is a fragment found by SearchSimilar with its distance to the
+// query embedding and a score where higher means more similar: the cosine
+// similarity, the inner product, or 1/(1+distance) for DistanceL2
+type FragmentMatch struct {
+	db.Fragment
+	Distance float64
+	Score    float64 `gorm:"-"`
+}
+
+// SearchSimilar returns up to query.Limit fragments ordered by their distance
+// to the query embedding, closest first. Fragments without embedding, and with
+// cosine a zero embedding, are never returned.
+func (s *FragmentStore) SearchSimilar(query SearchQuery) ([]FragmentMatch, error) {
+	operator, err := query.Metric.operator()
+	if err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("search limit must be positive")
+	}
+
+	if s.mem != nil {
+		matches := s.mem.searchSimilar(s.table, query.Metric, query.Embedding, query.Limit, query.MaxDistance, query.SearchFilter.match)
+		scoreMatches(query.Metric, matches)
+		return matches, nil
+	}
+
+	// The operator comes from a fixed set, the embedding is always a parameter
+	distance := "embedding " + operator + " ?"
+	q := s.query().
+		Model(&db.Fragment{}).
+		Select("*, "+distance+" AS distance", query.Embedding).
+		Where("embedding IS NOT NULL")
+
+	if query.Metric == DistanceCosine || query.Metric == "" {
+		q = q.Where("vector_norm(embedding) > 0")
+	}
+	q = query.SearchFilter.apply(q)
+	if query.MaxDistance != nil {
+		q = q.Where("("+distance+") <= ?", query.Embedding, *query.MaxDistance)
+	}
+
+	var matches []FragmentMatch
+	if err := q.Clauses(clause.OrderBy{
+		Expression: clause.Expr{SQL: distance, Vars: []interface{}{query.Embedding}},
+	}).Limit(query.Limit).Find(&matches).Error; err != nil {
+		return nil, fmt.Errorf("failed to search similar fragments: %w", err)
+	}
+
+	scoreMatches(query.Metric, matches)
+	return matches, nil
+}
+
+// scoreMatches sets the score of matches from their distance in metric
+func scoreMatches(metric DistanceMetric, matches []FragmentMatch) {
+	for i := range matches {
+		switch metric {
+		case DistanceL2:
+			matches[i].Score = 1 / (1 + matches[i].Distance)
+		case DistanceInnerProduct:
+			matches[i].Score = -matches[i].Distance
+		default:
+			matches[i].Score = 1 - matches[i].Distance
+		}
+	}
+}
+
+// Iterate calls fn with all fragments in creation order, batchSize fragments at
+// a time. Iteration stops at the first error fn returns.
+func (s *FragmentStore) Iterate(batchSize int, fn func([]db.Fragment) error) error {
+	var (
+		lastCreatedAt time.Time
+		lastID        id.ID
+	)
+
+	for {
+		var fragments []db.Fragment
+		if s.mem != nil {
+			fragments = page(s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+				return lastID == "" || fragmentAfter(fragment, lastCreatedAt, lastID)
+			}}, false, false), 0, batchSize)
+		} else {
+			q := s.query()
+			if lastID != "" {
+				q = q.Where("(created_at, id) > (?, ?)", lastCreatedAt, lastID)
+			}
+			if err := q.Order("created_at").Order("id").Limit(batchSize).Find(&fragments).Error; err != nil {
+				return fmt.Errorf("failed to get fragments: %w", err)
+			}
+		}
+		if len(fragments) == 0 {
+			return nil
+		}
+
+		if err := fn(fragments); err != nil {
+			return err
+		}
+
+		if len(fragments) < batchSize {
+			return nil
+		}
+		last := fragments[len(fragments)-1]
+		lastCreatedAt, lastID = last.CreatedAt, last.ID
+	}
+}
+
+// Delete soft-deletes a fragment
+func (s *FragmentStore) Delete(fragmentID id.ID) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(fragmentID)
+	if s.mem != nil {
+		s.mem.deleteFragment(s.table, fragmentID)
+		return nil
+	}
+	if err := s.query().Where("id = ?", fragmentID).Delete(&db.Fragment{}).Error; err != nil {
+		return fmt.Errorf("failed to delete fragment: %w", err)
+	}
+	return nil
+}
+
+// UpdatePendingEmbedding sets the embedding of a fragment whose embedding is still
+// the zero vector. Returns whether it was set, so each pending embedding is
+// written at most once even with concurrent backfills.
+func (s *FragmentStore) UpdatePendingEmbedding(fragmentID id.ID, embedding pgvector.Vector) (bool, error) {
+	if s.dryRun {
+		return true, nil
+	}
+	defer s.invalidate(fragmentID)
+	if s.mem != nil {
+		if n := len(embedding.Slice()); n != db.EmbeddingDimensions {
+			return false, fmt.Errorf("failed to update fragment embedding: expected %d dimensions, not %d", db.EmbeddingDimensions, n)
+		}
+		return s.mem.updateFragment(s.table, fragmentID, func(fragment *db.Fragment) bool {
+			if !pendingEmbedding(*fragment) {
+				return false
+			}
+			fragment.Embedding = pgvector.NewVector(append([]float32(nil), embedding.Slice()...))
+			return true
+		}), nil
+	}
+	result := s.query().
+		Model(&db.Fragment{}).
+		Where("id = ? AND vector_norm(embedding) = 0", fragmentID).
+		Update("embedding", embedding)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update fragment embedding: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetPendingEmbeddings returns up to limit fragments whose embedding is still the
+// zero vector, oldest first. Passing the creation time and ID of the last fragment
+// of the previous batch returns the next batch.
+func (s *FragmentStore) GetPendingEmbeddings(afterCreatedAt time.Time, afterID id.ID, limit int) ([]db.Fragment, error) {
+	if s.mem != nil {
+		return page(s.mem.findFragments(s.table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+			return pendingEmbedding(fragment) && (afterID == "" || fragmentAfter(fragment, afterCreatedAt, afterID))
+		}}, false, false), 0, limit), nil
+	}
+	q := s.query().Where("embedding IS NOT NULL AND vector_norm(embedding) = 0")
+	if afterID != "" {
+		q = q.Where("(created_at, id) > (?, ?)", afterCreatedAt, afterID)
+	}
+
+	var fragments []db.Fragment
+	if err := q.Order("created_at").Order("id").Limit(limit).Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get fragments with pending embeddings: %w", err)
+	}
+	return fragments, nil
+}
+
+// invalidate drops fragments from the store's cache after writing them
+func (s *FragmentStore) invalidate(fragmentIDs ...id.ID) {
+	s.cache.invalidate(fragmentIDs...)
+}
+
+// query returns the store's database scoped to its table, context and tenant
+func (s *FragmentStore) query() *gorm.DB {
+	return scopeTenant(s.db.WithContext(s.ctx).Table(string(s.table)), s.tenant, "tenant_id")
+}
+
+// conflictColumns returns the columns matching the fragment an upsert
+// replaces: its ID, and its creation time in partitioned tables, whose unique
+// keys include the partition key
+func (s *FragmentStore) conflictColumns() []clause.Column {
+	if db.IsMarkedPartitioned(s.db, s.table) {
+		return []clause.Column{{Name: "id"}, {Name: "created_at"}}
+	}
+	return []clause.Column{{Name: "id"}}
+}
+
+// tableName returns the quoted name of the store's table for raw queries
+func (s *FragmentStore) tableName() string {
+	stmt := &gorm.Statement{DB: s.db}
+	return stmt.Quote(string(s.table))
+}
+
+// write returns the query for inserting a fragment. Fragments without an
+// embedding store NULL, since pgvector rejects empty vectors.
+func (s *FragmentStore) write(fragment *db.Fragment) *gorm.DB {
+	omit := []string{clause.Associations}
+	if len(fragment.Embedding.Slice()) == 0 {
+		omit = append(omit, "Embedding")
+	}
+	return s.query().Omit(omit...)
+}
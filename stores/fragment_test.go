@@ -0,0 +1,709 @@
+package stores
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// testDatabaseURLEnv names the Postgres database with pgvector live tests run
+// against, in a transaction rolled back when they end
+const testDatabaseURLEnv = "THOR_TEST_DATABASE_URL"
+
+// embedding returns a vector of the fragment dimension starting with values
+func embedding(values ...float32) pgvector.Vector {
+	v := make([]float32, 1536)
+	copy(v, values)
+	return pgvector.NewVector(v)
+}
+
+// seedFragment is an interaction seeded by seedConversations
+type seedFragment struct {
+	name string
+	// at is when the fragment is created, from the start of the conversations
+	at time.Duration
+	// id is the ID of the fragment, a new one if empty
+	id        id.ID
+	embedding pgvector.Vector
+	metadata  db.Metadata
+	// other makes the fragment of the other actor, in the other session
+	other   bool
+	deleted bool
+}
+
+// conversations are two conversations made with batchConversation, of actor
+// in session and of other in otherSession, with seeded interactions
+type conversations struct {
+	store                 *FragmentStore
+	actor, other          *db.Actor
+	session, otherSession *db.Session
+	start                 time.Time
+	// names are the names of the seeded fragments, by ID
+	names map[id.ID]string
+}
+
+// seedConversations creates two conversations in bundle, and their
+// interactions in order from 2026-01-01 12:00 UTC
+func seedConversations(t *testing.T, bundle *Stores, fragments []seedFragment) *conversations {
+	t.Helper()
+	c := &conversations{
+		store: bundle.Fragments(db.FragmentTableInteraction),
+		start: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		names: make(map[id.ID]string),
+	}
+	c.actor, c.session = batchConversation(t, bundle)
+	c.other, c.otherSession = batchConversation(t, bundle)
+	for _, f := range fragments {
+		c.add(t, f)
+	}
+	return c
+}
+
+// add creates an interaction of the conversations
+func (c *conversations) add(t *testing.T, f seedFragment) *db.Fragment {
+	t.Helper()
+	fragment := batchFragment(c.actor, c.session, f.name)
+	if f.other {
+		fragment = batchFragment(c.other, c.otherSession, f.name)
+	}
+	if f.id != "" {
+		fragment.ID = f.id
+	}
+	if f.metadata != nil {
+		fragment.Metadata = f.metadata
+	}
+	fragment.Embedding = f.embedding
+	fragment.CreatedAt = c.start.Add(f.at)
+	if err := c.store.Create(fragment); err != nil {
+		t.Fatal(err)
+	}
+	if f.deleted {
+		if err := c.store.Delete(fragment.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.names[fragment.ID] = f.name
+	return fragment
+}
+
+// searchFragments are at known distances to the query embedding (1, 0, ...),
+// created a minute apart in this order:
+//
+//	name      embedding  cosine  l2     inner product
+//	same      (3, 0)     0       2      -3
+//	close     (1, 0.5)   0.106   0.5    -1
+//	wide      (0.2, 2)   0.900   2.154  -0.2
+//	zero      (0, 0)     -       1      0
+//	opposite  (-2, 0)    2       3      2     of the other actor
+//
+// with one without embedding.
+var searchFragments = []seedFragment{
+	{name: "same", embedding: embedding(3, 0)},
+	{name: "close", at: time.Minute, embedding: embedding(1, 0.5)},
+	{name: "wide", at: 2 * time.Minute, embedding: embedding(0.2, 2)},
+	{name: "zero", at: 3 * time.Minute, embedding: embedding()},
+	{name: "opposite", at: 4 * time.Minute, embedding: embedding(-2, 0), other: true},
+	{name: "none", at: 5 * time.Minute},
+}
+
+// testSearchSimilar runs the SearchSimilar cases against the fragments of a
+// fixture in bundle
+func testSearchSimilar(t *testing.T, bundle *Stores) {
+	fixture := seedConversations(t, bundle, searchFragments)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	distance := func(d float64) *float64 { return &d }
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  []string
+		// wantScore is the score of the first match
+		wantScore float64
+	}{
+		{
+			name:      "cosine by default",
+			query:     SearchQuery{Limit: 10},
+			want:      []string{"same", "close", "wide", "opposite"},
+			wantScore: 1,
+		},
+		{
+			name:      "l2",
+			query:     SearchQuery{Limit: 10, Metric: DistanceL2},
+			want:      []string{"close", "zero", "same", "wide", "opposite"},
+			wantScore: 1 / 1.5,
+		},
+		{
+			name:      "inner product",
+			query:     SearchQuery{Limit: 10, Metric: DistanceInnerProduct},
+			want:      []string{"same", "close", "wide", "zero", "opposite"},
+			wantScore: 3,
+		},
+		{
+			name:      "limit",
+			query:     SearchQuery{Limit: 2, Metric: DistanceCosine},
+			want:      []string{"same", "close"},
+			wantScore: 1,
+		},
+		{
+			name:      "max cosine distance",
+			query:     SearchQuery{Limit: 10, MaxDistance: distance(0.5)},
+			want:      []string{"same", "close"},
+			wantScore: 1,
+		},
+		{
+			name:      "max l2 distance",
+			query:     SearchQuery{Limit: 10, Metric: DistanceL2, MaxDistance: distance(2)},
+			want:      []string{"close", "zero", "same"},
+			wantScore: 1 / 1.5,
+		},
+		{
+			name:      "max inner product distance",
+			query:     SearchQuery{Limit: 10, Metric: DistanceInnerProduct, MaxDistance: distance(-0.5)},
+			want:      []string{"same", "close"},
+			wantScore: 3,
+		},
+		{
+			name:      "session",
+			query:     SearchQuery{Limit: 10, SearchFilter: SearchFilter{SessionID: fixture.otherSession.ID}},
+			want:      []string{"opposite"},
+			wantScore: -1,
+		},
+		{
+			name:      "actor",
+			query:     SearchQuery{Limit: 10, Metric: DistanceL2, SearchFilter: SearchFilter{ActorID: fixture.other.ID}},
+			want:      []string{"opposite"},
+			wantScore: 0.25,
+		},
+		{
+			name: "time range",
+			query: SearchQuery{Limit: 10, SearchFilter: SearchFilter{
+				After:  fixture.start,
+				Before: fixture.start.Add(3 * time.Minute),
+			}},
+			want:      []string{"close", "wide"},
+			wantScore: 1 / math.Sqrt(1.25),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Embedding = embedding(1, 0)
+			matches, err := store.SearchSimilar(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(matches))
+			for i, match := range matches {
+				got[i] = fixture.names[match.ID]
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("matches = %v, want %v", got, tt.want)
+			}
+			if score := matches[0].Score; math.Abs(score-tt.wantScore) > 1e-4 {
+				t.Errorf("score of %s = %v, want %v", got[0], score, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestSearchSimilar(t *testing.T) {
+	forEachStores(t, testSearchSimilar)
+}
+
+// openTestPool connects to the pgvector database named by
+// THOR_TEST_DATABASE_URL, closed when the test ends, or returns nil if it is
+// not set. Tests writing to it must clean up after themselves.
+func openTestPool(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	url := os.Getenv(testDatabaseURLEnv)
+	if url == "" {
+		return nil
+	}
+	database, err := db.NewDatabase(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return database
+}
+
+// openTestDatabase returns a transaction of the database named by
+// THOR_TEST_DATABASE_URL rolled back when the test ends, or nil if it is not
+// set
+func openTestDatabase(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	database := openTestPool(tb)
+	if database == nil {
+		return nil
+	}
+	tx := database.Begin()
+	if tx.Error != nil {
+		tb.Fatal(tx.Error)
+	}
+	tb.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// capturingFragmentStore returns a store over a database building queries
+// without running them, and the statement of its last query
+func capturingFragmentStore(t *testing.T) (*FragmentStore, **gorm.Statement) {
+	t.Helper()
+	database := offlineDatabase()
+	statement := new(*gorm.Statement)
+	if err := database.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		*statement = tx.Statement
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return NewFragmentStore(context.Background(), database, db.FragmentTableInteraction), statement
+}
+
+// checkQuery checks that the SQL of a statement has the parts in order, and
+// returns it
+func checkQuery(t *testing.T, statement *gorm.Statement, parts []string) string {
+	t.Helper()
+	if statement == nil {
+		t.Fatal("no query was built")
+	}
+	sql := statement.SQL.String()
+	rest := sql
+	for _, part := range parts {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			t.Fatalf("query %s has no %q after the previous parts", sql, part)
+		}
+		rest = rest[i+len(part):]
+	}
+	return sql
+}
+
+func TestSearchSimilarQuery(t *testing.T) {
+	query := embedding(1, 0)
+	maxDistance := 0.5
+
+	tests := []struct {
+		name   string
+		metric DistanceMetric
+		filter SearchFilter
+		max    *float64
+		// want are parts of the SQL expected, in order
+		want []string
+		// vars is the number of parameters expected
+		vars int
+	}{
+		{
+			name: "cosine",
+			want: []string{"embedding <=> $1 AS distance", "embedding IS NOT NULL", "vector_norm(embedding) > 0", "ORDER BY embedding <=> $2", "LIMIT $3"},
+			vars: 3,
+		},
+		{
+			name:   "l2",
+			metric: DistanceL2,
+			want:   []string{"embedding <-> $1 AS distance", "embedding IS NOT NULL", "ORDER BY embedding <-> $2", "LIMIT $3"},
+			vars:   3,
+		},
+		{
+			name:   "inner product",
+			metric: DistanceInnerProduct,
+			want:   []string{"embedding <#> $1 AS distance", "embedding IS NOT NULL", "ORDER BY embedding <#> $2", "LIMIT $3"},
+			vars:   3,
+		},
+		{
+			name:   "filters",
+			metric: DistanceL2,
+			filter: SearchFilter{SessionID: id.New(), ActorID: id.New(), After: time.Now().Add(-time.Hour), Before: time.Now()},
+			max:    &maxDistance,
+			want: []string{
+				"embedding <-> $1 AS distance", "session_id = $2", "actor_id = $3", "created_at > $4", "created_at < $5",
+				"(embedding <-> $6) <= $7", "ORDER BY embedding <-> $8", "LIMIT $9",
+			},
+			vars: 9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, statement := capturingFragmentStore(t)
+
+			if _, err := store.SearchSimilar(SearchQuery{
+				Embedding:    query,
+				Limit:        5,
+				Metric:       tt.metric,
+				SearchFilter: tt.filter,
+				MaxDistance:  tt.max,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			sql := checkQuery(t, *statement, tt.want)
+			if tt.metric != "" && tt.metric != DistanceCosine && strings.Contains(sql, "vector_norm") {
+				t.Errorf("query %s skips zero embeddings, which only cosine needs", sql)
+			}
+			if vars := (*statement).Vars; len(vars) != tt.vars {
+				t.Errorf("query has %d parameters, want %d", len(vars), tt.vars)
+			} else if fmt.Sprint(vars[0]) != fmt.Sprint(query) {
+				t.Errorf("first parameter = %v, want the query embedding", vars[0])
+			}
+		})
+	}
+}
+
+func TestSearchSimilarInvalid(t *testing.T) {
+	store := NewMemoryStores(context.Background()).Fragments(db.FragmentTableInteraction)
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  string
+	}{
+		{name: "unknown metric", query: SearchQuery{Limit: 5, Metric: "hamming"}, want: `unknown distance metric "hamming"`},
+		{name: "no limit", query: SearchQuery{}, want: "search limit must be positive"},
+		{name: "negative limit", query: SearchQuery{Limit: -1}, want: "search limit must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Embedding = embedding(1, 0)
+			_, err := store.SearchSimilar(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// listFragments are f0 to f7 created a minute apart, except tie-a and tie-b
+// sharing f4's minute in ID order, with even ones on discord and f3 deleted,
+// and b0 of the other actor
+func listFragments() []seedFragment {
+	tied := []id.ID{id.New(), id.New()}
+	if tied[1] < tied[0] {
+		tied[0], tied[1] = tied[1], tied[0]
+	}
+
+	var fragments []seedFragment
+	for i := 0; i < 8; i++ {
+		metadata := db.Metadata{"platform": "slack"}
+		if i%2 == 0 {
+			metadata = db.Metadata{"platform": "discord"}
+		}
+		fragments = append(fragments, seedFragment{
+			name:     fmt.Sprintf("f%d", i),
+			at:       time.Duration(i) * time.Minute,
+			metadata: metadata,
+			deleted:  i == 3,
+		})
+	}
+	return append(fragments,
+		seedFragment{name: "tie-b", at: 4*time.Minute + 30*time.Second, id: tied[1]},
+		seedFragment{name: "tie-a", at: 4*time.Minute + 30*time.Second, id: tied[0]},
+		seedFragment{name: "b0", at: 90 * time.Second, metadata: db.Metadata{"platform": "discord"}, other: true},
+	)
+}
+
+// listAll pages through the fragments matching filter, calling between, if
+// set, after each page, and returns their contents and the page sizes
+func listAll(t *testing.T, store *FragmentStore, filter FragmentFilter, between func(page int)) (names []string, sizes []int) {
+	t.Helper()
+	for page := 0; ; page++ {
+		if page > 20 {
+			t.Fatal("listing never ends")
+		}
+		result, err := store.List(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, fragment := range result.Fragments {
+			names = append(names, fragment.Content)
+		}
+		sizes = append(sizes, len(result.Fragments))
+		if result.Next == nil {
+			return names, sizes
+		}
+		filter.Cursor = result.Next
+		if between != nil {
+			between(page)
+		}
+	}
+}
+
+func TestFragmentList(t *testing.T) {
+	f := seedConversations(t, NewMemoryStores(context.Background()), listFragments())
+
+	tests := []struct {
+		name   string
+		filter FragmentFilter
+		want   []string
+		sizes  []int
+	}{
+		{
+			name:   "all",
+			filter: FragmentFilter{Limit: 3},
+			want:   []string{"f0", "f1", "b0", "f2", "f4", "tie-a", "tie-b", "f5", "f6", "f7"},
+			sizes:  []int{3, 3, 3, 1},
+		},
+		{
+			name:   "descending",
+			filter: FragmentFilter{Limit: 4, Descending: true},
+			want:   []string{"f7", "f6", "f5", "tie-b", "tie-a", "f4", "f2", "b0", "f1", "f0"},
+			sizes:  []int{4, 4, 2},
+		},
+		{
+			name:   "exact pages",
+			filter: FragmentFilter{Limit: 5},
+			want:   []string{"f0", "f1", "b0", "f2", "f4", "tie-a", "tie-b", "f5", "f6", "f7"},
+			sizes:  []int{5, 5},
+		},
+		{
+			name:   "default limit",
+			filter: FragmentFilter{},
+			want:   []string{"f0", "f1", "b0", "f2", "f4", "tie-a", "tie-b", "f5", "f6", "f7"},
+			sizes:  []int{10},
+		},
+		{
+			name:   "session",
+			filter: FragmentFilter{SessionID: f.session.ID, Limit: 4},
+			want:   []string{"f0", "f1", "f2", "f4", "tie-a", "tie-b", "f5", "f6", "f7"},
+			sizes:  []int{4, 4, 1},
+		},
+		{
+			name:   "actor",
+			filter: FragmentFilter{ActorID: f.other.ID, Limit: 4},
+			want:   []string{"b0"},
+			sizes:  []int{1},
+		},
+		{
+			name:   "time range",
+			filter: FragmentFilter{After: f.start.Add(time.Minute), Before: f.start.Add(5 * time.Minute), Limit: 2},
+			want:   []string{"b0", "f2", "f4", "tie-a", "tie-b"},
+			sizes:  []int{2, 2, 1},
+		},
+		{
+			name:   "metadata",
+			filter: FragmentFilter{SessionID: f.session.ID, Metadata: db.Metadata{"platform": "discord"}, Limit: 2},
+			want:   []string{"f0", "f2", "f4", "f6"},
+			sizes:  []int{2, 2},
+		},
+		{
+			name:   "include deleted",
+			filter: FragmentFilter{SessionID: f.session.ID, Metadata: db.Metadata{"platform": "slack"}, IncludeDeleted: true, Limit: 3},
+			want:   []string{"f1", "f3", "f5", "f7"},
+			sizes:  []int{3, 1},
+		},
+		{
+			name:   "no match",
+			filter: FragmentFilter{Metadata: db.Metadata{"platform": "irc"}},
+			sizes:  []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, sizes := listAll(t, f.store, tt.filter, nil)
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("fragments = %v, want %v", names, tt.want)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.sizes) {
+				t.Errorf("page sizes = %v, want %v", sizes, tt.sizes)
+			}
+		})
+	}
+}
+
+func TestFragmentListInsertedMidIteration(t *testing.T) {
+	// Fragments created after the first page, at these offsets from the start
+	inserted := map[string]time.Duration{
+		"t-60m":  -time.Hour,
+		"t+0.5m": 30 * time.Second,
+		"t+6.5m": 6*time.Minute + 30*time.Second,
+		"t+60m":  time.Hour,
+	}
+
+	tests := []struct {
+		name       string
+		descending bool
+		// want are the fragments listed, which include those inserted beyond
+		// the first page's cursor only
+		want []string
+	}{
+		{
+			name: "ascending",
+			want: []string{"f0", "f1", "f2", "f4", "tie-a", "tie-b", "f5", "f6", "t+6.5m", "f7", "t+60m"},
+		},
+		{
+			name:       "descending",
+			descending: true,
+			want:       []string{"f7", "f6", "f5", "tie-b", "tie-a", "f4", "f2", "f1", "t+0.5m", "f0", "t-60m"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := seedConversations(t, NewMemoryStores(context.Background()), listFragments())
+			filter := FragmentFilter{SessionID: f.session.ID, Descending: tt.descending, Limit: 3}
+			names, _ := listAll(t, f.store, filter, func(page int) {
+				if page == 0 {
+					for name, offset := range inserted {
+						f.add(t, seedFragment{name: name, at: offset})
+					}
+				}
+			})
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("fragments = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestFragmentListQuery(t *testing.T) {
+	cursor := &FragmentCursor{CreatedAt: time.Now(), ID: id.New()}
+	tests := []struct {
+		name   string
+		filter FragmentFilter
+		want   []string
+	}{
+		{
+			name:   "first page",
+			filter: FragmentFilter{Limit: 10},
+			want:   []string{`"deleted_at" IS NULL`, "ORDER BY created_at, id", "LIMIT $1"},
+		},
+		{
+			name:   "ascending cursor",
+			filter: FragmentFilter{SessionID: id.New(), Cursor: cursor, Limit: 10},
+			want:   []string{"session_id = $1", "(created_at, id) > ($2, $3)", "ORDER BY created_at, id", "LIMIT $4"},
+		},
+		{
+			name:   "descending cursor",
+			filter: FragmentFilter{Descending: true, Cursor: cursor, Limit: 10},
+			want:   []string{"(created_at, id) < ($1, $2)", "ORDER BY created_at DESC, id DESC", "LIMIT $3"},
+		},
+		{
+			name:   "metadata and deleted",
+			filter: FragmentFilter{Metadata: db.Metadata{"platform": "discord"}, IncludeDeleted: true, Limit: 10},
+			want:   []string{"metadata @> $1::jsonb", "ORDER BY created_at, id", "LIMIT $2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, statement := capturingFragmentStore(t)
+			if _, err := store.List(tt.filter); err != nil {
+				t.Fatal(err)
+			}
+			sql := checkQuery(t, *statement, tt.want)
+			if tt.filter.IncludeDeleted && strings.Contains(sql, "deleted_at") {
+				t.Errorf("query %s leaves out deleted fragments", sql)
+			}
+			// One more fragment than the page is fetched to know whether
+			// there is a next page
+			if vars := (*statement).Vars; vars[len(vars)-1] != 11 {
+				t.Errorf("limit = %v, want 11", vars[len(vars)-1])
+			}
+		})
+	}
+}
+
+func TestFragmentCursor(t *testing.T) {
+	cursor := FragmentCursor{CreatedAt: time.Date(2026, 1, 1, 12, 0, 0, 123456789, time.FixedZone("CET", 3600)), ID: id.New()}
+	parsed, err := ParseFragmentCursor(cursor.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.CreatedAt.Equal(cursor.CreatedAt) || parsed.ID != cursor.ID {
+		t.Errorf("parsed cursor = %+v, want %+v", parsed, cursor)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "not base64", token: "not a cursor!"},
+		{name: "no separator", token: base64.RawURLEncoding.EncodeToString([]byte("2026-01-01T12:00:00Z"))},
+		{name: "no ID", token: base64.RawURLEncoding.EncodeToString([]byte("2026-01-01T12:00:00Z|"))},
+		{name: "invalid time", token: base64.RawURLEncoding.EncodeToString([]byte("yesterday|abc"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseFragmentCursor(tt.token); err == nil {
+				t.Errorf("ParseFragmentCursor(%q) succeeded", tt.token)
+			}
+		})
+	}
+}
+
+func TestFragmentStoreNotFound(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		store := bundle.Fragments(db.FragmentTableInteraction)
+		actor, session := batchConversation(t, bundle)
+		fragment := batchFragment(actor, session, "summary")
+		fragment.Metadata = db.Metadata{"type": "summary"}
+		if err := store.Create(fragment); err != nil {
+			t.Fatal(err)
+		}
+		deleted := batchFragment(actor, session, "deleted")
+		if err := store.Create(deleted); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(deleted.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name     string
+			call     func() error
+			notFound bool
+		}{
+			{name: "GetByID", call: func() error {
+				_, err := store.GetByID(fragment.ID)
+				return err
+			}},
+			{name: "GetByID missing", notFound: true, call: func() error {
+				_, err := store.GetByID(id.New())
+				return err
+			}},
+			{name: "GetByID deleted", notFound: true, call: func() error {
+				_, err := store.GetByID(deleted.ID)
+				return err
+			}},
+			{name: "GetLatestByMetadata", call: func() error {
+				_, err := store.GetLatestByMetadata(session.ID, "type", "summary")
+				return err
+			}},
+			{name: "GetLatestByMetadata other value", notFound: true, call: func() error {
+				_, err := store.GetLatestByMetadata(session.ID, "type", "insight")
+				return err
+			}},
+			{name: "GetLatestByMetadata other session", notFound: true, call: func() error {
+				_, err := store.GetLatestByMetadata(id.New(), "type", "summary")
+				return err
+			}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.call()
+				if tt.notFound && !errors.Is(err, ErrNotFound) {
+					t.Errorf("error = %v, want ErrNotFound", err)
+				}
+				if !tt.notFound && err != nil {
+					t.Errorf("error = %v, want none", err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,740 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// NewMemoryStores returns a bundle of stores keeping their records in memory
+// instead of Postgres, to try the package or test managers without a database:
+//
+//	memory := stores.NewMemoryStores(ctx)
+//	eng, err := engine.New(
+//	    engine.WithContext(ctx),
+//	    engine.WithDB(memory.DB()),
+//	    engine.WithActorStore(memory.Actors()),
+//	    engine.WithSessionStore(memory.Sessions()),
+//	    engine.WithInteractionFragmentStore(memory.Fragments(db.FragmentTableInteraction)),
+//	    ...
+//	)
+//
+// The stores of the bundle share one dataset, safe for concurrent use, and
+// behave like their Postgres counterparts on small datasets, with these
+// approximations:
+//
+//   - Similarity searches compare every embedding, as an exact index would;
+//     the results match pgvector's up to float rounding.
+//   - Metadata values are compared by their JSON text, so 1.0 and 1 are the
+//     same number, and containment filters follow jsonb's @> rules on decoded
+//     JSON.
+//   - Full text search splits words on non-alphanumeric characters without
+//     stemming or stop words, whatever the language, and ranks by the number
+//     of occurrences of the query terms rather than ts_rank_cd.
+//   - Transactions aren't supported: stores bound to a transaction keep
+//     writing to the dataset, and rolled back writes are kept.
+//
+// DB returns a database that builds queries without running them, for the
+// engine features that query it directly.
+func NewMemoryStores(ctx context.Context) *Stores {
+	return &Stores{
+		db:  offlineDatabase(),
+		ctx: ctx,
+		mem: newMemoryDB(),
+	}
+}
+
+// offlineDatabase returns a database that builds queries without executing them
+func offlineDatabase() *gorm.DB {
+	database, err := gorm.Open(postgres.New(postgres.Config{}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		// Opening without a connection only fails on invalid configuration
+		panic(fmt.Sprintf("failed to open offline database: %v", err))
+	}
+	return database
+}
+
+// memoryDB is the dataset of memory stores, as a tenant sees it if scoped
+type memoryDB struct {
+	*memoryData
+	tenant id.ID
+}
+
+// memoryData is the dataset shared by the views of memory stores
+type memoryData struct {
+	mu        sync.RWMutex
+	actors    map[id.ID]db.Actor
+	sessions  map[id.ID]db.Session
+	schedules map[id.ID]db.ScheduledResponse
+	toolCalls map[id.ID]db.ToolCall
+	// usage is keyed by the tenant prefixed scope, see UsageStore
+	usage map[string]db.TokenUsage
+	// participants are keyed by session, then actor
+	participants map[id.ID]map[id.ID]db.SessionActor
+	fragments    map[db.FragmentTable]map[id.ID]db.Fragment
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{memoryData: &memoryData{
+		actors:    make(map[id.ID]db.Actor),
+		sessions:  make(map[id.ID]db.Session),
+		schedules: make(map[id.ID]db.ScheduledResponse),
+		toolCalls: make(map[id.ID]db.ToolCall),
+		usage:     make(map[string]db.TokenUsage),
+
+		participants: make(map[id.ID]map[id.ID]db.SessionActor),
+		fragments:    make(map[db.FragmentTable]map[id.ID]db.Fragment),
+	}}
+}
+
+// forTenant returns the view of the dataset of a tenant, or all of it for an
+// empty ID. A nil dataset stays nil.
+func (m *memoryDB) forTenant(tenantID id.ID) *memoryDB {
+	if m == nil {
+		return nil
+	}
+	return &memoryDB{memoryData: m.memoryData, tenant: tenantID}
+}
+
+// visible reports whether a record of a tenant is in the view
+func (m *memoryDB) visible(tenantID *id.ID) bool {
+	return m.tenant == "" || (tenantID != nil && *tenantID == m.tenant)
+}
+
+// errOtherTenant is the error of memory writes to a record outside the view
+func errOtherTenant(record string, recordID id.ID) error {
+	return fmt.Errorf("%s %s: %w", record, recordID, ErrOtherTenant)
+}
+
+// errDuplicateKey is the error of memory inserts of an existing ID
+func errDuplicateKey(record string, recordID id.ID) error {
+	return fmt.Errorf("duplicate key: %s %s already exists", record, recordID)
+}
+
+// storedMetadata returns metadata as the database would store and read it back,
+// through its JSON encoding
+func storedMetadata(metadata db.Metadata) (db.Metadata, error) {
+	encoded, err := metadata.Value()
+	if err != nil {
+		return nil, err
+	}
+	var stored db.Metadata
+	if err := stored.Scan(encoded); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// stamp sets the timestamps gorm sets on insert
+func stamp(createdAt, updatedAt *time.Time) {
+	now := time.Now()
+	if createdAt.IsZero() {
+		*createdAt = now
+	}
+	if updatedAt.IsZero() {
+		*updatedAt = now
+	}
+}
+
+// Actors
+
+func (m *memoryDB) createActor(actor *db.Actor, upsert bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, exists := m.actors[actor.ID]
+	if exists && !m.visible(existing.TenantID) {
+		return errOtherTenant("actor", actor.ID)
+	}
+	if exists && !upsert {
+		return errDuplicateKey("actor", actor.ID)
+	}
+	metadata, err := storedMetadata(actor.Metadata)
+	if err != nil {
+		return fmt.Errorf("invalid actor metadata: %w", err)
+	}
+	stamp(&actor.CreatedAt, &actor.UpdatedAt)
+	if exists {
+		actor.CreatedAt = existing.CreatedAt
+		merged := existing.Metadata.Clone()
+		if merged == nil {
+			merged = db.Metadata{}
+		}
+		for key, value := range metadata {
+			merged[key] = value
+		}
+		metadata = merged
+	}
+	stored := *actor
+	stored.Metadata = metadata
+	m.actors[actor.ID] = stored
+	return nil
+}
+
+// patchActor merges metadata into a live actor's
+func (m *memoryDB) patchActor(actorID id.ID, patch db.Metadata) error {
+	patch, err := storedMetadata(patch)
+	if err != nil {
+		return fmt.Errorf("invalid actor metadata: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actor, ok := m.actors[actorID]
+	if !ok || actor.DeletedAt.Valid || !m.visible(actor.TenantID) {
+		return fmt.Errorf("actor %s: %w", actorID, ErrNotFound)
+	}
+	actor.Metadata = actor.Metadata.Clone()
+	if actor.Metadata == nil {
+		actor.Metadata = db.Metadata{}
+	}
+	for key, value := range patch {
+		actor.Metadata[key] = value
+	}
+	actor.UpdatedAt = time.Now()
+	m.actors[actorID] = actor
+	return nil
+}
+
+// findActors returns the live actors whose metadata contains filter, oldest
+// first
+func (m *memoryDB) findActors(filter db.Metadata) ([]db.Actor, error) {
+	filter, err := storedMetadata(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actors by metadata: %w", err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var actors []db.Actor
+	for _, actor := range m.actors {
+		if actor.DeletedAt.Valid || !m.visible(actor.TenantID) || !metadataContains(actor.Metadata, filter) {
+			continue
+		}
+		actor.Metadata = actor.Metadata.Clone()
+		actors = append(actors, actor)
+	}
+	sort.Slice(actors, func(i, j int) bool {
+		if !actors[i].CreatedAt.Equal(actors[j].CreatedAt) {
+			return actors[i].CreatedAt.Before(actors[j].CreatedAt)
+		}
+		return actors[i].ID < actors[j].ID
+	})
+	return actors, nil
+}
+
+func (m *memoryDB) getActor(actorID id.ID) (*db.Actor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	actor, ok := m.actors[actorID]
+	if !ok || actor.DeletedAt.Valid || !m.visible(actor.TenantID) {
+		return nil, fmt.Errorf("actor %v: %w", actorID, ErrNotFound)
+	}
+	actor.Metadata = actor.Metadata.Clone()
+	return &actor, nil
+}
+
+// mergeActors merges live actors into keepID at mergedAt, see ActorStore.Merge
+func (m *memoryDB) mergeActors(keepID id.ID, mergeIDs []id.ID, mergedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var actors []db.Actor
+	for _, actorID := range append([]id.ID{keepID}, mergeIDs...) {
+		if actor, ok := m.actors[actorID]; ok && !actor.DeletedAt.Valid && m.visible(actor.TenantID) {
+			actors = append(actors, actor)
+		}
+	}
+	keep, merged, err := splitMergedActors(actors, keepID, mergeIDs)
+	if err != nil {
+		return err
+	}
+	metadata, err := storedMetadata(mergedActorMetadata(keep, merged, mergedAt))
+	if err != nil {
+		return fmt.Errorf("invalid actor metadata: %w", err)
+	}
+
+	isMerged := make(map[id.ID]bool, len(mergeIDs))
+	for _, mergeID := range mergeIDs {
+		isMerged[mergeID] = true
+	}
+	for _, fragments := range m.fragments {
+		for fragmentID, fragment := range fragments {
+			if isMerged[fragment.ActorID] && m.visible(fragment.TenantID) {
+				fragment.ActorID = keepID
+				fragments[fragmentID] = fragment
+			}
+		}
+	}
+	for _, participants := range m.participants {
+		for _, mergeID := range mergeIDs {
+			participant, ok := participants[mergeID]
+			if !ok {
+				continue
+			}
+			delete(participants, mergeID)
+			kept, ok := participants[keepID]
+			if !ok {
+				kept = db.SessionActor{SessionID: participant.SessionID, ActorID: keepID, FirstSeenAt: participant.FirstSeenAt}
+			}
+			if participant.FirstSeenAt.Before(kept.FirstSeenAt) {
+				kept.FirstSeenAt = participant.FirstSeenAt
+			}
+			if participant.LastSeenAt.After(kept.LastSeenAt) {
+				kept.LastSeenAt = participant.LastSeenAt
+			}
+			participants[keepID] = kept
+		}
+	}
+
+	keep.Metadata = metadata
+	keep.UpdatedAt = mergedAt
+	m.actors[keepID] = keep
+	for _, actor := range merged {
+		actor.DeletedAt = gorm.DeletedAt{Time: mergedAt, Valid: true}
+		m.actors[actor.ID] = actor
+	}
+	return nil
+}
+
+// actorOf returns a copy of an actor to preload, or nil. Must be called with
+// the lock held.
+func (m *memoryDB) actorOf(actorID id.ID) *db.Actor {
+	actor, ok := m.actors[actorID]
+	if !ok || actor.DeletedAt.Valid || !m.visible(actor.TenantID) {
+		return nil
+	}
+	actor.Metadata = actor.Metadata.Clone()
+	return &actor
+}
+
+// Sessions
+
+func (m *memoryDB) createSession(session *db.Session, upsert bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session.ID == "" {
+		session.ID = id.New()
+	}
+	existing, exists := m.sessions[session.ID]
+	if exists && !m.visible(existing.TenantID) {
+		return errOtherTenant("session", session.ID)
+	}
+	if exists && !upsert {
+		return errDuplicateKey("session", session.ID)
+	}
+	metadata, err := storedMetadata(session.Metadata)
+	if err != nil {
+		return fmt.Errorf("invalid session metadata: %w", err)
+	}
+	stamp(&session.CreatedAt, &session.UpdatedAt)
+	if exists {
+		session.CreatedAt = existing.CreatedAt
+	}
+	stored := *session
+	stored.Metadata = metadata
+	m.sessions[session.ID] = stored
+	return nil
+}
+
+func (m *memoryDB) getSession(sessionID id.ID) (*db.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.DeletedAt.Valid || !m.visible(session.TenantID) {
+		return nil, fmt.Errorf("session %v: %w", sessionID, ErrNotFound)
+	}
+	session.Metadata = session.Metadata.Clone()
+	return &session, nil
+}
+
+// sessionOf returns a copy of a session to preload, or nil. Must be called
+// with the lock held.
+func (m *memoryDB) sessionOf(sessionID id.ID) *db.Session {
+	session, ok := m.sessions[sessionID]
+	if !ok || session.DeletedAt.Valid || !m.visible(session.TenantID) {
+		return nil
+	}
+	session.Metadata = session.Metadata.Clone()
+	return &session
+}
+
+func (m *memoryDB) touchSession(sessionID id.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	session, exists := m.sessions[sessionID]
+	if exists && !m.visible(session.TenantID) {
+		return errOtherTenant("session", sessionID)
+	}
+	if !exists {
+		session = db.Session{ID: sessionID, Metadata: db.Metadata{}, CreatedAt: now}
+		if m.tenant != "" {
+			tenantID := m.tenant
+			session.TenantID = &tenantID
+		}
+	}
+	session.LastActivityAt = now
+	session.UpdatedAt = now
+	m.sessions[sessionID] = session
+	return nil
+}
+
+// updateSession applies fn to a live session, reporting whether fn changed
+// it. A missing session is an error matching ErrNotFound.
+func (m *memoryDB) updateSession(sessionID id.ID, fn func(*db.Session) bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.DeletedAt.Valid || !m.visible(session.TenantID) {
+		return false, fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	session.Metadata = session.Metadata.Clone()
+	if !fn(&session) {
+		return false, nil
+	}
+	session.UpdatedAt = time.Now()
+	m.sessions[sessionID] = session
+	return true, nil
+}
+
+func (m *memoryDB) listSessions(query SessionQuery) []db.Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sessions := make([]db.Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if session.DeletedAt.Valid || !m.visible(session.TenantID) || (!query.IncludeClosed && session.IsClosed()) {
+			continue
+		}
+		session.Metadata = session.Metadata.Clone()
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastActivityAt.Equal(sessions[j].LastActivityAt) {
+			return sessions[i].LastActivityAt.After(sessions[j].LastActivityAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return page(sessions, query.Offset, query.Limit)
+}
+
+func (m *memoryDB) addParticipant(sessionID, actorID id.ID, seenAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tenant != "" {
+		if session, ok := m.sessions[sessionID]; !ok || !m.visible(session.TenantID) {
+			return errOtherTenant("session", sessionID)
+		}
+	}
+
+	actors := m.participants[sessionID]
+	if actors == nil {
+		actors = make(map[id.ID]db.SessionActor)
+		m.participants[sessionID] = actors
+	}
+	participant, exists := actors[actorID]
+	if !exists {
+		participant = db.SessionActor{SessionID: sessionID, ActorID: actorID, FirstSeenAt: seenAt}
+	}
+	if seenAt.After(participant.LastSeenAt) {
+		participant.LastSeenAt = seenAt
+	}
+	actors[actorID] = participant
+	return nil
+}
+
+// listParticipants returns the participants matching match, most recently
+// seen first, with their actors or sessions loaded if requested
+func (m *memoryDB) listParticipants(match func(db.SessionActor) bool, preloadActor, preloadSession bool) []db.SessionActor {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var participants []db.SessionActor
+	for sessionID, actors := range m.participants {
+		if m.tenant != "" && !m.visible(m.sessions[sessionID].TenantID) {
+			continue
+		}
+		for _, participant := range actors {
+			if !match(participant) {
+				continue
+			}
+			if preloadActor {
+				participant.Actor = m.actorOf(participant.ActorID)
+			}
+			if preloadSession {
+				participant.Session = m.sessionOf(participant.SessionID)
+			}
+			participants = append(participants, participant)
+		}
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		a, b := participants[i], participants[j]
+		if !a.LastSeenAt.Equal(b.LastSeenAt) {
+			return a.LastSeenAt.After(b.LastSeenAt)
+		}
+		if a.SessionID != b.SessionID {
+			return a.SessionID < b.SessionID
+		}
+		return a.ActorID < b.ActorID
+	})
+	return participants
+}
+
+// sessionsByActivity returns the live sessions with fragments in a table,
+// ordered by their newest fragment, at most limit of them if positive
+func (m *memoryDB) sessionsByActivity(table db.FragmentTable, limit int) []SessionActivity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	activity := make(map[id.ID]*SessionActivity)
+	var sessions []*SessionActivity
+	for _, fragment := range m.fragments[table] {
+		if fragment.DeletedAt.Valid || !m.visible(fragment.TenantID) {
+			continue
+		}
+		entry, ok := activity[fragment.SessionID]
+		if !ok {
+			session := m.sessionOf(fragment.SessionID)
+			if session == nil {
+				continue
+			}
+			entry = &SessionActivity{Session: *session}
+			activity[fragment.SessionID] = entry
+			sessions = append(sessions, entry)
+		}
+		entry.Fragments++
+		if fragment.CreatedAt.After(entry.LastFragmentAt) {
+			entry.LastFragmentAt = fragment.CreatedAt
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastFragmentAt.Equal(sessions[j].LastFragmentAt) {
+			return sessions[i].LastFragmentAt.After(sessions[j].LastFragmentAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	results := make([]SessionActivity, 0, len(sessions))
+	for _, entry := range page(sessions, 0, limit) {
+		results = append(results, *entry)
+	}
+	return results
+}
+
+// page returns the items after offset, at most limit of them if positive
+func page[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// Schedules
+
+func (m *memoryDB) createSchedule(schedule *db.ScheduledResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.schedules[schedule.ID]; exists {
+		return errDuplicateKey("scheduled response", schedule.ID)
+	}
+	if m.tenant != "" {
+		if session, ok := m.sessions[schedule.SessionID]; !ok || !m.visible(session.TenantID) {
+			return errOtherTenant("session", schedule.SessionID)
+		}
+	}
+	data, err := storedMetadata(schedule.Data)
+	if err != nil {
+		return fmt.Errorf("invalid scheduled response data: %w", err)
+	}
+	stamp(&schedule.CreatedAt, &schedule.UpdatedAt)
+	stored := *schedule
+	stored.Data = data
+	m.schedules[schedule.ID] = stored
+	return nil
+}
+
+// updateSchedules applies fn to the scheduled responses matching match in run
+// order, at most limit of them if positive, and returns the updated ones
+func (m *memoryDB) updateSchedules(match func(db.ScheduledResponse) bool, limit int, fn func(*db.ScheduledResponse)) []db.ScheduledResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := m.findSchedules(match)
+	matched = page(matched, 0, limit)
+	now := time.Now()
+	for i := range matched {
+		fn(&matched[i])
+		matched[i].UpdatedAt = now
+		m.schedules[matched[i].ID] = matched[i]
+	}
+	return matched
+}
+
+func (m *memoryDB) listSchedules(match func(db.ScheduledResponse) bool) []db.ScheduledResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.findSchedules(match)
+}
+
+// findSchedules returns copies of the scheduled responses matching match in
+// run order. Must be called with the lock held.
+func (m *memoryDB) findSchedules(match func(db.ScheduledResponse) bool) []db.ScheduledResponse {
+	var schedules []db.ScheduledResponse
+	for _, schedule := range m.schedules {
+		if !match(schedule) {
+			continue
+		}
+		if m.tenant != "" && !m.visible(m.sessions[schedule.SessionID].TenantID) {
+			continue
+		}
+		schedule.Data = schedule.Data.Clone()
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].RunAt.Equal(schedules[j].RunAt) {
+			return schedules[i].RunAt.Before(schedules[j].RunAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+// Tool calls
+
+func (m *memoryDB) createToolCall(call *db.ToolCall) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.toolCalls[call.ID]; exists {
+		return errDuplicateKey("tool call", call.ID)
+	}
+	return m.writeToolCall(call)
+}
+
+func (m *memoryDB) upsertToolCall(call *db.ToolCall) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, exists := m.toolCalls[call.ID]; exists && m.tenant != "" && !m.visible(m.sessions[existing.SessionID].TenantID) {
+		return errOtherTenant("tool call", call.ID)
+	}
+	return m.writeToolCall(call)
+}
+
+// writeToolCall stores a tool call. Must be called with the lock held.
+func (m *memoryDB) writeToolCall(call *db.ToolCall) error {
+	if m.tenant != "" {
+		if session, ok := m.sessions[call.SessionID]; !ok || !m.visible(session.TenantID) {
+			return errOtherTenant("session", call.SessionID)
+		}
+	}
+	arguments, err := storedMetadata(call.Arguments)
+	if err != nil {
+		return fmt.Errorf("invalid tool call arguments: %w", err)
+	}
+	if call.CreatedAt.IsZero() {
+		call.CreatedAt = time.Now()
+	}
+	stored := *call
+	stored.Arguments = arguments
+	stored.FragmentID = cloneFragmentID(call.FragmentID)
+	m.toolCalls[call.ID] = stored
+	return nil
+}
+
+// listToolCalls returns copies of the tool calls matching match, oldest first
+// or newest first if newestFirst, at most limit of them if positive
+func (m *memoryDB) listToolCalls(match func(db.ToolCall) bool, newestFirst bool, limit int) []db.ToolCall {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var calls []db.ToolCall
+	for _, call := range m.toolCalls {
+		if !match(call) {
+			continue
+		}
+		if m.tenant != "" && !m.visible(m.sessions[call.SessionID].TenantID) {
+			continue
+		}
+		call.Arguments = call.Arguments.Clone()
+		call.FragmentID = cloneFragmentID(call.FragmentID)
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		a, b := calls[i], calls[j]
+		if newestFirst {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return page(calls, 0, limit)
+}
+
+// toolCallStats summarizes the tool calls made at or after since, see Stats
+func (m *memoryDB) toolCallStats(since time.Time) []ToolCallStats {
+	calls := m.listToolCalls(func(call db.ToolCall) bool {
+		return since.IsZero() || !call.CreatedAt.Before(since)
+	}, false, 0)
+
+	byName := make(map[string]*ToolCallStats)
+	total := make(map[string]time.Duration)
+	for _, call := range calls {
+		stats, ok := byName[call.Name]
+		if !ok {
+			stats = &ToolCallStats{Name: call.Name}
+			byName[call.Name] = stats
+		}
+		stats.Calls++
+		if call.Error != "" {
+			stats.Failures++
+		}
+		if call.Duration > stats.MaxDuration {
+			stats.MaxDuration = call.Duration
+		}
+		total[call.Name] += call.Duration
+	}
+
+	stats := make([]ToolCallStats, 0, len(byName))
+	for name, tool := range byName {
+		tool.AverageDuration = total[name] / time.Duration(tool.Calls)
+		stats = append(stats, *tool)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Calls != stats[j].Calls {
+			return stats[i].Calls > stats[j].Calls
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
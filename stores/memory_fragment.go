@@ -0,0 +1,557 @@
+package stores
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// memoryFragmentFilter selects the fragments of a memory table
+type memoryFragmentFilter struct {
+	includeDeleted bool
+	match          func(db.Fragment) bool
+}
+
+// writeFragment inserts a fragment, or with upsert replaces the one with its
+// ID, keeping the creation time and, if the fragment has none, the embedding.
+// Returns whether it was written, which without upsert it isn't if the ID
+// exists and ignoreConflict is set.
+func (m *memoryDB) writeFragment(table db.FragmentTable, fragment *db.Fragment, upsert, ignoreConflict bool) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := m.fragments[table]
+	if rows == nil {
+		rows = make(map[id.ID]db.Fragment)
+		m.fragments[table] = rows
+	}
+
+	existing, exists := rows[fragment.ID]
+	if exists && !m.visible(existing.TenantID) {
+		return false, errOtherTenant("fragment", fragment.ID)
+	}
+	if exists && !upsert {
+		if ignoreConflict {
+			return false, nil
+		}
+		return false, errDuplicateKey("fragment", fragment.ID)
+	}
+	if n := len(fragment.Embedding.Slice()); n != 0 && n != db.EmbeddingDimensions {
+		return false, fmt.Errorf("expected %d dimensions, not %d", db.EmbeddingDimensions, n)
+	}
+
+	metadata, err := storedMetadata(fragment.Metadata)
+	if err != nil {
+		return false, fmt.Errorf("invalid fragment metadata: %w", err)
+	}
+	stamp(&fragment.CreatedAt, &fragment.UpdatedAt)
+	if fragment.Metadata == nil {
+		fragment.Metadata = db.Metadata{}
+	}
+
+	stored := *fragment
+	stored.Metadata = metadata
+	stored.Embedding = pgvector.NewVector(append([]float32(nil), fragment.Embedding.Slice()...))
+	stored.ParentID = cloneFragmentID(fragment.ParentID)
+	stored.TenantID = cloneFragmentID(fragment.TenantID)
+	stored.Actor, stored.Session = nil, nil
+	if exists {
+		stored.CreatedAt = existing.CreatedAt
+		if len(fragment.Embedding.Slice()) == 0 {
+			stored.Embedding = existing.Embedding
+		}
+	}
+	rows[fragment.ID] = stored
+	return true, nil
+}
+
+// restoreFragment writes an imported fragment, replacing the one with its ID
+// with its timestamps included
+func (m *memoryDB) restoreFragment(table db.FragmentTable, fragment *db.Fragment) error {
+	if _, err := m.writeFragment(table, fragment, true, false); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.fragments[table][fragment.ID]
+	stored.CreatedAt, stored.UpdatedAt, stored.DeletedAt = fragment.CreatedAt, fragment.UpdatedAt, fragment.DeletedAt
+	m.fragments[table][fragment.ID] = stored
+	return nil
+}
+
+// cloneFragmentID copies an optional ID
+func cloneFragmentID(fragmentID *id.ID) *id.ID {
+	if fragmentID == nil {
+		return nil
+	}
+	clone := *fragmentID
+	return &clone
+}
+
+// findFragments returns copies of the fragments of a table matching filter,
+// ordered by creation time and ID, with their actors and sessions loaded if
+// requested
+func (m *memoryDB) findFragments(table db.FragmentTable, filter memoryFragmentFilter, preloadActor, preloadSession bool) []db.Fragment {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var fragments []db.Fragment
+	for _, fragment := range m.fragments[table] {
+		if (fragment.DeletedAt.Valid && !filter.includeDeleted) || !m.visible(fragment.TenantID) {
+			continue
+		}
+		if filter.match != nil && !filter.match(fragment) {
+			continue
+		}
+		fragment.Metadata = fragment.Metadata.Clone()
+		fragment.ParentID = cloneFragmentID(fragment.ParentID)
+		if preloadActor {
+			fragment.Actor = m.actorOf(fragment.ActorID)
+		}
+		if preloadSession {
+			fragment.Session = m.sessionOf(fragment.SessionID)
+		}
+		fragments = append(fragments, fragment)
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragmentBefore(fragments[i], fragments[j])
+	})
+	return fragments
+}
+
+// fragmentBefore orders fragments by creation time and ID
+func fragmentBefore(a, b db.Fragment) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// fragmentAfter reports whether a fragment comes after a position in creation
+// time and ID order
+func fragmentAfter(fragment db.Fragment, createdAt time.Time, fragmentID id.ID) bool {
+	return fragmentBefore(db.Fragment{CreatedAt: createdAt, ID: fragmentID}, fragment)
+}
+
+// reversed returns fragments in reverse order
+func reversed(fragments []db.Fragment) []db.Fragment {
+	for i, j := 0, len(fragments)-1; i < j; i, j = i+1, j-1 {
+		fragments[i], fragments[j] = fragments[j], fragments[i]
+	}
+	return fragments
+}
+
+// updateFragment applies fn to a live fragment of a table, reporting whether
+// fn changed it
+func (m *memoryDB) updateFragment(table db.FragmentTable, fragmentID id.ID, fn func(*db.Fragment) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fragment, ok := m.fragments[table][fragmentID]
+	if !ok || fragment.DeletedAt.Valid || !m.visible(fragment.TenantID) || !fn(&fragment) {
+		return false
+	}
+	fragment.UpdatedAt = time.Now()
+	m.fragments[table][fragmentID] = fragment
+	return true
+}
+
+// deleteFragment soft-deletes a fragment of a table
+func (m *memoryDB) deleteFragment(table db.FragmentTable, fragmentID id.ID) {
+	m.updateFragment(table, fragmentID, func(fragment *db.Fragment) bool {
+		fragment.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		return true
+	})
+}
+
+// removeFragments permanently deletes fragments of a table, returning how many
+// existed
+func (m *memoryDB) removeFragments(table db.FragmentTable, fragmentIDs []id.ID) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed int64
+	for _, fragmentID := range fragmentIDs {
+		if _, ok := m.fragments[table][fragmentID]; ok {
+			delete(m.fragments[table], fragmentID)
+			removed++
+		}
+	}
+	return removed
+}
+
+// thread walks a fragment's parents up to maxDepth of them, returning the
+// chain root first
+func (m *memoryDB) thread(table db.FragmentTable, fragmentID id.ID, maxDepth int) []db.Fragment {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chain []db.Fragment
+	current, ok := m.fragments[table][fragmentID]
+	for depth := 0; ok && !current.DeletedAt.Valid && m.visible(current.TenantID) && depth <= maxDepth; depth++ {
+		fragment := current
+		fragment.Metadata = fragment.Metadata.Clone()
+		fragment.ParentID = cloneFragmentID(fragment.ParentID)
+		fragment.Actor = m.actorOf(fragment.ActorID)
+		chain = append(chain, fragment)
+
+		if current.ParentID == nil {
+			break
+		}
+		current, ok = m.fragments[table][*current.ParentID]
+	}
+	return reversed(chain)
+}
+
+// metadataText returns a metadata value as Postgres' ->> operator does: strings
+// as they are, other values as JSON, and JSON null as SQL NULL
+func metadataText(metadata db.Metadata, key string) (string, bool) {
+	value, ok := metadata[key]
+	if !ok || value == nil {
+		return "", false
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// metadataContains reports whether metadata contains filter as jsonb's @>
+// does: objects contain the keys of the filter with contained values, arrays
+// contain each element of the filter's arrays, and scalars are equal
+func metadataContains(metadata, filter db.Metadata) bool {
+	normalized, err := storedMetadata(filter)
+	if err != nil {
+		return false
+	}
+	return jsonContains(map[string]interface{}(metadata), map[string]interface{}(normalized))
+}
+
+func jsonContains(value, filter interface{}) bool {
+	switch f := filter.(type) {
+	case map[string]interface{}:
+		v, ok := asJSONObject(value)
+		if !ok {
+			return false
+		}
+		for key, fv := range f {
+			vv, ok := v[key]
+			if !ok || !jsonContains(vv, fv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		v, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, fv := range f {
+			found := false
+			for _, vv := range v {
+				if jsonContains(vv, fv) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(value, filter)
+	}
+}
+
+// asJSONObject returns a decoded JSON object as a map
+func asJSONObject(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case db.Metadata:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// vectorDistance returns the pgvector distance between two embeddings, or
+// false if the metric is undefined for them
+func vectorDistance(metric DistanceMetric, a, b []float32) (float64, bool) {
+	if len(a) != len(b) {
+		return 0, false
+	}
+
+	var dot, normA, normB, squared float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		dot += x * y
+		normA += x * x
+		normB += y * y
+		squared += (x - y) * (x - y)
+	}
+
+	switch metric {
+	case DistanceL2:
+		return math.Sqrt(squared), true
+	case DistanceInnerProduct:
+		return -dot, true
+	default:
+		if normA == 0 || normB == 0 {
+			return 0, false
+		}
+		return 1 - dot/math.Sqrt(normA*normB), true
+	}
+}
+
+// vectorNorm returns the Euclidean norm of an embedding
+func vectorNorm(v []float32) float64 {
+	var squared float64
+	for _, x := range v {
+		squared += float64(x) * float64(x)
+	}
+	return math.Sqrt(squared)
+}
+
+// searchSimilar returns the fragments of a table matching filter closest to
+// an embedding, closest first, at most limit of them
+func (m *memoryDB) searchSimilar(table db.FragmentTable, metric DistanceMetric, embedding pgvector.Vector, limit int, maxDistance *float64, filter func(db.Fragment) bool) []FragmentMatch {
+	candidates := m.findFragments(table, memoryFragmentFilter{match: func(fragment db.Fragment) bool {
+		if len(fragment.Embedding.Slice()) == 0 {
+			return false
+		}
+		return filter == nil || filter(fragment)
+	}}, false, false)
+
+	var matches []FragmentMatch
+	for _, fragment := range candidates {
+		distance, ok := vectorDistance(metric, fragment.Embedding.Slice(), embedding.Slice())
+		if !ok || (maxDistance != nil && distance > *maxDistance) {
+			continue
+		}
+		matches = append(matches, FragmentMatch{Fragment: fragment, Distance: distance})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// textTerm is a term of a web search query: a word or a quoted phrase,
+// possibly excluded
+type textTerm struct {
+	words    []string
+	excluded bool
+}
+
+// parseWebSearch parses web search syntax into alternatives of terms that must
+// all match: words and "quoted phrases" are required, -prefixed ones excluded,
+// and "or" separates alternatives
+func parseWebSearch(text string) [][]textTerm {
+	var (
+		alternatives [][]textTerm
+		current      []textTerm
+	)
+
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimSpace(text) {
+		excluded := false
+		if strings.HasPrefix(text, "-") {
+			excluded = true
+			text = text[1:]
+		}
+
+		var raw string
+		if strings.HasPrefix(text, `"`) {
+			end := strings.Index(text[1:], `"`)
+			if end < 0 {
+				raw, text = text[1:], ""
+			} else {
+				raw, text = text[1:end+1], text[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			raw, text = text[:end], text[end:]
+			if strings.EqualFold(raw, "or") && !excluded {
+				if len(current) > 0 {
+					alternatives = append(alternatives, current)
+					current = nil
+				}
+				continue
+			}
+		}
+
+		if words := textWords(raw); len(words) > 0 {
+			current = append(current, textTerm{words: words, excluded: excluded})
+		}
+	}
+	if len(current) > 0 {
+		alternatives = append(alternatives, current)
+	}
+	return alternatives
+}
+
+// textWords splits text into lowercase words
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// occurrences counts the occurrences of a phrase in words
+func occurrences(words, phrase []string) int {
+	count := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matched := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
+
+// textRank returns the number of occurrences of the required terms of the best
+// matching alternative in content, or false if no alternative matches
+func textRank(alternatives [][]textTerm, content string) (float64, bool) {
+	words := textWords(content)
+	best, matched := 0, false
+
+	for _, terms := range alternatives {
+		rank, ok := 0, true
+		for _, term := range terms {
+			n := occurrences(words, term.words)
+			if (n > 0) == term.excluded {
+				ok = false
+				break
+			}
+			rank += n
+		}
+		// An alternative of only exclusions matches nothing, as in Postgres
+		if ok && rank > 0 && (!matched || rank > best) {
+			best, matched = rank, true
+		}
+	}
+	return float64(best), matched
+}
+
+// searchText returns the fragments of a table matching a web search query and
+// filter, best ranked first, at most limit of them
+func (m *memoryDB) searchText(table db.FragmentTable, text string, limit int, filter func(db.Fragment) bool) []FragmentMatch {
+	alternatives := parseWebSearch(text)
+	candidates := m.findFragments(table, memoryFragmentFilter{match: filter}, false, false)
+
+	var matches []FragmentMatch
+	for _, fragment := range candidates {
+		if rank, ok := textRank(alternatives, fragment.Content); ok {
+			matches = append(matches, FragmentMatch{Fragment: fragment, Score: rank})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// match returns the filter as a predicate on memory fragments
+func (f SearchFilter) match(fragment db.Fragment) bool {
+	if f.SessionID != "" && fragment.SessionID != f.SessionID {
+		return false
+	}
+	if f.ActorID != "" && fragment.ActorID != f.ActorID {
+		return false
+	}
+	if !f.After.IsZero() && !fragment.CreatedAt.After(f.After) {
+		return false
+	}
+	if !f.Before.IsZero() && !fragment.CreatedAt.Before(f.Before) {
+		return false
+	}
+	return true
+}
+
+// metadataEquals reports whether metadata holds value under key, as
+// metadata ->> key = value does
+func metadataEquals(metadata db.Metadata, key, value string) bool {
+	text, ok := metadataText(metadata, key)
+	return ok && text == value
+}
+
+// pendingEmbedding reports whether a fragment's embedding is the zero vector
+// its real embedding is still to replace
+func pendingEmbedding(fragment db.Fragment) bool {
+	return len(fragment.Embedding.Slice()) > 0 && vectorNorm(fragment.Embedding.Slice()) == 0
+}
+
+// listMemory is List over a memory table
+func (s *FragmentStore) listMemory(filter FragmentFilter, conditions []*compiledCondition, limit int) *FragmentPage {
+	fragments := s.mem.findFragments(s.table, memoryFragmentFilter{
+		includeDeleted: filter.IncludeDeleted,
+		match: func(fragment db.Fragment) bool {
+			search := SearchFilter{SessionID: filter.SessionID, ActorID: filter.ActorID, After: filter.After, Before: filter.Before}
+			if !search.match(fragment) || !metadataContains(fragment.Metadata, filter.Metadata) || !matchMetadataConditions(fragment.Metadata, conditions) {
+				return false
+			}
+			if filter.Cursor == nil {
+				return true
+			}
+			if filter.Descending {
+				return fragmentBefore(fragment, db.Fragment{CreatedAt: filter.Cursor.CreatedAt, ID: filter.Cursor.ID})
+			}
+			return fragmentAfter(fragment, filter.Cursor.CreatedAt, filter.Cursor.ID)
+		},
+	}, filter.PreloadActor, false)
+	if filter.Descending {
+		fragments = reversed(fragments)
+	}
+
+	page := &FragmentPage{Fragments: fragments}
+	if len(fragments) > limit {
+		page.Fragments = fragments[:limit]
+		last := page.Fragments[limit-1]
+		page.Next = &FragmentCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	return page
+}
+
+// findSimilarMemory is FindSimilar over a memory table
+func (s *FragmentStore) findSimilarMemory(embedding pgvector.Vector, filter SearchFilter, limit int) []SimilarFragment {
+	matches := s.mem.searchSimilar(s.table, DistanceCosine, embedding, limit, nil, filter.match)
+	results := make([]SimilarFragment, len(matches))
+	for i, match := range matches {
+		results[i] = SimilarFragment{Fragment: match.Fragment, Similarity: 1 - match.Distance}
+	}
+	return results
+}
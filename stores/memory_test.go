@@ -0,0 +1,197 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+)
+
+// TestStoreConformance checks that memory stores answer like Postgres ones on
+// small datasets, including for the features they approximate. Each case runs
+// against both when THOR_TEST_DATABASE_URL is set.
+func TestStoreConformance(t *testing.T) {
+	t.Run("similarity", func(t *testing.T) {
+		forEachStores(t, testSimilarityConformance)
+	})
+	t.Run("metadata containment", func(t *testing.T) {
+		forEachStores(t, testMetadataConformance)
+	})
+	t.Run("text search", func(t *testing.T) {
+		forEachStores(t, testTextSearchConformance)
+	})
+}
+
+// similarNames returns the contents of similar fragments with their
+// similarities to 3 decimals, in order
+func similarNames(results []SimilarFragment) string {
+	names := make([]string, len(results))
+	for i, result := range results {
+		names[i] = fmt.Sprintf("%s=%.3f", result.Content, result.Similarity)
+	}
+	return strings.Join(names, " ")
+}
+
+func testSimilarityConformance(t *testing.T, bundle *Stores) {
+	fixture := seedConversations(t, bundle, searchFragments)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+
+	tests := []struct {
+		name   string
+		search func() ([]SimilarFragment, error)
+		want   string
+	}{
+		{
+			// Zero embeddings and fragments without embedding are skipped
+			name: "session",
+			search: func() ([]SimilarFragment, error) {
+				return store.FindSimilar(embedding(1, 0), fixture.session.ID, 10)
+			},
+			want: "same=1.000 close=0.894 wide=0.100",
+		},
+		{
+			name: "all sessions",
+			search: func() ([]SimilarFragment, error) {
+				return store.FindSimilar(embedding(1, 0), "", 2)
+			},
+			want: "same=1.000 close=0.894",
+		},
+		{
+			name: "actor",
+			search: func() ([]SimilarFragment, error) {
+				return store.FindSimilarForActor(embedding(1, 0), fixture.actor.ID, 10)
+			},
+			want: "same=1.000 close=0.894 wide=0.100",
+		},
+		{
+			name: "other actor",
+			search: func() ([]SimilarFragment, error) {
+				return store.FindSimilarForActor(embedding(1, 0), fixture.other.ID, 10)
+			},
+			want: "opposite=-1.000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := tt.search()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := similarNames(results); got != tt.want {
+				t.Errorf("similar fragments = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func testMetadataConformance(t *testing.T, bundle *Stores) {
+	actor, session := batchConversation(t, bundle)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	for name, metadata := range map[string]db.Metadata{
+		"alice": {
+			"platform": "discord",
+			"score":    1,
+			"tags":     []string{"a", "b"},
+			"user":     map[string]interface{}{"name": "alice", "roles": []string{"admin"}},
+		},
+		"bob":   {"platform": "slack", "score": 1.5, "tags": []string{"b"}},
+		"empty": {},
+	} {
+		fragment := batchFragment(actor, session, name)
+		fragment.Metadata = metadata
+		if err := store.Create(fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter db.Metadata
+		want   []string
+	}{
+		{name: "string", filter: db.Metadata{"platform": "discord"}, want: []string{"alice"}},
+		// Numbers compare by value, so 1.0 is 1
+		{name: "number", filter: db.Metadata{"score": 1.0}, want: []string{"alice"}},
+		{name: "array element", filter: db.Metadata{"tags": []string{"b"}}, want: []string{"alice", "bob"}},
+		{name: "array elements", filter: db.Metadata{"tags": []string{"b", "a"}}, want: []string{"alice"}},
+		// Nested arrays only contain arrays, unlike top-level ones
+		{name: "scalar in array", filter: db.Metadata{"tags": "a"}},
+		{name: "nested object", filter: db.Metadata{"user": map[string]interface{}{"name": "alice"}}, want: []string{"alice"}},
+		{name: "nested array", filter: db.Metadata{"user": map[string]interface{}{"roles": []string{"admin"}}}, want: []string{"alice"}},
+		{name: "several keys", filter: db.Metadata{"platform": "slack", "score": 1.5}, want: []string{"bob"}},
+		{name: "missing key", filter: db.Metadata{"channel": "general"}},
+		{name: "null", filter: db.Metadata{"platform": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.List(FragmentFilter{SessionID: session.ID, Metadata: tt.filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, fragment := range page.Fragments {
+				got = append(got, fragment.Content)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("fragments containing %v = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func testTextSearchConformance(t *testing.T, bundle *Stores) {
+	actor, session := batchConversation(t, bundle)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	for _, content := range []string{
+		"the quick brown fox",
+		"a lazy brown dog",
+		"quick quick dog",
+		"nothing to see",
+	} {
+		if err := store.Create(batchFragment(actor, session, content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stemming and stop words are approximated, so the queries use neither.
+	// Ranks are too, so ordered results have clearly different ranks.
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+		// ordered is whether want is in rank order
+		ordered bool
+	}{
+		{name: "word", query: "brown", want: []string{"a lazy brown dog", "the quick brown fox"}},
+		{name: "words", query: "brown dog", want: []string{"a lazy brown dog"}},
+		{name: "case", query: "QUICK", want: []string{"quick quick dog", "the quick brown fox"}, ordered: true},
+		{name: "phrase", query: `"brown fox"`, want: []string{"the quick brown fox"}},
+		{name: "or", query: "fox or lazy", want: []string{"a lazy brown dog", "the quick brown fox"}},
+		{name: "excluded", query: "dog -lazy", want: []string{"quick quick dog"}},
+		{name: "no match", query: "cat"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := store.SearchText(TextSearchQuery{Text: tt.query, Limit: 10, SearchFilter: SearchFilter{SessionID: session.ID}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, match := range matches {
+				got = append(got, match.Content)
+			}
+			if !tt.ordered {
+				sort.Strings(got)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("matches of %q = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
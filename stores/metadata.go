@@ -0,0 +1,296 @@
+package stores
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/velumlabs/thor/db"
+
+	"gorm.io/gorm"
+)
+
+// MetadataOperator compares the metadata value at a path in a MetadataCondition
+type MetadataOperator string
+
+const (
+	MetadataEquals         MetadataOperator = "="        // Equal to a string, number, boolean or null
+	MetadataNotEquals      MetadataOperator = "!="       // Not equal, or missing
+	MetadataExists         MetadataOperator = "exists"   // Present, even if null
+	MetadataGreater        MetadataOperator = ">"        // A number greater than the value
+	MetadataGreaterOrEqual MetadataOperator = ">="       // A number at least the value
+	MetadataLess           MetadataOperator = "<"        // A number less than the value
+	MetadataLessOrEqual    MetadataOperator = "<="       // A number at most the value
+	MetadataContains       MetadataOperator = "contains" // Contains the JSON value, as jsonb @> does
+)
+
+// MetadataCondition is a condition on the metadata value at a path of nested
+// keys. Conditions compile to jsonb operators with their keys and values as
+// query parameters, so they are safe to build from user input. Build them with
+// Meta:
+//
+//	filter := stores.FragmentFilter{
+//	    MetadataWhere: []stores.MetadataCondition{
+//	        stores.Meta("type").Equals("insight"),
+//	        stores.Meta("confidence").GreaterThan(0.8),
+//	    },
+//	}
+//
+// Equality and containment use @>, which the GIN index of
+// db.IndexConfig.Metadata serves. Numeric comparisons only match numbers.
+type MetadataCondition struct {
+	Path     []string
+	Operator MetadataOperator
+	Value    interface{}
+}
+
+// MetadataPath is the path of a metadata value conditions are built on
+type MetadataPath []string
+
+// Meta returns the metadata path of dot separated keys, e.g. "source.platform".
+// Keys containing dots can be given as a MetadataCondition's Path instead.
+func Meta(path string) MetadataPath {
+	return MetadataPath(strings.Split(path, "."))
+}
+
+func (p MetadataPath) condition(operator MetadataOperator, value interface{}) MetadataCondition {
+	return MetadataCondition{Path: p, Operator: operator, Value: value}
+}
+
+// Equals matches a string, number, boolean or nil value
+func (p MetadataPath) Equals(value interface{}) MetadataCondition {
+	return p.condition(MetadataEquals, value)
+}
+
+// NotEquals matches values other than value, and missing values
+func (p MetadataPath) NotEquals(value interface{}) MetadataCondition {
+	return p.condition(MetadataNotEquals, value)
+}
+
+// Exists matches present values, including null
+func (p MetadataPath) Exists() MetadataCondition {
+	return p.condition(MetadataExists, nil)
+}
+
+// GreaterThan matches numbers greater than value
+func (p MetadataPath) GreaterThan(value interface{}) MetadataCondition {
+	return p.condition(MetadataGreater, value)
+}
+
+// GreaterOrEqual matches numbers at least value
+func (p MetadataPath) GreaterOrEqual(value interface{}) MetadataCondition {
+	return p.condition(MetadataGreaterOrEqual, value)
+}
+
+// LessThan matches numbers less than value
+func (p MetadataPath) LessThan(value interface{}) MetadataCondition {
+	return p.condition(MetadataLess, value)
+}
+
+// LessOrEqual matches numbers at most value
+func (p MetadataPath) LessOrEqual(value interface{}) MetadataCondition {
+	return p.condition(MetadataLessOrEqual, value)
+}
+
+// Contains matches values containing a JSON value, e.g. an array holding an
+// element or an object holding some keys
+func (p MetadataPath) Contains(value interface{}) MetadataCondition {
+	return p.condition(MetadataContains, value)
+}
+
+// compiledCondition is a metadata condition as a SQL condition with its
+// arguments, and as a predicate on memory metadata
+type compiledCondition struct {
+	sql   string
+	args  []interface{}
+	match func(metadata db.Metadata) bool
+}
+
+// compile checks a condition and compiles it
+func (c MetadataCondition) compile() (*compiledCondition, error) {
+	if len(c.Path) == 0 {
+		return nil, fmt.Errorf("metadata condition has no path")
+	}
+	for _, key := range c.Path {
+		if key == "" {
+			return nil, fmt.Errorf("metadata path %q has an empty key", strings.Join(c.Path, "."))
+		}
+	}
+
+	// The value at the path: metadata -> ? -> ?
+	value := "metadata" + strings.Repeat(" -> ?", len(c.Path))
+	pathArgs := make([]interface{}, len(c.Path))
+	for i, key := range c.Path {
+		pathArgs[i] = key
+	}
+
+	switch c.Operator {
+	case MetadataEquals, MetadataNotEquals:
+		if kind := reflect.ValueOf(c.Value).Kind(); kind == reflect.Map || kind == reflect.Slice || kind == reflect.Array || kind == reflect.Struct {
+			return nil, fmt.Errorf("metadata operator %s compares scalars, use %s for objects and arrays", c.Operator, MetadataContains)
+		}
+		document, err := c.document()
+		if err != nil {
+			return nil, err
+		}
+		compiled := &compiledCondition{
+			sql:  "metadata @> ?::jsonb",
+			args: []interface{}{document},
+			match: func(metadata db.Metadata) bool {
+				return metadataContains(metadata, document)
+			},
+		}
+		if c.Operator == MetadataNotEquals {
+			compiled.sql = "NOT (" + compiled.sql + ")"
+			contains := compiled.match
+			compiled.match = func(metadata db.Metadata) bool {
+				return !contains(metadata)
+			}
+		}
+		return compiled, nil
+
+	case MetadataContains:
+		document, err := c.document()
+		if err != nil {
+			return nil, err
+		}
+		return &compiledCondition{
+			sql:  "metadata @> ?::jsonb",
+			args: []interface{}{document},
+			match: func(metadata db.Metadata) bool {
+				return metadataContains(metadata, document)
+			},
+		}, nil
+
+	case MetadataExists:
+		return &compiledCondition{
+			sql:  "(" + value + ") IS NOT NULL",
+			args: pathArgs,
+			match: func(metadata db.Metadata) bool {
+				_, ok := metadataAt(metadata, c.Path)
+				return ok
+			},
+		}, nil
+
+	case MetadataGreater, MetadataGreaterOrEqual, MetadataLess, MetadataLessOrEqual:
+		bound, ok := toFloat(c.Value)
+		if !ok {
+			return nil, fmt.Errorf("metadata operator %s needs a number, not %T", c.Operator, c.Value)
+		}
+		// Values that aren't numbers compare as NULL rather than failing the cast
+		number := "CASE WHEN jsonb_typeof(" + value + ") = 'number' THEN (" + value + ")::numeric END"
+		args := append(append(append([]interface{}(nil), pathArgs...), pathArgs...), bound)
+		return &compiledCondition{
+			sql:  number + " " + string(c.Operator) + " ?",
+			args: args,
+			match: func(metadata db.Metadata) bool {
+				found, ok := metadataAt(metadata, c.Path)
+				if !ok {
+					return false
+				}
+				n, ok := found.(float64)
+				if !ok {
+					return false
+				}
+				return compareNumbers(c.Operator, n, bound)
+			},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported metadata operator %q", c.Operator)
+	}
+}
+
+// document returns the metadata holding the condition's value at its path, as
+// the database would store it
+func (c MetadataCondition) document() (db.Metadata, error) {
+	var nested interface{} = c.Value
+	for i := len(c.Path) - 1; i > 0; i-- {
+		nested = map[string]interface{}{c.Path[i]: nested}
+	}
+	document, err := storedMetadata(db.Metadata{c.Path[0]: nested})
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata value: %w", err)
+	}
+	return document, nil
+}
+
+// metadataAt returns the decoded JSON value at a path of metadata
+func metadataAt(metadata db.Metadata, path []string) (interface{}, bool) {
+	var current interface{} = map[string]interface{}(metadata)
+	for _, key := range path {
+		object, ok := asJSONObject(current)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// toFloat converts a Go number to a float64
+func toFloat(value interface{}) (float64, bool) {
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		return f, err == nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// compareNumbers applies a numeric metadata operator
+func compareNumbers(operator MetadataOperator, a, b float64) bool {
+	switch operator {
+	case MetadataGreater:
+		return a > b
+	case MetadataGreaterOrEqual:
+		return a >= b
+	case MetadataLess:
+		return a < b
+	default:
+		return a <= b
+	}
+}
+
+// compileMetadataConditions compiles conditions, reporting the first invalid one
+func compileMetadataConditions(conditions []MetadataCondition) ([]*compiledCondition, error) {
+	compiled := make([]*compiledCondition, len(conditions))
+	for i, condition := range conditions {
+		c, err := condition.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata condition %d: %w", i, err)
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// applyMetadataConditions adds compiled conditions to a query
+func applyMetadataConditions(q *gorm.DB, conditions []*compiledCondition) *gorm.DB {
+	for _, condition := range conditions {
+		q = q.Where(condition.sql, condition.args...)
+	}
+	return q
+}
+
+// matchMetadataConditions reports whether metadata meets all compiled conditions
+func matchMetadataConditions(metadata db.Metadata, conditions []*compiledCondition) bool {
+	for _, condition := range conditions {
+		if !condition.match(metadata) {
+			return false
+		}
+	}
+	return true
+}
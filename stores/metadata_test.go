@@ -0,0 +1,270 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+)
+
+// Values and keys trying to break out of the SQL they are compiled to
+const (
+	injectionDrop   = "'; DROP TABLE interaction_fragments; --"
+	injectionOr     = "insight' OR '1'='1"
+	injectionKey    = "a' OR '1'='1"
+	injectionParens = "type') IS NOT NULL OR ('x"
+)
+
+func TestMeta(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "type", want: []string{"type"}},
+		{path: "source.platform", want: []string{"source", "platform"}},
+		{path: injectionDrop, want: []string{injectionDrop}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := Meta(tt.path).Exists().Path
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Meta(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataConditionQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition MetadataCondition
+		// want are parts of the SQL expected, in order
+		want []string
+		// vars are the query parameters expected before the limit
+		vars []interface{}
+	}{
+		{
+			name:      "equals",
+			condition: Meta("type").Equals("insight"),
+			want:      []string{"metadata @> $1::jsonb"},
+			vars:      []interface{}{db.Metadata{"type": "insight"}},
+		},
+		{
+			name:      "not equals",
+			condition: Meta("source.platform").NotEquals("discord"),
+			want:      []string{"NOT (metadata @> $1::jsonb)"},
+			vars:      []interface{}{db.Metadata{"source": map[string]interface{}{"platform": "discord"}}},
+		},
+		{
+			name:      "contains",
+			condition: Meta("tags").Contains([]string{"b"}),
+			want:      []string{"metadata @> $1::jsonb"},
+			vars:      []interface{}{db.Metadata{"tags": []interface{}{"b"}}},
+		},
+		{
+			name:      "exists",
+			condition: Meta("source.platform").Exists(),
+			want:      []string{"(metadata -> $1 -> $2) IS NOT NULL"},
+			vars:      []interface{}{"source", "platform"},
+		},
+		{
+			name:      "greater",
+			condition: Meta("confidence").GreaterThan(0.8),
+			want: []string{
+				"CASE WHEN jsonb_typeof(metadata -> $1) = 'number' THEN (metadata -> $2)::numeric END > $3",
+			},
+			vars: []interface{}{"confidence", "confidence", 0.8},
+		},
+		{
+			name:      "less or equal integer",
+			condition: Meta("score").LessOrEqual(3),
+			want:      []string{"END <= $3"},
+			vars:      []interface{}{"score", "score", 3.0},
+		},
+		{
+			name:      "injection value",
+			condition: Meta("type").Equals(injectionDrop),
+			want:      []string{"metadata @> $1::jsonb"},
+			vars:      []interface{}{db.Metadata{"type": injectionDrop}},
+		},
+		{
+			name:      "injection key",
+			condition: MetadataCondition{Path: []string{injectionKey}, Operator: MetadataExists},
+			want:      []string{"(metadata -> $1) IS NOT NULL"},
+			vars:      []interface{}{injectionKey},
+		},
+		{
+			name:      "injection key in comparison",
+			condition: MetadataCondition{Path: []string{injectionParens}, Operator: MetadataGreater, Value: 0},
+			want:      []string{"jsonb_typeof(metadata -> $1)", "(metadata -> $2)::numeric END > $3"},
+			vars:      []interface{}{injectionParens, injectionParens, 0.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, statement := capturingFragmentStore(t)
+			if _, err := store.List(FragmentFilter{MetadataWhere: []MetadataCondition{tt.condition}, Limit: 10}); err != nil {
+				t.Fatal(err)
+			}
+			sql := checkQuery(t, *statement, tt.want)
+			for _, injection := range []string{injectionDrop, injectionOr, injectionKey, injectionParens} {
+				if strings.Contains(sql, injection) {
+					t.Errorf("query %s has %q in its SQL rather than its parameters", sql, injection)
+				}
+			}
+			vars := (*statement).Vars
+			if len(vars) != len(tt.vars)+1 {
+				t.Fatalf("query parameters = %v, want %v and the limit", vars, tt.vars)
+			}
+			for i, want := range tt.vars {
+				if fmt.Sprint(vars[i]) != fmt.Sprint(want) {
+					t.Errorf("query parameter %d = %v, want %v", i+1, vars[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataConditionInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition MetadataCondition
+		// want is part of the error expected
+		want string
+	}{
+		{name: "no path", condition: MetadataCondition{Operator: MetadataExists}, want: "has no path"},
+		{name: "empty key", condition: Meta("source..platform").Exists(), want: `metadata path "source..platform" has an empty key`},
+		{name: "unsupported operator", condition: MetadataCondition{Path: []string{"type"}, Operator: "LIKE"}, want: `unsupported metadata operator "LIKE"`},
+		{name: "injection operator", condition: MetadataCondition{Path: []string{"type"}, Operator: "= 'x' OR 1=1 --"}, want: "unsupported metadata operator"},
+		{name: "comparison with a string", condition: Meta("confidence").GreaterThan("0.8"), want: "metadata operator > needs a number, not string"},
+		{name: "comparison with nil", condition: Meta("confidence").LessThan(nil), want: "needs a number, not <nil>"},
+		{name: "equals an object", condition: Meta("source").Equals(map[string]interface{}{"platform": "discord"}), want: "use contains for objects and arrays"},
+		{name: "equals an array", condition: Meta("tags").NotEquals([]string{"a"}), want: "use contains for objects and arrays"},
+		{name: "unencodable value", condition: Meta("source").Contains(make(chan int)), want: "invalid metadata value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, statement := capturingFragmentStore(t)
+			conditions := []MetadataCondition{Meta("type").Exists(), tt.condition}
+			_, err := store.List(FragmentFilter{MetadataWhere: conditions})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), "invalid metadata condition 1") {
+				t.Errorf("error %q does not name the invalid condition", err)
+			}
+			if *statement != nil {
+				t.Errorf("query %s was built for an invalid condition", (*statement).SQL.String())
+			}
+
+			memory := NewMemoryStores(context.Background()).Fragments(db.FragmentTableInteraction)
+			if _, err := memory.List(FragmentFilter{MetadataWhere: conditions}); err == nil {
+				t.Error("memory store accepted an invalid condition")
+			}
+		})
+	}
+}
+
+func TestFragmentListMetadataWhere(t *testing.T) {
+	forEachStores(t, testFragmentListMetadataWhere)
+}
+
+func testFragmentListMetadataWhere(t *testing.T, bundle *Stores) {
+	actor, session := batchConversation(t, bundle)
+	store := bundle.Fragments(db.FragmentTableInteraction)
+	for name, metadata := range map[string]db.Metadata{
+		"high": {
+			"type":       "insight",
+			"confidence": 0.9,
+			"source":     map[string]interface{}{"platform": "discord"},
+			"tags":       []string{"a", "b"},
+		},
+		"low":  {"type": "insight", "confidence": 0.5, "tags": []string{"b"}},
+		"note": {"type": "note", "confidence": "0.95", "source": map[string]interface{}{"platform": "slack"}},
+		"null": {"type": nil},
+		"injection": {
+			"type":       injectionDrop,
+			injectionKey: true,
+		},
+		"empty": {},
+	} {
+		fragment := batchFragment(actor, session, name)
+		fragment.Metadata = metadata
+		if err := store.Create(fragment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		conditions []MetadataCondition
+		want       []string
+	}{
+		{name: "equals", conditions: []MetadataCondition{Meta("type").Equals("insight")}, want: []string{"high", "low"}},
+		{
+			name:       "equals and greater",
+			conditions: []MetadataCondition{Meta("type").Equals("insight"), Meta("confidence").GreaterThan(0.8)},
+			want:       []string{"high"},
+		},
+		// "0.95" is a string, so it isn't compared
+		{name: "greater", conditions: []MetadataCondition{Meta("confidence").GreaterThan(0.8)}, want: []string{"high"}},
+		{name: "greater or equal", conditions: []MetadataCondition{Meta("confidence").GreaterOrEqual(0.5)}, want: []string{"high", "low"}},
+		{name: "less", conditions: []MetadataCondition{Meta("confidence").LessThan(0.9)}, want: []string{"low"}},
+		{name: "less or equal", conditions: []MetadataCondition{Meta("confidence").LessOrEqual(0.9)}, want: []string{"high", "low"}},
+		{name: "integer bound", conditions: []MetadataCondition{Meta("confidence").GreaterThan(0)}, want: []string{"high", "low"}},
+		{name: "nested equals", conditions: []MetadataCondition{Meta("source.platform").Equals("discord")}, want: []string{"high"}},
+		{name: "not equals", conditions: []MetadataCondition{Meta("type").NotEquals("insight")}, want: []string{"empty", "injection", "note", "null"}},
+		{name: "equals null", conditions: []MetadataCondition{Meta("type").Equals(nil)}, want: []string{"null"}},
+		{name: "exists", conditions: []MetadataCondition{Meta("type").Exists()}, want: []string{"high", "injection", "low", "note", "null"}},
+		{name: "nested exists", conditions: []MetadataCondition{Meta("source.platform").Exists()}, want: []string{"high", "note"}},
+		{name: "contains array element", conditions: []MetadataCondition{Meta("tags").Contains([]string{"b"})}, want: []string{"high", "low"}},
+		{
+			name:       "contains object",
+			conditions: []MetadataCondition{Meta("source").Contains(map[string]interface{}{"platform": "slack"})},
+			want:       []string{"note"},
+		},
+		// Injection attempts match the strings themselves, never more rows
+		{name: "injection value", conditions: []MetadataCondition{Meta("type").Equals(injectionDrop)}, want: []string{"injection"}},
+		{name: "injection tautology", conditions: []MetadataCondition{Meta("type").Equals(injectionOr)}},
+		{name: "injection not equals", conditions: []MetadataCondition{Meta("type").NotEquals(injectionOr)}, want: []string{"empty", "high", "injection", "low", "note", "null"}},
+		{
+			name:       "injection key",
+			conditions: []MetadataCondition{{Path: []string{injectionKey}, Operator: MetadataExists}},
+			want:       []string{"injection"},
+		},
+		{name: "injection key closing parens", conditions: []MetadataCondition{{Path: []string{injectionParens}, Operator: MetadataExists}}},
+		{name: "injection key in comparison", conditions: []MetadataCondition{{Path: []string{injectionParens}, Operator: MetadataGreater, Value: 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := store.List(FragmentFilter{SessionID: session.ID, MetadataWhere: tt.conditions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, fragment := range page.Fragments {
+				got = append(got, fragment.Content)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("fragments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The table is still there after the injection attempts
+	page, err := store.List(FragmentFilter{SessionID: session.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Fragments) != 6 {
+		t.Errorf("%d fragments left, want 6", len(page.Fragments))
+	}
+}
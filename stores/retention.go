@@ -0,0 +1,340 @@
+package stores
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultPurgeBatchSize is the number of fragments deleted per statement when
+// PurgeOptions sets no batch size
+const DefaultPurgeBatchSize = 1000
+
+// FragmentArchive receives the fragments a purge is about to delete. Archive
+// runs in the transaction deleting them, so if it fails they are kept.
+type FragmentArchive interface {
+	Archive(tx *gorm.DB, fragments []db.Fragment) error
+}
+
+// PurgeOptions configures the hard deletes of PurgeBefore, DeleteBySession and
+// DeleteByActor
+type PurgeOptions struct {
+	// Rows deleted per transaction, DefaultPurgeBatchSize if zero. Small
+	// batches hold their row locks briefly, so the table stays writable.
+	BatchSize int
+	// Archive the fragments are copied to before deletion, if set
+	Archive FragmentArchive
+	// Called after each batch with the rows it deleted and the running total,
+	// e.g. to log progress
+	OnBatch func(deleted, total int64)
+}
+
+// PurgeBefore permanently deletes the fragments soft-deleted before cutoff,
+// and also live fragments created before liveBefore if it is set, for data
+// retention. It returns the number of fragments deleted, which is accurate
+// even when it fails part way.
+//
+// In tables partitioned by month, see db.PartitionFragmentTable, the
+// partitions of the months wholly before liveBefore are dropped rather than
+// deleted row by row, which is much faster, unless the store is scoped to a
+// tenant, an archive is set or some of their fragments were soft-deleted at or
+// after cutoff. Dropping a partition briefly locks the whole table, and clears
+// the store's cache, since the IDs of the fragments dropped aren't read.
+func (s *FragmentStore) PurgeBefore(cutoff, liveBefore time.Time, opts PurgeOptions) (int64, error) {
+	where := func(q *gorm.DB) *gorm.DB {
+		if liveBefore.IsZero() {
+			return q.Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+		}
+		return q.Where("(deleted_at IS NOT NULL AND deleted_at < ?) OR (deleted_at IS NULL AND created_at < ?)", cutoff, liveBefore)
+	}
+	match := func(fragment db.Fragment) bool {
+		if fragment.DeletedAt.Valid {
+			return fragment.DeletedAt.Time.Before(cutoff)
+		}
+		return !liveBefore.IsZero() && fragment.CreatedAt.Before(liveBefore)
+	}
+	dropped, err := s.dropExpiredPartitions(cutoff, liveBefore, opts)
+	if err != nil {
+		return dropped, err
+	}
+	purged, err := s.purge(where, match, opts)
+	return dropped + purged, err
+}
+
+// dropExpiredPartitions drops the monthly partitions of a partitioned table
+// whose fragments PurgeBefore would all delete, and returns the number of
+// fragments they held
+func (s *FragmentStore) dropExpiredPartitions(cutoff, liveBefore time.Time, opts PurgeOptions) (int64, error) {
+	if s.dryRun || s.mem != nil || s.tenant != "" || opts.Archive != nil || liveBefore.IsZero() ||
+		!db.IsMarkedPartitioned(s.db, s.table) {
+		return 0, nil
+	}
+	partitions, err := db.ListPartitions(s.db.WithContext(s.ctx), s.table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge fragments: %w", err)
+	}
+
+	var total int64
+	for _, partition := range partitions {
+		if partition.To.After(liveBefore) {
+			break
+		}
+		var dropped int64
+		err := s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+			var kept bool
+			if err := tx.Raw(
+				"SELECT EXISTS (SELECT 1 FROM "+tx.Statement.Quote(partition.Name)+" WHERE deleted_at >= ?)",
+				cutoff,
+			).Scan(&kept).Error; err != nil || kept {
+				return err
+			}
+			if err := tx.Table(partition.Name).Unscoped().Count(&dropped).Error; err != nil {
+				return err
+			}
+			return tx.Exec("DROP TABLE " + tx.Statement.Quote(partition.Name)).Error
+		})
+		if err != nil {
+			return total, fmt.Errorf("failed to drop partition %s: %w", partition.Name, err)
+		}
+		if dropped == 0 {
+			continue
+		}
+		s.cache.clear()
+		total += dropped
+		if opts.OnBatch != nil {
+			opts.OnBatch(dropped, total)
+		}
+	}
+	return total, nil
+}
+
+// DeleteBySession permanently deletes the fragments of a session, soft-deleted
+// or not, e.g. for a request to be forgotten. It returns the number of
+// fragments deleted.
+func (s *FragmentStore) DeleteBySession(sessionID id.ID, opts PurgeOptions) (int64, error) {
+	if sessionID == "" {
+		return 0, fmt.Errorf("session ID is required")
+	}
+	return s.purge(func(q *gorm.DB) *gorm.DB {
+		return q.Where("session_id = ?", sessionID)
+	}, func(fragment db.Fragment) bool {
+		return fragment.SessionID == sessionID
+	}, opts)
+}
+
+// DeleteByActor permanently deletes the fragments of an actor across all
+// sessions, soft-deleted or not, e.g. for a request to be forgotten. It
+// returns the number of fragments deleted.
+func (s *FragmentStore) DeleteByActor(actorID id.ID, opts PurgeOptions) (int64, error) {
+	if actorID == "" {
+		return 0, fmt.Errorf("actor ID is required")
+	}
+	return s.purge(func(q *gorm.DB) *gorm.DB {
+		return q.Where("actor_id = ?", actorID)
+	}, func(fragment db.Fragment) bool {
+		return fragment.ActorID == actorID
+	}, opts)
+}
+
+// purge deletes the fragments where selects, or match in memory, in batches of
+// one transaction each
+func (s *FragmentStore) purge(where func(*gorm.DB) *gorm.DB, match func(db.Fragment) bool, opts PurgeOptions) (int64, error) {
+	if s.dryRun {
+		return 0, nil
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultPurgeBatchSize
+	}
+
+	var total int64
+	for {
+		if err := s.ctx.Err(); err != nil {
+			return total, fmt.Errorf("failed to purge fragments: %w", err)
+		}
+
+		selected, deleted, err := s.purgeBatch(where, match, batchSize, opts.Archive)
+		total += deleted
+		if err != nil {
+			return total, fmt.Errorf("failed to purge fragments: %w", err)
+		}
+		if deleted > 0 && opts.OnBatch != nil {
+			opts.OnBatch(deleted, total)
+		}
+		if selected < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// purgeBatch archives and deletes up to batchSize fragments, returning how many
+// were selected and deleted. Rows locked by other transactions are skipped
+// rather than waited for.
+func (s *FragmentStore) purgeBatch(where func(*gorm.DB) *gorm.DB, match func(db.Fragment) bool, batchSize int, archive FragmentArchive) (int, int64, error) {
+	if s.mem != nil {
+		batch := page(s.mem.findFragments(s.table, memoryFragmentFilter{includeDeleted: true, match: match}, false, false), 0, batchSize)
+		if archive != nil && len(batch) > 0 {
+			if err := archive.Archive(s.db.WithContext(s.ctx), batch); err != nil {
+				return len(batch), 0, fmt.Errorf("failed to archive fragments: %w", err)
+			}
+		}
+		defer s.invalidate(fragmentIDs(batch)...)
+		return len(batch), s.mem.removeFragments(s.table, fragmentIDs(batch)), nil
+	}
+
+	var (
+		selected int
+		deleted  int64
+	)
+	err := s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		q := where(scopeTenant(tx.Table(string(s.table)).Unscoped(), s.tenant, "tenant_id"))
+		if archive == nil {
+			q = q.Select("id")
+		}
+
+		var batch []db.Fragment
+		if err := q.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Order("id").
+			Limit(batchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		selected = len(batch)
+		if selected == 0 {
+			return nil
+		}
+		defer s.invalidate(fragmentIDs(batch)...)
+
+		if archive != nil {
+			if err := archive.Archive(tx, batch); err != nil {
+				return fmt.Errorf("failed to archive fragments: %w", err)
+			}
+		}
+
+		result := scopeTenant(tx.Table(string(s.table)).Unscoped(), s.tenant, "tenant_id").
+			Where("id IN ?", fragmentIDs(batch)).
+			Delete(&db.Fragment{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return selected, 0, err
+	}
+	return selected, deleted, nil
+}
+
+// fragmentIDs returns the IDs of fragments
+func fragmentIDs(fragments []db.Fragment) []id.ID {
+	ids := make([]id.ID, len(fragments))
+	for i, fragment := range fragments {
+		ids[i] = fragment.ID
+	}
+	return ids
+}
+
+// tableArchive copies fragments to a table
+type tableArchive struct {
+	table string
+}
+
+// NewTableArchive returns an archive copying purged fragments to a table of
+// the same database created with db.CreateFragmentArchiveTable. Fragments
+// already in the archive are left as archived first.
+func NewTableArchive(table string) FragmentArchive {
+	return &tableArchive{table: table}
+}
+
+func (a *tableArchive) Archive(tx *gorm.DB, fragments []db.Fragment) error {
+	// Rows without an embedding omit the column, as FragmentStore.Create does
+	var embedded, plain []db.Fragment
+	for _, fragment := range fragments {
+		fragment.Actor, fragment.Session = nil, nil
+		if len(fragment.Embedding.Slice()) == 0 {
+			plain = append(plain, fragment)
+		} else {
+			embedded = append(embedded, fragment)
+		}
+	}
+
+	for _, rows := range [][]db.Fragment{embedded, plain} {
+		if len(rows) == 0 {
+			continue
+		}
+		omit := []string{clause.Associations}
+		if len(rows[0].Embedding.Slice()) == 0 {
+			omit = append(omit, "Embedding")
+		}
+		if err := tx.Table(a.table).
+			Omit(omit...).
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&rows).Error; err != nil {
+			return fmt.Errorf("failed to write to %s: %w", a.table, err)
+		}
+	}
+	return nil
+}
+
+// archivedFragment is a fragment as a JSONL archive writes it
+type archivedFragment struct {
+	ID        id.ID       `json:"id"`
+	ActorID   id.ID       `json:"actor_id"`
+	SessionID id.ID       `json:"session_id"`
+	ParentID  *id.ID      `json:"parent_id,omitempty"`
+	Content   string      `json:"content"`
+	Metadata  db.Metadata `json:"metadata"`
+	Embedding []float32   `json:"embedding,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty"`
+}
+
+// jsonlArchive writes fragments as JSON lines
+type jsonlArchive struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLArchive returns an archive writing purged fragments to w, one JSON
+// object per line. Lines are written before the fragments are deleted, so a
+// failed purge may leave lines of fragments that were kept.
+func NewJSONLArchive(w io.Writer) FragmentArchive {
+	return &jsonlArchive{encoder: json.NewEncoder(w)}
+}
+
+func (a *jsonlArchive) Archive(_ *gorm.DB, fragments []db.Fragment) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, fragment := range fragments {
+		record := archivedFragment{
+			ID:        fragment.ID,
+			ActorID:   fragment.ActorID,
+			SessionID: fragment.SessionID,
+			ParentID:  fragment.ParentID,
+			Content:   fragment.Content,
+			Metadata:  fragment.Metadata,
+			Embedding: fragment.Embedding.Slice(),
+			CreatedAt: fragment.CreatedAt,
+			UpdatedAt: fragment.UpdatedAt,
+		}
+		if fragment.DeletedAt.Valid {
+			deletedAt := fragment.DeletedAt.Time
+			record.DeletedAt = &deletedAt
+		}
+		if err := a.encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to write fragment %s: %w", fragment.ID, err)
+		}
+	}
+	return nil
+}
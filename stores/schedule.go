@@ -0,0 +1,233 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+)
+
+// ScheduleStore provides persistence for scheduled responses
+type ScheduleStore struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the sessions of a tenant, see WithTenant
+	tenant id.ID
+}
+
+// NewScheduleStore creates a new ScheduleStore backed by the given database,
+// scoped to its tenant if it is marked with one
+func NewScheduleStore(ctx context.Context, db *gorm.DB) *ScheduleStore {
+	return &ScheduleStore{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *ScheduleStore) WithTx(tx *gorm.DB) *ScheduleStore {
+	if tx == nil {
+		return s
+	}
+	return &ScheduleStore{
+		db:     tx,
+		ctx:    s.ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *ScheduleStore) WithContext(ctx context.Context) *ScheduleStore {
+	return &ScheduleStore{
+		db:     s.db,
+		ctx:    ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the scheduled responses of
+// the sessions of a tenant, see the WithTenant function
+func (s *ScheduleStore) WithTenant(tenantID id.ID) *ScheduleStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &ScheduleStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+	}
+}
+
+// Create inserts a new scheduled response
+func (s *ScheduleStore) Create(schedule *db.ScheduledResponse) error {
+	if s.mem != nil {
+		if err := s.mem.createSchedule(schedule); err != nil {
+			return fmt.Errorf("failed to create scheduled response: %w", err)
+		}
+		return nil
+	}
+	if err := checkTenantSession(s.db.WithContext(s.ctx), s.tenant, schedule.SessionID); err != nil {
+		return fmt.Errorf("failed to create scheduled response: %w", err)
+	}
+	if err := s.db.WithContext(s.ctx).Create(schedule).Error; err != nil {
+		return fmt.Errorf("failed to create scheduled response: %w", err)
+	}
+	return nil
+}
+
+// Cancel marks a pending scheduled response as cancelled.
+// Returns whether it was pending.
+func (s *ScheduleStore) Cancel(scheduleID id.ID) (bool, error) {
+	if s.mem != nil {
+		cancelled := s.mem.updateSchedules(func(schedule db.ScheduledResponse) bool {
+			return schedule.ID == scheduleID && schedule.Status == db.SchedulePending
+		}, 0, func(schedule *db.ScheduledResponse) {
+			schedule.Status = db.ScheduleCancelled
+		})
+		return len(cancelled) > 0, nil
+	}
+	result := s.query().
+		Model(&db.ScheduledResponse{}).
+		Where("id = ? AND status = ?", scheduleID, db.SchedulePending).
+		Update("status", db.ScheduleCancelled)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to cancel scheduled response: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListPending returns the pending scheduled responses of a session, earliest first
+func (s *ScheduleStore) ListPending(sessionID id.ID) ([]db.ScheduledResponse, error) {
+	if s.mem != nil {
+		return s.mem.listSchedules(func(schedule db.ScheduledResponse) bool {
+			return schedule.SessionID == sessionID && schedule.Status == db.SchedulePending
+		}), nil
+	}
+	var schedules []db.ScheduledResponse
+	if err := s.query().
+		Where("session_id = ? AND status = ?", sessionID, db.SchedulePending).
+		Order("run_at").
+		Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list scheduled responses: %w", err)
+	}
+	return schedules, nil
+}
+
+// ClaimDue marks up to limit pending scheduled responses due at now as running and
+// returns them, earliest first. Rows claimed concurrently by another process are
+// skipped, so each scheduled response is claimed once.
+func (s *ScheduleStore) ClaimDue(now time.Time, limit int) ([]db.ScheduledResponse, error) {
+	if s.mem != nil {
+		return s.mem.updateSchedules(func(schedule db.ScheduledResponse) bool {
+			return schedule.Status == db.SchedulePending && !schedule.RunAt.After(now)
+		}, limit, func(schedule *db.ScheduledResponse) {
+			claimedAt := now
+			schedule.Status = db.ScheduleRunning
+			schedule.ClaimedAt = &claimedAt
+		}), nil
+	}
+	// Scoped stores claim the responses of their tenant's sessions only
+	tenant := ""
+	if s.tenant != "" {
+		tenant = " AND session_id IN (SELECT id FROM sessions WHERE tenant_id = @tenant)"
+	}
+
+	var schedules []db.ScheduledResponse
+	if err := s.db.WithContext(s.ctx).Raw(`
+		UPDATE scheduled_responses SET status = @running, claimed_at = @now, updated_at = @now
+		WHERE id IN (
+			SELECT id FROM scheduled_responses
+			WHERE status = @pending AND run_at <= @now`+tenant+`
+			ORDER BY run_at
+			LIMIT @limit
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		map[string]interface{}{
+			"running": db.ScheduleRunning,
+			"pending": db.SchedulePending,
+			"now":     now,
+			"limit":   limit,
+			"tenant":  s.tenant,
+		},
+	).Scan(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to claim scheduled responses: %w", err)
+	}
+
+	// RETURNING doesn't preserve the order of the subquery
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].RunAt.Before(schedules[j].RunAt)
+	})
+	return schedules, nil
+}
+
+// Complete records the outcome of a claimed scheduled response
+func (s *ScheduleStore) Complete(scheduleID id.ID, runErr error) error {
+	updates := map[string]interface{}{"status": db.ScheduleDone}
+	if runErr != nil {
+		updates = map[string]interface{}{
+			"status": db.ScheduleFailed,
+			"error":  runErr.Error(),
+		}
+	}
+	if s.mem != nil {
+		s.mem.updateSchedules(func(schedule db.ScheduledResponse) bool {
+			return schedule.ID == scheduleID && schedule.Status == db.ScheduleRunning
+		}, 0, func(schedule *db.ScheduledResponse) {
+			schedule.Status = updates["status"].(db.ScheduleStatus)
+			if runErr != nil {
+				schedule.Error = runErr.Error()
+			}
+		})
+		return nil
+	}
+	if err := s.query().
+		Model(&db.ScheduledResponse{}).
+		Where("id = ? AND status = ?", scheduleID, db.ScheduleRunning).
+		Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to complete scheduled response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseStale returns scheduled responses claimed before the given time, e.g. by
+// a process that stopped while running them, to pending. Returns how many were released.
+func (s *ScheduleStore) ReleaseStale(claimedBefore time.Time) (int64, error) {
+	if s.mem != nil {
+		released := s.mem.updateSchedules(func(schedule db.ScheduledResponse) bool {
+			return schedule.Status == db.ScheduleRunning && schedule.ClaimedAt != nil && schedule.ClaimedAt.Before(claimedBefore)
+		}, 0, func(schedule *db.ScheduledResponse) {
+			schedule.Status = db.SchedulePending
+			schedule.ClaimedAt = nil
+		})
+		return int64(len(released)), nil
+	}
+	result := s.query().
+		Model(&db.ScheduledResponse{}).
+		Where("status = ? AND claimed_at < ?", db.ScheduleRunning, claimedBefore).
+		Updates(map[string]interface{}{
+			"status":     db.SchedulePending,
+			"claimed_at": nil,
+		})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to release stale scheduled responses: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// query returns the store's database with its context, scoped to the sessions
+// of its tenant
+func (s *ScheduleStore) query() *gorm.DB {
+	return scopeSessionTenant(s.db.WithContext(s.ctx), s.tenant, "session_id")
+}
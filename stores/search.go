@@ -0,0 +1,263 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+const (
+	// rrfK dampens the weight of the top ranks in reciprocal rank fusion
+	rrfK = 60
+
+	// hybridCandidateFactor is how many more candidates than requested each
+	// search of SearchHybrid returns, so fusion has rankings to merge
+	hybridCandidateFactor = 4
+
+	// defaultTextWeight is the share of the text ranking in hybrid searches
+	defaultTextWeight = 0.5
+)
+
+// SearchFilter restricts the fragments of a search
+type SearchFilter struct {
+	SessionID id.ID     // Only fragments of this session, if set
+	ActorID   id.ID     // Only fragments of this actor, if set
+	After     time.Time // Only fragments created after this time, if set
+	Before    time.Time // Only fragments created before this time, if set
+}
+
+// apply adds the filter's conditions to a query
+func (f SearchFilter) apply(q *gorm.DB) *gorm.DB {
+	if f.SessionID != "" {
+		q = q.Where("session_id = ?", f.SessionID)
+	}
+	if f.ActorID != "" {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if !f.After.IsZero() {
+		q = q.Where("created_at > ?", f.After)
+	}
+	if !f.Before.IsZero() {
+		q = q.Where("created_at < ?", f.Before)
+	}
+	return q
+}
+
+// TextSearchQuery selects the fragments SearchText returns
+type TextSearchQuery struct {
+	// Search in web search syntax: words, "quoted phrases", or and -excluded
+	Text  string
+	Limit int
+	// Text search configuration the search vectors were built with,
+	// db.DefaultTextSearchLanguage if empty
+	Language string
+	SearchFilter
+}
+
+// textMatch is a row of a full text search
+type textMatch struct {
+	db.Fragment
+	Rank float64
+}
+
+// SearchText returns up to query.Limit fragments matching a full text search,
+// best ranked first. The Score of the matches is their ts_rank_cd rank; their
+// Distance is not set. Requires the search vectors of db.MigrateTextSearch.
+func (s *FragmentStore) SearchText(query TextSearchQuery) ([]FragmentMatch, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("search limit must be positive")
+	}
+	language := query.Language
+	if language == "" {
+		language = db.DefaultTextSearchLanguage
+	}
+	if s.mem != nil {
+		return s.mem.searchText(s.table, query.Text, query.Limit, query.SearchFilter.match), nil
+	}
+
+	tsquery := "websearch_to_tsquery(?::regconfig, ?)"
+	q := s.query().
+		Model(&db.Fragment{}).
+		Select("*, ts_rank_cd("+db.SearchVectorColumn+", "+tsquery+") AS rank", language, query.Text).
+		Where(db.SearchVectorColumn+" @@ "+tsquery, language, query.Text)
+	q = query.SearchFilter.apply(q)
+
+	var rows []textMatch
+	if err := q.Order("rank DESC").Order("id").Limit(query.Limit).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to search fragments by text: %w", err)
+	}
+
+	matches := make([]FragmentMatch, len(rows))
+	for i, row := range rows {
+		matches[i] = FragmentMatch{Fragment: row.Fragment, Score: row.Rank}
+	}
+	return matches, nil
+}
+
+// FusionMethod determines how SearchHybrid merges its text and vector results
+type FusionMethod string
+
+const (
+	// FusionRRF scores fragments by reciprocal rank fusion of their ranks in
+	// each search, ignoring the scales of the scores
+	FusionRRF FusionMethod = "rrf"
+	// FusionWeighted scores fragments by the weighted sum of their scores in
+	// each search, each normalized to between 0 and 1
+	FusionWeighted FusionMethod = "weighted"
+)
+
+// HybridQuery selects the fragments SearchHybrid returns
+type HybridQuery struct {
+	Text      string
+	Embedding pgvector.Vector
+	Limit     int
+
+	Metric   DistanceMetric // Metric of the vector search, DistanceCosine if empty
+	Language string         // Configuration of the text search, see TextSearchQuery
+
+	Fusion FusionMethod // FusionRRF if empty
+	// Share of the text search in the fused score, between 0 and 1, the rest
+	// going to the vector search. 0.5 if zero.
+	TextWeight float64
+
+	SearchFilter
+}
+
+// HybridMatch is a fragment found by SearchHybrid with its fused score, and its
+// scores in the searches that found it
+type HybridMatch struct {
+	db.Fragment
+	Score float64
+
+	InText      bool
+	TextScore   float64 // Text rank, if found by the text search
+	InVector    bool
+	VectorScore float64 // Vector score, if found by the vector search, see FragmentMatch
+}
+
+// SearchHybrid runs a full text search and a vector search with the same
+// filters and returns up to query.Limit fragments of both, best fused score
+// first, so exact names and IDs are found along with paraphrases
+func (s *FragmentStore) SearchHybrid(query HybridQuery) ([]HybridMatch, error) {
+	if query.Limit <= 0 {
+		return nil, fmt.Errorf("search limit must be positive")
+	}
+	if query.TextWeight < 0 || query.TextWeight > 1 {
+		return nil, fmt.Errorf("text weight must be between 0 and 1")
+	}
+	textWeight := query.TextWeight
+	if textWeight == 0 {
+		textWeight = defaultTextWeight
+	}
+
+	candidates := query.Limit * hybridCandidateFactor
+	textMatches, err := s.SearchText(TextSearchQuery{
+		Text:         query.Text,
+		Limit:        candidates,
+		Language:     query.Language,
+		SearchFilter: query.SearchFilter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	vectorMatches, err := s.SearchSimilar(SearchQuery{
+		Embedding:    query.Embedding,
+		Limit:        candidates,
+		Metric:       query.Metric,
+		SearchFilter: query.SearchFilter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var fuse func(matches []FragmentMatch, weight float64) []float64
+	switch query.Fusion {
+	case FusionRRF, "":
+		fuse = reciprocalRanks
+	case FusionWeighted:
+		fuse = normalizedScores
+	default:
+		return nil, fmt.Errorf("unknown fusion method %q", query.Fusion)
+	}
+
+	byID := make(map[id.ID]*HybridMatch)
+	var merged []*HybridMatch
+	match := func(fragment db.Fragment) *HybridMatch {
+		if m, ok := byID[fragment.ID]; ok {
+			return m
+		}
+		m := &HybridMatch{Fragment: fragment}
+		byID[fragment.ID] = m
+		merged = append(merged, m)
+		return m
+	}
+
+	for i, score := range fuse(textMatches, textWeight) {
+		m := match(textMatches[i].Fragment)
+		m.Score += score
+		m.InText = true
+		m.TextScore = textMatches[i].Score
+	}
+	for i, score := range fuse(vectorMatches, 1-textWeight) {
+		m := match(vectorMatches[i].Fragment)
+		m.Score += score
+		m.InVector = true
+		m.VectorScore = vectorMatches[i].Score
+	}
+
+	// Best first, ties in the order the fragments were found
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	if len(merged) > query.Limit {
+		merged = merged[:query.Limit]
+	}
+
+	results := make([]HybridMatch, len(merged))
+	for i, m := range merged {
+		results[i] = *m
+	}
+	return results, nil
+}
+
+// reciprocalRanks returns the weighted reciprocal rank of each match, in order
+func reciprocalRanks(matches []FragmentMatch, weight float64) []float64 {
+	scores := make([]float64, len(matches))
+	for i := range matches {
+		scores[i] = weight / float64(rrfK+i+1)
+	}
+	return scores
+}
+
+// normalizedScores returns the weighted score of each match scaled to between
+// 0 and 1 over the matches, in order
+func normalizedScores(matches []FragmentMatch, weight float64) []float64 {
+	scores := make([]float64, len(matches))
+	if len(matches) == 0 {
+		return scores
+	}
+
+	lowest, highest := matches[0].Score, matches[0].Score
+	for _, m := range matches {
+		if m.Score < lowest {
+			lowest = m.Score
+		}
+		if m.Score > highest {
+			highest = m.Score
+		}
+	}
+	for i, m := range matches {
+		normalized := 1.0
+		if highest > lowest {
+			normalized = (m.Score - lowest) / (highest - lowest)
+		}
+		scores[i] = weight * normalized
+	}
+	return scores
+}
@@ -0,0 +1,481 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/cache"
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionStore provides persistence for sessions
+type SessionStore struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// dryRun turns all writes into no-ops
+	dryRun bool
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the sessions of a tenant, see WithTenant
+	tenant id.ID
+	// cache caches the records looked up by ID, see WithCache
+	cache *storeCache
+}
+
+// NewSessionStore creates a new SessionStore backed by the given database,
+// scoped to its tenant if it is marked with one
+func NewSessionStore(ctx context.Context, db *gorm.DB) *SessionStore {
+	return &SessionStore{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *SessionStore) WithTx(tx *gorm.DB) *SessionStore {
+	if tx == nil {
+		return s
+	}
+	return &SessionStore{
+		db:     tx,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache.inTx(),
+	}
+}
+
+// DryRun returns a copy of the store whose writes are no-ops, while reads still
+// hit the database
+func (s *SessionStore) DryRun() *SessionStore {
+	return &SessionStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: true,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *SessionStore) WithContext(ctx context.Context) *SessionStore {
+	return &SessionStore{
+		db:     s.db,
+		ctx:    ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  s.cache,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the sessions of a tenant,
+// see the WithTenant function
+func (s *SessionStore) WithTenant(tenantID id.ID) *SessionStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &SessionStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+		cache:  s.cache,
+	}
+}
+
+// WithCache returns a copy of the store that caches the sessions GetByID
+// looks up in c, dropping them when they are written through the store or its
+// copies. Missing sessions are cached for notFoundTTL, DefaultNotFoundTTL if
+// zero, or not at all if negative.
+//
+// Copies bound to a transaction with WithTx don't use the cache for lookups.
+// Writes made to the database bypassing the store's copies aren't seen until
+// the cache's TTL expires.
+func (s *SessionStore) WithCache(c *cache.Cache, notFoundTTL time.Duration) *SessionStore {
+	return &SessionStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		dryRun: s.dryRun,
+		mem:    s.mem,
+		tenant: s.tenant,
+		cache:  newStoreCache(c, "sessions", notFoundTTL),
+	}
+}
+
+// CacheStats returns the lookups of the store's cache, shared with its copies,
+// see WithCache
+func (s *SessionStore) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// Create inserts a new session
+func (s *SessionStore) Create(session *db.Session) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(session.ID)
+	if err := stampTenant("session", session.ID, &session.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if s.mem != nil {
+		if err := s.mem.createSession(session, false); err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		return nil
+	}
+	if err := s.db.WithContext(s.ctx).Create(session).Error; err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// Upsert inserts a session or updates it if the ID already exists
+func (s *SessionStore) Upsert(session *db.Session) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(session.ID)
+	if err := stampTenant("session", session.ID, &session.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to upsert session: %w", err)
+	}
+	if s.mem != nil {
+		if err := s.mem.createSession(session, true); err != nil {
+			return fmt.Errorf("failed to upsert session: %w", err)
+		}
+		return nil
+	}
+	result := s.db.WithContext(s.ctx).Clauses(conflictScope(clause.OnConflict{
+		UpdateAll: true,
+	}, "sessions", s.tenant)).Create(session)
+	if result.Error != nil {
+		return fmt.Errorf("failed to upsert session: %w", result.Error)
+	}
+	if s.tenant != "" && result.RowsAffected == 0 {
+		return fmt.Errorf("failed to upsert session: session %s: %w", session.ID, ErrOtherTenant)
+	}
+	return nil
+}
+
+// GetByID retrieves a session by its ID, or an error matching ErrNotFound if it
+// doesn't exist
+func (s *SessionStore) GetByID(sessionID id.ID) (*db.Session, error) {
+	return cachedLookup(s.cache, sessionID, s.tenant, func(session db.Session) *id.ID {
+		return session.TenantID
+	}, func() (*db.Session, error) {
+		if s.mem != nil {
+			return s.mem.getSession(sessionID)
+		}
+		var session db.Session
+		if err := s.query().Where("id = ?", sessionID).First(&session).Error; err != nil {
+			return nil, lookupError(err, "session", sessionID)
+		}
+		return &session, nil
+	}, cloneSession)
+}
+
+// Touch creates the session if it doesn't exist and records activity on it,
+// leaving its title, metadata and closed state untouched
+func (s *SessionStore) Touch(sessionID id.ID) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(sessionID)
+	if s.mem != nil {
+		if err := s.mem.touchSession(sessionID); err != nil {
+			return fmt.Errorf("failed to touch session: %w", err)
+		}
+		return nil
+	}
+	now := time.Now()
+	session := &db.Session{
+		ID:             sessionID,
+		LastActivityAt: now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := stampTenant("session", sessionID, &session.TenantID, s.tenant); err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	result := s.db.WithContext(s.ctx).Clauses(conflictScope(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_activity_at", "updated_at"}),
+	}, "sessions", s.tenant)).Create(session)
+	if result.Error != nil {
+		return fmt.Errorf("failed to touch session: %w", result.Error)
+	}
+	if s.tenant != "" && result.RowsAffected == 0 {
+		return fmt.Errorf("failed to touch session: session %s: %w", sessionID, ErrOtherTenant)
+	}
+	return nil
+}
+
+// UpdateTitle sets the title of a session
+func (s *SessionStore) UpdateTitle(sessionID id.ID, title string) error {
+	defer s.invalidate(sessionID)
+	if s.mem != nil && !s.dryRun {
+		_, err := s.mem.updateSession(sessionID, func(session *db.Session) bool {
+			session.Title = title
+			return true
+		})
+		return err
+	}
+	return s.update(sessionID, "title", title)
+}
+
+// SetTitleIfEmpty sets the title of a session unless it already has one.
+// Returns whether the title was set.
+func (s *SessionStore) SetTitleIfEmpty(sessionID id.ID, title string) (bool, error) {
+	if s.dryRun {
+		return true, nil
+	}
+	defer s.invalidate(sessionID)
+	if s.mem != nil {
+		set, err := s.mem.updateSession(sessionID, func(session *db.Session) bool {
+			if session.Title != "" {
+				return false
+			}
+			session.Title = title
+			return true
+		})
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return set, err
+	}
+	result := s.query().
+		Model(&db.Session{}).
+		Where("id = ? AND title = ''", sessionID).
+		Update("title", title)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to update session: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// MergeMetadata merges the given keys into the metadata of a session,
+// overwriting existing values of the same keys
+func (s *SessionStore) MergeMetadata(sessionID id.ID, metadata db.Metadata) error {
+	defer s.invalidate(sessionID)
+	if s.mem != nil && !s.dryRun {
+		merged, err := storedMetadata(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to update session: %w", err)
+		}
+		_, err = s.mem.updateSession(sessionID, func(session *db.Session) bool {
+			if session.Metadata == nil {
+				session.Metadata = db.Metadata{}
+			}
+			for key, value := range merged {
+				session.Metadata[key] = value
+			}
+			return true
+		})
+		return err
+	}
+	return s.update(sessionID, "metadata", gorm.Expr("metadata || ?", metadata))
+}
+
+// Close marks a session as closed. Closing a closed session keeps its original close time.
+// Closing a missing session returns an error matching ErrNotFound.
+func (s *SessionStore) Close(sessionID id.ID) error {
+	if s.dryRun {
+		return nil
+	}
+	defer s.invalidate(sessionID)
+	if s.mem != nil {
+		_, err := s.mem.updateSession(sessionID, func(session *db.Session) bool {
+			if session.ClosedAt == nil {
+				now := time.Now()
+				session.ClosedAt = &now
+			}
+			return true
+		})
+		return err
+	}
+	result := s.query().
+		Model(&db.Session{}).
+		Where("id = ?", sessionID).
+		Update("closed_at", gorm.Expr("COALESCE(closed_at, ?)", time.Now()))
+	if result.Error != nil {
+		return fmt.Errorf("failed to close session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// SessionQuery controls which sessions List returns
+type SessionQuery struct {
+	Limit         int  // Maximum number of sessions, 0 for no limit
+	Offset        int  // Number of sessions to skip
+	IncludeClosed bool // Include closed sessions
+}
+
+// List returns sessions ordered by last activity, most recent first
+func (s *SessionStore) List(query SessionQuery) ([]db.Session, error) {
+	if s.mem != nil {
+		return s.mem.listSessions(query), nil
+	}
+	q := s.query().Order("last_activity_at DESC").Order("id")
+
+	if !query.IncludeClosed {
+		q = q.Where("closed_at IS NULL")
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	if query.Offset > 0 {
+		q = q.Offset(query.Offset)
+	}
+
+	var sessions []db.Session
+	if err := q.Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// update sets a single column of a session
+func (s *SessionStore) update(sessionID id.ID, column string, value interface{}) error {
+	if s.dryRun {
+		return nil
+	}
+	result := s.query().
+		Model(&db.Session{}).
+		Where("id = ?", sessionID).
+		Update(column, value)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	return nil
+}
+
+// AddParticipant records an actor taking part in a session at seenAt, moving
+// its last seen time forward if it already takes part
+func (s *SessionStore) AddParticipant(sessionID, actorID id.ID, seenAt time.Time) error {
+	if s.dryRun {
+		return nil
+	}
+	if s.mem != nil {
+		if err := s.mem.addParticipant(sessionID, actorID, seenAt); err != nil {
+			return fmt.Errorf("failed to add session participant: %w", err)
+		}
+		return nil
+	}
+	if err := checkTenantSession(s.db.WithContext(s.ctx), s.tenant, sessionID); err != nil {
+		return fmt.Errorf("failed to add session participant: %w", err)
+	}
+	participant := &db.SessionActor{
+		SessionID:   sessionID,
+		ActorID:     actorID,
+		FirstSeenAt: seenAt,
+		LastSeenAt:  seenAt,
+	}
+	if err := s.db.WithContext(s.ctx).Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "session_id"}, {Name: "actor_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_seen_at": gorm.Expr("GREATEST(session_actors.last_seen_at, excluded.last_seen_at)"),
+		}),
+	}).Create(participant).Error; err != nil {
+		return fmt.Errorf("failed to add session participant: %w", err)
+	}
+	return nil
+}
+
+// GetParticipants returns the actors taking part in a session with their
+// actors loaded, most recently seen first
+func (s *SessionStore) GetParticipants(sessionID id.ID) ([]db.SessionActor, error) {
+	if s.mem != nil {
+		return s.mem.listParticipants(func(participant db.SessionActor) bool {
+			return participant.SessionID == sessionID
+		}, true, false), nil
+	}
+	var participants []db.SessionActor
+	q := scopeSessionTenant(s.db.WithContext(s.ctx), s.tenant, "session_id")
+	if err := preloadTenant(q, s.tenant, "Actor").
+		Where("session_id = ?", sessionID).
+		Order("last_seen_at DESC").
+		Order("actor_id").
+		Find(&participants).Error; err != nil {
+		return nil, fmt.Errorf("failed to get session participants: %w", err)
+	}
+	return participants, nil
+}
+
+// SessionActivity is a session with the activity of its interactions
+type SessionActivity struct {
+	db.Session
+	LastFragmentAt time.Time // Creation time of its newest interaction
+	Fragments      int64     // Number of interactions
+}
+
+// ListByActivity returns up to limit sessions with interactions, 0 for all of
+// them, ordered by their most recent interaction, newest first
+func (s *SessionStore) ListByActivity(limit int) ([]SessionActivity, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("session limit must not be negative")
+	}
+	if s.mem != nil {
+		return s.mem.sessionsByActivity(db.FragmentTableInteraction, limit), nil
+	}
+
+	interactions := (&gorm.Statement{DB: s.db}).Quote(string(db.FragmentTableInteraction))
+	q := s.db.WithContext(s.ctx).
+		Table("sessions AS s").
+		Select("s.*, a.last_fragment_at, a.fragments").
+		Joins(`JOIN (
+			SELECT session_id, MAX(created_at) AS last_fragment_at, COUNT(*) AS fragments
+			FROM ` + interactions + `
+			WHERE deleted_at IS NULL
+			GROUP BY session_id
+		) a ON a.session_id = s.id`).
+		Where("s.deleted_at IS NULL").
+		Order("a.last_fragment_at DESC").
+		Order("s.id")
+	q = scopeTenant(q, s.tenant, "s.tenant_id")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+
+	var sessions []SessionActivity
+	if err := q.Scan(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions by activity: %w", err)
+	}
+	return sessions, nil
+}
+
+// Usage returns the store of the token usage of sessions, sharing the
+// database, memory dataset and tenant of this store
+func (s *SessionStore) Usage() *UsageStore {
+	return &UsageStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// invalidate drops a session from the store's cache after writing it
+func (s *SessionStore) invalidate(sessionID id.ID) {
+	s.cache.invalidate(sessionID)
+}
+
+// query returns the store's database with its context, scoped to its tenant
+func (s *SessionStore) query() *gorm.DB {
+	return scopeTenant(s.db.WithContext(s.ctx), s.tenant, "tenant_id")
+}
@@ -0,0 +1,64 @@
+package stores
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+func TestSessionStoreNotFound(t *testing.T) {
+	forEachStores(t, func(t *testing.T, bundle *Stores) {
+		store := bundle.Sessions()
+		newSession := func() id.ID {
+			session := &db.Session{ID: id.New(), Metadata: db.Metadata{}, LastActivityAt: time.Now()}
+			if err := store.Create(session); err != nil {
+				t.Fatal(err)
+			}
+			return session.ID
+		}
+
+		tests := []struct {
+			name string
+			call func(sessionID id.ID) error
+			// notFound is whether a missing session fails the call with
+			// ErrNotFound, rather than succeeding
+			notFound bool
+		}{
+			{name: "GetByID", notFound: true, call: func(sessionID id.ID) error {
+				_, err := store.GetByID(sessionID)
+				return err
+			}},
+			{name: "UpdateTitle", notFound: true, call: func(sessionID id.ID) error {
+				return store.UpdateTitle(sessionID, "Greetings")
+			}},
+			{name: "MergeMetadata", notFound: true, call: func(sessionID id.ID) error {
+				return store.MergeMetadata(sessionID, db.Metadata{"platform": "discord"})
+			}},
+			{name: "Close", notFound: true, call: store.Close},
+			{name: "SetTitleIfEmpty", call: func(sessionID id.ID) error {
+				_, err := store.SetTitleIfEmpty(sessionID, "Greetings")
+				return err
+			}},
+			// Touching creates missing sessions
+			{name: "Touch", call: store.Touch},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := tt.call(newSession()); err != nil {
+					t.Fatalf("%s of an existing session failed: %v", tt.name, err)
+				}
+				err := tt.call(id.New())
+				if tt.notFound && !errors.Is(err, ErrNotFound) {
+					t.Errorf("%s of a missing session returned %v, want ErrNotFound", tt.name, err)
+				}
+				if !tt.notFound && err != nil {
+					t.Errorf("%s of a missing session failed: %v", tt.name, err)
+				}
+			})
+		}
+	})
+}
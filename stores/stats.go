@@ -0,0 +1,210 @@
+package stores
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+// StatsGroup is what Stats counts fragments by
+type StatsGroup string
+
+const (
+	StatsBySession StatsGroup = "session"
+	StatsByActor   StatsGroup = "actor"
+	StatsByDay     StatsGroup = "day" // UTC days
+)
+
+// StatsQuery selects the fragments Stats counts and how it groups them
+type StatsQuery struct {
+	GroupBy StatsGroup
+	Limit   int // Maximum number of groups, 0 for all of them
+	SearchFilter
+}
+
+// FragmentStats are the counts of a group of fragments. Only the field of the
+// query's group is set among SessionID, ActorID and Day.
+type FragmentStats struct {
+	SessionID id.ID
+	ActorID   id.ID
+	Day       time.Time
+
+	Count   int64
+	FirstAt time.Time // Creation time of the oldest fragment
+	LastAt  time.Time // Creation time of the newest fragment
+
+	// Fragments replying to a fragment of the table, e.g. responses to inputs,
+	// and the average time between their parent and them
+	Replies        int64
+	AverageLatency time.Duration
+}
+
+// statsRow is a row of the Stats query
+type statsRow struct {
+	SessionID             id.ID
+	ActorID               id.ID
+	Day                   time.Time
+	Count                 int64
+	FirstAt               time.Time
+	LastAt                time.Time
+	Replies               int64
+	AverageLatencySeconds *float64
+}
+
+// Stats counts the fragments matching the query's filter by session, actor or
+// day in a single aggregate query. Groups by day are in chronological order,
+// the others most recently active first. Latencies are measured from replies
+// to their parent, see db.Fragment.ParentID.
+func (s *FragmentStore) Stats(query StatsQuery) ([]FragmentStats, error) {
+	var key, order string
+	switch query.GroupBy {
+	case StatsBySession:
+		key, order = "f.session_id AS session_id", "last_at DESC, session_id"
+	case StatsByActor:
+		key, order = "f.actor_id AS actor_id", "last_at DESC, actor_id"
+	case StatsByDay:
+		key, order = "date_trunc('day', f.created_at AT TIME ZONE 'UTC') AS day", "day"
+	default:
+		return nil, fmt.Errorf("unknown stats group %q", query.GroupBy)
+	}
+	if query.Limit < 0 {
+		return nil, fmt.Errorf("stats limit must not be negative")
+	}
+
+	if s.mem != nil {
+		return s.statsMemory(query), nil
+	}
+
+	table := s.tableName()
+	q := s.db.WithContext(s.ctx).
+		Table(table + " AS f").
+		Select(key + `,
+			COUNT(*) AS count,
+			MIN(f.created_at) AS first_at,
+			MAX(f.created_at) AS last_at,
+			COUNT(p.id) AS replies,
+			AVG(EXTRACT(EPOCH FROM f.created_at - p.created_at)) AS average_latency_seconds`).
+		Joins("LEFT JOIN " + table + " p ON p.id = f.parent_id AND p.deleted_at IS NULL").
+		Where("f.deleted_at IS NULL")
+	q = scopeTenant(q, s.tenant, "f.tenant_id")
+
+	filter := query.SearchFilter
+	if filter.SessionID != "" {
+		q = q.Where("f.session_id = ?", filter.SessionID)
+	}
+	if filter.ActorID != "" {
+		q = q.Where("f.actor_id = ?", filter.ActorID)
+	}
+	if !filter.After.IsZero() {
+		q = q.Where("f.created_at > ?", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		q = q.Where("f.created_at < ?", filter.Before)
+	}
+	q = q.Group("1").Order(order)
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	var rows []statsRow
+	if err := q.Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get fragment stats: %w", err)
+	}
+
+	stats := make([]FragmentStats, len(rows))
+	for i, row := range rows {
+		stats[i] = FragmentStats{
+			SessionID: row.SessionID,
+			ActorID:   row.ActorID,
+			Day:       row.Day,
+			Count:     row.Count,
+			FirstAt:   row.FirstAt,
+			LastAt:    row.LastAt,
+			Replies:   row.Replies,
+		}
+		if row.AverageLatencySeconds != nil {
+			stats[i].AverageLatency = time.Duration(*row.AverageLatencySeconds * float64(time.Second))
+		}
+	}
+	return stats, nil
+}
+
+// statsMemory is Stats over a memory table
+func (s *FragmentStore) statsMemory(query StatsQuery) []FragmentStats {
+	all := s.mem.findFragments(s.table, memoryFragmentFilter{}, false, false)
+	byID := make(map[id.ID]db.Fragment, len(all))
+	for _, fragment := range all {
+		byID[fragment.ID] = fragment
+	}
+
+	type groupKey struct {
+		sessionID, actorID id.ID
+		day                time.Time
+	}
+	groups := make(map[groupKey]*FragmentStats)
+	latencies := make(map[*FragmentStats]time.Duration)
+	var stats []*FragmentStats
+	for _, fragment := range all {
+		if !query.SearchFilter.match(fragment) {
+			continue
+		}
+
+		var key groupKey
+		switch query.GroupBy {
+		case StatsBySession:
+			key.sessionID = fragment.SessionID
+		case StatsByActor:
+			key.actorID = fragment.ActorID
+		default:
+			key.day = fragment.CreatedAt.UTC().Truncate(24 * time.Hour)
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &FragmentStats{
+				SessionID: key.sessionID,
+				ActorID:   key.actorID,
+				Day:       key.day,
+				FirstAt:   fragment.CreatedAt,
+			}
+			groups[key] = group
+			stats = append(stats, group)
+		}
+		group.Count++
+		if fragment.CreatedAt.Before(group.FirstAt) {
+			group.FirstAt = fragment.CreatedAt
+		}
+		if fragment.CreatedAt.After(group.LastAt) {
+			group.LastAt = fragment.CreatedAt
+		}
+		if fragment.ParentID != nil {
+			if parent, ok := byID[*fragment.ParentID]; ok {
+				group.Replies++
+				latencies[group] += fragment.CreatedAt.Sub(parent.CreatedAt)
+			}
+		}
+	}
+
+	for group, latency := range latencies {
+		group.AverageLatency = latency / time.Duration(group.Replies)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if query.GroupBy == StatsByDay {
+			return a.Day.Before(b.Day)
+		}
+		if !a.LastAt.Equal(b.LastAt) {
+			return a.LastAt.After(b.LastAt)
+		}
+		return a.SessionID < b.SessionID || (a.SessionID == b.SessionID && a.ActorID < b.ActorID)
+	})
+
+	results := make([]FragmentStats, 0, len(stats))
+	for _, group := range page(stats, 0, query.Limit) {
+		results = append(results, *group)
+	}
+	return results
+}
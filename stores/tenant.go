@@ -0,0 +1,127 @@
+package stores
+
+import (
+	"fmt"
+
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tenantKey is the gorm setting marking a database with the tenant the stores
+// created from it are scoped to
+const tenantKey = "thor:tenant_id"
+
+// WithTenant returns database marked with a tenant, so the stores created from it or
+// from its transactions, e.g. by NewStores, ForTx and Transaction, are scoped
+// to the tenant:
+//
+//	tenantDB := stores.WithTenant(database, tenantID)
+//	sessions := stores.NewSessionStore(ctx, tenantDB)
+//
+// Scoped stores only read the records of their tenant and stamp it on the
+// records they write, so rows of other tenants can't be reached through them.
+// Rows written before tenants were used have no tenant and are only reachable
+// unscoped, see NewAdminStores.
+//
+// Scoping is permanent: scoping a database or store of a tenant to another
+// tenant panics, rather than letting it read the other tenant's rows.
+func WithTenant(database *gorm.DB, tenantID id.ID) *gorm.DB {
+	return database.Set(tenantKey, rescope(tenantOf(database), tenantID)).Session(&gorm.Session{})
+}
+
+// rescope returns the tenant a database or store scoped to current is scoped
+// to by WithTenant, panicking if that would change its tenant
+func rescope(current, tenantID id.ID) id.ID {
+	if tenantID == "" {
+		panic("stores: tenant ID is required")
+	}
+	if current != "" && current != tenantID {
+		panic(fmt.Sprintf("stores: store of tenant %s cannot be scoped to tenant %s", current, tenantID))
+	}
+	return tenantID
+}
+
+// tenantOf returns the tenant a database is marked with, or an empty ID
+func tenantOf(database *gorm.DB) id.ID {
+	if database == nil {
+		return ""
+	}
+	value, ok := database.Get(tenantKey)
+	if !ok {
+		return ""
+	}
+	tenantID, _ := value.(id.ID)
+	return tenantID
+}
+
+// scopeTenant restricts a query to the rows of a tenant, if set. column is the
+// tenant column, qualified when the query joins tables.
+func scopeTenant(q *gorm.DB, tenantID id.ID, column string) *gorm.DB {
+	if tenantID == "" {
+		return q
+	}
+	return q.Where(column+" = ?", tenantID)
+}
+
+// preloadTenant preloads an association of the rows of a query, restricted to
+// the rows of a tenant if set
+func preloadTenant(q *gorm.DB, tenantID id.ID, association string) *gorm.DB {
+	if tenantID == "" {
+		return q.Preload(association)
+	}
+	return q.Preload(association, "tenant_id = ?", tenantID)
+}
+
+// stampTenant sets the tenant of a record a scoped store writes. Records
+// already belonging to another tenant are refused.
+func stampTenant(record string, recordID id.ID, target **id.ID, tenantID id.ID) error {
+	if tenantID == "" {
+		return nil
+	}
+	if *target != nil && **target != tenantID {
+		return fmt.Errorf("%s %s: %w", record, recordID, ErrOtherTenant)
+	}
+	owner := tenantID
+	*target = &owner
+	return nil
+}
+
+// conflictScope restricts the update of an upsert to rows of a tenant, so a
+// scoped store can't overwrite a row of another tenant sharing the ID. The
+// upsert then affects no rows.
+func conflictScope(onConflict clause.OnConflict, table string, tenantID id.ID) clause.OnConflict {
+	if tenantID == "" {
+		return onConflict
+	}
+	onConflict.Where = clause.Where{Exprs: []clause.Expression{
+		clause.Expr{SQL: "?.tenant_id = ?", Vars: []interface{}{clause.Table{Name: table}, tenantID}},
+	}}
+	return onConflict
+}
+
+// scopeSessionTenant restricts a query to the rows whose session, in column,
+// belongs to a tenant, if set, for the tables without a tenant column
+func scopeSessionTenant(q *gorm.DB, tenantID id.ID, column string) *gorm.DB {
+	if tenantID == "" {
+		return q
+	}
+	return q.Where(column+" IN (SELECT id FROM sessions WHERE tenant_id = ?)", tenantID)
+}
+
+// checkTenantSession refuses a write referencing a session outside a tenant,
+// if set, for the tables without a tenant column
+func checkTenantSession(q *gorm.DB, tenantID, sessionID id.ID) error {
+	if tenantID == "" {
+		return nil
+	}
+	var count int64
+	if err := q.Table("sessions").Where("id = ? AND tenant_id = ?", sessionID, tenantID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check session tenant: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("session %s: %w", sessionID, ErrOtherTenant)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+// errNotReached is returned by the reads of TestTenantIsolation finding nothing
+var errNotReached = errors.New("record not reached")
+
+// tenantRecords are records written through the stores of a tenant
+type tenantRecords struct {
+	actor    *db.Actor
+	session  *db.Session
+	fragment *db.Fragment
+}
+
+// newTenantRecords writes an actor taking part in a session with a fragment
+// through bundle
+func newTenantRecords(t *testing.T, bundle *Stores) tenantRecords {
+	t.Helper()
+	actor, session := batchConversation(t, bundle)
+	actor.Metadata = db.Metadata{"plan": "enterprise"}
+	if err := bundle.Actors().Upsert(actor); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Sessions().AddParticipant(session.ID, actor.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	fragment := batchFragment(actor, session, "secret plans")
+	fragment.Metadata = db.Metadata{"kind": "secret"}
+	if err := bundle.Fragments(db.FragmentTableInteraction).Create(fragment); err != nil {
+		t.Fatal(err)
+	}
+	return tenantRecords{actor: actor, session: session, fragment: fragment}
+}
+
+// found returns errNotReached unless n records were found
+func found(n int, err error) error {
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotReached
+	}
+	return nil
+}
+
+func TestTenantIsolation(t *testing.T) {
+	forEachStores(t, testTenantIsolation)
+}
+
+func testTenantIsolation(t *testing.T, bundle *Stores) {
+	tenantA, tenantB := id.New(), id.New()
+	storesA, storesB := bundle.WithTenant(tenantA), bundle.WithTenant(tenantB)
+	records := newTenantRecords(t, storesA)
+	for name, tenantID := range map[string]*id.ID{
+		"actor":    records.actor.TenantID,
+		"session":  records.session.TenantID,
+		"fragment": records.fragment.TenantID,
+	} {
+		if tenantID == nil || *tenantID != tenantA {
+			t.Errorf("tenant of the %s = %v, want %s", name, tenantID, tenantA)
+		}
+	}
+
+	// Each read returns nil if it reaches the records of tenant A
+	reads := []struct {
+		name string
+		read func(s *Stores) error
+	}{
+		{name: "actor", read: func(s *Stores) error {
+			_, err := s.Actors().GetByID(records.actor.ID)
+			return err
+		}},
+		{name: "actors by metadata", read: func(s *Stores) error {
+			return found(lenOf(s.Actors().FindByMetadata("plan", "enterprise")))
+		}},
+		{name: "actor sessions", read: func(s *Stores) error {
+			return found(lenOf(s.Actors().GetSessions(records.actor.ID)))
+		}},
+		{name: "session", read: func(s *Stores) error {
+			_, err := s.Sessions().GetByID(records.session.ID)
+			return err
+		}},
+		{name: "sessions", read: func(s *Stores) error {
+			return found(lenOf(s.Sessions().List(SessionQuery{})))
+		}},
+		{name: "participants", read: func(s *Stores) error {
+			return found(lenOf(s.Sessions().GetParticipants(records.session.ID)))
+		}},
+		{name: "fragment", read: func(s *Stores) error {
+			_, err := s.Fragments(db.FragmentTableInteraction).GetByID(records.fragment.ID)
+			return err
+		}},
+		{name: "fragment exists", read: func(s *Stores) error {
+			exists, err := s.Fragments(db.FragmentTableInteraction).Exists(records.fragment.ID)
+			if err != nil || !exists {
+				return found(0, err)
+			}
+			return nil
+		}},
+		{name: "session history", read: func(s *Stores) error {
+			return found(lenOf(s.Fragments(db.FragmentTableInteraction).GetSessionHistory(records.session.ID, HistoryQuery{})))
+		}},
+		{name: "fragment list", read: func(s *Stores) error {
+			page, err := s.Fragments(db.FragmentTableInteraction).List(FragmentFilter{SessionID: records.session.ID})
+			if err != nil {
+				return err
+			}
+			return found(len(page.Fragments), nil)
+		}},
+		{name: "fragments by metadata", read: func(s *Stores) error {
+			return found(lenOf(s.Fragments(db.FragmentTableInteraction).ListByMetadata("kind", "secret", 10)))
+		}},
+		{name: "similar fragments", read: func(s *Stores) error {
+			return found(lenOf(s.Fragments(db.FragmentTableInteraction).SearchSimilar(SearchQuery{Embedding: embedding(1), Limit: 10})))
+		}},
+		{name: "text search", read: func(s *Stores) error {
+			return found(lenOf(s.Fragments(db.FragmentTableInteraction).SearchText(TextSearchQuery{Text: "secret", Limit: 10})))
+		}},
+	}
+	viewers := []struct {
+		name    string
+		stores  *Stores
+		reaches bool
+	}{
+		{name: "own tenant", stores: storesA, reaches: true},
+		{name: "unscoped", stores: bundle, reaches: true},
+		{name: "other tenant", stores: storesB},
+	}
+
+	for _, tt := range reads {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, viewer := range viewers {
+				err := tt.read(viewer.stores)
+				switch {
+				case viewer.reaches && err != nil:
+					t.Errorf("%s stores failed to reach the record: %v", viewer.name, err)
+				case !viewer.reaches && err == nil:
+					t.Errorf("%s stores reached the record", viewer.name)
+				case !viewer.reaches && !errors.Is(err, ErrNotFound) && !errors.Is(err, errNotReached):
+					t.Errorf("%s stores failed with %v, want not found", viewer.name, err)
+				}
+			}
+		})
+	}
+
+	// Writes of tenant B to the records of tenant A fail with want, if any,
+	// and leave them unchanged
+	writes := []struct {
+		name  string
+		write func(s *Stores) error
+		want  error
+	}{
+		{name: "actor upsert", write: func(s *Stores) error {
+			return s.Actors().Upsert(&db.Actor{ID: records.actor.ID, Name: "mallory", Metadata: db.Metadata{"plan": "free"}})
+		}, want: ErrOtherTenant},
+		{name: "actor of the other tenant", write: func(s *Stores) error {
+			return s.Actors().Create(&db.Actor{ID: id.New(), Name: "mallory", TenantID: &tenantA})
+		}, want: ErrOtherTenant},
+		{name: "actor metadata", write: func(s *Stores) error {
+			return s.Actors().UpdateMetadata(records.actor.ID, db.Metadata{"plan": "free"})
+		}, want: ErrNotFound},
+		{name: "session upsert", write: func(s *Stores) error {
+			return s.Sessions().Upsert(&db.Session{ID: records.session.ID, Title: "pwned", Metadata: db.Metadata{}, LastActivityAt: time.Now()})
+		}, want: ErrOtherTenant},
+		{name: "session title", write: func(s *Stores) error {
+			return s.Sessions().UpdateTitle(records.session.ID, "pwned")
+		}, want: ErrNotFound},
+		{name: "session close", write: func(s *Stores) error {
+			return s.Sessions().Close(records.session.ID)
+		}, want: ErrNotFound},
+		{name: "fragment upsert", write: func(s *Stores) error {
+			overwrite := batchFragment(records.actor, records.session, "pwned")
+			overwrite.ID = records.fragment.ID
+			return s.Fragments(db.FragmentTableInteraction).Upsert(overwrite)
+		}, want: ErrOtherTenant},
+		// Deleting a missing fragment is a no-op
+		{name: "fragment delete", write: func(s *Stores) error {
+			return s.Fragments(db.FragmentTableInteraction).Delete(records.fragment.ID)
+		}},
+	}
+
+	for _, tt := range writes {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(storesB); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	actor, err := storesA.Actors().GetByID(records.actor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actor.Name != records.actor.Name || actor.Metadata["plan"] != "enterprise" {
+		t.Errorf("actor = %s with %v after writes of another tenant", actor.Name, actor.Metadata)
+	}
+	session, err := storesA.Sessions().GetByID(records.session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Title != "" || session.ClosedAt != nil {
+		t.Errorf("session titled %q and closed at %v after writes of another tenant", session.Title, session.ClosedAt)
+	}
+	fragment, err := storesA.Fragments(db.FragmentTableInteraction).GetByID(records.fragment.ID)
+	if err != nil {
+		t.Fatalf("fragment lost after writes of another tenant: %v", err)
+	}
+	if fragment.Content != records.fragment.Content {
+		t.Errorf("fragment content = %q after writes of another tenant", fragment.Content)
+	}
+}
+
+// lenOf returns the length of the records a store method returns
+func lenOf[T any](records []T, err error) (int, error) {
+	return len(records), err
+}
+
+func TestTenantQueries(t *testing.T) {
+	tenantID := id.New()
+	tests := []struct {
+		name string
+		read func(store *FragmentStore) error
+		// want are parts of the SQL expected, in order
+		want []string
+	}{
+		{
+			name: "get",
+			read: func(store *FragmentStore) error {
+				_, err := store.GetByID(id.New())
+				return err
+			},
+			want: []string{"tenant_id = $1", "id = $2"},
+		},
+		{
+			name: "list",
+			read: func(store *FragmentStore) error {
+				_, err := store.List(FragmentFilter{SessionID: id.New(), Limit: 10})
+				return err
+			},
+			want: []string{"tenant_id = $1", "session_id = $2"},
+		},
+		{
+			name: "similar",
+			read: func(store *FragmentStore) error {
+				_, err := store.SearchSimilar(SearchQuery{Embedding: embedding(1), Limit: 10})
+				return err
+			},
+			want: []string{"tenant_id = $"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, statement := capturingFragmentStore(t)
+			// Dry-run lookups find nothing
+			if err := tt.read(store.WithTenant(tenantID)); err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatal(err)
+			}
+			checkQuery(t, *statement, tt.want)
+			if vars := (*statement).Vars; len(vars) == 0 || !containsVar(vars, tenantID) {
+				t.Errorf("query parameters %v have no tenant %s", vars, tenantID)
+			}
+
+			*statement = nil
+			if err := tt.read(store); err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatal(err)
+			}
+			if sql := (*statement).SQL.String(); strings.Contains(sql, "tenant_id") {
+				t.Errorf("unscoped query %s is scoped to a tenant", sql)
+			}
+		})
+	}
+}
+
+// containsVar reports whether a tenant is among query parameters
+func containsVar(vars []interface{}, tenantID id.ID) bool {
+	for _, v := range vars {
+		if v == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewAdminStores(t *testing.T) {
+	tenantID := id.New()
+	database := WithTenant(offlineDatabase(), tenantID)
+	tests := []struct {
+		name   string
+		stores *Stores
+		want   id.ID
+	}{
+		{name: "scoped by the database", stores: NewStores(context.Background(), database), want: tenantID},
+		{name: "transaction of a scoped database", stores: ForTx(database.Begin()), want: tenantID},
+		{name: "admin", stores: NewAdminStores(context.Background(), database)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stores.Tenant(); got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+			if got := tt.stores.Actors().tenant; got != tt.want {
+				t.Errorf("tenant of the actor store = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTenantRescope(t *testing.T) {
+	tenantA, tenantB := id.New(), id.New()
+	tests := []struct {
+		name    string
+		current id.ID
+		scope   id.ID
+		// panics is part of the panic expected, if any
+		panics string
+	}{
+		{name: "unscoped", scope: tenantA},
+		{name: "same tenant", current: tenantA, scope: tenantA},
+		{name: "other tenant", current: tenantA, scope: tenantB, panics: "cannot be scoped to tenant " + string(tenantB)},
+		{name: "no tenant", scope: "", panics: "tenant ID is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := NewMemoryStores(context.Background())
+			if tt.current != "" {
+				bundle = bundle.WithTenant(tt.current)
+			}
+			defer func() {
+				r := recover()
+				if tt.panics == "" {
+					if r != nil {
+						t.Fatalf("scoping panicked: %v", r)
+					}
+					return
+				}
+				if r == nil || !strings.Contains(r.(string), tt.panics) {
+					t.Errorf("panic = %v, want %q", r, tt.panics)
+				}
+			}()
+
+			scoped := bundle.WithTenant(tt.scope)
+			if scoped.Tenant() != tt.scope || tenantOf(scoped.DB()) != tt.scope {
+				t.Errorf("tenant = %q and %q of the database, want %q", scoped.Tenant(), tenantOf(scoped.DB()), tt.scope)
+			}
+			// Stores of the bundle can't be rescoped either
+			_ = scoped.Fragments(db.FragmentTableInteraction).WithTenant(tt.scope)
+		})
+	}
+}
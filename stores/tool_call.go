@@ -0,0 +1,270 @@
+package stores
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxToolResultLength is the number of bytes of a tool call's result kept by
+// ToolCallStore.Create, the rest is cut off
+const MaxToolResultLength = 16 << 10
+
+// ToolCallStore provides persistence for the records of tool calls
+type ToolCallStore struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the tool calls of the sessions of a tenant,
+	// see WithTenant
+	tenant id.ID
+}
+
+// NewToolCallStore creates a new ToolCallStore backed by the given database,
+// scoped to its tenant if it is marked with one
+func NewToolCallStore(ctx context.Context, db *gorm.DB) *ToolCallStore {
+	return &ToolCallStore{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *ToolCallStore) WithTx(tx *gorm.DB) *ToolCallStore {
+	if tx == nil {
+		return s
+	}
+	return &ToolCallStore{
+		db:     tx,
+		ctx:    s.ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *ToolCallStore) WithContext(ctx context.Context) *ToolCallStore {
+	return &ToolCallStore{
+		db:     s.db,
+		ctx:    ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the tool calls of the
+// sessions of a tenant, see the WithTenant function
+func (s *ToolCallStore) WithTenant(tenantID id.ID) *ToolCallStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &ToolCallStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+	}
+}
+
+// Create inserts a tool call record, cutting its result to MaxToolResultLength
+// bytes. Records without an ID get a new one.
+func (s *ToolCallStore) Create(call *db.ToolCall) error {
+	if call.ID == "" {
+		call.ID = id.New()
+	}
+	if len(call.Result) > MaxToolResultLength {
+		call.Result = truncateUTF8(call.Result, MaxToolResultLength)
+		call.ResultTruncated = true
+	}
+	if s.mem != nil {
+		if err := s.mem.createToolCall(call); err != nil {
+			return fmt.Errorf("failed to create tool call: %w", err)
+		}
+		return nil
+	}
+	if err := checkTenantSession(s.db.WithContext(s.ctx), s.tenant, call.SessionID); err != nil {
+		return fmt.Errorf("failed to create tool call: %w", err)
+	}
+	if err := s.db.WithContext(s.ctx).Create(call).Error; err != nil {
+		return fmt.Errorf("failed to create tool call: %w", err)
+	}
+	return nil
+}
+
+// Upsert inserts a tool call record or replaces the one with its ID, cutting
+// its result as Create does, e.g. to import records again
+func (s *ToolCallStore) Upsert(call *db.ToolCall) error {
+	if len(call.Result) > MaxToolResultLength {
+		call.Result = truncateUTF8(call.Result, MaxToolResultLength)
+		call.ResultTruncated = true
+	}
+	if s.mem != nil {
+		if err := s.mem.upsertToolCall(call); err != nil {
+			return fmt.Errorf("failed to upsert tool call: %w", err)
+		}
+		return nil
+	}
+	if err := checkTenantSession(s.db.WithContext(s.ctx), s.tenant, call.SessionID); err != nil {
+		return fmt.Errorf("failed to upsert tool call: %w", err)
+	}
+	onConflict := clause.OnConflict{UpdateAll: true}
+	if s.tenant != "" {
+		// Records of sessions of other tenants sharing the ID are left alone
+		onConflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "tool_calls.session_id IN (SELECT id FROM sessions WHERE tenant_id = ?)", Vars: []interface{}{s.tenant}},
+		}}
+	}
+	result := s.db.WithContext(s.ctx).Clauses(onConflict).Create(call)
+	if result.Error != nil {
+		return fmt.Errorf("failed to upsert tool call: %w", result.Error)
+	}
+	if s.tenant != "" && result.RowsAffected == 0 {
+		return fmt.Errorf("failed to upsert tool call: tool call %s: %w", call.ID, ErrOtherTenant)
+	}
+	return nil
+}
+
+// ListBySession returns the tool calls of a session, oldest first
+func (s *ToolCallStore) ListBySession(sessionID id.ID) ([]db.ToolCall, error) {
+	if s.mem != nil {
+		return s.mem.listToolCalls(func(call db.ToolCall) bool {
+			return call.SessionID == sessionID
+		}, false, 0), nil
+	}
+	var calls []db.ToolCall
+	if err := s.query().
+		Where("session_id = ?", sessionID).
+		Order("created_at").
+		Order("id").
+		Find(&calls).Error; err != nil {
+		return nil, fmt.Errorf("failed to list session tool calls: %w", err)
+	}
+	return calls, nil
+}
+
+// ToolCallQuery selects the tool calls ListByTool returns
+type ToolCallQuery struct {
+	Limit      int       // Maximum number of calls, 0 for no limit
+	Since      time.Time // Only calls made at or after this time, if set
+	FailedOnly bool      // Only calls that failed
+}
+
+// ListByTool returns the calls of a tool, most recent first
+func (s *ToolCallStore) ListByTool(name string, query ToolCallQuery) ([]db.ToolCall, error) {
+	if s.mem != nil {
+		return s.mem.listToolCalls(func(call db.ToolCall) bool {
+			return call.Name == name &&
+				(query.Since.IsZero() || !call.CreatedAt.Before(query.Since)) &&
+				(!query.FailedOnly || call.Error != "")
+		}, true, query.Limit), nil
+	}
+	q := s.query().Where("name = ?", name)
+	if !query.Since.IsZero() {
+		q = q.Where("created_at >= ?", query.Since)
+	}
+	if query.FailedOnly {
+		q = q.Where("error <> ''")
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	var calls []db.ToolCall
+	if err := q.Order("created_at DESC").Order("id DESC").Find(&calls).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tool calls: %w", err)
+	}
+	return calls, nil
+}
+
+// ToolCallStats summarizes the calls of a tool
+type ToolCallStats struct {
+	Name            string
+	Calls           int64
+	Failures        int64
+	AverageDuration time.Duration
+	MaxDuration     time.Duration
+}
+
+// Stats summarizes the calls of each tool made at or after since, or of all
+// calls if since is zero, most called tools first
+func (s *ToolCallStore) Stats(since time.Time) ([]ToolCallStats, error) {
+	if s.mem != nil {
+		return s.mem.toolCallStats(since), nil
+	}
+	q := s.query().Model(&db.ToolCall{})
+	if !since.IsZero() {
+		q = q.Where("created_at >= ?", since)
+	}
+
+	var rows []struct {
+		Name            string
+		Calls           int64
+		Failures        int64
+		AverageDuration float64
+		MaxDuration     int64
+	}
+	if err := q.Select(`name,
+		COUNT(*) AS calls,
+		COUNT(*) FILTER (WHERE error <> '') AS failures,
+		AVG(duration) AS average_duration,
+		MAX(duration) AS max_duration`).
+		Group("name").
+		Order("calls DESC").
+		Order("name").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tool call stats: %w", err)
+	}
+
+	stats := make([]ToolCallStats, len(rows))
+	for i, row := range rows {
+		stats[i] = ToolCallStats{
+			Name:            row.Name,
+			Calls:           row.Calls,
+			Failures:        row.Failures,
+			AverageDuration: time.Duration(row.AverageDuration),
+			MaxDuration:     time.Duration(row.MaxDuration),
+		}
+	}
+	return stats, nil
+}
+
+// query returns the store's database with its context, scoped to the sessions
+// of its tenant
+func (s *ToolCallStore) query() *gorm.DB {
+	return scopeSessionTenant(s.db.WithContext(s.ctx), s.tenant, "session_id")
+}
+
+// ToolCallArguments returns the arguments of a tool call as stored in
+// db.ToolCall: the JSON object they encode, or the raw text under "raw" if
+// they aren't one
+func ToolCallArguments(arguments string) db.Metadata {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &object); err != nil || object == nil {
+		if arguments == "" {
+			return db.Metadata{}
+		}
+		return db.Metadata{"raw": arguments}
+	}
+	return object
+}
+
+// truncateUTF8 cuts text to at most n bytes without splitting a character
+func truncateUTF8(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
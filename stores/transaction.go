@@ -0,0 +1,141 @@
+package stores
+
+import (
+	"context"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+)
+
+// Stores is a bundle of stores sharing a database or transaction, so writes
+// across them commit or roll back together when bound to a transaction
+type Stores struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the stores to the records of a tenant, see WithTenant
+	tenant id.ID
+}
+
+// NewStores creates a bundle of stores backed by the given database, scoped to
+// its tenant if it is marked with one
+func NewStores(ctx context.Context, db *gorm.DB) *Stores {
+	return &Stores{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// NewAdminStores creates a bundle of stores backed by the given database that
+// reach the records of every tenant, even if the database is marked with one,
+// for administration and migrations across tenants
+func NewAdminStores(ctx context.Context, db *gorm.DB) *Stores {
+	return &Stores{
+		db:  db,
+		ctx: ctx,
+	}
+}
+
+// WithTenant returns a copy of the bundle scoped to the records of a tenant,
+// see the WithTenant function. Transactions of its database are scoped too.
+func (s *Stores) WithTenant(tenantID id.ID) *Stores {
+	tenantID = rescope(s.tenant, tenantID)
+	return &Stores{
+		db:     WithTenant(s.db, tenantID),
+		ctx:    s.ctx,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+	}
+}
+
+// Tenant returns the tenant the stores are scoped to, or an empty ID if they
+// reach every tenant
+func (s *Stores) Tenant() id.ID {
+	return s.tenant
+}
+
+// ForTx returns a bundle of stores bound to a transaction, using the
+// transaction's context for their queries. Transactions of a database marked
+// with a tenant give stores scoped to it.
+func ForTx(tx *gorm.DB) *Stores {
+	ctx := context.Background()
+	if tx.Statement != nil && tx.Statement.Context != nil {
+		ctx = tx.Statement.Context
+	}
+	return NewStores(ctx, tx)
+}
+
+// DB returns the database or transaction the stores are bound to
+func (s *Stores) DB() *gorm.DB {
+	return s.db
+}
+
+// Fragments returns the store of a fragment table
+func (s *Stores) Fragments(table db.FragmentTable) *FragmentStore {
+	store := NewFragmentStore(s.ctx, s.db, table)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// Actors returns the actor store
+func (s *Stores) Actors() *ActorStore {
+	store := NewActorStore(s.ctx, s.db)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// Sessions returns the session store
+func (s *Stores) Sessions() *SessionStore {
+	store := NewSessionStore(s.ctx, s.db)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// Schedules returns the scheduled response store
+func (s *Stores) Schedules() *ScheduleStore {
+	store := NewScheduleStore(s.ctx, s.db)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// ToolCalls returns the tool call store
+func (s *Stores) ToolCalls() *ToolCallStore {
+	store := NewToolCallStore(s.ctx, s.db)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// Usage returns the token usage store
+func (s *Stores) Usage() *UsageStore {
+	store := NewUsageStore(s.ctx, s.db)
+	store.mem = s.mem
+	store.tenant = s.tenant
+	return store
+}
+
+// Transaction runs fn with stores bound to a new transaction of db, committing
+// it if fn returns nil and rolling it back otherwise. Called with a transaction,
+// it reuses it, rolling back to a savepoint if fn fails, so helpers can open a
+// transaction whether or not their caller already has one:
+//
+//	err := stores.Transaction(database.WithContext(ctx), func(tx *stores.Stores) error {
+//		if err := tx.Fragments(db.FragmentTableInsight).Create(insight); err != nil {
+//			return err
+//		}
+//		return tx.Sessions().MergeMetadata(sessionID, db.Metadata{"insights": count})
+//	})
+func Transaction(db *gorm.DB, fn func(txStores *Stores) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return fn(ForTx(tx))
+	})
+}
@@ -0,0 +1,317 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UsageStore provides persistence for the token usage of budget scopes, such
+// as a session or a day. Scopes are plain names; a store scoped to a tenant
+// keeps its own usage for each of them, so tenants don't share daily budgets.
+type UsageStore struct {
+	db  *gorm.DB
+	ctx context.Context
+
+	// mem keeps the records in memory instead, see NewMemoryStores
+	mem *memoryDB
+	// tenant scopes the store to the usage of a tenant, see WithTenant
+	tenant id.ID
+}
+
+// UsageLimit is the budget of a scope checked by Reserve. A budget of zero
+// doesn't limit the scope.
+type UsageLimit struct {
+	Scope  string
+	Budget int64
+}
+
+// UsageLimitError is the error of a reservation refused because a scope has
+// used up its budget
+type UsageLimitError struct {
+	Scope  string
+	Budget int64
+	Used   int64
+}
+
+func (e *UsageLimitError) Error() string {
+	return fmt.Sprintf("%s used %d of %d tokens", e.Scope, e.Used, e.Budget)
+}
+
+// NewUsageStore creates a new UsageStore backed by the given database, scoped
+// to its tenant if it is marked with one
+func NewUsageStore(ctx context.Context, db *gorm.DB) *UsageStore {
+	return &UsageStore{
+		db:     db,
+		ctx:    ctx,
+		tenant: tenantOf(db),
+	}
+}
+
+// WithTx returns a copy of the store bound to the given transaction.
+// A nil transaction returns the store unchanged.
+func (s *UsageStore) WithTx(tx *gorm.DB) *UsageStore {
+	if tx == nil {
+		return s
+	}
+	return &UsageStore{
+		db:     tx,
+		ctx:    s.ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithContext returns a copy of the store that uses ctx for its queries
+func (s *UsageStore) WithContext(ctx context.Context) *UsageStore {
+	return &UsageStore{
+		db:     s.db,
+		ctx:    ctx,
+		mem:    s.mem,
+		tenant: s.tenant,
+	}
+}
+
+// WithTenant returns a copy of the store scoped to the usage of a tenant, see
+// the WithTenant function
+func (s *UsageStore) WithTenant(tenantID id.ID) *UsageStore {
+	tenantID = rescope(s.tenant, tenantID)
+	return &UsageStore{
+		db:     s.db,
+		ctx:    s.ctx,
+		mem:    s.mem.forTenant(tenantID),
+		tenant: tenantID,
+	}
+}
+
+// Get returns the usage recorded for a scope, or a zero usage if there is none
+func (s *UsageStore) Get(scope string) (*db.TokenUsage, error) {
+	key := s.key(scope)
+	if s.mem != nil {
+		return s.mem.getUsage(key), nil
+	}
+	usage := &db.TokenUsage{Scope: key}
+	if err := s.query().Where("scope = ?", key).Limit(1).Find(usage).Error; err != nil {
+		return nil, fmt.Errorf("failed to get token usage for %s: %w", scope, err)
+	}
+	return usage, nil
+}
+
+// Reserve adds tokens to the total of each scope of limits, unless one of them
+// has already used up its budget, in which case none is changed and a
+// *UsageLimitError is returned. Checking and adding happen in one transaction,
+// so concurrent reservations can't all pass the check of the same usage.
+// Reserved tokens are corrected with Add once the actual usage is known.
+func (s *UsageStore) Reserve(tokens int64, limits ...UsageLimit) error {
+	if s.mem != nil {
+		return s.mem.reserveUsage(s.key, tokens, limits)
+	}
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for _, limit := range limits {
+			onConflict := usageConflict("total_tokens")
+			if limit.Budget > 0 {
+				onConflict.Where = clause.Where{Exprs: []clause.Expression{
+					clause.Expr{SQL: "token_usages.total_tokens < ?", Vars: []interface{}{limit.Budget}},
+				}}
+			}
+			record := s.record(limit.Scope)
+			record.TotalTokens = tokens
+			result := tx.Clauses(onConflict).Create(record)
+			if result.Error != nil {
+				return fmt.Errorf("failed to reserve tokens for %s: %w", limit.Scope, result.Error)
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
+			usage, err := s.WithTx(tx).Get(limit.Scope)
+			if err != nil {
+				return err
+			}
+			return &UsageLimitError{Scope: limit.Scope, Budget: limit.Budget, Used: usage.TotalTokens}
+		}
+		return nil
+	})
+}
+
+// Add adds the tokens of usage to each scope in one transaction. Its counts
+// may be negative, to give back tokens reserved but not used.
+func (s *UsageStore) Add(usage db.TokenUsage, scopes ...string) error {
+	if s.mem != nil {
+		s.mem.addUsage(s.key, usage, scopes)
+		return nil
+	}
+	return s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		for _, scope := range scopes {
+			record := s.record(scope)
+			record.PromptTokens = usage.PromptTokens
+			record.CompletionTokens = usage.CompletionTokens
+			record.TotalTokens = usage.TotalTokens
+			if err := tx.Clauses(usageConflict("prompt_tokens", "completion_tokens", "total_tokens")).
+				Create(record).Error; err != nil {
+				return fmt.Errorf("failed to record token usage for %s: %w", scope, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Pause marks a scope as paused, reporting whether it wasn't already, so a
+// message sent when a budget is used up goes out once
+func (s *UsageStore) Pause(scope string) (bool, error) {
+	if s.mem != nil {
+		return s.mem.pauseUsage(s.key(scope)), nil
+	}
+	var paused bool
+	err := s.db.WithContext(s.ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(s.record(scope)).Error; err != nil {
+			return fmt.Errorf("failed to record pause for %s: %w", scope, err)
+		}
+		result := scopeTenant(tx.Model(&db.TokenUsage{}), s.tenant, "tenant_id").
+			Where("scope = ? AND paused = ?", s.key(scope), false).
+			Update("paused", true)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record pause for %s: %w", scope, result.Error)
+		}
+		paused = result.RowsAffected > 0
+		return nil
+	})
+	return paused, err
+}
+
+// Reset deletes the usage recorded for a scope, lifting its pause
+func (s *UsageStore) Reset(scope string) error {
+	if s.mem != nil {
+		s.mem.resetUsage(s.key(scope))
+		return nil
+	}
+	if err := s.query().Where("scope = ?", s.key(scope)).Delete(&db.TokenUsage{}).Error; err != nil {
+		return fmt.Errorf("failed to reset token usage for %s: %w", scope, err)
+	}
+	return nil
+}
+
+// key returns the primary key of the usage of a scope, prefixed with the
+// store's tenant
+func (s *UsageStore) key(scope string) string {
+	if s.tenant == "" {
+		return scope
+	}
+	return "tenant:" + string(s.tenant) + ":" + scope
+}
+
+// record returns an empty usage record of a scope, stamped with the store's tenant
+func (s *UsageStore) record(scope string) *db.TokenUsage {
+	record := &db.TokenUsage{Scope: s.key(scope)}
+	if s.tenant != "" {
+		tenantID := s.tenant
+		record.TenantID = &tenantID
+	}
+	return record
+}
+
+// query returns the store's database with its context, scoped to its tenant
+func (s *UsageStore) query() *gorm.DB {
+	return scopeTenant(s.db.WithContext(s.ctx), s.tenant, "tenant_id")
+}
+
+// usageConflict returns the upsert of a usage record adding to the columns of
+// the existing one
+func usageConflict(columns ...string) clause.OnConflict {
+	updates := map[string]interface{}{
+		"updated_at": gorm.Expr("EXCLUDED.updated_at"),
+	}
+	for _, column := range columns {
+		updates[column] = gorm.Expr(fmt.Sprintf("token_usages.%s + EXCLUDED.%s", column, column))
+	}
+	return clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}},
+		DoUpdates: clause.Assignments(updates),
+	}
+}
+
+// Token usage of the memory stores
+
+func (m *memoryDB) getUsage(key string) *db.TokenUsage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	usage, ok := m.usage[key]
+	if !ok || !m.visible(usage.TenantID) {
+		return &db.TokenUsage{Scope: key}
+	}
+	usage.TenantID = cloneFragmentID(usage.TenantID)
+	return &usage
+}
+
+func (m *memoryDB) reserveUsage(key func(string) string, tokens int64, limits []UsageLimit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, limit := range limits {
+		used := m.usage[key(limit.Scope)].TotalTokens
+		if limit.Budget > 0 && used >= limit.Budget {
+			return &UsageLimitError{Scope: limit.Scope, Budget: limit.Budget, Used: used}
+		}
+	}
+	for _, limit := range limits {
+		m.updateUsage(key(limit.Scope), func(usage *db.TokenUsage) {
+			usage.TotalTokens += tokens
+		})
+	}
+	return nil
+}
+
+func (m *memoryDB) addUsage(key func(string) string, delta db.TokenUsage, scopes []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, scope := range scopes {
+		m.updateUsage(key(scope), func(usage *db.TokenUsage) {
+			usage.PromptTokens += delta.PromptTokens
+			usage.CompletionTokens += delta.CompletionTokens
+			usage.TotalTokens += delta.TotalTokens
+		})
+	}
+}
+
+func (m *memoryDB) pauseUsage(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var paused bool
+	m.updateUsage(key, func(usage *db.TokenUsage) {
+		paused = !usage.Paused
+		usage.Paused = true
+	})
+	return paused
+}
+
+func (m *memoryDB) resetUsage(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.usage, key)
+}
+
+// updateUsage applies update to the usage of a key, creating it stamped with
+// the view's tenant if needed. Must be called with the lock held.
+func (m *memoryDB) updateUsage(key string, update func(usage *db.TokenUsage)) {
+	now := time.Now()
+	usage, ok := m.usage[key]
+	if !ok {
+		usage = db.TokenUsage{Scope: key, CreatedAt: now}
+		if m.tenant != "" {
+			tenantID := m.tenant
+			usage.TenantID = &tenantID
+		}
+	}
+	update(&usage)
+	usage.UpdatedAt = now
+	m.usage[key] = usage
+}
@@ -0,0 +1,210 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/velumlabs/thor/db"
+	"github.com/velumlabs/thor/id"
+)
+
+func TestUsageReserve(t *testing.T) {
+	tests := []struct {
+		name string
+		// used is the usage of the scopes session and daily before reserving
+		used map[string]int64
+		// limits are the budgets of session and daily
+		limits []UsageLimit
+		// refused is the scope refusing the reservation, if any
+		refused string
+		// want is the usage of session and daily afterwards
+		want map[string]int64
+	}{
+		{
+			name:   "no usage",
+			limits: []UsageLimit{{Scope: "session", Budget: 100}, {Scope: "daily", Budget: 1000}},
+			want:   map[string]int64{"session": 10, "daily": 10},
+		},
+		{
+			name:   "under budget",
+			used:   map[string]int64{"session": 95, "daily": 500},
+			limits: []UsageLimit{{Scope: "session", Budget: 100}, {Scope: "daily", Budget: 1000}},
+			want:   map[string]int64{"session": 105, "daily": 510},
+		},
+		{
+			name:    "session used up",
+			used:    map[string]int64{"session": 100, "daily": 500},
+			limits:  []UsageLimit{{Scope: "session", Budget: 100}, {Scope: "daily", Budget: 1000}},
+			refused: "session",
+			want:    map[string]int64{"session": 100, "daily": 500},
+		},
+		{
+			name:    "daily used up",
+			used:    map[string]int64{"session": 50, "daily": 1200},
+			limits:  []UsageLimit{{Scope: "session", Budget: 100}, {Scope: "daily", Budget: 1000}},
+			refused: "daily",
+			want:    map[string]int64{"session": 50, "daily": 1200},
+		},
+		{
+			name:   "unlimited",
+			used:   map[string]int64{"session": 5000, "daily": 5000},
+			limits: []UsageLimit{{Scope: "session"}, {Scope: "daily"}},
+			want:   map[string]int64{"session": 5010, "daily": 5010},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := NewMemoryStores(context.Background()).Usage()
+			for scope, used := range tt.used {
+				if err := usage.Add(db.TokenUsage{TotalTokens: used}, scope); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := usage.Reserve(10, tt.limits...)
+			var limitErr *UsageLimitError
+			if tt.refused == "" && err != nil {
+				t.Fatalf("Reserve failed: %v", err)
+			}
+			if tt.refused != "" {
+				if !errors.As(err, &limitErr) {
+					t.Fatalf("Reserve returned %v, want a UsageLimitError", err)
+				}
+				if limitErr.Scope != tt.refused || limitErr.Used != tt.used[tt.refused] {
+					t.Errorf("error = %+v, want scope %s with %d tokens used", limitErr, tt.refused, tt.used[tt.refused])
+				}
+			}
+
+			for scope, want := range tt.want {
+				got, err := usage.Get(scope)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.TotalTokens != want {
+					t.Errorf("%s total = %d, want %d", scope, got.TotalTokens, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageReserveConcurrent(t *testing.T) {
+	usage := NewMemoryStores(context.Background()).Usage()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := usage.Reserve(10, UsageLimit{Scope: "daily", Budget: 50}); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each reservation sees those before it, so the budget stops the sixth
+	if reserved != 5 {
+		t.Errorf("reservations = %d, want 5", reserved)
+	}
+}
+
+func TestUsageAdd(t *testing.T) {
+	usage := NewMemoryStores(context.Background()).Usage()
+	if err := usage.Reserve(100, UsageLimit{Scope: "session"}, UsageLimit{Scope: "daily"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The actual usage corrects the reservation
+	if err := usage.Add(db.TokenUsage{PromptTokens: 30, CompletionTokens: 20, TotalTokens: 50 - 100}, "session", "daily"); err != nil {
+		t.Fatal(err)
+	}
+	for _, scope := range []string{"session", "daily"} {
+		got, err := usage.Get(scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.PromptTokens != 30 || got.CompletionTokens != 20 || got.TotalTokens != 50 {
+			t.Errorf("%s usage = %d+%d=%d, want 30+20=50", scope, got.PromptTokens, got.CompletionTokens, got.TotalTokens)
+		}
+	}
+}
+
+func TestUsagePause(t *testing.T) {
+	usage := NewMemoryStores(context.Background()).Usage()
+
+	for i, want := range []bool{true, false} {
+		paused, err := usage.Pause("session")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paused != want {
+			t.Errorf("pause %d = %v, want %v", i, paused, want)
+		}
+	}
+
+	// Resetting lifts the pause
+	if err := usage.Reset("session"); err != nil {
+		t.Fatal(err)
+	}
+	if paused, err := usage.Pause("session"); err != nil || !paused {
+		t.Errorf("pause after reset = %v, %v, want true", paused, err)
+	}
+}
+
+func TestUsageTenants(t *testing.T) {
+	memory := NewMemoryStores(context.Background())
+	tenantA, tenantB := id.New(), id.New()
+	usageA := memory.WithTenant(tenantA).Usage()
+	usageB := memory.WithTenant(tenantB).Usage()
+
+	if err := usageA.Add(db.TokenUsage{TotalTokens: 80}, "daily"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		usage *UsageStore
+		want  int64
+	}{
+		{name: "tenant", usage: usageA, want: 80},
+		{name: "other tenant", usage: usageB},
+		{name: "unscoped", usage: memory.Usage()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.usage.Get("daily")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.TotalTokens != tt.want {
+				t.Errorf("total = %d, want %d", got.TotalTokens, tt.want)
+			}
+		})
+	}
+
+	// Each tenant has its own budget
+	if err := usageA.Reserve(10, UsageLimit{Scope: "daily", Budget: 50}); err == nil {
+		t.Error("tenant reserved tokens over its budget")
+	}
+	if err := usageB.Reserve(10, UsageLimit{Scope: "daily", Budget: 50}); err != nil {
+		t.Errorf("other tenant failed to reserve tokens: %v", err)
+	}
+
+	got, err := usageA.Get("daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TenantID == nil || *got.TenantID != tenantA {
+		t.Errorf("tenant of usage = %v, want %s", got.TenantID, tenantA)
+	}
+}